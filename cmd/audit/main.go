@@ -0,0 +1,51 @@
+// Команда audit проверяет журнал аудита:
+//
+//	audit verify
+//	audit verify -env .env.prod
+//
+// verify проходит цепочку хешей с первого события и печатает результат; при разрыве цепочки
+// называет первое событие, которое не сходится, и завершается с кодом 1
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/zhedevops/idm/inner/audit"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"log"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: audit verify [-env .env]")
+		os.Exit(2)
+	}
+	var flags = flag.NewFlagSet("verify", flag.ExitOnError)
+	var envFile = flags.String("env", ".env", "path to .env file")
+	_ = flags.Parse(os.Args[2:])
+
+	cfg, errStr := common.GetConfig(*envFile, true)
+	if errStr != "" {
+		log.Fatal(errors.New(errStr))
+	}
+	var db = database.ConnectDbWithCfg(cfg)
+	defer db.Close()
+
+	result, err := audit.NewService(audit.NewRepository(db)).Verify()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var encoder = json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(result); err != nil {
+		log.Fatal(err)
+	}
+	if !result.Valid {
+		os.Exit(1)
+	}
+}
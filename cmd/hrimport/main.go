@@ -0,0 +1,102 @@
+// Команда hrimport загружает выгрузку сотрудников из HR-системы:
+//
+//	hrimport -file employees.csv -dry-run
+//	hrimport -file employees.csv -external-id-column "Табельный номер" -name-columns "Имя,Фамилия" -delimiter ";"
+//	hrimport -file employees.csv -department-column "Отдел" -title-column "Должность"
+//
+// Подключение к базе и настройки провижининга берутся из .env, как у сервера
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/zhedevops/idm/inner/assignmentrule"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"github.com/zhedevops/idm/inner/hrimport"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/sod"
+	"github.com/zhedevops/idm/inner/validator"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	var file = flag.String("file", "", "path to CSV file, - for stdin")
+	var dryRun = flag.Bool("dry-run", false, "only print changes")
+	var externalId = flag.String("external-id-column", hrimport.DefaultMapping.ExternalId, "column with employee number")
+	var names = flag.String("name-columns", strings.Join(hrimport.DefaultMapping.Name, ","), "comma-separated columns joined into name")
+	var department = flag.String("department-column", hrimport.DefaultMapping.Department, "column with department")
+	var title = flag.String("title-column", hrimport.DefaultMapping.Title, "column with job title")
+	var employmentType = flag.String("employment-type-column", hrimport.DefaultMapping.EmploymentType, "column with employment type")
+	var location = flag.String("location-column", hrimport.DefaultMapping.Location, "column with location")
+	var delimiter = flag.String("delimiter", hrimport.DefaultMapping.Delimiter, "column delimiter")
+	var batchSize = flag.Int("batch-size", hrimport.DefaultBatchSize, "changes per transaction")
+	var envFile = flag.String("env", ".env", "path to .env file")
+	flag.Parse()
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var input = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	cfg, errStr := common.GetConfig(*envFile, true)
+	if errStr != "" {
+		log.Fatal(errors.New(errStr))
+	}
+	var db = database.ConnectDbWithCfg(cfg)
+	defer db.Close()
+
+	provisioningCfg, err := provisioning.LoadConfig(cfg.ProvisioningConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dispatcher, err := provisioning.NewRegistry().Build(provisioningCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dispatcher.Start()
+
+	// импорт пересчитывает роли по правилам с той же проверкой SoD, что и сервер
+	var guard = sod.NewService(sod.NewRepository(db), validator.New())
+	var rules = assignmentrule.NewService(assignmentrule.NewRepository(db), validator.New(), dispatcher, guard)
+	var service = hrimport.NewService(hrimport.NewRepository(db), validator.New(), dispatcher, rules)
+	report, err := service.Import(input, hrimport.Options{
+		DryRun:    *dryRun,
+		BatchSize: *batchSize,
+		Mapping: hrimport.Mapping{
+			ExternalId:     *externalId,
+			Name:           strings.Split(*names, ","),
+			Department:     *department,
+			Title:          *title,
+			EmploymentType: *employmentType,
+			Location:       *location,
+			Delimiter:      *delimiter,
+		},
+	})
+	// ждём, пока изменения уйдут в коннекторы
+	dispatcher.Stop()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var encoder = json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,265 @@
+package accessrequest
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+)
+
+type Controller struct {
+	server               *web.Server
+	accessRequestService Svc
+}
+
+// интерфейс сервиса accessrequest.Service
+type Svc interface {
+	Submit(request SubmitRequest) (int64, error)
+	Approve(request DecisionRequest) (Response, error)
+	Reject(request DecisionRequest) (Response, error)
+	Cancel(request CancelRequest) (Response, error)
+	FindById(id int64) (Response, error)
+	FindAll(status string) ([]Response, error)
+	FindPendingByApprover(approverId int64) ([]Response, error)
+	FindPolicy(roleId int64) (PolicyResponse, error)
+	SetPolicy(request PolicyRequest) (PolicyResponse, error)
+}
+
+func NewController(server *web.Server, accessRequestService Svc) *Controller {
+	return &Controller{
+		server:               server,
+		accessRequestService: accessRequestService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/access-requests", c.Submit)
+	c.server.GroupApiV1.Get("/access-requests", c.FindAll)
+	// регистрируется раньше "/access-requests/:id", иначе "pending" будет принят за id
+	c.server.GroupApiV1.Get("/access-requests/pending", c.FindPendingByApprover)
+	c.server.GroupApiV1.Get("/access-requests/:id", c.FindById)
+	c.server.GroupApiV1.Post("/access-requests/:id/approve", c.Approve)
+	c.server.GroupApiV1.Post("/access-requests/:id/reject", c.Reject)
+	c.server.GroupApiV1.Post("/access-requests/:id/cancel", c.Cancel)
+	c.server.GroupApiV1.Get("/roles/:id/approval-policy", c.FindPolicy)
+	c.server.GroupApiV1.Put("/roles/:id/approval-policy", c.SetPolicy)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/access-requests"
+func (c *Controller) Submit(ctx *fiber.Ctx) error {
+	var request SubmitRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	// сотрудник подаёт заявку от своего имени; сервисный аккаунт указывает requester_id в теле
+	if principal, ok := auth.PrincipalFrom(ctx); ok && principal.EmployeeId != 0 {
+		request.RequesterId = principal.EmployeeId
+	}
+
+	var id, err = c.accessRequestService.Submit(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning created access request id")
+	}
+
+	return nil
+}
+
+func (c *Controller) Approve(ctx *fiber.Ctx) error {
+	return c.decide(ctx, c.accessRequestService.Approve)
+}
+
+func (c *Controller) Reject(ctx *fiber.Ctx) error {
+	return c.decide(ctx, c.accessRequestService.Reject)
+}
+
+func (c *Controller) decide(ctx *fiber.Ctx, decide func(DecisionRequest) (Response, error)) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	var request DecisionRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.Id = id
+	if request.ApproverId, err = actingEmployee(ctx, request.ApproverId); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	resp, err := decide(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning access request")
+	}
+
+	return nil
+}
+
+func (c *Controller) Cancel(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	var request CancelRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.Id = id
+	if request.EmployeeId, err = actingEmployee(ctx, request.EmployeeId); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	resp, err := c.accessRequestService.Cancel(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning access request")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindById(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.accessRequestService.FindById(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get access request by id")
+	}
+
+	return nil
+}
+
+// FindAll возвращает заявки; параметр status отбирает заявки в одном статусе
+func (c *Controller) FindAll(ctx *fiber.Ctx) error {
+	var resp, err = c.accessRequestService.FindAll(ctx.Query("status"))
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get access requests")
+	}
+
+	return nil
+}
+
+// FindPendingByApprover возвращает заявки, ждущие решения вошедшего сотрудника;
+// сервисный аккаунт указывает согласующего параметром: GET /access-requests/pending?approver_id=7
+func (c *Controller) FindPendingByApprover(ctx *fiber.Ctx) error {
+	var approverId int64
+	if principal, ok := auth.PrincipalFrom(ctx); ok && principal.EmployeeId != 0 {
+		approverId = principal.EmployeeId
+	} else {
+		var err error
+		if approverId, err = strconv.ParseInt(ctx.Query("approver_id"), 10, 64); err != nil {
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid approver_id")
+		}
+	}
+
+	resp, err := c.accessRequestService.FindPendingByApprover(approverId)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get pending access requests")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindPolicy(ctx *fiber.Ctx) error {
+	roleId, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.accessRequestService.FindPolicy(roleId)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get approval policy")
+	}
+
+	return nil
+}
+
+// SetPolicy задаёт владельца роли и порядок согласования; это может только администратор
+func (c *Controller) SetPolicy(ctx *fiber.Ctx) error {
+	roleId, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	if principal, ok := auth.PrincipalFrom(ctx); ok && !principal.Admin {
+		return common.ErrResponse(ctx, fiber.StatusForbidden, "administrator role required")
+	}
+	var request PolicyRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.RoleId = roleId
+
+	resp, err := c.accessRequestService.SetPolicy(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error set approval policy")
+	}
+
+	return nil
+}
+
+// actingEmployee сотрудник, от имени которого принимается решение по заявке: вошедший сотрудник, а не id из тела.
+// Сервисный аккаунт решений не принимает; без middleware аутентификации используется id из тела
+func actingEmployee(ctx *fiber.Ctx, requested int64) (int64, error) {
+	principal, ok := auth.PrincipalFrom(ctx)
+	switch {
+	case !ok:
+		return requested, nil
+	case principal.EmployeeId == 0:
+		return 0, common.ForbiddenError{Message: "acting on access requests requires an employee session"}
+	default:
+		return principal.EmployeeId, nil
+	}
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.ForbiddenError{}):
+		return common.ErrResponse(ctx, fiber.StatusForbidden, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	case errors.As(err, &common.AlreadyExistsError{}) || errors.As(err, &common.ConflictError{}) ||
+		errors.As(err, &common.PolicyViolationError{}):
+		return common.ErrResponse(ctx, fiber.StatusConflict, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,123 @@
+package accessrequest
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+// StubSvc запоминает запросы, дошедшие до сервиса
+type StubSvc struct {
+	Svc
+	submitted  SubmitRequest
+	decided    DecisionRequest
+	cancelled  CancelRequest
+	approverId int64
+}
+
+func (s *StubSvc) Submit(request SubmitRequest) (int64, error) {
+	s.submitted = request
+	return 1, nil
+}
+
+func (s *StubSvc) Approve(request DecisionRequest) (Response, error) {
+	s.decided = request
+	return Response{Id: request.Id}, nil
+}
+
+func (s *StubSvc) Cancel(request CancelRequest) (Response, error) {
+	s.cancelled = request
+	return Response{Id: request.Id}, nil
+}
+
+func (s *StubSvc) FindPendingByApprover(approverId int64) ([]Response, error) {
+	s.approverId = approverId
+	return []Response{}, nil
+}
+
+type StubSessions struct{}
+
+func (StubSessions) Verify(accessToken string) (auth.Claims, error) {
+	if accessToken != "employee-token" {
+		return auth.Claims{}, common.UnauthorizedError{Message: "invalid token"}
+	}
+	return auth.Claims{EmployeeId: 7}, nil
+}
+
+type StubApiKeys struct{}
+
+func (StubApiKeys) Authenticate(key string, clientIp string) (auth.Principal, error) {
+	return auth.Principal{ServiceAccountId: 3, Scopes: []string{"access-requests:read", "access-requests:write"}}, nil
+}
+
+func newApp(svc Svc) *fiber.App {
+	var server = web.NewServer()
+	server.GroupApiV1.Use(auth.NewMiddleware(StubSessions{}, StubApiKeys{}, nil))
+	NewController(server, svc).RegisterRoutes()
+	return server.App
+}
+
+func TestControllerActingEmployee(t *testing.T) {
+	a := assert.New(t)
+
+	var send = func(app *fiber.App, method string, path string, body string, authorization string) int {
+		var req = httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+		resp, err := app.Test(req)
+		a.Nil(err)
+		return resp.StatusCode
+	}
+
+	t.Run("should take requester and approver from employee session", func(t *testing.T) {
+		var svc = &StubSvc{}
+		var app = newApp(svc)
+
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodPost, "/api/v1/access-requests",
+			`{"requester_id":99,"employee_id":5,"role_id":2,"justification":"need access to reports"}`, "Bearer employee-token"))
+		a.Equal(int64(7), svc.submitted.RequesterId)
+		a.Equal(int64(5), svc.submitted.EmployeeId)
+
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodPost, "/api/v1/access-requests/4/approve",
+			`{"approver_id":99}`, "Bearer employee-token"))
+		a.Equal(int64(4), svc.decided.Id)
+		a.Equal(int64(7), svc.decided.ApproverId)
+
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodPost, "/api/v1/access-requests/4/cancel",
+			`{"employee_id":99}`, "Bearer employee-token"))
+		a.Equal(int64(7), svc.cancelled.EmployeeId)
+
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodGet, "/api/v1/access-requests/pending?approver_id=99", "", "Bearer employee-token"))
+		a.Equal(int64(7), svc.approverId)
+	})
+
+	t.Run("should not let service account decide on access requests", func(t *testing.T) {
+		var svc = &StubSvc{}
+		var app = newApp(svc)
+
+		a.Equal(fiber.StatusForbidden, send(app, fiber.MethodPost, "/api/v1/access-requests/4/approve",
+			`{"approver_id":99}`, "ApiKey key"))
+		a.Equal(DecisionRequest{}, svc.decided)
+		a.Equal(fiber.StatusForbidden, send(app, fiber.MethodPost, "/api/v1/access-requests/4/cancel",
+			`{"employee_id":99}`, "ApiKey key"))
+
+		// заявку от имени сотрудника интеграция подаёт с requester_id в теле
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodPost, "/api/v1/access-requests",
+			`{"requester_id":99,"employee_id":5,"role_id":2,"justification":"need access to reports"}`, "ApiKey key"))
+		a.Equal(int64(99), svc.submitted.RequesterId)
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodGet, "/api/v1/access-requests/pending?approver_id=99", "", "ApiKey key"))
+		a.Equal(int64(99), svc.approverId)
+	})
+
+	t.Run("should let only administrator set approval policy", func(t *testing.T) {
+		var app = newApp(&StubSvc{})
+		a.Equal(fiber.StatusForbidden, send(app, fiber.MethodPut, "/api/v1/roles/2/approval-policy",
+			`{"owner_id":7,"approvers":["role_owner"]}`, "Bearer employee-token"))
+	})
+}
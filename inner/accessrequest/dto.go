@@ -0,0 +1,157 @@
+package accessrequest
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Статусы заявки на доступ
+const (
+	StatusPending   = "pending"
+	StatusApproved  = "approved"
+	StatusRejected  = "rejected"
+	StatusCancelled = "cancelled"
+)
+
+// Типы согласующих: владелец запрашиваемой роли и руководитель сотрудника, которому нужен доступ
+const (
+	ApproverRoleOwner = "role_owner"
+	ApproverManager   = "manager"
+)
+
+// DefaultApprovers согласующие для роли без настроенной политики
+var DefaultApprovers = []string{ApproverRoleOwner}
+
+// Решения согласующего
+const (
+	DecisionApproved = "approved"
+	DecisionRejected = "rejected"
+)
+
+// Entity заявка на назначение роли сотруднику
+type Entity struct {
+	Id            int64  `db:"id"`
+	RequesterId   int64  `db:"requester_id"`
+	EmployeeId    int64  `db:"employee_id"`
+	RoleId        int64  `db:"role_id"`
+	Justification string `db:"justification"`
+	Status        string `db:"status"`
+	// Approvers согласующие, зафиксированные при подаче, Step — индекс текущего из них
+	Approvers pq.StringArray `db:"approvers"`
+	// ApproverIds сотрудники, которые были согласующими Approvers при подаче
+	ApproverIds pq.Int64Array `db:"approver_ids"`
+	Step        int           `db:"step"`
+	CreatedAt   time.Time     `db:"created_at"`
+	UpdatedAt   time.Time     `db:"updated_at"`
+	ClosedAt    sql.NullTime  `db:"closed_at"`
+}
+
+// DecisionEntity решение согласующего по одному шагу заявки
+type DecisionEntity struct {
+	Id           int64     `db:"id"`
+	RequestId    int64     `db:"request_id"`
+	Step         int       `db:"step"`
+	ApproverType string    `db:"approver_type"`
+	ApproverId   int64     `db:"approver_id"`
+	Decision     string    `db:"decision"`
+	Comment      string    `db:"comment"`
+	DecidedAt    time.Time `db:"decided_at"`
+}
+
+// PartiesEntity сотрудник и роль из заявки вместе с теми, кто может её согласовать
+type PartiesEntity struct {
+	EmployeeName string        `db:"employee_name"`
+	ManagerId    sql.NullInt64 `db:"manager_id"`
+	RoleName     string        `db:"role_name"`
+	OwnerId      sql.NullInt64 `db:"owner_id"`
+}
+
+// PolicyEntity владелец роли и порядок согласования заявок на неё
+type PolicyEntity struct {
+	RoleId    int64          `db:"role_id"`
+	OwnerId   sql.NullInt64  `db:"owner_id"`
+	Approvers pq.StringArray `db:"approvers"`
+}
+
+type Response struct {
+	Id            int64              `json:"id"`
+	RequesterId   int64              `json:"requester_id"`
+	EmployeeId    int64              `json:"employee_id"`
+	RoleId        int64              `json:"role_id"`
+	Justification string             `json:"justification"`
+	Status        string             `json:"status"`
+	Approvers     []string           `json:"approvers"`
+	ApproverIds   []int64            `json:"approver_ids"`
+	Step          int                `json:"step"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	ClosedAt      *time.Time         `json:"closed_at,omitempty"`
+	Decisions     []DecisionResponse `json:"decisions,omitempty"`
+}
+
+type DecisionResponse struct {
+	Step         int       `json:"step"`
+	ApproverType string    `json:"approver_type"`
+	ApproverId   int64     `json:"approver_id"`
+	Decision     string    `json:"decision"`
+	Comment      string    `json:"comment,omitempty"`
+	DecidedAt    time.Time `json:"decided_at"`
+}
+
+type PolicyResponse struct {
+	RoleId    int64    `json:"role_id"`
+	OwnerId   int64    `json:"owner_id,omitempty"`
+	Approvers []string `json:"approvers"`
+}
+
+// approver возвращает сотрудника, который согласует шаг типа approverType, и false, если такого нет
+func (p *PartiesEntity) approver(approverType string) (int64, bool) {
+	switch approverType {
+	case ApproverRoleOwner:
+		return p.OwnerId.Int64, p.OwnerId.Valid
+	case ApproverManager:
+		return p.ManagerId.Int64, p.ManagerId.Valid
+	default:
+		return 0, false
+	}
+}
+
+func (e *Entity) toResponse() Response {
+	var resp = Response{
+		Id:            e.Id,
+		RequesterId:   e.RequesterId,
+		EmployeeId:    e.EmployeeId,
+		RoleId:        e.RoleId,
+		Justification: e.Justification,
+		Status:        e.Status,
+		Approvers:     e.Approvers,
+		ApproverIds:   e.ApproverIds,
+		Step:          e.Step,
+		CreatedAt:     e.CreatedAt,
+		UpdatedAt:     e.UpdatedAt,
+	}
+	if e.ClosedAt.Valid {
+		resp.ClosedAt = &e.ClosedAt.Time
+	}
+	return resp
+}
+
+func (d *DecisionEntity) toResponse() DecisionResponse {
+	return DecisionResponse{
+		Step:         d.Step,
+		ApproverType: d.ApproverType,
+		ApproverId:   d.ApproverId,
+		Decision:     d.Decision,
+		Comment:      d.Comment,
+		DecidedAt:    d.DecidedAt,
+	}
+}
+
+func (p *PolicyEntity) toResponse() PolicyResponse {
+	return PolicyResponse{
+		RoleId:    p.RoleId,
+		OwnerId:   p.OwnerId.Int64,
+		Approvers: p.Approvers,
+	}
+}
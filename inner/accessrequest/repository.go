@@ -0,0 +1,162 @@
+package accessrequest
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) FindById(id int64) (request Entity, err error) {
+	err = r.db.Get(&request, "SELECT * FROM access_request WHERE id = $1", id)
+	return
+}
+
+// FindAll возвращает заявки, новые первыми. Пустой status означает заявки в любом статусе
+func (r *Repository) FindAll(status string) (requests []Entity, err error) {
+	query := "SELECT * FROM access_request WHERE $1 = '' OR status = $1 ORDER BY id DESC"
+	err = r.db.Select(&requests, query, status)
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// FindPendingByApprover возвращает заявки, которые на текущем шаге ждут решения сотрудника approverId
+func (r *Repository) FindPendingByApprover(approverId int64) (requests []Entity, err error) {
+	query := `SELECT * FROM access_request
+              WHERE status = 'pending' AND approver_ids[step + 1] = $1
+              ORDER BY id`
+	err = r.db.Select(&requests, query, approverId)
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (r *Repository) FindDecisions(requestId int64) (decisions []DecisionEntity, err error) {
+	query := "SELECT * FROM access_request_decision WHERE request_id = $1 ORDER BY step, id"
+	err = r.db.Select(&decisions, query, requestId)
+	if err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
+
+// FindPartiesTx возвращает имена сотрудника и роли и тех, кто может согласовать заявку
+func (r *Repository) FindPartiesTx(tx *sqlx.Tx, employeeId int64, roleId int64) (parties PartiesEntity, err error) {
+	query := `SELECT e.name AS employee_name, e.manager_id, r.name AS role_name, r.owner_id
+              FROM employee e, role r WHERE e.id = $1 AND r.id = $2`
+	err = tx.Get(&parties, query, employeeId, roleId)
+	return
+}
+
+func (r *Repository) HasAssignmentTx(tx *sqlx.Tx, employeeId int64, roleId int64) (exists bool, err error) {
+	query := "SELECT EXISTS (SELECT 1 FROM employee_role WHERE employee_id = $1 AND role_id = $2)"
+	err = tx.Get(&exists, query, employeeId, roleId)
+	return
+}
+
+func (r *Repository) HasPendingTx(tx *sqlx.Tx, employeeId int64, roleId int64) (exists bool, err error) {
+	query := "SELECT EXISTS (SELECT 1 FROM access_request WHERE employee_id = $1 AND role_id = $2 AND status = 'pending')"
+	err = tx.Get(&exists, query, employeeId, roleId)
+	return
+}
+
+// FindApproversTx возвращает согласующих из политики роли или nil, если политика не задана
+func (r *Repository) FindApproversTx(tx *sqlx.Tx, roleId int64) ([]string, error) {
+	var approvers pq.StringArray
+	err := tx.Get(&approvers, "SELECT approvers FROM role_approval_policy WHERE role_id = $1", roleId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return approvers, nil
+}
+
+func (r *Repository) CreateTx(tx *sqlx.Tx, e *Entity) error {
+	query := `INSERT INTO access_request (requester_id, employee_id, role_id, justification, approvers, approver_ids)
+              VALUES (:requester_id, :employee_id, :role_id, :justification, :approvers, :approver_ids)
+              RETURNING *`
+	rows, err := tx.NamedQuery(query, e)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.StructScan(e)
+	}
+	return rows.Err()
+}
+
+// FindByIdTx читает заявку и блокирует её до конца транзакции, чтобы решения по ней не пересекались
+func (r *Repository) FindByIdTx(tx *sqlx.Tx, id int64) (request Entity, err error) {
+	err = tx.Get(&request, "SELECT * FROM access_request WHERE id = $1 FOR UPDATE", id)
+	return
+}
+
+// UpdateTx сохраняет статус и шаг заявки и возвращает её актуальное состояние в e
+func (r *Repository) UpdateTx(tx *sqlx.Tx, e *Entity) error {
+	query := `UPDATE access_request SET status = $1, step = $2, updated_at = NOW(),
+              closed_at = CASE WHEN $1 = 'pending' THEN NULL ELSE NOW() END
+              WHERE id = $3 RETURNING *`
+	return tx.Get(e, query, e.Status, e.Step, e.Id)
+}
+
+func (r *Repository) CreateDecisionTx(tx *sqlx.Tx, d DecisionEntity) error {
+	query := `INSERT INTO access_request_decision (request_id, step, approver_type, approver_id, decision, comment)
+              VALUES (:request_id, :step, :approver_type, :approver_id, :decision, :comment)`
+	_, err := tx.NamedExec(query, d)
+	return err
+}
+
+// AssignTx назначает роль сотруднику по согласованной заявке бессрочно и сразу, как assignment.Repository.CreateTx.
+// Назначение, уже выданное правилом, становится ручным
+func (r *Repository) AssignTx(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	query := `INSERT INTO employee_role (employee_id, role_id) VALUES ($1, $2)
+              ON CONFLICT (employee_id, role_id) DO UPDATE SET rule_id = NULL,
+                  valid_from = LEAST(employee_role.valid_from, EXCLUDED.valid_from),
+                  valid_until = NULL, activated = TRUE, warned_at = NULL`
+	_, err := tx.Exec(query, employeeId, roleId)
+	return err
+}
+
+// FindPolicy возвращает владельца роли и её политику согласования; approvers пуст, если политика не задана
+func (r *Repository) FindPolicy(roleId int64) (policy PolicyEntity, err error) {
+	query := `SELECT r.id AS role_id, r.owner_id, COALESCE(p.approvers, '{}') AS approvers
+              FROM role r LEFT JOIN role_approval_policy p ON p.role_id = r.id
+              WHERE r.id = $1`
+	err = r.db.Get(&policy, query, roleId)
+	return
+}
+
+// SavePolicyTx задаёт владельца роли и порядок согласования. Возвращает false, если роли нет
+func (r *Repository) SavePolicyTx(tx *sqlx.Tx, p PolicyEntity) (bool, error) {
+	res, err := tx.Exec("UPDATE role SET owner_id = $1 WHERE id = $2", p.OwnerId, p.RoleId)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil || count == 0 {
+		return false, err
+	}
+	query := `INSERT INTO role_approval_policy (role_id, approvers) VALUES ($1, $2)
+              ON CONFLICT (role_id) DO UPDATE SET approvers = EXCLUDED.approvers`
+	_, err = tx.Exec(query, p.RoleId, p.Approvers)
+	return err == nil, err
+}
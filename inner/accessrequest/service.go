@@ -0,0 +1,441 @@
+package accessrequest
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"slices"
+)
+
+// Структура сервиса заявок на доступ: подача, согласование по шагам и назначение роли после последнего согласования
+type Service struct {
+	repo        Repo
+	validator   Validator
+	provisioner Provisioner
+	guard       Guard
+}
+
+type SubmitRequest struct {
+	RequesterId   int64  `json:"requester_id" validate:"required,gt=0"`
+	EmployeeId    int64  `json:"employee_id" validate:"required,gt=0"`
+	RoleId        int64  `json:"role_id" validate:"required,gt=0"`
+	Justification string `json:"justification" validate:"required,min=10,max=1000"`
+}
+
+// DecisionRequest решение согласующего approverId по заявке Id
+type DecisionRequest struct {
+	Id         int64  `json:"-" validate:"required,gt=0"`
+	ApproverId int64  `json:"approver_id" validate:"required,gt=0"`
+	Comment    string `json:"comment" validate:"max=1000"`
+}
+
+// CancelRequest отзыв заявки тем, кто её подал, или сотрудником, которому нужен доступ
+type CancelRequest struct {
+	Id         int64 `json:"-" validate:"required,gt=0"`
+	EmployeeId int64 `json:"employee_id" validate:"required,gt=0"`
+}
+
+// PolicyRequest владелец роли и согласующие по порядку. OwnerId, равный 0, снимает владельца
+type PolicyRequest struct {
+	RoleId    int64    `json:"-" validate:"required,gt=0"`
+	OwnerId   int64    `json:"owner_id" validate:"omitempty,gt=0"`
+	Approvers []string `json:"approvers" validate:"required,min=1,max=5,dive,oneof=role_owner manager"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+// Provisioner асинхронно передаёт назначенные по заявкам роли во внешние системы
+type Provisioner interface {
+	Publish(event provisioning.Event)
+}
+
+// Guard проверяет в транзакции, что роль можно выдать сотруднику, например sod.Service
+type Guard interface {
+	CheckTx(tx *sqlx.Tx, employeeId int64, roleId int64) error
+}
+
+type Repo interface {
+	BeginTransaction() (*sqlx.Tx, error)
+	FindById(int64) (Entity, error)
+	FindAll(string) ([]Entity, error)
+	FindPendingByApprover(int64) ([]Entity, error)
+	FindDecisions(int64) ([]DecisionEntity, error)
+	FindPartiesTx(*sqlx.Tx, int64, int64) (PartiesEntity, error)
+	HasAssignmentTx(*sqlx.Tx, int64, int64) (bool, error)
+	HasPendingTx(*sqlx.Tx, int64, int64) (bool, error)
+	FindApproversTx(*sqlx.Tx, int64) ([]string, error)
+	CreateTx(*sqlx.Tx, *Entity) error
+	FindByIdTx(*sqlx.Tx, int64) (Entity, error)
+	UpdateTx(*sqlx.Tx, *Entity) error
+	CreateDecisionTx(*sqlx.Tx, DecisionEntity) error
+	AssignTx(*sqlx.Tx, int64, int64) error
+	FindPolicy(int64) (PolicyEntity, error)
+	SavePolicyTx(*sqlx.Tx, PolicyEntity) (bool, error)
+}
+
+// NewService создаёт сервис заявок. Если provisioner равен nil, назначенные роли во внешние системы не передаются,
+// если guard равен nil, запрашиваемые роли не проверяются
+func NewService(repo Repo, validator Validator, provisioner Provisioner, guard Guard) *Service {
+	return &Service{
+		repo:        repo,
+		validator:   validator,
+		provisioner: provisioner,
+		guard:       guard,
+	}
+}
+
+// Submit подаёт заявку на роль для сотрудника и возвращает её идентификатор.
+// Согласующие берутся из политики роли и фиксируются в заявке вместе с тем, кто ими был при подаче,
+// поэтому ни смена политики, ни смена руководителя или владельца роли не влияют на уже поданные заявки
+func (srv *Service) Submit(request SubmitRequest) (id int64, err error) {
+	err = srv.validator.Validate(request)
+	if err != nil {
+		return 0, common.RequestValidationError{Message: err.Error()}
+	}
+
+	err = database.InTransaction(srv.repo.BeginTransaction, "submitting access request", func(tx *sqlx.Tx) error {
+		parties, err := srv.findParties(tx, request.EmployeeId, request.RoleId)
+		if err != nil {
+			return err
+		}
+		assigned, err := srv.repo.HasAssignmentTx(tx, request.EmployeeId, request.RoleId)
+		if err != nil {
+			return fmt.Errorf("error checking assignment of role %d to employee %d: %w", request.RoleId, request.EmployeeId, err)
+		}
+		if assigned {
+			return common.AlreadyExistsError{Message: fmt.Sprintf("employee %d already has role %d", request.EmployeeId, request.RoleId)}
+		}
+		pending, err := srv.repo.HasPendingTx(tx, request.EmployeeId, request.RoleId)
+		if err != nil {
+			return fmt.Errorf("error checking pending access requests: %w", err)
+		}
+		if pending {
+			return common.AlreadyExistsError{Message: fmt.Sprintf("access request for role %d of employee %d is already pending", request.RoleId, request.EmployeeId)}
+		}
+
+		// заявку, которую всё равно нельзя будет исполнить, не отправляем на согласование
+		if err := srv.check(tx, request.EmployeeId, request.RoleId); err != nil {
+			return err
+		}
+
+		approvers, err := srv.repo.FindApproversTx(tx, request.RoleId)
+		if err != nil {
+			return fmt.Errorf("error get approval policy of role %d: %w", request.RoleId, err)
+		}
+		if len(approvers) == 0 {
+			approvers = DefaultApprovers
+		}
+		// заявку, которую некому согласовать, не принимаем сразу, а не оставляем висеть
+		var approverIds = make([]int64, 0, len(approvers))
+		for _, approverType := range approvers {
+			approverId, ok := parties.approver(approverType)
+			if !ok {
+				return common.RequestValidationError{Message: fmt.Sprintf("no %s to approve access to role %q for employee %q",
+					approverType, parties.RoleName, parties.EmployeeName)}
+			}
+			approverIds = append(approverIds, approverId)
+		}
+
+		var entity = Entity{
+			RequesterId:   request.RequesterId,
+			EmployeeId:    request.EmployeeId,
+			RoleId:        request.RoleId,
+			Justification: request.Justification,
+			Approvers:     approvers,
+			ApproverIds:   approverIds,
+		}
+		if err := srv.repo.CreateTx(tx, &entity); err != nil {
+			return fmt.Errorf("error creating access request: %w", err)
+		}
+		id = entity.Id
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Approve согласует текущий шаг заявки. После последнего шага роль назначается в той же транзакции
+func (srv *Service) Approve(request DecisionRequest) (Response, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return Response{}, common.RequestValidationError{Message: err.Error()}
+	}
+
+	var entity Entity
+	var events []provisioning.Event
+	err = database.InTransaction(srv.repo.BeginTransaction, "approving access request", func(tx *sqlx.Tx) error {
+		var parties PartiesEntity
+		entity, parties, err = srv.decide(tx, request, DecisionApproved)
+		if err != nil {
+			return err
+		}
+
+		entity.Step++
+		if entity.Step == len(entity.Approvers) {
+			entity.Status = StatusApproved
+			// роли сотрудника могли измениться, пока заявка согласовывалась
+			if err := srv.check(tx, entity.EmployeeId, entity.RoleId); err != nil {
+				return err
+			}
+			if err := srv.repo.AssignTx(tx, entity.EmployeeId, entity.RoleId); err != nil {
+				return fmt.Errorf("error assign role %d to employee %d: %w", entity.RoleId, entity.EmployeeId, err)
+			}
+			if srv.provisioner != nil {
+				events = append(events, provisioning.Event{
+					Type:        provisioning.EntitlementAdded,
+					Account:     provisioning.Account{EmployeeId: entity.EmployeeId, Name: parties.EmployeeName},
+					Entitlement: provisioning.Entitlement{RoleId: entity.RoleId, Name: parties.RoleName},
+				})
+			}
+		}
+		if err := srv.repo.UpdateTx(tx, &entity); err != nil {
+			return fmt.Errorf("error updating access request %d: %w", entity.Id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	for _, e := range events {
+		srv.provisioner.Publish(e)
+	}
+	return entity.toResponse(), nil
+}
+
+// Reject отклоняет заявку на текущем шаге; причина отказа обязательна
+func (srv *Service) Reject(request DecisionRequest) (Response, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return Response{}, common.RequestValidationError{Message: err.Error()}
+	}
+	if request.Comment == "" {
+		return Response{}, common.RequestValidationError{Message: "comment is required to reject access request"}
+	}
+
+	var entity Entity
+	err = database.InTransaction(srv.repo.BeginTransaction, "rejecting access request", func(tx *sqlx.Tx) error {
+		entity, _, err = srv.decide(tx, request, DecisionRejected)
+		if err != nil {
+			return err
+		}
+		entity.Status = StatusRejected
+		if err := srv.repo.UpdateTx(tx, &entity); err != nil {
+			return fmt.Errorf("error updating access request %d: %w", entity.Id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return entity.toResponse(), nil
+}
+
+// Cancel отзывает заявку, пока по ней не принято окончательное решение
+func (srv *Service) Cancel(request CancelRequest) (Response, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return Response{}, common.RequestValidationError{Message: err.Error()}
+	}
+
+	var entity Entity
+	err = database.InTransaction(srv.repo.BeginTransaction, "cancelling access request", func(tx *sqlx.Tx) error {
+		entity, err = srv.findPendingTx(tx, request.Id)
+		if err != nil {
+			return err
+		}
+		if request.EmployeeId != entity.RequesterId && request.EmployeeId != entity.EmployeeId {
+			return common.ForbiddenError{Message: fmt.Sprintf("employee %d cannot cancel access request %d", request.EmployeeId, request.Id)}
+		}
+		entity.Status = StatusCancelled
+		if err := srv.repo.UpdateTx(tx, &entity); err != nil {
+			return fmt.Errorf("error updating access request %d: %w", entity.Id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return entity.toResponse(), nil
+}
+
+// decide проверяет, что решение по текущему шагу принимает согласующий, зафиксированный на него при подаче заявки,
+// и записывает решение. Руководитель или владелец роли, назначенный после подачи, заявку не согласует
+func (srv *Service) decide(tx *sqlx.Tx, request DecisionRequest, decision string) (Entity, PartiesEntity, error) {
+	entity, err := srv.findPendingTx(tx, request.Id)
+	if err != nil {
+		return Entity{}, PartiesEntity{}, err
+	}
+	parties, err := srv.findParties(tx, entity.EmployeeId, entity.RoleId)
+	if err != nil {
+		return Entity{}, PartiesEntity{}, err
+	}
+
+	var approverType = entity.Approvers[entity.Step]
+	if entity.Step >= len(entity.ApproverIds) || entity.ApproverIds[entity.Step] != request.ApproverId {
+		return Entity{}, PartiesEntity{}, common.ForbiddenError{Message: fmt.Sprintf(
+			"employee %d is not the %s approving step %d of access request %d", request.ApproverId, approverType, entity.Step+1, entity.Id)}
+	}
+	// руководитель или владелец роли не согласует доступ сам себе
+	if request.ApproverId == entity.EmployeeId {
+		return Entity{}, PartiesEntity{}, common.ForbiddenError{Message: fmt.Sprintf(
+			"employee %d cannot decide on own access request %d", request.ApproverId, entity.Id)}
+	}
+
+	err = srv.repo.CreateDecisionTx(tx, DecisionEntity{
+		RequestId:    entity.Id,
+		Step:         entity.Step,
+		ApproverType: approverType,
+		ApproverId:   request.ApproverId,
+		Decision:     decision,
+		Comment:      request.Comment,
+	})
+	if err != nil {
+		return Entity{}, PartiesEntity{}, fmt.Errorf("error saving decision on access request %d: %w", entity.Id, err)
+	}
+	return entity, parties, nil
+}
+
+// check проверяет назначение через guard, если он задан
+func (srv *Service) check(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	if srv.guard == nil {
+		return nil
+	}
+	return srv.guard.CheckTx(tx, employeeId, roleId)
+}
+
+func (srv *Service) findPendingTx(tx *sqlx.Tx, id int64) (Entity, error) {
+	entity, err := srv.repo.FindByIdTx(tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entity{}, common.NotFoundError{Message: fmt.Sprintf("access request with id %d not found", id)}
+	}
+	if err != nil {
+		return Entity{}, fmt.Errorf("error finding access request with id %d: %w", id, err)
+	}
+	if entity.Status != StatusPending {
+		return Entity{}, common.ConflictError{Message: fmt.Sprintf("access request %d is already %s", id, entity.Status)}
+	}
+	return entity, nil
+}
+
+func (srv *Service) findParties(tx *sqlx.Tx, employeeId int64, roleId int64) (PartiesEntity, error) {
+	parties, err := srv.repo.FindPartiesTx(tx, employeeId, roleId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PartiesEntity{}, common.NotFoundError{Message: fmt.Sprintf("employee %d or role %d not found", employeeId, roleId)}
+	}
+	if err != nil {
+		return PartiesEntity{}, fmt.Errorf("error get employee %d and role %d: %w", employeeId, roleId, err)
+	}
+	return parties, nil
+}
+
+// FindById возвращает заявку вместе с принятыми по ней решениями
+func (srv *Service) FindById(id int64) (Response, error) {
+	var entity, err = srv.repo.FindById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Response{}, common.NotFoundError{Message: fmt.Sprintf("access request with id %d not found", id)}
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("error finding access request with id %d: %w", id, err)
+	}
+	decisions, err := srv.repo.FindDecisions(id)
+	if err != nil {
+		return Response{}, fmt.Errorf("error get decisions on access request %d: %w", id, err)
+	}
+
+	var resp = entity.toResponse()
+	for _, d := range decisions {
+		resp.Decisions = append(resp.Decisions, d.toResponse())
+	}
+	return resp, nil
+}
+
+// FindAll возвращает заявки в статусе status или все, если status пуст
+func (srv *Service) FindAll(status string) ([]Response, error) {
+	if status != "" && !slices.Contains([]string{StatusPending, StatusApproved, StatusRejected, StatusCancelled}, status) {
+		return []Response{}, common.RequestValidationError{Message: fmt.Sprintf("unknown access request status %q", status)}
+	}
+	var entities, err = srv.repo.FindAll(status)
+	if err != nil {
+		return []Response{}, fmt.Errorf("error get access requests: %w", err)
+	}
+	return toResponses(entities), nil
+}
+
+// FindPendingByApprover возвращает заявки, ожидающие решения сотрудника
+func (srv *Service) FindPendingByApprover(approverId int64) ([]Response, error) {
+	if approverId <= 0 {
+		return []Response{}, common.RequestValidationError{Message: "approver_id must be greater than 0"}
+	}
+	var entities, err = srv.repo.FindPendingByApprover(approverId)
+	if err != nil {
+		return []Response{}, fmt.Errorf("error get access requests pending for employee %d: %w", approverId, err)
+	}
+	return toResponses(entities), nil
+}
+
+// FindPolicy возвращает владельца роли и порядок согласования заявок на неё
+func (srv *Service) FindPolicy(roleId int64) (PolicyResponse, error) {
+	var policy, err = srv.repo.FindPolicy(roleId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PolicyResponse{}, common.NotFoundError{Message: fmt.Sprintf("role with id %d not found", roleId)}
+	}
+	if err != nil {
+		return PolicyResponse{}, fmt.Errorf("error get approval policy of role %d: %w", roleId, err)
+	}
+	if len(policy.Approvers) == 0 {
+		policy.Approvers = DefaultApprovers
+	}
+	return policy.toResponse(), nil
+}
+
+// SetPolicy задаёт владельца роли и порядок согласования. Уже поданные заявки согласуются по прежним правилам
+func (srv *Service) SetPolicy(request PolicyRequest) (PolicyResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return PolicyResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	var approvers []string
+	for _, a := range request.Approvers {
+		if slices.Contains(approvers, a) {
+			return PolicyResponse{}, common.RequestValidationError{Message: fmt.Sprintf("approver %s is listed twice", a)}
+		}
+		approvers = append(approvers, a)
+	}
+
+	var policy = PolicyEntity{
+		RoleId:    request.RoleId,
+		OwnerId:   sql.NullInt64{Int64: request.OwnerId, Valid: request.OwnerId > 0},
+		Approvers: approvers,
+	}
+	err = database.InTransaction(srv.repo.BeginTransaction, "setting approval policy", func(tx *sqlx.Tx) error {
+		found, err := srv.repo.SavePolicyTx(tx, policy)
+		if err != nil {
+			return fmt.Errorf("error saving approval policy of role %d: %w", request.RoleId, err)
+		}
+		if !found {
+			return common.NotFoundError{Message: fmt.Sprintf("role with id %d not found", request.RoleId)}
+		}
+		return nil
+	})
+	if err != nil {
+		return PolicyResponse{}, err
+	}
+	return policy.toResponse(), nil
+}
+
+func toResponses(entities []Entity) []Response {
+	var resp = []Response{}
+	for _, e := range entities {
+		resp = append(resp, e.toResponse())
+	}
+	return resp
+}
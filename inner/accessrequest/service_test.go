@@ -0,0 +1,370 @@
+package accessrequest
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) FindById(id int64) (Entity, error) {
+	args := m.Called(id)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindAll(status string) ([]Entity, error) {
+	args := m.Called(status)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindPendingByApprover(approverId int64) ([]Entity, error) {
+	args := m.Called(approverId)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindDecisions(requestId int64) ([]DecisionEntity, error) {
+	args := m.Called(requestId)
+	return args.Get(0).([]DecisionEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindPartiesTx(tx *sqlx.Tx, employeeId int64, roleId int64) (PartiesEntity, error) {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Get(0).(PartiesEntity), args.Error(1)
+}
+
+func (m *MockRepo) HasAssignmentTx(tx *sqlx.Tx, employeeId int64, roleId int64) (bool, error) {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) HasPendingTx(tx *sqlx.Tx, employeeId int64, roleId int64) (bool, error) {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) FindApproversTx(tx *sqlx.Tx, roleId int64) ([]string, error) {
+	args := m.Called(tx, roleId)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockRepo) CreateTx(tx *sqlx.Tx, e *Entity) error {
+	args := m.Called(tx, e)
+	e.Id = 1
+	return args.Error(0)
+}
+
+func (m *MockRepo) FindByIdTx(tx *sqlx.Tx, id int64) (Entity, error) {
+	args := m.Called(tx, id)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) UpdateTx(tx *sqlx.Tx, e *Entity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) CreateDecisionTx(tx *sqlx.Tx, d DecisionEntity) error {
+	args := m.Called(tx, d)
+	return args.Error(0)
+}
+
+func (m *MockRepo) AssignTx(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Error(0)
+}
+
+func (m *MockRepo) FindPolicy(roleId int64) (PolicyEntity, error) {
+	args := m.Called(roleId)
+	return args.Get(0).(PolicyEntity), args.Error(1)
+}
+
+func (m *MockRepo) SavePolicyTx(tx *sqlx.Tx, p PolicyEntity) (bool, error) {
+	args := m.Called(tx, p)
+	return args.Bool(0), args.Error(1)
+}
+
+// StubProvisioner запоминает опубликованные события
+type StubProvisioner struct {
+	events []provisioning.Event
+}
+
+func (s *StubProvisioner) Publish(event provisioning.Event) {
+	s.events = append(s.events, event)
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+// сотрудник 10 с руководителем 20 просит роль 5, владелец которой сотрудник 30
+var parties = PartiesEntity{
+	EmployeeName: "John Doe",
+	ManagerId:    sql.NullInt64{Int64: 20, Valid: true},
+	RoleName:     "Payment Approver",
+	OwnerId:      sql.NullInt64{Int64: 30, Valid: true},
+}
+
+// pendingRequest заявка, согласующими которой при подаче были руководитель и владелец роли из parties
+func pendingRequest(approvers ...string) Entity {
+	var approverIds []int64
+	for _, approverType := range approvers {
+		approverId, _ := parties.approver(approverType)
+		approverIds = append(approverIds, approverId)
+	}
+	return Entity{Id: 1, RequesterId: 10, EmployeeId: 10, RoleId: 5, Status: StatusPending,
+		Approvers: approvers, ApproverIds: approverIds}
+}
+
+func TestSubmit(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+	var request = SubmitRequest{RequesterId: 10, EmployeeId: 10, RoleId: 5, Justification: "need to approve invoices"}
+
+	t.Run("should use role owner when role has no policy", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(parties, nil)
+		repo.On("HasAssignmentTx", tx, int64(10), int64(5)).Return(false, nil)
+		repo.On("HasPendingTx", tx, int64(10), int64(5)).Return(false, nil)
+		repo.On("FindApproversTx", tx, int64(5)).Return([]string(nil), nil)
+		repo.On("CreateTx", tx, mock.MatchedBy(func(e *Entity) bool {
+			return assert.ObjectsAreEqual([]string{ApproverRoleOwner}, []string(e.Approvers)) &&
+				assert.ObjectsAreEqual([]int64{30}, []int64(e.ApproverIds))
+		})).Return(nil)
+		var id, err = svc.Submit(request)
+		a.Nil(err)
+		a.Equal(int64(1), id)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject request nobody can approve", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, sqlMock := newTx(a, false)
+		var noManager = parties
+		noManager.ManagerId = sql.NullInt64{}
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(noManager, nil)
+		repo.On("HasAssignmentTx", tx, int64(10), int64(5)).Return(false, nil)
+		repo.On("HasPendingTx", tx, int64(10), int64(5)).Return(false, nil)
+		repo.On("FindApproversTx", tx, int64(5)).Return([]string{ApproverManager, ApproverRoleOwner}, nil)
+		var _, err = svc.Submit(request)
+		a.ErrorAs(err, &common.RequestValidationError{})
+		a.ErrorContains(err, "no manager")
+		repo.AssertNotCalled(t, "CreateTx", mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should not request role already assigned", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(parties, nil)
+		repo.On("HasAssignmentTx", tx, int64(10), int64(5)).Return(true, nil)
+		var _, err = svc.Submit(request)
+		a.ErrorAs(err, &common.AlreadyExistsError{})
+	})
+
+	t.Run("should require justification", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator, nil, nil)
+		var _, err = svc.Submit(SubmitRequest{RequesterId: 10, EmployeeId: 10, RoleId: 5})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+}
+
+func TestApprove(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+
+	t.Run("should move to next approver without assigning", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(1)).Return(pendingRequest(ApproverManager, ApproverRoleOwner), nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(parties, nil)
+		repo.On("CreateDecisionTx", tx, DecisionEntity{RequestId: 1, Step: 0, ApproverType: ApproverManager,
+			ApproverId: 20, Decision: DecisionApproved}).Return(nil)
+		repo.On("UpdateTx", tx, mock.Anything).Return(nil)
+		var resp, err = svc.Approve(DecisionRequest{Id: 1, ApproverId: 20})
+		a.Nil(err)
+		a.Equal(StatusPending, resp.Status)
+		a.Equal(1, resp.Step)
+		repo.AssertNotCalled(t, "AssignTx", mock.Anything, mock.Anything, mock.Anything)
+		a.Empty(provisioner.events)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should assign role on final approval and publish after commit", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		tx, sqlMock := newTx(a, true)
+		var request = pendingRequest(ApproverManager, ApproverRoleOwner)
+		request.Step = 1
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(1)).Return(request, nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(parties, nil)
+		repo.On("CreateDecisionTx", tx, mock.Anything).Return(nil)
+		repo.On("AssignTx", tx, int64(10), int64(5)).Return(nil)
+		repo.On("UpdateTx", tx, mock.Anything).Return(nil)
+		var resp, err = svc.Approve(DecisionRequest{Id: 1, ApproverId: 30, Comment: "ok"})
+		a.Nil(err)
+		a.Equal(StatusApproved, resp.Status)
+		a.Equal([]provisioning.Event{{
+			Type:        provisioning.EntitlementAdded,
+			Account:     provisioning.Account{EmployeeId: 10, Name: "John Doe"},
+			Entitlement: provisioning.Entitlement{RoleId: 5, Name: "Payment Approver"},
+		}}, provisioner.events)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should forbid decision by someone else", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, sqlMock := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(1)).Return(pendingRequest(ApproverRoleOwner), nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(parties, nil)
+		var _, err = svc.Approve(DecisionRequest{Id: 1, ApproverId: 20})
+		a.ErrorAs(err, &common.ForbiddenError{})
+		repo.AssertNotCalled(t, "CreateDecisionTx", mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should forbid decision by manager assigned after submit", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, sqlMock := newTx(a, false)
+		var newManager = parties
+		newManager.ManagerId = sql.NullInt64{Int64: 21, Valid: true}
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(1)).Return(pendingRequest(ApproverManager), nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(newManager, nil)
+		var _, err = svc.Approve(DecisionRequest{Id: 1, ApproverId: 21})
+		a.ErrorAs(err, &common.ForbiddenError{})
+		repo.AssertNotCalled(t, "CreateDecisionTx", mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should forbid approving own access", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, _ := newTx(a, false)
+		var ownerParties = parties
+		ownerParties.OwnerId = sql.NullInt64{Int64: 10, Valid: true}
+		var request = pendingRequest(ApproverRoleOwner)
+		request.ApproverIds = []int64{10}
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(1)).Return(request, nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(ownerParties, nil)
+		var _, err = svc.Approve(DecisionRequest{Id: 1, ApproverId: 10})
+		a.ErrorAs(err, &common.ForbiddenError{})
+	})
+
+	t.Run("should return conflict for closed request", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, _ := newTx(a, false)
+		var closed = pendingRequest(ApproverRoleOwner)
+		closed.Status = StatusCancelled
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(1)).Return(closed, nil)
+		var _, err = svc.Approve(DecisionRequest{Id: 1, ApproverId: 30})
+		a.ErrorAs(err, &common.ConflictError{})
+	})
+}
+
+func TestRejectAndCancel(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+
+	t.Run("should require reason to reject", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator, nil, nil)
+		var _, err = svc.Reject(DecisionRequest{Id: 1, ApproverId: 30})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should reject on any step", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(1)).Return(pendingRequest(ApproverRoleOwner), nil)
+		repo.On("FindPartiesTx", tx, int64(10), int64(5)).Return(parties, nil)
+		repo.On("CreateDecisionTx", tx, mock.Anything).Return(nil)
+		repo.On("UpdateTx", tx, mock.Anything).Return(nil)
+		var resp, err = svc.Reject(DecisionRequest{Id: 1, ApproverId: 30, Comment: "not in finance"})
+		a.Nil(err)
+		a.Equal(StatusRejected, resp.Status)
+		repo.AssertNotCalled(t, "AssignTx", mock.Anything, mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should forbid cancel by unrelated employee", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(1)).Return(pendingRequest(ApproverRoleOwner), nil)
+		var _, err = svc.Cancel(CancelRequest{Id: 1, EmployeeId: 30})
+		a.ErrorAs(err, &common.ForbiddenError{})
+	})
+}
+
+func TestSetPolicy(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+
+	t.Run("should reject unknown approver type", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator, nil, nil)
+		var _, err = svc.SetPolicy(PolicyRequest{RoleId: 5, Approvers: []string{"cfo"}})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should reject duplicated approver", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator, nil, nil)
+		var _, err = svc.SetPolicy(PolicyRequest{RoleId: 5, Approvers: []string{ApproverManager, ApproverManager}})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should return not found for missing role", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("SavePolicyTx", tx, mock.Anything).Return(false, nil)
+		var _, err = svc.SetPolicy(PolicyRequest{RoleId: 5, OwnerId: 30, Approvers: []string{ApproverRoleOwner}})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+}
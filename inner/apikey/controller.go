@@ -0,0 +1,200 @@
+package apikey
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+)
+
+type Controller struct {
+	server        *web.Server
+	apiKeyService Svc
+}
+
+// интерфейс сервиса apikey.Service
+type Svc interface {
+	CreateAccount(request AccountRequest) (AccountResponse, error)
+	FindAccounts() ([]AccountResponse, error)
+	DeleteAccount(id int64) error
+	FindKeys(accountId int64) ([]KeyResponse, error)
+	CreateKey(request KeyRequest) (KeyResponse, error)
+	RotateKey(request RotateRequest) (KeyResponse, error)
+	RevokeKey(accountId int64, keyId int64) error
+}
+
+func NewController(server *web.Server, apiKeyService Svc) *Controller {
+	return &Controller{
+		server:        server,
+		apiKeyService: apiKeyService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/service-accounts", c.CreateAccount)
+	c.server.GroupApiV1.Get("/service-accounts", c.FindAccounts)
+	c.server.GroupApiV1.Delete("/service-accounts/:id", c.DeleteAccount)
+	c.server.GroupApiV1.Get("/service-accounts/:id/keys", c.FindKeys)
+	c.server.GroupApiV1.Post("/service-accounts/:id/keys", c.CreateKey)
+	c.server.GroupApiV1.Post("/service-accounts/:id/keys/:keyId/rotate", c.RotateKey)
+	c.server.GroupApiV1.Delete("/service-accounts/:id/keys/:keyId", c.RevokeKey)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/service-accounts"
+func (c *Controller) CreateAccount(ctx *fiber.Ctx) error {
+	var request AccountRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	resp, err := c.apiKeyService.CreateAccount(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error create service account")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindAccounts(ctx *fiber.Ctx) error {
+	resp, err := c.apiKeyService.FindAccounts()
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get service accounts")
+	}
+
+	return nil
+}
+
+func (c *Controller) DeleteAccount(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	if err = c.apiKeyService.DeleteAccount(id); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error delete service account")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindKeys(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.apiKeyService.FindKeys(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get api keys")
+	}
+
+	return nil
+}
+
+// CreateKey выпускает ключ; ответ содержит ключ, поэтому не кешируется
+func (c *Controller) CreateKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	var request KeyRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.AccountId = id
+
+	resp, err := c.apiKeyService.CreateKey(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error create api key")
+	}
+
+	return nil
+}
+
+func (c *Controller) RotateKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	keyId, err := strconv.ParseInt(ctx.Params("keyId"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid key id")
+	}
+	var request RotateRequest
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&request); err != nil {
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		}
+	}
+	request.AccountId, request.KeyId = id, keyId
+
+	resp, err := c.apiKeyService.RotateKey(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error rotate api key")
+	}
+
+	return nil
+}
+
+func (c *Controller) RevokeKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	keyId, err := strconv.ParseInt(ctx.Params("keyId"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid key id")
+	}
+
+	if err = c.apiKeyService.RevokeKey(id, keyId); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, keyId); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error revoke api key")
+	}
+
+	return nil
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	case errors.As(err, &common.AlreadyExistsError{}), errors.As(err, &common.ConflictError{}):
+		return common.ErrResponse(ctx, fiber.StatusConflict, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
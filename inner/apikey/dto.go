@@ -0,0 +1,83 @@
+package apikey
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// AccountEntity сервисный аккаунт машинного клиента
+type AccountEntity struct {
+	Id          int64     `db:"id"`
+	Name        string    `db:"name"`
+	Description string    `db:"description"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// KeyEntity API-ключ сервисного аккаунта; сам ключ не хранится, только префикс и SHA-256
+type KeyEntity struct {
+	Id               int64          `db:"id"`
+	ServiceAccountId int64          `db:"service_account_id"`
+	Name             string         `db:"name"`
+	Prefix           string         `db:"prefix"`
+	SecretHash       string         `db:"secret_hash"`
+	Scopes           pq.StringArray `db:"scopes"`
+	ExpiresAt        sql.NullTime   `db:"expires_at"`
+	LastUsedAt       sql.NullTime   `db:"last_used_at"`
+	LastUsedIp       sql.NullString `db:"last_used_ip"`
+	RevokedAt        sql.NullTime   `db:"revoked_at"`
+	CreatedAt        time.Time      `db:"created_at"`
+}
+
+type AccountResponse struct {
+	Id          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type KeyResponse struct {
+	Id               int64      `json:"id"`
+	ServiceAccountId int64      `json:"service_account_id"`
+	Name             string     `json:"name"`
+	Prefix           string     `json:"prefix"`
+	Scopes           []string   `json:"scopes"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIp       string     `json:"last_used_ip,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	// Key показывается один раз при создании или ротации
+	Key string `json:"key,omitempty"`
+}
+
+func (e *AccountEntity) toResponse() AccountResponse {
+	return AccountResponse{
+		Id:          e.Id,
+		Name:        e.Name,
+		Description: e.Description,
+		CreatedAt:   e.CreatedAt,
+	}
+}
+
+func (e *KeyEntity) toResponse() KeyResponse {
+	var resp = KeyResponse{
+		Id:               e.Id,
+		ServiceAccountId: e.ServiceAccountId,
+		Name:             e.Name,
+		Prefix:           e.Prefix,
+		Scopes:           e.Scopes,
+		LastUsedIp:       e.LastUsedIp.String,
+		CreatedAt:        e.CreatedAt,
+	}
+	if e.ExpiresAt.Valid {
+		resp.ExpiresAt = &e.ExpiresAt.Time
+	}
+	if e.LastUsedAt.Valid {
+		resp.LastUsedAt = &e.LastUsedAt.Time
+	}
+	if e.RevokedAt.Valid {
+		resp.RevokedAt = &e.RevokedAt.Time
+	}
+	return resp
+}
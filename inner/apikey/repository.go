@@ -0,0 +1,109 @@
+package apikey
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// touchInterval не чаще этого обновляется время последнего использования, чтобы не писать в базу на каждый запрос
+const touchInterval = time.Minute
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// FindAccount возвращает сервисный аккаунт; sql.ErrNoRows, если его нет
+func (r *Repository) FindAccount(id int64) (account AccountEntity, err error) {
+	err = r.db.Get(&account, "SELECT * FROM service_account WHERE id = $1", id)
+	return
+}
+
+func (r *Repository) FindAccounts() (accounts []AccountEntity, err error) {
+	err = r.db.Select(&accounts, "SELECT * FROM service_account ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// DeleteAccount удаляет аккаунт вместе с его ключами; возвращает false, если аккаунта нет
+func (r *Repository) DeleteAccount(id int64) (bool, error) {
+	result, err := r.db.Exec("DELETE FROM service_account WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+func (r *Repository) FindKeys(accountId int64) (keys []KeyEntity, err error) {
+	err = r.db.Select(&keys, "SELECT * FROM api_key WHERE service_account_id = $1 ORDER BY id", accountId)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// FindKeyByPrefix возвращает ключ по префиксу; sql.ErrNoRows, если его нет
+func (r *Repository) FindKeyByPrefix(prefix string) (key KeyEntity, err error) {
+	err = r.db.Get(&key, "SELECT * FROM api_key WHERE prefix = $1", prefix)
+	return
+}
+
+// TouchKey запоминает время и адрес последнего использования ключа
+func (r *Repository) TouchKey(id int64, at time.Time, ip string) error {
+	query := `UPDATE api_key SET last_used_at = $2, last_used_ip = $3
+              WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $4 OR last_used_ip IS DISTINCT FROM $3)`
+	_, err := r.db.Exec(query, id, at, ip, at.Add(-touchInterval))
+	return err
+}
+
+// RevokeKey отзывает ключ аккаунта; возвращает false, если такого ключа у аккаунта нет
+func (r *Repository) RevokeKey(accountId int64, id int64, at time.Time) (bool, error) {
+	query := `UPDATE api_key SET revoked_at = COALESCE(revoked_at, $3)
+              WHERE id = $1 AND service_account_id = $2`
+	result, err := r.db.Exec(query, id, accountId, at)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) AccountExistsTx(tx *sqlx.Tx, name string) (exists bool, err error) {
+	err = tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM service_account WHERE name = $1)", name)
+	return
+}
+
+func (r *Repository) CreateAccountTx(tx *sqlx.Tx, e *AccountEntity) error {
+	query := `INSERT INTO service_account (name, description) VALUES ($1, $2) RETURNING *`
+	return tx.Get(e, query, e.Name, e.Description)
+}
+
+// FindKeyTx возвращает ключ и блокирует его до конца транзакции; sql.ErrNoRows, если его нет
+func (r *Repository) FindKeyTx(tx *sqlx.Tx, id int64) (key KeyEntity, err error) {
+	err = tx.Get(&key, "SELECT * FROM api_key WHERE id = $1 FOR UPDATE", id)
+	return
+}
+
+func (r *Repository) CreateKeyTx(tx *sqlx.Tx, e *KeyEntity) error {
+	query := `INSERT INTO api_key (service_account_id, name, prefix, secret_hash, scopes, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              RETURNING *`
+	return tx.Get(e, query, e.ServiceAccountId, e.Name, e.Prefix, e.SecretHash, e.Scopes, e.ExpiresAt)
+}
+
+// ExpireKeyTx сокращает срок действия ключа до at, если он истекает позже
+func (r *Repository) ExpireKeyTx(tx *sqlx.Tx, id int64, at time.Time) error {
+	query := `UPDATE api_key SET expires_at = LEAST(COALESCE(expires_at, $2), $2) WHERE id = $1`
+	_, err := tx.Exec(query, id, at)
+	return err
+}
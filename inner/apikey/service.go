@@ -0,0 +1,335 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// KeyPrefix начало каждого ключа, по которому его легко найти в логах и сканерах секретов
+const KeyPrefix = "idm_"
+
+// prefixLength длина открытой части ключа, по которой он ищется в базе
+const prefixLength = 12
+
+// scopePattern область доступа вида "<ресурс>:read" или "<ресурс>:write", см. auth.RequiredScope;
+// кроме них ключу можно выдать auth.ScopeAdmin
+var scopePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*:(read|write)$`)
+
+// Структура сервиса сервисных аккаунтов и их API-ключей
+type Service struct {
+	repo      Repo
+	validator Validator
+	maxTTL    time.Duration
+	now       func() time.Time
+}
+
+type AccountRequest struct {
+	Name        string `json:"name" validate:"required,max=155"`
+	Description string `json:"description" validate:"max=1000"`
+}
+
+// KeyRequest выпуск ключа. Без ExpiresAt ключ действует максимальный срок
+type KeyRequest struct {
+	AccountId int64      `json:"-" validate:"required,gt=0"`
+	Name      string     `json:"name" validate:"required,max=155"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,max=50,dive,required,max=100"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// RotateRequest замена ключа новым с теми же областями доступа.
+// OverlapSeconds сколько старый ключ ещё действует, чтобы клиент успел переключиться
+type RotateRequest struct {
+	AccountId      int64 `json:"-" validate:"required,gt=0"`
+	KeyId          int64 `json:"-" validate:"required,gt=0"`
+	OverlapSeconds int   `json:"overlap_seconds" validate:"min=0,max=604800"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+type Repo interface {
+	FindAccount(int64) (AccountEntity, error)
+	FindAccounts() ([]AccountEntity, error)
+	DeleteAccount(int64) (bool, error)
+	FindKeys(int64) ([]KeyEntity, error)
+	FindKeyByPrefix(string) (KeyEntity, error)
+	TouchKey(int64, time.Time, string) error
+	RevokeKey(int64, int64, time.Time) (bool, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	AccountExistsTx(*sqlx.Tx, string) (bool, error)
+	CreateAccountTx(*sqlx.Tx, *AccountEntity) error
+	FindKeyTx(*sqlx.Tx, int64) (KeyEntity, error)
+	CreateKeyTx(*sqlx.Tx, *KeyEntity) error
+	ExpireKeyTx(*sqlx.Tx, int64, time.Time) error
+}
+
+// NewService создаёт сервис API-ключей; maxTTL — наибольший срок действия ключа
+func NewService(repo Repo, validator Validator, maxTTL time.Duration) *Service {
+	return &Service{
+		repo:      repo,
+		validator: validator,
+		maxTTL:    maxTTL,
+		now:       time.Now,
+	}
+}
+
+func (srv *Service) CreateAccount(request AccountRequest) (AccountResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return AccountResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	var account = AccountEntity{Name: request.Name, Description: request.Description}
+	err = database.InTransaction(srv.repo.BeginTransaction, "creating service account", func(tx *sqlx.Tx) error {
+		exists, err := srv.repo.AccountExistsTx(tx, request.Name)
+		if err != nil {
+			return fmt.Errorf("error finding service account by name: %s, %w", request.Name, err)
+		}
+		if exists {
+			return common.AlreadyExistsError{Message: fmt.Sprintf("service account with name %s already exists", request.Name)}
+		}
+		if err := srv.repo.CreateAccountTx(tx, &account); err != nil {
+			return fmt.Errorf("error creating service account %s: %w", request.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return AccountResponse{}, err
+	}
+	return account.toResponse(), nil
+}
+
+func (srv *Service) FindAccounts() ([]AccountResponse, error) {
+	accounts, err := srv.repo.FindAccounts()
+	if err != nil {
+		return nil, fmt.Errorf("error finding service accounts: %w", err)
+	}
+	var resp = make([]AccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		resp = append(resp, account.toResponse())
+	}
+	return resp, nil
+}
+
+// DeleteAccount удаляет аккаунт; его ключи перестают действовать сразу
+func (srv *Service) DeleteAccount(id int64) error {
+	deleted, err := srv.repo.DeleteAccount(id)
+	if err != nil {
+		return fmt.Errorf("error deleting service account %d: %w", id, err)
+	}
+	if !deleted {
+		return common.NotFoundError{Message: fmt.Sprintf("service account %d not found", id)}
+	}
+	return nil
+}
+
+func (srv *Service) FindKeys(accountId int64) ([]KeyResponse, error) {
+	if err := srv.findAccount(accountId); err != nil {
+		return nil, err
+	}
+	keys, err := srv.repo.FindKeys(accountId)
+	if err != nil {
+		return nil, fmt.Errorf("error finding api keys of service account %d: %w", accountId, err)
+	}
+	var resp = make([]KeyResponse, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, key.toResponse())
+	}
+	return resp, nil
+}
+
+// CreateKey выпускает ключ; сам ключ возвращается один раз и нигде не хранится в открытом виде
+func (srv *Service) CreateKey(request KeyRequest) (KeyResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return KeyResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	for _, scope := range request.Scopes {
+		if !scopePattern.MatchString(scope) && scope != auth.ScopeAdmin {
+			return KeyResponse{}, common.RequestValidationError{
+				Message: fmt.Sprintf("invalid scope %q, expected <resource>:read, <resource>:write or %s", scope, auth.ScopeAdmin),
+			}
+		}
+	}
+	var now = srv.now()
+	var expiresAt = now.Add(srv.maxTTL)
+	if request.ExpiresAt != nil {
+		if !request.ExpiresAt.After(now) || request.ExpiresAt.After(expiresAt) {
+			return KeyResponse{}, common.RequestValidationError{
+				Message: fmt.Sprintf("expires_at must be in the future and within %s", srv.maxTTL),
+			}
+		}
+		expiresAt = *request.ExpiresAt
+	}
+	if err = srv.findAccount(request.AccountId); err != nil {
+		return KeyResponse{}, err
+	}
+
+	var resp KeyResponse
+	err = database.InTransaction(srv.repo.BeginTransaction, "creating api key", func(tx *sqlx.Tx) error {
+		var key = KeyEntity{ServiceAccountId: request.AccountId, Name: request.Name, Scopes: request.Scopes}
+		key.ExpiresAt = sql.NullTime{Time: expiresAt, Valid: true}
+		resp, err = srv.create(tx, key)
+		return err
+	})
+	if err != nil {
+		return KeyResponse{}, err
+	}
+	return resp, nil
+}
+
+// RotateKey выпускает вместо ключа новый с теми же именем, областями доступа и сроком жизни.
+// Старый ключ действует ещё OverlapSeconds, при нуле перестаёт действовать сразу
+func (srv *Service) RotateKey(request RotateRequest) (KeyResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return KeyResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	var now = srv.now()
+	var resp KeyResponse
+	err = database.InTransaction(srv.repo.BeginTransaction, "rotating api key", func(tx *sqlx.Tx) error {
+		old, err := srv.repo.FindKeyTx(tx, request.KeyId)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && old.ServiceAccountId != request.AccountId) {
+			return common.NotFoundError{
+				Message: fmt.Sprintf("api key %d of service account %d not found", request.KeyId, request.AccountId),
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding api key %d: %w", request.KeyId, err)
+		}
+		if !usable(old, now) {
+			return common.ConflictError{Message: fmt.Sprintf("api key %d is revoked or expired", request.KeyId)}
+		}
+
+		var lifetime = srv.maxTTL
+		if old.ExpiresAt.Valid {
+			lifetime = min(old.ExpiresAt.Time.Sub(old.CreatedAt), srv.maxTTL)
+		}
+		var key = KeyEntity{ServiceAccountId: old.ServiceAccountId, Name: old.Name, Scopes: old.Scopes}
+		key.ExpiresAt = sql.NullTime{Time: now.Add(lifetime), Valid: true}
+		if resp, err = srv.create(tx, key); err != nil {
+			return err
+		}
+		var overlap = time.Duration(request.OverlapSeconds) * time.Second
+		if err = srv.repo.ExpireKeyTx(tx, old.Id, now.Add(overlap)); err != nil {
+			return fmt.Errorf("error expiring api key %d: %w", old.Id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return KeyResponse{}, err
+	}
+	return resp, nil
+}
+
+// RevokeKey отзывает ключ; повторный отзыв ничего не меняет
+func (srv *Service) RevokeKey(accountId int64, keyId int64) error {
+	revoked, err := srv.repo.RevokeKey(accountId, keyId, srv.now())
+	if err != nil {
+		return fmt.Errorf("error revoking api key %d: %w", keyId, err)
+	}
+	if !revoked {
+		return common.NotFoundError{Message: fmt.Sprintf("api key %d of service account %d not found", keyId, accountId)}
+	}
+	return nil
+}
+
+// Authenticate проверяет ключ из заголовка "Authorization: ApiKey ..." и запоминает его использование.
+// Неизвестный, отозванный, истёкший или неверный ключ — common.UnauthorizedError без уточнения причины
+func (srv *Service) Authenticate(key string, clientIp string) (auth.Principal, error) {
+	var invalid = common.UnauthorizedError{Message: "invalid api key"}
+	var prefix, ok = parseKey(key)
+	if !ok {
+		return auth.Principal{}, invalid
+	}
+	stored, err := srv.repo.FindKeyByPrefix(prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.Principal{}, invalid
+	}
+	if err != nil {
+		return auth.Principal{}, fmt.Errorf("error finding api key %s: %w", prefix, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashKey(key)), []byte(stored.SecretHash)) != 1 {
+		return auth.Principal{}, invalid
+	}
+	var now = srv.now()
+	if !usable(stored, now) {
+		return auth.Principal{}, invalid
+	}
+	if err = srv.repo.TouchKey(stored.Id, now, clientIp); err != nil {
+		// учёт использования не должен ломать вход клиента
+		log.Printf("apikey: error saving last use of key %s: %v", prefix, err)
+	}
+	return auth.Principal{ServiceAccountId: stored.ServiceAccountId, ApiKeyId: stored.Id, Scopes: stored.Scopes}, nil
+}
+
+func (srv *Service) findAccount(id int64) error {
+	_, err := srv.repo.FindAccount(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.NotFoundError{Message: fmt.Sprintf("service account %d not found", id)}
+	}
+	if err != nil {
+		return fmt.Errorf("error finding service account %d: %w", id, err)
+	}
+	return nil
+}
+
+// create генерирует ключ и сохраняет его префикс и хеш
+func (srv *Service) create(tx *sqlx.Tx, key KeyEntity) (KeyResponse, error) {
+	plain, prefix, err := generateKey()
+	if err != nil {
+		return KeyResponse{}, fmt.Errorf("error generating api key: %w", err)
+	}
+	key.Prefix = prefix
+	key.SecretHash = hashKey(plain)
+	if err = srv.repo.CreateKeyTx(tx, &key); err != nil {
+		return KeyResponse{}, fmt.Errorf("error saving api key of service account %d: %w", key.ServiceAccountId, err)
+	}
+	var resp = key.toResponse()
+	resp.Key = plain
+	return resp, nil
+}
+
+// usable ключ не отозван и не истёк
+func usable(key KeyEntity, now time.Time) bool {
+	return !key.RevokedAt.Valid && (!key.ExpiresAt.Valid || now.Before(key.ExpiresAt.Time))
+}
+
+// generateKey ключ вида idm_<префикс>_<секрет>: префикс из 6 случайных байт в hex, секрет из 32 байт в base64url
+func generateKey() (key string, prefix string, err error) {
+	var buf = make([]byte, 6+32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(buf[:6])
+	return KeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(buf[6:]), prefix, nil
+}
+
+// parseKey выделяет префикс; секрет может содержать "_", поэтому префикс берётся по длине
+func parseKey(key string) (string, bool) {
+	var rest, ok = strings.CutPrefix(key, KeyPrefix)
+	if !ok || len(rest) <= prefixLength+1 || rest[prefixLength] != '_' {
+		return "", false
+	}
+	return rest[:prefixLength], true
+}
+
+// hashKey SHA-256 ключа; у случайного ключа достаточно энтропии, медленный хеш не нужен
+func hashKey(key string) string {
+	var sum = sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
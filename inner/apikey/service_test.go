@@ -0,0 +1,317 @@
+package apikey
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindAccount(id int64) (AccountEntity, error) {
+	args := m.Called(id)
+	return args.Get(0).(AccountEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindAccounts() ([]AccountEntity, error) {
+	args := m.Called()
+	return args.Get(0).([]AccountEntity), args.Error(1)
+}
+
+func (m *MockRepo) DeleteAccount(id int64) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) FindKeys(accountId int64) ([]KeyEntity, error) {
+	args := m.Called(accountId)
+	return args.Get(0).([]KeyEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindKeyByPrefix(prefix string) (KeyEntity, error) {
+	args := m.Called(prefix)
+	return args.Get(0).(KeyEntity), args.Error(1)
+}
+
+func (m *MockRepo) TouchKey(id int64, at time.Time, ip string) error {
+	args := m.Called(id, at, ip)
+	return args.Error(0)
+}
+
+func (m *MockRepo) RevokeKey(accountId int64, id int64, at time.Time) (bool, error) {
+	args := m.Called(accountId, id, at)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) AccountExistsTx(tx *sqlx.Tx, name string) (bool, error) {
+	args := m.Called(tx, name)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) CreateAccountTx(tx *sqlx.Tx, e *AccountEntity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) FindKeyTx(tx *sqlx.Tx, id int64) (KeyEntity, error) {
+	args := m.Called(tx, id)
+	return args.Get(0).(KeyEntity), args.Error(1)
+}
+
+func (m *MockRepo) CreateKeyTx(tx *sqlx.Tx, e *KeyEntity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ExpireKeyTx(tx *sqlx.Tx, id int64, at time.Time) error {
+	args := m.Called(tx, id, at)
+	return args.Error(0)
+}
+
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+var now = time.Date(2025, 12, 22, 12, 0, 0, 0, time.UTC)
+
+const maxTTL = 90 * 24 * time.Hour
+
+func newService(repo Repo) *Service {
+	var svc = NewService(repo, validator.New(), maxTTL)
+	svc.now = func() time.Time { return now }
+	return svc
+}
+
+func TestCreateAccount(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should reject duplicate name", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, sqlMock := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("AccountExistsTx", tx, "hr-sync").Return(true, nil)
+
+		var _, err = svc.CreateAccount(AccountRequest{Name: "hr-sync"})
+		a.ErrorAs(err, &common.AlreadyExistsError{})
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should create account", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("AccountExistsTx", tx, "hr-sync").Return(false, nil)
+		repo.On("CreateAccountTx", tx, mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(1).(*AccountEntity).Id = 1
+		}).Return(nil)
+
+		var resp, err = svc.CreateAccount(AccountRequest{Name: "hr-sync", Description: "HR import"})
+		a.Nil(err)
+		a.Equal(int64(1), resp.Id)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+}
+
+func TestCreateKey(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should reject malformed scope", func(t *testing.T) {
+		var svc = newService(new(MockRepo))
+		var _, err = svc.CreateKey(KeyRequest{AccountId: 1, Name: "ci", Scopes: []string{"employees"}})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should reject expiry beyond max ttl", func(t *testing.T) {
+		var svc = newService(new(MockRepo))
+		var expiresAt = now.Add(maxTTL + time.Hour)
+		var _, err = svc.CreateKey(KeyRequest{AccountId: 1, Name: "ci", Scopes: []string{"employees:read"}, ExpiresAt: &expiresAt})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should return not found for unknown account", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		repo.On("FindAccount", int64(1)).Return(AccountEntity{}, sql.ErrNoRows)
+		var _, err = svc.CreateKey(KeyRequest{AccountId: 1, Name: "ci", Scopes: []string{"employees:read"}})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+
+	t.Run("should store only prefix and hash", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, sqlMock := newTx(a, true)
+		repo.On("FindAccount", int64(1)).Return(AccountEntity{Id: 1}, nil)
+		repo.On("BeginTransaction").Return(tx, nil)
+		var stored KeyEntity
+		repo.On("CreateKeyTx", tx, mock.Anything).Run(func(args mock.Arguments) {
+			stored = *args.Get(1).(*KeyEntity)
+		}).Return(nil)
+
+		var resp, err = svc.CreateKey(KeyRequest{AccountId: 1, Name: "ci", Scopes: []string{"employees:read", auth.ScopeAdmin}})
+		a.Nil(err)
+		a.Nil(sqlMock.ExpectationsWereMet())
+		a.Equal([]string{"employees:read", auth.ScopeAdmin}, []string(stored.Scopes))
+		a.True(strings.HasPrefix(resp.Key, KeyPrefix+stored.Prefix+"_"))
+		a.Equal(hashKey(resp.Key), stored.SecretHash)
+		a.NotContains(stored.SecretHash, resp.Key)
+		a.Equal(now.Add(maxTTL), stored.ExpiresAt.Time)
+		prefix, ok := parseKey(resp.Key)
+		a.True(ok)
+		a.Equal(stored.Prefix, prefix)
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	var a = assert.New(t)
+	key, prefix, err := generateKey()
+	a.Nil(err)
+	var stored = KeyEntity{
+		Id:               7,
+		ServiceAccountId: 1,
+		Prefix:           prefix,
+		SecretHash:       hashKey(key),
+		Scopes:           []string{"employees:read"},
+		ExpiresAt:        sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+	}
+
+	t.Run("should accept valid key and track its use", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		repo.On("FindKeyByPrefix", prefix).Return(stored, nil)
+		repo.On("TouchKey", int64(7), now, "10.0.0.1").Return(nil)
+
+		var principal, err = svc.Authenticate(key, "10.0.0.1")
+		a.Nil(err)
+		a.Equal(int64(1), principal.ServiceAccountId)
+		a.Equal(int64(7), principal.ApiKeyId)
+		a.Equal([]string{"employees:read"}, principal.Scopes)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("should reject wrong secret with known prefix", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		repo.On("FindKeyByPrefix", prefix).Return(stored, nil)
+		var _, err = svc.Authenticate(KeyPrefix+prefix+"_"+strings.Repeat("A", 43), "10.0.0.1")
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		repo.AssertNotCalled(t, "TouchKey", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("should reject revoked and expired keys", func(t *testing.T) {
+		var revoked = stored
+		revoked.RevokedAt = sql.NullTime{Time: now, Valid: true}
+		var expired = stored
+		expired.ExpiresAt = sql.NullTime{Time: now, Valid: true}
+		for _, entity := range []KeyEntity{revoked, expired} {
+			var repo = new(MockRepo)
+			var svc = newService(repo)
+			repo.On("FindKeyByPrefix", prefix).Return(entity, nil)
+			var _, err = svc.Authenticate(key, "10.0.0.1")
+			a.ErrorAs(err, &common.UnauthorizedError{})
+		}
+	})
+
+	t.Run("should reject malformed key without lookup", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		var _, err = svc.Authenticate("not-a-key", "10.0.0.1")
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		repo.AssertNotCalled(t, "FindKeyByPrefix", mock.Anything)
+	})
+}
+
+func TestRotateKey(t *testing.T) {
+	var a = assert.New(t)
+	var old = KeyEntity{
+		Id:               7,
+		ServiceAccountId: 1,
+		Name:             "ci",
+		Scopes:           []string{"employees:write"},
+		CreatedAt:        now.Add(-24 * time.Hour),
+		ExpiresAt:        sql.NullTime{Time: now.Add(6 * 24 * time.Hour), Valid: true},
+	}
+
+	t.Run("should issue new key and keep old one for overlap", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindKeyTx", tx, int64(7)).Return(old, nil)
+		var created KeyEntity
+		repo.On("CreateKeyTx", tx, mock.Anything).Run(func(args mock.Arguments) {
+			created = *args.Get(1).(*KeyEntity)
+		}).Return(nil)
+		repo.On("ExpireKeyTx", tx, int64(7), now.Add(time.Hour)).Return(nil)
+
+		var resp, err = svc.RotateKey(RotateRequest{AccountId: 1, KeyId: 7, OverlapSeconds: 3600})
+		a.Nil(err)
+		a.Nil(sqlMock.ExpectationsWereMet())
+		a.NotEmpty(resp.Key)
+		a.Equal("ci", created.Name)
+		a.Equal([]string{"employees:write"}, []string(created.Scopes))
+		// новый ключ живёт столько же, сколько старый: 7 дней
+		a.Equal(now.Add(7*24*time.Hour), created.ExpiresAt.Time)
+	})
+
+	t.Run("should not rotate key of another account", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindKeyTx", tx, int64(7)).Return(old, nil)
+		var _, err = svc.RotateKey(RotateRequest{AccountId: 2, KeyId: 7})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+
+	t.Run("should not rotate revoked key", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, _ := newTx(a, false)
+		var revoked = old
+		revoked.RevokedAt = sql.NullTime{Time: now, Valid: true}
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindKeyTx", tx, int64(7)).Return(revoked, nil)
+		var _, err = svc.RotateKey(RotateRequest{AccountId: 1, KeyId: 7})
+		a.ErrorAs(err, &common.ConflictError{})
+		repo.AssertNotCalled(t, "CreateKeyTx", mock.Anything, mock.Anything)
+	})
+}
+
+func TestRevokeKey(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = newService(repo)
+	repo.On("RevokeKey", int64(1), int64(7), now).Return(true, nil)
+	repo.On("RevokeKey", int64(1), int64(8), now).Return(false, nil)
+
+	a.Nil(svc.RevokeKey(1, 7))
+	a.ErrorAs(svc.RevokeKey(1, 8), &common.NotFoundError{})
+}
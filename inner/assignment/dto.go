@@ -0,0 +1,121 @@
+package assignment
+
+import (
+	"database/sql"
+	"github.com/zhedevops/idm/inner/audit"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"strconv"
+	"time"
+)
+
+// События срока действия назначений, которые сохраняются в журнале аудита
+const (
+	AuditActivated     = "activated"
+	AuditExpired       = "expired"
+	AuditExpiryWarning = "expiry_warning"
+)
+
+// Entity назначение роли сотруднику
+type Entity struct {
+	Id         int64     `db:"id"`
+	EmployeeId int64     `db:"employee_id"`
+	RoleId     int64     `db:"role_id"`
+	CreatedAt  time.Time `db:"created_at"`
+	// роль действует с ValidFrom по ValidUntil; пустой ValidUntil — бессрочно
+	ValidFrom  time.Time    `db:"valid_from"`
+	ValidUntil sql.NullTime `db:"valid_until"`
+	// Activated начало действия обработано и роль передана во внешние системы
+	Activated bool         `db:"activated"`
+	WarnedAt  sql.NullTime `db:"warned_at"`
+	// RuleId правило, выдавшее роль; пусто у назначений, сделанных вручную
+	RuleId sql.NullInt64 `db:"rule_id"`
+}
+
+type Response struct {
+	Id         int64      `json:"id"`
+	EmployeeId int64      `json:"employee_id"`
+	RoleId     int64      `json:"role_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ValidFrom  time.Time  `json:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	RuleId     int64      `json:"rule_id,omitempty"`
+}
+
+// AuditEntity событие журнала аудита о наступлении или окончании срока действия назначения
+type AuditEntity struct {
+	EmployeeId int64
+	RoleId     int64
+	Event      string
+	ValidFrom  time.Time
+	ValidUntil sql.NullTime
+}
+
+// auditData подробности события назначения в журнале аудита
+type auditData struct {
+	RoleId     int64      `json:"role_id"`
+	ValidFrom  time.Time  `json:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+// ExpiryWarning предупреждение о том, что назначение скоро истечёт
+type ExpiryWarning struct {
+	EmployeeId   int64
+	EmployeeName string
+	RoleId       int64
+	RoleName     string
+	ValidUntil   time.Time
+}
+
+func (e *Entity) toResponse() Response {
+	var resp = Response{
+		Id:         e.Id,
+		EmployeeId: e.EmployeeId,
+		RoleId:     e.RoleId,
+		CreatedAt:  e.CreatedAt,
+		ValidFrom:  e.ValidFrom,
+		RuleId:     e.RuleId.Int64,
+	}
+	if e.ValidUntil.Valid {
+		resp.ValidUntil = &e.ValidUntil.Time
+	}
+	return resp
+}
+
+func (e *Entity) toAudit(event string) AuditEntity {
+	return AuditEntity{
+		EmployeeId: e.EmployeeId,
+		RoleId:     e.RoleId,
+		Event:      event,
+		ValidFrom:  e.ValidFrom,
+		ValidUntil: e.ValidUntil,
+	}
+}
+
+func (a *AuditEntity) toEvent() audit.Event {
+	var data = auditData{RoleId: a.RoleId, ValidFrom: a.ValidFrom}
+	if a.ValidUntil.Valid {
+		data.ValidUntil = &a.ValidUntil.Time
+	}
+	return audit.Event{
+		Category: audit.CategoryAssignment,
+		Action:   a.Event,
+		Subject:  "employee:" + strconv.FormatInt(a.EmployeeId, 10),
+		Data:     data,
+	}
+}
+
+// GrantEntity назначение роли вместе с именами сотрудника и роли, нужными внешним системам
+type GrantEntity struct {
+	EmployeeId   int64  `db:"employee_id"`
+	EmployeeName string `db:"employee_name"`
+	RoleId       int64  `db:"role_id"`
+	RoleName     string `db:"role_name"`
+}
+
+func (g *GrantEntity) toEvent(eventType provisioning.EventType) provisioning.Event {
+	return provisioning.Event{
+		Type:        eventType,
+		Account:     provisioning.Account{EmployeeId: g.EmployeeId, Name: g.EmployeeName},
+		Entitlement: provisioning.Entitlement{RoleId: g.RoleId, Name: g.RoleName},
+	}
+}
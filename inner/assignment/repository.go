@@ -0,0 +1,144 @@
+package assignment
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/audit"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+func (r *Repository) FindAll() (assignments []Entity, err error) {
+	query := "SELECT * FROM employee_role ORDER BY employee_id, role_id"
+	err = r.db.Select(&assignments, query)
+	if err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+func (r *Repository) FindByEmployeeId(employeeId int64) (assignments []Entity, err error) {
+	query := "SELECT * FROM effective_employee_role WHERE employee_id = $1 ORDER BY role_id"
+	err = r.db.Select(&assignments, query, employeeId)
+	if err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+func (r *Repository) FindByRoleId(roleId int64) (assignments []Entity, err error) {
+	query := "SELECT * FROM effective_employee_role WHERE role_id = $1 ORDER BY employee_id"
+	err = r.db.Select(&assignments, query, roleId)
+	if err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) FindByRoleIdTx(tx *sqlx.Tx, roleId int64) (assignments []Entity, err error) {
+	query := "SELECT * FROM employee_role WHERE role_id = $1 ORDER BY employee_id FOR UPDATE"
+	err = tx.Select(&assignments, query, roleId)
+	if err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// CreateTx назначает роль сотруднику бессрочно и сразу. Повторное назначение той же роли снимает прежний срок
+// и переносит ещё не наступившее начало на текущий момент, а назначение, выданное правилом, становится ручным
+// и при пересчёте правил уже не снимается
+func (r *Repository) CreateTx(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	query := `INSERT INTO employee_role (employee_id, role_id) VALUES ($1, $2)
+              ON CONFLICT (employee_id, role_id) DO UPDATE SET rule_id = NULL,
+                  valid_from = LEAST(employee_role.valid_from, EXCLUDED.valid_from),
+                  valid_until = NULL, activated = TRUE, warned_at = NULL`
+	_, err := tx.Exec(query, employeeId, roleId)
+	return err
+}
+
+func (r *Repository) DeleteTx(tx *sqlx.Tx, employeeId int64, roleId int64) (int64, error) {
+	res, err := tx.Exec("DELETE FROM employee_role WHERE employee_id = $1 AND role_id = $2", employeeId, roleId)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+// FindTx возвращает назначение и блокирует его до конца транзакции; sql.ErrNoRows, если его нет
+func (r *Repository) FindTx(tx *sqlx.Tx, employeeId int64, roleId int64) (assignment Entity, err error) {
+	query := "SELECT * FROM employee_role WHERE employee_id = $1 AND role_id = $2 FOR UPDATE"
+	err = tx.Get(&assignment, query, employeeId, roleId)
+	return
+}
+
+// FindGrantTx возвращает имена сотрудника и роли для события провижининга
+func (r *Repository) FindGrantTx(tx *sqlx.Tx, employeeId int64, roleId int64) (grant GrantEntity, err error) {
+	query := `SELECT e.id AS employee_id, e.name AS employee_name, r.id AS role_id, r.name AS role_name
+              FROM employee e, role r WHERE e.id = $1 AND r.id = $2`
+	err = tx.Get(&grant, query, employeeId, roleId)
+	return
+}
+
+// UpsertTx назначает роль на срок из e. Если назначение уже есть, его срок заменяется, предупреждение отправляется заново,
+// а назначение становится ручным
+func (r *Repository) UpsertTx(tx *sqlx.Tx, e Entity) error {
+	query := `INSERT INTO employee_role (employee_id, role_id, valid_from, valid_until, activated)
+              VALUES (:employee_id, :role_id, :valid_from, :valid_until, :activated)
+              ON CONFLICT (employee_id, role_id) DO UPDATE SET valid_from = EXCLUDED.valid_from,
+                  valid_until = EXCLUDED.valid_until, activated = EXCLUDED.activated, warned_at = NULL, rule_id = NULL`
+	_, err := tx.NamedExec(query, e)
+	return err
+}
+
+// ExpireTx удаляет назначения, срок которых закончился к моменту now, и возвращает их
+func (r *Repository) ExpireTx(tx *sqlx.Tx, now time.Time) (expired []Entity, err error) {
+	query := "DELETE FROM employee_role WHERE valid_until <= $1 RETURNING *"
+	err = tx.Select(&expired, query, now)
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// ActivateTx отмечает назначения, срок которых наступил к моменту now, и возвращает их
+func (r *Repository) ActivateTx(tx *sqlx.Tx, now time.Time) (activated []Entity, err error) {
+	query := `UPDATE employee_role SET activated = TRUE
+              WHERE NOT activated AND valid_from <= $1 AND (valid_until IS NULL OR valid_until > $1)
+              RETURNING *`
+	err = tx.Select(&activated, query, now)
+	if err != nil {
+		return nil, err
+	}
+	return activated, nil
+}
+
+// MarkExpiringTx отмечает действующие назначения, которые истекут до until и о которых ещё не предупреждали
+func (r *Repository) MarkExpiringTx(tx *sqlx.Tx, now time.Time, until time.Time) (expiring []Entity, err error) {
+	query := `UPDATE employee_role SET warned_at = $1
+              WHERE warned_at IS NULL AND activated AND valid_until > $1 AND valid_until <= $2
+              RETURNING *`
+	err = tx.Select(&expiring, query, now, until)
+	if err != nil {
+		return nil, err
+	}
+	return expiring, nil
+}
+
+// CreateAuditTx дописывает событие назначения в журнал аудита
+func (r *Repository) CreateAuditTx(tx *sqlx.Tx, a AuditEntity) error {
+	return audit.AppendTx(tx, a.toEvent())
+}
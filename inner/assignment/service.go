@@ -0,0 +1,436 @@
+package assignment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"log"
+	"time"
+)
+
+// Структура сервиса, которая будет инкапсулировать бизнес-логику назначения ролей
+type Service struct {
+	repo        Repo
+	validator   Validator
+	provisioner Provisioner
+	guard       Guard
+	// now подменяется в тестах
+	now func() time.Time
+}
+
+// AssignRequest назначение роли. Без ValidFrom роль действует сразу, без ValidUntil — бессрочно
+type AssignRequest struct {
+	EmployeeId int64      `json:"employee_id" validate:"required,gt=0"`
+	RoleId     int64      `json:"role_id" validate:"required,gt=0"`
+	ValidFrom  *time.Time `json:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until"`
+}
+
+// ExpiryOptions настройки фонового истечения назначений
+type ExpiryOptions struct {
+	// Interval как часто проверяются сроки назначений
+	Interval time.Duration
+	// WarnBefore за сколько до окончания срока предупреждать; 0 или пустой Notifier — не предупреждать
+	WarnBefore time.Duration
+	Notifier   Notifier
+}
+
+// ExpiryResult сколько назначений обработано за один проход
+type ExpiryResult struct {
+	Activated int
+	Expired   int
+	Warned    int
+}
+
+type SetRoleMembersRequest struct {
+	RoleId      int64   `validate:"required,gt=0"`
+	EmployeeIds []int64 `validate:"dive,gt=0"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+type Repo interface {
+	FindAll() ([]Entity, error)
+	FindByEmployeeId(int64) ([]Entity, error)
+	FindByRoleId(int64) ([]Entity, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	FindByRoleIdTx(*sqlx.Tx, int64) ([]Entity, error)
+	CreateTx(*sqlx.Tx, int64, int64) error
+	DeleteTx(*sqlx.Tx, int64, int64) (int64, error)
+	FindTx(*sqlx.Tx, int64, int64) (Entity, error)
+	FindGrantTx(*sqlx.Tx, int64, int64) (GrantEntity, error)
+	UpsertTx(*sqlx.Tx, Entity) error
+	ExpireTx(*sqlx.Tx, time.Time) ([]Entity, error)
+	ActivateTx(*sqlx.Tx, time.Time) ([]Entity, error)
+	MarkExpiringTx(*sqlx.Tx, time.Time, time.Time) ([]Entity, error)
+	CreateAuditTx(*sqlx.Tx, AuditEntity) error
+}
+
+// Guard проверяет в транзакции назначения, что роль можно выдать сотруднику, например sod.Service
+type Guard interface {
+	CheckTx(tx *sqlx.Tx, employeeId int64, roleId int64) error
+}
+
+// Notifier сообщает сотруднику и владельцу роли, что назначение скоро истечёт
+type Notifier interface {
+	NotifyExpiring(warning ExpiryWarning) error
+}
+
+// LogNotifier пишет предупреждения об истечении назначений в лог
+type LogNotifier struct{}
+
+func (LogNotifier) NotifyExpiring(w ExpiryWarning) error {
+	log.Printf("assignment: role %q of employee %q expires at %s", w.RoleName, w.EmployeeName, w.ValidUntil.Format(time.RFC3339))
+	return nil
+}
+
+// Provisioner асинхронно передаёт изменения назначений во внешние системы
+type Provisioner interface {
+	Publish(event provisioning.Event)
+}
+
+// NewService создаёт сервис назначений. Если provisioner равен nil, изменения во внешние системы не передаются,
+// если guard равен nil, назначения не проверяются
+func NewService(repo Repo, validator Validator, provisioner Provisioner, guard Guard) *Service {
+	return &Service{
+		repo:        repo,
+		validator:   validator,
+		provisioner: provisioner,
+		guard:       guard,
+		now:         time.Now,
+	}
+}
+
+func (srv *Service) FindAll() ([]Response, error) {
+	var entities, err = srv.repo.FindAll()
+	if err != nil {
+		return []Response{}, fmt.Errorf("error get all role assignments: %w", err)
+	}
+
+	var resp = []Response{}
+	for _, e := range entities {
+		resp = append(resp, e.toResponse())
+	}
+	return resp, nil
+}
+
+func (srv *Service) FindByEmployeeId(employeeId int64) ([]Response, error) {
+	var entities, err = srv.repo.FindByEmployeeId(employeeId)
+	if err != nil {
+		return []Response{}, fmt.Errorf("error get roles of employee with id %d: %w", employeeId, err)
+	}
+
+	var resp = []Response{}
+	for _, e := range entities {
+		resp = append(resp, e.toResponse())
+	}
+	return resp, nil
+}
+
+func (srv *Service) FindByRoleId(roleId int64) ([]Response, error) {
+	var entities, err = srv.repo.FindByRoleId(roleId)
+	if err != nil {
+		return []Response{}, fmt.Errorf("error get members of role with id %d: %w", roleId, err)
+	}
+
+	var resp = []Response{}
+	for _, e := range entities {
+		resp = append(resp, e.toResponse())
+	}
+	return resp, nil
+}
+
+// Assign назначает роль сотруднику. Если действующее назначение переносится на будущее,
+// роль отзывается во внешних системах и будет передана снова, когда наступит новый срок
+func (srv *Service) Assign(request AssignRequest) error {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return common.RequestValidationError{Message: err.Error()}
+	}
+
+	var now = srv.now()
+	var entity = Entity{EmployeeId: request.EmployeeId, RoleId: request.RoleId, ValidFrom: now, Activated: true}
+	if request.ValidFrom != nil {
+		entity.ValidFrom = *request.ValidFrom
+		// роль, срок которой ещё не наступил, передаётся во внешние системы планировщиком
+		entity.Activated = !entity.ValidFrom.After(now)
+	}
+	if request.ValidUntil != nil {
+		if !request.ValidUntil.After(entity.ValidFrom) || !request.ValidUntil.After(now) {
+			return common.RequestValidationError{Message: "valid_until must be later than valid_from and the current time"}
+		}
+		entity.ValidUntil = sql.NullTime{Time: *request.ValidUntil, Valid: true}
+	}
+
+	var events []provisioning.Event
+	err = database.InTransaction(srv.repo.BeginTransaction, "assigning role", func(tx *sqlx.Tx) error {
+		var err = srv.check(tx, request.EmployeeId, request.RoleId)
+		if err != nil {
+			return err
+		}
+		var wasActive bool
+		if !entity.Activated {
+			existing, err := srv.repo.FindTx(tx, request.EmployeeId, request.RoleId)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("error finding role %d of employee %d: %w", request.RoleId, request.EmployeeId, err)
+			}
+			wasActive = err == nil && existing.Activated
+		}
+		if request.ValidFrom == nil && request.ValidUntil == nil {
+			err = srv.repo.CreateTx(tx, request.EmployeeId, request.RoleId)
+		} else {
+			err = srv.repo.UpsertTx(tx, entity)
+		}
+		if err != nil {
+			return fmt.Errorf("error assign role %d to employee %d: %w", request.RoleId, request.EmployeeId, err)
+		}
+		if !entity.Activated {
+			if !wasActive {
+				return nil
+			}
+			return srv.collect(tx, &events, provisioning.EntitlementRemoved, request.EmployeeId, request.RoleId)
+		}
+		return srv.collect(tx, &events, provisioning.EntitlementAdded, request.EmployeeId, request.RoleId)
+	})
+	if err != nil {
+		return err
+	}
+
+	srv.Publish(events)
+	return nil
+}
+
+// Unassign снимает роль с сотрудника и возвращает количество удалённых назначений
+func (srv *Service) Unassign(request AssignRequest) (count int64, err error) {
+	err = srv.validator.Validate(request)
+	if err != nil {
+		return 0, common.RequestValidationError{Message: err.Error()}
+	}
+
+	var events []provisioning.Event
+	err = database.InTransaction(srv.repo.BeginTransaction, "unassigning role", func(tx *sqlx.Tx) error {
+		count, err = srv.repo.DeleteTx(tx, request.EmployeeId, request.RoleId)
+		if err != nil {
+			return fmt.Errorf("error unassign role %d from employee %d: %w", request.RoleId, request.EmployeeId, err)
+		}
+		if count == 0 {
+			return nil
+		}
+		return srv.collect(tx, &events, provisioning.EntitlementRemoved, request.EmployeeId, request.RoleId)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	srv.Publish(events)
+	return count, nil
+}
+
+// SetRoleMembers приводит список сотрудников с ролью к переданному:
+// недостающие назначения создаются, лишние удаляются
+func (srv *Service) SetRoleMembers(request SetRoleMembersRequest) error {
+	var events []provisioning.Event
+	var err = database.InTransaction(srv.repo.BeginTransaction, "setting role members", func(tx *sqlx.Tx) (err error) {
+		events, err = srv.SetRoleMembersTx(tx, request)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	srv.Publish(events)
+	return nil
+}
+
+// SetRoleMembersTx делает то же, что SetRoleMembers, в транзакции вызывающего.
+// События для внешних систем возвращаются, их публикует Publish после коммита
+func (srv *Service) SetRoleMembersTx(tx *sqlx.Tx, request SetRoleMembersRequest) ([]provisioning.Event, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return nil, common.RequestValidationError{Message: err.Error()}
+	}
+
+	current, err := srv.repo.FindByRoleIdTx(tx, request.RoleId)
+	if err != nil {
+		return nil, fmt.Errorf("error get members of role with id %d: %w", request.RoleId, err)
+	}
+
+	var events []provisioning.Event
+	var wanted = make(map[int64]bool, len(request.EmployeeIds))
+	for _, id := range request.EmployeeIds {
+		wanted[id] = true
+	}
+	for _, e := range current {
+		if wanted[e.EmployeeId] {
+			// назначение уже есть, создавать его не нужно
+			delete(wanted, e.EmployeeId)
+			continue
+		}
+		if _, err := srv.repo.DeleteTx(tx, e.EmployeeId, request.RoleId); err != nil {
+			return nil, fmt.Errorf("error unassign role %d from employee %d: %w", request.RoleId, e.EmployeeId, err)
+		}
+		if err := srv.collect(tx, &events, provisioning.EntitlementRemoved, e.EmployeeId, request.RoleId); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range request.EmployeeIds {
+		if !wanted[id] {
+			continue
+		}
+		delete(wanted, id)
+		if err := srv.check(tx, id, request.RoleId); err != nil {
+			return nil, err
+		}
+		if err := srv.repo.CreateTx(tx, id, request.RoleId); err != nil {
+			return nil, fmt.Errorf("error assign role %d to employee %d: %w", request.RoleId, id, err)
+		}
+		if err := srv.collect(tx, &events, provisioning.EntitlementAdded, id, request.RoleId); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// Schedule запускает ExpireDue каждые options.Interval, пока не отменён ctx
+func (srv *Service) Schedule(ctx context.Context, options ExpiryOptions) {
+	if options.Interval <= 0 {
+		return
+	}
+	go func() {
+		var ticker = time.NewTicker(options.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				result, err := srv.ExpireDue(options)
+				if err != nil {
+					log.Printf("assignment: expiry failed: %v", err)
+					continue
+				}
+				if result != (ExpiryResult{}) {
+					log.Printf("assignment: %d activated, %d expired, %d expiry warnings",
+						result.Activated, result.Expired, result.Warned)
+				}
+			}
+		}
+	}()
+}
+
+// ExpireDue за один проход удаляет истёкшие назначения, включает назначения, срок которых наступил,
+// и отмечает скоро истекающие. Каждое изменение записывается в журнал в той же транзакции,
+// события провижининга и предупреждения отправляются после коммита
+func (srv *Service) ExpireDue(options ExpiryOptions) (ExpiryResult, error) {
+	var now = srv.now()
+	var result ExpiryResult
+	var events []provisioning.Event
+	var warnings []ExpiryWarning
+	err := database.InTransaction(srv.repo.BeginTransaction, "expiring role assignments", func(tx *sqlx.Tx) error {
+		expired, err := srv.repo.ExpireTx(tx, now)
+		if err != nil {
+			return fmt.Errorf("error expire role assignments: %w", err)
+		}
+		for _, e := range expired {
+			if err := srv.repo.CreateAuditTx(tx, e.toAudit(AuditExpired)); err != nil {
+				return fmt.Errorf("error saving audit of role %d of employee %d: %w", e.RoleId, e.EmployeeId, err)
+			}
+			// роль, срок которой так и не наступил, во внешние системы не передавалась
+			if !e.Activated {
+				continue
+			}
+			if err := srv.collect(tx, &events, provisioning.EntitlementRemoved, e.EmployeeId, e.RoleId); err != nil {
+				return err
+			}
+		}
+		result.Expired = len(expired)
+
+		activated, err := srv.repo.ActivateTx(tx, now)
+		if err != nil {
+			return fmt.Errorf("error activate role assignments: %w", err)
+		}
+		for _, e := range activated {
+			if err := srv.repo.CreateAuditTx(tx, e.toAudit(AuditActivated)); err != nil {
+				return fmt.Errorf("error saving audit of role %d of employee %d: %w", e.RoleId, e.EmployeeId, err)
+			}
+			if err := srv.collect(tx, &events, provisioning.EntitlementAdded, e.EmployeeId, e.RoleId); err != nil {
+				return err
+			}
+		}
+		result.Activated = len(activated)
+
+		if options.WarnBefore <= 0 || options.Notifier == nil {
+			return nil
+		}
+		expiring, err := srv.repo.MarkExpiringTx(tx, now, now.Add(options.WarnBefore))
+		if err != nil {
+			return fmt.Errorf("error find expiring role assignments: %w", err)
+		}
+		for _, e := range expiring {
+			if err := srv.repo.CreateAuditTx(tx, e.toAudit(AuditExpiryWarning)); err != nil {
+				return fmt.Errorf("error saving audit of role %d of employee %d: %w", e.RoleId, e.EmployeeId, err)
+			}
+			grant, err := srv.repo.FindGrantTx(tx, e.EmployeeId, e.RoleId)
+			if err != nil {
+				return fmt.Errorf("error get names of employee %d and role %d: %w", e.EmployeeId, e.RoleId, err)
+			}
+			warnings = append(warnings, ExpiryWarning{
+				EmployeeId:   e.EmployeeId,
+				EmployeeName: grant.EmployeeName,
+				RoleId:       e.RoleId,
+				RoleName:     grant.RoleName,
+				ValidUntil:   e.ValidUntil.Time,
+			})
+		}
+		result.Warned = len(expiring)
+		return nil
+	})
+	if err != nil {
+		return ExpiryResult{}, err
+	}
+
+	srv.Publish(events)
+	for _, w := range warnings {
+		// повторно предупреждение не отправляется: warned_at уже сохранён
+		if err := options.Notifier.NotifyExpiring(w); err != nil {
+			log.Printf("assignment: expiry warning for role %d of employee %d failed: %v", w.RoleId, w.EmployeeId, err)
+		}
+	}
+	return result, nil
+}
+
+// check проверяет назначение через guard, если он задан
+func (srv *Service) check(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	if srv.guard == nil {
+		return nil
+	}
+	return srv.guard.CheckTx(tx, employeeId, roleId)
+}
+
+// collect добавляет в events событие по назначению. Имена читаются в той же транзакции,
+// а сами события публикуются только после коммита. Если провижининг выключен, ничего не делает
+func (srv *Service) collect(tx *sqlx.Tx, events *[]provisioning.Event, eventType provisioning.EventType, employeeId int64, roleId int64) error {
+	if srv.provisioner == nil {
+		return nil
+	}
+	var grant, err = srv.repo.FindGrantTx(tx, employeeId, roleId)
+	if err != nil {
+		return fmt.Errorf("error get names of employee %d and role %d: %w", employeeId, roleId, err)
+	}
+	*events = append(*events, grant.toEvent(eventType))
+	return nil
+}
+
+// Publish передаёт события во внешние системы
+func (srv *Service) Publish(events []provisioning.Event) {
+	for _, e := range events {
+		srv.provisioner.Publish(e)
+	}
+}
@@ -0,0 +1,429 @@
+package assignment
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindAll() ([]Entity, error) {
+	args := m.Called()
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindByEmployeeId(employeeId int64) ([]Entity, error) {
+	args := m.Called(employeeId)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindByRoleId(roleId int64) ([]Entity, error) {
+	args := m.Called(roleId)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) FindByRoleIdTx(tx *sqlx.Tx, roleId int64) ([]Entity, error) {
+	args := m.Called(tx, roleId)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) CreateTx(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Error(0)
+}
+
+func (m *MockRepo) DeleteTx(tx *sqlx.Tx, employeeId int64, roleId int64) (int64, error) {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindGrantTx(tx *sqlx.Tx, employeeId int64, roleId int64) (GrantEntity, error) {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Get(0).(GrantEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindTx(tx *sqlx.Tx, employeeId int64, roleId int64) (Entity, error) {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) UpsertTx(tx *sqlx.Tx, e Entity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ExpireTx(tx *sqlx.Tx, now time.Time) ([]Entity, error) {
+	args := m.Called(tx, now)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) ActivateTx(tx *sqlx.Tx, now time.Time) ([]Entity, error) {
+	args := m.Called(tx, now)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) MarkExpiringTx(tx *sqlx.Tx, now time.Time, until time.Time) ([]Entity, error) {
+	args := m.Called(tx, now, until)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) CreateAuditTx(tx *sqlx.Tx, audit AuditEntity) error {
+	args := m.Called(tx, audit)
+	return args.Error(0)
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+func TestAssign(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+
+	t.Run("should assign role in transaction", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, mock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("CreateTx", tx, int64(1), int64(2)).Return(nil)
+		var err = svc.Assign(AssignRequest{EmployeeId: 1, RoleId: 2})
+		a.Nil(err)
+		a.Nil(mock.ExpectationsWereMet())
+	})
+
+	t.Run("should rollback on error", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, mock := newTx(a, false)
+		var dbErr = errors.New("database error")
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("CreateTx", tx, int64(1), int64(2)).Return(dbErr)
+		var err = svc.Assign(AssignRequest{EmployeeId: 1, RoleId: 2})
+		a.ErrorIs(err, dbErr)
+		a.Nil(mock.ExpectationsWereMet())
+	})
+
+	t.Run("should return validation error", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var err = svc.Assign(AssignRequest{EmployeeId: 1})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		a.True(repo.AssertNumberOfCalls(t, "BeginTransaction", 0))
+	})
+}
+
+func TestSetRoleMembers(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+
+	t.Run("should add missing and remove extra members", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, mock := newTx(a, true)
+		var current = []Entity{
+			{Id: 1, EmployeeId: 1, RoleId: 5},
+			{Id: 2, EmployeeId: 2, RoleId: 5},
+		}
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByRoleIdTx", tx, int64(5)).Return(current, nil)
+		repo.On("DeleteTx", tx, int64(1), int64(5)).Return(int64(1), nil)
+		repo.On("CreateTx", tx, int64(3), int64(5)).Return(nil)
+		var err = svc.SetRoleMembers(SetRoleMembersRequest{RoleId: 5, EmployeeIds: []int64{2, 3, 3}})
+		a.Nil(err)
+		a.Nil(mock.ExpectationsWereMet())
+		repo.AssertNumberOfCalls(t, "DeleteTx", 1)
+		repo.AssertNumberOfCalls(t, "CreateTx", 1)
+	})
+
+	t.Run("should remove all members", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		tx, _ := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByRoleIdTx", tx, int64(5)).Return([]Entity{{Id: 1, EmployeeId: 1, RoleId: 5}}, nil)
+		repo.On("DeleteTx", tx, int64(1), int64(5)).Return(int64(1), nil)
+		var err = svc.SetRoleMembers(SetRoleMembersRequest{RoleId: 5})
+		a.Nil(err)
+		repo.AssertNumberOfCalls(t, "CreateTx", 0)
+	})
+}
+
+func TestFindByEmployeeId(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = NewService(repo, validator.New(), nil, nil)
+	var entity = Entity{Id: 1, EmployeeId: 1, RoleId: 2}
+	repo.On("FindByEmployeeId", int64(1)).Return([]Entity{entity}, nil)
+	var got, err = svc.FindByEmployeeId(1)
+	a.Nil(err)
+	a.Equal([]Response{entity.toResponse()}, got)
+}
+
+// StubProvisioner запоминает опубликованные события
+type StubProvisioner struct {
+	events []provisioning.Event
+}
+
+func (p *StubProvisioner) Publish(event provisioning.Event) {
+	p.events = append(p.events, event)
+}
+
+func TestProvisioningEvents(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+	var grant = func(employeeId int64, name string) *GrantEntity {
+		return &GrantEntity{EmployeeId: employeeId, EmployeeName: name, RoleId: 1, RoleName: "Admin"}
+	}
+
+	t.Run("should publish changes of role members after commit", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		var tx, mock = newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByRoleIdTx", tx, int64(1)).Return([]Entity{{EmployeeId: 10, RoleId: 1}}, nil)
+		repo.On("DeleteTx", tx, int64(10), int64(1)).Return(int64(1), nil)
+		repo.On("CreateTx", tx, int64(11), int64(1)).Return(nil)
+		repo.On("FindGrantTx", tx, int64(10), int64(1)).Return(*grant(10, "John Doe"), nil)
+		repo.On("FindGrantTx", tx, int64(11), int64(1)).Return(*grant(11, "Jane Doe"), nil)
+		var err = svc.SetRoleMembers(SetRoleMembersRequest{RoleId: 1, EmployeeIds: []int64{11}})
+		a.Nil(err)
+		a.Nil(mock.ExpectationsWereMet())
+		a.Equal([]provisioning.Event{
+			grant(10, "John Doe").toEvent(provisioning.EntitlementRemoved),
+			grant(11, "Jane Doe").toEvent(provisioning.EntitlementAdded),
+		}, provisioner.events)
+	})
+
+	t.Run("should not publish when transaction is rolled back", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		var tx, _ = newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("CreateTx", tx, int64(10), int64(1)).Return(nil)
+		repo.On("FindGrantTx", tx, int64(10), int64(1)).Return(GrantEntity{}, errors.New("connection lost"))
+		var err = svc.Assign(AssignRequest{EmployeeId: 10, RoleId: 1})
+		a.NotNil(err)
+		a.Empty(provisioner.events)
+	})
+
+	t.Run("should not publish when nothing was unassigned", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		var tx, _ = newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("DeleteTx", tx, int64(10), int64(1)).Return(int64(0), nil)
+		var count, err = svc.Unassign(AssignRequest{EmployeeId: 10, RoleId: 1})
+		a.Nil(err)
+		a.Equal(int64(0), count)
+		a.Empty(provisioner.events)
+	})
+}
+
+// StubNotifier запоминает отправленные предупреждения
+type StubNotifier struct {
+	warnings []ExpiryWarning
+}
+
+func (n *StubNotifier) NotifyExpiring(warning ExpiryWarning) error {
+	n.warnings = append(n.warnings, warning)
+	return nil
+}
+
+func TestTimeBoundAssign(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+	var now = time.Date(2025, 11, 26, 12, 0, 0, 0, time.UTC)
+	var tomorrow = now.Add(24 * time.Hour)
+	var nextMonth = now.AddDate(0, 1, 0)
+
+	t.Run("should defer provisioning until valid_from", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		svc.now = func() time.Time { return now }
+		var tx, sqlMock = newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTx", tx, int64(10), int64(1)).Return(Entity{}, sql.ErrNoRows)
+		repo.On("UpsertTx", tx, Entity{EmployeeId: 10, RoleId: 1, ValidFrom: tomorrow,
+			ValidUntil: sql.NullTime{Time: nextMonth, Valid: true}}).Return(nil)
+		var err = svc.Assign(AssignRequest{EmployeeId: 10, RoleId: 1, ValidFrom: &tomorrow, ValidUntil: &nextMonth})
+		a.Nil(err)
+		a.Empty(provisioner.events)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should revoke active role moved into the future", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		svc.now = func() time.Time { return now }
+		var tx, sqlMock = newTx(a, true)
+		var grant = GrantEntity{EmployeeId: 10, EmployeeName: "John Doe", RoleId: 1, RoleName: "Admin"}
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTx", tx, int64(10), int64(1)).Return(Entity{EmployeeId: 10, RoleId: 1, ValidFrom: now.AddDate(0, -1, 0), Activated: true}, nil)
+		repo.On("UpsertTx", tx, Entity{EmployeeId: 10, RoleId: 1, ValidFrom: tomorrow}).Return(nil)
+		repo.On("FindGrantTx", tx, int64(10), int64(1)).Return(grant, nil)
+		var err = svc.Assign(AssignRequest{EmployeeId: 10, RoleId: 1, ValidFrom: &tomorrow})
+		a.Nil(err)
+		a.Equal([]provisioning.Event{grant.toEvent(provisioning.EntitlementRemoved)}, provisioner.events)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should provision contractor access starting now", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		svc.now = func() time.Time { return now }
+		var tx, _ = newTx(a, true)
+		var grant = GrantEntity{EmployeeId: 10, EmployeeName: "John Doe", RoleId: 1, RoleName: "Admin"}
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("UpsertTx", tx, Entity{EmployeeId: 10, RoleId: 1, ValidFrom: now, Activated: true,
+			ValidUntil: sql.NullTime{Time: nextMonth, Valid: true}}).Return(nil)
+		repo.On("FindGrantTx", tx, int64(10), int64(1)).Return(grant, nil)
+		var err = svc.Assign(AssignRequest{EmployeeId: 10, RoleId: 1, ValidUntil: &nextMonth})
+		a.Nil(err)
+		a.Equal([]provisioning.Event{grant.toEvent(provisioning.EntitlementAdded)}, provisioner.events)
+	})
+
+	t.Run("should reject valid_until before valid_from", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator, nil, nil)
+		svc.now = func() time.Time { return now }
+		var err = svc.Assign(AssignRequest{EmployeeId: 10, RoleId: 1, ValidFrom: &nextMonth, ValidUntil: &tomorrow})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+}
+
+func TestExpireDue(t *testing.T) {
+	var a = assert.New(t)
+	var now = time.Date(2025, 11, 26, 12, 0, 0, 0, time.UTC)
+	var until = func(t time.Time) sql.NullTime { return sql.NullTime{Time: t, Valid: true} }
+	var expired = Entity{EmployeeId: 10, RoleId: 1, ValidFrom: now.AddDate(0, -1, 0), ValidUntil: until(now), Activated: true}
+	var neverStarted = Entity{EmployeeId: 12, RoleId: 1, ValidFrom: now.Add(time.Hour), ValidUntil: until(now), Activated: false}
+	var started = Entity{EmployeeId: 11, RoleId: 2, ValidFrom: now, Activated: true}
+	var expiring = Entity{EmployeeId: 13, RoleId: 2, ValidFrom: now.AddDate(0, -1, 0), ValidUntil: until(now.Add(48 * time.Hour)), Activated: true}
+	var grant = func(employeeId int64, roleId int64) GrantEntity {
+		return GrantEntity{EmployeeId: employeeId, EmployeeName: "Employee", RoleId: roleId, RoleName: "Role"}
+	}
+
+	t.Run("should expire, activate and warn in one transaction", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var notifier = new(StubNotifier)
+		var svc = NewService(repo, validator.New(), provisioner, nil)
+		svc.now = func() time.Time { return now }
+		var tx, sqlMock = newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("ExpireTx", tx, now).Return([]Entity{expired, neverStarted}, nil)
+		repo.On("ActivateTx", tx, now).Return([]Entity{started}, nil)
+		repo.On("MarkExpiringTx", tx, now, now.Add(72*time.Hour)).Return([]Entity{expiring}, nil)
+		repo.On("CreateAuditTx", tx, mock.Anything).Return(nil)
+		repo.On("FindGrantTx", tx, int64(10), int64(1)).Return(grant(10, 1), nil)
+		repo.On("FindGrantTx", tx, int64(11), int64(2)).Return(grant(11, 2), nil)
+		repo.On("FindGrantTx", tx, int64(13), int64(2)).Return(grant(13, 2), nil)
+
+		var result, err = svc.ExpireDue(ExpiryOptions{WarnBefore: 72 * time.Hour, Notifier: notifier})
+		a.Nil(err)
+		a.Equal(ExpiryResult{Expired: 2, Activated: 1, Warned: 1}, result)
+		a.Equal([]provisioning.Event{
+			(&GrantEntity{EmployeeId: 10, EmployeeName: "Employee", RoleId: 1, RoleName: "Role"}).toEvent(provisioning.EntitlementRemoved),
+			(&GrantEntity{EmployeeId: 11, EmployeeName: "Employee", RoleId: 2, RoleName: "Role"}).toEvent(provisioning.EntitlementAdded),
+		}, provisioner.events)
+		a.Equal([]ExpiryWarning{{EmployeeId: 13, EmployeeName: "Employee", RoleId: 2, RoleName: "Role",
+			ValidUntil: now.Add(48 * time.Hour)}}, notifier.warnings)
+		repo.AssertCalled(t, "CreateAuditTx", tx, expired.toAudit(AuditExpired))
+		repo.AssertCalled(t, "CreateAuditTx", tx, neverStarted.toAudit(AuditExpired))
+		repo.AssertCalled(t, "CreateAuditTx", tx, started.toAudit(AuditActivated))
+		repo.AssertCalled(t, "CreateAuditTx", tx, expiring.toAudit(AuditExpiryWarning))
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should not publish or warn when audit fails", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var notifier = new(StubNotifier)
+		var svc = NewService(repo, validator.New(), provisioner, nil)
+		svc.now = func() time.Time { return now }
+		var tx, sqlMock = newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("ExpireTx", tx, now).Return([]Entity{expired}, nil)
+		repo.On("CreateAuditTx", tx, mock.Anything).Return(errors.New("disk full"))
+		var _, err = svc.ExpireDue(ExpiryOptions{WarnBefore: time.Hour, Notifier: notifier})
+		a.ErrorContains(err, "disk full")
+		a.Empty(provisioner.events)
+		a.Empty(notifier.warnings)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+}
+
+// StubGuard отклоняет назначение ролей из denied
+type StubGuard struct {
+	denied map[int64]bool
+}
+
+func (g *StubGuard) CheckTx(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	if g.denied[roleId] {
+		return common.PolicyViolationError{Message: fmt.Sprintf("role %d conflicts with roles of employee %d", roleId, employeeId)}
+	}
+	return nil
+}
+
+func TestGuard(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+	var guard = &StubGuard{denied: map[int64]bool{2: true}}
+
+	t.Run("should not assign conflicting role", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, guard)
+		tx, sqlMock := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		var err = svc.Assign(AssignRequest{EmployeeId: 1, RoleId: 2})
+		a.ErrorAs(err, &common.PolicyViolationError{})
+		repo.AssertNotCalled(t, "CreateTx", mock.Anything, mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should check only members being added", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, guard)
+		tx, sqlMock := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByRoleIdTx", tx, int64(2)).Return([]Entity{{EmployeeId: 1, RoleId: 2}}, nil)
+		var err = svc.SetRoleMembers(SetRoleMembersRequest{RoleId: 2, EmployeeIds: []int64{1, 3}})
+		a.ErrorAs(err, &common.PolicyViolationError{})
+		a.ErrorContains(err, "employee 3")
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,136 @@
+package assignmentrule
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+)
+
+type Controller struct {
+	server      *web.Server
+	ruleService Svc
+}
+
+// интерфейс сервиса assignmentrule.Service
+type Svc interface {
+	CreateRule(request CreateRuleRequest) (int64, error)
+	FindRules() ([]RuleResponse, error)
+	FindRuleById(id int64) (RuleResponse, error)
+	DeleteRule(id int64) (int64, error)
+	Evaluate() (ApplyResult, error)
+}
+
+func NewController(server *web.Server, ruleService Svc) *Controller {
+	return &Controller{
+		server:      server,
+		ruleService: ruleService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/assignment-rules", c.CreateRule)
+	c.server.GroupApiV1.Get("/assignment-rules", c.FindRules)
+	// регистрируется раньше "/assignment-rules/:id", иначе "evaluate" будет принят за id
+	c.server.GroupApiV1.Post("/assignment-rules/evaluate", c.Evaluate)
+	c.server.GroupApiV1.Get("/assignment-rules/:id", c.FindRuleById)
+	c.server.GroupApiV1.Delete("/assignment-rules/:id", c.DeleteRule)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/assignment-rules"
+func (c *Controller) CreateRule(ctx *fiber.Ctx) error {
+	var request CreateRuleRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	var id, err = c.ruleService.CreateRule(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning created assignment rule id")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindRules(ctx *fiber.Ctx) error {
+	var resp, err = c.ruleService.FindRules()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get assignment rules")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindRuleById(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.ruleService.FindRuleById(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get assignment rule by id")
+	}
+
+	return nil
+}
+
+func (c *Controller) DeleteRule(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	count, err := c.ruleService.DeleteRule(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, count); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error delete assignment rule by id")
+	}
+
+	return nil
+}
+
+// Evaluate сразу пересчитывает роли всех сотрудников, не дожидаясь фонового пересчёта
+func (c *Controller) Evaluate(ctx *fiber.Ctx) error {
+	var resp, err = c.ruleService.Evaluate()
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning evaluation result")
+	}
+
+	return nil
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	case errors.As(err, &common.AlreadyExistsError{}):
+		return common.ErrResponse(ctx, fiber.StatusConflict, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
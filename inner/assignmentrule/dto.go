@@ -0,0 +1,124 @@
+package assignmentrule
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RuleEntity правило выдаёт роль RoleId сотрудникам, атрибуты которых входят во все непустые списки правила.
+// Если задан OrgUnitId, сотрудник также должен состоять в этом подразделении или во вложенном в него
+type RuleEntity struct {
+	Id              int64          `db:"id"`
+	Name            string         `db:"name"`
+	RoleId          int64          `db:"role_id"`
+	RoleName        string         `db:"role_name"`
+	Departments     pq.StringArray `db:"departments"`
+	Titles          pq.StringArray `db:"titles"`
+	EmploymentTypes pq.StringArray `db:"employment_types"`
+	Locations       pq.StringArray `db:"locations"`
+	OrgUnitId       sql.NullInt64  `db:"org_unit_id"`
+	CreatedAt       time.Time      `db:"created_at"`
+}
+
+// EmployeeEntity сотрудник с атрибутами, по которым проверяются правила
+type EmployeeEntity struct {
+	Id             int64  `db:"id"`
+	Name           string `db:"name"`
+	Department     string `db:"department"`
+	Title          string `db:"title"`
+	EmploymentType string `db:"employment_type"`
+	Location       string `db:"location"`
+	// TerminatedAt заполнено у уволенного сотрудника; правила ему роли не выдают
+	TerminatedAt sql.NullTime `db:"terminated_at"`
+	// UnitIds подразделение сотрудника и все вышестоящие
+	UnitIds pq.Int64Array `db:"unit_ids"`
+}
+
+// AssignmentEntity роль сотрудника; RuleId пуст у назначений, сделанных вручную
+type AssignmentEntity struct {
+	RoleId   int64         `db:"role_id"`
+	RoleName string        `db:"role_name"`
+	RuleId   sql.NullInt64 `db:"rule_id"`
+}
+
+// Conditions списки допустимых значений атрибутов; пустой список не ограничивает атрибут
+type Conditions struct {
+	Department     []string `json:"department,omitempty" validate:"max=50,dive,required,max=155"`
+	Title          []string `json:"title,omitempty" validate:"max=50,dive,required,max=155"`
+	EmploymentType []string `json:"employment_type,omitempty" validate:"max=50,dive,required,max=64"`
+	Location       []string `json:"location,omitempty" validate:"max=50,dive,required,max=155"`
+	OrgUnitId      int64    `json:"org_unit_id,omitempty" validate:"min=0"`
+}
+
+type RuleResponse struct {
+	Id         int64      `json:"id"`
+	Name       string     `json:"name"`
+	RoleId     int64      `json:"role_id"`
+	RoleName   string     `json:"role_name"`
+	Conditions Conditions `json:"conditions"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ApplyResult сколько ролей выдано и снято правилами за один пересчёт. Skipped — роли,
+// которые правило выдало бы, но не пропустила проверка назначения, например правило SoD
+type ApplyResult struct {
+	Employees int `json:"employees"`
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+}
+
+func (r *ApplyResult) add(other ApplyResult) {
+	r.Employees += other.Employees
+	r.Added += other.Added
+	r.Removed += other.Removed
+	r.Skipped += other.Skipped
+	r.Failed += other.Failed
+}
+
+// matches проверяет атрибуты сотрудника по правилу; значения сравниваются без учёта регистра.
+// Уволенному сотруднику не подходит ни одно правило, поэтому выданные правилами роли у него снимаются
+func (e *RuleEntity) matches(employee EmployeeEntity) bool {
+	return !employee.TerminatedAt.Valid &&
+		matchesAny(e.Departments, employee.Department) &&
+		matchesAny(e.Titles, employee.Title) &&
+		matchesAny(e.EmploymentTypes, employee.EmploymentType) &&
+		matchesAny(e.Locations, employee.Location) &&
+		(!e.OrgUnitId.Valid || slices.Contains(employee.UnitIds, e.OrgUnitId.Int64))
+}
+
+func matchesAny(values []string, value string) bool {
+	return len(values) == 0 || slices.ContainsFunc(values, func(v string) bool {
+		return strings.EqualFold(v, value)
+	})
+}
+
+func (e *RuleEntity) toResponse() RuleResponse {
+	return RuleResponse{
+		Id:       e.Id,
+		Name:     e.Name,
+		RoleId:   e.RoleId,
+		RoleName: e.RoleName,
+		Conditions: Conditions{
+			Department:     e.Departments,
+			Title:          e.Titles,
+			EmploymentType: e.EmploymentTypes,
+			Location:       e.Locations,
+			OrgUnitId:      e.OrgUnitId.Int64,
+		},
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+func toEvent(eventType provisioning.EventType, employee EmployeeEntity, roleId int64, roleName string) provisioning.Event {
+	return provisioning.Event{
+		Type:        eventType,
+		Account:     provisioning.Account{EmployeeId: employee.Id, Name: employee.Name},
+		Entitlement: provisioning.Entitlement{RoleId: roleId, Name: roleName},
+	}
+}
@@ -0,0 +1,127 @@
+package assignmentrule
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+const selectRules = `SELECT ar.id, ar.name, ar.role_id, r.name AS role_name,
+              ar.departments, ar.titles, ar.employment_types, ar.locations, ar.org_unit_id, ar.created_at
+              FROM assignment_rule ar JOIN role r ON r.id = ar.role_id`
+
+func (r *Repository) FindRules() (rules []RuleEntity, err error) {
+	err = r.db.Select(&rules, selectRules+" ORDER BY ar.id")
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *Repository) FindRuleById(id int64) (rule RuleEntity, err error) {
+	err = r.db.Get(&rule, selectRules+" WHERE ar.id = $1", id)
+	return
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) FindByNameTx(tx *sqlx.Tx, name string) (exists bool, err error) {
+	err = tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM assignment_rule WHERE name = $1)", name)
+	return
+}
+
+func (r *Repository) OrgUnitExistsTx(tx *sqlx.Tx, id int64) (exists bool, err error) {
+	err = tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM org_unit WHERE id = $1)", id)
+	return
+}
+
+// FindRoleNameTx возвращает имя роли; sql.ErrNoRows, если роли нет
+func (r *Repository) FindRoleNameTx(tx *sqlx.Tx, roleId int64) (name string, err error) {
+	err = tx.Get(&name, "SELECT name FROM role WHERE id = $1", roleId)
+	return
+}
+
+// CreateRuleTx сохраняет правило и заполняет Id и CreatedAt
+func (r *Repository) CreateRuleTx(tx *sqlx.Tx, e *RuleEntity) error {
+	query := `INSERT INTO assignment_rule (name, role_id, departments, titles, employment_types, locations, org_unit_id)
+              VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	return tx.QueryRowx(query, e.Name, e.RoleId, e.Departments, e.Titles, e.EmploymentTypes, e.Locations, e.OrgUnitId).
+		Scan(&e.Id, &e.CreatedAt)
+}
+
+func (r *Repository) DeleteRule(id int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM assignment_rule WHERE id = $1", id)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *Repository) FindEmployeeIds() (ids []int64, err error) {
+	err = r.db.Select(&ids, "SELECT id FROM employee ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// FindEmployeeTx читает атрибуты сотрудника вместе с цепочкой его подразделений и блокирует его до конца транзакции,
+// чтобы параллельные пересчёты одного сотрудника не выдали роль дважды
+func (r *Repository) FindEmployeeTx(tx *sqlx.Tx, id int64) (employee EmployeeEntity, err error) {
+	query := `SELECT e.id, e.name, e.department, e.title, e.employment_type, e.location, e.terminated_at,
+                  ARRAY(
+                      WITH RECURSIVE up AS (
+                          SELECT id, parent_id FROM org_unit WHERE id = e.org_unit_id
+                          UNION
+                          SELECT u.id, u.parent_id FROM org_unit u JOIN up ON u.id = up.parent_id
+                      )
+                      SELECT id FROM up
+                  ) AS unit_ids
+              FROM employee e WHERE e.id = $1 FOR UPDATE OF e`
+	err = tx.Get(&employee, query, id)
+	return
+}
+
+// FindRulesTx возвращает все правила в порядке создания
+func (r *Repository) FindRulesTx(tx *sqlx.Tx) (rules []RuleEntity, err error) {
+	err = tx.Select(&rules, selectRules+" ORDER BY ar.id")
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// FindAssignmentsTx возвращает все назначения сотрудника, включая ещё не наступившие
+func (r *Repository) FindAssignmentsTx(tx *sqlx.Tx, employeeId int64) (assignments []AssignmentEntity, err error) {
+	query := `SELECT er.role_id, r.name AS role_name, er.rule_id
+              FROM employee_role er JOIN role r ON r.id = er.role_id
+              WHERE er.employee_id = $1 ORDER BY er.role_id`
+	err = tx.Select(&assignments, query, employeeId)
+	if err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// AssignTx выдаёт роль по правилу ruleId. Уже имеющееся назначение не меняется
+func (r *Repository) AssignTx(tx *sqlx.Tx, employeeId int64, roleId int64, ruleId int64) error {
+	query := `INSERT INTO employee_role (employee_id, role_id, rule_id) VALUES ($1, $2, $3)
+              ON CONFLICT (employee_id, role_id) DO NOTHING`
+	_, err := tx.Exec(query, employeeId, roleId, ruleId)
+	return err
+}
+
+// RemoveTx снимает роль, только если её выдало правило
+func (r *Repository) RemoveTx(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	query := "DELETE FROM employee_role WHERE employee_id = $1 AND role_id = $2 AND rule_id IS NOT NULL"
+	_, err := tx.Exec(query, employeeId, roleId)
+	return err
+}
@@ -0,0 +1,302 @@
+package assignmentrule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"log"
+	"time"
+)
+
+// Структура сервиса правил назначения ролей по атрибутам сотрудников (birthright access)
+type Service struct {
+	repo        Repo
+	validator   Validator
+	provisioner Provisioner
+	guard       Guard
+}
+
+// CreateRuleRequest правило выдаёт роль RoleId сотрудникам, подходящим под все заданные условия
+type CreateRuleRequest struct {
+	Name       string     `json:"name" validate:"required,min=2,max=155"`
+	RoleId     int64      `json:"role_id" validate:"required,gt=0"`
+	Conditions Conditions `json:"conditions"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+// Provisioner асинхронно передаёт выданные и снятые правилами роли во внешние системы
+type Provisioner interface {
+	Publish(event provisioning.Event)
+}
+
+// Guard проверяет в транзакции, что роль можно выдать сотруднику, например sod.Service
+type Guard interface {
+	CheckTx(tx *sqlx.Tx, employeeId int64, roleId int64) error
+}
+
+type Repo interface {
+	FindRules() ([]RuleEntity, error)
+	FindRuleById(int64) (RuleEntity, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	FindByNameTx(*sqlx.Tx, string) (bool, error)
+	FindRoleNameTx(*sqlx.Tx, int64) (string, error)
+	OrgUnitExistsTx(*sqlx.Tx, int64) (bool, error)
+	CreateRuleTx(*sqlx.Tx, *RuleEntity) error
+	DeleteRule(int64) (int64, error)
+	FindEmployeeIds() ([]int64, error)
+	FindEmployeeTx(*sqlx.Tx, int64) (EmployeeEntity, error)
+	FindRulesTx(*sqlx.Tx) ([]RuleEntity, error)
+	FindAssignmentsTx(*sqlx.Tx, int64) ([]AssignmentEntity, error)
+	AssignTx(*sqlx.Tx, int64, int64, int64) error
+	RemoveTx(*sqlx.Tx, int64, int64) error
+}
+
+// NewService создаёт сервис правил. Если provisioner равен nil, изменения ролей во внешние системы не передаются,
+// если guard равен nil, выдаваемые правилами роли не проверяются
+func NewService(repo Repo, validator Validator, provisioner Provisioner, guard Guard) *Service {
+	return &Service{
+		repo:        repo,
+		validator:   validator,
+		provisioner: provisioner,
+		guard:       guard,
+	}
+}
+
+// CreateRule создаёт правило и возвращает его идентификатор. Роли по новому правилу выдаются
+// при следующем изменении сотрудника или пересчёте
+func (srv *Service) CreateRule(request CreateRuleRequest) (id int64, err error) {
+	err = srv.validator.Validate(request)
+	if err != nil {
+		return 0, common.RequestValidationError{Message: err.Error()}
+	}
+	var c = request.Conditions
+	if len(c.Department) == 0 && len(c.Title) == 0 && len(c.EmploymentType) == 0 && len(c.Location) == 0 &&
+		c.OrgUnitId == 0 {
+		// правило без условий выдало бы роль всем сотрудникам
+		return 0, common.RequestValidationError{Message: "rule must have at least one condition"}
+	}
+	var entity = RuleEntity{
+		Name:            request.Name,
+		RoleId:          request.RoleId,
+		Departments:     c.Department,
+		Titles:          c.Title,
+		EmploymentTypes: c.EmploymentType,
+		Locations:       c.Location,
+		OrgUnitId:       sql.NullInt64{Int64: c.OrgUnitId, Valid: c.OrgUnitId > 0},
+	}
+
+	err = database.InTransaction(srv.repo.BeginTransaction, "creating assignment rule", func(tx *sqlx.Tx) error {
+		exists, err := srv.repo.FindByNameTx(tx, entity.Name)
+		if err != nil {
+			return fmt.Errorf("error finding assignment rule by name: %s, %w", entity.Name, err)
+		}
+		if exists {
+			return common.AlreadyExistsError{Message: fmt.Sprintf("assignment rule with name %s already exists", entity.Name)}
+		}
+		entity.RoleName, err = srv.repo.FindRoleNameTx(tx, entity.RoleId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("role with id %d not found", entity.RoleId)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding role with id %d: %w", entity.RoleId, err)
+		}
+		if entity.OrgUnitId.Valid {
+			exists, err := srv.repo.OrgUnitExistsTx(tx, entity.OrgUnitId.Int64)
+			if err != nil {
+				return fmt.Errorf("error finding org unit with id %d: %w", entity.OrgUnitId.Int64, err)
+			}
+			if !exists {
+				return common.NotFoundError{Message: fmt.Sprintf("org unit with id %d not found", entity.OrgUnitId.Int64)}
+			}
+		}
+		if err := srv.repo.CreateRuleTx(tx, &entity); err != nil {
+			return fmt.Errorf("error creating assignment rule: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entity.Id, nil
+}
+
+func (srv *Service) FindRules() ([]RuleResponse, error) {
+	var entities, err = srv.repo.FindRules()
+	if err != nil {
+		return []RuleResponse{}, fmt.Errorf("error get assignment rules: %w", err)
+	}
+
+	var resp = []RuleResponse{}
+	for _, e := range entities {
+		resp = append(resp, e.toResponse())
+	}
+	return resp, nil
+}
+
+func (srv *Service) FindRuleById(id int64) (RuleResponse, error) {
+	var entity, err = srv.repo.FindRuleById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RuleResponse{}, common.NotFoundError{Message: fmt.Sprintf("assignment rule with id %d not found", id)}
+	}
+	if err != nil {
+		return RuleResponse{}, fmt.Errorf("error finding assignment rule with id %d: %w", id, err)
+	}
+	return entity.toResponse(), nil
+}
+
+// DeleteRule удаляет правило. Выданные им роли снимаются при следующем пересчёте,
+// если их не выдаёт другое правило
+func (srv *Service) DeleteRule(id int64) (int64, error) {
+	var count, err = srv.repo.DeleteRule(id)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting assignment rule with id %d: %w", id, err)
+	}
+	if count == 0 {
+		return 0, common.NotFoundError{Message: fmt.Sprintf("assignment rule with id %d not found", id)}
+	}
+	return count, nil
+}
+
+// Apply пересчитывает роли сотрудника по правилам. Реализует employee.Rules
+func (srv *Service) Apply(employeeId int64) error {
+	_, err := srv.apply(employeeId)
+	return err
+}
+
+// Evaluate пересчитывает роли всех сотрудников, каждого в своей транзакции.
+// Ошибка по одному сотруднику записывается в лог и не останавливает пересчёт остальных
+func (srv *Service) Evaluate() (ApplyResult, error) {
+	var ids, err = srv.repo.FindEmployeeIds()
+	if err != nil {
+		return ApplyResult{}, fmt.Errorf("error get employees: %w", err)
+	}
+
+	var result ApplyResult
+	for _, id := range ids {
+		employeeResult, err := srv.apply(id)
+		if err != nil {
+			log.Printf("assignmentrule: applying rules to employee %d failed: %v", id, err)
+			result.Failed++
+			continue
+		}
+		result.add(employeeResult)
+	}
+	return result, nil
+}
+
+// Schedule запускает Evaluate каждые interval, пока не отменён ctx
+func (srv *Service) Schedule(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				result, err := srv.Evaluate()
+				if err != nil {
+					log.Printf("assignmentrule: evaluation failed: %v", err)
+					continue
+				}
+				if result.Added+result.Removed+result.Skipped+result.Failed > 0 {
+					log.Printf("assignmentrule: %d roles added, %d removed, %d skipped, %d employees failed",
+						result.Added, result.Removed, result.Skipped, result.Failed)
+				}
+			}
+		}
+	}()
+}
+
+// apply выдаёт сотруднику роли подходящих правил и снимает выданные правилами роли, под которые он больше не подходит.
+// Назначения, сделанные вручную, не меняются. Роль, которую не пропустил guard, пропускается, а не прерывает пересчёт
+func (srv *Service) apply(employeeId int64) (ApplyResult, error) {
+	var result = ApplyResult{Employees: 1}
+	var events []provisioning.Event
+	err := database.InTransaction(srv.repo.BeginTransaction, "applying assignment rules", func(tx *sqlx.Tx) error {
+		employee, err := srv.repo.FindEmployeeTx(tx, employeeId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", employeeId)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding employee with id %d: %w", employeeId, err)
+		}
+		rules, err := srv.repo.FindRulesTx(tx)
+		if err != nil {
+			return fmt.Errorf("error get assignment rules: %w", err)
+		}
+		assignments, err := srv.repo.FindAssignmentsTx(tx, employeeId)
+		if err != nil {
+			return fmt.Errorf("error get roles of employee %d: %w", employeeId, err)
+		}
+
+		var assigned = make(map[int64]bool, len(assignments))
+		for _, a := range assignments {
+			assigned[a.RoleId] = true
+		}
+		var wanted = make(map[int64]bool)
+		for _, rule := range rules {
+			if !rule.matches(employee) || wanted[rule.RoleId] {
+				continue
+			}
+			wanted[rule.RoleId] = true
+			if assigned[rule.RoleId] {
+				continue
+			}
+			if err := srv.check(tx, employeeId, rule.RoleId); err != nil {
+				if errors.As(err, &common.PolicyViolationError{}) {
+					log.Printf("assignmentrule: rule %q skipped for employee %d: %v", rule.Name, employeeId, err)
+					result.Skipped++
+					continue
+				}
+				return err
+			}
+			if err := srv.repo.AssignTx(tx, employeeId, rule.RoleId, rule.Id); err != nil {
+				return fmt.Errorf("error assign role %d to employee %d: %w", rule.RoleId, employeeId, err)
+			}
+			result.Added++
+			events = append(events, toEvent(provisioning.EntitlementAdded, employee, rule.RoleId, rule.RoleName))
+		}
+
+		for _, a := range assignments {
+			if !a.RuleId.Valid || wanted[a.RoleId] {
+				continue
+			}
+			if err := srv.repo.RemoveTx(tx, employeeId, a.RoleId); err != nil {
+				return fmt.Errorf("error remove role %d from employee %d: %w", a.RoleId, employeeId, err)
+			}
+			result.Removed++
+			events = append(events, toEvent(provisioning.EntitlementRemoved, employee, a.RoleId, a.RoleName))
+		}
+		return nil
+	})
+	if err != nil {
+		return ApplyResult{}, err
+	}
+
+	if srv.provisioner != nil {
+		for _, e := range events {
+			srv.provisioner.Publish(e)
+		}
+	}
+	return result, nil
+}
+
+// check проверяет назначение через guard, если он задан
+func (srv *Service) check(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	if srv.guard == nil {
+		return nil
+	}
+	return srv.guard.CheckTx(tx, employeeId, roleId)
+}
@@ -0,0 +1,318 @@
+package assignmentrule
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindRules() ([]RuleEntity, error) {
+	args := m.Called()
+	return args.Get(0).([]RuleEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindRuleById(id int64) (RuleEntity, error) {
+	args := m.Called(id)
+	return args.Get(0).(RuleEntity), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) FindByNameTx(tx *sqlx.Tx, name string) (bool, error) {
+	args := m.Called(tx, name)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) FindRoleNameTx(tx *sqlx.Tx, roleId int64) (string, error) {
+	args := m.Called(tx, roleId)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockRepo) OrgUnitExistsTx(tx *sqlx.Tx, id int64) (bool, error) {
+	args := m.Called(tx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) CreateRuleTx(tx *sqlx.Tx, e *RuleEntity) error {
+	args := m.Called(tx, e)
+	e.Id = 1
+	return args.Error(0)
+}
+
+func (m *MockRepo) DeleteRule(id int64) (int64, error) {
+	args := m.Called(id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindEmployeeIds() ([]int64, error) {
+	args := m.Called()
+	return args.Get(0).([]int64), args.Error(1)
+}
+
+func (m *MockRepo) FindEmployeeTx(tx *sqlx.Tx, id int64) (EmployeeEntity, error) {
+	args := m.Called(tx, id)
+	return args.Get(0).(EmployeeEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindRulesTx(tx *sqlx.Tx) ([]RuleEntity, error) {
+	args := m.Called(tx)
+	return args.Get(0).([]RuleEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindAssignmentsTx(tx *sqlx.Tx, employeeId int64) ([]AssignmentEntity, error) {
+	args := m.Called(tx, employeeId)
+	return args.Get(0).([]AssignmentEntity), args.Error(1)
+}
+
+func (m *MockRepo) AssignTx(tx *sqlx.Tx, employeeId int64, roleId int64, ruleId int64) error {
+	args := m.Called(tx, employeeId, roleId, ruleId)
+	return args.Error(0)
+}
+
+func (m *MockRepo) RemoveTx(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Error(0)
+}
+
+// StubProvisioner запоминает опубликованные события
+type StubProvisioner struct {
+	events []provisioning.Event
+}
+
+func (s *StubProvisioner) Publish(event provisioning.Event) {
+	s.events = append(s.events, event)
+}
+
+// StubGuard запрещает роли из denied
+type StubGuard struct {
+	denied map[int64]bool
+}
+
+func (g *StubGuard) CheckTx(_ *sqlx.Tx, employeeId int64, roleId int64) error {
+	if g.denied[roleId] {
+		return common.PolicyViolationError{Message: "role conflicts with another role"}
+	}
+	return nil
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+var engineer = EmployeeEntity{Id: 10, Name: "Ivan", Department: "engineering", EmploymentType: "full-time", Location: "Berlin"}
+
+var rules = []RuleEntity{
+	{Id: 1, Name: "Engineering", RoleId: 3, RoleName: "Engineer", Departments: []string{"Engineering"}},
+	{Id: 2, Name: "Berlin staff", RoleId: 4, RoleName: "Berlin Office",
+		EmploymentTypes: []string{"full-time"}, Locations: []string{"Berlin", "Potsdam"}},
+	{Id: 3, Name: "Sales", RoleId: 5, RoleName: "CRM", Departments: []string{"Sales"}},
+	// вторая роль Engineer по другому правилу не выдаётся повторно
+	{Id: 4, Name: "Engineering again", RoleId: 3, RoleName: "Engineer", Departments: []string{"Engineering"}},
+}
+
+func TestMatches(t *testing.T) {
+	var a = assert.New(t)
+	a.True(rules[0].matches(engineer))
+	a.True(rules[1].matches(engineer))
+	a.False(rules[2].matches(engineer))
+	var contractor = engineer
+	contractor.EmploymentType = "contractor"
+	a.False(rules[1].matches(contractor))
+
+	// правило по подразделению 2 подходит и сотрудникам вложенного подразделения 5
+	var byUnit = RuleEntity{Id: 5, RoleId: 7, OrgUnitId: sql.NullInt64{Int64: 2, Valid: true}}
+	var member = engineer
+	member.UnitIds = []int64{5, 2, 1}
+	a.True(byUnit.matches(member))
+	a.False(byUnit.matches(engineer))
+
+	// уволенному сотруднику правила ролей не выдают
+	var terminated = engineer
+	terminated.TerminatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	a.False(rules[0].matches(terminated))
+}
+
+func TestCreateRule(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should create rule", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, "Engineering").Return(false, nil)
+		repo.On("FindRoleNameTx", tx, int64(3)).Return("Engineer", nil)
+		repo.On("CreateRuleTx", tx, mock.MatchedBy(func(e *RuleEntity) bool {
+			return e.RoleName == "Engineer" && len(e.Departments) == 1
+		})).Return(nil)
+		var id, err = svc.CreateRule(CreateRuleRequest{Name: "Engineering", RoleId: 3,
+			Conditions: Conditions{Department: []string{"Engineering"}}})
+		a.Nil(err)
+		a.Equal(int64(1), id)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should require at least one condition", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator.New(), nil, nil)
+		var _, err = svc.CreateRule(CreateRuleRequest{Name: "Everyone", RoleId: 3})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should reject empty condition value", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator.New(), nil, nil)
+		var _, err = svc.CreateRule(CreateRuleRequest{Name: "Engineering", RoleId: 3,
+			Conditions: Conditions{Department: []string{""}}})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should return not found for unknown org unit", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, "Engineering").Return(false, nil)
+		repo.On("FindRoleNameTx", tx, int64(3)).Return("Engineer", nil)
+		repo.On("OrgUnitExistsTx", tx, int64(99)).Return(false, nil)
+		var _, err = svc.CreateRule(CreateRuleRequest{Name: "Engineering", RoleId: 3,
+			Conditions: Conditions{OrgUnitId: 99}})
+		a.ErrorAs(err, &common.NotFoundError{})
+		repo.AssertNotCalled(t, "CreateRuleTx", mock.Anything, mock.Anything)
+	})
+
+	t.Run("should return not found for unknown role", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, "Engineering").Return(false, nil)
+		repo.On("FindRoleNameTx", tx, int64(99)).Return("", sql.ErrNoRows)
+		var _, err = svc.CreateRule(CreateRuleRequest{Name: "Engineering", RoleId: 99,
+			Conditions: Conditions{Department: []string{"Engineering"}}})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+}
+
+func TestApply(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should add matching roles and remove rule roles that no longer match", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator.New(), provisioner, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindEmployeeTx", tx, int64(10)).Return(engineer, nil)
+		repo.On("FindRulesTx", tx).Return(rules, nil)
+		repo.On("FindAssignmentsTx", tx, int64(10)).Return([]AssignmentEntity{
+			// роль выдана правилом Sales, сотрудник перешёл в Engineering
+			{RoleId: 5, RoleName: "CRM", RuleId: sql.NullInt64{Int64: 3, Valid: true}},
+			// ручное назначение правила не трогают
+			{RoleId: 6, RoleName: "VPN"},
+		}, nil)
+		repo.On("AssignTx", tx, int64(10), int64(3), int64(1)).Return(nil)
+		repo.On("AssignTx", tx, int64(10), int64(4), int64(2)).Return(nil)
+		repo.On("RemoveTx", tx, int64(10), int64(5)).Return(nil)
+
+		var result, err = svc.apply(10)
+		a.Nil(err)
+		a.Equal(ApplyResult{Employees: 1, Added: 2, Removed: 1}, result)
+		repo.AssertNumberOfCalls(t, "AssignTx", 2)
+		repo.AssertNumberOfCalls(t, "RemoveTx", 1)
+		a.Equal([]provisioning.Event{
+			toEvent(provisioning.EntitlementAdded, engineer, 3, "Engineer"),
+			toEvent(provisioning.EntitlementAdded, engineer, 4, "Berlin Office"),
+			toEvent(provisioning.EntitlementRemoved, engineer, 5, "CRM"),
+		}, provisioner.events)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should keep role that is already assigned", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindEmployeeTx", tx, int64(10)).Return(engineer, nil)
+		repo.On("FindRulesTx", tx).Return(rules[:1], nil)
+		repo.On("FindAssignmentsTx", tx, int64(10)).Return([]AssignmentEntity{
+			{RoleId: 3, RoleName: "Engineer", RuleId: sql.NullInt64{Int64: 1, Valid: true}},
+		}, nil)
+		var result, err = svc.apply(10)
+		a.Nil(err)
+		a.Equal(ApplyResult{Employees: 1}, result)
+		repo.AssertNotCalled(t, "AssignTx", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		repo.AssertNotCalled(t, "RemoveTx", mock.Anything, mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should skip role rejected by guard", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, &StubGuard{denied: map[int64]bool{4: true}})
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindEmployeeTx", tx, int64(10)).Return(engineer, nil)
+		repo.On("FindRulesTx", tx).Return(rules[:2], nil)
+		repo.On("FindAssignmentsTx", tx, int64(10)).Return([]AssignmentEntity{}, nil)
+		repo.On("AssignTx", tx, int64(10), int64(3), int64(1)).Return(nil)
+		var result, err = svc.apply(10)
+		a.Nil(err)
+		a.Equal(ApplyResult{Employees: 1, Added: 1, Skipped: 1}, result)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should return not found for unknown employee", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindEmployeeTx", tx, int64(99)).Return(EmployeeEntity{}, sql.ErrNoRows)
+		a.ErrorAs(svc.Apply(99), &common.NotFoundError{})
+	})
+}
+
+func TestEvaluate(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = NewService(repo, validator.New(), nil, nil)
+	repo.On("FindEmployeeIds").Return([]int64{10, 11}, nil)
+
+	tx, _ := newTx(a, true)
+	failed, _ := newTx(a, false)
+	repo.On("BeginTransaction").Return(tx, nil).Once()
+	repo.On("BeginTransaction").Return(failed, nil).Once()
+	repo.On("FindEmployeeTx", tx, int64(10)).Return(engineer, nil)
+	repo.On("FindRulesTx", tx).Return(rules[:1], nil)
+	repo.On("FindAssignmentsTx", tx, int64(10)).Return([]AssignmentEntity{}, nil)
+	repo.On("AssignTx", tx, int64(10), int64(3), int64(1)).Return(nil)
+	repo.On("FindEmployeeTx", failed, int64(11)).Return(EmployeeEntity{}, errors.New("connection lost"))
+
+	var result, err = svc.Evaluate()
+	a.Nil(err)
+	a.Equal(ApplyResult{Employees: 1, Added: 1, Failed: 1}, result)
+}
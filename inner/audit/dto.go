@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Категории событий журнала
+const (
+	CategoryAssignment = "assignment"
+	CategoryLockout    = "lockout"
+)
+
+// Entity событие журнала аудита. Hash — SHA-256 от содержимого события и PrevHash, хеша предыдущего события,
+// поэтому правка или удаление записи в базе разрывает цепочку начиная с этого события
+type Entity struct {
+	Id       int64  `db:"id"`
+	Category string `db:"category"`
+	Action   string `db:"action"`
+	// Subject кого касается событие, например "employee:1" или "ip:10.0.0.1"
+	Subject string `db:"subject"`
+	// Data подробности события в JSON, хранятся как есть, без нормализации
+	Data       string    `db:"data"`
+	OccurredAt time.Time `db:"occurred_at"`
+	PrevHash   string    `db:"prev_hash"`
+	Hash       string    `db:"hash"`
+}
+
+// Event событие, которое другие пакеты дописывают в журнал
+type Event struct {
+	Category string
+	Action   string
+	Subject  string
+	Data     any
+	// OccurredAt время события; если пусто — момент записи
+	OccurredAt time.Time
+}
+
+// Head последнее событие цепочки. Сохранённая снаружи голова позволяет заметить и удаление событий с конца журнала
+type Head struct {
+	Id   int64  `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// VerifyResult результат проверки цепочки
+type VerifyResult struct {
+	Valid  bool  `json:"valid"`
+	Events int64 `json:"events"`
+	Head   Head  `json:"head"`
+	// BrokenId первое событие, на котором цепочка не сходится
+	BrokenId int64  `json:"broken_id,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// computeHash хеш события по его содержимому и PrevHash. Поля пишутся с длиной,
+// чтобы перенос символов из одного поля в соседнее менял хеш
+func (e *Entity) computeHash() string {
+	var hash = sha256.New()
+	var fields = []string{
+		e.PrevHash,
+		e.Category,
+		e.Action,
+		e.Subject,
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		e.Data,
+	}
+	for _, field := range fields {
+		hash.Write([]byte(strconv.Itoa(len(field))))
+		hash.Write([]byte{':'})
+		hash.Write([]byte(field))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (e *Entity) toHead() Head {
+	return Head{Id: e.Id, Hash: e.Hash}
+}
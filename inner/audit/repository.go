@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// FindHead возвращает последнее событие; sql.ErrNoRows, если журнал пуст
+func (r *Repository) FindHead() (e Entity, err error) {
+	err = r.db.Get(&e, "SELECT * FROM audit_event ORDER BY id DESC LIMIT 1")
+	return
+}
+
+// FindAfter возвращает до limit событий с id больше afterId в порядке цепочки
+func (r *Repository) FindAfter(afterId int64, limit int) (events []Entity, err error) {
+	err = r.db.Select(&events, "SELECT * FROM audit_event WHERE id > $1 ORDER BY id LIMIT $2", afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// AppendTx дописывает событие в конец цепочки в транзакции tx.
+// Таблица блокируется от других записей до конца tx, чтобы два события не сослались на одного предшественника;
+// чтение журнала блокировка не задерживает
+func AppendTx(tx *sqlx.Tx, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	var occurredAt = event.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+	if _, err = tx.Exec("LOCK TABLE audit_event IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	var e = Entity{
+		Category: event.Category,
+		Action:   event.Action,
+		Subject:  event.Subject,
+		Data:     string(data),
+		// в базе время хранится с точностью до микросекунды, хеш считается от того же значения
+		OccurredAt: occurredAt.UTC().Truncate(time.Microsecond),
+	}
+	err = tx.Get(&e.PrevHash, "SELECT hash FROM audit_event ORDER BY id DESC LIMIT 1")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	e.Hash = e.computeHash()
+	query := `INSERT INTO audit_event (category, action, subject, data, occurred_at, prev_hash, hash)
+              VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.Exec(query, e.Category, e.Action, e.Subject, e.Data, e.OccurredAt, e.PrevHash, e.Hash)
+	return err
+}
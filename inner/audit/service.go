@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// verifyPageSize сколько событий читается за раз при проверке цепочки
+const verifyPageSize = 1000
+
+// Структура сервиса журнала аудита
+type Service struct {
+	repo Repo
+}
+
+// интерфейс репозитория audit.Repository
+type Repo interface {
+	FindHead() (Entity, error)
+	FindAfter(int64, int) ([]Entity, error)
+}
+
+func NewService(repo Repo) *Service {
+	return &Service{repo: repo}
+}
+
+// Head возвращает голову цепочки; у пустого журнала она нулевая
+func (srv *Service) Head() (Head, error) {
+	head, err := srv.repo.FindHead()
+	if errors.Is(err, sql.ErrNoRows) {
+		return Head{}, nil
+	}
+	if err != nil {
+		return Head{}, fmt.Errorf("error finding audit chain head: %w", err)
+	}
+	return head.toHead(), nil
+}
+
+// Verify проходит цепочку с первого события и пересчитывает хеши.
+// Останавливается на первом разрыве: дальше хеши сходятся уже с подделанным событием и ничего не доказывают
+func (srv *Service) Verify() (VerifyResult, error) {
+	var result = VerifyResult{Valid: true}
+	var prev Entity
+	for {
+		events, err := srv.repo.FindAfter(prev.Id, verifyPageSize)
+		if err != nil {
+			return VerifyResult{}, fmt.Errorf("error reading audit events after %d: %w", prev.Id, err)
+		}
+		for _, e := range events {
+			switch {
+			case e.PrevHash != prev.Hash:
+				result.Reason = fmt.Sprintf("event %d does not link to event %d", e.Id, prev.Id)
+			case e.computeHash() != e.Hash:
+				result.Reason = fmt.Sprintf("content of event %d does not match its hash", e.Id)
+			}
+			if result.Reason != "" {
+				result.Valid = false
+				result.BrokenId = e.Id
+				return result, nil
+			}
+			result.Events++
+			result.Head = e.toHead()
+			prev = e
+		}
+		if len(events) < verifyPageSize {
+			return result, nil
+		}
+	}
+}
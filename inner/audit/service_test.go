@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindHead() (Entity, error) {
+	args := m.Called()
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindAfter(afterId int64, limit int) ([]Entity, error) {
+	args := m.Called(afterId, limit)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+// newChain строит цепочку из count событий так же, как AppendTx
+func newChain(count int) []Entity {
+	var events []Entity
+	var prevHash string
+	for i := 1; i <= count; i++ {
+		var e = Entity{
+			Id:         int64(i),
+			Category:   CategoryLockout,
+			Action:     "locked",
+			Subject:    "employee:1",
+			Data:       `{"locked_until":"2026-01-01T00:00:00Z"}`,
+			OccurredAt: time.Date(2025, 12, 1, 10, i, 0, 0, time.UTC),
+			PrevHash:   prevHash,
+		}
+		e.Hash = e.computeHash()
+		prevHash = e.Hash
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestVerify(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should accept intact chain", func(t *testing.T) {
+		var repo = &MockRepo{}
+		var chain = newChain(3)
+		repo.On("FindAfter", int64(0), verifyPageSize).Return(chain, nil)
+
+		result, err := NewService(repo).Verify()
+		a.Nil(err)
+		a.True(result.Valid)
+		a.Equal(int64(3), result.Events)
+		a.Equal(Head{Id: 3, Hash: chain[2].Hash}, result.Head)
+	})
+
+	t.Run("should accept empty journal", func(t *testing.T) {
+		var repo = &MockRepo{}
+		repo.On("FindAfter", int64(0), verifyPageSize).Return([]Entity(nil), nil)
+
+		result, err := NewService(repo).Verify()
+		a.Nil(err)
+		a.Equal(VerifyResult{Valid: true}, result)
+	})
+
+	t.Run("should report edited event", func(t *testing.T) {
+		var repo = &MockRepo{}
+		var chain = newChain(3)
+		chain[1].Subject = "employee:2"
+		repo.On("FindAfter", int64(0), verifyPageSize).Return(chain, nil)
+
+		result, err := NewService(repo).Verify()
+		a.Nil(err)
+		a.False(result.Valid)
+		a.Equal(int64(2), result.BrokenId)
+		a.Equal(int64(1), result.Events)
+		a.Equal("content of event 2 does not match its hash", result.Reason)
+	})
+
+	t.Run("should report deleted event", func(t *testing.T) {
+		var repo = &MockRepo{}
+		var chain = newChain(3)
+		repo.On("FindAfter", int64(0), verifyPageSize).Return([]Entity{chain[0], chain[2]}, nil)
+
+		result, err := NewService(repo).Verify()
+		a.Nil(err)
+		a.False(result.Valid)
+		a.Equal(int64(3), result.BrokenId)
+		a.Equal("event 3 does not link to event 1", result.Reason)
+	})
+
+	t.Run("should report rehashed event by the next link", func(t *testing.T) {
+		var repo = &MockRepo{}
+		var chain = newChain(3)
+		chain[1].Data = `{"locked_until":"2030-01-01T00:00:00Z"}`
+		chain[1].Hash = chain[1].computeHash()
+		repo.On("FindAfter", int64(0), verifyPageSize).Return(chain, nil)
+
+		result, err := NewService(repo).Verify()
+		a.Nil(err)
+		a.False(result.Valid)
+		a.Equal(int64(3), result.BrokenId)
+	})
+
+	t.Run("should read chain page by page", func(t *testing.T) {
+		var repo = &MockRepo{}
+		var chain = newChain(verifyPageSize + 1)
+		repo.On("FindAfter", int64(0), verifyPageSize).Return(chain[:verifyPageSize], nil)
+		repo.On("FindAfter", int64(verifyPageSize), verifyPageSize).Return(chain[verifyPageSize:], nil)
+
+		result, err := NewService(repo).Verify()
+		a.Nil(err)
+		a.True(result.Valid)
+		a.Equal(int64(verifyPageSize+1), result.Events)
+	})
+}
+
+func TestHead(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should return last event", func(t *testing.T) {
+		var repo = &MockRepo{}
+		var chain = newChain(2)
+		repo.On("FindHead").Return(chain[1], nil)
+
+		head, err := NewService(repo).Head()
+		a.Nil(err)
+		a.Equal(Head{Id: 2, Hash: chain[1].Hash}, head)
+	})
+
+	t.Run("should return empty head for empty journal", func(t *testing.T) {
+		var repo = &MockRepo{}
+		repo.On("FindHead").Return(Entity{}, sql.ErrNoRows)
+
+		head, err := NewService(repo).Head()
+		a.Nil(err)
+		a.Equal(Head{}, head)
+	})
+
+	t.Run("should return database error", func(t *testing.T) {
+		var repo = &MockRepo{}
+		repo.On("FindHead").Return(Entity{}, errors.New("connection refused"))
+
+		_, err := NewService(repo).Head()
+		a.ErrorContains(err, "connection refused")
+	})
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"math"
+	"strconv"
+	"time"
+)
+
+// SessionCookie cookie с access-токеном IdM, по которой authorization endpoint OpenID Connect узнаёт вошедшего сотрудника
+const SessionCookie = "idm_access_token"
+
+type Controller struct {
+	server      *web.Server
+	authService Svc
+}
+
+// интерфейс сервиса auth.Service
+type Svc interface {
+	Login(request LoginRequest) (TokenResponse, error)
+	LoginMfa(request MfaRequest) (TokenResponse, error)
+	Refresh(request RefreshRequest) (TokenResponse, error)
+	Logout(request RefreshRequest) error
+}
+
+func NewController(server *web.Server, authService Svc) *Controller {
+	return &Controller{
+		server:      server,
+		authService: authService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/auth/login", c.Login)
+	c.server.GroupApiV1.Post("/auth/login/mfa", c.LoginMfa)
+	c.server.GroupApiV1.Post("/auth/refresh", c.Refresh)
+	c.server.GroupApiV1.Post("/auth/logout", c.Logout)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/auth/login"
+func (c *Controller) Login(ctx *fiber.Ctx) error {
+	var request LoginRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.ClientIp = ctx.IP()
+
+	resp, err := c.authService.Login(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if resp.AccessToken != "" {
+		setSession(ctx, resp.AccessToken, time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second))
+	}
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error login")
+	}
+
+	return nil
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/auth/login/mfa"
+func (c *Controller) LoginMfa(ctx *fiber.Ctx) error {
+	var request MfaRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.ClientIp = ctx.IP()
+
+	resp, err := c.authService.LoginMfa(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if resp.AccessToken != "" {
+		setSession(ctx, resp.AccessToken, time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second))
+	}
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error login")
+	}
+
+	return nil
+}
+
+func (c *Controller) Refresh(ctx *fiber.Ctx) error {
+	var request RefreshRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	resp, err := c.authService.Refresh(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if resp.AccessToken != "" {
+		setSession(ctx, resp.AccessToken, time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second))
+	}
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error refresh token")
+	}
+
+	return nil
+}
+
+func (c *Controller) Logout(ctx *fiber.Ctx) error {
+	var request RefreshRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := c.authService.Logout(request); err != nil {
+		return errResponse(ctx, err)
+	}
+	setSession(ctx, "", time.Unix(0, 0))
+
+	if err := common.OkResponse[any](ctx, nil); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error logout")
+	}
+
+	return nil
+}
+
+// setSession кладёт access-токен в cookie сессии до expires; cookie недоступна скриптам
+// и не уходит с чужих POST-запросов. Пустой токен с прошедшим expires удаляет cookie при выходе
+func setSession(ctx *fiber.Ctx, accessToken string, expires time.Time) {
+	ctx.Cookie(&fiber.Cookie{
+		Name:     SessionCookie,
+		Value:    accessToken,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	var locked common.LockedError
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.UnauthorizedError{}):
+		return common.ErrResponse(ctx, fiber.StatusUnauthorized, err.Error())
+	case errors.As(err, &locked):
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		return common.ErrResponse(ctx, fiber.StatusTooManyRequests, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/web"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// StubSvc выдаёт токены на любой вход, кроме сотрудника со вторым фактором
+type StubSvc struct {
+	Svc
+}
+
+func (StubSvc) Login(request LoginRequest) (TokenResponse, error) {
+	if request.Username == "mfa" {
+		return TokenResponse{MfaRequired: true, MfaToken: "mfa-token"}, nil
+	}
+	return TokenResponse{AccessToken: "access-token", TokenType: "Bearer", ExpiresIn: 900, RefreshToken: "refresh"}, nil
+}
+
+func (StubSvc) Logout(request RefreshRequest) error {
+	return nil
+}
+
+func TestControllerSessionCookie(t *testing.T) {
+	a := assert.New(t)
+	var server = web.NewServer()
+	NewController(server, StubSvc{}).RegisterRoutes()
+
+	var send = func(path string, body string) []string {
+		var req = httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := server.App.Test(req)
+		a.Nil(err)
+		a.Equal(fiber.StatusOK, resp.StatusCode)
+		return resp.Header.Values(fiber.HeaderSetCookie)
+	}
+
+	var cookies = send("/api/v1/auth/login", `{"username":"john","password":"secret"}`)
+	a.Len(cookies, 1)
+	a.True(strings.HasPrefix(cookies[0], SessionCookie+"=access-token;"))
+	a.Contains(cookies[0], "path=/")
+	a.Contains(cookies[0], "HttpOnly")
+	a.Contains(cookies[0], "secure")
+	a.Contains(cookies[0], "SameSite=Lax")
+
+	// до второго фактора сессии ещё нет
+	a.Empty(send("/api/v1/auth/login", `{"username":"mfa","password":"secret"}`))
+
+	cookies = send("/api/v1/auth/logout", `{"refresh_token":"refresh"}`)
+	a.Len(cookies, 1)
+	a.True(strings.HasPrefix(cookies[0], SessionCookie+"=;"))
+	a.Contains(cookies[0], "expires=Thu, 01 Jan 1970")
+}
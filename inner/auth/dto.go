@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"database/sql"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// TokenEntity refresh-токен сессии; сам токен не хранится, только его SHA-256
+type TokenEntity struct {
+	Id         int64        `db:"id"`
+	EmployeeId int64        `db:"employee_id"`
+	FamilyId   string       `db:"family_id"`
+	TokenHash  string       `db:"token_hash"`
+	ExpiresAt  time.Time    `db:"expires_at"`
+	UsedAt     sql.NullTime `db:"used_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+	CreatedAt  time.Time    `db:"created_at"`
+	// Mfa сессия открыта со вторым фактором
+	Mfa bool `db:"mfa"`
+}
+
+// Claims содержимое access-токена: сотрудник и его действующие роли на момент выдачи
+type Claims struct {
+	EmployeeId int64    `json:"employee_id"`
+	Roles      []string `json:"roles"`
+	// Amr способы, которыми сотрудник подтвердил вход
+	Amr []string `json:"amr"`
+	jwt.RegisteredClaims
+}
+
+// mfaClaims содержимое промежуточного токена между проверкой пароля и второго фактора
+type mfaClaims struct {
+	EmployeeId         int64 `json:"employee_id"`
+	MustChangePassword bool  `json:"must_change_password,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenResponse пара токенов в формате ответа OAuth 2.0 либо, если нужен второй фактор, промежуточный токен
+type TokenResponse struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// MustChangePassword пароль выдан администратором или истёк, клиент должен предложить его сменить
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+	// MfaRequired токенов ещё нет: нужно передать MfaToken и код в /auth/login/mfa
+	MfaRequired bool   `json:"mfa_required,omitempty"`
+	MfaToken    string `json:"mfa_token,omitempty"`
+	// MfaEnrollmentRequired часть ролей не выдана, пока сотрудник не подключит второй фактор
+	MfaEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
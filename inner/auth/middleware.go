@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"slices"
+	"strings"
+)
+
+// Схемы заголовка Authorization: access-токен сотрудника и ключ сервисного аккаунта
+const (
+	SchemeBearer = "Bearer"
+	SchemeApiKey = "ApiKey"
+)
+
+// Действия в областях доступа API-ключей: scope имеет вид "<ресурс>:read" или "<ресурс>:write"
+const (
+	ActionRead  = "read"
+	ActionWrite = "write"
+)
+
+// ScopeAdmin область доступа API-ключа к администрированию IdM (см. AdminRoute); дополняет область ресурса
+const ScopeAdmin = "admin"
+
+// principalKey ключ ctx.Locals, под которым middleware сохраняет Principal
+const principalKey = "auth.principal"
+
+// Principal тот, от чьего имени выполняется запрос: сотрудник по access-токену или сервисный аккаунт по API-ключу
+type Principal struct {
+	EmployeeId       int64
+	ServiceAccountId int64
+	// ApiKeyId ключ, которым вошёл сервисный аккаунт
+	ApiKeyId int64
+	Roles    []string
+	// Scopes области доступа API-ключа; у сотрудника пусто, его права определяются ролями
+	Scopes []string
+	// Admin может ли администрировать IdM: у сотрудника есть роль администратора, у API-ключа — область ScopeAdmin
+	Admin bool
+}
+
+// Sessions проверяет access-токен сотрудника, например Service
+type Sessions interface {
+	Verify(accessToken string) (Claims, error)
+}
+
+// ApiKeys проверяет API-ключ сервисного аккаунта, например apikey.Service
+type ApiKeys interface {
+	Authenticate(key string, clientIp string) (Principal, error)
+}
+
+// NewMiddleware создаёт middleware, которое пускает только запросы с действующим access-токеном или API-ключом.
+// Запрос по API-ключу должен иметь область доступа к ресурсу: GET /api/v1/employees требует employees:read,
+// POST, PUT, PATCH и DELETE — employees:write, запросы SCIM — scim:read или scim:write.
+// К администрированию (см. AdminRoute) сотрудник допускается, только если у него есть одна из adminRoles;
+// если это роли, требующие второго фактора, они есть в токене только после входа с ним.
+// API-ключу для администрирования нужна ещё и область ScopeAdmin.
+// Если apiKeys равен nil, API-ключи не принимаются
+func NewMiddleware(sessions Sessions, apiKeys ApiKeys, adminRoles []string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		var scheme, credential, _ = strings.Cut(ctx.Get(fiber.HeaderAuthorization), " ")
+		credential = strings.TrimSpace(credential)
+		var principal Principal
+		var err error
+		switch {
+		case credential == "":
+			err = common.UnauthorizedError{Message: "authorization required"}
+		case strings.EqualFold(scheme, SchemeBearer):
+			var claims Claims
+			if claims, err = sessions.Verify(credential); err == nil {
+				principal = Principal{EmployeeId: claims.EmployeeId, Roles: claims.Roles}
+			}
+		case strings.EqualFold(scheme, SchemeApiKey) && apiKeys != nil:
+			principal, err = apiKeys.Authenticate(credential, ctx.IP())
+		default:
+			err = common.UnauthorizedError{Message: "unsupported authorization scheme"}
+		}
+		if err != nil {
+			if !errors.As(err, &common.UnauthorizedError{}) {
+				return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+			}
+			ctx.Set(fiber.HeaderWWWAuthenticate, SchemeBearer+", "+SchemeApiKey)
+			return common.ErrResponse(ctx, fiber.StatusUnauthorized, err.Error())
+		}
+
+		if principal.ServiceAccountId != 0 {
+			var scope = RequiredScope(ctx.Method(), ctx.Path())
+			if !slices.Contains(principal.Scopes, scope) {
+				return common.ErrResponse(ctx, fiber.StatusForbidden, "api key has no scope "+scope)
+			}
+			principal.Admin = slices.Contains(principal.Scopes, ScopeAdmin)
+		} else {
+			principal.Admin = slices.ContainsFunc(principal.Roles, func(role string) bool {
+				return slices.Contains(adminRoles, role)
+			})
+		}
+		if AdminRoute(ctx.Method(), ctx.Path()) && !principal.Admin {
+			if principal.ServiceAccountId != 0 {
+				return common.ErrResponse(ctx, fiber.StatusForbidden, "api key has no scope "+ScopeAdmin)
+			}
+			return common.ErrResponse(ctx, fiber.StatusForbidden, "administrator role required")
+		}
+		ctx.Locals(principalKey, principal)
+		return ctx.Next()
+	}
+}
+
+// PrincipalFrom возвращает Principal, сохранённый middleware; false, если запрос прошёл без него
+func PrincipalFrom(ctx *fiber.Ctx) (Principal, bool) {
+	principal, ok := ctx.Locals(principalKey).(Principal)
+	return principal, ok
+}
+
+// RequiredScope область доступа для запроса: ресурс — первый сегмент пути после "/api/v1/", для SCIM — scim
+func RequiredScope(method string, path string) string {
+	path = strings.ToLower(path)
+	var resource string
+	switch {
+	case strings.HasPrefix(path, "/scim/"):
+		resource = "scim"
+	default:
+		var rest = strings.TrimPrefix(strings.TrimPrefix(path, "/api/v1"), "/")
+		resource, _, _ = strings.Cut(rest, "/")
+	}
+	var action = ActionWrite
+	if readMethod(method) {
+		action = ActionRead
+	}
+	return resource + ":" + action
+}
+
+func readMethod(method string) bool {
+	return method == fiber.MethodGet || method == fiber.MethodHead || method == fiber.MethodOptions
+}
+
+// adminResources ресурсы, все запросы к которым, включая чтение, — администрирование IdM
+var adminResources = []string{"service-accounts", "signing-keys", "oidc", "lockouts"}
+
+// selfServiceRoutes изменяющие запросы, которые сотрудник выполняет сам, без роли администратора:
+// вход, смена своего пароля, подключение второго фактора, заявки на доступ и решения по ним, решения пересматривающих.
+// Сегмент "*" — параметр пути. Права на конкретный объект проверяет сервис
+var selfServiceRoutes = []string{
+	"auth/login",
+	"auth/login/mfa",
+	"auth/refresh",
+	"auth/logout",
+	"employees/*/password/change",
+	"employees/*/mfa/totp",
+	"employees/*/mfa/totp/confirm",
+	"access-requests",
+	"access-requests/*/approve",
+	"access-requests/*/reject",
+	"access-requests/*/cancel",
+	"reviews/*/items/*/certify",
+	"reviews/*/items/*/revoke",
+}
+
+// AdminRoute относится ли запрос к администрированию IdM. Администрирование — любое изменение через /api/v1/
+// и SCIM, кроме selfServiceRoutes, а также чтение сервисных аккаунтов, ключей подписи, клиентов OIDC и блокировок.
+// Путь сравнивается без учёта регистра и завершающего "/", как его сопоставляет fiber
+func AdminRoute(method string, path string) bool {
+	path = strings.TrimRight(strings.ToLower(path), "/")
+	if strings.HasPrefix(path, "/scim/") {
+		return !readMethod(method)
+	}
+	rest, ok := strings.CutPrefix(path, "/api/v1/")
+	if !ok {
+		return false
+	}
+	var segments = strings.Split(rest, "/")
+	if slices.Contains(adminResources, segments[0]) ||
+		(segments[0] == "employees" && len(segments) == 3 && segments[2] == "lockout") {
+		return true
+	}
+	if readMethod(method) {
+		return false
+	}
+	return !slices.ContainsFunc(selfServiceRoutes, func(route string) bool {
+		return matchRoute(strings.Split(route, "/"), segments)
+	})
+}
+
+func matchRoute(route []string, segments []string) bool {
+	return slices.EqualFunc(route, segments, func(r string, s string) bool {
+		return r == "*" || r == s
+	})
+}
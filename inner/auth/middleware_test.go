@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/common"
+	"net/http/httptest"
+	"testing"
+)
+
+// StubSessions принимает access-токены "employee-token" сотрудника без ролей и "admin-token" администратора
+type StubSessions struct{}
+
+func (StubSessions) Verify(accessToken string) (Claims, error) {
+	switch accessToken {
+	case "employee-token":
+		return Claims{EmployeeId: 2, Roles: []string{"auditor"}}, nil
+	case "admin-token":
+		return Claims{EmployeeId: 1, Roles: []string{"admin"}}, nil
+	}
+	return Claims{}, common.UnauthorizedError{Message: "invalid access token"}
+}
+
+// StubApiKeys принимает ключ "ci-key" с правом читать сотрудников, "hr-key" с правом их менять
+// и "admin-key" с правом менять сотрудников и администрировать IdM
+type StubApiKeys struct{}
+
+func (StubApiKeys) Authenticate(key string, clientIp string) (Principal, error) {
+	switch key {
+	case "ci-key":
+		return Principal{ServiceAccountId: 5, Scopes: []string{"employees:read"}}, nil
+	case "hr-key":
+		return Principal{ServiceAccountId: 6, Scopes: []string{"employees:write"}}, nil
+	case "admin-key":
+		return Principal{ServiceAccountId: 7, Scopes: []string{"employees:write", ScopeAdmin}}, nil
+	}
+	return Principal{}, common.UnauthorizedError{Message: "invalid api key"}
+}
+
+func newMiddlewareApp(apiKeys ApiKeys) *fiber.App {
+	var app = fiber.New()
+	var handler = func(ctx *fiber.Ctx) error {
+		principal, _ := PrincipalFrom(ctx)
+		return ctx.JSON(principal)
+	}
+	app.Use(NewMiddleware(StubSessions{}, apiKeys, []string{"admin"}))
+	app.Get("/api/v1/employees", handler)
+	app.Post("/api/v1/employees", handler)
+	app.Post("/api/v1/signing-keys/rotate", handler)
+	app.Post("/api/v1/employees/:id/password/reset", handler)
+	app.Post("/api/v1/employees/:id/password/change", handler)
+	app.Patch("/scim/v2/Groups/:id", handler)
+	return app
+}
+
+func request(app *fiber.App, method string, authorization string) int {
+	return requestPath(app, method, "/api/v1/employees", authorization)
+}
+
+func requestPath(app *fiber.App, method string, path string, authorization string) int {
+	var req = httptest.NewRequest(method, path, nil)
+	if authorization != "" {
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		panic(err)
+	}
+	return resp.StatusCode
+}
+
+func TestMiddleware(t *testing.T) {
+	var a = assert.New(t)
+	var app = newMiddlewareApp(StubApiKeys{})
+
+	a.Equal(fiber.StatusUnauthorized, request(app, fiber.MethodGet, ""))
+	a.Equal(fiber.StatusUnauthorized, request(app, fiber.MethodGet, "Bearer wrong"))
+	a.Equal(fiber.StatusUnauthorized, request(app, fiber.MethodGet, "ApiKey wrong"))
+	a.Equal(fiber.StatusUnauthorized, request(app, fiber.MethodGet, "Basic Y2k6a2V5"))
+
+	a.Equal(fiber.StatusOK, request(app, fiber.MethodGet, "Bearer employee-token"))
+	a.Equal(fiber.StatusForbidden, request(app, fiber.MethodPost, "Bearer employee-token"))
+	a.Equal(fiber.StatusOK, request(app, fiber.MethodPost, "Bearer admin-token"))
+	a.Equal(fiber.StatusOK, request(app, fiber.MethodGet, "ApiKey ci-key"))
+	a.Equal(fiber.StatusForbidden, request(app, fiber.MethodPost, "ApiKey ci-key"), "key has no employees:write")
+
+	t.Run("admin routes require admin role", func(t *testing.T) {
+		for _, path := range []string{"/api/v1/signing-keys/rotate", "/API/v1/Signing-Keys/rotate/", "/api/v1/employees/1/password/reset"} {
+			a.Equal(fiber.StatusForbidden, requestPath(app, fiber.MethodPost, path, "Bearer employee-token"), path)
+			a.Equal(fiber.StatusOK, requestPath(app, fiber.MethodPost, path, "Bearer admin-token"), path)
+		}
+		a.Equal(fiber.StatusForbidden, requestPath(app, fiber.MethodPatch, "/scim/v2/Groups/1", "Bearer employee-token"))
+		a.Equal(fiber.StatusOK, requestPath(app, fiber.MethodPost, "/api/v1/employees/2/password/change", "Bearer employee-token"))
+	})
+
+	t.Run("admin routes require admin scope of api key", func(t *testing.T) {
+		var path = "/api/v1/employees/1/password/reset"
+		a.Equal(fiber.StatusForbidden, requestPath(app, fiber.MethodPost, path, "ApiKey hr-key"))
+		a.Equal(fiber.StatusForbidden, request(app, fiber.MethodPost, "ApiKey hr-key"))
+		a.Equal(fiber.StatusOK, requestPath(app, fiber.MethodPost, path, "ApiKey admin-key"))
+		a.Equal(fiber.StatusOK, request(app, fiber.MethodPost, "ApiKey admin-key"))
+	})
+
+	t.Run("api keys disabled", func(t *testing.T) {
+		var app = newMiddlewareApp(nil)
+		a.Equal(fiber.StatusUnauthorized, request(app, fiber.MethodGet, "ApiKey ci-key"))
+	})
+}
+
+func TestAdminRoute(t *testing.T) {
+	var a = assert.New(t)
+	a.True(AdminRoute(fiber.MethodGet, "/api/v1/service-accounts"))
+	a.True(AdminRoute(fiber.MethodDelete, "/api/v1/signing-keys/abc"))
+	a.True(AdminRoute(fiber.MethodPost, "/api/v1/oidc/clients"))
+	a.True(AdminRoute(fiber.MethodDelete, "/api/v1/lockouts/ip/10.0.0.1"))
+	a.True(AdminRoute(fiber.MethodDelete, "/api/v1/employees/1/lockout"))
+	a.True(AdminRoute(fiber.MethodPut, "/api/v1/employees/1/password"))
+	a.True(AdminRoute(fiber.MethodPost, "/api/v1/employees/1/password/reset"))
+	a.True(AdminRoute(fiber.MethodDelete, "/api/v1/employees/1/mfa"))
+	a.True(AdminRoute(fiber.MethodPost, "/api/v1/employees/import"))
+	a.True(AdminRoute(fiber.MethodPost, "/API/V1/Service-Accounts/"))
+	a.True(AdminRoute(fiber.MethodGet, "/api/v1/employees/1/lockout"))
+	// изменения запрещены по умолчанию
+	a.True(AdminRoute(fiber.MethodPut, "/api/v1/employees/1"))
+	a.True(AdminRoute(fiber.MethodPut, "/api/v1/employees/1/manager"))
+	a.True(AdminRoute(fiber.MethodDelete, "/api/v1/employees/1"))
+	a.True(AdminRoute(fiber.MethodPost, "/api/v1/employees/delete"))
+	a.True(AdminRoute(fiber.MethodPost, "/api/v1/assignment-rules/evaluate"))
+	a.True(AdminRoute(fiber.MethodPost, "/api/v1/sod/rules/1/exceptions"))
+	a.True(AdminRoute(fiber.MethodPut, "/api/v1/roles/1/approval-policy"))
+	a.True(AdminRoute(fiber.MethodPost, "/api/v1/reviews"))
+	a.True(AdminRoute(fiber.MethodPost, "/api/v1/reconciliations"))
+	a.True(AdminRoute(fiber.MethodPut, "/api/v1/org-units/1/members/2"))
+	a.True(AdminRoute(fiber.MethodPatch, "/scim/v2/Groups/1"))
+	a.True(AdminRoute(fiber.MethodPost, "/scim/v2/Users"))
+
+	a.False(AdminRoute(fiber.MethodGet, "/api/v1/employees"))
+	a.False(AdminRoute(fiber.MethodPost, "/api/v1/employees/1/password/change"))
+	a.False(AdminRoute(fiber.MethodGet, "/api/v1/employees/1/password"))
+	a.False(AdminRoute(fiber.MethodPost, "/api/v1/employees/1/mfa/totp"))
+	a.False(AdminRoute(fiber.MethodPost, "/api/v1/employees/1/mfa/totp/confirm/"))
+	a.False(AdminRoute(fiber.MethodPost, "/api/v1/access-requests"))
+	a.False(AdminRoute(fiber.MethodPost, "/api/v1/access-requests/3/approve"))
+	a.False(AdminRoute(fiber.MethodPost, "/api/v1/reviews/1/items/2/certify"))
+	a.False(AdminRoute(fiber.MethodPost, "/api/v1/auth/logout"))
+	a.False(AdminRoute(fiber.MethodGet, "/scim/v2/Users"))
+}
+
+func TestRequiredScope(t *testing.T) {
+	var a = assert.New(t)
+	a.Equal("employees:read", RequiredScope(fiber.MethodGet, "/api/v1/employees/1/roles"))
+	a.Equal("employees:write", RequiredScope(fiber.MethodDelete, "/api/v1/employees/1"))
+	a.Equal("roles:write", RequiredScope(fiber.MethodPost, "/api/v1/roles"))
+	a.Equal("scim:read", RequiredScope(fiber.MethodGet, "/scim/v2/Users"))
+}
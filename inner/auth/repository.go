@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// FindEmployeeIdByName возвращает id работающего сотрудника по имени, которое служит логином; sql.ErrNoRows, если его нет
+func (r *Repository) FindEmployeeIdByName(name string) (id int64, err error) {
+	err = r.db.Get(&id, "SELECT id FROM employee WHERE name = $1 AND terminated_at IS NULL ORDER BY id LIMIT 1", name)
+	return
+}
+
+// FindRoles возвращает названия действующих ролей сотрудника
+func (r *Repository) FindRoles(employeeId int64) (roles []string, err error) {
+	query := `SELECT r.name FROM effective_employee_role er
+              JOIN role r ON r.id = er.role_id
+              WHERE er.employee_id = $1
+              ORDER BY r.name`
+	err = r.db.Select(&roles, query, employeeId)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) CreateTokenTx(tx *sqlx.Tx, e *TokenEntity) error {
+	query := `INSERT INTO refresh_token (employee_id, family_id, token_hash, expires_at, mfa)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING *`
+	return tx.Get(e, query, e.EmployeeId, e.FamilyId, e.TokenHash, e.ExpiresAt, e.Mfa)
+}
+
+// FindTokenTx находит токен по хешу и блокирует его, чтобы один токен нельзя было обменять дважды параллельно.
+// Токены уволенных сотрудников не находятся
+func (r *Repository) FindTokenTx(tx *sqlx.Tx, hash string) (token TokenEntity, err error) {
+	query := `SELECT t.* FROM refresh_token t JOIN employee e ON e.id = t.employee_id AND e.terminated_at IS NULL
+              WHERE t.token_hash = $1 FOR UPDATE OF t`
+	err = tx.Get(&token, query, hash)
+	return
+}
+
+// MarkUsedTx отмечает, что токен обменян на новый
+func (r *Repository) MarkUsedTx(tx *sqlx.Tx, id int64, at time.Time) error {
+	_, err := tx.Exec("UPDATE refresh_token SET used_at = $2 WHERE id = $1", id, at)
+	return err
+}
+
+// RevokeFamilyTx отзывает все ещё не отозванные токены сессии
+func (r *Repository) RevokeFamilyTx(tx *sqlx.Tx, familyId string, at time.Time) error {
+	query := "UPDATE refresh_token SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL"
+	_, err := tx.Exec(query, familyId, at)
+	return err
+}
@@ -0,0 +1,444 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Issuer издатель access-токенов, claim iss
+const Issuer = "idm"
+
+// Аудитории токенов, claim aud: access-токен для API и промежуточный токен между паролем и вторым фактором
+const (
+	AccessAudience = "idm"
+	MfaAudience    = "idm:mfa"
+)
+
+// Способы входа для claim amr по RFC 8176
+const (
+	AmrPassword = "pwd"
+	AmrOtp      = "otp"
+)
+
+// mfaTokenTTL сколько после ввода пароля можно ввести код второго фактора
+const mfaTokenTTL = 5 * time.Minute
+
+// Структура сервиса входа и выдачи токенов
+type Service struct {
+	repo      Repo
+	validator Validator
+	passwords Passwords
+	tokens    TokenConfig
+	throttle  Throttle
+	mfa       Mfa
+	now       func() time.Time
+}
+
+// TokenConfig ключи подписи и сроки жизни токенов
+type TokenConfig struct {
+	// Keys ключи подписи access-токенов и промежуточных токенов второго фактора, например signingkey.Service
+	Keys       Keys
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// Keys хранилище ключей подписи: токены подписываются активным ключом и проверяются по kid,
+// поэтому ротация и отзыв ключа действуют и на сессии сотрудников
+type Keys interface {
+	// Sign подписывает токен активным ключом, заменяя метод подписи алгоритмом ключа
+	Sign(token *jwt.Token) (string, error)
+	// Key возвращает открытый ключ для проверки токена по kid из заголовка
+	Key(token *jwt.Token) (any, error)
+	// Algorithms алгоритмы подписи опубликованных ключей
+	Algorithms() []string
+}
+
+// LoginRequest вход по имени сотрудника и локальному паролю
+type LoginRequest struct {
+	Username string `json:"username" validate:"required,max=155"`
+	Password string `json:"password" validate:"required,max=128"`
+	// ClientIp адрес клиента, по которому считаются неудачные попытки
+	ClientIp string `json:"-"`
+}
+
+// MfaRequest второй шаг входа: промежуточный токен из ответа Login и код из приложения или код восстановления
+type MfaRequest struct {
+	MfaToken string `json:"mfa_token" validate:"required,max=1024"`
+	Code     string `json:"code" validate:"required,max=32"`
+	ClientIp string `json:"-"`
+}
+
+// RefreshRequest refresh-токен для обновления пары токенов или выхода
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=128"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+// Passwords проверяет пароль сотрудника, например credential.Service
+type Passwords interface {
+	Authenticate(employeeId int64, password string) (mustChange bool, err error)
+}
+
+// Throttle считает неудачные входы и блокирует перебор паролей, например lockout.Service
+// Если сотрудник не найден, employeeId равен 0 и попытки считаются по введённому имени username
+type Throttle interface {
+	Check(employeeId int64, username string, ip string) error
+	Fail(employeeId int64, username string, ip string) error
+	Succeed(employeeId int64) error
+}
+
+// Mfa второй фактор входа, например mfa.Service
+type Mfa interface {
+	Enabled(employeeId int64) (bool, error)
+	Verify(employeeId int64, code string) error
+	// Privileged роль попадает в access-токен только при входе со вторым фактором
+	Privileged(role string) bool
+}
+
+type Repo interface {
+	FindEmployeeIdByName(string) (int64, error)
+	FindRoles(int64) ([]string, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	CreateTokenTx(*sqlx.Tx, *TokenEntity) error
+	FindTokenTx(*sqlx.Tx, string) (TokenEntity, error)
+	MarkUsedTx(*sqlx.Tx, int64, time.Time) error
+	RevokeFamilyTx(*sqlx.Tx, string, time.Time) error
+}
+
+// NewService создаёт сервис входа. Если throttle равен nil, неудачные попытки не ограничиваются,
+// если mfa равен nil, вход только по паролю
+func NewService(
+	repo Repo,
+	validator Validator,
+	passwords Passwords,
+	tokens TokenConfig,
+	throttle Throttle,
+	mfa Mfa,
+) *Service {
+	return &Service{
+		repo:      repo,
+		validator: validator,
+		passwords: passwords,
+		tokens:    tokens,
+		throttle:  throttle,
+		mfa:       mfa,
+		now:       time.Now,
+	}
+}
+
+// Login проверяет пароль и открывает новую сессию: выдаёт access-токен и первый refresh-токен сессии.
+// Если у сотрудника включён второй фактор, вместо токенов возвращается промежуточный токен для LoginMfa.
+// Неверное имя или пароль — common.UnauthorizedError, без уточнения, что именно неверно;
+// после череды неудачных попыток — common.LockedError, и пароль уже не проверяется
+func (srv *Service) Login(request LoginRequest) (TokenResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return TokenResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	employeeId, err := srv.repo.FindEmployeeIdByName(request.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return TokenResponse{}, fmt.Errorf("error finding employee %q: %w", request.Username, err)
+	}
+	if srv.throttle != nil {
+		if err = srv.throttle.Check(employeeId, request.Username, request.ClientIp); err != nil {
+			return TokenResponse{}, err
+		}
+	}
+	// неизвестный сотрудник проверяется как сотрудник без пароля, чтобы ответ не выдавал, что его нет
+	mustChange, err := srv.passwords.Authenticate(employeeId, request.Password)
+	if errors.As(err, &common.UnauthorizedError{}) && srv.throttle != nil {
+		if errFail := srv.throttle.Fail(employeeId, request.Username, request.ClientIp); errFail != nil {
+			return TokenResponse{}, fmt.Errorf("error counting failed login: %w", errFail)
+		}
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	if srv.mfa != nil {
+		enabled, err := srv.mfa.Enabled(employeeId)
+		if err != nil {
+			return TokenResponse{}, fmt.Errorf("error checking mfa of employee %d: %w", employeeId, err)
+		}
+		if enabled {
+			// счётчик неудачных попыток сбрасывается только после второго фактора
+			token, err := srv.mfaToken(employeeId, mustChange)
+			if err != nil {
+				return TokenResponse{}, err
+			}
+			return TokenResponse{MfaRequired: true, MfaToken: token, MustChangePassword: mustChange}, nil
+		}
+	}
+	return srv.open(employeeId, false, mustChange)
+}
+
+// LoginMfa завершает вход сотрудника со вторым фактором. Неверный код — common.UnauthorizedError,
+// неудачные попытки считаются вместе с неудачными вводами пароля
+func (srv *Service) LoginMfa(request MfaRequest) (TokenResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return TokenResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	var claims mfaClaims
+	if err = srv.parse(request.MfaToken, MfaAudience, &claims); err != nil || srv.mfa == nil {
+		return TokenResponse{}, common.UnauthorizedError{Message: "mfa token is not valid"}
+	}
+	if srv.throttle != nil {
+		if err = srv.throttle.Check(claims.EmployeeId, "", request.ClientIp); err != nil {
+			return TokenResponse{}, err
+		}
+	}
+	err = srv.mfa.Verify(claims.EmployeeId, request.Code)
+	if errors.As(err, &common.UnauthorizedError{}) && srv.throttle != nil {
+		if errFail := srv.throttle.Fail(claims.EmployeeId, "", request.ClientIp); errFail != nil {
+			return TokenResponse{}, fmt.Errorf("error counting failed login: %w", errFail)
+		}
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	return srv.open(claims.EmployeeId, true, claims.MustChangePassword)
+}
+
+// Refresh обменивает refresh-токен на новую пару токенов; старый токен больше не действует.
+// Повторное предъявление уже обменянного токена означает, что он украден, поэтому отзывается вся сессия
+func (srv *Service) Refresh(request RefreshRequest) (TokenResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return TokenResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	var now = srv.now()
+	var employeeId int64
+	var mfa bool
+	var refresh string
+	var reused bool
+	err = database.InTransaction(srv.repo.BeginTransaction, "refreshing token", func(tx *sqlx.Tx) error {
+		token, err := srv.findToken(tx, request.RefreshToken)
+		if err != nil {
+			return err
+		}
+		if token.UsedAt.Valid || token.RevokedAt.Valid {
+			// отзыв сессии должен закоммититься, поэтому ошибка возвращается уже после транзакции
+			reused = token.UsedAt.Valid && !token.RevokedAt.Valid
+			if err = srv.repo.RevokeFamilyTx(tx, token.FamilyId, now); err != nil {
+				return fmt.Errorf("error revoking session of employee %d: %w", token.EmployeeId, err)
+			}
+			return nil
+		}
+		if !now.Before(token.ExpiresAt) {
+			return common.UnauthorizedError{Message: "refresh token has expired"}
+		}
+		if err = srv.repo.MarkUsedTx(tx, token.Id, now); err != nil {
+			return fmt.Errorf("error rotating refresh token of employee %d: %w", token.EmployeeId, err)
+		}
+		employeeId, mfa = token.EmployeeId, token.Mfa
+		refresh, err = srv.createToken(tx, token.EmployeeId, token.FamilyId, token.Mfa, now)
+		return err
+	})
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if refresh == "" {
+		if reused {
+			log.Printf("auth: reused refresh token, session revoked")
+		}
+		return TokenResponse{}, common.UnauthorizedError{Message: "refresh token is no longer valid"}
+	}
+	return srv.response(employeeId, refresh, mfa, now)
+}
+
+// Logout завершает сессию: отзывает все её refresh-токены. Неизвестный токен не считается ошибкой
+func (srv *Service) Logout(request RefreshRequest) error {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return common.RequestValidationError{Message: err.Error()}
+	}
+	err = database.InTransaction(srv.repo.BeginTransaction, "logout", func(tx *sqlx.Tx) error {
+		token, err := srv.findToken(tx, request.RefreshToken)
+		if err != nil {
+			return err
+		}
+		if err = srv.repo.RevokeFamilyTx(tx, token.FamilyId, srv.now()); err != nil {
+			return fmt.Errorf("error revoking session of employee %d: %w", token.EmployeeId, err)
+		}
+		return nil
+	})
+	if errors.As(err, &common.UnauthorizedError{}) {
+		return nil
+	}
+	return err
+}
+
+// Verify проверяет подпись и срок действия access-токена и возвращает его claims
+func (srv *Service) Verify(accessToken string) (Claims, error) {
+	var claims Claims
+	if err := srv.parse(accessToken, AccessAudience, &claims); err != nil {
+		return Claims{}, common.UnauthorizedError{Message: fmt.Sprintf("invalid access token: %v", err)}
+	}
+	return claims, nil
+}
+
+// parse проверяет подпись, издателя, аудиторию и срок действия токена
+func (srv *Service) parse(token string, audience string, claims jwt.Claims) error {
+	var parser = jwt.NewParser(
+		jwt.WithValidMethods(srv.tokens.Keys.Algorithms()),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(srv.now),
+	)
+	_, err := parser.ParseWithClaims(token, claims, srv.tokens.Keys.Key)
+	return err
+}
+
+// mfaToken подписывает промежуточный токен, подтверждающий, что пароль уже проверен
+func (srv *Service) mfaToken(employeeId int64, mustChange bool) (string, error) {
+	var now = srv.now()
+	var claims = mfaClaims{
+		EmployeeId:         employeeId,
+		MustChangePassword: mustChange,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   strconv.FormatInt(employeeId, 10),
+			Audience:  jwt.ClaimStrings{MfaAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenTTL)),
+		},
+	}
+	token, err := srv.tokens.Keys.Sign(jwt.NewWithClaims(jwt.SigningMethodRS256, claims))
+	if err != nil {
+		return "", fmt.Errorf("error signing mfa token: %w", err)
+	}
+	return token, nil
+}
+
+// open открывает сессию после проверки всех факторов: выдаёт access-токен и первый refresh-токен сессии
+func (srv *Service) open(employeeId int64, mfa bool, mustChange bool) (TokenResponse, error) {
+	if srv.throttle != nil {
+		if err := srv.throttle.Succeed(employeeId); err != nil {
+			return TokenResponse{}, fmt.Errorf("error resetting failed logins: %w", err)
+		}
+	}
+	familyId, err := randomToken(16)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("error generating session id: %w", err)
+	}
+	var now = srv.now()
+	var refresh string
+	err = database.InTransaction(srv.repo.BeginTransaction, "login", func(tx *sqlx.Tx) (err error) {
+		refresh, err = srv.createToken(tx, employeeId, familyId, mfa, now)
+		return err
+	})
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	resp, err := srv.response(employeeId, refresh, mfa, now)
+	resp.MustChangePassword = mustChange
+	return resp, err
+}
+
+func (srv *Service) findToken(tx *sqlx.Tx, refreshToken string) (TokenEntity, error) {
+	token, err := srv.repo.FindTokenTx(tx, hashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return TokenEntity{}, common.UnauthorizedError{Message: "refresh token is not valid"}
+	}
+	if err != nil {
+		return TokenEntity{}, fmt.Errorf("error finding refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// createToken выпускает refresh-токен сессии familyId и сохраняет его хеш
+func (srv *Service) createToken(tx *sqlx.Tx, employeeId int64, familyId string, mfa bool, now time.Time) (string, error) {
+	refresh, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("error generating refresh token: %w", err)
+	}
+	var token = TokenEntity{
+		EmployeeId: employeeId,
+		FamilyId:   familyId,
+		TokenHash:  hashToken(refresh),
+		ExpiresAt:  now.Add(srv.tokens.RefreshTTL),
+		Mfa:        mfa,
+	}
+	if err = srv.repo.CreateTokenTx(tx, &token); err != nil {
+		return "", fmt.Errorf("error saving refresh token of employee %d: %w", employeeId, err)
+	}
+	return refresh, nil
+}
+
+// response подписывает access-токен с ролями, действующими на момент выдачи.
+// В сессии без второго фактора привилегированные роли в токен не попадают
+func (srv *Service) response(employeeId int64, refresh string, mfa bool, now time.Time) (TokenResponse, error) {
+	all, err := srv.repo.FindRoles(employeeId)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("error finding roles of employee %d: %w", employeeId, err)
+	}
+	var roles = make([]string, 0, len(all))
+	var withheld bool
+	for _, role := range all {
+		if !mfa && srv.mfa != nil && srv.mfa.Privileged(role) {
+			withheld = true
+			continue
+		}
+		roles = append(roles, role)
+	}
+	var amr = []string{AmrPassword}
+	if mfa {
+		amr = append(amr, AmrOtp)
+	}
+	var claims = Claims{
+		EmployeeId: employeeId,
+		Roles:      roles,
+		Amr:        amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   strconv.FormatInt(employeeId, 10),
+			Audience:  jwt.ClaimStrings{AccessAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(srv.tokens.AccessTTL)),
+		},
+	}
+	access, err := srv.tokens.Keys.Sign(jwt.NewWithClaims(jwt.SigningMethodRS256, claims))
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("error signing access token: %w", err)
+	}
+	return TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(srv.tokens.AccessTTL / time.Second),
+		RefreshToken: refresh,
+		// сотрудник с привилегированными ролями должен подключить второй фактор, чтобы их получить
+		MfaEnrollmentRequired: withheld,
+	}, nil
+}
+
+// randomToken случайная строка из size байт в base64url
+func randomToken(size int) (string, error) {
+	var buf = make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken SHA-256 токена; у случайного токена достаточно энтропии, медленный хеш не нужен
+func hashToken(token string) string {
+	var sum = sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
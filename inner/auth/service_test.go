@@ -0,0 +1,429 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindEmployeeIdByName(name string) (int64, error) {
+	args := m.Called(name)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindRoles(employeeId int64) ([]string, error) {
+	args := m.Called(employeeId)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) CreateTokenTx(tx *sqlx.Tx, e *TokenEntity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) FindTokenTx(tx *sqlx.Tx, hash string) (TokenEntity, error) {
+	args := m.Called(tx, hash)
+	return args.Get(0).(TokenEntity), args.Error(1)
+}
+
+func (m *MockRepo) MarkUsedTx(tx *sqlx.Tx, id int64, at time.Time) error {
+	args := m.Called(tx, id, at)
+	return args.Error(0)
+}
+
+func (m *MockRepo) RevokeFamilyTx(tx *sqlx.Tx, familyId string, at time.Time) error {
+	args := m.Called(tx, familyId, at)
+	return args.Error(0)
+}
+
+// StubPasswords принимает только пароль "secret" сотрудника 1
+type StubPasswords struct {
+	mustChange bool
+}
+
+func (s StubPasswords) Authenticate(employeeId int64, password string) (bool, error) {
+	if employeeId != 1 || password != "secret" {
+		return false, common.UnauthorizedError{Message: "invalid username or password"}
+	}
+	return s.mustChange, nil
+}
+
+// StubThrottle блокирует вход, если locked, и запоминает учтённые попытки
+type StubThrottle struct {
+	locked    bool
+	failed    []string
+	succeeded []int64
+}
+
+func (s *StubThrottle) Check(employeeId int64, username string, ip string) error {
+	if s.locked {
+		return common.LockedError{Message: "too many failed login attempts, try again later", RetryAfter: time.Minute}
+	}
+	return nil
+}
+
+func (s *StubThrottle) Fail(employeeId int64, username string, ip string) error {
+	s.failed = append(s.failed, fmt.Sprintf("%d:%s@%s", employeeId, username, ip))
+	return nil
+}
+
+func (s *StubThrottle) Succeed(employeeId int64) error {
+	s.succeeded = append(s.succeeded, employeeId)
+	return nil
+}
+
+// StubMfa второй фактор включён у сотрудника 1, код "123456"; роль admin требует второго фактора
+type StubMfa struct {
+	enabled bool
+}
+
+func (s StubMfa) Enabled(employeeId int64) (bool, error) {
+	return s.enabled && employeeId == 1, nil
+}
+
+func (s StubMfa) Verify(employeeId int64, code string) error {
+	if !s.enabled || employeeId != 1 || code != "123456" {
+		return common.UnauthorizedError{Message: "invalid mfa code"}
+	}
+	return nil
+}
+
+func (s StubMfa) Privileged(role string) bool {
+	return role == "admin"
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+var now = time.Date(2025, 12, 10, 12, 0, 0, 0, time.UTC)
+
+// StubKeys подписывает токены HS256 одним ключом с kid "test"
+type StubKeys struct {
+	secret []byte
+}
+
+func (k StubKeys) Sign(token *jwt.Token) (string, error) {
+	token.Method = jwt.SigningMethodHS256
+	token.Header["alg"] = jwt.SigningMethodHS256.Alg()
+	token.Header["kid"] = "test"
+	return token.SignedString(k.secret)
+}
+
+func (k StubKeys) Key(token *jwt.Token) (any, error) {
+	if token.Header["kid"] != "test" {
+		return nil, errors.New("unknown signing key")
+	}
+	return k.secret, nil
+}
+
+func (k StubKeys) Algorithms() []string {
+	return []string{jwt.SigningMethodHS256.Alg()}
+}
+
+var tokens = TokenConfig{
+	Keys:       StubKeys{secret: []byte("0123456789abcdef0123456789abcdef")},
+	AccessTTL:  15 * time.Minute,
+	RefreshTTL: 24 * time.Hour,
+}
+
+func newService(repo Repo, passwords Passwords) *Service {
+	var svc = NewService(repo, validator.New(), passwords, tokens, nil, nil)
+	svc.now = func() time.Time { return now }
+	return svc
+}
+
+func TestLogin(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should issue access token with roles and refresh token", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, StubPasswords{mustChange: true})
+		tx, sqlMock := newTx(a, true)
+		repo.On("FindEmployeeIdByName", "John Doe").Return(int64(1), nil)
+		repo.On("BeginTransaction").Return(tx, nil)
+		var saved *TokenEntity
+		repo.On("CreateTokenTx", tx, mock.MatchedBy(func(e *TokenEntity) bool {
+			saved = e
+			return e.EmployeeId == 1 && e.FamilyId != "" && e.ExpiresAt.Equal(now.Add(tokens.RefreshTTL))
+		})).Return(nil)
+		repo.On("FindRoles", int64(1)).Return([]string{"admin", "auditor"}, nil)
+
+		var resp, err = svc.Login(LoginRequest{Username: "John Doe", Password: "secret"})
+		a.Nil(err)
+		a.Equal("Bearer", resp.TokenType)
+		a.Equal(int64(900), resp.ExpiresIn)
+		a.True(resp.MustChangePassword)
+		// в базе лежит только хеш refresh-токена
+		a.Equal(hashToken(resp.RefreshToken), saved.TokenHash)
+		a.NotEqual(resp.RefreshToken, saved.TokenHash)
+
+		claims, err := svc.Verify(resp.AccessToken)
+		a.Nil(err)
+		a.Equal(int64(1), claims.EmployeeId)
+		a.Equal("1", claims.Subject)
+		a.Equal([]string{"admin", "auditor"}, claims.Roles)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject unknown employee like wrong password", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, StubPasswords{})
+		repo.On("FindEmployeeIdByName", "Nobody").Return(int64(0), sql.ErrNoRows)
+		repo.On("FindEmployeeIdByName", "John Doe").Return(int64(1), nil)
+		var _, unknown = svc.Login(LoginRequest{Username: "Nobody", Password: "secret"})
+		var _, wrong = svc.Login(LoginRequest{Username: "John Doe", Password: "wrong"})
+		a.ErrorAs(unknown, &common.UnauthorizedError{})
+		a.Equal(wrong, unknown)
+		repo.AssertNotCalled(t, "BeginTransaction")
+	})
+}
+
+func TestLoginThrottle(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should not check password of locked account", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var throttle = &StubThrottle{locked: true}
+		var svc = NewService(repo, validator.New(), StubPasswords{}, tokens, throttle, nil)
+		repo.On("FindEmployeeIdByName", "John Doe").Return(int64(1), nil)
+		var _, err = svc.Login(LoginRequest{Username: "John Doe", Password: "secret", ClientIp: "10.0.0.1"})
+		a.ErrorAs(err, &common.LockedError{})
+		a.Empty(throttle.failed)
+		repo.AssertNotCalled(t, "BeginTransaction")
+	})
+
+	t.Run("should count failed and reset after successful login", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var throttle = &StubThrottle{}
+		var svc = NewService(repo, validator.New(), StubPasswords{}, tokens, throttle, nil)
+		svc.now = func() time.Time { return now }
+		tx, _ := newTx(a, true)
+		repo.On("FindEmployeeIdByName", "John Doe").Return(int64(1), nil)
+		repo.On("FindEmployeeIdByName", "Nobody").Return(int64(0), sql.ErrNoRows)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("CreateTokenTx", tx, mock.Anything).Return(nil)
+		repo.On("FindRoles", int64(1)).Return([]string{}, nil)
+
+		var _, err = svc.Login(LoginRequest{Username: "John Doe", Password: "wrong", ClientIp: "10.0.0.1"})
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		_, err = svc.Login(LoginRequest{Username: "Nobody", Password: "wrong", ClientIp: "10.0.0.1"})
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		a.Equal([]string{"1:John Doe@10.0.0.1", "0:Nobody@10.0.0.1"}, throttle.failed)
+
+		_, err = svc.Login(LoginRequest{Username: "John Doe", Password: "secret", ClientIp: "10.0.0.1"})
+		a.Nil(err)
+		a.Equal([]int64{1}, throttle.succeeded)
+	})
+}
+
+func TestLoginMfa(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should require second factor and then issue tokens with all roles", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var throttle = &StubThrottle{}
+		var svc = NewService(repo, validator.New(), StubPasswords{}, tokens, throttle, StubMfa{enabled: true})
+		svc.now = func() time.Time { return now }
+		tx, sqlMock := newTx(a, true)
+		repo.On("FindEmployeeIdByName", "John Doe").Return(int64(1), nil)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("CreateTokenTx", tx, mock.MatchedBy(func(e *TokenEntity) bool { return e.Mfa })).Return(nil)
+		repo.On("FindRoles", int64(1)).Return([]string{"admin", "auditor"}, nil)
+
+		var challenge, err = svc.Login(LoginRequest{Username: "John Doe", Password: "secret", ClientIp: "10.0.0.1"})
+		a.Nil(err)
+		a.True(challenge.MfaRequired)
+		a.Empty(challenge.AccessToken)
+		a.Empty(throttle.succeeded)
+		// промежуточный токен не годится как access-токен
+		_, err = svc.Verify(challenge.MfaToken)
+		a.ErrorAs(err, &common.UnauthorizedError{})
+
+		_, err = svc.LoginMfa(MfaRequest{MfaToken: challenge.MfaToken, Code: "000000", ClientIp: "10.0.0.1"})
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		a.Equal([]string{"1:@10.0.0.1"}, throttle.failed)
+
+		resp, err := svc.LoginMfa(MfaRequest{MfaToken: challenge.MfaToken, Code: "123456", ClientIp: "10.0.0.1"})
+		a.Nil(err)
+		a.False(resp.MfaEnrollmentRequired)
+		claims, err := svc.Verify(resp.AccessToken)
+		a.Nil(err)
+		a.Equal([]string{"admin", "auditor"}, claims.Roles)
+		a.Equal([]string{AmrPassword, AmrOtp}, claims.Amr)
+		a.Equal([]int64{1}, throttle.succeeded)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should withhold privileged roles without second factor", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), StubPasswords{}, tokens, nil, StubMfa{})
+		svc.now = func() time.Time { return now }
+		tx, _ := newTx(a, true)
+		repo.On("FindEmployeeIdByName", "John Doe").Return(int64(1), nil)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("CreateTokenTx", tx, mock.MatchedBy(func(e *TokenEntity) bool { return !e.Mfa })).Return(nil)
+		repo.On("FindRoles", int64(1)).Return([]string{"admin", "auditor"}, nil)
+
+		var resp, err = svc.Login(LoginRequest{Username: "John Doe", Password: "secret"})
+		a.Nil(err)
+		a.True(resp.MfaEnrollmentRequired)
+		claims, err := svc.Verify(resp.AccessToken)
+		a.Nil(err)
+		a.Equal([]string{"auditor"}, claims.Roles)
+		a.Equal([]string{AmrPassword}, claims.Amr)
+	})
+
+	t.Run("should reject forged or expired mfa token", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator.New(), StubPasswords{}, tokens, nil, StubMfa{enabled: true})
+		svc.now = func() time.Time { return now }
+		token, err := svc.mfaToken(1, false)
+		a.Nil(err)
+		svc.now = func() time.Time { return now.Add(mfaTokenTTL) }
+		_, err = svc.LoginMfa(MfaRequest{MfaToken: token, Code: "123456"})
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		_, err = svc.LoginMfa(MfaRequest{MfaToken: "forged", Code: "123456"})
+		a.ErrorAs(err, &common.UnauthorizedError{})
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	var a = assert.New(t)
+	var request = RefreshRequest{RefreshToken: "refresh-token"}
+
+	t.Run("should rotate refresh token within session", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, StubPasswords{})
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTokenTx", tx, hashToken(request.RefreshToken)).
+			Return(TokenEntity{Id: 7, EmployeeId: 1, FamilyId: "family", ExpiresAt: now.Add(time.Hour)}, nil)
+		repo.On("MarkUsedTx", tx, int64(7), now).Return(nil)
+		repo.On("CreateTokenTx", tx, mock.MatchedBy(func(e *TokenEntity) bool {
+			return e.EmployeeId == 1 && e.FamilyId == "family"
+		})).Return(nil)
+		repo.On("FindRoles", int64(1)).Return([]string(nil), nil)
+
+		var resp, err = svc.Refresh(request)
+		a.Nil(err)
+		a.NotEqual(request.RefreshToken, resp.RefreshToken)
+		claims, err := svc.Verify(resp.AccessToken)
+		a.Nil(err)
+		a.Equal([]string{}, claims.Roles)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should revoke session when used token is presented again", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, StubPasswords{})
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTokenTx", tx, hashToken(request.RefreshToken)).Return(TokenEntity{
+			Id: 7, EmployeeId: 1, FamilyId: "family", ExpiresAt: now.Add(time.Hour),
+			UsedAt: sql.NullTime{Time: now.Add(-time.Minute), Valid: true},
+		}, nil)
+		repo.On("RevokeFamilyTx", tx, "family", now).Return(nil)
+
+		var _, err = svc.Refresh(request)
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		repo.AssertNotCalled(t, "CreateTokenTx", mock.Anything, mock.Anything)
+		// отзыв сессии закоммичен, несмотря на ошибку
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject expired or unknown token", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, StubPasswords{})
+		tx, sqlMock := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTokenTx", tx, hashToken(request.RefreshToken)).
+			Return(TokenEntity{Id: 7, EmployeeId: 1, FamilyId: "family", ExpiresAt: now}, nil)
+		var _, err = svc.Refresh(request)
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		a.Nil(sqlMock.ExpectationsWereMet())
+
+		tx, _ = newTx(a, false)
+		repo = new(MockRepo)
+		svc = newService(repo, StubPasswords{})
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTokenTx", tx, mock.Anything).Return(TokenEntity{}, sql.ErrNoRows)
+		_, err = svc.Refresh(request)
+		a.ErrorAs(err, &common.UnauthorizedError{})
+	})
+}
+
+func TestLogout(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should revoke whole session", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, StubPasswords{})
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTokenTx", tx, hashToken("refresh-token")).Return(TokenEntity{Id: 7, FamilyId: "family"}, nil)
+		repo.On("RevokeFamilyTx", tx, "family", now).Return(nil)
+		a.Nil(svc.Logout(RefreshRequest{RefreshToken: "refresh-token"}))
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should ignore unknown token", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, StubPasswords{})
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTokenTx", tx, mock.Anything).Return(TokenEntity{}, sql.ErrNoRows)
+		a.Nil(svc.Logout(RefreshRequest{RefreshToken: "unknown"}))
+	})
+}
+
+func TestVerify(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = newService(repo, StubPasswords{})
+	repo.On("FindRoles", int64(1)).Return([]string{"admin"}, nil)
+	var resp, err = svc.response(1, "", false, now)
+	a.Nil(err)
+
+	t.Run("should reject token signed with another key", func(t *testing.T) {
+		var other = NewService(repo, validator.New(), StubPasswords{}, TokenConfig{Keys: StubKeys{secret: []byte("another-secret-another-secret-00")}}, nil, nil)
+		other.now = svc.now
+		var _, err = other.Verify(resp.AccessToken)
+		a.ErrorAs(err, &common.UnauthorizedError{})
+	})
+
+	t.Run("should reject expired token", func(t *testing.T) {
+		svc.now = func() time.Time { return now.Add(tokens.AccessTTL) }
+		var _, err = svc.Verify(resp.AccessToken)
+		a.ErrorAs(err, &common.UnauthorizedError{})
+	})
+}
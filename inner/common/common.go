@@ -1,15 +1,140 @@
 package common
 
 import (
+	"encoding/base64"
 	"fmt"
 	"github.com/joho/godotenv"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultIdempotencyTTL время хранения ключа идемпотентности, если IDEMPOTENCY_TTL не задан
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// Значения по умолчанию для истечения назначений ролей, если ASSIGNMENT_EXPIRY_* не заданы
+const (
+	DefaultAssignmentExpiryInterval = time.Minute
+	DefaultAssignmentExpiryWarning  = 72 * time.Hour
+)
+
+// DefaultReviewDeadlineInterval как часто проверяются сроки кампаний пересмотра, если REVIEW_DEADLINE_INTERVAL не задан
+const DefaultReviewDeadlineInterval = 5 * time.Minute
+
+// DefaultAssignmentRulesInterval как часто роли пересчитываются по правилам, если ASSIGNMENT_RULES_INTERVAL не задан
+const DefaultAssignmentRulesInterval = 15 * time.Minute
+
+// Значения по умолчанию для политики паролей, если PASSWORD_* не заданы
+const (
+	DefaultPasswordHash       = "argon2id"
+	DefaultPasswordMinLength  = 12
+	DefaultPasswordMinClasses = 3
+	DefaultPasswordHistory    = 5
+	DefaultPasswordMaxAge     = 90 * 24 * time.Hour
+)
+
+// Значения по умолчанию для сроков жизни токенов, если *_TOKEN_TTL не заданы
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// Значения по умолчанию для блокировки входа, если LOCKOUT_* не заданы
+const (
+	DefaultLockoutThreshold   = 5
+	DefaultLockoutIpThreshold = 20
+	DefaultLockoutDuration    = 15 * time.Minute
+	DefaultLockoutMaxDuration = 24 * time.Hour
+	DefaultLockoutBackoff     = "exponential"
+)
+
+// Значения по умолчанию для второго фактора, если MFA_* не заданы
+const (
+	DefaultMfaIssuer        = "IdM"
+	DefaultMfaRequiredRoles = "admin"
+)
+
+// Значения по умолчанию для ключей подписи, если SIGNING_KEY_* не заданы
+const (
+	DefaultSigningKeyAlgorithm   = "RS256"
+	DefaultSigningKeyRotation    = 30 * 24 * time.Hour
+	DefaultSigningKeyGracePeriod = 7 * 24 * time.Hour
+)
+
+// DefaultApiKeyMaxTTL наибольший срок действия API-ключа, если API_KEY_MAX_TTL не задан
+const DefaultApiKeyMaxTTL = 365 * 24 * time.Hour
+
+// Значения по умолчанию для ограничения частоты запросов, если RATE_LIMIT* не заданы
+const (
+	DefaultRateLimits     = "*=600/m,GET /api/v1/employees=60/m:20,POST /api/v1/auth/*=30/m:10"
+	DefaultRateLimitStore = "memory"
 )
 
 // Config общая конфигурация всего приложения
 type Config struct {
 	DbDriverName string `validate:"required"`
 	Dsn          string `validate:"required"`
+	// IdempotencyTTL сколько хранится ответ, сохранённый по заголовку Idempotency-Key
+	IdempotencyTTL time.Duration
+	// ProvisioningConfig путь к JSON-файлу с настройками коннекторов провижининга; пустой — провижининг выключен
+	ProvisioningConfig string
+	// AssignmentExpiryInterval как часто проверяются сроки действия назначений ролей
+	AssignmentExpiryInterval time.Duration
+	// AssignmentExpiryWarning за сколько до окончания срока назначения предупреждать; 0 — не предупреждать
+	AssignmentExpiryWarning time.Duration
+	// ReviewDeadlineInterval как часто закрываются кампании пересмотра доступа с истёкшим сроком
+	ReviewDeadlineInterval time.Duration
+	// AssignmentRulesInterval как часто роли всех сотрудников пересчитываются по правилам назначения
+	AssignmentRulesInterval time.Duration
+	// PasswordHash алгоритм хеширования новых паролей: argon2id или bcrypt
+	PasswordHash string
+	// PasswordMinLength минимальная длина пароля
+	PasswordMinLength int
+	// PasswordMinClasses сколько классов символов должно быть в пароле, от 0 до 4
+	PasswordMinClasses int
+	// PasswordHistory сколько последних паролей нельзя использовать повторно
+	PasswordHistory int
+	// PasswordMaxAge срок действия пароля; 0 — пароль не истекает
+	PasswordMaxAge time.Duration
+	// BreachedPasswordsFile отсортированный файл с SHA-1 хешами утёкших паролей; пустой — проверка по утечкам выключена
+	BreachedPasswordsFile string
+	// AccessTokenTTL срок жизни access-токена
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL срок жизни refresh-токена; каждое обновление выдаёт токен с новым сроком
+	RefreshTokenTTL time.Duration
+	// LockoutThreshold неудачных входов сотрудника до блокировки; 0 — не блокировать
+	LockoutThreshold int
+	// LockoutIpThreshold неудачных входов с одного IP до блокировки; 0 — не блокировать
+	LockoutIpThreshold int
+	// LockoutDuration срок первой блокировки
+	LockoutDuration time.Duration
+	// LockoutMaxDuration предельный срок блокировки при экспоненциальном росте
+	LockoutMaxDuration time.Duration
+	// LockoutBackoff exponential — каждая следующая блокировка вдвое дольше, fixed — все по LockoutDuration
+	LockoutBackoff string
+	// MfaIssuer название системы, которое приложение-аутентификатор показывает рядом с кодом
+	MfaIssuer string
+	// MfaRequiredRoles роли, которые выдаются в токене только при входе со вторым фактором
+	MfaRequiredRoles []string
+	// OidcIssuer внешний адрес IdM для OpenID Connect, например https://idm.example.com; пустой — провайдер выключен
+	OidcIssuer string
+	// SigningKeyEncryptionKey 32-байтный ключ AES, которым ключи подписи шифруются в базе; пустой — хранилище ключей
+	// выключено, а вместе с ним и вход по паролю: access-токены подписываются ключами из хранилища
+	SigningKeyEncryptionKey []byte
+	// SigningKeyAlgorithm алгоритм новых ключей подписи: RS256 или ES256
+	SigningKeyAlgorithm string
+	// SigningKeyRotation как часто следующий ключ становится активным
+	SigningKeyRotation time.Duration
+	// SigningKeyGracePeriod сколько выведенный ключ публикуется в JWKS; не меньше срока жизни выданных им токенов
+	SigningKeyGracePeriod time.Duration
+	// ApiKeyMaxTTL наибольший срок действия API-ключа сервисного аккаунта
+	ApiKeyMaxTTL time.Duration
+	// RateLimits бюджеты запросов по маршрутам в формате ratelimit.ParseRules; пустая строка — без ограничений
+	RateLimits string
+	// RateLimitStore memory — бюджеты в памяти каждого экземпляра, postgres — общие для всех экземпляров
+	RateLimitStore string
 }
 
 // GetConfig загружает конфигурацию из .env файла или переменных окружения.
@@ -57,5 +182,185 @@ func GetConfig(envFile string, overwrite bool) (Config, string) {
 		return Config{}, "required environment variables are missing"
 	}
 
+	cfg.IdempotencyTTL = DefaultIdempotencyTTL
+	if ttl := os.Getenv("IDEMPOTENCY_TTL"); ttl != "" {
+		if cfg.IdempotencyTTL, err = time.ParseDuration(ttl); err != nil || cfg.IdempotencyTTL <= 0 {
+			return Config{}, "IDEMPOTENCY_TTL must be a positive duration"
+		}
+	}
+
+	cfg.ProvisioningConfig = os.Getenv("PROVISIONING_CONFIG")
+
+	cfg.AssignmentExpiryInterval = DefaultAssignmentExpiryInterval
+	if interval := os.Getenv("ASSIGNMENT_EXPIRY_INTERVAL"); interval != "" {
+		if cfg.AssignmentExpiryInterval, err = time.ParseDuration(interval); err != nil || cfg.AssignmentExpiryInterval <= 0 {
+			return Config{}, "ASSIGNMENT_EXPIRY_INTERVAL must be a positive duration"
+		}
+	}
+	cfg.AssignmentExpiryWarning = DefaultAssignmentExpiryWarning
+	if warning := os.Getenv("ASSIGNMENT_EXPIRY_WARNING"); warning != "" {
+		if cfg.AssignmentExpiryWarning, err = time.ParseDuration(warning); err != nil || cfg.AssignmentExpiryWarning < 0 {
+			return Config{}, "ASSIGNMENT_EXPIRY_WARNING must be a non-negative duration"
+		}
+	}
+
+	cfg.ReviewDeadlineInterval = DefaultReviewDeadlineInterval
+	if interval := os.Getenv("REVIEW_DEADLINE_INTERVAL"); interval != "" {
+		if cfg.ReviewDeadlineInterval, err = time.ParseDuration(interval); err != nil || cfg.ReviewDeadlineInterval <= 0 {
+			return Config{}, "REVIEW_DEADLINE_INTERVAL must be a positive duration"
+		}
+	}
+
+	cfg.AssignmentRulesInterval = DefaultAssignmentRulesInterval
+	if interval := os.Getenv("ASSIGNMENT_RULES_INTERVAL"); interval != "" {
+		if cfg.AssignmentRulesInterval, err = time.ParseDuration(interval); err != nil || cfg.AssignmentRulesInterval <= 0 {
+			return Config{}, "ASSIGNMENT_RULES_INTERVAL must be a positive duration"
+		}
+	}
+
+	cfg.PasswordHash = DefaultPasswordHash
+	if hash := os.Getenv("PASSWORD_HASH"); hash != "" {
+		if hash != "argon2id" && hash != "bcrypt" {
+			return Config{}, "PASSWORD_HASH must be argon2id or bcrypt"
+		}
+		cfg.PasswordHash = hash
+	}
+	cfg.PasswordMinLength = DefaultPasswordMinLength
+	if length := os.Getenv("PASSWORD_MIN_LENGTH"); length != "" {
+		if cfg.PasswordMinLength, err = strconv.Atoi(length); err != nil || cfg.PasswordMinLength <= 0 {
+			return Config{}, "PASSWORD_MIN_LENGTH must be a positive number"
+		}
+	}
+	cfg.PasswordMinClasses = DefaultPasswordMinClasses
+	if classes := os.Getenv("PASSWORD_MIN_CLASSES"); classes != "" {
+		if cfg.PasswordMinClasses, err = strconv.Atoi(classes); err != nil || cfg.PasswordMinClasses < 0 || cfg.PasswordMinClasses > 4 {
+			return Config{}, "PASSWORD_MIN_CLASSES must be a number from 0 to 4"
+		}
+	}
+	cfg.PasswordHistory = DefaultPasswordHistory
+	if history := os.Getenv("PASSWORD_HISTORY"); history != "" {
+		if cfg.PasswordHistory, err = strconv.Atoi(history); err != nil || cfg.PasswordHistory < 0 {
+			return Config{}, "PASSWORD_HISTORY must be a non-negative number"
+		}
+	}
+	cfg.PasswordMaxAge = DefaultPasswordMaxAge
+	if maxAge := os.Getenv("PASSWORD_MAX_AGE"); maxAge != "" {
+		if cfg.PasswordMaxAge, err = time.ParseDuration(maxAge); err != nil || cfg.PasswordMaxAge < 0 {
+			return Config{}, "PASSWORD_MAX_AGE must be a non-negative duration"
+		}
+	}
+	cfg.BreachedPasswordsFile = os.Getenv("BREACHED_PASSWORDS_FILE")
+
+	cfg.AccessTokenTTL = DefaultAccessTokenTTL
+	if ttl := os.Getenv("ACCESS_TOKEN_TTL"); ttl != "" {
+		if cfg.AccessTokenTTL, err = time.ParseDuration(ttl); err != nil || cfg.AccessTokenTTL <= 0 {
+			return Config{}, "ACCESS_TOKEN_TTL must be a positive duration"
+		}
+	}
+	cfg.RefreshTokenTTL = DefaultRefreshTokenTTL
+	if ttl := os.Getenv("REFRESH_TOKEN_TTL"); ttl != "" {
+		if cfg.RefreshTokenTTL, err = time.ParseDuration(ttl); err != nil || cfg.RefreshTokenTTL <= 0 {
+			return Config{}, "REFRESH_TOKEN_TTL must be a positive duration"
+		}
+	}
+
+	cfg.LockoutThreshold = DefaultLockoutThreshold
+	if threshold := os.Getenv("LOCKOUT_THRESHOLD"); threshold != "" {
+		if cfg.LockoutThreshold, err = strconv.Atoi(threshold); err != nil || cfg.LockoutThreshold < 0 {
+			return Config{}, "LOCKOUT_THRESHOLD must be a non-negative number"
+		}
+	}
+	cfg.LockoutIpThreshold = DefaultLockoutIpThreshold
+	if threshold := os.Getenv("LOCKOUT_IP_THRESHOLD"); threshold != "" {
+		if cfg.LockoutIpThreshold, err = strconv.Atoi(threshold); err != nil || cfg.LockoutIpThreshold < 0 {
+			return Config{}, "LOCKOUT_IP_THRESHOLD must be a non-negative number"
+		}
+	}
+	cfg.LockoutDuration = DefaultLockoutDuration
+	if duration := os.Getenv("LOCKOUT_DURATION"); duration != "" {
+		if cfg.LockoutDuration, err = time.ParseDuration(duration); err != nil || cfg.LockoutDuration <= 0 {
+			return Config{}, "LOCKOUT_DURATION must be a positive duration"
+		}
+	}
+	cfg.LockoutMaxDuration = max(DefaultLockoutMaxDuration, cfg.LockoutDuration)
+	if duration := os.Getenv("LOCKOUT_MAX_DURATION"); duration != "" {
+		if cfg.LockoutMaxDuration, err = time.ParseDuration(duration); err != nil || cfg.LockoutMaxDuration < cfg.LockoutDuration {
+			return Config{}, "LOCKOUT_MAX_DURATION must be a duration not less than LOCKOUT_DURATION"
+		}
+	}
+	cfg.LockoutBackoff = DefaultLockoutBackoff
+	if backoff := os.Getenv("LOCKOUT_BACKOFF"); backoff != "" {
+		if backoff != "exponential" && backoff != "fixed" {
+			return Config{}, "LOCKOUT_BACKOFF must be exponential or fixed"
+		}
+		cfg.LockoutBackoff = backoff
+	}
+
+	cfg.MfaIssuer = DefaultMfaIssuer
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		cfg.MfaIssuer = issuer
+	}
+	var requiredRoles = DefaultMfaRequiredRoles
+	if roles, ok := os.LookupEnv("MFA_REQUIRED_ROLES"); ok {
+		requiredRoles = roles
+	}
+	for _, role := range strings.Split(requiredRoles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			cfg.MfaRequiredRoles = append(cfg.MfaRequiredRoles, role)
+		}
+	}
+
+	cfg.OidcIssuer = strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	if cfg.OidcIssuer != "" {
+		if issuer, err := url.Parse(cfg.OidcIssuer); err != nil || issuer.Host == "" ||
+			(issuer.Scheme != "https" && issuer.Hostname() != "localhost") || issuer.RawQuery != "" || issuer.Fragment != "" {
+			return Config{}, "OIDC_ISSUER must be an https URL without query and fragment"
+		}
+	}
+
+	if key := os.Getenv("SIGNING_KEY_ENCRYPTION_KEY"); key != "" {
+		if cfg.SigningKeyEncryptionKey, err = base64.StdEncoding.DecodeString(key); err != nil || len(cfg.SigningKeyEncryptionKey) != 32 {
+			return Config{}, "SIGNING_KEY_ENCRYPTION_KEY must be 32 bytes encoded in base64"
+		}
+	}
+	cfg.SigningKeyAlgorithm = DefaultSigningKeyAlgorithm
+	if algorithm := os.Getenv("SIGNING_KEY_ALGORITHM"); algorithm != "" {
+		if algorithm != "RS256" && algorithm != "ES256" {
+			return Config{}, "SIGNING_KEY_ALGORITHM must be RS256 or ES256"
+		}
+		cfg.SigningKeyAlgorithm = algorithm
+	}
+	cfg.SigningKeyRotation = DefaultSigningKeyRotation
+	if rotation := os.Getenv("SIGNING_KEY_ROTATION"); rotation != "" {
+		if cfg.SigningKeyRotation, err = time.ParseDuration(rotation); err != nil || cfg.SigningKeyRotation <= 0 {
+			return Config{}, "SIGNING_KEY_ROTATION must be a positive duration"
+		}
+	}
+	cfg.SigningKeyGracePeriod = max(DefaultSigningKeyGracePeriod, cfg.AccessTokenTTL)
+	if grace := os.Getenv("SIGNING_KEY_GRACE_PERIOD"); grace != "" {
+		if cfg.SigningKeyGracePeriod, err = time.ParseDuration(grace); err != nil || cfg.SigningKeyGracePeriod < cfg.AccessTokenTTL {
+			return Config{}, "SIGNING_KEY_GRACE_PERIOD must be a duration not less than ACCESS_TOKEN_TTL"
+		}
+	}
+
+	cfg.ApiKeyMaxTTL = DefaultApiKeyMaxTTL
+	if ttl := os.Getenv("API_KEY_MAX_TTL"); ttl != "" {
+		if cfg.ApiKeyMaxTTL, err = time.ParseDuration(ttl); err != nil || cfg.ApiKeyMaxTTL <= 0 {
+			return Config{}, "API_KEY_MAX_TTL must be a positive duration"
+		}
+	}
+
+	cfg.RateLimits = DefaultRateLimits
+	if limits, ok := os.LookupEnv("RATE_LIMITS"); ok {
+		cfg.RateLimits = limits
+	}
+	cfg.RateLimitStore = DefaultRateLimitStore
+	if store := os.Getenv("RATE_LIMIT_STORE"); store != "" {
+		if store != "memory" && store != "postgres" {
+			return Config{}, "RATE_LIMIT_STORE must be memory or postgres"
+		}
+		cfg.RateLimitStore = store
+	}
+
 	return cfg, ""
 }
@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 type RequestValidationError struct {
 	Message string
 }
@@ -15,3 +17,57 @@ type AlreadyExistsError struct {
 func (err AlreadyExistsError) Error() string {
 	return err.Message
 }
+
+type NotFoundError struct {
+	Message string
+}
+
+func (err NotFoundError) Error() string {
+	return err.Message
+}
+
+// ConflictError запись была изменена другим запросом после того, как клиент её прочитал
+type ConflictError struct {
+	Message string
+}
+
+func (err ConflictError) Error() string {
+	return err.Message
+}
+
+// ForbiddenError действие не разрешено выполнившему его сотруднику
+type ForbiddenError struct {
+	Message string
+}
+
+func (err ForbiddenError) Error() string {
+	return err.Message
+}
+
+// PolicyViolationError изменение нарушает правило доступа, например разделение полномочий
+type PolicyViolationError struct {
+	Message string
+}
+
+func (err PolicyViolationError) Error() string {
+	return err.Message
+}
+
+// UnauthorizedError неверные учётные данные или токен
+type UnauthorizedError struct {
+	Message string
+}
+
+func (err UnauthorizedError) Error() string {
+	return err.Message
+}
+
+// LockedError вход временно заблокирован после неудачных попыток; RetryAfter — через сколько можно повторить
+type LockedError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (err LockedError) Error() string {
+	return err.Message
+}
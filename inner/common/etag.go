@@ -0,0 +1,41 @@
+package common
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ETag формирует значение заголовка ETag из версии записи
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// WeakETag формирует слабый ETag, например для meta.version ресурсов SCIM
+func WeakETag(version int64) string {
+	return "W/" + ETag(version)
+}
+
+// AnyVersion возвращает ParseIfMatch для If-Match: *, которому подходит любая текущая версия записи
+const AnyVersion int64 = 0
+
+// ParseIfMatch извлекает версию записи из заголовка If-Match.
+// Принимается одно значение ETag, сформированное функцией ETag, или "*".
+// Слабый ETag отклоняется: If-Match сравнивает ETag строго (RFC 9110, 13.1.1)
+func ParseIfMatch(header string) (int64, error) {
+	var value = strings.TrimSpace(header)
+	switch {
+	case value == "":
+		return 0, errors.New("If-Match header is required")
+	case value == "*":
+		return AnyVersion, nil
+	case strings.HasPrefix(value, "W/"):
+		return 0, errors.New("weak ETag cannot be used in If-Match")
+	}
+	value = strings.Trim(value, `"`)
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, errors.New("invalid If-Match header")
+	}
+	return version, nil
+}
@@ -0,0 +1,24 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	var a = assert.New(t)
+
+	version, err := ParseIfMatch(ETag(7))
+	a.Nil(err)
+	a.Equal(int64(7), version)
+
+	version, err = ParseIfMatch(" * ")
+	a.Nil(err)
+	a.Equal(AnyVersion, version)
+
+	for _, header := range []string{"", WeakETag(7), `"0"`, `"abc"`, `"1", "2"`} {
+		_, err = ParseIfMatch(header)
+		a.NotNil(err, "header %q", header)
+	}
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// FieldChange изменение одного поля между двумя версиями записи
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// DiffSnapshots сравнивает два JSON-снимка записи и возвращает изменившиеся поля.
+// Пустой prev означает, что сравнивать не с чем, и все поля next считаются новыми
+func DiffSnapshots(prev, next []byte) ([]FieldChange, error) {
+	var oldFields = map[string]any{}
+	var newFields = map[string]any{}
+	if len(prev) > 0 {
+		if err := json.Unmarshal(prev, &oldFields); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(next, &newFields); err != nil {
+		return nil, err
+	}
+
+	var keys = make([]string, 0, len(newFields))
+	for k := range newFields {
+		keys = append(keys, k)
+	}
+	for k := range oldFields {
+		if _, ok := newFields[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	// порядок полей в map не определён, сортируем для стабильного ответа
+	sort.Strings(keys)
+
+	var changes = []FieldChange{}
+	for _, k := range keys {
+		if !reflect.DeepEqual(oldFields[k], newFields[k]) {
+			changes = append(changes, FieldChange{Field: k, Old: oldFields[k], New: newFields[k]})
+		}
+	}
+	return changes, nil
+}
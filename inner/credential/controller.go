@@ -0,0 +1,140 @@
+package credential
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"math"
+	"strconv"
+)
+
+type Controller struct {
+	server            *web.Server
+	credentialService Svc
+}
+
+// интерфейс сервиса credential.Service
+type Svc interface {
+	Status(employeeId int64) (StatusResponse, error)
+	SetPassword(request SetRequest) error
+	ChangePassword(request ChangeRequest) error
+	ResetPassword(employeeId int64) (ResetResponse, error)
+}
+
+func NewController(server *web.Server, credentialService Svc) *Controller {
+	return &Controller{
+		server:            server,
+		credentialService: credentialService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Get("/employees/:id/password", c.Status)
+	c.server.GroupApiV1.Put("/employees/:id/password", c.SetPassword)
+	c.server.GroupApiV1.Post("/employees/:id/password/change", c.ChangePassword)
+	c.server.GroupApiV1.Post("/employees/:id/password/reset", c.ResetPassword)
+}
+
+func (c *Controller) Status(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.credentialService.Status(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get password status")
+	}
+
+	return nil
+}
+
+// функция-хендлер для PUT запроса по маршруту "/api/v1/employees/:id/password"
+func (c *Controller) SetPassword(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	var request SetRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.EmployeeId = id
+
+	if err = c.credentialService.SetPassword(request); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error set password")
+	}
+
+	return nil
+}
+
+func (c *Controller) ChangePassword(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	var request ChangeRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.EmployeeId = id
+	request.ClientIp = ctx.IP()
+
+	if err = c.credentialService.ChangePassword(request); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error change password")
+	}
+
+	return nil
+}
+
+// ResetPassword выдаёт сотруднику временный пароль; ответ содержит пароль, поэтому не кешируется
+func (c *Controller) ResetPassword(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.credentialService.ResetPassword(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error reset password")
+	}
+
+	return nil
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	var locked common.LockedError
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	case errors.As(err, &common.ForbiddenError{}):
+		return common.ErrResponse(ctx, fiber.StatusForbidden, err.Error())
+	case errors.As(err, &locked):
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		return common.ErrResponse(ctx, fiber.StatusTooManyRequests, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,30 @@
+package credential
+
+import (
+	"time"
+)
+
+// Entity локальный пароль сотрудника
+type Entity struct {
+	EmployeeId   int64     `db:"employee_id"`
+	PasswordHash string    `db:"password_hash"`
+	MustChange   bool      `db:"must_change"`
+	ChangedAt    time.Time `db:"changed_at"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// StatusResponse состояние пароля сотрудника без самого хеша
+type StatusResponse struct {
+	EmployeeId  int64      `json:"employee_id"`
+	HasPassword bool       `json:"has_password"`
+	MustChange  bool       `json:"must_change"`
+	ChangedAt   *time.Time `json:"changed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Expired     bool       `json:"expired"`
+}
+
+// ResetResponse временный пароль, выданный при сбросе; показывается один раз
+type ResetResponse struct {
+	EmployeeId        int64  `json:"employee_id"`
+	TemporaryPassword string `json:"temporary_password"`
+}
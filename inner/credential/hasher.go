@@ -0,0 +1,101 @@
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Алгоритмы хеширования паролей
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// Параметры argon2id по рекомендации OWASP: 64 МиБ памяти, 3 прохода
+const (
+	argon2Memory  = 64 * 1024
+	argon2Time    = 3
+	argon2Threads = 2
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// ErrUnknownHash хеш записан в формате, который Hasher не умеет проверять
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher хеширует новые пароли выбранным алгоритмом и проверяет хеши обоих алгоритмов,
+// поэтому алгоритм можно сменить без сброса уже сохранённых паролей
+type Hasher struct {
+	algorithm  string
+	memory     uint32
+	time       uint32
+	bcryptCost int
+}
+
+// NewHasher создаёт Hasher для алгоритма argon2id или bcrypt; пустой algorithm означает argon2id
+func NewHasher(algorithm string) (*Hasher, error) {
+	switch algorithm {
+	case "", AlgorithmArgon2id:
+		return &Hasher{algorithm: AlgorithmArgon2id, memory: argon2Memory, time: argon2Time}, nil
+	case AlgorithmBcrypt:
+		return &Hasher{algorithm: AlgorithmBcrypt, bcryptCost: bcrypt.DefaultCost}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+}
+
+// Hash возвращает хеш пароля в формате PHC для argon2id или в стандартном формате bcrypt.
+// Для bcrypt пароль длиннее 72 байт отклоняется с bcrypt.ErrPasswordTooLong
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(hash), err
+	}
+	var salt = make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	var key = argon2.IDKey([]byte(password), salt, h.time, h.memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify проверяет пароль по хешу; параметры argon2id берутся из самого хеша
+func (h *Hasher) Verify(hash string, password string) (bool, error) {
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	var parts = strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return false, ErrUnknownHash
+	}
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, ErrUnknownHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ErrUnknownHash
+	}
+	var actual = argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
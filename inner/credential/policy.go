@@ -0,0 +1,213 @@
+package credential
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Policy требования к паролям
+type Policy struct {
+	// MinLength минимальная длина пароля в символах
+	MinLength int
+	// MinClasses сколько классов символов из четырёх (строчные, прописные, цифры, прочие) должно быть в пароле
+	MinClasses int
+	// History сколько последних паролей нельзя использовать повторно; 0 — только текущий
+	History int
+	// MaxAge срок действия пароля; 0 — пароль не истекает
+	MaxAge time.Duration
+}
+
+// Check проверяет длину и состав пароля. Ошибка описывает первое нарушенное требование
+func (p Policy) Check(password string) error {
+	if n := len([]rune(password)); n < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	var classes int
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		return fmt.Errorf("password must contain at least %d of: lowercase letters, uppercase letters, digits, other characters",
+			p.MinClasses)
+	}
+	return nil
+}
+
+// ExpiresAt момент истечения пароля, сменённого в changedAt; нулевое время, если пароль не истекает
+func (p Policy) ExpiresAt(changedAt time.Time) time.Time {
+	if p.MaxAge <= 0 {
+		return time.Time{}
+	}
+	return changedAt.Add(p.MaxAge)
+}
+
+// BreachedList файл с SHA-1 хешами паролей из известных утечек. Файл не загружается в память:
+// Contains ищет хеш двоичным поиском, поэтому строки должны быть отсортированы по хешу,
+// как в выгрузке Have I Been Pwned, упорядоченной по хешу
+type BreachedList struct {
+	file *os.File
+	size int64
+}
+
+// OpenBreachedList открывает файл с SHA-1 хешами утёкших паролей в верхнем регистре, по одному в строке.
+// Допускается формат Have I Been Pwned "ХЕШ:количество". Пустой путь означает, что проверка по утечкам выключена
+func OpenBreachedList(path string) (*BreachedList, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening breached password list: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error opening breached password list: %w", err)
+	}
+	return &BreachedList{file: file, size: info.Size()}, nil
+}
+
+// Contains проверяет, встречался ли пароль в утечках
+func (l *BreachedList) Contains(password string) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+	var sum = sha1.Sum([]byte(password))
+	var target = strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// искомая строка, если она есть, начинается в [lo, hi); lo всегда указывает на начало строки
+	var lo, hi int64 = 0, l.size
+	for lo < hi {
+		var mid = lo + (hi-lo)/2
+		start, err := l.lineStart(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			// в [mid, hi) не начинается ни одной строки, осталось несколько строк от lo
+			break
+		}
+		line, err := l.line(start)
+		if err != nil {
+			return false, err
+		}
+		switch strings.Compare(breachedHash(line), target) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = start + int64(len(line))
+		default:
+			hi = start
+		}
+	}
+	for lo < hi {
+		line, err := l.line(lo)
+		if err != nil {
+			return false, err
+		}
+		if breachedHash(line) == target {
+			return true, nil
+		}
+		lo += int64(len(line))
+	}
+	return false, nil
+}
+
+// Close закрывает файл списка
+func (l *BreachedList) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// lineStart смещение первой строки, которая начинается не раньше offset, или размер файла, если такой нет
+func (l *BreachedList) lineStart(offset int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	var reader = bufio.NewReader(io.NewSectionReader(l.file, offset-1, l.size-offset+1))
+	skipped, err := reader.ReadString('\n')
+	if errors.Is(err, io.EOF) {
+		return l.size, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading breached password list: %w", err)
+	}
+	return offset - 1 + int64(len(skipped)), nil
+}
+
+// line строка, начинающаяся со смещения offset, вместе с переводом строки
+func (l *BreachedList) line(offset int64) (string, error) {
+	var reader = bufio.NewReader(io.NewSectionReader(l.file, offset, l.size-offset))
+	line, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("error reading breached password list: %w", err)
+	}
+	return line, nil
+}
+
+func breachedHash(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
+
+// Наборы символов временного пароля; похожие символы вроде 0/O и 1/l исключены
+var passwordAlphabets = []string{
+	"abcdefghijkmnopqrstuvwxyz",
+	"ABCDEFGHJKLMNPQRSTUVWXYZ",
+	"23456789",
+	"!#$%&*+-=?@_",
+}
+
+// generatePassword создаёт случайный пароль длины length с символами всех четырёх классов
+func generatePassword(length int) (string, error) {
+	var all = strings.Join(passwordAlphabets, "")
+	var password = make([]byte, 0, length)
+	for i := 0; i < length; i++ {
+		// первые символы берутся по одному из каждого класса, остальные из всех сразу
+		var alphabet = all
+		if i < len(passwordAlphabets) {
+			alphabet = passwordAlphabets[i]
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, alphabet[n.Int64()])
+	}
+	// перемешиваем, чтобы классы не стояли на предсказуемых позициях
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
@@ -0,0 +1,83 @@
+package credential
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// FindByEmployeeId возвращает пароль сотрудника; sql.ErrNoRows, если пароль не задан
+func (r *Repository) FindByEmployeeId(employeeId int64) (credential Entity, err error) {
+	err = r.db.Get(&credential, "SELECT * FROM credential WHERE employee_id = $1", employeeId)
+	return
+}
+
+func (r *Repository) EmployeeExists(employeeId int64) (exists bool, err error) {
+	err = r.db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM employee WHERE id = $1)", employeeId)
+	return
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+// LockEmployeeTx блокирует сотрудника до конца транзакции, чтобы параллельные смены пароля
+// не обошли проверку истории. Возвращает false, если сотрудника нет
+func (r *Repository) LockEmployeeTx(tx *sqlx.Tx, employeeId int64) (exists bool, err error) {
+	var ids []int64
+	err = tx.Select(&ids, "SELECT id FROM employee WHERE id = $1 FOR UPDATE", employeeId)
+	return len(ids) > 0, err
+}
+
+// FindTx возвращает пароль сотрудника; sql.ErrNoRows, если пароль не задан
+func (r *Repository) FindTx(tx *sqlx.Tx, employeeId int64) (credential Entity, err error) {
+	err = tx.Get(&credential, "SELECT * FROM credential WHERE employee_id = $1", employeeId)
+	return
+}
+
+// FindHistoryTx возвращает хеши limit последних паролей сотрудника, от новых к старым
+func (r *Repository) FindHistoryTx(tx *sqlx.Tx, employeeId int64, limit int) (hashes []string, err error) {
+	query := `SELECT password_hash FROM password_history WHERE employee_id = $1 ORDER BY id DESC LIMIT $2`
+	err = tx.Select(&hashes, query, employeeId, limit)
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// SaveTx создаёт или заменяет пароль сотрудника
+func (r *Repository) SaveTx(tx *sqlx.Tx, e *Entity) error {
+	query := `INSERT INTO credential (employee_id, password_hash, must_change, changed_at)
+              VALUES ($1, $2, $3, $4)
+              ON CONFLICT (employee_id) DO UPDATE
+              SET password_hash = EXCLUDED.password_hash, must_change = EXCLUDED.must_change, changed_at = EXCLUDED.changed_at
+              RETURNING *`
+	return tx.Get(e, query, e.EmployeeId, e.PasswordHash, e.MustChange, e.ChangedAt)
+}
+
+// RevokeRefreshTokensTx отзывает все ещё не отозванные refresh-токены сотрудника
+func (r *Repository) RevokeRefreshTokensTx(tx *sqlx.Tx, employeeId int64, at time.Time) error {
+	query := "UPDATE refresh_token SET revoked_at = $2 WHERE employee_id = $1 AND revoked_at IS NULL"
+	_, err := tx.Exec(query, employeeId, at)
+	return err
+}
+
+// AddHistoryTx добавляет хеш в историю паролей и оставляет в ней только keep последних записей
+func (r *Repository) AddHistoryTx(tx *sqlx.Tx, employeeId int64, hash string, keep int) error {
+	_, err := tx.Exec("INSERT INTO password_history (employee_id, password_hash) VALUES ($1, $2)", employeeId, hash)
+	if err != nil {
+		return err
+	}
+	query := `DELETE FROM password_history WHERE employee_id = $1 AND id NOT IN (
+                  SELECT id FROM password_history WHERE employee_id = $1 ORDER BY id DESC LIMIT $2
+              )`
+	_, err = tx.Exec(query, employeeId, keep)
+	return err
+}
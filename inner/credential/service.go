@@ -0,0 +1,296 @@
+package credential
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+// minTemporaryLength минимальная длина временного пароля, выдаваемого при сбросе
+const minTemporaryLength = 16
+
+// Структура сервиса локальных паролей сотрудников
+type Service struct {
+	repo      Repo
+	validator Validator
+	hasher    *Hasher
+	policy    Policy
+	breached  Breached
+	throttle  Throttle
+	now       func() time.Time
+}
+
+// SetRequest пароль, который администратор задаёт сотруднику. MustChange требует сменить его при первом входе
+type SetRequest struct {
+	EmployeeId int64  `json:"-" validate:"required,gt=0"`
+	Password   string `json:"password" validate:"required,max=128"`
+	MustChange bool   `json:"must_change"`
+}
+
+// ChangeRequest смена пароля самим сотрудником
+type ChangeRequest struct {
+	EmployeeId      int64  `json:"-" validate:"required,gt=0"`
+	CurrentPassword string `json:"current_password" validate:"required,max=128"`
+	NewPassword     string `json:"new_password" validate:"required,max=128"`
+	// ClientIp адрес клиента, по которому считаются неудачные проверки текущего пароля
+	ClientIp string `json:"-"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+// Breached проверяет пароль по списку утечек, например BreachedList
+type Breached interface {
+	Contains(password string) (bool, error)
+}
+
+// Throttle считает неудачные проверки пароля вместе с неудачными входами, например lockout.Service
+type Throttle interface {
+	Check(employeeId int64, username string, ip string) error
+	Fail(employeeId int64, username string, ip string) error
+}
+
+type Repo interface {
+	FindByEmployeeId(int64) (Entity, error)
+	EmployeeExists(int64) (bool, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	LockEmployeeTx(*sqlx.Tx, int64) (bool, error)
+	FindTx(*sqlx.Tx, int64) (Entity, error)
+	FindHistoryTx(*sqlx.Tx, int64, int) ([]string, error)
+	SaveTx(*sqlx.Tx, *Entity) error
+	AddHistoryTx(*sqlx.Tx, int64, string, int) error
+	RevokeRefreshTokensTx(*sqlx.Tx, int64, time.Time) error
+}
+
+// NewService создаёт сервис паролей. Если breached равен nil, пароли по утечкам не проверяются,
+// если throttle равен nil, подбор текущего пароля при смене не ограничивается
+func NewService(
+	repo Repo,
+	validator Validator,
+	hasher *Hasher,
+	policy Policy,
+	breached Breached,
+	throttle Throttle,
+) *Service {
+	return &Service{
+		repo:      repo,
+		validator: validator,
+		hasher:    hasher,
+		policy:    policy,
+		breached:  breached,
+		throttle:  throttle,
+		now:       time.Now,
+	}
+}
+
+// Status возвращает состояние пароля сотрудника: задан ли он, когда сменён и когда истекает
+func (srv *Service) Status(employeeId int64) (StatusResponse, error) {
+	var resp = StatusResponse{EmployeeId: employeeId}
+	credential, err := srv.repo.FindByEmployeeId(employeeId)
+	if errors.Is(err, sql.ErrNoRows) {
+		exists, err := srv.repo.EmployeeExists(employeeId)
+		if err != nil {
+			return StatusResponse{}, fmt.Errorf("error finding employee with id %d: %w", employeeId, err)
+		}
+		if !exists {
+			return StatusResponse{}, common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", employeeId)}
+		}
+		return resp, nil
+	}
+	if err != nil {
+		return StatusResponse{}, fmt.Errorf("error finding password of employee %d: %w", employeeId, err)
+	}
+
+	resp.HasPassword = true
+	resp.MustChange = credential.MustChange
+	resp.ChangedAt = &credential.ChangedAt
+	if expiresAt := srv.policy.ExpiresAt(credential.ChangedAt); !expiresAt.IsZero() {
+		resp.ExpiresAt = &expiresAt
+		resp.Expired = !srv.now().Before(expiresAt)
+	}
+	return resp, nil
+}
+
+// Authenticate проверяет пароль сотрудника при входе. Неверный пароль или отсутствие пароля — common.UnauthorizedError.
+// Возвращает, нужно ли сменить пароль: он выдан администратором или истёк
+func (srv *Service) Authenticate(employeeId int64, password string) (mustChange bool, err error) {
+	credential, err := srv.repo.FindByEmployeeId(employeeId)
+	if errors.Is(err, sql.ErrNoRows) {
+		// хешируем впустую, чтобы по времени ответа нельзя было узнать, есть ли такой сотрудник
+		_, _ = srv.hasher.Hash(password)
+		return false, common.UnauthorizedError{Message: "invalid username or password"}
+	}
+	if err != nil {
+		return false, fmt.Errorf("error finding password of employee %d: %w", employeeId, err)
+	}
+	ok, err := srv.hasher.Verify(credential.PasswordHash, password)
+	if err != nil {
+		return false, fmt.Errorf("error verifying password of employee %d: %w", employeeId, err)
+	}
+	if !ok {
+		return false, common.UnauthorizedError{Message: "invalid username or password"}
+	}
+	var expiresAt = srv.policy.ExpiresAt(credential.ChangedAt)
+	return credential.MustChange || (!expiresAt.IsZero() && !srv.now().Before(expiresAt)), nil
+}
+
+// SetPassword задаёт сотруднику пароль от имени администратора. Пароль проверяется политикой, списком утечек и историей
+func (srv *Service) SetPassword(request SetRequest) error {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return common.RequestValidationError{Message: err.Error()}
+	}
+	if err = srv.check(request.Password); err != nil {
+		return err
+	}
+	return database.InTransaction(srv.repo.BeginTransaction, "setting password", func(tx *sqlx.Tx) error {
+		if err := srv.lockEmployee(tx, request.EmployeeId); err != nil {
+			return err
+		}
+		return srv.save(tx, request.EmployeeId, request.Password, request.MustChange, true)
+	})
+}
+
+// ChangePassword меняет пароль сотрудника после проверки текущего. Неверный текущий пароль — common.ForbiddenError;
+// такие попытки считаются как неудачные входы, и после череды неудач возвращается common.LockedError
+func (srv *Service) ChangePassword(request ChangeRequest) error {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return common.RequestValidationError{Message: err.Error()}
+	}
+	if err = srv.check(request.NewPassword); err != nil {
+		return err
+	}
+	if srv.throttle != nil {
+		if err = srv.throttle.Check(request.EmployeeId, "", request.ClientIp); err != nil {
+			return err
+		}
+	}
+	err = database.InTransaction(srv.repo.BeginTransaction, "changing password", func(tx *sqlx.Tx) error {
+		if err := srv.lockEmployee(tx, request.EmployeeId); err != nil {
+			return err
+		}
+		credential, err := srv.repo.FindTx(tx, request.EmployeeId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("employee %d has no password", request.EmployeeId)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding password of employee %d: %w", request.EmployeeId, err)
+		}
+		ok, err := srv.hasher.Verify(credential.PasswordHash, request.CurrentPassword)
+		if err != nil {
+			return fmt.Errorf("error verifying password of employee %d: %w", request.EmployeeId, err)
+		}
+		if !ok {
+			return common.ForbiddenError{Message: "current password is incorrect"}
+		}
+		return srv.save(tx, request.EmployeeId, request.NewPassword, false, true)
+	})
+	// неудача считается после отката транзакции смены, в собственной транзакции счётчика
+	if errors.As(err, &common.ForbiddenError{}) && srv.throttle != nil {
+		if errFail := srv.throttle.Fail(request.EmployeeId, "", request.ClientIp); errFail != nil {
+			return fmt.Errorf("error counting failed password check: %w", errFail)
+		}
+	}
+	return err
+}
+
+// ResetPassword заменяет пароль сотрудника случайным временным, который нужно сменить при следующем входе.
+// Временный пароль возвращается один раз и нигде не хранится в открытом виде
+func (srv *Service) ResetPassword(employeeId int64) (ResetResponse, error) {
+	var length = max(srv.policy.MinLength, minTemporaryLength)
+	password, err := generatePassword(length)
+	if err != nil {
+		return ResetResponse{}, fmt.Errorf("error generating temporary password: %w", err)
+	}
+	err = database.InTransaction(srv.repo.BeginTransaction, "resetting password", func(tx *sqlx.Tx) error {
+		if err := srv.lockEmployee(tx, employeeId); err != nil {
+			return err
+		}
+		// случайный пароль не совпадёт с прежними, поэтому история не проверяется
+		return srv.save(tx, employeeId, password, true, false)
+	})
+	if err != nil {
+		return ResetResponse{}, err
+	}
+	return ResetResponse{EmployeeId: employeeId, TemporaryPassword: password}, nil
+}
+
+// check проверяет пароль политикой и списком утечек
+func (srv *Service) check(password string) error {
+	if err := srv.policy.Check(password); err != nil {
+		return common.RequestValidationError{Message: err.Error()}
+	}
+	if srv.breached == nil {
+		return nil
+	}
+	breached, err := srv.breached.Contains(password)
+	if err != nil {
+		return fmt.Errorf("error checking password against breached list: %w", err)
+	}
+	if breached {
+		return common.RequestValidationError{Message: "password has appeared in a data breach, choose another one"}
+	}
+	return nil
+}
+
+func (srv *Service) lockEmployee(tx *sqlx.Tx, employeeId int64) error {
+	exists, err := srv.repo.LockEmployeeTx(tx, employeeId)
+	if err != nil {
+		return fmt.Errorf("error finding employee with id %d: %w", employeeId, err)
+	}
+	if !exists {
+		return common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", employeeId)}
+	}
+	return nil
+}
+
+// save хеширует и сохраняет пароль и отзывает refresh-токены сотрудника, чтобы сессии, открытые со старым паролем,
+// нельзя было продлить. При checkHistory пароль, совпадающий с одним из последних, отклоняется
+func (srv *Service) save(tx *sqlx.Tx, employeeId int64, password string, mustChange bool, checkHistory bool) error {
+	// текущий пароль тоже лежит в истории, поэтому в ней хранится хотя бы одна запись
+	var keep = max(srv.policy.History, 1)
+	if checkHistory {
+		hashes, err := srv.repo.FindHistoryTx(tx, employeeId, keep)
+		if err != nil {
+			return fmt.Errorf("error get password history of employee %d: %w", employeeId, err)
+		}
+		for _, hash := range hashes {
+			used, err := srv.hasher.Verify(hash, password)
+			if err != nil {
+				return fmt.Errorf("error verifying password history of employee %d: %w", employeeId, err)
+			}
+			if used {
+				return common.RequestValidationError{
+					Message: fmt.Sprintf("password must differ from the last %d passwords", keep),
+				}
+			}
+		}
+	}
+
+	hash, err := srv.hasher.Hash(password)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return common.RequestValidationError{Message: "password must be at most 72 bytes long"}
+	}
+	if err != nil {
+		return fmt.Errorf("error hashing password: %w", err)
+	}
+	var now = srv.now()
+	var credential = Entity{EmployeeId: employeeId, PasswordHash: hash, MustChange: mustChange, ChangedAt: now}
+	if err = srv.repo.SaveTx(tx, &credential); err != nil {
+		return fmt.Errorf("error saving password of employee %d: %w", employeeId, err)
+	}
+	if err = srv.repo.AddHistoryTx(tx, employeeId, hash, keep); err != nil {
+		return fmt.Errorf("error saving password history of employee %d: %w", employeeId, err)
+	}
+	if err = srv.repo.RevokeRefreshTokensTx(tx, employeeId, now); err != nil {
+		return fmt.Errorf("error revoking sessions of employee %d: %w", employeeId, err)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package credential
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindByEmployeeId(employeeId int64) (Entity, error) {
+	args := m.Called(employeeId)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) EmployeeExists(employeeId int64) (bool, error) {
+	args := m.Called(employeeId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) LockEmployeeTx(tx *sqlx.Tx, employeeId int64) (bool, error) {
+	args := m.Called(tx, employeeId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) FindTx(tx *sqlx.Tx, employeeId int64) (Entity, error) {
+	args := m.Called(tx, employeeId)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindHistoryTx(tx *sqlx.Tx, employeeId int64, limit int) ([]string, error) {
+	args := m.Called(tx, employeeId, limit)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockRepo) SaveTx(tx *sqlx.Tx, e *Entity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) AddHistoryTx(tx *sqlx.Tx, employeeId int64, hash string, keep int) error {
+	args := m.Called(tx, employeeId, hash, keep)
+	return args.Error(0)
+}
+
+func (m *MockRepo) RevokeRefreshTokensTx(tx *sqlx.Tx, employeeId int64, at time.Time) error {
+	args := m.Called(tx, employeeId, at)
+	return args.Error(0)
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+// fastHasher argon2id с минимальными параметрами, чтобы тесты не тратили 64 МиБ на каждый хеш
+var fastHasher = &Hasher{algorithm: AlgorithmArgon2id, memory: 64, time: 1}
+
+var policy = Policy{MinLength: 12, MinClasses: 3, History: 3, MaxAge: 90 * 24 * time.Hour}
+
+// hashOf SHA-1 пароля в формате списка утечек
+func hashOf(password string) string {
+	var sum = sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// newBreachedList записывает строки во временный файл и открывает его как список утечек
+func newBreachedList(t *testing.T, lines ...string) *BreachedList {
+	var path = filepath.Join(t.TempDir(), "breached.txt")
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")), 0o600))
+	list, err := OpenBreachedList(path)
+	assert.Nil(t, err)
+	t.Cleanup(func() { list.Close() })
+	return list
+}
+
+// StubThrottle блокирует смену, если locked, и запоминает учтённые неудачи
+type StubThrottle struct {
+	locked bool
+	failed []int64
+}
+
+func (s *StubThrottle) Check(employeeId int64, username string, ip string) error {
+	if s.locked {
+		return common.LockedError{Message: "too many failed login attempts, try again later", RetryAfter: time.Minute}
+	}
+	return nil
+}
+
+func (s *StubThrottle) Fail(employeeId int64, username string, ip string) error {
+	s.failed = append(s.failed, employeeId)
+	return nil
+}
+
+func newService(repo Repo, breached Breached) *Service {
+	var svc = NewService(repo, validator.New(), fastHasher, policy, breached, nil)
+	svc.now = func() time.Time { return time.Date(2025, 12, 8, 12, 0, 0, 0, time.UTC) }
+	return svc
+}
+
+func TestHasher(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should verify argon2id hash", func(t *testing.T) {
+		hash, err := fastHasher.Hash("Correct-Horse-42")
+		a.Nil(err)
+		a.Contains(hash, "$argon2id$v=19$m=64,t=1,p=2$")
+		ok, err := fastHasher.Verify(hash, "Correct-Horse-42")
+		a.Nil(err)
+		a.True(ok)
+		ok, err = fastHasher.Verify(hash, "Correct-Horse-43")
+		a.Nil(err)
+		a.False(ok)
+	})
+
+	t.Run("should verify bcrypt hash with argon2id hasher", func(t *testing.T) {
+		var bcryptHasher = &Hasher{algorithm: AlgorithmBcrypt, bcryptCost: bcrypt.MinCost}
+		hash, err := bcryptHasher.Hash("Correct-Horse-42")
+		a.Nil(err)
+		ok, err := fastHasher.Verify(hash, "Correct-Horse-42")
+		a.Nil(err)
+		a.True(ok)
+	})
+
+	t.Run("should reject unknown hash", func(t *testing.T) {
+		var _, err = fastHasher.Verify("plain-text", "plain-text")
+		a.ErrorIs(err, ErrUnknownHash)
+		_, err = NewHasher("md5")
+		a.NotNil(err)
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	var a = assert.New(t)
+	a.NotNil(policy.Check("Short-1"))
+	a.NotNil(policy.Check("alllowercaseletters"))
+	a.Nil(policy.Check("lowercase-and-digits-42"))
+	a.Nil(policy.Check("Пароль-на-русском"))
+
+	password, err := generatePassword(16)
+	a.Nil(err)
+	a.Len(password, 16)
+	a.Nil(Policy{MinLength: 16, MinClasses: 4}.Check(password))
+}
+
+func TestBreachedList(t *testing.T) {
+	var a = assert.New(t)
+	var passwords = []string{"password", "123456", "qwerty", "letmein", "dragon", "monkey", "Summer-2025!"}
+	var lines []string
+	for i, p := range passwords {
+		lines = append(lines, fmt.Sprintf("%s:%d", hashOf(p), i+1))
+	}
+	slices.Sort(lines)
+	var list = newBreachedList(t, lines...)
+	for _, p := range passwords {
+		found, err := list.Contains(p)
+		a.Nil(err)
+		a.True(found, p)
+	}
+	found, err := list.Contains("Correct-Horse-42")
+	a.Nil(err)
+	a.False(found)
+
+	// SHA-1 от "password" в формате Have I Been Pwned, единственная строка без перевода строки
+	found, err = newBreachedList(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:10434004").Contains("password")
+	a.Nil(err)
+	a.True(found)
+
+	disabled, err := OpenBreachedList("")
+	a.Nil(err)
+	found, err = disabled.Contains("password")
+	a.Nil(err)
+	a.False(found)
+}
+
+func TestStatus(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should report expired password", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		var changedAt = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+		repo.On("FindByEmployeeId", int64(1)).Return(Entity{EmployeeId: 1, ChangedAt: changedAt}, nil)
+		var resp, err = svc.Status(1)
+		a.Nil(err)
+		a.True(resp.HasPassword)
+		a.True(resp.Expired)
+		a.Equal(changedAt.Add(policy.MaxAge), *resp.ExpiresAt)
+	})
+
+	t.Run("should return not found for unknown employee", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		repo.On("FindByEmployeeId", int64(99)).Return(Entity{}, sql.ErrNoRows)
+		repo.On("EmployeeExists", int64(99)).Return(false, nil)
+		var _, err = svc.Status(99)
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+}
+
+func TestSetPassword(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should hash and save password with history", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockEmployeeTx", tx, int64(1)).Return(true, nil)
+		repo.On("FindHistoryTx", tx, int64(1), 3).Return([]string{}, nil)
+		repo.On("SaveTx", tx, mock.MatchedBy(func(e *Entity) bool {
+			ok, _ := fastHasher.Verify(e.PasswordHash, "Correct-Horse-42")
+			return ok && e.MustChange
+		})).Return(nil)
+		repo.On("AddHistoryTx", tx, int64(1), mock.Anything, 3).Return(nil)
+		repo.On("RevokeRefreshTokensTx", tx, int64(1), svc.now()).Return(nil)
+		a.Nil(svc.SetPassword(SetRequest{EmployeeId: 1, Password: "Correct-Horse-42", MustChange: true}))
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject breached password", func(t *testing.T) {
+		var svc = newService(new(MockRepo), newBreachedList(t, hashOf("Summer-2025!")))
+		var err = svc.SetPassword(SetRequest{EmployeeId: 1, Password: "Summer-2025!"})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should reject recently used password", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		tx, _ := newTx(a, false)
+		used, _ := fastHasher.Hash("Correct-Horse-42")
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockEmployeeTx", tx, int64(1)).Return(true, nil)
+		repo.On("FindHistoryTx", tx, int64(1), 3).Return([]string{used}, nil)
+		var err = svc.SetPassword(SetRequest{EmployeeId: 1, Password: "Correct-Horse-42"})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		repo.AssertNotCalled(t, "SaveTx", mock.Anything, mock.Anything)
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	var a = assert.New(t)
+	current, _ := fastHasher.Hash("Correct-Horse-42")
+
+	t.Run("should reject wrong current password", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockEmployeeTx", tx, int64(1)).Return(true, nil)
+		repo.On("FindTx", tx, int64(1)).Return(Entity{EmployeeId: 1, PasswordHash: current}, nil)
+		var err = svc.ChangePassword(ChangeRequest{EmployeeId: 1, CurrentPassword: "wrong", NewPassword: "Battery-Staple-7"})
+		a.ErrorAs(err, &common.ForbiddenError{})
+	})
+
+	t.Run("should count wrong current password and stop when locked", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var throttle = &StubThrottle{}
+		var svc = newService(repo, nil)
+		svc.throttle = throttle
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil).Once()
+		repo.On("LockEmployeeTx", tx, int64(1)).Return(true, nil)
+		repo.On("FindTx", tx, int64(1)).Return(Entity{EmployeeId: 1, PasswordHash: current}, nil)
+		var request = ChangeRequest{EmployeeId: 1, CurrentPassword: "wrong", NewPassword: "Battery-Staple-7", ClientIp: "10.0.0.1"}
+		a.ErrorAs(svc.ChangePassword(request), &common.ForbiddenError{})
+		a.Equal([]int64{1}, throttle.failed)
+
+		throttle.locked = true
+		a.ErrorAs(svc.ChangePassword(request), &common.LockedError{})
+		repo.AssertNumberOfCalls(t, "BeginTransaction", 1)
+	})
+
+	t.Run("should change password and clear must change", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockEmployeeTx", tx, int64(1)).Return(true, nil)
+		repo.On("FindTx", tx, int64(1)).Return(Entity{EmployeeId: 1, PasswordHash: current, MustChange: true}, nil)
+		repo.On("FindHistoryTx", tx, int64(1), 3).Return([]string{current}, nil)
+		repo.On("SaveTx", tx, mock.MatchedBy(func(e *Entity) bool { return !e.MustChange })).Return(nil)
+		repo.On("AddHistoryTx", tx, int64(1), mock.Anything, 3).Return(nil)
+		// сессии, открытые со старым паролем, закрываются
+		repo.On("RevokeRefreshTokensTx", tx, int64(1), svc.now()).Return(nil)
+		var err = svc.ChangePassword(ChangeRequest{EmployeeId: 1, CurrentPassword: "Correct-Horse-42", NewPassword: "Battery-Staple-7"})
+		a.Nil(err)
+		repo.AssertCalled(t, "RevokeRefreshTokensTx", tx, int64(1), svc.now())
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = newService(repo, nil)
+	tx, sqlMock := newTx(a, true)
+	repo.On("BeginTransaction").Return(tx, nil)
+	repo.On("LockEmployeeTx", tx, int64(1)).Return(true, nil)
+	repo.On("SaveTx", tx, mock.MatchedBy(func(e *Entity) bool { return e.MustChange })).Return(nil)
+	repo.On("AddHistoryTx", tx, int64(1), mock.Anything, 3).Return(nil)
+	repo.On("RevokeRefreshTokensTx", tx, int64(1), svc.now()).Return(nil)
+	var resp, err = svc.ResetPassword(1)
+	a.Nil(err)
+	repo.AssertCalled(t, "RevokeRefreshTokensTx", tx, int64(1), svc.now())
+	a.Len(resp.TemporaryPassword, minTemporaryLength)
+	a.Nil(policy.Check(resp.TemporaryPassword))
+	repo.AssertNotCalled(t, "FindHistoryTx", mock.Anything, mock.Anything, mock.Anything)
+	a.Nil(sqlMock.ExpectationsWereMet())
+}
+
+func TestAuthenticate(t *testing.T) {
+	var a = assert.New(t)
+	current, _ := fastHasher.Hash("Correct-Horse-42")
+
+	t.Run("should accept valid password", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		repo.On("FindByEmployeeId", int64(1)).Return(Entity{EmployeeId: 1, PasswordHash: current, ChangedAt: svc.now()}, nil)
+		mustChange, err := svc.Authenticate(1, "Correct-Horse-42")
+		a.Nil(err)
+		a.False(mustChange)
+	})
+
+	t.Run("should require change of expired password", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		var changedAt = svc.now().Add(-policy.MaxAge)
+		repo.On("FindByEmployeeId", int64(1)).Return(Entity{EmployeeId: 1, PasswordHash: current, ChangedAt: changedAt}, nil)
+		mustChange, err := svc.Authenticate(1, "Correct-Horse-42")
+		a.Nil(err)
+		a.True(mustChange)
+	})
+
+	t.Run("should reject wrong or missing password", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		repo.On("FindByEmployeeId", int64(1)).Return(Entity{EmployeeId: 1, PasswordHash: current}, nil)
+		repo.On("FindByEmployeeId", int64(2)).Return(Entity{}, sql.ErrNoRows)
+		var _, err = svc.Authenticate(1, "wrong")
+		a.ErrorAs(err, &common.UnauthorizedError{})
+		_, err = svc.Authenticate(2, "Correct-Horse-42")
+		a.ErrorAs(err, &common.UnauthorizedError{})
+	})
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+)
+
+// InTransaction выполняет fn в транзакции, начатой begin: коммитит её, если fn завершилась без ошибки,
+// и откатывает при ошибке или панике. operation описывает действие в тексте ошибок
+func InTransaction(begin func() (*sqlx.Tx, error), operation string, fn func(tx *sqlx.Tx) error) (err error) {
+	tx, err := begin()
+	if err != nil {
+		return fmt.Errorf("error creating transaction: %w", err)
+	}
+
+	// отложенная функция завершения транзакции
+	defer func() {
+		// проверяем, не было ли паники
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s panic: %v", operation, r)
+			// если была паника, то откатываем транзакцию
+			errTx := tx.Rollback()
+			if errTx != nil {
+				err = fmt.Errorf("%s: rolling back transaction errors: %w, %w", operation, err, errTx)
+			}
+		} else if err != nil {
+			// если произошла другая ошибка (не паника), то откатываем транзакцию
+			errTx := tx.Rollback()
+			if errTx != nil {
+				err = fmt.Errorf("%s: rolling back transaction errors: %w, %w", operation, err, errTx)
+			}
+		} else {
+			// если ошибок нет, то коммитим транзакцию
+			errTx := tx.Commit()
+			if errTx != nil {
+				err = fmt.Errorf("%s: commiting transaction error: %w", operation, errTx)
+			}
+		}
+	}()
+
+	return fn(tx)
+}
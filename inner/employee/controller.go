@@ -1,17 +1,23 @@
 package employee
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/export"
 	"github.com/zhedevops/idm/inner/web"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Controller struct {
 	server          *web.Server
 	employeeService Svc
+	// middleware, которая повторяет сохранённый ответ на запрос с тем же Idempotency-Key
+	idempotency fiber.Handler
 }
 
 // интерфейс сервиса employee.Service
@@ -22,20 +28,39 @@ type Svc interface {
 	FilterByIDs(request ParamIdsRequest) ([]Response, error)
 	DeleteById(request ParamIdRequest) (int64, error)
 	DeleteByIds(request ParamIdsRequest) (int64, error)
+	UpdateEmployee(request UpdateRequest) (Response, error)
+	DeleteByIdVersion(request ParamIdVersionRequest) (int64, error)
+	FindHistory(request ParamIdRequest) ([]HistoryResponse, error)
+	FindByIdAsOf(request ParamIdAsOfRequest) (Response, error)
+	Export(request export.Request) (export.Stream, error)
+	FindManagers(request ParamChainRequest) ([]ChainResponse, error)
+	FindReports(request ParamChainRequest) ([]ChainResponse, error)
+	SetManager(request SetManagerRequest) (Response, error)
 }
 
-func NewController(server *web.Server, employeeService Svc) *Controller {
+// DefaultChainDepth глубина цепочки руководителей и подчинённых, если параметр depth не задан
+const DefaultChainDepth = 10
+
+func NewController(server *web.Server, employeeService Svc, idempotency fiber.Handler) *Controller {
 	return &Controller{
 		server:          server,
 		employeeService: employeeService,
+		idempotency:     idempotency,
 	}
 }
 
 // функция для регистрации маршрутов
 func (c *Controller) RegisterRoutes() {
 	// полный маршрут получится "/api/v1/employees"
-	c.server.GroupApiV1.Post("/employees", c.CreateEmployee)
+	c.server.GroupApiV1.Post("/employees", c.idempotency, c.CreateEmployee)
+	// регистрируется раньше "/employees/:id", иначе "export" будет принят за id
+	c.server.GroupApiV1.Get("/employees/export", c.Export)
 	c.server.GroupApiV1.Get("/employees/:id", c.FindById)
+	c.server.GroupApiV1.Get("/employees/:id/history", c.FindHistory)
+	c.server.GroupApiV1.Get("/employees/:id/managers", c.FindManagers)
+	c.server.GroupApiV1.Get("/employees/:id/reports", c.FindReports)
+	c.server.GroupApiV1.Put("/employees/:id/manager", c.SetManager)
+	c.server.GroupApiV1.Put("/employees/:id", c.UpdateEmployee)
 	c.server.GroupApiV1.Get("/employees", c.FindAll)
 	c.server.GroupApiV1.Get("/employees/list/:ids", c.FilterByIDs)
 	c.server.GroupApiV1.Delete("/employees/:id", c.DeleteById)
@@ -82,19 +107,35 @@ func (c *Controller) FindById(ctx *fiber.Ctx) error {
 		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
 	}
 
-	req := ParamIdRequest{Id: id}
-
-	entity, err := c.employeeService.FindById(req)
+	// параметр as_of в формате RFC 3339 запрашивает состояние сотрудника на указанный момент
+	var entity Response
+	var asOfStr = ctx.Query("as_of")
+	if asOfStr != "" {
+		asOf, errParse := time.Parse(time.RFC3339, asOfStr)
+		if errParse != nil {
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid as_of")
+		}
+		entity, err = c.employeeService.FindByIdAsOf(ParamIdAsOfRequest{Id: id, AsOf: asOf})
+	} else {
+		entity, err = c.employeeService.FindById(ParamIdRequest{Id: id})
+	}
 	if err != nil {
 		switch {
 		case errors.As(err, &common.RequestValidationError{}) || errors.As(err, &common.AlreadyExistsError{}):
 			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		case errors.As(err, &common.NotFoundError{}):
+			return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
 		default:
 			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
 		}
 
 	}
 
+	// версия записи передаётся клиенту в ETag, чтобы он вернул её в If-Match при изменении.
+	// Для исторического состояния ETag не отдаём: изменять по нему запись нельзя
+	if asOfStr == "" {
+		ctx.Set(fiber.HeaderETag, common.ETag(entity.Version))
+	}
 	if err = common.OkResponse(ctx, entity); err != nil {
 		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get employee by id")
 	}
@@ -102,6 +143,53 @@ func (c *Controller) FindById(ctx *fiber.Ctx) error {
 	return nil
 }
 
+// функция-хендлер для PUT запроса по маршруту "/api/v1/employees/:id", требует заголовок If-Match
+func (c *Controller) UpdateEmployee(ctx *fiber.Ctx) error {
+	idStr := ctx.Params("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	version, err := common.ParseIfMatch(ctx.Get(fiber.HeaderIfMatch))
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusPreconditionRequired, err.Error())
+	}
+	if version, err = c.currentVersion(id, version); err != nil {
+		if errors.As(err, &common.ConflictError{}) {
+			return common.ErrResponse(ctx, fiber.StatusPreconditionFailed, err.Error())
+		}
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	var request UpdateRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.Id = id
+	request.Version = version
+
+	entity, err := c.employeeService.UpdateEmployee(request)
+	if err != nil {
+		switch {
+		case errors.As(err, &common.RequestValidationError{}):
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		case errors.As(err, &common.NotFoundError{}):
+			return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+		case errors.As(err, &common.ConflictError{}):
+			return common.ErrResponse(ctx, fiber.StatusPreconditionFailed, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	ctx.Set(fiber.HeaderETag, common.ETag(entity.Version))
+	if err = common.OkResponse(ctx, entity); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error update employee")
+	}
+
+	return nil
+}
+
 func (c *Controller) FindAll(ctx *fiber.Ctx) error {
 	resp, err := c.employeeService.FindAll()
 	if err != nil {
@@ -122,13 +210,28 @@ func (c *Controller) DeleteById(ctx *fiber.Ctx) error {
 		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
 	}
 
-	req := ParamIdRequest{Id: id}
+	version, err := common.ParseIfMatch(ctx.Get(fiber.HeaderIfMatch))
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusPreconditionRequired, err.Error())
+	}
+	if version, err = c.currentVersion(id, version); err != nil {
+		if errors.As(err, &common.ConflictError{}) {
+			return common.ErrResponse(ctx, fiber.StatusPreconditionFailed, err.Error())
+		}
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
 
-	entity, err := c.employeeService.DeleteById(req)
+	req := ParamIdVersionRequest{Id: id, Version: version}
+
+	entity, err := c.employeeService.DeleteByIdVersion(req)
 	if err != nil {
 		switch {
 		case errors.As(err, &common.RequestValidationError{}) || errors.As(err, &common.AlreadyExistsError{}):
 			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		case errors.As(err, &common.NotFoundError{}):
+			return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+		case errors.As(err, &common.ConflictError{}):
+			return common.ErrResponse(ctx, fiber.StatusPreconditionFailed, err.Error())
 		default:
 			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
 		}
@@ -213,3 +316,135 @@ func (c *Controller) DeleteByIds(ctx *fiber.Ctx) error {
 
 	return nil
 }
+
+func (c *Controller) FindHistory(ctx *fiber.Ctx) error {
+	idStr := ctx.Params("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	req := ParamIdRequest{Id: id}
+
+	history, err := c.employeeService.FindHistory(req)
+	if err != nil {
+		switch {
+		case errors.As(err, &common.RequestValidationError{}):
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if err = common.OkResponse(ctx, history); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get employee history")
+	}
+
+	return nil
+}
+
+// FindManagers отдаёт руководителей сотрудника снизу вверх: GET /employees/:id/managers?depth=5
+func (c *Controller) FindManagers(ctx *fiber.Ctx) error {
+	return c.findChain(ctx, c.employeeService.FindManagers)
+}
+
+// FindReports отдаёт прямых и косвенных подчинённых сотрудника: GET /employees/:id/reports?depth=1
+func (c *Controller) FindReports(ctx *fiber.Ctx) error {
+	return c.findChain(ctx, c.employeeService.FindReports)
+}
+
+func (c *Controller) findChain(ctx *fiber.Ctx, find func(ParamChainRequest) ([]ChainResponse, error)) error {
+	idStr := ctx.Params("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	chain, err := find(ParamChainRequest{Id: id, Depth: ctx.QueryInt("depth", DefaultChainDepth)})
+	if err != nil {
+		switch {
+		case errors.As(err, &common.RequestValidationError{}):
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		case errors.As(err, &common.NotFoundError{}):
+			return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if err = common.OkResponse(ctx, chain); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get employee reporting line")
+	}
+
+	return nil
+}
+
+// функция-хендлер для PUT запроса по маршруту "/api/v1/employees/:id/manager"
+func (c *Controller) SetManager(ctx *fiber.Ctx) error {
+	idStr := ctx.Params("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	var request SetManagerRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.Id = id
+
+	entity, err := c.employeeService.SetManager(request)
+	if err != nil {
+		switch {
+		case errors.As(err, &common.RequestValidationError{}):
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		case errors.As(err, &common.NotFoundError{}):
+			return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	ctx.Set(fiber.HeaderETag, common.ETag(entity.Version))
+	if err = common.OkResponse(ctx, entity); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error set employee manager")
+	}
+
+	return nil
+}
+
+// Export отдаёт сотрудников файлом CSV, NDJSON или XLSX: GET /employees/export?format=xlsx&columns=id,name,roles&ids=1,2
+func (c *Controller) Export(ctx *fiber.Ctx) error {
+	req, err := export.ParseRequest(ctx)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	stream, err := c.employeeService.Export(req)
+	if err != nil {
+		switch {
+		case errors.As(err, &common.RequestValidationError{}):
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	return export.Send(ctx, "employees", req.Format, stream)
+}
+
+// currentVersion заменяет common.AnyVersion из If-Match: * текущей версией сотрудника.
+// Если сотрудника нет, условие * не выполняется и возвращается common.ConflictError
+func (c *Controller) currentVersion(id int64, version int64) (int64, error) {
+	if version != common.AnyVersion {
+		return version, nil
+	}
+	current, err := c.employeeService.FindById(ParamIdRequest{Id: id})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, common.ConflictError{Message: fmt.Sprintf("employee with id %d has no current version", id)}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return current.Version, nil
+}
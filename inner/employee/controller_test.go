@@ -0,0 +1,84 @@
+package employee
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+// StubSvc хранит одного сотрудника с id 1 и запоминает версию, с которой его изменяли
+type StubSvc struct {
+	Svc
+	current Response
+	version int64
+}
+
+func (s *StubSvc) FindById(request ParamIdRequest) (Response, error) {
+	if request.Id != s.current.Id {
+		return Response{}, fmt.Errorf("error finding employee with id %d: %w", request.Id, sql.ErrNoRows)
+	}
+	return s.current, nil
+}
+
+func (s *StubSvc) UpdateEmployee(request UpdateRequest) (Response, error) {
+	s.version = request.Version
+	return Response{Id: request.Id, Name: request.Name, Version: request.Version + 1}, nil
+}
+
+func (s *StubSvc) DeleteByIdVersion(request ParamIdVersionRequest) (int64, error) {
+	s.version = request.Version
+	return 1, nil
+}
+
+func TestControllerIfMatch(t *testing.T) {
+	var a = assert.New(t)
+
+	var send = func(method string, path string, ifMatch string) (*StubSvc, int) {
+		var svc = &StubSvc{current: Response{Id: 1, Name: "John Doe", Version: 4}}
+		var server = web.NewServer()
+		NewController(server, svc, func(ctx *fiber.Ctx) error { return ctx.Next() }).RegisterRoutes()
+		var req = httptest.NewRequest(method, path, strings.NewReader(`{"name":"John Deer"}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		if ifMatch != "" {
+			req.Header.Set(fiber.HeaderIfMatch, ifMatch)
+		}
+		resp, err := server.App.Test(req)
+		a.Nil(err)
+		return svc, resp.StatusCode
+	}
+
+	t.Run("should use version from strong etag", func(t *testing.T) {
+		var svc, status = send(fiber.MethodPut, "/api/v1/employees/1", `"3"`)
+		a.Equal(fiber.StatusOK, status)
+		a.Equal(int64(3), svc.version)
+	})
+
+	t.Run("should use current version for star", func(t *testing.T) {
+		var svc, status = send(fiber.MethodPut, "/api/v1/employees/1", "*")
+		a.Equal(fiber.StatusOK, status)
+		a.Equal(int64(4), svc.version)
+		svc, status = send(fiber.MethodDelete, "/api/v1/employees/1", "*")
+		a.Equal(fiber.StatusOK, status)
+		a.Equal(int64(4), svc.version)
+	})
+
+	t.Run("should fail star for missing employee", func(t *testing.T) {
+		var svc, status = send(fiber.MethodPut, "/api/v1/employees/2", "*")
+		a.Equal(fiber.StatusPreconditionFailed, status)
+		a.Zero(svc.version)
+	})
+
+	t.Run("should reject weak etag and missing header", func(t *testing.T) {
+		var svc, status = send(fiber.MethodPut, "/api/v1/employees/1", `W/"4"`)
+		a.Equal(fiber.StatusPreconditionRequired, status)
+		a.Zero(svc.version)
+		_, status = send(fiber.MethodDelete, "/api/v1/employees/1", "")
+		a.Equal(fiber.StatusPreconditionRequired, status)
+	})
+}
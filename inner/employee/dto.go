@@ -1,28 +1,138 @@
 package employee
 
 import (
+	"database/sql"
+	"encoding/json"
+	"github.com/lib/pq"
+	"github.com/zhedevops/idm/inner/common"
 	"time"
 )
 
+// Attributes атрибуты сотрудника из HR, по которым правила назначают роли
+type Attributes struct {
+	Department     string `db:"department" json:"department,omitempty" validate:"max=155"`
+	Title          string `db:"title" json:"title,omitempty" validate:"max=155"`
+	EmploymentType string `db:"employment_type" json:"employment_type,omitempty" validate:"max=64"`
+	Location       string `db:"location" json:"location,omitempty" validate:"max=155"`
+}
+
 type Entity struct {
-	Id        int64     `db:"id"`
-	Name      string    `db:"name"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	Id   int64  `db:"id"`
+	Name string `db:"name"`
+	Attributes
+	// ExternalId табельный номер в HR-системе; заполняется при импорте из HR
+	ExternalId sql.NullString `db:"external_id"`
+	// TerminatedAt момент увольнения по выгрузке HR; уволенный сотрудник не может войти
+	TerminatedAt sql.NullTime `db:"terminated_at"`
+	// ManagerId непосредственный руководитель; согласует заявки на доступ сотрудника
+	ManagerId sql.NullInt64 `db:"manager_id"`
+	// OrgUnitId подразделение, в котором работает сотрудник
+	OrgUnitId sql.NullInt64 `db:"org_unit_id"`
+	Version   int64         `db:"version"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
 }
 
 type Response struct {
-	Id        int64     `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Id         int64  `json:"id"`
+	Name       string `json:"name"`
+	ExternalId string `json:"external_id,omitempty"`
+	ManagerId  int64  `json:"manager_id,omitempty"`
+	OrgUnitId  int64  `json:"org_unit_id,omitempty"`
+	Attributes
+	TerminatedAt *time.Time `json:"terminated_at,omitempty"`
+	Version      int64      `json:"version"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// ChainEntity сотрудник в цепочке руководителей или подчинённых; Depth — расстояние от исходного сотрудника
+type ChainEntity struct {
+	Id        int64         `db:"id"`
+	Name      string        `db:"name"`
+	ManagerId sql.NullInt64 `db:"manager_id"`
+	Depth     int           `db:"depth"`
+}
+
+type ChainResponse struct {
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	ManagerId int64  `json:"manager_id,omitempty"`
+	Depth     int    `json:"depth"`
+}
+
+// HistoryEntity версия записи employee, сохранённая триггером при изменении
+type HistoryEntity struct {
+	HistoryId  int64     `db:"history_id"`
+	EmployeeId int64     `db:"employee_id"`
+	Operation  string    `db:"operation"`
+	Data       []byte    `db:"data"`
+	ChangedAt  time.Time `db:"changed_at"`
+}
+
+type HistoryResponse struct {
+	Version   int64                `json:"version"`
+	Operation string               `json:"operation"`
+	ChangedAt time.Time            `json:"changed_at"`
+	Employee  Response             `json:"employee"`
+	Changes   []common.FieldChange `json:"changes"`
 }
 
 func (e *Entity) toResponse() Response {
-	return Response{
-		Id:        e.Id,
-		Name:      e.Name,
-		CreatedAt: e.CreatedAt,
-		UpdatedAt: e.UpdatedAt,
+	var resp = Response{
+		Id:         e.Id,
+		Name:       e.Name,
+		ExternalId: e.ExternalId.String,
+		ManagerId:  e.ManagerId.Int64,
+		OrgUnitId:  e.OrgUnitId.Int64,
+		Attributes: e.Attributes,
+		Version:    e.Version,
+		CreatedAt:  e.CreatedAt,
+		UpdatedAt:  e.UpdatedAt,
+	}
+	if e.TerminatedAt.Valid {
+		resp.TerminatedAt = &e.TerminatedAt.Time
+	}
+	return resp
+}
+
+func (e *ChainEntity) toResponse() ChainResponse {
+	return ChainResponse{Id: e.Id, Name: e.Name, ManagerId: e.ManagerId.Int64, Depth: e.Depth}
+}
+
+// снимок в data содержит колонки таблицы employee, имена которых совпадают с json-тегами Response
+func (h *HistoryEntity) toResponse() (Response, error) {
+	var resp Response
+	err := json.Unmarshal(h.Data, &resp)
+	return resp, err
+}
+
+// ExportEntity строка выгрузки сотрудников вместе с именами назначенных ролей
+type ExportEntity struct {
+	Entity
+	Roles pq.StringArray `db:"roles"`
+}
+
+// ExportColumns колонки выгрузки сотрудников в порядке по умолчанию
+var ExportColumns = []string{"id", "name", "external_id", "version", "created_at", "updated_at", "roles"}
+
+func (e *ExportEntity) value(column string) any {
+	switch column {
+	case "id":
+		return e.Id
+	case "name":
+		return e.Name
+	case "external_id":
+		return e.ExternalId.String
+	case "version":
+		return e.Version
+	case "created_at":
+		return e.CreatedAt
+	case "updated_at":
+		return e.UpdatedAt
+	case "roles":
+		return []string(e.Roles)
+	default:
+		return nil
 	}
 }
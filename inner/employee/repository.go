@@ -1,7 +1,12 @@
 package employee
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"time"
 )
 
 type Repository struct {
@@ -47,7 +52,8 @@ func (r *Repository) CreateNamed(e *Entity) error {
 }
 
 func (r *Repository) FindAll() (employees []Entity, err error) {
-	query := "SELECT id, name, created_at, updated_at FROM employee ORDER BY id"
+	query := `SELECT id, name, external_id, manager_id, org_unit_id, department, title, employment_type, location, version, created_at, updated_at
+              FROM employee ORDER BY id`
 	err = r.db.Select(&employees, query)
 	if err != nil {
 		return nil, err
@@ -69,6 +75,43 @@ func (r *Repository) FilterByIDs(ids []int64) (employees []Entity, err error) {
 	return employees, nil
 }
 
+// Export читает сотрудников курсором и передаёт их в fn по одному, не загружая выборку в память.
+// Пустой ids означает всех сотрудников
+func (r *Repository) Export(ids []int64, fn func(ExportEntity) error) error {
+	query := `SELECT e.id, e.name, e.external_id, e.version, e.created_at, e.updated_at,
+              COALESCE(array_agg(r.name ORDER BY r.name) FILTER (WHERE r.id IS NOT NULL), '{}') AS roles
+              FROM employee e
+              LEFT JOIN effective_employee_role er ON er.employee_id = e.id
+              LEFT JOIN role r ON r.id = er.role_id`
+	var args []any
+	if len(ids) > 0 {
+		var err error
+		query, args, err = sqlx.In(query+" WHERE e.id IN (?)", ids)
+		if err != nil {
+			return err
+		}
+		query = r.db.Rebind(query)
+	}
+	query += " GROUP BY e.id ORDER BY e.id"
+
+	rows, err := r.db.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e ExportEntity
+		if err = rows.StructScan(&e); err != nil {
+			return err
+		}
+		if err = fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func (r *Repository) DeleteById(id int64) (int64, error) {
 	res, err := r.db.Exec("DELETE FROM employee WHERE id = $1", id)
 	if err != nil {
@@ -81,6 +124,49 @@ func (r *Repository) DeleteById(id int64) (int64, error) {
 	return rows, nil
 }
 
+// Update обновляет запись, только если её версия совпадает с e.Version.
+// При успехе e заполняется актуальным состоянием записи с увеличенной версией
+func (r *Repository) Update(e *Entity) error {
+	query := `UPDATE employee SET name = $1, department = $2, title = $3, employment_type = $4, location = $5,
+                  version = version + 1, updated_at = NOW()
+              WHERE id = $6 AND version = $7 RETURNING *`
+	err := r.db.Get(e, query, e.Name, e.Department, e.Title, e.EmploymentType, e.Location, e.Id, e.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r.versionConflict(e.Id)
+	}
+	return err
+}
+
+// DeleteByIdVersion удаляет запись, только если её версия совпадает с переданной
+func (r *Repository) DeleteByIdVersion(id int64, version int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM employee WHERE id = $1 AND version = $2", id, version)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, r.versionConflict(id)
+	}
+	return rows, nil
+}
+
+// versionConflict выясняет, почему условное изменение не затронуло ни одной строки:
+// записи нет совсем или её версия уже ушла вперёд
+func (r *Repository) versionConflict(id int64) error {
+	var exists bool
+	err := r.db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM employee WHERE id = $1)", id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", id)}
+	}
+	return common.ConflictError{Message: fmt.Sprintf("employee with id %d was modified by another request", id)}
+}
+
 func (r *Repository) DeleteByIds(ids []int64) (int64, error) {
 	query, args, err := sqlx.In("DELETE FROM employee WHERE id IN (?)", ids)
 	if err != nil {
@@ -113,7 +199,8 @@ func (r *Repository) FindByNameTx(tx *sqlx.Tx, name string) (isExists bool, err
 
 func (r *Repository) CreateTx(tx *sqlx.Tx, request CreateRequest) (employeeId int64, err error) {
 	var e = request.ToEntity()
-	query := `INSERT INTO employee (name) VALUES (:name) RETURNING id`
+	query := `INSERT INTO employee (name, department, title, employment_type, location)
+              VALUES (:name, :department, :title, :employment_type, :location) RETURNING id`
 	res, err := tx.NamedQuery(query, e)
 	if err != nil {
 		return 0, err
@@ -127,3 +214,94 @@ func (r *Repository) CreateTx(tx *sqlx.Tx, request CreateRequest) (employeeId in
 	}
 	return employeeId, err
 }
+
+// FindManagers возвращает руководителей сотрудника снизу вверх, не дальше maxDepth уровней.
+// Путь обхода хранится в path, поэтому цикл в данных не зацикливает запрос
+func (r *Repository) FindManagers(id int64, maxDepth int) (managers []ChainEntity, err error) {
+	query := `WITH RECURSIVE chain AS (
+                  SELECT m.id, m.name, m.manager_id, 1 AS depth, ARRAY[e.id, m.id] AS path
+                  FROM employee e JOIN employee m ON m.id = e.manager_id
+                  WHERE e.id = $1 AND m.id <> e.id
+                  UNION ALL
+                  SELECT m.id, m.name, m.manager_id, c.depth + 1, c.path || m.id
+                  FROM chain c JOIN employee m ON m.id = c.manager_id
+                  WHERE c.depth < $2 AND m.id <> ALL (c.path)
+              )
+              SELECT id, name, manager_id, depth FROM chain ORDER BY depth`
+	err = r.db.Select(&managers, query, id, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	return managers, nil
+}
+
+// FindReports возвращает прямых и косвенных подчинённых сотрудника, не глубже maxDepth уровней
+func (r *Repository) FindReports(id int64, maxDepth int) (reports []ChainEntity, err error) {
+	query := `WITH RECURSIVE chain AS (
+                  SELECT e.id, e.name, e.manager_id, 1 AS depth, ARRAY[$1::BIGINT, e.id] AS path
+                  FROM employee e
+                  WHERE e.manager_id = $1 AND e.id <> $1
+                  UNION ALL
+                  SELECT e.id, e.name, e.manager_id, c.depth + 1, c.path || e.id
+                  FROM chain c JOIN employee e ON e.manager_id = c.id
+                  WHERE c.depth < $2 AND e.id <> ALL (c.path)
+              )
+              SELECT id, name, manager_id, depth FROM chain ORDER BY depth, id`
+	err = r.db.Select(&reports, query, id, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// LockManagersTx не даёт другим транзакциям менять руководителей до завершения текущей:
+// иначе две параллельные смены могли бы вместе образовать цикл
+func (r *Repository) LockManagersTx(tx *sqlx.Tx) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('employee.manager_id'))")
+	return err
+}
+
+// IsReportTx проверяет, подчиняется ли candidateId сотруднику id на любом уровне или совпадает с ним
+func (r *Repository) IsReportTx(tx *sqlx.Tx, id int64, candidateId int64) (report bool, err error) {
+	query := `WITH RECURSIVE chain AS (
+                  SELECT id FROM employee WHERE id = $1
+                  UNION
+                  SELECT e.id FROM employee e JOIN chain c ON e.manager_id = c.id
+              )
+              SELECT EXISTS (SELECT 1 FROM chain WHERE id = $2)`
+	err = tx.Get(&report, query, id, candidateId)
+	return
+}
+
+// SetManagerTx назначает сотруднику руководителя; 0 снимает руководителя.
+// Возвращает sql.ErrNoRows, если сотрудника нет
+func (r *Repository) SetManagerTx(tx *sqlx.Tx, id int64, managerId int64) (employee Entity, err error) {
+	query := `UPDATE employee SET manager_id = NULLIF($1, 0), version = version + 1, updated_at = NOW()
+              WHERE id = $2 RETURNING *`
+	err = tx.Get(&employee, query, managerId, id)
+	return
+}
+
+func (r *Repository) ExistsTx(tx *sqlx.Tx, id int64) (exists bool, err error) {
+	err = tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM employee WHERE id = $1)", id)
+	return
+}
+
+func (r *Repository) FindHistory(id int64) (history []HistoryEntity, err error) {
+	query := `SELECT history_id, employee_id, operation, data, changed_at FROM employee_history
+              WHERE employee_id = $1 ORDER BY changed_at, history_id`
+	err = r.db.Select(&history, query, id)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// FindByIdAsOf возвращает последнюю версию записи, сохранённую не позже момента asOf
+func (r *Repository) FindByIdAsOf(id int64, asOf time.Time) (version HistoryEntity, err error) {
+	query := `SELECT history_id, employee_id, operation, data, changed_at FROM employee_history
+              WHERE employee_id = $1 AND changed_at <= $2
+              ORDER BY changed_at DESC, history_id DESC LIMIT 1`
+	err = r.db.Get(&version, query, id, asOf)
+	return
+}
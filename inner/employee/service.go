@@ -1,25 +1,65 @@
 package employee
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/export"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"io"
+	"log"
+	"time"
 )
 
 // Структура сервиса, которая будет инкапсулировать бизнес-логику
 type Service struct {
-	repo      Repo
-	validator Validator
+	repo        Repo
+	validator   Validator
+	provisioner Provisioner
+	rules       Rules
 }
 
 type CreateRequest struct {
 	Name string `json:"name" validate:"required,min=2,max=155"`
+	Attributes
+}
+
+// UpdateRequest заменяет имя и атрибуты сотрудника; незаданные атрибуты очищаются
+type UpdateRequest struct {
+	Id      int64  `json:"-" validate:"required,gt=0"`
+	Name    string `json:"name" validate:"required,min=2,max=155"`
+	Version int64  `json:"-" validate:"required,gt=0"`
+	Attributes
+}
+
+type ParamIdVersionRequest struct {
+	Id      int64 `validate:"required,gt=0"`
+	Version int64 `validate:"required,gt=0"`
 }
 
 type ParamIdRequest struct {
 	Id int64 `validate:"required,gt=0"`
 }
 
+type ParamIdAsOfRequest struct {
+	Id   int64     `validate:"required,gt=0"`
+	AsOf time.Time `validate:"required"`
+}
+
+// ParamChainRequest запрос цепочки руководителей или подчинённых не глубже Depth уровней
+type ParamChainRequest struct {
+	Id    int64 `validate:"required,gt=0"`
+	Depth int   `validate:"required,gt=0,max=50"`
+}
+
+// SetManagerRequest назначает сотруднику руководителя; ManagerId 0 снимает руководителя
+type SetManagerRequest struct {
+	Id        int64 `json:"-" validate:"required,gt=0"`
+	ManagerId int64 `json:"manager_id" validate:"min=0"`
+}
+
 type ParamIdsRequest struct {
 	Ids []int64 `validate:"required,min=1,dive,gt=0"`
 }
@@ -28,6 +68,16 @@ type Validator interface {
 	Validate(request any) error
 }
 
+// Provisioner асинхронно передаёт изменения сотрудников во внешние системы
+type Provisioner interface {
+	Publish(event provisioning.Event)
+}
+
+// Rules пересчитывает назначенные правилами роли сотрудника по его атрибутам, например assignmentrule.Service
+type Rules interface {
+	Apply(employeeId int64) error
+}
+
 // Согласно идеологии Go:
 // - "принимайте интерфейсы и возвращайте структуры",
 // - "объявляйте интерфейсы там, где вы собираетесь их использовать"
@@ -39,20 +89,57 @@ type Repo interface {
 	FilterByIDs([]int64) ([]Entity, error)
 	DeleteById(int64) (int64, error)
 	DeleteByIds([]int64) (int64, error)
+	Update(*Entity) error
+	DeleteByIdVersion(int64, int64) (int64, error)
 	BeginTransaction() (*sqlx.Tx, error)
 	FindByNameTx(*sqlx.Tx, string) (bool, error)
 	CreateTx(*sqlx.Tx, CreateRequest) (int64, error)
+	FindHistory(int64) ([]HistoryEntity, error)
+	FindByIdAsOf(int64, time.Time) (HistoryEntity, error)
+	Export([]int64, func(ExportEntity) error) error
+	FindManagers(int64, int) ([]ChainEntity, error)
+	FindReports(int64, int) ([]ChainEntity, error)
+	LockManagersTx(*sqlx.Tx) error
+	ExistsTx(*sqlx.Tx, int64) (bool, error)
+	IsReportTx(*sqlx.Tx, int64, int64) (bool, error)
+	SetManagerTx(*sqlx.Tx, int64, int64) (Entity, error)
 }
 
-func NewService(repo Repo, validator Validator) *Service {
+// NewService создаёт сервис сотрудников. Если provisioner равен nil, изменения во внешние системы не передаются,
+// если rules равен nil, роли по атрибутам при создании и изменении сотрудника не назначаются
+func NewService(repo Repo, validator Validator, provisioner Provisioner, rules Rules) *Service {
 	return &Service{
-		repo:      repo,
-		validator: validator,
+		repo:        repo,
+		validator:   validator,
+		provisioner: provisioner,
+		rules:       rules,
+	}
+}
+
+// publish отправляет событие по учётной записи сотрудника, если провижининг включён
+func (srv *Service) publish(eventType provisioning.EventType, id int64, name string) {
+	if srv.provisioner == nil {
+		return
+	}
+	srv.provisioner.Publish(provisioning.Event{
+		Type:    eventType,
+		Account: provisioning.Account{EmployeeId: id, Name: name},
+	})
+}
+
+// applyRules пересчитывает роли сотрудника по правилам. Сотрудник к этому моменту уже сохранён,
+// поэтому ошибка только записывается в лог: расхождение исправит следующий пересчёт по расписанию
+func (srv *Service) applyRules(id int64) {
+	if srv.rules == nil {
+		return
+	}
+	if err := srv.rules.Apply(id); err != nil {
+		log.Printf("employee: applying assignment rules to employee %d failed: %v", id, err)
 	}
 }
 
 func (req *CreateRequest) ToEntity() Entity {
-	return Entity{Name: req.Name}
+	return Entity{Name: req.Name, Attributes: req.Attributes}
 }
 
 func (srv *Service) FindById(request ParamIdRequest) (Response, error) {
@@ -74,6 +161,7 @@ func (srv *Service) Create(e Entity) error {
 		return fmt.Errorf("employee not created: %w", err)
 	}
 
+	srv.publish(provisioning.AccountCreated, e.Id, e.Name)
 	return nil
 }
 
@@ -83,6 +171,7 @@ func (srv *Service) CreateNamed(e Entity) error {
 		return fmt.Errorf("employee not created: %w", err)
 	}
 
+	srv.publish(provisioning.AccountCreated, e.Id, e.Name)
 	return nil
 }
 
@@ -126,6 +215,44 @@ func (srv *Service) DeleteById(request ParamIdRequest) (int64, error) {
 		return 0, fmt.Errorf("error delete employee by id: %w", err)
 	}
 
+	if count > 0 {
+		srv.publish(provisioning.AccountDeleted, request.Id, "")
+	}
+	return count, nil
+}
+
+// UpdateEmployee обновляет сотрудника, если с момента чтения его версия не изменилась.
+// Если версия ушла вперёд, возвращается common.ConflictError
+func (srv *Service) UpdateEmployee(request UpdateRequest) (Response, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return Response{}, common.RequestValidationError{Message: err.Error()}
+	}
+	var entity = Entity{Id: request.Id, Name: request.Name, Attributes: request.Attributes, Version: request.Version}
+	err = srv.repo.Update(&entity)
+	if err != nil {
+		return Response{}, fmt.Errorf("error update employee with id %d: %w", request.Id, err)
+	}
+
+	srv.publish(provisioning.AccountUpdated, entity.Id, entity.Name)
+	srv.applyRules(entity.Id)
+	return entity.toResponse(), nil
+}
+
+// DeleteByIdVersion удаляет сотрудника, если с момента чтения его версия не изменилась
+func (srv *Service) DeleteByIdVersion(request ParamIdVersionRequest) (int64, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return 0, common.RequestValidationError{Message: err.Error()}
+	}
+	count, err := srv.repo.DeleteByIdVersion(request.Id, request.Version)
+	if err != nil {
+		return 0, fmt.Errorf("error delete employee by id: %w", err)
+	}
+
+	if count > 0 {
+		srv.publish(provisioning.AccountDeleted, request.Id, "")
+	}
 	return count, nil
 }
 
@@ -139,6 +266,13 @@ func (srv *Service) DeleteByIds(request ParamIdsRequest) (int64, error) {
 		return 0, fmt.Errorf("error delete employee by ids: %w", err)
 	}
 
+	// репозиторий не сообщает, какие именно id были удалены,
+	// поэтому событие отправляется по каждому: удаление в коннекторах идемпотентно
+	if count > 0 {
+		for _, id := range request.Ids {
+			srv.publish(provisioning.AccountDeleted, id, "")
+		}
+	}
 	return count, nil
 }
 
@@ -151,7 +285,23 @@ func (srv *Service) CreateEmployee(request CreateRequest) (int64, error) {
 		return 0, common.RequestValidationError{Message: err.Error()}
 	}
 
+	id, err := srv.createEmployeeTx(request)
+	if err != nil {
+		return 0, err
+	}
+
+	// событие отправляется только после коммита, чтобы не создать учётную запись для откатившейся вставки
+	srv.publish(provisioning.AccountCreated, id, request.Name)
+	srv.applyRules(id)
+	return id, nil
+}
+
+// createEmployeeTx проверяет уникальность имени и создаёт сотрудника в одной транзакции
+func (srv *Service) createEmployeeTx(request CreateRequest) (id int64, err error) {
 	tx, err := srv.repo.BeginTransaction()
+	if err != nil {
+		return 0, fmt.Errorf("error creating transaction: %w", err)
+	}
 
 	// отложенная функция завершения транзакции
 	defer func() {
@@ -178,10 +328,6 @@ func (srv *Service) CreateEmployee(request CreateRequest) (int64, error) {
 		}
 	}()
 
-	if err != nil {
-		return 0, fmt.Errorf("error creating transaction: %w", err)
-	}
-
 	isExists, err := srv.repo.FindByNameTx(tx, request.Name)
 	if err != nil {
 		return 0, fmt.Errorf("error finding employee by name: %w", err)
@@ -195,3 +341,224 @@ func (srv *Service) CreateEmployee(request CreateRequest) (int64, error) {
 	}
 	return newEmployeeId, nil
 }
+
+// FindHistory возвращает все версии сотрудника от самой старой к самой новой
+// вместе со списком полей, изменившихся относительно предыдущей версии
+func (srv *Service) FindHistory(request ParamIdRequest) ([]HistoryResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return []HistoryResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	history, err := srv.repo.FindHistory(request.Id)
+	if err != nil {
+		return []HistoryResponse{}, fmt.Errorf("error get history of employee with id %d: %w", request.Id, err)
+	}
+
+	var resp = []HistoryResponse{}
+	var prev []byte
+	for _, h := range history {
+		employee, err := h.toResponse()
+		if err != nil {
+			return []HistoryResponse{}, fmt.Errorf("error decoding history of employee with id %d: %w", request.Id, err)
+		}
+		changes, err := common.DiffSnapshots(prev, h.Data)
+		if err != nil {
+			return []HistoryResponse{}, fmt.Errorf("error comparing history of employee with id %d: %w", request.Id, err)
+		}
+		// версия берётся из снимка: это то же значение, что отдаётся в ETag,
+		// а номер в списке разошёлся бы с ним после очистки или переноса истории
+		resp = append(resp, HistoryResponse{
+			Version:   employee.Version,
+			Operation: h.Operation,
+			ChangedAt: h.ChangedAt,
+			Employee:  employee,
+			Changes:   changes,
+		})
+		prev = h.Data
+	}
+	return resp, nil
+}
+
+// FindByIdAsOf восстанавливает состояние сотрудника на момент request.AsOf
+func (srv *Service) FindByIdAsOf(request ParamIdAsOfRequest) (Response, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return Response{}, common.RequestValidationError{Message: err.Error()}
+	}
+	version, err := srv.repo.FindByIdAsOf(request.Id, request.AsOf)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && version.Operation == "DELETE") {
+		return Response{}, common.NotFoundError{
+			Message: fmt.Sprintf("employee with id %d did not exist at %s", request.Id, request.AsOf.Format(time.RFC3339)),
+		}
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("error finding employee with id %d as of %s: %w", request.Id, request.AsOf.Format(time.RFC3339), err)
+	}
+
+	employee, err := version.toResponse()
+	if err != nil {
+		return Response{}, fmt.Errorf("error decoding history of employee with id %d: %w", request.Id, err)
+	}
+	return employee, nil
+}
+
+// FindManagers возвращает руководителей сотрудника от непосредственного вверх, не дальше request.Depth уровней
+func (srv *Service) FindManagers(request ParamChainRequest) ([]ChainResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return []ChainResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	if _, err = srv.findExisting(request.Id); err != nil {
+		return []ChainResponse{}, err
+	}
+	managers, err := srv.repo.FindManagers(request.Id, request.Depth)
+	if err != nil {
+		return []ChainResponse{}, fmt.Errorf("error get managers of employee with id %d: %w", request.Id, err)
+	}
+	return toChainResponse(managers), nil
+}
+
+// FindReports возвращает прямых и косвенных подчинённых сотрудника, не глубже request.Depth уровней
+func (srv *Service) FindReports(request ParamChainRequest) ([]ChainResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return []ChainResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	if _, err = srv.findExisting(request.Id); err != nil {
+		return []ChainResponse{}, err
+	}
+	reports, err := srv.repo.FindReports(request.Id, request.Depth)
+	if err != nil {
+		return []ChainResponse{}, fmt.Errorf("error get reports of employee with id %d: %w", request.Id, err)
+	}
+	return toChainResponse(reports), nil
+}
+
+// SetManager назначает сотруднику руководителя. Руководителем нельзя назначить самого сотрудника
+// или его подчинённого на любом уровне, чтобы в цепочке руководителей не появился цикл
+func (srv *Service) SetManager(request SetManagerRequest) (Response, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return Response{}, common.RequestValidationError{Message: err.Error()}
+	}
+	entity, err := srv.setManagerTx(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	srv.publish(provisioning.AccountUpdated, entity.Id, entity.Name)
+	return entity.toResponse(), nil
+}
+
+// setManagerTx проверяет отсутствие цикла и меняет руководителя в одной транзакции
+func (srv *Service) setManagerTx(request SetManagerRequest) (entity Entity, err error) {
+	tx, err := srv.repo.BeginTransaction()
+	if err != nil {
+		return Entity{}, fmt.Errorf("error creating transaction: %w", err)
+	}
+
+	// отложенная функция завершения транзакции
+	defer func() {
+		// проверяем, не было ли паники
+		if r := recover(); r != nil {
+			err = fmt.Errorf("setting manager panic: %v", r)
+			// если была паника, то откатываем транзакцию
+			errTx := tx.Rollback()
+			if errTx != nil {
+				err = fmt.Errorf("setting manager: rolling back transaction errors: %w, %w", err, errTx)
+			}
+		} else if err != nil {
+			// если произошла другая ошибка (не паника), то откатываем транзакцию
+			errTx := tx.Rollback()
+			if errTx != nil {
+				err = fmt.Errorf("setting manager: rolling back transaction errors: %w, %w", err, errTx)
+			}
+		} else {
+			// если ошибок нет, то коммитим транзакцию
+			errTx := tx.Commit()
+			if errTx != nil {
+				err = fmt.Errorf("setting manager: commiting transaction error: %w", errTx)
+			}
+		}
+	}()
+
+	if err = srv.repo.LockManagersTx(tx); err != nil {
+		return Entity{}, fmt.Errorf("error locking managers: %w", err)
+	}
+	if request.ManagerId > 0 {
+		exists, err := srv.repo.ExistsTx(tx, request.ManagerId)
+		if err != nil {
+			return Entity{}, fmt.Errorf("error finding employee with id %d: %w", request.ManagerId, err)
+		}
+		if !exists {
+			return Entity{}, common.NotFoundError{Message: fmt.Sprintf("manager with id %d not found", request.ManagerId)}
+		}
+		report, err := srv.repo.IsReportTx(tx, request.Id, request.ManagerId)
+		if err != nil {
+			return Entity{}, fmt.Errorf("error checking reporting line of employee with id %d: %w", request.Id, err)
+		}
+		if report {
+			return Entity{}, common.RequestValidationError{
+				Message: fmt.Sprintf("employee %d cannot report to %d: it is the employee or one of their reports", request.Id, request.ManagerId),
+			}
+		}
+	}
+	entity, err = srv.repo.SetManagerTx(tx, request.Id, request.ManagerId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entity{}, common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", request.Id)}
+	}
+	if err != nil {
+		return Entity{}, fmt.Errorf("error setting manager of employee with id %d: %w", request.Id, err)
+	}
+	return entity, nil
+}
+
+// findExisting возвращает сотрудника или common.NotFoundError, если его нет
+func (srv *Service) findExisting(id int64) (Entity, error) {
+	entity, err := srv.repo.FindById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entity{}, common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", id)}
+	}
+	if err != nil {
+		return Entity{}, fmt.Errorf("error finding employee with id %d: %w", id, err)
+	}
+	return entity, nil
+}
+
+func toChainResponse(entities []ChainEntity) []ChainResponse {
+	var resp = []ChainResponse{}
+	for _, e := range entities {
+		resp = append(resp, e.toResponse())
+	}
+	return resp
+}
+
+// Export проверяет параметры выгрузки и возвращает функцию, которая потоком пишет сотрудников в выбранном формате
+func (srv *Service) Export(request export.Request) (export.Stream, error) {
+	var err = request.Validate()
+	if err != nil {
+		return nil, common.RequestValidationError{Message: err.Error()}
+	}
+	columns, err := export.SelectColumns(request.Columns, ExportColumns)
+	if err != nil {
+		return nil, common.RequestValidationError{Message: err.Error()}
+	}
+
+	return func(w io.Writer) error {
+		writer, err := export.NewWriter(request.Format, w, columns)
+		if err != nil {
+			return err
+		}
+		var values = make([]any, len(columns))
+		err = srv.repo.Export(request.Ids, func(e ExportEntity) error {
+			for i, c := range columns {
+				values[i] = e.value(c)
+			}
+			return writer.Write(values)
+		})
+		if err != nil {
+			return fmt.Errorf("error export employees: %w", err)
+		}
+		return writer.Close()
+	}, nil
+}
@@ -1,6 +1,8 @@
 package employee
 
 import (
+	"bytes"
+	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
@@ -11,6 +13,9 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/export"
+	"github.com/zhedevops/idm/inner/provisioning"
 	"github.com/zhedevops/idm/inner/validator"
 )
 
@@ -70,6 +75,67 @@ func (m *MockRepo) CreateTx(tx *sqlx.Tx, request CreateRequest) (int64, error) {
 	return args.Get(0).(int64), args.Error(1)
 }
 
+func (m *MockRepo) Update(e *Entity) error {
+	args := m.Called(e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) DeleteByIdVersion(id int64, version int64) (int64, error) {
+	args := m.Called(id, version)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindHistory(id int64) ([]HistoryEntity, error) {
+	args := m.Called(id)
+	return args.Get(0).([]HistoryEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindByIdAsOf(id int64, asOf time.Time) (HistoryEntity, error) {
+	args := m.Called(id, asOf)
+	return args.Get(0).(HistoryEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindManagers(id int64, maxDepth int) ([]ChainEntity, error) {
+	args := m.Called(id, maxDepth)
+	return args.Get(0).([]ChainEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindReports(id int64, maxDepth int) ([]ChainEntity, error) {
+	args := m.Called(id, maxDepth)
+	return args.Get(0).([]ChainEntity), args.Error(1)
+}
+
+func (m *MockRepo) LockManagersTx(tx *sqlx.Tx) error {
+	args := m.Called(tx)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ExistsTx(tx *sqlx.Tx, id int64) (bool, error) {
+	args := m.Called(tx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) IsReportTx(tx *sqlx.Tx, id int64, candidateId int64) (bool, error) {
+	args := m.Called(tx, id, candidateId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) SetManagerTx(tx *sqlx.Tx, id int64, managerId int64) (Entity, error) {
+	args := m.Called(tx, id, managerId)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+// Export передаёт в fn строки, которые вернул мок
+func (m *MockRepo) Export(ids []int64, fn func(ExportEntity) error) error {
+	args := m.Called(ids)
+	for _, e := range args.Get(0).([]ExportEntity) {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
 func TestFindById(t *testing.T) {
 	var a = assert.New(t)
 	var validator = validator.New()
@@ -78,7 +144,7 @@ func TestFindById(t *testing.T) {
 		// создаём экземпляр мок-объекта
 		var repo = new(MockRepo)
 		// создаём экземпляр сервиса, который собираемся тестировать. Передаём в его конструктор мок вместо реального репозитория
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		// создаём Entity, которую должен вернуть репозиторий
 		var entity = Entity{
 			Id:        1,
@@ -107,7 +173,7 @@ func TestFindById(t *testing.T) {
 		// выполненных в рамках одного нашего теста.
 		// Ели сделать мок общим для нескольких тестов, то он посчитает вызовы, которые сделали все тесты
 		var repo = new(MockRepo)
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		// создаём пустую структуру employee.Entity, которую сервис вернёт вместе с ошибкой
 		var entity = Entity{}
 		req := ParamIdRequest{Id: 1}
@@ -129,7 +195,7 @@ func TestCreateNamed(t *testing.T) {
 	var a = assert.New(t)
 	var validator = validator.New()
 	var repo = new(MockRepo)
-	var svc = NewService(repo, validator)
+	var svc = NewService(repo, validator, nil, nil)
 	t.Run("error is nil", func(t *testing.T) {
 		var entity = Entity{
 			Name: "Grigory Leps",
@@ -154,7 +220,7 @@ func TestFindAll(t *testing.T) {
 	var validator = validator.New()
 	t.Run("found employees", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		var entity1 = Entity{
 			Id:        1,
 			Name:      "Grigory Leps",
@@ -179,7 +245,7 @@ func TestFindAll(t *testing.T) {
 	})
 	t.Run("not found employees", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		var entities = []Entity{}
 		var want []Response
 		repo.On("FindAll").Return(entities, nil)
@@ -206,7 +272,7 @@ func TestFilterByIDs(t *testing.T) {
 	}
 	var entities = []Entity{entity1, entity2}
 	var repo = new(MockRepo)
-	var svc = NewService(repo, validator)
+	var svc = NewService(repo, validator, nil, nil)
 	t.Run("found employees", func(t *testing.T) {
 		var req = ParamIdsRequest{Ids: []int64{1, 2}}
 		var want []Response
@@ -234,7 +300,7 @@ func TestDeleteById(t *testing.T) {
 	var a = assert.New(t)
 	var validator = validator.New()
 	var repo = new(MockRepo)
-	var svc = NewService(repo, validator)
+	var svc = NewService(repo, validator, nil, nil)
 	t.Run("delete employee", func(t *testing.T) {
 		var req = ParamIdRequest{Id: 1}
 		repo.On("DeleteById", req.Id).Return(int64(1), nil)
@@ -257,7 +323,7 @@ func TestDeleteByIds(t *testing.T) {
 	var a = assert.New(t)
 	var validator = validator.New()
 	var repo = new(MockRepo)
-	var svc = NewService(repo, validator)
+	var svc = NewService(repo, validator, nil, nil)
 	t.Run("delete employees", func(t *testing.T) {
 		var req = ParamIdsRequest{Ids: []int64{1, 2}}
 		repo.On("DeleteByIds", req.Ids).Return(int64(2), nil)
@@ -285,7 +351,7 @@ func TestCreateEmployee(t *testing.T) {
 
 	t.Run("success begin transaction and create employee", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		db, mock, err := sqlmock.New()
 		a.Nil(err)
 		sqlxDB := sqlx.NewDb(db, "sqlmock")
@@ -303,7 +369,7 @@ func TestCreateEmployee(t *testing.T) {
 
 	t.Run("failure begin transaction", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		db, mock, err := sqlmock.New()
 		a.Nil(err)
 		sqlxDB := sqlx.NewDb(db, "sqlmock")
@@ -321,7 +387,7 @@ func TestCreateEmployee(t *testing.T) {
 
 	t.Run("failure on FindByNameTx", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		db, mock, err := sqlmock.New()
 		a.Nil(err)
 		sqlxDB := sqlx.NewDb(db, "sqlmock")
@@ -343,7 +409,7 @@ func TestCreateEmployee(t *testing.T) {
 
 	t.Run("entity already exists", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		db, mock, err := sqlmock.New()
 		a.Nil(err)
 		sqlxDB := sqlx.NewDb(db, "sqlmock")
@@ -362,7 +428,7 @@ func TestCreateEmployee(t *testing.T) {
 
 	t.Run("error create employee", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo, validator)
+		var svc = NewService(repo, validator, nil, nil)
 		db, mock, err := sqlmock.New()
 		a.Nil(err)
 		sqlxDB := sqlx.NewDb(db, "sqlmock")
@@ -466,3 +532,412 @@ func TestService_FindById(t *testing.T) {
 		})
 	}
 }
+
+func TestFindHistory(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+
+	t.Run("should return versions with changes", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var tm = time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+		var history = []HistoryEntity{
+			{
+				HistoryId:  1,
+				EmployeeId: 1,
+				Operation:  "INSERT",
+				Data:       []byte(`{"id": 1, "name": "John Doe", "version": 4}`),
+				ChangedAt:  tm,
+			},
+			{
+				HistoryId:  2,
+				EmployeeId: 1,
+				Operation:  "UPDATE",
+				Data:       []byte(`{"id": 1, "name": "John Deer", "version": 5}`),
+				ChangedAt:  tm.Add(time.Hour),
+			},
+		}
+		repo.On("FindHistory", int64(1)).Return(history, nil)
+		var got, err = svc.FindHistory(ParamIdRequest{Id: 1})
+		a.Nil(err)
+		a.Len(got, 2)
+		// версии из снимков, а не номера в списке: более ранняя история очищена
+		a.Equal(int64(4), got[0].Version)
+		a.Equal("John Doe", got[0].Employee.Name)
+		a.Len(got[0].Changes, 3)
+		a.Equal(int64(5), got[1].Version)
+		a.Equal("UPDATE", got[1].Operation)
+		a.Equal("John Deer", got[1].Employee.Name)
+		// во второй версии изменились имя и версия
+		a.Equal([]common.FieldChange{
+			{Field: "name", Old: "John Doe", New: "John Deer"},
+			{Field: "version", Old: float64(4), New: float64(5)},
+		}, got[1].Changes)
+	})
+
+	t.Run("should return validation error", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var _, err = svc.FindHistory(ParamIdRequest{Id: 0})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		a.True(repo.AssertNumberOfCalls(t, "FindHistory", 0))
+	})
+}
+
+func TestFindByIdAsOf(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+	var asOf = time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	t.Run("should return employee state at the moment", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var version = HistoryEntity{
+			HistoryId:  1,
+			EmployeeId: 1,
+			Operation:  "UPDATE",
+			Data:       []byte(`{"id": 1, "name": "John Doe", "created_at": "2025-11-01T10:00:00+00:00"}`),
+		}
+		repo.On("FindByIdAsOf", int64(1), asOf).Return(version, nil)
+		var got, err = svc.FindByIdAsOf(ParamIdAsOfRequest{Id: 1, AsOf: asOf})
+		a.Nil(err)
+		a.Equal(int64(1), got.Id)
+		a.Equal("John Doe", got.Name)
+		a.True(got.CreatedAt.Equal(time.Date(2025, 11, 1, 10, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("should return not found before creation", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		repo.On("FindByIdAsOf", int64(1), asOf).Return(HistoryEntity{}, sql.ErrNoRows)
+		var _, err = svc.FindByIdAsOf(ParamIdAsOfRequest{Id: 1, AsOf: asOf})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+
+	t.Run("should return not found after deletion", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var version = HistoryEntity{Operation: "DELETE", Data: []byte(`{"id": 1, "name": "John Doe"}`)}
+		repo.On("FindByIdAsOf", int64(1), asOf).Return(version, nil)
+		var _, err = svc.FindByIdAsOf(ParamIdAsOfRequest{Id: 1, AsOf: asOf})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+
+	t.Run("should return wrapped error", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var err = errors.New("database error")
+		repo.On("FindByIdAsOf", int64(1), asOf).Return(HistoryEntity{}, err)
+		var _, got = svc.FindByIdAsOf(ParamIdAsOfRequest{Id: 1, AsOf: asOf})
+		a.ErrorIs(got, err)
+	})
+}
+
+func TestUpdateEmployee(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+
+	t.Run("should return updated employee with new version", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var request = UpdateRequest{Id: 1, Name: "John Deer", Version: 2}
+		repo.On("Update", &Entity{Id: 1, Name: "John Deer", Version: 2}).
+			Run(func(args mock.Arguments) {
+				// репозиторий увеличивает версию при успешном обновлении
+				args.Get(0).(*Entity).Version = 3
+			}).
+			Return(nil)
+		var got, err = svc.UpdateEmployee(request)
+		a.Nil(err)
+		a.Equal("John Deer", got.Name)
+		a.Equal(int64(3), got.Version)
+	})
+
+	t.Run("should return conflict error", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var request = UpdateRequest{Id: 1, Name: "John Deer", Version: 2}
+		var conflict = common.ConflictError{Message: "employee with id 1 was modified by another request"}
+		repo.On("Update", &Entity{Id: 1, Name: "John Deer", Version: 2}).Return(conflict)
+		var _, err = svc.UpdateEmployee(request)
+		a.ErrorAs(err, &common.ConflictError{})
+	})
+
+	t.Run("should return validation error without version", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		var _, err = svc.UpdateEmployee(UpdateRequest{Id: 1, Name: "John Deer"})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		a.True(repo.AssertNumberOfCalls(t, "Update", 0))
+	})
+}
+
+func TestDeleteByIdVersion(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+	var repo = new(MockRepo)
+	var svc = NewService(repo, validator, nil, nil)
+	t.Run("delete employee", func(t *testing.T) {
+		repo.On("DeleteByIdVersion", int64(1), int64(1)).Return(int64(1), nil)
+		var count, err = svc.DeleteByIdVersion(ParamIdVersionRequest{Id: 1, Version: 1})
+		a.Nil(err)
+		a.Equal(int64(1), count)
+	})
+	t.Run("version conflict", func(t *testing.T) {
+		var conflict = common.ConflictError{Message: "employee with id 2 was modified by another request"}
+		repo.On("DeleteByIdVersion", int64(2), int64(1)).Return(int64(0), conflict)
+		var count, err = svc.DeleteByIdVersion(ParamIdVersionRequest{Id: 2, Version: 1})
+		a.ErrorAs(err, &common.ConflictError{})
+		a.Equal(int64(0), count)
+	})
+}
+
+// StubProvisioner запоминает опубликованные события
+type StubProvisioner struct {
+	events []provisioning.Event
+}
+
+func (p *StubProvisioner) Publish(event provisioning.Event) {
+	p.events = append(p.events, event)
+}
+
+func TestProvisioningEvents(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+	var request = CreateRequest{Name: "Uncle Bob"}
+
+	t.Run("should publish account created after commit", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		db, mock, err := sqlmock.New()
+		a.Nil(err)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+		a.Nil(err)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, request.Name).Return(false, nil)
+		repo.On("CreateTx", tx, request).Return(int64(7), nil)
+		_, err = svc.CreateEmployee(request)
+		a.Nil(err)
+		a.Equal([]provisioning.Event{
+			{Type: provisioning.AccountCreated, Account: provisioning.Account{EmployeeId: 7, Name: "Uncle Bob"}},
+		}, provisioner.events)
+	})
+
+	t.Run("should not publish when commit fails", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		db, mock, err := sqlmock.New()
+		a.Nil(err)
+		mock.ExpectBegin()
+		mock.ExpectCommit().WillReturnError(errors.New("connection lost"))
+		tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+		a.Nil(err)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, request.Name).Return(false, nil)
+		repo.On("CreateTx", tx, request).Return(int64(7), nil)
+		_, err = svc.CreateEmployee(request)
+		a.NotNil(err)
+		a.Empty(provisioner.events)
+	})
+
+	t.Run("should publish account updated and deleted", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		repo.On("Update", &Entity{Id: 1, Name: "John Deer", Version: 2}).Return(nil)
+		repo.On("DeleteById", int64(1)).Return(int64(1), nil)
+		repo.On("DeleteById", int64(2)).Return(int64(0), nil)
+		_, err := svc.UpdateEmployee(UpdateRequest{Id: 1, Name: "John Deer", Version: 2})
+		a.Nil(err)
+		_, err = svc.DeleteById(ParamIdRequest{Id: 1})
+		a.Nil(err)
+		// несуществующий сотрудник не порождает события
+		_, err = svc.DeleteById(ParamIdRequest{Id: 2})
+		a.Nil(err)
+		a.Equal([]provisioning.Event{
+			{Type: provisioning.AccountUpdated, Account: provisioning.Account{EmployeeId: 1, Name: "John Deer"}},
+			{Type: provisioning.AccountDeleted, Account: provisioning.Account{EmployeeId: 1}},
+		}, provisioner.events)
+	})
+}
+
+func TestExport(t *testing.T) {
+	var a = assert.New(t)
+	var created = time.Date(2025, 11, 1, 10, 0, 0, 0, time.UTC)
+	var rows = []ExportEntity{
+		{
+			Entity: Entity{Id: 1, Name: "John Doe", ExternalId: sql.NullString{String: "E-1", Valid: true},
+				Version: 2, CreatedAt: created, UpdatedAt: created},
+			Roles: []string{"Admin", "Auditor"},
+		},
+		{Entity: Entity{Id: 2, Name: "Jane, Doe", Version: 1, CreatedAt: created, UpdatedAt: created}, Roles: []string{}},
+	}
+
+	t.Run("should write csv with all columns by default", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		repo.On("Export", []int64(nil)).Return(rows, nil)
+		stream, err := svc.Export(export.Request{Format: export.FormatCsv})
+		a.Nil(err)
+		var out bytes.Buffer
+		a.Nil(stream(&out))
+		a.Equal("id,name,external_id,version,created_at,updated_at,roles\n"+
+			"1,John Doe,E-1,2,2025-11-01T10:00:00Z,2025-11-01T10:00:00Z,Admin;Auditor\n"+
+			"2,\"Jane, Doe\",,1,2025-11-01T10:00:00Z,2025-11-01T10:00:00Z,\n", out.String())
+	})
+
+	t.Run("should reject unsupported format", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		var _, err = svc.Export(export.Request{Format: "pdf"})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should reject invalid id filter", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		var _, err = svc.Export(export.Request{Format: export.FormatCsv, Ids: []int64{0}})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+}
+
+// StubRules запоминает сотрудников, для которых пересчитывались правила
+type StubRules struct {
+	ids []int64
+	err error
+}
+
+func (r *StubRules) Apply(employeeId int64) error {
+	r.ids = append(r.ids, employeeId)
+	return r.err
+}
+
+func TestApplyRules(t *testing.T) {
+	var a = assert.New(t)
+	var attributes = Attributes{Department: "Engineering", Location: "Berlin"}
+
+	t.Run("should apply rules to created employee", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var rules = new(StubRules)
+		var svc = NewService(repo, validator.New(), nil, rules)
+		var request = CreateRequest{Name: "Uncle Bob", Attributes: attributes}
+		db, mock, err := sqlmock.New()
+		a.Nil(err)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+		a.Nil(err)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, request.Name).Return(false, nil)
+		repo.On("CreateTx", tx, request).Return(int64(7), nil)
+		_, err = svc.CreateEmployee(request)
+		a.Nil(err)
+		a.Equal([]int64{7}, rules.ids)
+	})
+
+	t.Run("should keep update when rules fail", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var rules = &StubRules{err: errors.New("database is down")}
+		var svc = NewService(repo, validator.New(), nil, rules)
+		repo.On("Update", &Entity{Id: 1, Name: "John Deer", Attributes: attributes, Version: 2}).Return(nil)
+		var resp, err = svc.UpdateEmployee(UpdateRequest{Id: 1, Name: "John Deer", Version: 2, Attributes: attributes})
+		a.Nil(err)
+		a.Equal("Engineering", resp.Department)
+		a.Equal([]int64{1}, rules.ids)
+	})
+}
+
+func TestReportingLine(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should find managers up to depth", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		repo.On("FindById", int64(3)).Return(Entity{Id: 3}, nil)
+		repo.On("FindManagers", int64(3), 2).Return([]ChainEntity{
+			{Id: 2, Name: "Team Lead", ManagerId: sql.NullInt64{Int64: 1, Valid: true}, Depth: 1},
+			{Id: 1, Name: "CTO", Depth: 2},
+		}, nil)
+		var resp, err = svc.FindManagers(ParamChainRequest{Id: 3, Depth: 2})
+		a.Nil(err)
+		a.Equal([]ChainResponse{{Id: 2, Name: "Team Lead", ManagerId: 1, Depth: 1}, {Id: 1, Name: "CTO", Depth: 2}}, resp)
+	})
+
+	t.Run("should reject depth over limit", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator.New(), nil, nil)
+		var _, err = svc.FindReports(ParamChainRequest{Id: 1, Depth: 51})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should return not found for unknown employee", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		repo.On("FindById", int64(99)).Return(Entity{}, sql.ErrNoRows)
+		var _, err = svc.FindReports(ParamChainRequest{Id: 99, Depth: 1})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+}
+
+func TestSetManager(t *testing.T) {
+	var a = assert.New(t)
+	var newTx = func(commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New()
+		a.Nil(err)
+		mock.ExpectBegin()
+		if commit {
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+		tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+		a.Nil(err)
+		return tx, mock
+	}
+
+	t.Run("should set manager", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		tx, sqlMock := newTx(true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockManagersTx", tx).Return(nil)
+		repo.On("ExistsTx", tx, int64(1)).Return(true, nil)
+		repo.On("IsReportTx", tx, int64(3), int64(1)).Return(false, nil)
+		repo.On("SetManagerTx", tx, int64(3), int64(1)).
+			Return(Entity{Id: 3, ManagerId: sql.NullInt64{Int64: 1, Valid: true}, Version: 2}, nil)
+		var resp, err = svc.SetManager(SetManagerRequest{Id: 3, ManagerId: 1})
+		a.Nil(err)
+		a.Equal(int64(1), resp.ManagerId)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject manager from own reporting line", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		tx, sqlMock := newTx(false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockManagersTx", tx).Return(nil)
+		repo.On("ExistsTx", tx, int64(3)).Return(true, nil)
+		repo.On("IsReportTx", tx, int64(1), int64(3)).Return(true, nil)
+		var _, err = svc.SetManager(SetManagerRequest{Id: 1, ManagerId: 3})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		repo.AssertNotCalled(t, "SetManagerTx", mock.Anything, mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should clear manager without checks", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil, nil)
+		tx, _ := newTx(true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockManagersTx", tx).Return(nil)
+		repo.On("SetManagerTx", tx, int64(3), int64(0)).Return(Entity{Id: 3, Version: 3}, nil)
+		var resp, err = svc.SetManager(SetManagerRequest{Id: 3})
+		a.Nil(err)
+		a.Zero(resp.ManagerId)
+		repo.AssertNotCalled(t, "IsReportTx", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
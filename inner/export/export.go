@@ -0,0 +1,70 @@
+// Package export записывает строки таблицы в CSV, JSON Lines или XLSX по мере их чтения из базы,
+// не накапливая всю выгрузку в памяти
+package export
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Поддерживаемые форматы выгрузки
+const (
+	FormatCsv    = "csv"
+	FormatNdjson = "ndjson"
+	FormatXlsx   = "xlsx"
+)
+
+var Formats = []string{FormatCsv, FormatNdjson, FormatXlsx}
+
+// Writer записывает строки выгрузки. Значения передаются в порядке колонок, заданных при создании
+type Writer interface {
+	Write(values []any) error
+	// Close дописывает окончание файла; без него XLSX получится повреждённым
+	Close() error
+}
+
+// NewWriter создаёт Writer для формата и сразу записывает заголовок
+func NewWriter(format string, w io.Writer, columns []string) (Writer, error) {
+	switch format {
+	case FormatCsv:
+		return newCsvWriter(w, columns)
+	case FormatNdjson:
+		return newNdjsonWriter(w, columns), nil
+	case FormatXlsx:
+		return newXlsxWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unsupported export format %q, expected one of %v", format, Formats)
+	}
+}
+
+// ContentType MIME-тип файла в формате format
+func ContentType(format string) string {
+	switch format {
+	case FormatNdjson:
+		return "application/x-ndjson"
+	case FormatXlsx:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// SelectColumns разбирает список колонок через запятую. Пустой список означает все колонки available
+func SelectColumns(requested string, available []string) ([]string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return available, nil
+	}
+	var columns []string
+	for _, c := range strings.Split(requested, ",") {
+		c = strings.TrimSpace(c)
+		if !slices.Contains(available, c) {
+			return nil, fmt.Errorf("unknown column %q, expected any of %v", c, available)
+		}
+		if !slices.Contains(columns, c) {
+			columns = append(columns, c)
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,124 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectColumns(t *testing.T) {
+	var a = assert.New(t)
+	var available = []string{"id", "name", "roles"}
+
+	t.Run("should return all columns when none requested", func(t *testing.T) {
+		columns, err := SelectColumns(" ", available)
+		a.Nil(err)
+		a.Equal(available, columns)
+	})
+
+	t.Run("should keep requested order and drop duplicates", func(t *testing.T) {
+		columns, err := SelectColumns("roles, id,roles", available)
+		a.Nil(err)
+		a.Equal([]string{"roles", "id"}, columns)
+	})
+
+	t.Run("should reject unknown column", func(t *testing.T) {
+		var _, err = SelectColumns("id,password", available)
+		a.ErrorContains(err, `unknown column "password"`)
+	})
+}
+
+func TestNdjsonWriter(t *testing.T) {
+	var a = assert.New(t)
+	var out bytes.Buffer
+	writer, err := NewWriter(FormatNdjson, &out, []string{"id", "created_at", "roles"})
+	a.Nil(err)
+	a.Nil(writer.Write([]any{int64(7), time.Date(2025, 11, 1, 10, 0, 0, 0, time.UTC), []string{"Admin"}}))
+	a.Nil(writer.Close())
+	a.Equal(`{"id":7,"created_at":"2025-11-01T10:00:00Z","roles":["Admin"]}`+"\n", out.String())
+}
+
+func TestCsvWriter(t *testing.T) {
+	var a = assert.New(t)
+	var out bytes.Buffer
+	writer, err := NewWriter(FormatCsv, &out, []string{"id", "name", "title", "roles"})
+	a.Nil(err)
+	a.Nil(writer.Write([]any{int64(-1), "=HYPERLINK(\"http://evil\")", "@SUM(A1)", []string{"-Admin", "+Auditor"}}))
+	a.Nil(writer.Write([]any{int64(2), "Jane Roe", "", []string{}}))
+	a.Nil(writer.Close())
+	a.Equal("id,name,title,roles\n"+
+		`-1,"'=HYPERLINK(""http://evil"")",'@SUM(A1),'-Admin;+Auditor`+"\n"+
+		"2,Jane Roe,,\n", out.String())
+}
+
+// failingWriter отказывает в каждой записи, как оборванное соединение клиента
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestXlsxWriter(t *testing.T) {
+	var a = assert.New(t)
+	var out bytes.Buffer
+	writer, err := NewWriter(FormatXlsx, &out, []string{"id", "name"})
+	a.Nil(err)
+	a.Nil(writer.Write([]any{int64(1), "Tom & Jerry <QA>\x01"}))
+	a.Nil(writer.Close())
+
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	a.Nil(err)
+	var parts = map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		a.Nil(err)
+		parts[f.Name], err = io.ReadAll(r)
+		a.Nil(err)
+	}
+	a.Contains(parts, "[Content_Types].xml")
+	a.Contains(parts, "xl/workbook.xml")
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	a.Nil(xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet))
+	a.Len(sheet.Rows, 2)
+	a.Equal("id", sheet.Rows[0].Cells[0].Inline)
+	a.Equal("n", sheet.Rows[1].Cells[0].Type)
+	a.Equal("1", sheet.Rows[1].Cells[0].Value)
+	a.Equal("Tom & Jerry <QA>", sheet.Rows[1].Cells[1].Inline)
+}
+
+func TestXlsxWriterError(t *testing.T) {
+	var a = assert.New(t)
+	writer, err := NewWriter(FormatXlsx, failingWriter{}, []string{"id", "name"})
+	if err == nil {
+		// запись буферизуется и сжимается, ошибка проявляется, когда буфер выталкивается в соединение,
+		// поэтому пишем плохо сжимаемые данные
+		for i := 0; i < 10000 && err == nil; i++ {
+			var hash = sha256.Sum256([]byte(strconv.Itoa(i)))
+			err = writer.Write([]any{int64(i), hex.EncodeToString(hash[:])})
+		}
+	}
+	a.EqualError(err, "connection reset")
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	var _, err = NewWriter("pdf", io.Discard, []string{"id"})
+	assert.ErrorContains(t, err, "unsupported export format")
+}
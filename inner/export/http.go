@@ -0,0 +1,69 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Request параметры выгрузки, общие для всех таблиц: формат, колонки через запятую и фильтр по id
+type Request struct {
+	Format  string
+	Columns string
+	Ids     []int64
+}
+
+// Stream пишет выгрузку в w; вызывается уже после отправки заголовков ответа
+type Stream func(w io.Writer) error
+
+// Validate проверяет формат и фильтр. Колонки проверяет SelectColumns, так как их набор зависит от таблицы
+func (r *Request) Validate() error {
+	if !slices.Contains(Formats, r.Format) {
+		return fmt.Errorf("unsupported export format %q, expected one of %v", r.Format, Formats)
+	}
+	for _, id := range r.Ids {
+		if id <= 0 {
+			return fmt.Errorf("invalid id: %d", id)
+		}
+	}
+	return nil
+}
+
+// ParseRequest читает параметры format (по умолчанию csv), columns и ids из строки запроса
+func ParseRequest(ctx *fiber.Ctx) (Request, error) {
+	var req = Request{
+		Format:  strings.ToLower(ctx.Query("format", FormatCsv)),
+		Columns: ctx.Query("columns"),
+	}
+	if idsStr := ctx.Query("ids"); idsStr != "" {
+		for _, v := range strings.Split(idsStr, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return Request{}, fmt.Errorf("invalid id: %s", v)
+			}
+			req.Ids = append(req.Ids, id)
+		}
+	}
+	return req, nil
+}
+
+// Send отдаёт выгрузку файлом name.<format>, записывая тело ответа потоком.
+// Статус 200 уже отправлен, поэтому ошибку посреди выгрузки можно только записать в лог — файл окажется обрезанным
+func Send(ctx *fiber.Ctx, name string, format string, stream Stream) error {
+	ctx.Set(fiber.HeaderContentType, ContentType(format))
+	ctx.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := stream(w); err != nil {
+			log.Printf("export: %s.%s interrupted: %v", name, format, err)
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("export: %s.%s flush failed: %v", name, format, err)
+		}
+	})
+	return nil
+}
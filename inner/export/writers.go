@@ -0,0 +1,211 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// formatValue приводит значение к строке: время — в RFC 3339, списки — через точку с запятой
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []string:
+		return strings.Join(v, ";")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCsvWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	var c = &csvWriter{writer: csv.NewWriter(w)}
+	return c, c.writer.Write(columns)
+}
+
+func (c *csvWriter) Write(values []any) error {
+	var record = make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case int64, int:
+			record[i] = formatValue(v)
+		default:
+			record[i] = csvSafe(formatValue(v))
+		}
+	}
+	return c.writer.Write(record)
+}
+
+// csvSafe экранирует апострофом значение, которое Excel и LibreOffice приняли бы за формулу
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+	columns []string
+}
+
+func newNdjsonWriter(w io.Writer, columns []string) *ndjsonWriter {
+	return &ndjsonWriter{encoder: json.NewEncoder(w), columns: columns}
+}
+
+// Write записывает строку JSON-объектом; порядок ключей совпадает с порядком колонок
+func (n *ndjsonWriter) Write(values []any) error {
+	var line = []byte{'{'}
+	for i, column := range n.columns {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		key, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		line = append(append(append(line, key...), ':'), value...)
+	}
+	line = append(line, '}')
+	return n.encoder.Encode(json.RawMessage(line))
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+// xlsxWriter пишет книгу с одним листом. Лист — первый элемент архива и пишется потоком,
+// остальные части книги небольшие и дописываются в Close
+type xlsxWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+}
+
+func newXlsxWriter(w io.Writer, columns []string) (*xlsxWriter, error) {
+	var archive = zip.NewWriter(w)
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	var x = &xlsxWriter{archive: archive, sheet: bufio.NewWriter(sheet)}
+	_, err = x.sheet.WriteString(xml.Header +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+	var header = make([]any, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	return x, x.Write(header)
+}
+
+func (x *xlsxWriter) Write(values []any) error {
+	if _, err := x.sheet.WriteString("<row>"); err != nil {
+		return err
+	}
+	for _, value := range values {
+		var err error
+		switch v := value.(type) {
+		case int64:
+			_, err = x.sheet.WriteString(`<c t="n"><v>` + strconv.FormatInt(v, 10) + `</v></c>`)
+		case int:
+			_, err = x.sheet.WriteString(`<c t="n"><v>` + strconv.Itoa(v) + `</v></c>`)
+		default:
+			err = x.writeText(formatValue(v))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := x.sheet.WriteString("</row>")
+	return err
+}
+
+// writeText пишет ячейку со строкой; в отличие от CSV, строковая ячейка не вычисляется как формула
+func (x *xlsxWriter) writeText(s string) error {
+	if _, err := x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(x.sheet, []byte(xmlSafe(s))); err != nil {
+		return err
+	}
+	_, err := x.sheet.WriteString(`</t></is></c>`)
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	if _, err := x.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	for _, part := range xlsxParts {
+		w, err := x.archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, xml.Header+part.content); err != nil {
+			return err
+		}
+	}
+	return x.archive.Close()
+}
+
+// xmlSafe удаляет символы, запрещённые в XML 1.0: Excel не откроет файл с ними
+func xmlSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r != utf8.RuneError && r != 0xfffe && r != 0xffff) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// минимальный набор частей книги Office Open XML
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
@@ -0,0 +1,51 @@
+package health
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/audit"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+type Controller struct {
+	server *web.Server
+	audit  AuditChain
+}
+
+// AuditChain голова журнала аудита, например audit.Service
+type AuditChain interface {
+	Head() (audit.Head, error)
+}
+
+// Response состояние сервиса. AuditHead позволяет мониторингу сохранять голову цепочки журнала
+// и замечать, если она откатилась назад
+type Response struct {
+	Status    string     `json:"status"`
+	AuditHead audit.Head `json:"audit_head"`
+}
+
+func NewController(server *web.Server, auditChain AuditChain) *Controller {
+	return &Controller{
+		server: server,
+		audit:  auditChain,
+	}
+}
+
+// функция для регистрации маршрутов; "/health" вне "/api/v1", чтобы его опрашивали без авторизации
+func (c *Controller) RegisterRoutes() {
+	c.server.App.Get("/health", c.Health)
+}
+
+// функция-хендлер для GET запроса по маршруту "/health"
+func (c *Controller) Health(ctx *fiber.Ctx) error {
+	head, err := c.audit.Head()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusServiceUnavailable, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, Response{Status: "ok", AuditHead: head}); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get health")
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package hrimport
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"io"
+	"strings"
+)
+
+type Controller struct {
+	server        *web.Server
+	importService Svc
+}
+
+// интерфейс сервиса hrimport.Service
+type Svc interface {
+	Import(r io.Reader, options Options) (Report, error)
+}
+
+func NewController(server *web.Server, importService Svc) *Controller {
+	return &Controller{
+		server:        server,
+		importService: importService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/employees/import", c.Import)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/employees/import".
+// Тело запроса — CSV файл. Параметры запроса:
+//   - dry_run=true — только показать изменения;
+//   - external_id_column, name_columns (через запятую), delimiter — сопоставление колонок;
+//   - department_column, title_column, employment_type_column, location_column — колонки атрибутов;
+//   - batch_size — количество изменений в одной транзакции;
+//   - confirm_terminations=true — разрешить увольнение больше MaxTerminationRatio сотрудников
+func (c *Controller) Import(ctx *fiber.Ctx) error {
+	var options = Options{
+		DryRun:              ctx.QueryBool("dry_run"),
+		BatchSize:           ctx.QueryInt("batch_size"),
+		ConfirmTerminations: ctx.QueryBool("confirm_terminations"),
+		Mapping: Mapping{
+			ExternalId:     ctx.Query("external_id_column"),
+			Department:     ctx.Query("department_column"),
+			Title:          ctx.Query("title_column"),
+			EmploymentType: ctx.Query("employment_type_column"),
+			Location:       ctx.Query("location_column"),
+			Delimiter:      ctx.Query("delimiter"),
+		},
+	}
+	if names := ctx.Query("name_columns"); names != "" {
+		options.Mapping.Name = strings.Split(names, ",")
+	}
+
+	var report, err = c.importService.Import(bytes.NewReader(ctx.Body()), options)
+	if err != nil {
+		switch {
+		case errors.As(err, &common.RequestValidationError{}):
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if err = common.OkResponse(ctx, report); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning import report")
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package hrimport
+
+import (
+	"database/sql"
+	"github.com/zhedevops/idm/inner/employee"
+)
+
+// Mapping сопоставление колонок CSV полям сотрудника. Имя может собираться из нескольких колонок
+// (например, имени и фамилии), их значения соединяются через пробел.
+// Колонки атрибутов, по которым правила назначают роли, необязательны: если колонки по умолчанию нет в файле,
+// атрибут сотрудника не меняется, а явно заданная, но отсутствующая колонка — ошибка
+type Mapping struct {
+	ExternalId     string   `json:"external_id"`
+	Name           []string `json:"name"`
+	Department     string   `json:"department"`
+	Title          string   `json:"title"`
+	EmploymentType string   `json:"employment_type"`
+	Location       string   `json:"location"`
+	// Delimiter разделитель колонок, по умолчанию запятая
+	Delimiter string `json:"delimiter"`
+}
+
+// DefaultMapping колонки с именами полей сотрудника, разделитель — запятая
+var DefaultMapping = Mapping{
+	ExternalId:     "external_id",
+	Name:           []string{"name"},
+	Department:     "department",
+	Title:          "title",
+	EmploymentType: "employment_type",
+	Location:       "location",
+	Delimiter:      ",",
+}
+
+// Row строка файла после сопоставления колонок. Атрибут равен nil, если его колонки нет в файле
+type Row struct {
+	Line           int     `json:"line"`
+	ExternalId     string  `json:"external_id" validate:"required,max=64"`
+	Name           string  `json:"name" validate:"required,min=2,max=155"`
+	Department     *string `json:"department,omitempty" validate:"omitempty,max=155"`
+	Title          *string `json:"title,omitempty" validate:"omitempty,max=155"`
+	EmploymentType *string `json:"employment_type,omitempty" validate:"omitempty,max=64"`
+	Location       *string `json:"location,omitempty" validate:"omitempty,max=155"`
+}
+
+// RowError строка, которую не удалось разобрать, проверить или применить
+type RowError struct {
+	Line       int    `json:"line"`
+	ExternalId string `json:"external_id,omitempty"`
+	Message    string `json:"message"`
+}
+
+// Entity сотрудник с полями, которые использует импорт
+type Entity struct {
+	Id         int64          `db:"id"`
+	ExternalId sql.NullString `db:"external_id"`
+	Name       string         `db:"name"`
+	employee.Attributes
+	// TerminatedAt когда сотрудник уволен; уволенный не удаляется, чтобы сохранить его историю
+	TerminatedAt sql.NullTime `db:"terminated_at"`
+}
+
+// Виды изменений, которые импорт вносит в сотрудников
+const (
+	ChangeCreate    = "create"
+	ChangeUpdate    = "update"
+	ChangeTerminate = "terminate"
+)
+
+// Change одно изменение сотрудника. OldName и OldAttributes заполнены для обновлений,
+// Line — для изменений, порождённых строкой файла
+type Change struct {
+	Type          string              `json:"type"`
+	Line          int                 `json:"line,omitempty"`
+	EmployeeId    int64               `json:"employee_id,omitempty"`
+	ExternalId    string              `json:"external_id"`
+	Name          string              `json:"name"`
+	OldName       string              `json:"old_name,omitempty"`
+	Attributes    employee.Attributes `json:"attributes"`
+	OldAttributes employee.Attributes `json:"old_attributes"`
+	// Rehire уволенный сотрудник снова появился в выгрузке
+	Rehire  bool `json:"rehire,omitempty"`
+	Applied bool `json:"applied"`
+}
+
+// Report результат импорта: при пробном запуске — только план изменений
+type Report struct {
+	DryRun       bool       `json:"dry_run"`
+	Rows         int        `json:"rows"`
+	Unchanged    int        `json:"unchanged"`
+	Creates      []Change   `json:"creates"`
+	Updates      []Change   `json:"updates"`
+	Terminations []Change   `json:"terminations"`
+	Errors       []RowError `json:"errors"`
+}
+
+// attributes атрибуты сотрудника после применения строки: атрибуты без колонки в файле остаются прежними
+func (r *Row) attributes(current employee.Attributes) employee.Attributes {
+	for _, a := range []struct {
+		value  *string
+		target *string
+	}{
+		{r.Department, &current.Department},
+		{r.Title, &current.Title},
+		{r.EmploymentType, &current.EmploymentType},
+		{r.Location, &current.Location},
+	} {
+		if a.value != nil {
+			*a.target = *a.value
+		}
+	}
+	return current
+}
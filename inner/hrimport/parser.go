@@ -0,0 +1,137 @@
+package hrimport
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parse читает CSV с заголовком и сопоставляет колонки по mapping; незаданные поля mapping
+// берутся из DefaultMapping. Строки, которые не удалось прочитать, попадают в ошибки
+// и не прерывают разбор остальных
+func Parse(r io.Reader, mapping Mapping) ([]Row, []RowError, error) {
+	if mapping.ExternalId == "" {
+		mapping.ExternalId = DefaultMapping.ExternalId
+	}
+	if len(mapping.Name) == 0 {
+		mapping.Name = DefaultMapping.Name
+	}
+	var reader = csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if mapping.Delimiter != "" {
+		delimiter, size := utf8.DecodeRuneInString(mapping.Delimiter)
+		if size != len(mapping.Delimiter) {
+			return nil, nil, fmt.Errorf("delimiter must be a single character, got %q", mapping.Delimiter)
+		}
+		reader.Comma = delimiter
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading header: %w", err)
+	}
+	// BOM, который добавляет Excel, не должен становиться частью имени первой колонки
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	var columns = map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	var index = func(name string) (int, error) {
+		i, ok := columns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("column %q not found in header", name)
+		}
+		return i, nil
+	}
+	externalIdColumn, err := index(mapping.ExternalId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var nameColumns []int
+	for _, name := range mapping.Name {
+		i, err := index(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		nameColumns = append(nameColumns, i)
+	}
+	// колонки атрибутов; колонку по умолчанию, которой нет в файле, пропускаем
+	var attributeColumns []attributeColumn
+	for _, a := range []attributeColumn{
+		{name: mapping.Department, fallback: DefaultMapping.Department, set: func(r *Row, v string) { r.Department = &v }},
+		{name: mapping.Title, fallback: DefaultMapping.Title, set: func(r *Row, v string) { r.Title = &v }},
+		{name: mapping.EmploymentType, fallback: DefaultMapping.EmploymentType, set: func(r *Row, v string) { r.EmploymentType = &v }},
+		{name: mapping.Location, fallback: DefaultMapping.Location, set: func(r *Row, v string) { r.Location = &v }},
+	} {
+		var explicit = a.name != ""
+		if !explicit {
+			a.name = a.fallback
+		}
+		a.index, err = index(a.name)
+		if err != nil && explicit {
+			return nil, nil, err
+		}
+		if err == nil {
+			attributeColumns = append(attributeColumns, a)
+		}
+	}
+
+	var rows []Row
+	var rowErrors []RowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErrors = append(rowErrors, RowError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		// FieldPos можно вызывать только после успешного Read, иначе он паникует
+		line, _ := reader.FieldPos(0)
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		var row = Row{Line: line, ExternalId: strings.TrimSpace(field(record, externalIdColumn))}
+		var parts []string
+		for _, i := range nameColumns {
+			if part := strings.TrimSpace(field(record, i)); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		row.Name = strings.Join(parts, " ")
+		for _, a := range attributeColumns {
+			a.set(&row, strings.TrimSpace(field(record, a.index)))
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+// attributeColumn колонка файла, из которой берётся атрибут сотрудника
+type attributeColumn struct {
+	name     string
+	fallback string
+	index    int
+	set      func(*Row, string)
+}
+
+func field(record []string, i int) string {
+	if i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
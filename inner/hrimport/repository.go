@@ -0,0 +1,76 @@
+package hrimport
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/employee"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+func (r *Repository) FindAll() (employees []Entity, err error) {
+	query := `SELECT id, external_id, name, department, title, employment_type, location, terminated_at
+              FROM employee ORDER BY id`
+	err = r.db.Select(&employees, query)
+	if err != nil {
+		return nil, err
+	}
+	return employees, nil
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+// CreateTx создаёт сотрудника с табельным номером и атрибутами и возвращает его id
+func (r *Repository) CreateTx(tx *sqlx.Tx, externalId string, name string, a employee.Attributes) (id int64, err error) {
+	err = savepoint(tx, func() error {
+		query := `INSERT INTO employee (name, external_id, department, title, employment_type, location)
+                  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+		return tx.Get(&id, query, name, externalId, a.Department, a.Title, a.EmploymentType, a.Location)
+	})
+	return id, err
+}
+
+// UpdateTx меняет имя и атрибуты сотрудника, привязывает его к табельному номеру и снимает отметку об увольнении
+func (r *Repository) UpdateTx(tx *sqlx.Tx, id int64, externalId string, name string, a employee.Attributes) error {
+	return savepoint(tx, func() error {
+		query := `UPDATE employee SET name = $1, external_id = $2,
+                      department = $3, title = $4, employment_type = $5, location = $6,
+                      terminated_at = NULL, version = version + 1, updated_at = NOW()
+                  WHERE id = $7`
+		_, err := tx.Exec(query, name, externalId, a.Department, a.Title, a.EmploymentType, a.Location, id)
+		return err
+	})
+}
+
+// TerminateTx отмечает сотрудника уволенным; запись и её история сохраняются
+func (r *Repository) TerminateTx(tx *sqlx.Tx, id int64) error {
+	return savepoint(tx, func() error {
+		query := `UPDATE employee SET terminated_at = NOW(), version = version + 1, updated_at = NOW()
+                  WHERE id = $1 AND terminated_at IS NULL`
+		_, err := tx.Exec(query, id)
+		return err
+	})
+}
+
+// savepoint выполняет fn внутри точки сохранения: ошибка одной строки откатывает только её,
+// а транзакция пачки остаётся пригодной для следующих строк
+func savepoint(tx *sqlx.Tx, fn func() error) error {
+	if _, err := tx.Exec("SAVEPOINT import_row"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if _, errRollback := tx.Exec("ROLLBACK TO SAVEPOINT import_row"); errRollback != nil {
+			return errRollback
+		}
+		return err
+	}
+	_, err := tx.Exec("RELEASE SAVEPOINT import_row")
+	return err
+}
@@ -0,0 +1,269 @@
+package hrimport
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"io"
+	"log"
+	"math"
+)
+
+// DefaultBatchSize количество изменений, применяемых в одной транзакции
+const DefaultBatchSize = 100
+
+// MaxTerminationRatio доля работающих сотрудников с табельным номером, которую импорт может уволить
+// без явного подтверждения. Обрезанная выгрузка не должна увольнять большую часть сотрудников
+const MaxTerminationRatio = 0.1
+
+// Структура сервиса импорта сотрудников из выгрузки HR-системы
+type Service struct {
+	repo        Repo
+	validator   Validator
+	provisioner Provisioner
+	rules       Rules
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+type Repo interface {
+	FindAll() ([]Entity, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	CreateTx(*sqlx.Tx, string, string, employee.Attributes) (int64, error)
+	UpdateTx(*sqlx.Tx, int64, string, string, employee.Attributes) error
+	TerminateTx(*sqlx.Tx, int64) error
+}
+
+// Provisioner асинхронно передаёт изменения сотрудников во внешние системы
+type Provisioner interface {
+	Publish(event provisioning.Event)
+}
+
+// Rules пересчитывает назначенные правилами роли сотрудника по его атрибутам, например assignmentrule.Service
+type Rules interface {
+	Apply(employeeId int64) error
+}
+
+// Options параметры импорта. При DryRun изменения только вычисляются
+type Options struct {
+	Mapping   Mapping
+	DryRun    bool
+	BatchSize int
+	// ConfirmTerminations разрешает уволить больше MaxTerminationRatio сотрудников
+	ConfirmTerminations bool
+}
+
+// NewService создаёт сервис импорта. Если provisioner равен nil, изменения во внешние системы не передаются,
+// если rules равен nil, роли по атрибутам импортированных сотрудников не пересчитываются
+func NewService(repo Repo, validator Validator, provisioner Provisioner, rules Rules) *Service {
+	return &Service{
+		repo:        repo,
+		validator:   validator,
+		provisioner: provisioner,
+		rules:       rules,
+	}
+}
+
+// Import сравнивает выгрузку HR с сотрудниками и применяет разницу:
+//   - строка с новым табельным номером создаёт сотрудника, а если есть ровно один сотрудник
+//     без табельного номера с тем же именем — привязывает его;
+//   - строка с известным номером и другим именем или атрибутами обновляет сотрудника,
+//     а уволенного ранее сотрудника возвращает на работу;
+//   - сотрудник с табельным номером, которого нет в файле, увольняется: запись остаётся вместе с историей,
+//     учётная запись во внешних системах отключается, а роли, выданные правилами, снимаются.
+//
+// После применения роли сотрудников пересчитываются по правилам, как при изменении через API.
+// Если увольнений больше MaxTerminationRatio, импорт без ConfirmTerminations отклоняется.
+//
+// Сотрудники без табельного номера, созданные вручную, при увольнении не затрагиваются
+func (srv *Service) Import(r io.Reader, options Options) (Report, error) {
+	var rows, rowErrors, err = Parse(r, options.Mapping)
+	if err != nil {
+		return Report{}, common.RequestValidationError{Message: err.Error()}
+	}
+	employees, err := srv.repo.FindAll()
+	if err != nil {
+		return Report{}, fmt.Errorf("error get employees: %w", err)
+	}
+
+	var report = srv.plan(rows, rowErrors, employees)
+	report.DryRun = options.DryRun
+	if report.Rows == 0 {
+		// пустая выгрузка уволила бы всех, скорее всего это ошибка на стороне HR
+		return Report{}, common.RequestValidationError{Message: "file contains no valid rows"}
+	}
+	if options.DryRun {
+		return report, nil
+	}
+	if limit := terminationLimit(employees); len(report.Terminations) > limit && !options.ConfirmTerminations {
+		return Report{}, common.RequestValidationError{Message: fmt.Sprintf(
+			"file would terminate %d employees, more than allowed %d: check the file or confirm terminations",
+			len(report.Terminations), limit)}
+	}
+
+	var batchSize = options.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	for _, changes := range [][]Change{report.Creates, report.Updates, report.Terminations} {
+		for start := 0; start < len(changes); start += batchSize {
+			var end = min(start+batchSize, len(changes))
+			report.Errors = append(report.Errors, srv.applyBatch(changes[start:end])...)
+		}
+	}
+	return report, nil
+}
+
+// plan проверяет строки и вычисляет изменения
+func (srv *Service) plan(rows []Row, rowErrors []RowError, employees []Entity) Report {
+	var report = Report{Errors: rowErrors, Creates: []Change{}, Updates: []Change{}, Terminations: []Change{}}
+	var byExternalId = map[string]Entity{}
+	var unlinkedByName = map[string][]Entity{}
+	for _, e := range employees {
+		if e.ExternalId.Valid {
+			byExternalId[e.ExternalId.String] = e
+		} else {
+			unlinkedByName[e.Name] = append(unlinkedByName[e.Name], e)
+		}
+	}
+
+	// номера из всех строк файла, включая ошибочные: сотрудник с ошибкой в строке не должен быть уволен
+	var inFile = map[string]int{}
+	for _, row := range rows {
+		if line, duplicate := inFile[row.ExternalId]; duplicate && row.ExternalId != "" {
+			report.Errors = append(report.Errors, RowError{
+				Line: row.Line, ExternalId: row.ExternalId,
+				Message: fmt.Sprintf("duplicate external_id, first seen on line %d", line),
+			})
+			continue
+		}
+		inFile[row.ExternalId] = row.Line
+		if err := srv.validator.Validate(row); err != nil {
+			report.Errors = append(report.Errors, RowError{Line: row.Line, ExternalId: row.ExternalId, Message: err.Error()})
+			continue
+		}
+		report.Rows++
+
+		var change = Change{Line: row.Line, ExternalId: row.ExternalId, Name: row.Name}
+		existing, found := byExternalId[row.ExternalId]
+		if !found && len(unlinkedByName[row.Name]) == 1 {
+			existing, found = unlinkedByName[row.Name][0], true
+			delete(unlinkedByName, row.Name)
+		}
+		change.Attributes = row.attributes(existing.Attributes)
+		switch {
+		case !found:
+			change.Type = ChangeCreate
+			report.Creates = append(report.Creates, change)
+		case existing.Name != row.Name || !existing.ExternalId.Valid || existing.TerminatedAt.Valid ||
+			change.Attributes != existing.Attributes:
+			change.Type = ChangeUpdate
+			change.EmployeeId = existing.Id
+			change.OldName = existing.Name
+			change.OldAttributes = existing.Attributes
+			change.Rehire = existing.TerminatedAt.Valid
+			report.Updates = append(report.Updates, change)
+		default:
+			report.Unchanged++
+		}
+	}
+
+	for _, e := range employees {
+		if _, ok := inFile[e.ExternalId.String]; e.ExternalId.Valid && !e.TerminatedAt.Valid && !ok {
+			report.Terminations = append(report.Terminations, Change{
+				Type:          ChangeTerminate,
+				EmployeeId:    e.Id,
+				ExternalId:    e.ExternalId.String,
+				Name:          e.Name,
+				Attributes:    e.Attributes,
+				OldAttributes: e.Attributes,
+			})
+		}
+	}
+	return report
+}
+
+// terminationLimit количество увольнений, допустимое без подтверждения. Одно увольнение разрешено всегда
+func terminationLimit(employees []Entity) int {
+	var linked int
+	for _, e := range employees {
+		if e.ExternalId.Valid && !e.TerminatedAt.Valid {
+			linked++
+		}
+	}
+	return max(int(math.Ceil(float64(linked)*MaxTerminationRatio)), 1)
+}
+
+// applyBatch применяет пачку изменений в одной транзакции. Ошибка строки откатывает только её;
+// если не удалось закоммитить транзакцию, вся пачка считается неприменённой.
+// После коммита изменения передаются во внешние системы, а роли сотрудников пересчитываются по правилам
+func (srv *Service) applyBatch(changes []Change) []RowError {
+	var rowErrors []RowError
+	var events []provisioning.Event
+	var err = database.InTransaction(srv.repo.BeginTransaction, "importing employees", func(tx *sqlx.Tx) error {
+		for i := range changes {
+			var c = &changes[i]
+			var err error
+			var eventType provisioning.EventType
+			switch c.Type {
+			case ChangeCreate:
+				c.EmployeeId, err = srv.repo.CreateTx(tx, c.ExternalId, c.Name, c.Attributes)
+				eventType = provisioning.AccountCreated
+			case ChangeUpdate:
+				err = srv.repo.UpdateTx(tx, c.EmployeeId, c.ExternalId, c.Name, c.Attributes)
+				eventType = provisioning.AccountUpdated
+				if c.Rehire {
+					// коннекторы включают отключённую учётную запись при повторном создании
+					eventType = provisioning.AccountCreated
+				}
+			case ChangeTerminate:
+				err = srv.repo.TerminateTx(tx, c.EmployeeId)
+				eventType = provisioning.AccountDisabled
+			}
+			if err != nil {
+				rowErrors = append(rowErrors, RowError{Line: c.Line, ExternalId: c.ExternalId, Message: err.Error()})
+				continue
+			}
+			c.Applied = true
+			events = append(events, provisioning.Event{
+				Type:    eventType,
+				Account: provisioning.Account{EmployeeId: c.EmployeeId, Name: c.Name},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		rowErrors = rowErrors[:0]
+		for i := range changes {
+			changes[i].Applied = false
+			rowErrors = append(rowErrors, RowError{Line: changes[i].Line, ExternalId: changes[i].ExternalId, Message: err.Error()})
+		}
+		return rowErrors
+	}
+
+	if srv.provisioner != nil {
+		for _, e := range events {
+			srv.provisioner.Publish(e)
+		}
+	}
+	for _, e := range events {
+		srv.applyRules(e.Account.EmployeeId)
+	}
+	return rowErrors
+}
+
+// applyRules пересчитывает роли сотрудника по правилам. Изменения импорта уже сохранены,
+// поэтому ошибка только записывается в лог: расхождение исправит следующий пересчёт по расписанию
+func (srv *Service) applyRules(id int64) {
+	if srv.rules == nil {
+		return
+	}
+	if err := srv.rules.Apply(id); err != nil {
+		log.Printf("hrimport: applying assignment rules to employee %d failed: %v", id, err)
+	}
+}
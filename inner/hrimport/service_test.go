@@ -0,0 +1,304 @@
+package hrimport
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindAll() ([]Entity, error) {
+	args := m.Called()
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) CreateTx(tx *sqlx.Tx, externalId string, name string, attributes employee.Attributes) (int64, error) {
+	args := m.Called(tx, externalId, name, attributes)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) UpdateTx(tx *sqlx.Tx, id int64, externalId string, name string, attributes employee.Attributes) error {
+	args := m.Called(tx, id, externalId, name, attributes)
+	return args.Error(0)
+}
+
+func (m *MockRepo) TerminateTx(tx *sqlx.Tx, id int64) error {
+	args := m.Called(tx, id)
+	return args.Error(0)
+}
+
+// StubProvisioner запоминает опубликованные события
+type StubProvisioner struct {
+	events []provisioning.Event
+}
+
+func (p *StubProvisioner) Publish(event provisioning.Event) {
+	p.events = append(p.events, event)
+}
+
+// StubRules запоминает сотрудников, роли которых пересчитаны по правилам
+type StubRules struct {
+	applied []int64
+}
+
+func (r *StubRules) Apply(employeeId int64) error {
+	r.applied = append(r.applied, employeeId)
+	return nil
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит с заданной ошибкой
+func newTx(a *assert.Assertions, commitErr error) *sqlx.Tx {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	mock.ExpectCommit().WillReturnError(commitErr)
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx
+}
+
+func external(id string) sql.NullString {
+	return sql.NullString{String: id, Valid: true}
+}
+
+func TestParse(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should map columns by header", func(t *testing.T) {
+		var data = "\ufeffTab No;First Name;Last Name;Dept\n" +
+			"E1; John ;Doe;IT\n" +
+			"\n" +
+			"E2;Jane;;HR\n"
+		var rows, rowErrors, err = Parse(strings.NewReader(data), Mapping{
+			ExternalId: "tab no",
+			Name:       []string{"First Name", "Last Name"},
+			Delimiter:  ";",
+		})
+		a.Nil(err)
+		a.Empty(rowErrors)
+		a.Equal([]Row{
+			{Line: 2, ExternalId: "E1", Name: "John Doe"},
+			{Line: 4, ExternalId: "E2", Name: "Jane"},
+		}, rows)
+	})
+
+	t.Run("should report malformed rows and continue", func(t *testing.T) {
+		var data = "external_id,name\nE1,\"John\nE2,Jane\n"
+		var _, rowErrors, err = Parse(strings.NewReader(data), Mapping{})
+		a.Nil(err)
+		a.Len(rowErrors, 1)
+	})
+
+	t.Run("should report bare quote in first column", func(t *testing.T) {
+		var data = "external_id,name\nE\"1,John\nE2,Jane\n"
+		var rows, rowErrors, err = Parse(strings.NewReader(data), Mapping{})
+		a.Nil(err)
+		a.Equal([]RowError{{Line: 2, Message: csv.ErrBareQuote.Error()}}, rowErrors)
+		a.Equal([]Row{{Line: 3, ExternalId: "E2", Name: "Jane"}}, rows)
+	})
+
+	t.Run("should fail on missing column", func(t *testing.T) {
+		var _, _, err = Parse(strings.NewReader("id,name\n1,John\n"), Mapping{})
+		a.NotNil(err)
+	})
+
+	t.Run("should map attribute columns", func(t *testing.T) {
+		var data = "external_id,name,department,Job Title\nE1,John, IT ,Engineer\n"
+		var rows, _, err = Parse(strings.NewReader(data), Mapping{Title: "job title"})
+		a.Nil(err)
+		var department, title = "IT", "Engineer"
+		a.Equal([]Row{{Line: 2, ExternalId: "E1", Name: "John", Department: &department, Title: &title}}, rows)
+	})
+
+	t.Run("should fail on missing explicit attribute column", func(t *testing.T) {
+		var _, _, err = Parse(strings.NewReader("external_id,name\nE1,John\n"), Mapping{Location: "office"})
+		a.NotNil(err)
+	})
+}
+
+func TestImport(t *testing.T) {
+	var a = assert.New(t)
+	var validator = validator.New()
+	var employees = []Entity{
+		{Id: 1, ExternalId: external("E1"), Name: "John Doe"},
+		{Id: 2, ExternalId: external("E2"), Name: "Jane Roe"},
+		{Id: 3, ExternalId: external("E3"), Name: "Gone Away"},
+		{Id: 4, Name: "Manual User"},
+		{Id: 5, Name: "Bob Smith"},
+	}
+	var data = "external_id,name\n" +
+		"E1,John Doe\n" +
+		"E2,Jane Doe\n" +
+		"E5,Bob Smith\n" +
+		"E6,New Hire\n" +
+		"E7,X\n" +
+		"E6,Twice\n"
+
+	t.Run("should show diff on dry run", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		repo.On("FindAll").Return(employees, nil)
+
+		var report, err = svc.Import(strings.NewReader(data), Options{DryRun: true})
+		a.Nil(err)
+		a.True(report.DryRun)
+		a.Equal(4, report.Rows)
+		a.Equal(1, report.Unchanged)
+		a.Equal([]Change{{Type: ChangeCreate, Line: 5, ExternalId: "E6", Name: "New Hire"}}, report.Creates)
+		a.Equal([]Change{
+			{Type: ChangeUpdate, Line: 3, EmployeeId: 2, ExternalId: "E2", Name: "Jane Doe", OldName: "Jane Roe"},
+			// сотрудник без табельного номера привязывается по имени
+			{Type: ChangeUpdate, Line: 4, EmployeeId: 5, ExternalId: "E5", Name: "Bob Smith", OldName: "Bob Smith"},
+		}, report.Updates)
+		a.Equal([]Change{{Type: ChangeTerminate, EmployeeId: 3, ExternalId: "E3", Name: "Gone Away"}}, report.Terminations)
+		a.Len(report.Errors, 2)
+		a.Equal(6, report.Errors[0].Line)
+		a.Equal(7, report.Errors[1].Line)
+		a.True(repo.AssertNumberOfCalls(t, "BeginTransaction", 0))
+	})
+
+	t.Run("should apply changes in batches and report row errors", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var rules = new(StubRules)
+		var svc = NewService(repo, validator, provisioner, rules)
+		var first, second, third = newTx(a, nil), newTx(a, nil), newTx(a, nil)
+		repo.On("FindAll").Return(employees, nil)
+		repo.On("BeginTransaction").Return(first, nil).Once()
+		repo.On("BeginTransaction").Return(second, nil).Once()
+		repo.On("BeginTransaction").Return(third, nil).Once()
+		repo.On("CreateTx", first, "E6", "New Hire", employee.Attributes{}).Return(int64(6), nil)
+		repo.On("UpdateTx", second, int64(2), "E2", "Jane Doe", employee.Attributes{}).Return(errors.New("deadlock"))
+		repo.On("UpdateTx", second, int64(5), "E5", "Bob Smith", employee.Attributes{}).Return(nil)
+		repo.On("TerminateTx", third, int64(3)).Return(nil)
+
+		var report, err = svc.Import(strings.NewReader(data), Options{BatchSize: 2})
+		a.Nil(err)
+		a.True(report.Creates[0].Applied)
+		a.False(report.Updates[0].Applied)
+		a.True(report.Updates[1].Applied)
+		a.True(report.Terminations[0].Applied)
+		a.Equal(RowError{Line: 3, ExternalId: "E2", Message: "deadlock"}, report.Errors[2])
+		a.Equal([]provisioning.Event{
+			{Type: provisioning.AccountCreated, Account: provisioning.Account{EmployeeId: 6, Name: "New Hire"}},
+			{Type: provisioning.AccountUpdated, Account: provisioning.Account{EmployeeId: 5, Name: "Bob Smith"}},
+			{Type: provisioning.AccountDisabled, Account: provisioning.Account{EmployeeId: 3, Name: "Gone Away"}},
+		}, provisioner.events)
+		a.Equal([]int64{6, 5, 3}, rules.applied)
+	})
+
+	t.Run("should mark whole batch failed when commit fails", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		var tx = newTx(a, errors.New("connection lost"))
+		repo.On("FindAll").Return([]Entity{}, nil)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("CreateTx", tx, "E1", "John Doe", employee.Attributes{}).Return(int64(1), nil)
+
+		var report, err = svc.Import(strings.NewReader("external_id,name\nE1,John Doe\n"), Options{})
+		a.Nil(err)
+		a.False(report.Creates[0].Applied)
+		a.Len(report.Errors, 1)
+		a.Empty(provisioner.events)
+	})
+
+	t.Run("should require confirmation for mass termination", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, validator, provisioner, nil)
+		var tx = newTx(a, nil)
+		repo.On("FindAll").Return(employees, nil)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("TerminateTx", tx, int64(2)).Return(nil)
+		repo.On("TerminateTx", tx, int64(3)).Return(nil)
+		var truncated = "external_id,name\nE1,John Doe\n"
+
+		var _, err = svc.Import(strings.NewReader(truncated), Options{})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		a.True(repo.AssertNumberOfCalls(t, "BeginTransaction", 0))
+
+		report, err := svc.Import(strings.NewReader(truncated), Options{ConfirmTerminations: true})
+		a.Nil(err)
+		a.Len(report.Terminations, 2)
+		a.True(report.Terminations[0].Applied)
+		a.True(report.Terminations[1].Applied)
+	})
+
+	t.Run("should reject file without valid rows", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		repo.On("FindAll").Return(employees, nil)
+		var _, err = svc.Import(strings.NewReader("external_id,name\n"), Options{})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should update attributes and rehire terminated employee", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var rules = new(StubRules)
+		var svc = NewService(repo, validator, provisioner, rules)
+		var tx = newTx(a, nil)
+		var it = employee.Attributes{Department: "IT", Location: "Moscow"}
+		repo.On("FindAll").Return([]Entity{
+			{Id: 1, ExternalId: external("E1"), Name: "John Doe", Attributes: it},
+			{Id: 2, ExternalId: external("E2"), Name: "Jane Roe", Attributes: it},
+			{Id: 3, ExternalId: external("E3"), Name: "Gone Away",
+				TerminatedAt: sql.NullTime{Time: time.Now(), Valid: true}},
+		}, nil)
+		repo.On("BeginTransaction").Return(tx, nil)
+		var sales = employee.Attributes{Department: "Sales", Location: "Moscow"}
+		repo.On("UpdateTx", tx, int64(2), "E2", "Jane Roe", sales).Return(nil)
+		repo.On("UpdateTx", tx, int64(3), "E3", "Gone Away", employee.Attributes{Department: "IT"}).Return(nil)
+		// колонки location нет, поэтому город сотрудников не меняется
+		var data = "external_id,name,department\nE1,John Doe,IT\nE2,Jane Roe,Sales\nE3,Gone Away,IT\n"
+
+		var report, err = svc.Import(strings.NewReader(data), Options{})
+		a.Nil(err)
+		a.Equal(1, report.Unchanged)
+		a.Len(report.Updates, 2)
+		a.Equal(it, report.Updates[0].OldAttributes)
+		a.True(report.Updates[1].Rehire)
+		a.Empty(report.Terminations)
+		a.Equal([]provisioning.Event{
+			{Type: provisioning.AccountUpdated, Account: provisioning.Account{EmployeeId: 2, Name: "Jane Roe"}},
+			{Type: provisioning.AccountCreated, Account: provisioning.Account{EmployeeId: 3, Name: "Gone Away"}},
+		}, provisioner.events)
+		a.Equal([]int64{2, 3}, rules.applied)
+	})
+
+	t.Run("should not terminate employee twice", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator, nil, nil)
+		repo.On("FindAll").Return([]Entity{
+			{Id: 1, ExternalId: external("E1"), Name: "John Doe"},
+			{Id: 2, ExternalId: external("E2"), Name: "Gone Away",
+				TerminatedAt: sql.NullTime{Time: time.Now(), Valid: true}},
+		}, nil)
+
+		var report, err = svc.Import(strings.NewReader("external_id,name\nE1,John Doe\n"), Options{DryRun: true})
+		a.Nil(err)
+		a.Empty(report.Terminations)
+		a.Equal(1, report.Unchanged)
+	})
+}
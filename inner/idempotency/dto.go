@@ -0,0 +1,20 @@
+package idempotency
+
+import (
+	"time"
+)
+
+// Entity сохранённый запрос с ключом идемпотентности.
+// StatusCode равен нулю, пока первый запрос с этим ключом ещё обрабатывается, но не дольше LockedUntil
+type Entity struct {
+	// Owner клиент, отправивший запрос: ключи разных клиентов не пересекаются
+	Owner        string    `db:"owner"`
+	Key          string    `db:"key"`
+	RequestHash  string    `db:"request_hash"`
+	StatusCode   int       `db:"status_code"`
+	ContentType  string    `db:"content_type"`
+	ResponseBody []byte    `db:"response_body"`
+	CreatedAt    time.Time `db:"created_at"`
+	ExpiresAt    time.Time `db:"expires_at"`
+	LockedUntil  time.Time `db:"locked_until"`
+}
@@ -0,0 +1,135 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"strconv"
+	"time"
+)
+
+// HeaderIdempotencyKey заголовок, которым клиент помечает запрос, безопасный для повтора
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// HeaderIdempotentReplayed заголовок, которым помечается ответ, повторённый из хранилища
+const HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+const maxKeyLength = 255
+
+// интерфейс репозитория idempotency.Repository
+type Repo interface {
+	Reserve(*Entity) (bool, error)
+	FindByKey(string, string) (Entity, error)
+	SaveResponse(*Entity) error
+	Release(string, string) error
+	DeleteExpired() (int64, error)
+}
+
+// New создаёт middleware для POST запросов с заголовком Idempotency-Key.
+// Первый запрос с ключом выполняется, и его ответ хранится в течение ttl.
+// Повтор с тем же ключом и тем же телом получает сохранённый ответ без повторного выполнения,
+// повтор с тем же ключом и другим телом отклоняется.
+// Ключ принадлежит клиенту из auth.Principal, поэтому middleware должно стоять после auth.NewMiddleware:
+// чужой запрос с тем же ключом и телом выполняется отдельно и не получает сохранённый ответ.
+// Пока первый запрос обрабатывается, ключ занят не дольше lease: если экземпляр упал, не сохранив ответ,
+// повтор выполнится заново через lease, а не через ttl. lease должен быть больше времени обработки запроса
+func New(repo Repo, ttl time.Duration, lease time.Duration) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		var key = ctx.Get(HeaderIdempotencyKey)
+		if key == "" || ctx.Method() != fiber.MethodPost {
+			return ctx.Next()
+		}
+		if len(key) > maxKeyLength {
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, "Idempotency-Key is too long")
+		}
+
+		if _, err := repo.DeleteExpired(); err != nil {
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+		var now = time.Now()
+		var entity = Entity{
+			Owner:       owner(ctx),
+			Key:         key,
+			RequestHash: requestHash(ctx),
+			ExpiresAt:   now.Add(ttl),
+			LockedUntil: now.Add(lease),
+		}
+		reserved, err := repo.Reserve(&entity)
+		if err != nil {
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+		if !reserved {
+			return replay(ctx, repo, entity)
+		}
+
+		if err = ctx.Next(); err != nil {
+			// ответ сформирует обработчик ошибок fiber, сохранять нечего: освобождаем ключ для повтора
+			return errors.Join(err, repo.Release(entity.Owner, key))
+		}
+		// ошибки сервера считаем временными и не запоминаем, чтобы клиент мог повторить запрос
+		if ctx.Response().StatusCode() >= fiber.StatusInternalServerError {
+			return repo.Release(entity.Owner, key)
+		}
+
+		entity.StatusCode = ctx.Response().StatusCode()
+		entity.ContentType = string(ctx.Response().Header.ContentType())
+		entity.ResponseBody = append([]byte(nil), ctx.Response().Body()...)
+		if err = repo.SaveResponse(&entity); err != nil {
+			// клиент уже получает успешный ответ, поэтому не подменяем его ошибкой,
+			// а освобождаем ключ, чтобы повтор не завис в состоянии "обрабатывается"
+			return repo.Release(entity.Owner, key)
+		}
+		return nil
+	}
+}
+
+// replay возвращает сохранённый ответ для уже использованного ключа
+func replay(ctx *fiber.Ctx, repo Repo, request Entity) error {
+	var stored, err = repo.FindByKey(request.Owner, request.Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// ключ освободили между Reserve и FindByKey: первый запрос завершился ошибкой
+		return common.ErrResponse(ctx, fiber.StatusConflict, "request with this Idempotency-Key failed, retry it")
+	}
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+	if stored.RequestHash != request.RequestHash {
+		return common.ErrResponse(ctx, fiber.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+	}
+	if stored.StatusCode == 0 {
+		return common.ErrResponse(ctx, fiber.StatusConflict, "request with this Idempotency-Key is still being processed")
+	}
+
+	ctx.Set(HeaderIdempotentReplayed, "true")
+	ctx.Set(fiber.HeaderContentType, stored.ContentType)
+	return ctx.Status(stored.StatusCode).Send(stored.ResponseBody)
+}
+
+// requestHash отпечаток запроса: метод, адрес и тело.
+// Тот же ключ на другом маршруте считается другим запросом
+func requestHash(ctx *fiber.Ctx) string {
+	var hash = sha256.New()
+	hash.Write([]byte(ctx.Method()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(ctx.OriginalURL()))
+	hash.Write([]byte{0})
+	hash.Write(ctx.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// owner клиент, которому принадлежит ключ: API-ключ или сотрудник по токену; пусто без auth.NewMiddleware
+func owner(ctx *fiber.Ctx) string {
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		switch {
+		case principal.ApiKeyId != 0:
+			return "apikey:" + strconv.FormatInt(principal.ApiKeyId, 10)
+		case principal.EmployeeId != 0:
+			return "employee:" + strconv.FormatInt(principal.EmployeeId, 10)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+package idempotency
+
+import (
+	"database/sql"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// StubKey первичный ключ таблицы idempotency_key
+type StubKey struct {
+	Owner string
+	Key   string
+}
+
+// StubRepo хранит ключи в памяти вместо таблицы idempotency_key
+type StubRepo struct {
+	Keys map[StubKey]Entity
+}
+
+func NewStubRepo() *StubRepo {
+	return &StubRepo{Keys: map[StubKey]Entity{}}
+}
+
+func (r *StubRepo) Reserve(e *Entity) (bool, error) {
+	if stored, ok := r.Keys[StubKey{e.Owner, e.Key}]; ok && stored.ExpiresAt.After(time.Now()) &&
+		(stored.StatusCode != 0 || stored.LockedUntil.After(time.Now())) {
+		return false, nil
+	}
+	r.Keys[StubKey{e.Owner, e.Key}] = *e
+	return true, nil
+}
+
+func (r *StubRepo) FindByKey(owner string, key string) (Entity, error) {
+	if stored, ok := r.Keys[StubKey{owner, key}]; ok {
+		return stored, nil
+	}
+	return Entity{}, sql.ErrNoRows
+}
+
+func (r *StubRepo) SaveResponse(e *Entity) error {
+	r.Keys[StubKey{e.Owner, e.Key}] = *e
+	return nil
+}
+
+func (r *StubRepo) Release(owner string, key string) error {
+	delete(r.Keys, StubKey{owner, key})
+	return nil
+}
+
+func (r *StubRepo) DeleteExpired() (int64, error) {
+	return 0, nil
+}
+
+// StubSessions принимает токен "employee-<id>"
+type StubSessions struct{}
+
+func (StubSessions) Verify(accessToken string) (auth.Claims, error) {
+	switch accessToken {
+	case "employee-7":
+		return auth.Claims{EmployeeId: 7}, nil
+	case "employee-8":
+		return auth.Claims{EmployeeId: 8}, nil
+	}
+	return auth.Claims{}, common.UnauthorizedError{Message: "invalid token"}
+}
+
+// newTestApp создаёт приложение с одним POST маршрутом, который считает свои вызовы
+func newTestApp(repo Repo, status int) (*fiber.App, *int) {
+	var calls = 0
+	var app = fiber.New()
+	app.Post("/employees", New(repo, time.Hour, time.Minute), func(ctx *fiber.Ctx) error {
+		calls++
+		return ctx.Status(status).JSON(fiber.Map{"data": calls})
+	})
+	return app, &calls
+}
+
+func post(app *fiber.App, key string, body string, headers ...string) (int, string, string) {
+	var req = httptest.NewRequest(fiber.MethodPost, "/employees", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		panic(err)
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(respBody), resp.Header.Get(HeaderIdempotentReplayed)
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should replay stored response", func(t *testing.T) {
+		var app, calls = newTestApp(NewStubRepo(), fiber.StatusOK)
+		status, body, replayed := post(app, "key-1", `{"name":"Uncle Bob"}`)
+		a.Equal(fiber.StatusOK, status)
+		a.Equal(`{"data":1}`, body)
+		a.Empty(replayed)
+
+		status, body, replayed = post(app, "key-1", `{"name":"Uncle Bob"}`)
+		a.Equal(fiber.StatusOK, status)
+		a.Equal(`{"data":1}`, body)
+		a.Equal("true", replayed)
+		a.Equal(1, *calls)
+	})
+
+	t.Run("should reject key reused with different payload", func(t *testing.T) {
+		var app, calls = newTestApp(NewStubRepo(), fiber.StatusOK)
+		post(app, "key-1", `{"name":"Uncle Bob"}`)
+		status, _, _ := post(app, "key-1", `{"name":"Martin Fowler"}`)
+		a.Equal(fiber.StatusUnprocessableEntity, status)
+		a.Equal(1, *calls)
+	})
+
+	t.Run("should reject key while first request is in progress", func(t *testing.T) {
+		var repo = NewStubRepo()
+		var app, _ = newTestApp(repo, fiber.StatusOK)
+		post(app, "key-1", `{"name":"Uncle Bob"}`)
+		// имитируем незавершённую обработку первого запроса
+		var stored = repo.Keys[StubKey{Key: "key-1"}]
+		stored.StatusCode = 0
+		repo.Keys[StubKey{Key: "key-1"}] = stored
+		status, _, _ := post(app, "key-1", `{"name":"Uncle Bob"}`)
+		a.Equal(fiber.StatusConflict, status)
+	})
+
+	t.Run("should take over key of request that outlived its lease", func(t *testing.T) {
+		var repo = NewStubRepo()
+		var app, calls = newTestApp(repo, fiber.StatusOK)
+		post(app, "key-1", `{"name":"Uncle Bob"}`)
+		// первый запрос так и не сохранил ответ: экземпляр упал посреди обработки
+		var stored = repo.Keys[StubKey{Key: "key-1"}]
+		stored.StatusCode = 0
+		stored.LockedUntil = time.Now().Add(-time.Second)
+		repo.Keys[StubKey{Key: "key-1"}] = stored
+		status, body, replayed := post(app, "key-1", `{"name":"Uncle Bob"}`)
+		a.Equal(fiber.StatusOK, status)
+		a.Equal(`{"data":2}`, body)
+		a.Empty(replayed)
+		a.Equal(2, *calls)
+	})
+
+	t.Run("should not store server errors", func(t *testing.T) {
+		var repo = NewStubRepo()
+		var app, calls = newTestApp(repo, fiber.StatusInternalServerError)
+		post(app, "key-1", `{"name":"Uncle Bob"}`)
+		a.Empty(repo.Keys)
+		post(app, "key-1", `{"name":"Uncle Bob"}`)
+		a.Equal(2, *calls)
+	})
+
+	t.Run("should not share key between clients", func(t *testing.T) {
+		var repo = NewStubRepo()
+		var calls = 0
+		var app = fiber.New()
+		app.Use(auth.NewMiddleware(StubSessions{}, nil, nil))
+		app.Post("/employees", New(repo, time.Hour, time.Minute), func(ctx *fiber.Ctx) error {
+			calls++
+			return ctx.JSON(fiber.Map{"data": calls})
+		})
+
+		_, body, _ := post(app, "key-1", `{"name":"Uncle Bob"}`, fiber.HeaderAuthorization, "Bearer employee-7")
+		a.Equal(`{"data":1}`, body)
+		status, body, replayed := post(app, "key-1", `{"name":"Uncle Bob"}`, fiber.HeaderAuthorization, "Bearer employee-8")
+		a.Equal(fiber.StatusOK, status)
+		a.Equal(`{"data":2}`, body, "another client must not receive the stored response")
+		a.Empty(replayed)
+		_, body, replayed = post(app, "key-1", `{"name":"Uncle Bob"}`, fiber.HeaderAuthorization, "Bearer employee-7")
+		a.Equal(`{"data":1}`, body)
+		a.Equal("true", replayed)
+		a.Contains(repo.Keys, StubKey{"employee:7", "key-1"})
+		a.Contains(repo.Keys, StubKey{"employee:8", "key-1"})
+	})
+
+	t.Run("should pass requests without key", func(t *testing.T) {
+		var app, calls = newTestApp(NewStubRepo(), fiber.StatusOK)
+		post(app, "", `{"name":"Uncle Bob"}`)
+		post(app, "", `{"name":"Uncle Bob"}`)
+		a.Equal(2, *calls)
+	})
+}
@@ -0,0 +1,76 @@
+package idempotency
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// Reserve занимает ключ за текущим запросом. Возвращает false, если ключ уже занят
+// и ещё не истёк. Истёкший ключ, как и ключ запроса, который так и не получил ответ
+// до LockedUntil, перезаписывается, как будто его не было
+func (r *Repository) Reserve(e *Entity) (bool, error) {
+	query := `
+		INSERT INTO idempotency_key (owner, key, request_hash, expires_at, locked_until)
+		VALUES (:owner, :key, :request_hash, :expires_at, :locked_until)
+		ON CONFLICT (owner, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+		    status_code = 0,
+		    content_type = '',
+		    response_body = NULL,
+		    created_at = NOW(),
+		    expires_at = EXCLUDED.expires_at,
+		    locked_until = EXCLUDED.locked_until
+		WHERE idempotency_key.expires_at <= NOW()
+		   OR (idempotency_key.status_code = 0 AND idempotency_key.locked_until <= NOW())
+	`
+	res, err := r.db.NamedExec(query, e)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (r *Repository) FindByKey(owner string, key string) (e Entity, err error) {
+	err = r.db.Get(&e, "SELECT * FROM idempotency_key WHERE owner = $1 AND key = $2", owner, key)
+	return
+}
+
+// SaveResponse сохраняет ответ на запрос, чтобы вернуть его при повторе
+func (r *Repository) SaveResponse(e *Entity) error {
+	query := `
+		UPDATE idempotency_key
+		SET status_code = :status_code, content_type = :content_type, response_body = :response_body
+		WHERE owner = :owner AND key = :key
+	`
+	_, err := r.db.NamedExec(query, e)
+	return err
+}
+
+// Release освобождает ключ, если запрос не удалось обработать, чтобы клиент мог повторить его
+func (r *Repository) Release(owner string, key string) error {
+	_, err := r.db.Exec("DELETE FROM idempotency_key WHERE owner = $1 AND key = $2", owner, key)
+	return err
+}
+
+func (r *Repository) DeleteExpired() (int64, error) {
+	res, err := r.db.Exec("DELETE FROM idempotency_key WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
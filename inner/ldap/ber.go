@@ -0,0 +1,253 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Классы тегов BER
+const (
+	ClassUniversal   byte = 0x00
+	ClassApplication byte = 0x40
+	ClassContext     byte = 0x80
+)
+
+// Универсальные теги, которые используются в LDAP
+const (
+	TagBoolean     = 0x01
+	TagInteger     = 0x02
+	TagOctetString = 0x04
+	TagNull        = 0x05
+	TagEnumerated  = 0x0a
+	TagSequence    = 0x10
+	TagSet         = 0x11
+)
+
+// maxPacketSize ограничивает размер одного сообщения, чтобы испорченная длина не заставила выделить гигабайты
+const maxPacketSize = 16 << 20
+
+// Packet элемент BER: примитивный со значением Value или составной с дочерними элементами
+type Packet struct {
+	Class       byte
+	Constructed bool
+	Tag         int
+	Value       []byte
+	Children    []*Packet
+}
+
+func NewSequence(children ...*Packet) *Packet {
+	return &Packet{Class: ClassUniversal, Constructed: true, Tag: TagSequence, Children: children}
+}
+
+func NewSet(children ...*Packet) *Packet {
+	return &Packet{Class: ClassUniversal, Constructed: true, Tag: TagSet, Children: children}
+}
+
+func NewString(value string) *Packet {
+	return &Packet{Class: ClassUniversal, Tag: TagOctetString, Value: []byte(value)}
+}
+
+func NewInteger(value int64) *Packet {
+	return &Packet{Class: ClassUniversal, Tag: TagInteger, Value: encodeInt(value)}
+}
+
+func NewEnumerated(value int64) *Packet {
+	return &Packet{Class: ClassUniversal, Tag: TagEnumerated, Value: encodeInt(value)}
+}
+
+func NewBoolean(value bool) *Packet {
+	var b byte
+	if value {
+		b = 0xff
+	}
+	return &Packet{Class: ClassUniversal, Tag: TagBoolean, Value: []byte{b}}
+}
+
+// Tagged меняет класс и тег элемента, сохраняя содержимое (неявное тегирование)
+func (p *Packet) Tagged(class byte, tag int) *Packet {
+	p.Class = class
+	p.Tag = tag
+	return p
+}
+
+func (p *Packet) Is(class byte, tag int) bool {
+	return p.Class == class && p.Tag == tag
+}
+
+func (p *Packet) String() string {
+	return string(p.Value)
+}
+
+// Int декодирует INTEGER или ENUMERATED
+func (p *Packet) Int() (int64, error) {
+	if len(p.Value) == 0 || len(p.Value) > 8 {
+		return 0, fmt.Errorf("invalid integer length %d", len(p.Value))
+	}
+	var v = int64(int8(p.Value[0]))
+	for _, b := range p.Value[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
+
+func (p *Packet) Bool() bool {
+	return len(p.Value) > 0 && p.Value[0] != 0
+}
+
+// Child возвращает дочерний элемент по индексу или ошибку, если его нет
+func (p *Packet) Child(i int) (*Packet, error) {
+	if i >= len(p.Children) {
+		return nil, fmt.Errorf("element has %d children, want at least %d", len(p.Children), i+1)
+	}
+	return p.Children[i], nil
+}
+
+// Bytes кодирует элемент в BER
+func (p *Packet) Bytes() []byte {
+	var content = p.Value
+	if p.Constructed {
+		content = nil
+		for _, c := range p.Children {
+			content = append(content, c.Bytes()...)
+		}
+	}
+	var id = p.Class | byte(p.Tag)
+	if p.Constructed {
+		id |= 0x20
+	}
+	var out = append([]byte{id}, encodeLength(len(content))...)
+	return append(out, content...)
+}
+
+// ReadPacket читает из r один элемент BER целиком
+func ReadPacket(r *bufio.Reader) (*Packet, error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if id&0x1f == 0x1f {
+		return nil, errors.New("multi-byte tags are not supported")
+	}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	var content = make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return decode(id, content)
+}
+
+// ParsePacket декодирует один элемент BER из data
+func ParsePacket(data []byte) (*Packet, error) {
+	var p, rest, err = parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after element", len(rest))
+	}
+	return p, nil
+}
+
+func parse(data []byte) (*Packet, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	var id = data[0]
+	if id&0x1f == 0x1f {
+		return nil, nil, errors.New("multi-byte tags are not supported")
+	}
+	var length = int(data[1])
+	var offset = 2
+	if length&0x80 != 0 {
+		var n = length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, nil, fmt.Errorf("unsupported length of %d bytes", n)
+		}
+		if len(data) < offset+n {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		length = 0
+		for _, b := range data[offset : offset+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if length > len(data)-offset {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	p, err := decode(id, data[offset:offset+length])
+	return p, data[offset+length:], err
+}
+
+func decode(id byte, content []byte) (*Packet, error) {
+	var p = &Packet{Class: id & 0xc0, Constructed: id&0x20 != 0, Tag: int(id & 0x1f)}
+	if !p.Constructed {
+		p.Value = content
+		return p, nil
+	}
+	for len(content) > 0 {
+		child, rest, err := parse(content)
+		if err != nil {
+			return nil, err
+		}
+		p.Children = append(p.Children, child)
+		content = rest
+	}
+	return p, nil
+}
+
+func readLength(r *bufio.Reader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b&0x80 == 0 {
+		return int(b), nil
+	}
+	var n = int(b & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, fmt.Errorf("unsupported length of %d bytes", n)
+	}
+	var length int
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	if length > maxPacketSize {
+		return 0, fmt.Errorf("packet of %d bytes is too large", length)
+	}
+	return length, nil
+}
+
+func encodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var out []byte
+	for l := length; l > 0; l >>= 8 {
+		out = append([]byte{byte(l)}, out...)
+	}
+	return append([]byte{0x80 | byte(len(out))}, out...)
+}
+
+// encodeInt кодирует число минимальным количеством байт в дополнительном коде
+func encodeInt(v int64) []byte {
+	var out = []byte{byte(v)}
+	for {
+		var rest = v >> 8
+		var sign = out[0] & 0x80
+		if (rest == 0 && sign == 0) || (rest == -1 && sign != 0) {
+			return out
+		}
+		v = rest
+		out = append([]byte{byte(v)}, out...)
+	}
+}
@@ -0,0 +1,83 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacket(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should encode integers minimally", func(t *testing.T) {
+		var cases = map[int64][]byte{
+			0:      {0x00},
+			127:    {0x7f},
+			128:    {0x00, 0x80},
+			256:    {0x01, 0x00},
+			-1:     {0xff},
+			-129:   {0xff, 0x7f},
+			100000: {0x01, 0x86, 0xa0},
+		}
+		for value, want := range cases {
+			var p = NewInteger(value)
+			a.Equal(want, p.Value, "value %d", value)
+			got, err := p.Int()
+			a.Nil(err)
+			a.Equal(value, got)
+		}
+	})
+
+	t.Run("should round trip nested packet with long length", func(t *testing.T) {
+		var long = strings.Repeat("x", 300)
+		var message = NewSequence(
+			NewInteger(7),
+			NewSequence(NewString("uid=1,ou=people"), NewSet(NewString(long))).Tagged(ClassApplication, OpAddRequest),
+		)
+		var data = message.Bytes()
+		got, err := ReadPacket(bufio.NewReader(bytes.NewReader(data)))
+		a.Nil(err)
+		a.Equal(data, got.Bytes())
+		a.True(got.Children[1].Is(ClassApplication, OpAddRequest))
+		a.Equal(long, got.Children[1].Children[1].Children[0].String())
+	})
+
+	t.Run("should reject truncated packet", func(t *testing.T) {
+		var data = NewSequence(NewString("abc")).Bytes()
+		_, err := ParsePacket(data[:len(data)-1])
+		a.NotNil(err)
+	})
+}
+
+func TestCompileFilter(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should compile nested filter", func(t *testing.T) {
+		var p, err = CompileFilter(`(&(objectClass=groupOfNames)(|(cn=a\2ab)(!(member=*))))`)
+		a.Nil(err)
+		a.True(p.Is(ClassContext, FilterAnd))
+		a.Equal("objectClass", p.Children[0].Children[0].String())
+		var or = p.Children[1]
+		a.True(or.Is(ClassContext, FilterOr))
+		a.Equal("a*b", or.Children[0].Children[1].String())
+		a.True(or.Children[1].Children[0].Is(ClassContext, FilterPresent))
+	})
+
+	t.Run("should reject unsupported filters", func(t *testing.T) {
+		for _, filter := range []string{"cn=a", "(cn=a*)", "(cn>=a)", "(&(cn=a)", `(cn=\2)`} {
+			var _, err = CompileFilter(filter)
+			a.NotNil(err, filter)
+		}
+	})
+}
+
+func TestEscape(t *testing.T) {
+	var a = assert.New(t)
+	a.Equal(`Sales\, Europe`, EscapeDN("Sales, Europe"))
+	a.Equal(`\#1 \+ \<x\> `+`\ `, EscapeDN("#1 + <x>  "))
+	a.Equal(`a\2a\28b\29\5c`, EscapeFilter(`a*(b)\`))
+	a.Equal(`cn=sales\, europe,ou=groups,dc=example`, NormalizeDN(`CN=Sales\, Europe, ou=Groups,dc = example`))
+}
@@ -0,0 +1,229 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout время ожидания подключения и ответа на одну операцию
+const DefaultTimeout = 10 * time.Second
+
+// Conn соединение с сервером LDAPv3. Операции выполняются по одной: запрос, затем ответ
+type Conn struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	lastId  int64
+	timeout time.Duration
+}
+
+// Dial подключается к серверу по адресу вида ldap://host:port или ldaps://host:port
+func Dial(rawURL string, timeout time.Duration) (*Conn, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url %q: %w", rawURL, err)
+	}
+	var dialer = &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", hostPort(u, "389"))
+	case "ldaps":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "636"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported ldap url scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+// Close отправляет UnbindRequest и закрывает соединение
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastId++
+	var unbind = &Packet{Class: ClassApplication, Tag: OpUnbindRequest}
+	_ = c.write(unbind)
+	return c.conn.Close()
+}
+
+// Bind выполняет простую аутентификацию. Пустой dn означает анонимный доступ
+func (c *Conn) Bind(dn string, password string) error {
+	var request = NewSequence(
+		NewInteger(3),
+		NewString(dn),
+		NewString(password).Tagged(ClassContext, 0),
+	).Tagged(ClassApplication, OpBindRequest)
+	return c.simple(request, OpBindResponse)
+}
+
+func (c *Conn) Add(dn string, attributes []Attribute) error {
+	var list = NewSequence()
+	for _, a := range attributes {
+		list.Children = append(list.Children, EncodeAttribute(a))
+	}
+	var request = NewSequence(NewString(dn), list).Tagged(ClassApplication, OpAddRequest)
+	return c.simple(request, OpAddResponse)
+}
+
+func (c *Conn) Modify(dn string, changes []Change) error {
+	var list = NewSequence()
+	for _, change := range changes {
+		list.Children = append(list.Children, NewSequence(NewEnumerated(int64(change.Operation)), EncodeAttribute(change.Attribute)))
+	}
+	var request = NewSequence(NewString(dn), list).Tagged(ClassApplication, OpModifyRequest)
+	return c.simple(request, OpModifyResponse)
+}
+
+func (c *Conn) Delete(dn string) error {
+	var request = NewString(dn).Tagged(ClassApplication, OpDelRequest)
+	return c.simple(request, OpDelResponse)
+}
+
+// Search возвращает все записи, подходящие под запрос. Ссылки на другие серверы пропускаются
+func (c *Conn) Search(request SearchRequest) ([]Entry, error) {
+	var filter = request.Filter
+	if filter == "" {
+		filter = "(objectClass=*)"
+	}
+	compiled, err := CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var attributes = NewSequence()
+	for _, a := range request.Attributes {
+		attributes.Children = append(attributes.Children, NewString(a))
+	}
+	var op = NewSequence(
+		NewString(request.BaseDN),
+		NewEnumerated(int64(request.Scope)),
+		NewEnumerated(0),
+		NewInteger(int64(request.SizeLimit)),
+		NewInteger(0),
+		NewBoolean(false),
+		compiled,
+		attributes,
+	).Tagged(ClassApplication, OpSearchRequest)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for {
+		response, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case response.Is(ClassApplication, OpSearchResultEntry):
+			entry, err := decodeEntry(response)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case response.Is(ClassApplication, OpSearchResultRef):
+			continue
+		case response.Is(ClassApplication, OpSearchResultDone):
+			return entries, DecodeResult(response)
+		default:
+			return nil, fmt.Errorf("unexpected search response tag %d", response.Tag)
+		}
+	}
+}
+
+func decodeEntry(p *Packet) (Entry, error) {
+	if len(p.Children) != 2 {
+		return Entry{}, fmt.Errorf("malformed search result entry")
+	}
+	var entry = Entry{DN: p.Children[0].String()}
+	for _, a := range p.Children[1].Children {
+		attribute, err := DecodeAttribute(a)
+		if err != nil {
+			return Entry{}, err
+		}
+		entry.Attributes = append(entry.Attributes, attribute)
+	}
+	return entry, nil
+}
+
+// simple отправляет запрос, на который сервер отвечает одним LDAPResult
+func (c *Conn) simple(request *Packet, responseOp int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, err := c.send(request)
+	if err != nil {
+		return err
+	}
+	response, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if !response.Is(ClassApplication, responseOp) {
+		return fmt.Errorf("unexpected response tag %d, want %d", response.Tag, responseOp)
+	}
+	return DecodeResult(response)
+}
+
+func (c *Conn) send(op *Packet) (int64, error) {
+	c.lastId++
+	return c.lastId, c.write(op)
+}
+
+func (c *Conn) write(op *Packet) error {
+	var message = NewSequence(NewInteger(c.lastId), op)
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(message.Bytes())
+	return err
+}
+
+// receive читает ответ на сообщение id. Уведомление о разрыве соединения (messageID 0) превращается в ошибку
+func (c *Conn) receive(id int64) (*Packet, error) {
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+		message, err := ReadPacket(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(message.Children) < 2 {
+			return nil, fmt.Errorf("malformed ldap message")
+		}
+		got, err := message.Children[0].Int()
+		if err != nil {
+			return nil, err
+		}
+		var op = message.Children[1]
+		if got == 0 && op.Is(ClassApplication, OpExtendedResponse) {
+			if err := DecodeResult(op); err != nil {
+				return nil, fmt.Errorf("server closed connection: %w", err)
+			}
+			return nil, fmt.Errorf("server closed connection")
+		}
+		if got == id {
+			return op, nil
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Теги вариантов Filter (RFC 4511), класс CONTEXT
+const (
+	FilterAnd           = 0
+	FilterOr            = 1
+	FilterNot           = 2
+	FilterEqualityMatch = 3
+	FilterPresent       = 7
+)
+
+// CompileFilter переводит строковый фильтр (RFC 4515) в BER.
+// Поддерживаются &, |, !, сравнение на равенство и проверка наличия атрибута (attr=*)
+func CompileFilter(filter string) (*Packet, error) {
+	var p, rest, err = compileFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", filter, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q", filter, rest)
+	}
+	return p, nil
+}
+
+func compileFilter(s string) (*Packet, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("expected '('")
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("unexpected end")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		var tag = FilterAnd
+		if s[0] == '|' {
+			tag = FilterOr
+		}
+		var set = &Packet{Class: ClassContext, Constructed: true, Tag: tag}
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := compileFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			set.Children = append(set.Children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("expected ')'")
+		}
+		return set, s[1:], nil
+	case '!':
+		child, rest, err := compileFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("expected ')'")
+		}
+		return &Packet{Class: ClassContext, Constructed: true, Tag: FilterNot, Children: []*Packet{child}}, rest[1:], nil
+	}
+
+	var end = strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("expected ')'")
+	}
+	var attr, value, found = strings.Cut(s[:end], "=")
+	if !found || attr == "" {
+		return nil, "", fmt.Errorf("expected attr=value")
+	}
+	if strings.ContainsAny(attr, "~<>:") {
+		return nil, "", fmt.Errorf("only equality and presence filters are supported")
+	}
+	if value == "*" {
+		return &Packet{Class: ClassContext, Tag: FilterPresent, Value: []byte(attr)}, s[end+1:], nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", fmt.Errorf("substring filters are not supported")
+	}
+	unescaped, err := unescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	var match = NewSequence(NewString(attr), NewString(unescaped)).Tagged(ClassContext, FilterEqualityMatch)
+	return match, s[end+1:], nil
+}
+
+func unescapeFilter(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", fmt.Errorf("truncated escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
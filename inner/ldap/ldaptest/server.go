@@ -0,0 +1,446 @@
+// Package ldaptest содержит встраиваемый LDAP-сервер для тестов.
+// Он хранит записи в памяти и понимает bind, add, modify, delete и search,
+// но не проверяет схему, кроме обязательного member у groupOfNames
+package ldaptest
+
+import (
+	"bufio"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zhedevops/idm/inner/ldap"
+)
+
+// Server LDAP-сервер, слушающий случайный порт на 127.0.0.1
+type Server struct {
+	listener net.Listener
+	mu       sync.Mutex
+	entries  map[string]*entry
+	bindDN   string
+	password string
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+}
+
+type entry struct {
+	dn         string
+	attributes []ldap.Attribute
+}
+
+// NewServer запускает сервер. Если bindDN не пуст, bind разрешён только с этими учётными данными
+func NewServer(bindDN string, password string) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ldaptest: failed to listen: " + err.Error())
+	}
+	var s = &Server{
+		listener: listener,
+		entries:  map[string]*entry{},
+		bindDN:   bindDN,
+		password: password,
+		conns:    map[net.Conn]bool{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// URL адрес сервера вида ldap://127.0.0.1:port
+func (s *Server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Close останавливает сервер и закрывает открытые соединения
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Put создаёт или заменяет запись напрямую, минуя протокол
+func (s *Server) Put(dn string, attributes map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var e = &entry{dn: dn}
+	var names = make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.attributes = append(e.attributes, ldap.Attribute{Type: name, Values: slices.Clone(attributes[name])})
+	}
+	s.entries[ldap.NormalizeDN(dn)] = e
+}
+
+// Get возвращает атрибуты записи; имена атрибутов приводятся к нижнему регистру
+func (s *Server) Get(dn string) (map[string][]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var e, ok = s.entries[ldap.NormalizeDN(dn)]
+	if !ok {
+		return nil, false
+	}
+	var attributes = map[string][]string{}
+	for _, a := range e.attributes {
+		attributes[strings.ToLower(a.Type)] = slices.Clone(a.Values)
+	}
+	return attributes, true
+}
+
+// DNs возвращает DN всех записей в отсортированном виде
+func (s *Server) DNs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var dns []string
+	for _, e := range s.entries {
+		dns = append(dns, e.dn)
+	}
+	sort.Strings(dns)
+	return dns
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	var reader = bufio.NewReader(conn)
+	var bound = s.bindDN == ""
+	for {
+		message, err := ldap.ReadPacket(reader)
+		if err != nil || len(message.Children) < 2 {
+			return
+		}
+		id, err := message.Children[0].Int()
+		if err != nil {
+			return
+		}
+		var op = message.Children[1]
+		if op.Is(ldap.ClassApplication, ldap.OpUnbindRequest) {
+			return
+		}
+
+		var responses []*ldap.Packet
+		switch {
+		case op.Is(ldap.ClassApplication, ldap.OpBindRequest):
+			var code int
+			code, bound = s.bind(op)
+			responses = []*ldap.Packet{ldap.EncodeResult(ldap.OpBindResponse, code, "", "")}
+		case !bound:
+			responses = []*ldap.Packet{reject(op, ldap.ResultInsufficientAccess, "bind required")}
+		case op.Is(ldap.ClassApplication, ldap.OpAddRequest):
+			responses = []*ldap.Packet{s.add(op)}
+		case op.Is(ldap.ClassApplication, ldap.OpModifyRequest):
+			responses = []*ldap.Packet{s.modify(op)}
+		case op.Is(ldap.ClassApplication, ldap.OpDelRequest):
+			responses = []*ldap.Packet{s.delete(op)}
+		case op.Is(ldap.ClassApplication, ldap.OpSearchRequest):
+			responses = s.search(op)
+		default:
+			responses = []*ldap.Packet{reject(op, ldap.ResultUnwillingToPerform, "operation is not supported")}
+		}
+
+		for _, response := range responses {
+			var packet = ldap.NewSequence(ldap.NewInteger(id), response)
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// reject формирует ответ с ошибкой на операцию, тег ответа на единицу больше тега запроса
+func reject(op *ldap.Packet, code int, message string) *ldap.Packet {
+	var responseOp = op.Tag + 1
+	if op.Is(ldap.ClassApplication, ldap.OpSearchRequest) {
+		responseOp = ldap.OpSearchResultDone
+	}
+	return ldap.EncodeResult(responseOp, code, "", message)
+}
+
+func (s *Server) bind(op *ldap.Packet) (int, bool) {
+	if len(op.Children) < 3 {
+		return ldap.ResultProtocolError, false
+	}
+	var dn, password = op.Children[1].String(), op.Children[2].String()
+	if s.bindDN == "" || (ldap.NormalizeDN(dn) == ldap.NormalizeDN(s.bindDN) && password == s.password) {
+		return ldap.ResultSuccess, true
+	}
+	return ldap.ResultInvalidCredentials, false
+}
+
+func (s *Server) add(op *ldap.Packet) *ldap.Packet {
+	if len(op.Children) != 2 {
+		return ldap.EncodeResult(ldap.OpAddResponse, ldap.ResultProtocolError, "", "malformed add request")
+	}
+	var e = &entry{dn: op.Children[0].String()}
+	for _, a := range op.Children[1].Children {
+		attribute, err := ldap.DecodeAttribute(a)
+		if err != nil {
+			return ldap.EncodeResult(ldap.OpAddResponse, ldap.ResultProtocolError, "", err.Error())
+		}
+		e.attributes = append(e.attributes, attribute)
+	}
+	if code, message := e.check(); code != ldap.ResultSuccess {
+		return ldap.EncodeResult(ldap.OpAddResponse, code, "", message)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var key = ldap.NormalizeDN(e.dn)
+	if _, exists := s.entries[key]; exists {
+		return ldap.EncodeResult(ldap.OpAddResponse, ldap.ResultEntryAlreadyExists, "", "entry already exists")
+	}
+	s.entries[key] = e
+	return ldap.EncodeResult(ldap.OpAddResponse, ldap.ResultSuccess, "", "")
+}
+
+func (s *Server) modify(op *ldap.Packet) *ldap.Packet {
+	if len(op.Children) != 2 {
+		return ldap.EncodeResult(ldap.OpModifyResponse, ldap.ResultProtocolError, "", "malformed modify request")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var current, ok = s.entries[ldap.NormalizeDN(op.Children[0].String())]
+	if !ok {
+		return ldap.EncodeResult(ldap.OpModifyResponse, ldap.ResultNoSuchObject, "", "no such object")
+	}
+
+	// изменения применяются к копии, чтобы ошибка в любом из них не оставила запись наполовину изменённой
+	var e = &entry{dn: current.dn}
+	for _, a := range current.attributes {
+		e.attributes = append(e.attributes, ldap.Attribute{Type: a.Type, Values: slices.Clone(a.Values)})
+	}
+	for _, c := range op.Children[1].Children {
+		if len(c.Children) != 2 {
+			return ldap.EncodeResult(ldap.OpModifyResponse, ldap.ResultProtocolError, "", "malformed change")
+		}
+		operation, err := c.Children[0].Int()
+		if err != nil {
+			return ldap.EncodeResult(ldap.OpModifyResponse, ldap.ResultProtocolError, "", err.Error())
+		}
+		attribute, err := ldap.DecodeAttribute(c.Children[1])
+		if err != nil {
+			return ldap.EncodeResult(ldap.OpModifyResponse, ldap.ResultProtocolError, "", err.Error())
+		}
+		if code, message := e.apply(int(operation), attribute); code != ldap.ResultSuccess {
+			return ldap.EncodeResult(ldap.OpModifyResponse, code, "", message)
+		}
+	}
+	if code, message := e.check(); code != ldap.ResultSuccess {
+		return ldap.EncodeResult(ldap.OpModifyResponse, code, "", message)
+	}
+	*current = *e
+	return ldap.EncodeResult(ldap.OpModifyResponse, ldap.ResultSuccess, "", "")
+}
+
+func (s *Server) delete(op *ldap.Packet) *ldap.Packet {
+	var key = ldap.NormalizeDN(op.String())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok {
+		return ldap.EncodeResult(ldap.OpDelResponse, ldap.ResultNoSuchObject, "", "no such object")
+	}
+	for other := range s.entries {
+		if strings.HasSuffix(other, ","+key) {
+			return ldap.EncodeResult(ldap.OpDelResponse, ldap.ResultNotAllowedOnNonLeaf, "", "entry has children")
+		}
+	}
+	delete(s.entries, key)
+	return ldap.EncodeResult(ldap.OpDelResponse, ldap.ResultSuccess, "", "")
+}
+
+func (s *Server) search(op *ldap.Packet) []*ldap.Packet {
+	if len(op.Children) != 8 {
+		return []*ldap.Packet{reject(op, ldap.ResultProtocolError, "malformed search request")}
+	}
+	var base = ldap.NormalizeDN(op.Children[0].String())
+	scope, err := op.Children[1].Int()
+	if err != nil {
+		return []*ldap.Packet{reject(op, ldap.ResultProtocolError, err.Error())}
+	}
+	var filter = op.Children[6]
+	var wanted []string
+	for _, a := range op.Children[7].Children {
+		wanted = append(wanted, a.String())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[base]; !ok && base != "" {
+		return []*ldap.Packet{ldap.EncodeResult(ldap.OpSearchResultDone, ldap.ResultNoSuchObject, "", "no such object")}
+	}
+
+	var keys []string
+	for key := range s.entries {
+		if inScope(key, base, int(scope)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var responses []*ldap.Packet
+	for _, key := range keys {
+		var e = s.entries[key]
+		if !e.matches(filter) {
+			continue
+		}
+		var attributes = ldap.NewSequence()
+		for _, a := range e.attributes {
+			if len(wanted) == 0 || slices.ContainsFunc(wanted, func(w string) bool { return w == "*" || strings.EqualFold(w, a.Type) }) {
+				attributes.Children = append(attributes.Children, ldap.EncodeAttribute(a))
+			}
+		}
+		responses = append(responses, ldap.NewSequence(ldap.NewString(e.dn), attributes).
+			Tagged(ldap.ClassApplication, ldap.OpSearchResultEntry))
+	}
+	return append(responses, ldap.EncodeResult(ldap.OpSearchResultDone, ldap.ResultSuccess, "", ""))
+}
+
+func inScope(key string, base string, scope int) bool {
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return key == base
+	case ldap.ScopeSingleLevel:
+		var _, parent, found = strings.Cut(key, ",")
+		return found && parent == base
+	default:
+		return key == base || base == "" || strings.HasSuffix(key, ","+base)
+	}
+}
+
+func (e *entry) get(name string) *ldap.Attribute {
+	for i := range e.attributes {
+		if strings.EqualFold(e.attributes[i].Type, name) {
+			return &e.attributes[i]
+		}
+	}
+	return nil
+}
+
+// apply выполняет одно изменение так же, как это делает настоящий сервер:
+// добавление существующего значения и удаление отсутствующего — ошибки
+func (e *entry) apply(operation int, change ldap.Attribute) (int, string) {
+	var current = e.get(change.Type)
+	switch operation {
+	case ldap.ModAdd:
+		if current == nil {
+			e.attributes = append(e.attributes, change)
+			return ldap.ResultSuccess, ""
+		}
+		for _, v := range change.Values {
+			if containsFold(current.Values, v) {
+				return ldap.ResultAttributeOrValueExists, "value " + v + " already exists"
+			}
+			current.Values = append(current.Values, v)
+		}
+	case ldap.ModDelete:
+		if current == nil {
+			return ldap.ResultNoSuchAttribute, "no such attribute " + change.Type
+		}
+		if len(change.Values) == 0 {
+			current.Values = nil
+		}
+		for _, v := range change.Values {
+			if !containsFold(current.Values, v) {
+				return ldap.ResultNoSuchAttribute, "no such value " + v
+			}
+			current.Values = slices.DeleteFunc(current.Values, func(c string) bool { return strings.EqualFold(c, v) })
+		}
+	case ldap.ModReplace:
+		if current == nil {
+			e.attributes = append(e.attributes, change)
+			return ldap.ResultSuccess, ""
+		}
+		current.Values = change.Values
+	default:
+		return ldap.ResultProtocolError, "unknown modify operation"
+	}
+	e.attributes = slices.DeleteFunc(e.attributes, func(a ldap.Attribute) bool { return len(a.Values) == 0 })
+	return ldap.ResultSuccess, ""
+}
+
+// check проверяет единственное правило схемы, на которое опирается провижининг:
+// у groupOfNames должен быть хотя бы один member
+func (e *entry) check() (int, string) {
+	var classes = e.get("objectClass")
+	if classes == nil {
+		return ldap.ResultObjectClassViolation, "objectClass is required"
+	}
+	if containsFold(classes.Values, "groupOfNames") && e.get("member") == nil {
+		return ldap.ResultObjectClassViolation, "groupOfNames requires member"
+	}
+	return ldap.ResultSuccess, ""
+}
+
+func (e *entry) matches(filter *ldap.Packet) bool {
+	switch {
+	case filter.Is(ldap.ClassContext, ldap.FilterAnd):
+		for _, f := range filter.Children {
+			if !e.matches(f) {
+				return false
+			}
+		}
+		return true
+	case filter.Is(ldap.ClassContext, ldap.FilterOr):
+		for _, f := range filter.Children {
+			if e.matches(f) {
+				return true
+			}
+		}
+		return false
+	case filter.Is(ldap.ClassContext, ldap.FilterNot):
+		return len(filter.Children) == 1 && !e.matches(filter.Children[0])
+	case filter.Is(ldap.ClassContext, ldap.FilterPresent):
+		return e.get(filter.String()) != nil
+	case filter.Is(ldap.ClassContext, ldap.FilterEqualityMatch):
+		if len(filter.Children) != 2 {
+			return false
+		}
+		var a = e.get(filter.Children[0].String())
+		if a == nil {
+			return false
+		}
+		var value = filter.Children[1].String()
+		if strings.EqualFold(a.Type, "member") {
+			// значения-DN сравниваются в нормализованном виде
+			return slices.ContainsFunc(a.Values, func(v string) bool { return ldap.NormalizeDN(v) == ldap.NormalizeDN(value) })
+		}
+		return containsFold(a.Values, value)
+	default:
+		return false
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, value) })
+}
@@ -0,0 +1,220 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Теги операций протокола LDAPv3 (RFC 4511), класс APPLICATION
+const (
+	OpBindRequest       = 0
+	OpBindResponse      = 1
+	OpUnbindRequest     = 2
+	OpSearchRequest     = 3
+	OpSearchResultEntry = 4
+	OpSearchResultDone  = 5
+	OpModifyRequest     = 6
+	OpModifyResponse    = 7
+	OpAddRequest        = 8
+	OpAddResponse       = 9
+	OpDelRequest        = 10
+	OpDelResponse       = 11
+	OpSearchResultRef   = 19
+	OpExtendedResponse  = 24
+)
+
+// Коды результата, на которые опирается провижининг
+const (
+	ResultSuccess                = 0
+	ResultProtocolError          = 2
+	ResultNoSuchAttribute        = 16
+	ResultAttributeOrValueExists = 20
+	ResultNoSuchObject           = 32
+	ResultInvalidDNSyntax        = 34
+	ResultInvalidCredentials     = 49
+	ResultInsufficientAccess     = 50
+	ResultUnwillingToPerform     = 53
+	ResultObjectClassViolation   = 65
+	ResultNotAllowedOnNonLeaf    = 66
+	ResultEntryAlreadyExists     = 68
+)
+
+// Области поиска
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// Операции изменения атрибута в ModifyRequest
+const (
+	ModAdd     = 0
+	ModDelete  = 1
+	ModReplace = 2
+)
+
+// Error ответ сервера с кодом, отличным от success
+type Error struct {
+	Code      int
+	MatchedDN string
+	Message   string
+}
+
+func (err *Error) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("ldap result code %d", err.Code)
+	}
+	return fmt.Sprintf("ldap result code %d: %s", err.Code, err.Message)
+}
+
+// IsCode сообщает, что err — ответ сервера с одним из переданных кодов
+func IsCode(err error, codes ...int) bool {
+	var ldapErr *Error
+	if !errors.As(err, &ldapErr) {
+		return false
+	}
+	for _, code := range codes {
+		if ldapErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Attribute атрибут записи со всеми значениями
+type Attribute struct {
+	Type   string
+	Values []string
+}
+
+// Change одно изменение атрибута в ModifyRequest
+type Change struct {
+	Operation int
+	Attribute Attribute
+}
+
+// Entry запись, найденная поиском
+type Entry struct {
+	DN         string
+	Attributes []Attribute
+}
+
+// Get возвращает значения атрибута без учёта регистра имени
+func (e *Entry) Get(name string) []string {
+	for _, a := range e.Attributes {
+		if strings.EqualFold(a.Type, name) {
+			return a.Values
+		}
+	}
+	return nil
+}
+
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	SizeLimit  int
+}
+
+// EncodeAttribute кодирует PartialAttribute: SEQUENCE { type, SET OF value }
+func EncodeAttribute(a Attribute) *Packet {
+	var values = NewSet()
+	for _, v := range a.Values {
+		values.Children = append(values.Children, NewString(v))
+	}
+	return NewSequence(NewString(a.Type), values)
+}
+
+// DecodeAttribute разбирает PartialAttribute
+func DecodeAttribute(p *Packet) (Attribute, error) {
+	if len(p.Children) != 2 {
+		return Attribute{}, fmt.Errorf("attribute must have type and values")
+	}
+	var a = Attribute{Type: p.Children[0].String()}
+	for _, v := range p.Children[1].Children {
+		a.Values = append(a.Values, v.String())
+	}
+	return a, nil
+}
+
+// EncodeResult кодирует LDAPResult с тегом операции ответа
+func EncodeResult(op int, code int, matchedDN string, message string) *Packet {
+	return NewSequence(NewEnumerated(int64(code)), NewString(matchedDN), NewString(message)).
+		Tagged(ClassApplication, op)
+}
+
+// DecodeResult разбирает LDAPResult и превращает неуспешный код в *Error
+func DecodeResult(p *Packet) error {
+	if len(p.Children) < 3 {
+		return fmt.Errorf("malformed ldap result")
+	}
+	code, err := p.Children[0].Int()
+	if err != nil {
+		return err
+	}
+	if code == ResultSuccess {
+		return nil
+	}
+	return &Error{Code: int(code), MatchedDN: p.Children[1].String(), Message: p.Children[2].String()}
+}
+
+// EscapeDN экранирует значение для подстановки в DN (RFC 4514)
+func EscapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			i == 0 && (r == '#' || r == ' '),
+			i == len(value)-1 && r == ' ':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// EscapeFilter экранирует значение для подстановки в фильтр поиска (RFC 4515)
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		var c = value[i]
+		if c == '*' || c == '(' || c == ')' || c == '\\' || c == 0 {
+			fmt.Fprintf(&b, `\%02x`, c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// NormalizeDN приводит DN к виду для сравнения: без пробелов вокруг разделителей и в нижнем регистре.
+// Экранированные запятые внутри значений сохраняются
+func NormalizeDN(dn string) string {
+	var parts []string
+	var start = 0
+	for i := 0; i < len(dn); i++ {
+		if dn[i] == '\\' {
+			i++
+			continue
+		}
+		if dn[i] == ',' {
+			parts = append(parts, dn[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, dn[start:])
+	for i, part := range parts {
+		var name, value, found = strings.Cut(part, "=")
+		if found {
+			part = strings.TrimSpace(name) + "=" + strings.TrimSpace(value)
+		}
+		parts[i] = strings.ToLower(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, ",")
+}
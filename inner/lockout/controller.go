@@ -0,0 +1,97 @@
+package lockout
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"net/url"
+	"strconv"
+)
+
+type Controller struct {
+	server         *web.Server
+	lockoutService Svc
+}
+
+// интерфейс сервиса lockout.Service
+type Svc interface {
+	FindLocked() ([]Response, error)
+	UnlockEmployee(employeeId int64) error
+	UnlockIp(ip string) error
+}
+
+func NewController(server *web.Server, lockoutService Svc) *Controller {
+	return &Controller{
+		server:         server,
+		lockoutService: lockoutService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Get("/lockouts", c.FindLocked)
+	c.server.GroupApiV1.Delete("/lockouts/ip/:ip", c.UnlockIp)
+	c.server.GroupApiV1.Delete("/employees/:id/lockout", c.UnlockEmployee)
+}
+
+func (c *Controller) FindLocked(ctx *fiber.Ctx) error {
+	resp, err := c.lockoutService.FindLocked()
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get lockouts")
+	}
+
+	return nil
+}
+
+// функция-хендлер для DELETE запроса по маршруту "/api/v1/employees/:id/lockout"
+func (c *Controller) UnlockEmployee(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	if err = c.lockoutService.UnlockEmployee(id); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error unlock employee")
+	}
+
+	return nil
+}
+
+// UnlockIp снимает блокировку IP; адрес IPv6 передаётся в пути в URL-кодировке
+func (c *Controller) UnlockIp(ctx *fiber.Ctx) error {
+	ip, err := url.PathUnescape(ctx.Params("ip"))
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid ip")
+	}
+
+	if err = c.lockoutService.UnlockIp(ip); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, ip); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error unlock ip")
+	}
+
+	return nil
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
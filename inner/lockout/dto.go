@@ -0,0 +1,78 @@
+package lockout
+
+import (
+	"database/sql"
+	"github.com/zhedevops/idm/inner/audit"
+	"time"
+)
+
+// Виды счётчиков неудачных входов
+const (
+	KindEmployee = "employee"
+	// KindUsername счётчик имени, под которым сотрудник не найден
+	KindUsername = "username"
+	KindIp       = "ip"
+)
+
+// События блокировок, которые сохраняются в журнале аудита
+const (
+	AuditLocked   = "locked"
+	AuditUnlocked = "unlocked"
+)
+
+// Entity счётчик неудачных входов сотрудника или IP-адреса
+type Entity struct {
+	Kind string `db:"kind"`
+	// Key id сотрудника, введённое имя или IP-адрес клиента
+	Key           string       `db:"key"`
+	Failures      int          `db:"failures"`
+	Lockouts      int          `db:"lockouts"`
+	LastFailureAt time.Time    `db:"last_failure_at"`
+	LockedUntil   sql.NullTime `db:"locked_until"`
+}
+
+// AuditEntity событие журнала аудита о блокировке или разблокировке
+type AuditEntity struct {
+	Kind        string
+	Key         string
+	Event       string
+	LockedUntil sql.NullTime
+	OccurredAt  time.Time
+}
+
+// auditData подробности блокировки в журнале аудита
+type auditData struct {
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+type Response struct {
+	Kind          string    `json:"kind"`
+	Key           string    `json:"key"`
+	Lockouts      int       `json:"lockouts"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+func (e *Entity) toResponse() Response {
+	return Response{
+		Kind:          e.Kind,
+		Key:           e.Key,
+		Lockouts:      e.Lockouts,
+		LastFailureAt: e.LastFailureAt,
+		LockedUntil:   e.LockedUntil.Time,
+	}
+}
+
+func (a *AuditEntity) toEvent() audit.Event {
+	var data auditData
+	if a.LockedUntil.Valid {
+		data.LockedUntil = &a.LockedUntil.Time
+	}
+	return audit.Event{
+		Category:   audit.CategoryLockout,
+		Action:     a.Event,
+		Subject:    a.Kind + ":" + a.Key,
+		Data:       data,
+		OccurredAt: a.OccurredAt,
+	}
+}
@@ -0,0 +1,85 @@
+package lockout
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/audit"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// Find возвращает счётчик; sql.ErrNoRows, если неудачных попыток не было
+func (r *Repository) Find(kind string, key string) (lockout Entity, err error) {
+	err = r.db.Get(&lockout, "SELECT * FROM login_lockout WHERE kind = $1 AND key = $2", kind, key)
+	return
+}
+
+// FindLocked возвращает ключи, заблокированные на момент now
+func (r *Repository) FindLocked(now time.Time) (lockouts []Entity, err error) {
+	query := "SELECT * FROM login_lockout WHERE locked_until > $1 ORDER BY locked_until DESC"
+	err = r.db.Select(&lockouts, query, now)
+	if err != nil {
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// Delete сбрасывает счётчик, например после успешного входа
+func (r *Repository) Delete(kind string, key string) error {
+	_, err := r.db.Exec("DELETE FROM login_lockout WHERE kind = $1 AND key = $2", kind, key)
+	return err
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+// AddFailureTx учитывает неудачную попытку и блокирует строку счётчика до конца транзакции.
+// Попытки до failuresSince не считаются, а число прошлых блокировок забывается, если их не было с lockoutsSince
+func (r *Repository) AddFailureTx(
+	tx *sqlx.Tx,
+	kind string,
+	key string,
+	now time.Time,
+	failuresSince time.Time,
+	lockoutsSince time.Time,
+) (lockout Entity, err error) {
+	query := `INSERT INTO login_lockout (kind, key, failures, last_failure_at)
+              VALUES ($1, $2, 1, $3)
+              ON CONFLICT (kind, key) DO UPDATE SET
+                  failures = CASE WHEN login_lockout.last_failure_at < $4 THEN 1 ELSE login_lockout.failures + 1 END,
+                  lockouts = CASE WHEN login_lockout.last_failure_at < $5 THEN 0 ELSE login_lockout.lockouts END,
+                  last_failure_at = EXCLUDED.last_failure_at
+              RETURNING *`
+	err = tx.Get(&lockout, query, kind, key, now, failuresSince, lockoutsSince)
+	return
+}
+
+// LockTx блокирует ключ до until и обнуляет счётчик попыток
+func (r *Repository) LockTx(tx *sqlx.Tx, kind string, key string, until time.Time) error {
+	query := `UPDATE login_lockout SET locked_until = $3, lockouts = lockouts + 1, failures = 0
+              WHERE kind = $1 AND key = $2`
+	_, err := tx.Exec(query, kind, key, until)
+	return err
+}
+
+// DeleteLockedTx снимает действующую блокировку вместе со счётчиками; возвращает false, если блокировки нет
+func (r *Repository) DeleteLockedTx(tx *sqlx.Tx, kind string, key string, now time.Time) (bool, error) {
+	result, err := tx.Exec("DELETE FROM login_lockout WHERE kind = $1 AND key = $2 AND locked_until > $3", kind, key, now)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// CreateAuditTx дописывает событие блокировки в журнал аудита
+func (r *Repository) CreateAuditTx(tx *sqlx.Tx, e AuditEntity) error {
+	return audit.AppendTx(tx, e.toEvent())
+}
@@ -0,0 +1,200 @@
+package lockout
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Структура сервиса блокировок входа после неудачных попыток
+type Service struct {
+	repo   Repo
+	policy Policy
+	now    func() time.Time
+}
+
+// Policy когда и на сколько блокировать вход
+type Policy struct {
+	// Threshold неудачных попыток сотрудника подряд до блокировки; 0 — не блокировать сотрудников
+	Threshold int
+	// IpThreshold неудачных попыток с одного IP до блокировки; 0 — не блокировать IP
+	IpThreshold int
+	// Duration срок первой блокировки; попытки старше Duration не считаются
+	Duration time.Duration
+	// MaxDuration предельный срок блокировки при Exponential
+	MaxDuration time.Duration
+	// Exponential каждая следующая блокировка вдвое дольше предыдущей; иначе все блокировки по Duration
+	Exponential bool
+}
+
+type Repo interface {
+	Find(string, string) (Entity, error)
+	FindLocked(time.Time) ([]Entity, error)
+	Delete(string, string) error
+	BeginTransaction() (*sqlx.Tx, error)
+	AddFailureTx(*sqlx.Tx, string, string, time.Time, time.Time, time.Time) (Entity, error)
+	LockTx(*sqlx.Tx, string, string, time.Time) error
+	DeleteLockedTx(*sqlx.Tx, string, string, time.Time) (bool, error)
+	CreateAuditTx(*sqlx.Tx, AuditEntity) error
+}
+
+func NewService(repo Repo, policy Policy) *Service {
+	return &Service{
+		repo:   repo,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// counter счётчик, который затрагивает попытка входа
+type counter struct {
+	kind      string
+	key       string
+	threshold int
+}
+
+// lockDuration срок n-й блокировки подряд
+func (p Policy) lockDuration(n int) time.Duration {
+	var duration = p.Duration
+	if !p.Exponential {
+		return duration
+	}
+	for i := 1; i < n && duration < p.MaxDuration; i++ {
+		duration *= 2
+	}
+	return min(duration, max(p.MaxDuration, p.Duration))
+}
+
+// Check возвращает common.LockedError, если заблокирован сотрудник или IP. Если сотрудник не найден (employeeId равен 0),
+// проверяется введённое имя username: иначе по блокировке можно было бы отличить существующее имя от несуществующего
+func (srv *Service) Check(employeeId int64, username string, ip string) error {
+	var now = srv.now()
+	for _, c := range srv.counters(employeeId, username, ip) {
+		lockout, err := srv.repo.Find(c.kind, c.key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error finding lockout of %s %s: %w", c.kind, c.key, err)
+		}
+		if lockout.LockedUntil.Valid && now.Before(lockout.LockedUntil.Time) {
+			return common.LockedError{
+				Message:    "too many failed login attempts, try again later",
+				RetryAfter: lockout.LockedUntil.Time.Sub(now),
+			}
+		}
+	}
+	return nil
+}
+
+// Fail учитывает неудачную попытку входа и блокирует сотрудника (или введённое имя) и IP, если попыток набралось на порог
+func (srv *Service) Fail(employeeId int64, username string, ip string) error {
+	var now = srv.now()
+	return database.InTransaction(srv.repo.BeginTransaction, "counting failed login", func(tx *sqlx.Tx) error {
+		for _, c := range srv.counters(employeeId, username, ip) {
+			if c.threshold <= 0 {
+				continue
+			}
+			var lockoutsSince = now.Add(-max(srv.policy.MaxDuration, srv.policy.Duration))
+			lockout, err := srv.repo.AddFailureTx(tx, c.kind, c.key, now, now.Add(-srv.policy.Duration), lockoutsSince)
+			if err != nil {
+				return fmt.Errorf("error counting failed login of %s %s: %w", c.kind, c.key, err)
+			}
+			if lockout.Failures < c.threshold {
+				continue
+			}
+			var until = now.Add(srv.policy.lockDuration(lockout.Lockouts + 1))
+			if err = srv.repo.LockTx(tx, c.kind, c.key, until); err != nil {
+				return fmt.Errorf("error locking %s %s: %w", c.kind, c.key, err)
+			}
+			var audit = AuditEntity{
+				Kind:        c.kind,
+				Key:         c.key,
+				Event:       AuditLocked,
+				LockedUntil: sql.NullTime{Time: until, Valid: true},
+				OccurredAt:  now,
+			}
+			if err = srv.repo.CreateAuditTx(tx, audit); err != nil {
+				return fmt.Errorf("error saving lockout audit of %s %s: %w", c.kind, c.key, err)
+			}
+			log.Printf("lockout: %s %s locked until %s after %d failed logins", c.kind, c.key, until.Format(time.RFC3339), lockout.Failures)
+		}
+		return nil
+	})
+}
+
+// Succeed сбрасывает счётчик сотрудника после успешного входа. Счётчик IP не сбрасывается,
+// чтобы вход в свою учётную запись не открывал перебор чужих
+func (srv *Service) Succeed(employeeId int64) error {
+	var err = srv.repo.Delete(KindEmployee, strconv.FormatInt(employeeId, 10))
+	if err != nil {
+		return fmt.Errorf("error resetting lockout of employee %d: %w", employeeId, err)
+	}
+	return nil
+}
+
+// FindLocked возвращает действующие блокировки
+func (srv *Service) FindLocked() ([]Response, error) {
+	lockouts, err := srv.repo.FindLocked(srv.now())
+	if err != nil {
+		return nil, fmt.Errorf("error finding lockouts: %w", err)
+	}
+	var resp = make([]Response, 0, len(lockouts))
+	for _, lockout := range lockouts {
+		resp = append(resp, lockout.toResponse())
+	}
+	return resp, nil
+}
+
+// UnlockEmployee снимает блокировку сотрудника администратором
+func (srv *Service) UnlockEmployee(employeeId int64) error {
+	return srv.unlock(KindEmployee, strconv.FormatInt(employeeId, 10))
+}
+
+// UnlockIp снимает блокировку IP-адреса администратором
+func (srv *Service) UnlockIp(ip string) error {
+	var parsed = net.ParseIP(ip)
+	if parsed == nil {
+		return common.RequestValidationError{Message: fmt.Sprintf("invalid ip address %q", ip)}
+	}
+	return srv.unlock(KindIp, parsed.String())
+}
+
+func (srv *Service) unlock(kind string, key string) error {
+	var now = srv.now()
+	return database.InTransaction(srv.repo.BeginTransaction, "unlocking login", func(tx *sqlx.Tx) error {
+		locked, err := srv.repo.DeleteLockedTx(tx, kind, key, now)
+		if err != nil {
+			return fmt.Errorf("error unlocking %s %s: %w", kind, key, err)
+		}
+		if !locked {
+			return common.NotFoundError{Message: fmt.Sprintf("%s %s is not locked", kind, key)}
+		}
+		if err = srv.repo.CreateAuditTx(tx, AuditEntity{Kind: kind, Key: key, Event: AuditUnlocked, OccurredAt: now}); err != nil {
+			return fmt.Errorf("error saving lockout audit of %s %s: %w", kind, key, err)
+		}
+		return nil
+	})
+}
+
+// counters счётчики сотрудника и IP; IP приводится к каноническому виду, чтобы одна запись не писалась по-разному.
+// Для неизвестного сотрудника вместо его счётчика берётся счётчик введённого имени с тем же порогом
+func (srv *Service) counters(employeeId int64, username string, ip string) []counter {
+	var counters []counter
+	if employeeId > 0 {
+		counters = append(counters, counter{KindEmployee, strconv.FormatInt(employeeId, 10), srv.policy.Threshold})
+	} else if username != "" {
+		counters = append(counters, counter{KindUsername, username, srv.policy.Threshold})
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		counters = append(counters, counter{KindIp, parsed.String(), srv.policy.IpThreshold})
+	}
+	return counters
+}
@@ -0,0 +1,209 @@
+package lockout
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) Find(kind string, key string) (Entity, error) {
+	args := m.Called(kind, key)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindLocked(now time.Time) ([]Entity, error) {
+	args := m.Called(now)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) Delete(kind string, key string) error {
+	args := m.Called(kind, key)
+	return args.Error(0)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) AddFailureTx(
+	tx *sqlx.Tx,
+	kind string,
+	key string,
+	now time.Time,
+	failuresSince time.Time,
+	lockoutsSince time.Time,
+) (Entity, error) {
+	args := m.Called(tx, kind, key, now, failuresSince, lockoutsSince)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) LockTx(tx *sqlx.Tx, kind string, key string, until time.Time) error {
+	args := m.Called(tx, kind, key, until)
+	return args.Error(0)
+}
+
+func (m *MockRepo) DeleteLockedTx(tx *sqlx.Tx, kind string, key string, now time.Time) (bool, error) {
+	args := m.Called(tx, kind, key, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) CreateAuditTx(tx *sqlx.Tx, e AuditEntity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+var now = time.Date(2025, 12, 12, 12, 0, 0, 0, time.UTC)
+
+var policy = Policy{Threshold: 3, IpThreshold: 10, Duration: 15 * time.Minute, MaxDuration: time.Hour, Exponential: true}
+
+func newService(repo Repo, policy Policy) *Service {
+	var svc = NewService(repo, policy)
+	svc.now = func() time.Time { return now }
+	return svc
+}
+
+func TestLockDuration(t *testing.T) {
+	var a = assert.New(t)
+	a.Equal(15*time.Minute, policy.lockDuration(1))
+	a.Equal(30*time.Minute, policy.lockDuration(2))
+	a.Equal(time.Hour, policy.lockDuration(3))
+	a.Equal(time.Hour, policy.lockDuration(100))
+
+	var fixed = policy
+	fixed.Exponential = false
+	a.Equal(15*time.Minute, fixed.lockDuration(5))
+}
+
+func TestCheck(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = newService(repo, policy)
+	repo.On("Find", KindEmployee, "1").Return(Entity{LockedUntil: sql.NullTime{Time: now.Add(-time.Minute), Valid: true}}, nil)
+	repo.On("Find", KindIp, "10.0.0.1").Return(Entity{LockedUntil: sql.NullTime{Time: now.Add(time.Minute), Valid: true}}, nil)
+	repo.On("Find", KindIp, "10.0.0.2").Return(Entity{}, sql.ErrNoRows)
+	repo.On("Find", KindUsername, "nobody").Return(Entity{LockedUntil: sql.NullTime{Time: now.Add(time.Minute), Valid: true}}, nil)
+	repo.On("Find", KindUsername, "someone").Return(Entity{}, sql.ErrNoRows)
+
+	var err = svc.Check(1, "John Doe", "10.0.0.1")
+	var locked common.LockedError
+	a.ErrorAs(err, &locked)
+	a.Equal(time.Minute, locked.RetryAfter)
+	// истёкшая блокировка сотрудника не мешает входу
+	a.Nil(svc.Check(1, "John Doe", "10.0.0.2"))
+	repo.AssertNotCalled(t, "Find", KindUsername, "John Doe")
+	// неизвестное имя блокируется так же, как сотрудник, и не выдаёт, что его нет
+	a.ErrorAs(svc.Check(0, "nobody", "10.0.0.2"), &locked)
+	a.Nil(svc.Check(0, "someone", "10.0.0.2"))
+	repo.AssertNotCalled(t, "Find", KindEmployee, "0")
+}
+
+func TestFail(t *testing.T) {
+	var a = assert.New(t)
+	var failuresSince, lockoutsSince = now.Add(-policy.Duration), now.Add(-policy.MaxDuration)
+
+	t.Run("should lock employee at threshold with longer lock each time", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, policy)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("AddFailureTx", tx, KindEmployee, "1", now, failuresSince, lockoutsSince).
+			Return(Entity{Kind: KindEmployee, Key: "1", Failures: 3, Lockouts: 1}, nil)
+		repo.On("AddFailureTx", tx, KindIp, "10.0.0.1", now, failuresSince, lockoutsSince).
+			Return(Entity{Kind: KindIp, Key: "10.0.0.1", Failures: 4}, nil)
+		var until = now.Add(30 * time.Minute)
+		repo.On("LockTx", tx, KindEmployee, "1", until).Return(nil)
+		repo.On("CreateAuditTx", tx, AuditEntity{
+			Kind: KindEmployee, Key: "1", Event: AuditLocked,
+			LockedUntil: sql.NullTime{Time: until, Valid: true}, OccurredAt: now,
+		}).Return(nil)
+
+		a.Nil(svc.Fail(1, "John Doe", "10.0.0.1"))
+		repo.AssertNotCalled(t, "LockTx", tx, KindIp, mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should not count disabled thresholds", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, Policy{Threshold: 3, Duration: time.Minute})
+		tx, _ := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("AddFailureTx", tx, KindEmployee, "1", now, mock.Anything, mock.Anything).
+			Return(Entity{Kind: KindEmployee, Key: "1", Failures: 1}, nil)
+		a.Nil(svc.Fail(1, "John Doe", "10.0.0.1"))
+		repo.AssertNotCalled(t, "AddFailureTx", tx, KindIp, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestFailUnknownUsername(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = newService(repo, Policy{Threshold: 3, Duration: time.Minute})
+	tx, sqlMock := newTx(a, true)
+	repo.On("BeginTransaction").Return(tx, nil)
+	repo.On("AddFailureTx", tx, KindUsername, "nobody", now, mock.Anything, mock.Anything).
+		Return(Entity{Kind: KindUsername, Key: "nobody", Failures: 3}, nil)
+	var until = now.Add(time.Minute)
+	repo.On("LockTx", tx, KindUsername, "nobody", until).Return(nil)
+	repo.On("CreateAuditTx", tx, AuditEntity{
+		Kind: KindUsername, Key: "nobody", Event: AuditLocked,
+		LockedUntil: sql.NullTime{Time: until, Valid: true}, OccurredAt: now,
+	}).Return(nil)
+
+	a.Nil(svc.Fail(0, "nobody", "10.0.0.1"))
+	a.Nil(sqlMock.ExpectationsWereMet())
+}
+
+func TestUnlock(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should unlock ip and record audit", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, policy)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("DeleteLockedTx", tx, KindIp, "2001:db8::1", now).Return(true, nil)
+		repo.On("CreateAuditTx", tx, AuditEntity{Kind: KindIp, Key: "2001:db8::1", Event: AuditUnlocked, OccurredAt: now}).Return(nil)
+		a.Nil(svc.UnlockIp("2001:0db8:0:0:0:0:0:1"))
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should return not found when employee is not locked", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, policy)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("DeleteLockedTx", tx, KindEmployee, "1", now).Return(false, nil)
+		a.ErrorAs(svc.UnlockEmployee(1), &common.NotFoundError{})
+	})
+
+	t.Run("should reject invalid ip", func(t *testing.T) {
+		var svc = newService(new(MockRepo), policy)
+		a.ErrorAs(svc.UnlockIp("not-an-ip"), &common.RequestValidationError{})
+	})
+}
@@ -0,0 +1,152 @@
+package mfa
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+)
+
+type Controller struct {
+	server     *web.Server
+	mfaService Svc
+}
+
+// интерфейс сервиса mfa.Service
+type Svc interface {
+	Status(employeeId int64) (StatusResponse, error)
+	Enroll(employeeId int64) (EnrollResponse, error)
+	Confirm(request ConfirmRequest) (ConfirmResponse, error)
+	Reset(employeeId int64) error
+}
+
+func NewController(server *web.Server, mfaService Svc) *Controller {
+	return &Controller{
+		server:     server,
+		mfaService: mfaService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Get("/employees/:id/mfa", c.Status)
+	c.server.GroupApiV1.Delete("/employees/:id/mfa", c.Reset)
+	c.server.GroupApiV1.Post("/employees/:id/mfa/totp", c.Enroll)
+	c.server.GroupApiV1.Post("/employees/:id/mfa/totp/confirm", c.Confirm)
+}
+
+func (c *Controller) Status(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.mfaService.Status(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get mfa status")
+	}
+
+	return nil
+}
+
+// Enroll возвращает секрет TOTP; ответ содержит секрет, поэтому не кешируется.
+// Подключить второй фактор сотрудник может только себе
+func (c *Controller) Enroll(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	if err = self(ctx, id); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	resp, err := c.mfaService.Enroll(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error enroll totp")
+	}
+
+	return nil
+}
+
+// Confirm включает TOTP и возвращает коды восстановления, поэтому ответ не кешируется.
+// Подтвердить второй фактор сотрудник может только свой
+func (c *Controller) Confirm(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	if err = self(ctx, id); err != nil {
+		return errResponse(ctx, err)
+	}
+	var request ConfirmRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.EmployeeId = id
+
+	resp, err := c.mfaService.Confirm(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error confirm totp")
+	}
+
+	return nil
+}
+
+// функция-хендлер для DELETE запроса по маршруту "/api/v1/employees/:id/mfa"
+func (c *Controller) Reset(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	if err = c.mfaService.Reset(id); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error reset mfa")
+	}
+
+	return nil
+}
+
+// self проверяет, что запрос выполняет сам сотрудник employeeId по своему access-токену
+func self(ctx *fiber.Ctx, employeeId int64) error {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if !ok || principal.EmployeeId != employeeId {
+		return common.ForbiddenError{Message: "second factor can be enrolled only by the employee themselves"}
+	}
+	return nil
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.ForbiddenError{}):
+		return common.ErrResponse(ctx, fiber.StatusForbidden, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	case errors.As(err, &common.ConflictError{}):
+		return common.ErrResponse(ctx, fiber.StatusConflict, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
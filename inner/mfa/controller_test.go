@@ -0,0 +1,73 @@
+package mfa
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+// StubSvc запоминает сотрудников, дошедших до сервиса
+type StubSvc struct {
+	Svc
+	enrolled  int64
+	confirmed int64
+}
+
+func (s *StubSvc) Enroll(employeeId int64) (EnrollResponse, error) {
+	s.enrolled = employeeId
+	return EnrollResponse{}, nil
+}
+
+func (s *StubSvc) Confirm(request ConfirmRequest) (ConfirmResponse, error) {
+	s.confirmed = request.EmployeeId
+	return ConfirmResponse{}, nil
+}
+
+// StubSessions принимает "employee-token" сотрудника 7 и "admin-token" администратора 1
+type StubSessions struct{}
+
+func (StubSessions) Verify(accessToken string) (auth.Claims, error) {
+	switch accessToken {
+	case "employee-token":
+		return auth.Claims{EmployeeId: 7}, nil
+	case "admin-token":
+		return auth.Claims{EmployeeId: 1, Roles: []string{"admin"}}, nil
+	}
+	return auth.Claims{}, common.UnauthorizedError{Message: "invalid token"}
+}
+
+func TestControllerOwnEnrollment(t *testing.T) {
+	a := assert.New(t)
+	var svc = &StubSvc{}
+	var server = web.NewServer()
+	server.GroupApiV1.Use(auth.NewMiddleware(StubSessions{}, nil, []string{"admin"}))
+	NewController(server, svc).RegisterRoutes()
+
+	var send = func(path string, authorization string) int {
+		var req = httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(`{"code":"123456"}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+		resp, err := server.App.Test(req)
+		a.Nil(err)
+		return resp.StatusCode
+	}
+
+	a.Equal(fiber.StatusOK, send("/api/v1/employees/7/mfa/totp", "Bearer employee-token"))
+	a.Equal(int64(7), svc.enrolled)
+	a.Equal(fiber.StatusOK, send("/api/v1/employees/7/mfa/totp/confirm", "Bearer employee-token"))
+	a.Equal(int64(7), svc.confirmed)
+
+	// второй фактор другого сотрудника не подключает и администратор
+	svc.enrolled, svc.confirmed = 0, 0
+	a.Equal(fiber.StatusForbidden, send("/api/v1/employees/8/mfa/totp", "Bearer employee-token"))
+	a.Equal(fiber.StatusForbidden, send("/api/v1/employees/7/mfa/totp", "Bearer admin-token"))
+	a.Equal(fiber.StatusForbidden, send("/api/v1/employees/8/mfa/totp/confirm", "Bearer employee-token"))
+	a.Equal(int64(0), svc.enrolled)
+	a.Equal(int64(0), svc.confirmed)
+}
@@ -0,0 +1,35 @@
+package mfa
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TotpEntity подключённый или ожидающий подтверждения TOTP сотрудника
+type TotpEntity struct {
+	EmployeeId   int64        `db:"employee_id"`
+	Secret       string       `db:"secret"`
+	ConfirmedAt  sql.NullTime `db:"confirmed_at"`
+	LastUsedStep int64        `db:"last_used_step"`
+	CreatedAt    time.Time    `db:"created_at"`
+}
+
+// EnrollResponse секрет для приложения-аутентификатора; показывается один раз при подключении
+type EnrollResponse struct {
+	EmployeeId int64  `json:"employee_id"`
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+// ConfirmResponse коды восстановления; показываются один раз, хранятся только их хеши
+type ConfirmResponse struct {
+	EmployeeId    int64    `json:"employee_id"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type StatusResponse struct {
+	EmployeeId        int64      `json:"employee_id"`
+	Enabled           bool       `json:"enabled"`
+	ConfirmedAt       *time.Time `json:"confirmed_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
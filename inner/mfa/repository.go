@@ -0,0 +1,107 @@
+package mfa
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// FindTotp возвращает TOTP сотрудника; sql.ErrNoRows, если он не подключён
+func (r *Repository) FindTotp(employeeId int64) (totp TotpEntity, err error) {
+	err = r.db.Get(&totp, "SELECT * FROM mfa_totp WHERE employee_id = $1", employeeId)
+	return
+}
+
+// CountRecoveryCodes число неиспользованных кодов восстановления
+func (r *Repository) CountRecoveryCodes(employeeId int64) (count int, err error) {
+	query := "SELECT COUNT(*) FROM mfa_recovery_code WHERE employee_id = $1 AND used_at IS NULL"
+	err = r.db.Get(&count, query, employeeId)
+	return
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+// FindEmployeeNameTx блокирует сотрудника до конца транзакции и возвращает его имя; sql.ErrNoRows, если его нет
+func (r *Repository) FindEmployeeNameTx(tx *sqlx.Tx, employeeId int64) (name string, err error) {
+	err = tx.Get(&name, "SELECT name FROM employee WHERE id = $1 FOR UPDATE", employeeId)
+	return
+}
+
+// FindTotpTx находит и блокирует TOTP сотрудника, чтобы один код нельзя было принять дважды параллельно
+func (r *Repository) FindTotpTx(tx *sqlx.Tx, employeeId int64) (totp TotpEntity, err error) {
+	err = tx.Get(&totp, "SELECT * FROM mfa_totp WHERE employee_id = $1 FOR UPDATE", employeeId)
+	return
+}
+
+// SaveTotpTx создаёт или заменяет неподтверждённый TOTP сотрудника
+func (r *Repository) SaveTotpTx(tx *sqlx.Tx, e *TotpEntity) error {
+	query := `INSERT INTO mfa_totp (employee_id, secret)
+              VALUES ($1, $2)
+              ON CONFLICT (employee_id) DO UPDATE
+              SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_step = 0, created_at = NOW()
+              RETURNING *`
+	return tx.Get(e, query, e.EmployeeId, e.Secret)
+}
+
+// ConfirmTotpTx отмечает TOTP подтверждённым и запоминает интервал принятого кода
+func (r *Repository) ConfirmTotpTx(tx *sqlx.Tx, employeeId int64, at time.Time, step int64) error {
+	query := "UPDATE mfa_totp SET confirmed_at = $2, last_used_step = $3 WHERE employee_id = $1"
+	_, err := tx.Exec(query, employeeId, at, step)
+	return err
+}
+
+// UseStepTx запоминает интервал принятого кода
+func (r *Repository) UseStepTx(tx *sqlx.Tx, employeeId int64, step int64) error {
+	_, err := tx.Exec("UPDATE mfa_totp SET last_used_step = $2 WHERE employee_id = $1", employeeId, step)
+	return err
+}
+
+// ReplaceRecoveryCodesTx заменяет все коды восстановления сотрудника новыми
+func (r *Repository) ReplaceRecoveryCodesTx(tx *sqlx.Tx, employeeId int64, hashes []string) error {
+	_, err := tx.Exec("DELETE FROM mfa_recovery_code WHERE employee_id = $1", employeeId)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		query := "INSERT INTO mfa_recovery_code (employee_id, code_hash) VALUES ($1, $2)"
+		if _, err = tx.Exec(query, employeeId, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCodeTx гасит неиспользованный код восстановления; возвращает false, если такого кода нет
+func (r *Repository) UseRecoveryCodeTx(tx *sqlx.Tx, employeeId int64, hash string, at time.Time) (bool, error) {
+	query := `UPDATE mfa_recovery_code SET used_at = $3
+              WHERE employee_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := tx.Exec(query, employeeId, hash, at)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// DeleteTx удаляет TOTP и коды восстановления сотрудника; возвращает false, если MFA не было подключено
+func (r *Repository) DeleteTx(tx *sqlx.Tx, employeeId int64) (bool, error) {
+	_, err := tx.Exec("DELETE FROM mfa_recovery_code WHERE employee_id = $1", employeeId)
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec("DELETE FROM mfa_totp WHERE employee_id = $1", employeeId)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
@@ -0,0 +1,257 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// recoveryCodeCount сколько кодов восстановления выдаётся при подключении
+const recoveryCodeCount = 10
+
+// Структура сервиса второго фактора входа
+type Service struct {
+	repo      Repo
+	validator Validator
+	// issuer название системы в приложении-аутентификаторе
+	issuer string
+	// requiredRoles роли, которые действуют только в сессии со вторым фактором
+	requiredRoles []string
+	now           func() time.Time
+}
+
+// ConfirmRequest код из приложения, подтверждающий подключение TOTP
+type ConfirmRequest struct {
+	EmployeeId int64  `json:"-" validate:"required,gt=0"`
+	Code       string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+type Repo interface {
+	FindTotp(int64) (TotpEntity, error)
+	CountRecoveryCodes(int64) (int, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	FindEmployeeNameTx(*sqlx.Tx, int64) (string, error)
+	FindTotpTx(*sqlx.Tx, int64) (TotpEntity, error)
+	SaveTotpTx(*sqlx.Tx, *TotpEntity) error
+	ConfirmTotpTx(*sqlx.Tx, int64, time.Time, int64) error
+	UseStepTx(*sqlx.Tx, int64, int64) error
+	ReplaceRecoveryCodesTx(*sqlx.Tx, int64, []string) error
+	UseRecoveryCodeTx(*sqlx.Tx, int64, string, time.Time) (bool, error)
+	DeleteTx(*sqlx.Tx, int64) (bool, error)
+}
+
+func NewService(repo Repo, validator Validator, issuer string, requiredRoles []string) *Service {
+	return &Service{
+		repo:          repo,
+		validator:     validator,
+		issuer:        issuer,
+		requiredRoles: requiredRoles,
+		now:           time.Now,
+	}
+}
+
+// Enroll начинает подключение TOTP: создаёт секрет, который вступит в силу после Confirm.
+// Повторный вызов до подтверждения заменяет секрет; подключённый TOTP сначала нужно сбросить
+func (srv *Service) Enroll(employeeId int64) (EnrollResponse, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return EnrollResponse{}, fmt.Errorf("error generating totp secret: %w", err)
+	}
+	var account string
+	err = database.InTransaction(srv.repo.BeginTransaction, "enrolling totp", func(tx *sqlx.Tx) error {
+		account, err = srv.repo.FindEmployeeNameTx(tx, employeeId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", employeeId)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding employee with id %d: %w", employeeId, err)
+		}
+		current, err := srv.repo.FindTotpTx(tx, employeeId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("error finding totp of employee %d: %w", employeeId, err)
+		}
+		if err == nil && current.ConfirmedAt.Valid {
+			return common.ConflictError{Message: fmt.Sprintf("employee %d already has mfa enabled, reset it first", employeeId)}
+		}
+		if err = srv.repo.SaveTotpTx(tx, &TotpEntity{EmployeeId: employeeId, Secret: secret}); err != nil {
+			return fmt.Errorf("error saving totp of employee %d: %w", employeeId, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return EnrollResponse{}, err
+	}
+	return EnrollResponse{EmployeeId: employeeId, Secret: secret, OtpauthUri: otpauthUri(srv.issuer, account, secret)}, nil
+}
+
+// Confirm включает TOTP после проверки кода из приложения и выдаёт новые коды восстановления
+func (srv *Service) Confirm(request ConfirmRequest) (ConfirmResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return ConfirmResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return ConfirmResponse{}, fmt.Errorf("error generating recovery codes: %w", err)
+	}
+	var now = srv.now()
+	err = database.InTransaction(srv.repo.BeginTransaction, "confirming totp", func(tx *sqlx.Tx) error {
+		totp, err := srv.repo.FindTotpTx(tx, request.EmployeeId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("employee %d has not started mfa enrollment", request.EmployeeId)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding totp of employee %d: %w", request.EmployeeId, err)
+		}
+		if totp.ConfirmedAt.Valid {
+			return common.ConflictError{Message: fmt.Sprintf("employee %d already has mfa enabled", request.EmployeeId)}
+		}
+		step, err := matchStep(totp.Secret, request.Code, now, 0)
+		if err != nil {
+			return fmt.Errorf("error checking totp of employee %d: %w", request.EmployeeId, err)
+		}
+		if step == 0 {
+			return common.RequestValidationError{Message: "invalid code, check the time on your device"}
+		}
+		if err = srv.repo.ConfirmTotpTx(tx, request.EmployeeId, now, step); err != nil {
+			return fmt.Errorf("error confirming totp of employee %d: %w", request.EmployeeId, err)
+		}
+		if err = srv.repo.ReplaceRecoveryCodesTx(tx, request.EmployeeId, hashes); err != nil {
+			return fmt.Errorf("error saving recovery codes of employee %d: %w", request.EmployeeId, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ConfirmResponse{}, err
+	}
+	return ConfirmResponse{EmployeeId: request.EmployeeId, RecoveryCodes: codes}, nil
+}
+
+// Status показывает, включён ли второй фактор и сколько осталось кодов восстановления
+func (srv *Service) Status(employeeId int64) (StatusResponse, error) {
+	var resp = StatusResponse{EmployeeId: employeeId}
+	totp, err := srv.repo.FindTotp(employeeId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return resp, nil
+	}
+	if err != nil {
+		return StatusResponse{}, fmt.Errorf("error finding totp of employee %d: %w", employeeId, err)
+	}
+	if !totp.ConfirmedAt.Valid {
+		return resp, nil
+	}
+	resp.Enabled = true
+	resp.ConfirmedAt = &totp.ConfirmedAt.Time
+	if resp.RecoveryCodesLeft, err = srv.repo.CountRecoveryCodes(employeeId); err != nil {
+		return StatusResponse{}, fmt.Errorf("error counting recovery codes of employee %d: %w", employeeId, err)
+	}
+	return resp, nil
+}
+
+// Reset отключает второй фактор сотрудника, например после потери телефона; сотрудник подключает его заново
+func (srv *Service) Reset(employeeId int64) error {
+	return database.InTransaction(srv.repo.BeginTransaction, "resetting mfa", func(tx *sqlx.Tx) error {
+		deleted, err := srv.repo.DeleteTx(tx, employeeId)
+		if err != nil {
+			return fmt.Errorf("error resetting mfa of employee %d: %w", employeeId, err)
+		}
+		if !deleted {
+			return common.NotFoundError{Message: fmt.Sprintf("employee %d has no mfa", employeeId)}
+		}
+		log.Printf("mfa: reset for employee %d", employeeId)
+		return nil
+	})
+}
+
+// Enabled включён ли у сотрудника второй фактор
+func (srv *Service) Enabled(employeeId int64) (bool, error) {
+	totp, err := srv.repo.FindTotp(employeeId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error finding totp of employee %d: %w", employeeId, err)
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
+// Verify проверяет второй фактор при входе: код из приложения или одноразовый код восстановления.
+// Неверный или уже использованный код — common.UnauthorizedError
+func (srv *Service) Verify(employeeId int64, code string) error {
+	var now = srv.now()
+	return database.InTransaction(srv.repo.BeginTransaction, "verifying mfa", func(tx *sqlx.Tx) error {
+		totp, err := srv.repo.FindTotpTx(tx, employeeId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("error finding totp of employee %d: %w", employeeId, err)
+		}
+		if err != nil || !totp.ConfirmedAt.Valid {
+			return common.UnauthorizedError{Message: "mfa is not enabled"}
+		}
+		code = strings.TrimSpace(code)
+		if len(code) == totpDigits {
+			step, err := matchStep(totp.Secret, code, now, totp.LastUsedStep)
+			if err != nil {
+				return fmt.Errorf("error checking totp of employee %d: %w", employeeId, err)
+			}
+			if step == 0 {
+				return common.UnauthorizedError{Message: "invalid mfa code"}
+			}
+			if err = srv.repo.UseStepTx(tx, employeeId, step); err != nil {
+				return fmt.Errorf("error saving totp step of employee %d: %w", employeeId, err)
+			}
+			return nil
+		}
+		used, err := srv.repo.UseRecoveryCodeTx(tx, employeeId, hashRecoveryCode(code), now)
+		if err != nil {
+			return fmt.Errorf("error using recovery code of employee %d: %w", employeeId, err)
+		}
+		if !used {
+			return common.UnauthorizedError{Message: "invalid mfa code"}
+		}
+		log.Printf("mfa: employee %d signed in with a recovery code", employeeId)
+		return nil
+	})
+}
+
+// Privileged действует ли роль только в сессии со вторым фактором
+func (srv *Service) Privileged(role string) bool {
+	return slices.Contains(srv.requiredRoles, role)
+}
+
+// generateRecoveryCodes коды вида xxxx-xxxx-xxxx-xxxx (80 бит) и их хеши
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	for range recoveryCodeCount {
+		var buf = make([]byte, 10)
+		if _, err = rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		var raw = strings.ToLower(encoding.EncodeToString(buf))
+		var code = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode SHA-256 кода без дефисов, пробелов и регистра, чтобы код можно было ввести как угодно
+func hashRecoveryCode(code string) string {
+	var normalized = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	var sum = sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
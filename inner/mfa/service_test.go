@@ -0,0 +1,255 @@
+package mfa
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindTotp(employeeId int64) (TotpEntity, error) {
+	args := m.Called(employeeId)
+	return args.Get(0).(TotpEntity), args.Error(1)
+}
+
+func (m *MockRepo) CountRecoveryCodes(employeeId int64) (int, error) {
+	args := m.Called(employeeId)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) FindEmployeeNameTx(tx *sqlx.Tx, employeeId int64) (string, error) {
+	args := m.Called(tx, employeeId)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockRepo) FindTotpTx(tx *sqlx.Tx, employeeId int64) (TotpEntity, error) {
+	args := m.Called(tx, employeeId)
+	return args.Get(0).(TotpEntity), args.Error(1)
+}
+
+func (m *MockRepo) SaveTotpTx(tx *sqlx.Tx, e *TotpEntity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ConfirmTotpTx(tx *sqlx.Tx, employeeId int64, at time.Time, step int64) error {
+	args := m.Called(tx, employeeId, at, step)
+	return args.Error(0)
+}
+
+func (m *MockRepo) UseStepTx(tx *sqlx.Tx, employeeId int64, step int64) error {
+	args := m.Called(tx, employeeId, step)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ReplaceRecoveryCodesTx(tx *sqlx.Tx, employeeId int64, hashes []string) error {
+	args := m.Called(tx, employeeId, hashes)
+	return args.Error(0)
+}
+
+func (m *MockRepo) UseRecoveryCodeTx(tx *sqlx.Tx, employeeId int64, hash string, at time.Time) (bool, error) {
+	args := m.Called(tx, employeeId, hash, at)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) DeleteTx(tx *sqlx.Tx, employeeId int64) (bool, error) {
+	args := m.Called(tx, employeeId)
+	return args.Bool(0), args.Error(1)
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+// secret ключ "12345678901234567890" из тестовых векторов RFC 6238 в base32
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var now = time.Unix(1111111109, 0).UTC()
+
+func newService(repo Repo) *Service {
+	var svc = NewService(repo, validator.New(), "IdM", []string{"admin"})
+	svc.now = func() time.Time { return now }
+	return svc
+}
+
+// codeAt код для момента t
+func codeAt(a *assert.Assertions, t time.Time) string {
+	code, err := totpCode(secret, totpStep(t))
+	a.Nil(err)
+	return code
+}
+
+func TestTotp(t *testing.T) {
+	var a = assert.New(t)
+	// RFC 6238, приложение B: младшие шесть цифр кодов SHA1
+	a.Equal("287082", codeAt(a, time.Unix(59, 0)))
+	a.Equal("081804", codeAt(a, time.Unix(1111111109, 0)))
+	a.Equal("005924", codeAt(a, time.Unix(1234567890, 0)))
+
+	var step = totpStep(now)
+	matched, err := matchStep(secret, codeAt(a, now.Add(-totpPeriod)), now, 0)
+	a.Nil(err)
+	a.Equal(step-1, matched)
+	// код уже принятого интервала повторно не подходит
+	matched, err = matchStep(secret, codeAt(a, now), now, step)
+	a.Nil(err)
+	a.Zero(matched)
+	matched, err = matchStep(secret, codeAt(a, now.Add(2*totpPeriod)), now, 0)
+	a.Nil(err)
+	a.Zero(matched)
+
+	generated, err := generateSecret()
+	a.Nil(err)
+	a.Len(generated, 32)
+	a.Equal("otpauth://totp/IdM:John%20Doe?algorithm=SHA1&digits=6&issuer=IdM&period=30&secret="+secret,
+		otpauthUri("IdM", "John Doe", secret))
+}
+
+func TestEnroll(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should create secret and otpauth uri", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindEmployeeNameTx", tx, int64(1)).Return("John Doe", nil)
+		repo.On("FindTotpTx", tx, int64(1)).Return(TotpEntity{}, sql.ErrNoRows)
+		repo.On("SaveTotpTx", tx, mock.Anything).Return(nil)
+		var resp, err = svc.Enroll(1)
+		a.Nil(err)
+		a.Len(resp.Secret, 32)
+		a.True(strings.HasPrefix(resp.OtpauthUri, "otpauth://totp/IdM:John%20Doe?"))
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should not replace confirmed totp", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindEmployeeNameTx", tx, int64(1)).Return("John Doe", nil)
+		repo.On("FindTotpTx", tx, int64(1)).Return(TotpEntity{ConfirmedAt: sql.NullTime{Time: now, Valid: true}}, nil)
+		var _, err = svc.Enroll(1)
+		a.ErrorAs(err, &common.ConflictError{})
+	})
+}
+
+func TestConfirm(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should enable totp and issue recovery codes", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTotpTx", tx, int64(1)).Return(TotpEntity{EmployeeId: 1, Secret: secret}, nil)
+		repo.On("ConfirmTotpTx", tx, int64(1), now, totpStep(now)).Return(nil)
+		var hashes []string
+		repo.On("ReplaceRecoveryCodesTx", tx, int64(1), mock.MatchedBy(func(h []string) bool {
+			hashes = h
+			return true
+		})).Return(nil)
+
+		var resp, err = svc.Confirm(ConfirmRequest{EmployeeId: 1, Code: codeAt(a, now)})
+		a.Nil(err)
+		a.Len(resp.RecoveryCodes, recoveryCodeCount)
+		a.Len(resp.RecoveryCodes[0], 19)
+		a.Equal(hashRecoveryCode(resp.RecoveryCodes[0]), hashes[0])
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should reject wrong code", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTotpTx", tx, int64(1)).Return(TotpEntity{EmployeeId: 1, Secret: secret}, nil)
+		var _, err = svc.Confirm(ConfirmRequest{EmployeeId: 1, Code: "000000"})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		repo.AssertNotCalled(t, "ConfirmTotpTx", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	var a = assert.New(t)
+	var confirmed = TotpEntity{EmployeeId: 1, Secret: secret, ConfirmedAt: sql.NullTime{Time: now, Valid: true}}
+
+	t.Run("should accept totp code once", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTotpTx", tx, int64(1)).Return(confirmed, nil)
+		repo.On("UseStepTx", tx, int64(1), totpStep(now)).Return(nil)
+		a.Nil(svc.Verify(1, codeAt(a, now)))
+		a.Nil(sqlMock.ExpectationsWereMet())
+
+		var used = confirmed
+		used.LastUsedStep = totpStep(now)
+		tx, _ = newTx(a, false)
+		repo = new(MockRepo)
+		svc = newService(repo)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTotpTx", tx, int64(1)).Return(used, nil)
+		a.ErrorAs(svc.Verify(1, codeAt(a, now)), &common.UnauthorizedError{})
+	})
+
+	t.Run("should accept recovery code in any case", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, _ := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTotpTx", tx, int64(1)).Return(confirmed, nil)
+		repo.On("UseRecoveryCodeTx", tx, int64(1), hashRecoveryCode("abcd-efgh-ijkl-mnop"), now).Return(true, nil)
+		a.Nil(svc.Verify(1, "ABCD EFGH IJKL MNOP"))
+	})
+
+	t.Run("should reject employee without confirmed totp", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindTotpTx", tx, int64(1)).Return(TotpEntity{EmployeeId: 1, Secret: secret}, nil)
+		a.ErrorAs(svc.Verify(1, codeAt(a, now)), &common.UnauthorizedError{})
+	})
+}
+
+func TestReset(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = newService(repo)
+	tx, _ := newTx(a, false)
+	repo.On("BeginTransaction").Return(tx, nil)
+	repo.On("DeleteTx", tx, int64(1)).Return(false, nil)
+	a.ErrorAs(svc.Reset(1), &common.NotFoundError{})
+	a.True(svc.Privileged("admin"))
+	a.False(svc.Privileged("auditor"))
+}
@@ -0,0 +1,84 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Параметры TOTP по умолчанию из RFC 6238; именно их поддерживают все приложения-аутентификаторы
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew на сколько интервалов в обе стороны допускается расхождение часов
+	totpSkew = 1
+	// secretSize длина секрета в байтах, рекомендованная RFC 4226 для HMAC-SHA1
+	secretSize = 20
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateSecret случайный секрет в base32 без выравнивания, как его ожидают аутентификаторы
+func generateSecret() (string, error) {
+	var buf = make([]byte, secretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(buf), nil
+}
+
+// totpStep номер 30-секундного интервала для момента t
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode код интервала step по RFC 4226: HMAC-SHA1 и динамическое усечение
+func totpCode(secret string, step int64) (string, error) {
+	key, err := secretEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	var mac = hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	var sum = mac.Sum(nil)
+	var offset = sum[len(sum)-1] & 0x0f
+	var value = binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000), nil
+}
+
+// matchStep ищет интервал не старше after, код которого совпадает с code; 0 — код не подошёл
+func matchStep(secret string, code string, now time.Time, after int64) (int64, error) {
+	var current = totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= after {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, err
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, nil
+		}
+	}
+	return 0, nil
+}
+
+// otpauthUri ссылка для QR-кода в формате Key Uri Format Google Authenticator
+func otpauthUri(issuer string, account string, secret string) string {
+	var query = url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	var label = url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
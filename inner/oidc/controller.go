@@ -0,0 +1,209 @@
+package oidc
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"net/url"
+	"strings"
+)
+
+type Controller struct {
+	server      *web.Server
+	oidcService Svc
+}
+
+// интерфейс сервиса oidc.Service
+type Svc interface {
+	Discovery() Discovery
+	Authorize(request AuthorizeRequest, accessToken string) (string, error)
+	Token(request TokenRequest) (TokenResponse, error)
+	Userinfo(accessToken string) (map[string]any, error)
+	CreateClient(request ClientRequest) (ClientResponse, error)
+	FindClients() ([]ClientResponse, error)
+	DeleteClient(id string) error
+}
+
+func NewController(server *web.Server, oidcService Svc) *Controller {
+	return &Controller{
+		server:      server,
+		oidcService: oidcService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.App.Get("/.well-known/openid-configuration", c.Discovery)
+
+	// полный маршрут получится "/oauth2/authorize"
+	c.server.GroupOAuth2.Get("/authorize", c.Authorize)
+	c.server.GroupOAuth2.Post("/token", c.Token)
+	c.server.GroupOAuth2.Get("/userinfo", c.Userinfo)
+	c.server.GroupOAuth2.Post("/userinfo", c.Userinfo)
+
+	c.server.GroupApiV1.Post("/oidc/clients", c.CreateClient)
+	c.server.GroupApiV1.Get("/oidc/clients", c.FindClients)
+	c.server.GroupApiV1.Delete("/oidc/clients/:id", c.DeleteClient)
+}
+
+func (c *Controller) Discovery(ctx *fiber.Ctx) error {
+	return ctx.JSON(c.oidcService.Discovery())
+}
+
+// функция-хендлер для GET запроса по маршруту "/oauth2/authorize"; сессия IdM берётся из заголовка Authorization или cookie
+func (c *Controller) Authorize(ctx *fiber.Ctx) error {
+	var request AuthorizeRequest
+	if err := ctx.QueryParser(&request); err != nil {
+		return oauthError(ctx, Error{Code: ErrInvalidRequest, Description: err.Error()})
+	}
+	var accessToken = bearerToken(ctx)
+	if accessToken == "" {
+		accessToken = ctx.Cookies(auth.SessionCookie)
+	}
+
+	location, err := c.oidcService.Authorize(request, accessToken)
+	if err != nil {
+		return oauthError(ctx, err)
+	}
+	return ctx.Redirect(location, fiber.StatusFound)
+}
+
+// функция-хендлер для POST запроса по маршруту "/oauth2/token"
+func (c *Controller) Token(ctx *fiber.Ctx) error {
+	var request TokenRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return oauthError(ctx, Error{Code: ErrInvalidRequest, Description: err.Error()})
+	}
+	if id, secret, ok := basicAuth(ctx); ok {
+		request.ClientId, request.ClientSecret = id, secret
+	}
+
+	resp, err := c.oidcService.Token(request)
+	if err != nil {
+		return oauthError(ctx, err)
+	}
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	ctx.Set(fiber.HeaderPragma, "no-cache")
+	return ctx.JSON(resp)
+}
+
+func (c *Controller) Userinfo(ctx *fiber.Ctx) error {
+	resp, err := c.oidcService.Userinfo(bearerToken(ctx))
+	if err != nil {
+		return oauthError(ctx, err)
+	}
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.JSON(resp)
+}
+
+// CreateClient регистрирует приложение; ответ содержит секрет, поэтому не кешируется
+func (c *Controller) CreateClient(ctx *fiber.Ctx) error {
+	var request ClientRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	resp, err := c.oidcService.CreateClient(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error create client")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindClients(ctx *fiber.Ctx) error {
+	resp, err := c.oidcService.FindClients()
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get clients")
+	}
+
+	return nil
+}
+
+func (c *Controller) DeleteClient(ctx *fiber.Ctx) error {
+	var id = ctx.Params("id")
+	if err := c.oidcService.DeleteClient(id); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err := common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error delete client")
+	}
+
+	return nil
+}
+
+// bearerToken токен из заголовка "Authorization: Bearer ..."
+func bearerToken(ctx *fiber.Ctx) string {
+	var header = ctx.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// basicAuth учётные данные клиента из заголовка "Authorization: Basic ..."; по RFC 6749 они url-кодированы
+func basicAuth(ctx *fiber.Ctx) (id string, secret string, ok bool) {
+	var header = ctx.Get(fiber.HeaderAuthorization)
+	if len(header) <= 6 || !strings.EqualFold(header[:6], "basic ") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[6:])
+	if err != nil {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+	if id, err = url.QueryUnescape(id); err != nil {
+		return "", "", false
+	}
+	if secret, err = url.QueryUnescape(secret); err != nil {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// oauthError ответ в формате RFC 6749; ошибка проверки токена userinfo — 401 с WWW-Authenticate по RFC 6750
+func oauthError(ctx *fiber.Ctx, err error) error {
+	var protocolErr Error
+	if !errors.As(err, &protocolErr) {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: "server_error"})
+	}
+	var status = fiber.StatusBadRequest
+	switch protocolErr.Code {
+	case ErrInvalidClient:
+		status = fiber.StatusUnauthorized
+		ctx.Set(fiber.HeaderWWWAuthenticate, `Basic realm="idm"`)
+	case ErrInvalidToken:
+		status = fiber.StatusUnauthorized
+		ctx.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+	}
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.Status(status).JSON(ErrorResponse{Error: protocolErr.Code, ErrorDescription: protocolErr.Description})
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
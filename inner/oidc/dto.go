@@ -0,0 +1,97 @@
+package oidc
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// ClientEntity приложение, зарегистрированное для входа через OpenID Connect
+type ClientEntity struct {
+	Id   string `db:"id"`
+	Name string `db:"name"`
+	// SecretHash SHA-256 секрета; пусто у публичных клиентов, например SPA и мобильных приложений
+	SecretHash   sql.NullString `db:"secret_hash"`
+	RedirectUris pq.StringArray `db:"redirect_uris"`
+	CreatedAt    time.Time      `db:"created_at"`
+}
+
+// CodeEntity код авторизации, который клиент обменивает на токены
+type CodeEntity struct {
+	CodeHash      string         `db:"code_hash"`
+	ClientId      string         `db:"client_id"`
+	EmployeeId    int64          `db:"employee_id"`
+	RedirectUri   string         `db:"redirect_uri"`
+	Scope         string         `db:"scope"`
+	Nonce         string         `db:"nonce"`
+	CodeChallenge string         `db:"code_challenge"`
+	Roles         pq.StringArray `db:"roles"`
+	Amr           pq.StringArray `db:"amr"`
+	AuthTime      time.Time      `db:"auth_time"`
+	ExpiresAt     time.Time      `db:"expires_at"`
+	UsedAt        sql.NullTime   `db:"used_at"`
+	CreatedAt     time.Time      `db:"created_at"`
+}
+
+// EmployeeEntity атрибуты сотрудника, которые попадают в ID-токен и userinfo
+type EmployeeEntity struct {
+	Id             int64          `db:"id"`
+	Name           string         `db:"name"`
+	ExternalId     sql.NullString `db:"external_id"`
+	Department     string         `db:"department"`
+	Title          string         `db:"title"`
+	EmploymentType string         `db:"employment_type"`
+	Location       string         `db:"location"`
+}
+
+type ClientResponse struct {
+	ClientId     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectUris []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	// ClientSecret показывается один раз при регистрации конфиденциального клиента
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// Discovery документ /.well-known/openid-configuration по OpenID Connect Discovery 1.0
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// TokenResponse ответ token endpoint по RFC 6749 и OpenID Connect Core
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IdToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// ErrorResponse ошибка в формате RFC 6749
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func (e *ClientEntity) toResponse() ClientResponse {
+	return ClientResponse{
+		ClientId:     e.Id,
+		Name:         e.Name,
+		RedirectUris: e.RedirectUris,
+		Confidential: e.SecretHash.Valid,
+		CreatedAt:    e.CreatedAt,
+	}
+}
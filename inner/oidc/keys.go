@@ -0,0 +1,70 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+)
+
+// Keys ключи подписи токенов провайдера, например signingkey.Service, который и публикует их в JWKS
+type Keys interface {
+	// Sign подписывает токен текущим ключом и указывает его kid в заголовке
+	Sign(token *jwt.Token) (string, error)
+	// Key возвращает открытый ключ для проверки токена по kid из заголовка
+	Key(token *jwt.Token) (any, error)
+	// Algorithms алгоритмы подписи опубликованных ключей
+	Algorithms() []string
+}
+
+// Jwk открытый ключ RSA или EC в формате RFC 7517
+type Jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+// NewJwk открытый ключ RSA или ECDSA P-256 для подписи алгоритмом alg.
+// kid — отпечаток ключа по RFC 7638, поэтому не меняется между перезапусками
+func NewJwk(public crypto.PublicKey, alg string) (Jwk, error) {
+	var jwk = Jwk{Use: "sig", Alg: alg}
+	var thumbprint string
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		thumbprint = `{"e":"` + jwk.E + `","kty":"RSA","n":"` + jwk.N + `"}`
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return Jwk{}, errors.New("only P-256 curve is supported")
+		}
+		var point = make([]byte, 32)
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(point))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(point))
+		thumbprint = `{"crv":"P-256","kty":"EC","x":"` + jwk.X + `","y":"` + jwk.Y + `"}`
+	default:
+		return Jwk{}, fmt.Errorf("unsupported key type %T", public)
+	}
+	var sum = sha256.Sum256([]byte(thumbprint))
+	jwk.Kid = base64.RawURLEncoding.EncodeToString(sum[:])
+	return jwk, nil
+}
@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+func (r *Repository) CreateClient(e *ClientEntity) error {
+	query := `INSERT INTO oidc_client (id, name, secret_hash, redirect_uris)
+              VALUES ($1, $2, $3, $4)
+              RETURNING *`
+	return r.db.Get(e, query, e.Id, e.Name, e.SecretHash, e.RedirectUris)
+}
+
+// FindClient возвращает клиента; sql.ErrNoRows, если его нет
+func (r *Repository) FindClient(id string) (client ClientEntity, err error) {
+	err = r.db.Get(&client, "SELECT * FROM oidc_client WHERE id = $1", id)
+	return
+}
+
+func (r *Repository) FindClients() (clients []ClientEntity, err error) {
+	err = r.db.Select(&clients, "SELECT * FROM oidc_client ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// DeleteClient удаляет клиента вместе с невыкупленными кодами; возвращает false, если клиента нет
+func (r *Repository) DeleteClient(id string) (bool, error) {
+	result, err := r.db.Exec("DELETE FROM oidc_client WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// FindEmployee возвращает атрибуты сотрудника; sql.ErrNoRows, если его нет
+func (r *Repository) FindEmployee(id int64) (employee EmployeeEntity, err error) {
+	query := `SELECT id, name, external_id, department, title, employment_type, location
+              FROM employee WHERE id = $1`
+	err = r.db.Get(&employee, query, id)
+	return
+}
+
+func (r *Repository) CreateCode(e *CodeEntity) error {
+	query := `INSERT INTO oidc_authorization_code (
+                  code_hash, client_id, employee_id, redirect_uri, scope, nonce,
+                  code_challenge, roles, amr, auth_time, expires_at
+              )
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+              RETURNING *`
+	return r.db.Get(e, query, e.CodeHash, e.ClientId, e.EmployeeId, e.RedirectUri, e.Scope, e.Nonce,
+		e.CodeChallenge, e.Roles, e.Amr, e.AuthTime, e.ExpiresAt)
+}
+
+// UseCode гасит код и возвращает его; sql.ErrNoRows, если кода нет или он уже использован.
+// Гашение одним запросом не даёт обменять код дважды параллельными запросами
+func (r *Repository) UseCode(hash string, at time.Time) (code CodeEntity, err error) {
+	query := `UPDATE oidc_authorization_code SET used_at = $2
+              WHERE code_hash = $1 AND used_at IS NULL
+              RETURNING *`
+	err = r.db.Get(&code, query, hash, at)
+	return
+}
@@ -0,0 +1,510 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Области доступа, которые понимает провайдер
+const (
+	ScopeOpenid  = "openid"
+	ScopeProfile = "profile"
+	ScopeRoles   = "roles"
+)
+
+// codeTTL сколько действует код авторизации; клиент обменивает его сразу после редиректа
+const codeTTL = time.Minute
+
+// accessTokenType тип access-токена в заголовке typ по RFC 9068, чтобы ID-токен нельзя было выдать за access-токен
+const accessTokenType = "at+jwt"
+
+// Коды ошибок OAuth 2.0 из RFC 6749 и OpenID Connect Core
+const (
+	ErrInvalidRequest          = "invalid_request"
+	ErrInvalidClient           = "invalid_client"
+	ErrInvalidGrant            = "invalid_grant"
+	ErrInvalidScope            = "invalid_scope"
+	ErrInvalidToken            = "invalid_token"
+	ErrUnsupportedResponseType = "unsupported_response_type"
+	ErrUnsupportedGrantType    = "unsupported_grant_type"
+	ErrLoginRequired           = "login_required"
+)
+
+// Error ошибка протокола; Code передаётся клиенту в поле error
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (err Error) Error() string {
+	return err.Code + ": " + err.Description
+}
+
+// Структура сервиса провайдера OpenID Connect
+type Service struct {
+	repo      Repo
+	validator Validator
+	sessions  Sessions
+	keys      Keys
+	settings  Settings
+	now       func() time.Time
+}
+
+// Settings адрес провайдера и срок жизни выдаваемых токенов
+type Settings struct {
+	// Issuer внешний адрес IdM без завершающего слеша; из него строятся адреса всех эндпоинтов
+	Issuer   string
+	TokenTTL time.Duration
+}
+
+// AuthorizeRequest параметры authorization endpoint
+type AuthorizeRequest struct {
+	ResponseType        string `query:"response_type"`
+	ClientId            string `query:"client_id"`
+	RedirectUri         string `query:"redirect_uri"`
+	Scope               string `query:"scope"`
+	State               string `query:"state"`
+	Nonce               string `query:"nonce"`
+	CodeChallenge       string `query:"code_challenge"`
+	CodeChallengeMethod string `query:"code_challenge_method"`
+}
+
+// TokenRequest параметры token endpoint; секрет клиента приходит в теле или в заголовке Authorization
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectUri  string `form:"redirect_uri"`
+	ClientId     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	CodeVerifier string `form:"code_verifier"`
+}
+
+// ClientRequest регистрация приложения
+type ClientRequest struct {
+	Name         string   `json:"name" validate:"required,max=155"`
+	RedirectUris []string `json:"redirect_uris" validate:"required,min=1,max=10,dive,url,max=2048"`
+	// Confidential клиент с серверной частью получает секрет; публичный входит только по PKCE
+	Confidential bool `json:"confidential"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+// Sessions проверяет сессию IdM, в которой сотрудник входит в приложение, например auth.Service
+type Sessions interface {
+	Verify(accessToken string) (auth.Claims, error)
+}
+
+type Repo interface {
+	CreateClient(*ClientEntity) error
+	FindClient(string) (ClientEntity, error)
+	FindClients() ([]ClientEntity, error)
+	DeleteClient(string) (bool, error)
+	FindEmployee(int64) (EmployeeEntity, error)
+	CreateCode(*CodeEntity) error
+	UseCode(string, time.Time) (CodeEntity, error)
+}
+
+func NewService(repo Repo, validator Validator, sessions Sessions, keys Keys, settings Settings) *Service {
+	return &Service{
+		repo:      repo,
+		validator: validator,
+		sessions:  sessions,
+		keys:      keys,
+		settings:  settings,
+		now:       time.Now,
+	}
+}
+
+// Discovery документ с адресами эндпоинтов и возможностями провайдера
+func (srv *Service) Discovery() Discovery {
+	var issuer = srv.settings.Issuer
+	return Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth2/authorize",
+		TokenEndpoint:                     issuer + "/oauth2/token",
+		UserinfoEndpoint:                  issuer + "/oauth2/userinfo",
+		JwksUri:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  srv.keys.Algorithms(),
+		ScopesSupported:                   []string{ScopeOpenid, ScopeProfile, ScopeRoles},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr", "name", "preferred_username",
+			"employee_number", "department", "title", "employment_type", "location", "roles",
+		},
+	}
+}
+
+// Authorize выдаёт код авторизации сотруднику, вошедшему в IdM, и возвращает адрес редиректа обратно в приложение.
+// Ошибка возвращается, только если клиент или redirect_uri неверны и перенаправлять некуда;
+// остальные ошибки передаются приложению в параметрах редиректа
+func (srv *Service) Authorize(request AuthorizeRequest, accessToken string) (string, error) {
+	client, err := srv.findClient(request.ClientId)
+	if err != nil {
+		var protocolErr Error
+		if errors.As(err, &protocolErr) {
+			protocolErr.Code = ErrInvalidRequest
+			return "", protocolErr
+		}
+		return "", err
+	}
+	if !slices.Contains(client.RedirectUris, request.RedirectUri) {
+		return "", Error{Code: ErrInvalidRequest, Description: "redirect_uri is not registered for the client"}
+	}
+
+	var fail = func(code string, description string) (string, error) {
+		return redirectUri(request.RedirectUri, map[string]string{
+			"error":             code,
+			"error_description": description,
+			"state":             request.State,
+		}), nil
+	}
+	if request.ResponseType != "code" {
+		return fail(ErrUnsupportedResponseType, "only response_type=code is supported")
+	}
+	var scopes = strings.Fields(request.Scope)
+	if !slices.Contains(scopes, ScopeOpenid) {
+		return fail(ErrInvalidScope, "scope must include openid")
+	}
+	if request.CodeChallenge == "" || request.CodeChallengeMethod != "S256" {
+		return fail(ErrInvalidRequest, "code_challenge with code_challenge_method=S256 is required")
+	}
+	session, err := srv.sessions.Verify(accessToken)
+	if errors.As(err, &common.UnauthorizedError{}) {
+		return fail(ErrLoginRequired, "sign in to IdM first")
+	}
+	if err != nil {
+		return "", fmt.Errorf("error verifying session: %w", err)
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("error generating authorization code: %w", err)
+	}
+	var now = srv.now()
+	var entity = CodeEntity{
+		CodeHash:      hashSecret(code),
+		ClientId:      client.Id,
+		EmployeeId:    session.EmployeeId,
+		RedirectUri:   request.RedirectUri,
+		Scope:         strings.Join(supportedScopes(scopes), " "),
+		Nonce:         request.Nonce,
+		CodeChallenge: request.CodeChallenge,
+		Roles:         session.Roles,
+		Amr:           session.Amr,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(codeTTL),
+	}
+	if session.IssuedAt != nil {
+		entity.AuthTime = session.IssuedAt.Time
+	}
+	if entity.Roles == nil {
+		entity.Roles = []string{}
+	}
+	if entity.Amr == nil {
+		entity.Amr = []string{}
+	}
+	if err = srv.repo.CreateCode(&entity); err != nil {
+		return "", fmt.Errorf("error saving authorization code of employee %d: %w", session.EmployeeId, err)
+	}
+	return redirectUri(request.RedirectUri, map[string]string{"code": code, "state": request.State}), nil
+}
+
+// Token обменивает код авторизации на ID-токен и access-токен. Код одноразовый и проверяется по PKCE
+func (srv *Service) Token(request TokenRequest) (TokenResponse, error) {
+	if request.GrantType != "authorization_code" {
+		return TokenResponse{}, Error{Code: ErrUnsupportedGrantType, Description: "only authorization_code grant is supported"}
+	}
+	client, err := srv.findClient(request.ClientId)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if client.SecretHash.Valid &&
+		subtle.ConstantTimeCompare([]byte(hashSecret(request.ClientSecret)), []byte(client.SecretHash.String)) != 1 {
+		return TokenResponse{}, Error{Code: ErrInvalidClient, Description: "client authentication failed"}
+	}
+
+	var now = srv.now()
+	// код гасится до проверок, поэтому неудачная попытка тоже делает его недействительным
+	code, err := srv.repo.UseCode(hashSecret(request.Code), now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TokenResponse{}, Error{Code: ErrInvalidGrant, Description: "authorization code is invalid or already used"}
+	}
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("error using authorization code: %w", err)
+	}
+	switch {
+	case code.ClientId != client.Id:
+		return TokenResponse{}, Error{Code: ErrInvalidGrant, Description: "authorization code was issued to another client"}
+	case code.RedirectUri != request.RedirectUri:
+		return TokenResponse{}, Error{Code: ErrInvalidGrant, Description: "redirect_uri does not match the authorization request"}
+	case !now.Before(code.ExpiresAt):
+		return TokenResponse{}, Error{Code: ErrInvalidGrant, Description: "authorization code has expired"}
+	case !verifyChallenge(code.CodeChallenge, request.CodeVerifier):
+		return TokenResponse{}, Error{Code: ErrInvalidGrant, Description: "code_verifier does not match code_challenge"}
+	}
+	employee, err := srv.repo.FindEmployee(code.EmployeeId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TokenResponse{}, Error{Code: ErrInvalidGrant, Description: "employee no longer exists"}
+	}
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("error finding employee with id %d: %w", code.EmployeeId, err)
+	}
+
+	var scopes = strings.Fields(code.Scope)
+	var subject = strconv.FormatInt(employee.Id, 10)
+	var expiresAt = now.Add(srv.settings.TokenTTL)
+	var idClaims = jwt.MapClaims{
+		"iss":       srv.settings.Issuer,
+		"sub":       subject,
+		"aud":       client.Id,
+		"azp":       client.Id,
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+		"auth_time": code.AuthTime.Unix(),
+		"amr":       []string(code.Amr),
+	}
+	if code.Nonce != "" {
+		idClaims["nonce"] = code.Nonce
+	}
+	for name, value := range userClaims(employee, scopes, code.Roles) {
+		idClaims[name] = value
+	}
+	// алгоритм подписи выбирает Keys по текущему ключу
+	idToken, err := srv.keys.Sign(jwt.NewWithClaims(jwt.SigningMethodRS256, idClaims))
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("error signing id token: %w", err)
+	}
+
+	var token = jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":       srv.settings.Issuer,
+		"sub":       subject,
+		"aud":       client.Id,
+		"client_id": client.Id,
+		"scope":     code.Scope,
+		"roles":     []string(code.Roles),
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+	})
+	token.Header["typ"] = accessTokenType
+	accessToken, err := srv.keys.Sign(token)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("error signing access token: %w", err)
+	}
+	return TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(srv.settings.TokenTTL / time.Second),
+		IdToken:     idToken,
+		Scope:       code.Scope,
+	}, nil
+}
+
+// Userinfo возвращает claims сотрудника по access-токену, выданному Token; атрибуты читаются заново из базы
+func (srv *Service) Userinfo(accessToken string) (map[string]any, error) {
+	var claims = jwt.MapClaims{}
+	var parser = jwt.NewParser(
+		jwt.WithValidMethods(srv.keys.Algorithms()),
+		jwt.WithIssuer(srv.settings.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(srv.now),
+	)
+	_, err := parser.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (any, error) {
+		if typ, _ := token.Header["typ"].(string); typ != accessTokenType {
+			return nil, errors.New("not an access token")
+		}
+		return srv.keys.Key(token)
+	})
+	if err != nil {
+		return nil, Error{Code: ErrInvalidToken, Description: err.Error()}
+	}
+	subject, _ := claims.GetSubject()
+	employeeId, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		return nil, Error{Code: ErrInvalidToken, Description: "invalid subject"}
+	}
+	employee, err := srv.repo.FindEmployee(employeeId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, Error{Code: ErrInvalidToken, Description: "employee no longer exists"}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding employee with id %d: %w", employeeId, err)
+	}
+	var roles []string
+	if list, ok := claims["roles"].([]any); ok {
+		for _, role := range list {
+			if name, ok := role.(string); ok {
+				roles = append(roles, name)
+			}
+		}
+	}
+	scope, _ := claims["scope"].(string)
+	var info = userClaims(employee, strings.Fields(scope), roles)
+	info["sub"] = subject
+	return info, nil
+}
+
+// CreateClient регистрирует приложение. Секрет конфиденциального клиента возвращается один раз
+func (srv *Service) CreateClient(request ClientRequest) (ClientResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return ClientResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	for _, uri := range request.RedirectUris {
+		if parsed, err := url.Parse(uri); err != nil || parsed.Fragment != "" || parsed.Host == "" {
+			return ClientResponse{}, common.RequestValidationError{Message: fmt.Sprintf("invalid redirect uri %q", uri)}
+		}
+	}
+	id, err := randomToken(16)
+	if err != nil {
+		return ClientResponse{}, fmt.Errorf("error generating client id: %w", err)
+	}
+	var client = ClientEntity{Id: id, Name: request.Name, RedirectUris: request.RedirectUris}
+	var secret string
+	if request.Confidential {
+		if secret, err = randomToken(32); err != nil {
+			return ClientResponse{}, fmt.Errorf("error generating client secret: %w", err)
+		}
+		client.SecretHash = sql.NullString{String: hashSecret(secret), Valid: true}
+	}
+	if err = srv.repo.CreateClient(&client); err != nil {
+		return ClientResponse{}, fmt.Errorf("error creating client %q: %w", request.Name, err)
+	}
+	var resp = client.toResponse()
+	resp.ClientSecret = secret
+	return resp, nil
+}
+
+func (srv *Service) FindClients() ([]ClientResponse, error) {
+	clients, err := srv.repo.FindClients()
+	if err != nil {
+		return nil, fmt.Errorf("error finding clients: %w", err)
+	}
+	var resp = make([]ClientResponse, 0, len(clients))
+	for _, client := range clients {
+		resp = append(resp, client.toResponse())
+	}
+	return resp, nil
+}
+
+// DeleteClient удаляет приложение; уже выданные ему токены действуют до истечения срока
+func (srv *Service) DeleteClient(id string) error {
+	deleted, err := srv.repo.DeleteClient(id)
+	if err != nil {
+		return fmt.Errorf("error deleting client %s: %w", id, err)
+	}
+	if !deleted {
+		return common.NotFoundError{Message: fmt.Sprintf("client %s not found", id)}
+	}
+	return nil
+}
+
+func (srv *Service) findClient(id string) (ClientEntity, error) {
+	client, err := srv.repo.FindClient(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ClientEntity{}, Error{Code: ErrInvalidClient, Description: "unknown client"}
+	}
+	if err != nil {
+		return ClientEntity{}, fmt.Errorf("error finding client %s: %w", id, err)
+	}
+	return client, nil
+}
+
+// userClaims атрибуты сотрудника по запрошенным областям доступа: profile — атрибуты из HR, roles — роли
+func userClaims(employee EmployeeEntity, scopes []string, roles []string) map[string]any {
+	var claims = map[string]any{}
+	if slices.Contains(scopes, ScopeProfile) {
+		claims["name"] = employee.Name
+		claims["preferred_username"] = employee.Name
+		var attributes = map[string]string{
+			"department":      employee.Department,
+			"title":           employee.Title,
+			"employment_type": employee.EmploymentType,
+			"location":        employee.Location,
+			"employee_number": employee.ExternalId.String,
+		}
+		for name, value := range attributes {
+			if value != "" {
+				claims[name] = value
+			}
+		}
+	}
+	if slices.Contains(scopes, ScopeRoles) {
+		if roles == nil {
+			roles = []string{}
+		}
+		claims["roles"] = roles
+	}
+	return claims
+}
+
+// supportedScopes оставляет известные провайдеру области доступа без повторов
+func supportedScopes(scopes []string) []string {
+	var supported []string
+	for _, scope := range []string{ScopeOpenid, ScopeProfile, ScopeRoles} {
+		if slices.Contains(scopes, scope) {
+			supported = append(supported, scope)
+		}
+	}
+	return supported
+}
+
+// verifyChallenge проверка PKCE S256 по RFC 7636: BASE64URL(SHA256(code_verifier)) == code_challenge
+func verifyChallenge(challenge string, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	var sum = sha256.Sum256([]byte(verifier))
+	var expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// redirectUri добавляет параметры к адресу возврата; пустые параметры пропускаются
+func redirectUri(uri string, params map[string]string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	var query = parsed.Query()
+	for name, value := range params {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// randomToken случайная строка из size байт в base64url
+func randomToken(size int) (string, error) {
+	var buf = make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashSecret SHA-256 кода или секрета клиента; у случайных значений достаточно энтропии, медленный хеш не нужен
+func hashSecret(secret string) string {
+	var sum = sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,405 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/validator"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+// MemoryRepo хранит клиентов и коды в памяти, чтобы весь поток проходил без базы
+type MemoryRepo struct {
+	clients   map[string]ClientEntity
+	codes     map[string]CodeEntity
+	employees map[int64]EmployeeEntity
+}
+
+func NewMemoryRepo(employees ...EmployeeEntity) *MemoryRepo {
+	var repo = &MemoryRepo{
+		clients:   map[string]ClientEntity{},
+		codes:     map[string]CodeEntity{},
+		employees: map[int64]EmployeeEntity{},
+	}
+	for _, employee := range employees {
+		repo.employees[employee.Id] = employee
+	}
+	return repo
+}
+
+func (r *MemoryRepo) CreateClient(e *ClientEntity) error {
+	e.CreatedAt = time.Now()
+	r.clients[e.Id] = *e
+	return nil
+}
+
+func (r *MemoryRepo) FindClient(id string) (ClientEntity, error) {
+	client, ok := r.clients[id]
+	if !ok {
+		return ClientEntity{}, sql.ErrNoRows
+	}
+	return client, nil
+}
+
+func (r *MemoryRepo) FindClients() ([]ClientEntity, error) {
+	var clients []ClientEntity
+	for _, client := range r.clients {
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+func (r *MemoryRepo) DeleteClient(id string) (bool, error) {
+	_, ok := r.clients[id]
+	delete(r.clients, id)
+	return ok, nil
+}
+
+func (r *MemoryRepo) FindEmployee(id int64) (EmployeeEntity, error) {
+	employee, ok := r.employees[id]
+	if !ok {
+		return EmployeeEntity{}, sql.ErrNoRows
+	}
+	return employee, nil
+}
+
+func (r *MemoryRepo) CreateCode(e *CodeEntity) error {
+	r.codes[e.CodeHash] = *e
+	return nil
+}
+
+func (r *MemoryRepo) UseCode(hash string, at time.Time) (CodeEntity, error) {
+	code, ok := r.codes[hash]
+	if !ok || code.UsedAt.Valid {
+		return CodeEntity{}, sql.ErrNoRows
+	}
+	code.UsedAt = sql.NullTime{Time: at, Valid: true}
+	r.codes[hash] = code
+	return code, nil
+}
+
+// StubSessions принимает только токен "session" сотрудника 1
+type StubSessions struct{}
+
+func (StubSessions) Verify(accessToken string) (auth.Claims, error) {
+	if accessToken != "session" {
+		return auth.Claims{}, common.UnauthorizedError{Message: "invalid token"}
+	}
+	return auth.Claims{
+		EmployeeId:       1,
+		Roles:            []string{"admin", "user"},
+		Amr:              []string{auth.AmrPassword, auth.AmrOtp},
+		RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))},
+	}, nil
+}
+
+// StubKeys подписывает токены RS256 одним ключом с kid "test"
+type StubKeys struct {
+	key *rsa.PrivateKey
+}
+
+func (k StubKeys) Sign(token *jwt.Token) (string, error) {
+	token.Header["kid"] = "test"
+	return token.SignedString(k.key)
+}
+
+func (k StubKeys) Key(token *jwt.Token) (any, error) {
+	if token.Header["kid"] != "test" {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+	return &k.key.PublicKey, nil
+}
+
+func (k StubKeys) Algorithms() []string {
+	return []string{jwt.SigningMethodRS256.Alg()}
+}
+
+const (
+	testIssuer   = "https://idm.example.com"
+	testRedirect = "https://wiki.example.com/callback"
+	testVerifier = "dBjftJeZ4CVP-mJ92K9qP9LgLqJ2fNxP6YhD8pQ7y3sWq1Xz"
+)
+
+func newTestApp(t *testing.T) (*fiber.App, *Service) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repo = NewMemoryRepo(EmployeeEntity{
+		Id:         1,
+		Name:       "John Doe",
+		ExternalId: sql.NullString{String: "E-1", Valid: true},
+		Department: "IT",
+		Title:      "Engineer",
+	})
+	var svc = NewService(repo, validator.New(), StubSessions{}, StubKeys{key: key},
+		Settings{Issuer: testIssuer, TokenTTL: 15 * time.Minute})
+	var server = web.NewServer()
+	NewController(server, svc).RegisterRoutes()
+	return server.App, svc
+}
+
+func challenge(verifier string) string {
+	var sum = sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func decode[T any](t *testing.T, resp *http.Response) T {
+	var value T
+	if err := json.NewDecoder(resp.Body).Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func registerClient(t *testing.T, app *fiber.App, confidential bool) ClientResponse {
+	var body = `{"name":"Wiki","redirect_uris":["` + testRedirect + `"],"confidential":` +
+		map[bool]string{true: "true", false: "false"}[confidential] + `}`
+	var req = httptest.NewRequest(fiber.MethodPost, "/api/v1/oidc/clients", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("register client: %v %v", err, resp.StatusCode)
+	}
+	return decode[common.Response[ClientResponse]](t, resp).Data
+}
+
+func authorize(t *testing.T, app *fiber.App, params url.Values, session string) *http.Response {
+	var req = httptest.NewRequest(fiber.MethodGet, "/oauth2/authorize?"+params.Encode(), nil)
+	if session != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+session)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func authorizeParams(clientId string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientId},
+		"redirect_uri":          {testRedirect},
+		"scope":                 {"openid profile roles"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {challenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func exchange(t *testing.T, app *fiber.App, client ClientResponse, code string, verifier string) *http.Response {
+	var form = url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirect},
+		"code_verifier": {verifier},
+	}
+	var req = httptest.NewRequest(fiber.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.SetBasicAuth(url.QueryEscape(client.ClientId), url.QueryEscape(client.ClientSecret))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func issueCode(t *testing.T, app *fiber.App, clientId string) string {
+	var resp = authorize(t, app, authorizeParams(clientId), "session")
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	var a = assert.New(t)
+	app, svc := newTestApp(t)
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/.well-known/openid-configuration", nil))
+	a.Nil(err)
+	var discovery = decode[Discovery](t, resp)
+	a.Equal(testIssuer, discovery.Issuer)
+	a.Equal(testIssuer+"/oauth2/token", discovery.TokenEndpoint)
+	a.Equal(testIssuer+"/.well-known/jwks.json", discovery.JwksUri)
+	a.Equal([]string{"S256"}, discovery.CodeChallengeMethodsSupported)
+
+	var client = registerClient(t, app, true)
+	a.NotEmpty(client.ClientSecret)
+	a.True(client.Confidential)
+
+	resp = authorize(t, app, authorizeParams(client.ClientId), "session")
+	a.Equal(fiber.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	a.Nil(err)
+	a.Equal("wiki.example.com", location.Host)
+	a.Equal("xyz", location.Query().Get("state"))
+	var code = location.Query().Get("code")
+	a.NotEmpty(code)
+
+	resp = exchange(t, app, client, code, testVerifier)
+	a.Equal(fiber.StatusOK, resp.StatusCode)
+	a.Equal("no-store", resp.Header.Get(fiber.HeaderCacheControl))
+	var tokens = decode[TokenResponse](t, resp)
+	a.Equal("Bearer", tokens.TokenType)
+	a.Equal("openid profile roles", tokens.Scope)
+
+	var claims = jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IdToken, claims, svc.keys.Key,
+		jwt.WithIssuer(testIssuer), jwt.WithAudience(client.ClientId))
+	a.Nil(err)
+	a.Equal("1", claims["sub"])
+	a.Equal("n-0S6", claims["nonce"])
+	a.Equal("John Doe", claims["name"])
+	a.Equal("IT", claims["department"])
+	a.Equal("E-1", claims["employee_number"])
+	a.Equal([]any{"admin", "user"}, claims["roles"])
+	a.Equal([]any{"pwd", "otp"}, claims["amr"])
+
+	var req = httptest.NewRequest(fiber.MethodGet, "/oauth2/userinfo", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tokens.AccessToken)
+	resp, err = app.Test(req)
+	a.Nil(err)
+	a.Equal(fiber.StatusOK, resp.StatusCode)
+	var info = decode[map[string]any](t, resp)
+	a.Equal("1", info["sub"])
+	a.Equal("Engineer", info["title"])
+	a.Equal([]any{"admin", "user"}, info["roles"])
+
+	t.Run("id token is not accepted by userinfo", func(t *testing.T) {
+		var req = httptest.NewRequest(fiber.MethodGet, "/oauth2/userinfo", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tokens.IdToken)
+		resp, err := app.Test(req)
+		a.Nil(err)
+		a.Equal(fiber.StatusUnauthorized, resp.StatusCode)
+		a.Contains(resp.Header.Get(fiber.HeaderWWWAuthenticate), "invalid_token")
+	})
+
+	t.Run("code can be exchanged only once", func(t *testing.T) {
+		var resp = exchange(t, app, client, code, testVerifier)
+		a.Equal(fiber.StatusBadRequest, resp.StatusCode)
+		a.Equal(ErrInvalidGrant, decode[ErrorResponse](t, resp).Error)
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	var a = assert.New(t)
+	app, _ := newTestApp(t)
+	var client = registerClient(t, app, false)
+	a.Empty(client.ClientSecret)
+
+	t.Run("redirects with login_required without session", func(t *testing.T) {
+		var resp = authorize(t, app, authorizeParams(client.ClientId), "")
+		a.Equal(fiber.StatusFound, resp.StatusCode)
+		location, _ := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+		a.Equal(ErrLoginRequired, location.Query().Get("error"))
+		a.Equal("xyz", location.Query().Get("state"))
+	})
+
+	t.Run("requires pkce", func(t *testing.T) {
+		var params = authorizeParams(client.ClientId)
+		params.Set("code_challenge_method", "plain")
+		var resp = authorize(t, app, params, "session")
+		location, _ := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+		a.Equal(ErrInvalidRequest, location.Query().Get("error"))
+		a.Empty(location.Query().Get("code"))
+	})
+
+	t.Run("requires openid scope", func(t *testing.T) {
+		var params = authorizeParams(client.ClientId)
+		params.Set("scope", "profile")
+		var resp = authorize(t, app, params, "session")
+		location, _ := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+		a.Equal(ErrInvalidScope, location.Query().Get("error"))
+	})
+
+	t.Run("does not redirect to unregistered uri", func(t *testing.T) {
+		var params = authorizeParams(client.ClientId)
+		params.Set("redirect_uri", "https://evil.example.com/callback")
+		var resp = authorize(t, app, params, "session")
+		a.Equal(fiber.StatusBadRequest, resp.StatusCode)
+		a.Empty(resp.Header.Get(fiber.HeaderLocation))
+	})
+
+	t.Run("does not redirect for unknown client", func(t *testing.T) {
+		var resp = authorize(t, app, authorizeParams("missing"), "session")
+		a.Equal(fiber.StatusBadRequest, resp.StatusCode)
+		a.Equal(ErrInvalidRequest, decode[ErrorResponse](t, resp).Error)
+	})
+}
+
+func TestToken(t *testing.T) {
+	var a = assert.New(t)
+	app, svc := newTestApp(t)
+	var client = registerClient(t, app, true)
+
+	t.Run("rejects wrong code verifier", func(t *testing.T) {
+		var code = issueCode(t, app, client.ClientId)
+		var resp = exchange(t, app, client, code, strings.Repeat("x", 43))
+		a.Equal(fiber.StatusBadRequest, resp.StatusCode)
+		a.Equal(ErrInvalidGrant, decode[ErrorResponse](t, resp).Error)
+
+		// неудачная попытка гасит код
+		resp = exchange(t, app, client, code, testVerifier)
+		a.Equal(ErrInvalidGrant, decode[ErrorResponse](t, resp).Error)
+	})
+
+	t.Run("rejects wrong client secret", func(t *testing.T) {
+		var code = issueCode(t, app, client.ClientId)
+		var wrong = client
+		wrong.ClientSecret = "wrong"
+		var resp = exchange(t, app, wrong, code, testVerifier)
+		a.Equal(fiber.StatusUnauthorized, resp.StatusCode)
+		a.Equal(ErrInvalidClient, decode[ErrorResponse](t, resp).Error)
+	})
+
+	t.Run("rejects expired code", func(t *testing.T) {
+		var code = issueCode(t, app, client.ClientId)
+		svc.now = func() time.Time { return time.Now().Add(2 * codeTTL) }
+		defer func() { svc.now = time.Now }()
+		var resp = exchange(t, app, client, code, testVerifier)
+		a.Equal(ErrInvalidGrant, decode[ErrorResponse](t, resp).Error)
+	})
+
+	t.Run("rejects unsupported grant type", func(t *testing.T) {
+		_, err := svc.Token(TokenRequest{GrantType: "password", ClientId: client.ClientId})
+		a.Equal(Error{Code: ErrUnsupportedGrantType, Description: "only authorization_code grant is supported"}, err)
+	})
+}
+
+func TestCreateClient(t *testing.T) {
+	var a = assert.New(t)
+	var svc = NewService(NewMemoryRepo(), validator.New(), StubSessions{}, nil, Settings{Issuer: testIssuer})
+
+	_, err := svc.CreateClient(ClientRequest{Name: "Wiki"})
+	a.ErrorAs(err, &common.RequestValidationError{})
+	_, err = svc.CreateClient(ClientRequest{Name: "Wiki", RedirectUris: []string{"https://wiki.example.com/#callback"}})
+	a.ErrorAs(err, &common.RequestValidationError{})
+
+	client, err := svc.CreateClient(ClientRequest{Name: "Wiki", RedirectUris: []string{testRedirect}})
+	a.Nil(err)
+	a.False(client.Confidential)
+	a.Nil(svc.DeleteClient(client.ClientId))
+	a.ErrorAs(svc.DeleteClient(client.ClientId), &common.NotFoundError{})
+}
@@ -0,0 +1,217 @@
+package orgunit
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+)
+
+type Controller struct {
+	server         *web.Server
+	orgUnitService Svc
+}
+
+// интерфейс сервиса orgunit.Service
+type Svc interface {
+	Create(request Request) (int64, error)
+	FindTree() ([]TreeResponse, error)
+	FindById(id int64) (Response, error)
+	Update(id int64, request Request) (Response, error)
+	Delete(id int64) (int64, error)
+	FindMembers(id int64, recursive bool) ([]MemberResponse, error)
+	AddMember(id int64, employeeId int64) error
+	RemoveMember(id int64, employeeId int64) error
+}
+
+func NewController(server *web.Server, orgUnitService Svc) *Controller {
+	return &Controller{
+		server:         server,
+		orgUnitService: orgUnitService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/org-units", c.Create)
+	c.server.GroupApiV1.Get("/org-units", c.FindTree)
+	c.server.GroupApiV1.Get("/org-units/:id", c.FindById)
+	c.server.GroupApiV1.Put("/org-units/:id", c.Update)
+	c.server.GroupApiV1.Delete("/org-units/:id", c.Delete)
+	c.server.GroupApiV1.Get("/org-units/:id/members", c.FindMembers)
+	c.server.GroupApiV1.Put("/org-units/:id/members/:employeeId", c.AddMember)
+	c.server.GroupApiV1.Delete("/org-units/:id/members/:employeeId", c.RemoveMember)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/org-units"
+func (c *Controller) Create(ctx *fiber.Ctx) error {
+	var request Request
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	var id, err = c.orgUnitService.Create(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning created org unit id")
+	}
+
+	return nil
+}
+
+// FindTree возвращает подразделения верхнего уровня с вложенными
+func (c *Controller) FindTree(ctx *fiber.Ctx) error {
+	var resp, err = c.orgUnitService.FindTree()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get org units")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindById(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.orgUnitService.FindById(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get org unit by id")
+	}
+
+	return nil
+}
+
+// функция-хендлер для PUT запроса по маршруту "/api/v1/org-units/:id"
+func (c *Controller) Update(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	var request Request
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	resp, err := c.orgUnitService.Update(id, request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning updated org unit")
+	}
+
+	return nil
+}
+
+func (c *Controller) Delete(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	count, err := c.orgUnitService.Delete(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, count); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error delete org unit by id")
+	}
+
+	return nil
+}
+
+// FindMembers возвращает сотрудников подразделения; с recursive=true — и всех вложенных подразделений
+func (c *Controller) FindMembers(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.orgUnitService.FindMembers(id, ctx.QueryBool("recursive"))
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get org unit members")
+	}
+
+	return nil
+}
+
+func (c *Controller) AddMember(ctx *fiber.Ctx) error {
+	id, employeeId, err := memberParams(ctx)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	if err = c.orgUnitService.AddMember(id, employeeId); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, employeeId); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning org unit member")
+	}
+
+	return nil
+}
+
+func (c *Controller) RemoveMember(ctx *fiber.Ctx) error {
+	id, employeeId, err := memberParams(ctx)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	if err = c.orgUnitService.RemoveMember(id, employeeId); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, employeeId); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning removed org unit member")
+	}
+
+	return nil
+}
+
+// memberParams разбирает идентификаторы подразделения и сотрудника из маршрута
+func memberParams(ctx *fiber.Ctx) (id int64, employeeId int64, err error) {
+	id, err = strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("invalid id")
+	}
+	employeeId, err = strconv.ParseInt(ctx.Params("employeeId"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("invalid employee id")
+	}
+	return id, employeeId, nil
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	case errors.As(err, &common.AlreadyExistsError{}), errors.As(err, &common.ConflictError{}):
+		return common.ErrResponse(ctx, fiber.StatusConflict, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
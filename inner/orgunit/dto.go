@@ -0,0 +1,78 @@
+package orgunit
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Entity подразделение; корневое подразделение без ParentId
+type Entity struct {
+	Id        int64         `db:"id"`
+	Name      string        `db:"name"`
+	ParentId  sql.NullInt64 `db:"parent_id"`
+	HeadId    sql.NullInt64 `db:"head_id"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
+}
+
+// MemberEntity сотрудник подразделения или одного из вложенных в него
+type MemberEntity struct {
+	Id        int64  `db:"id"`
+	Name      string `db:"name"`
+	OrgUnitId int64  `db:"org_unit_id"`
+}
+
+type Response struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	ParentId  int64     `json:"parent_id,omitempty"`
+	HeadId    int64     `json:"head_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TreeResponse подразделение вместе с вложенными
+type TreeResponse struct {
+	Response
+	Children []TreeResponse `json:"children"`
+}
+
+type MemberResponse struct {
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	OrgUnitId int64  `json:"org_unit_id"`
+}
+
+func (e *Entity) toResponse() Response {
+	return Response{
+		Id:        e.Id,
+		Name:      e.Name,
+		ParentId:  e.ParentId.Int64,
+		HeadId:    e.HeadId.Int64,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+}
+
+// toTree собирает дерево из подразделений, упорядоченных по id
+func toTree(entities []Entity) []TreeResponse {
+	var children = make(map[int64][]Entity)
+	var roots []Entity
+	for _, e := range entities {
+		if e.ParentId.Valid {
+			children[e.ParentId.Int64] = append(children[e.ParentId.Int64], e)
+		} else {
+			roots = append(roots, e)
+		}
+	}
+
+	var build func([]Entity) []TreeResponse
+	build = func(units []Entity) []TreeResponse {
+		var resp = []TreeResponse{}
+		for _, u := range units {
+			resp = append(resp, TreeResponse{Response: u.toResponse(), Children: build(children[u.Id])})
+		}
+		return resp
+	}
+	return build(roots)
+}
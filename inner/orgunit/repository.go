@@ -0,0 +1,130 @@
+package orgunit
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+func (r *Repository) FindAll() (units []Entity, err error) {
+	err = r.db.Select(&units, "SELECT * FROM org_unit ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return units, nil
+}
+
+func (r *Repository) FindById(id int64) (unit Entity, err error) {
+	err = r.db.Get(&unit, "SELECT * FROM org_unit WHERE id = $1", id)
+	return
+}
+
+// FindMembers возвращает сотрудников подразделения, а при recursive — и всех вложенных в него подразделений
+func (r *Repository) FindMembers(id int64, recursive bool) (members []MemberEntity, err error) {
+	query := `WITH RECURSIVE subtree AS (
+                  SELECT id FROM org_unit WHERE id = $1
+                  UNION
+                  SELECT u.id FROM org_unit u JOIN subtree s ON u.parent_id = s.id WHERE $2
+              )
+              SELECT e.id, e.name, e.org_unit_id FROM employee e
+              JOIN subtree s ON s.id = e.org_unit_id
+              ORDER BY e.id`
+	err = r.db.Select(&members, query, id, recursive)
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// BeginTransaction блокирует изменение дерева другими транзакциями до её завершения:
+// иначе два параллельных переноса могли бы вместе образовать цикл
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	tx, err = r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec("LOCK TABLE org_unit IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (r *Repository) FindByIdTx(tx *sqlx.Tx, id int64) (unit Entity, err error) {
+	err = tx.Get(&unit, "SELECT * FROM org_unit WHERE id = $1", id)
+	return
+}
+
+// FindByNameTx проверяет, есть ли у родителя parentId подразделение с именем name, кроме exceptId
+func (r *Repository) FindByNameTx(tx *sqlx.Tx, parentId int64, name string, exceptId int64) (exists bool, err error) {
+	query := `SELECT EXISTS (SELECT 1 FROM org_unit WHERE COALESCE(parent_id, 0) = $1 AND name = $2 AND id <> $3)`
+	err = tx.Get(&exists, query, parentId, name, exceptId)
+	return
+}
+
+func (r *Repository) EmployeeExistsTx(tx *sqlx.Tx, id int64) (exists bool, err error) {
+	err = tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM employee WHERE id = $1)", id)
+	return
+}
+
+// IsDescendantTx проверяет, вложено ли подразделение candidateId в ancestorId на любую глубину или совпадает с ним
+func (r *Repository) IsDescendantTx(tx *sqlx.Tx, ancestorId int64, candidateId int64) (descendant bool, err error) {
+	query := `WITH RECURSIVE subtree AS (
+                  SELECT id FROM org_unit WHERE id = $1
+                  UNION
+                  SELECT u.id FROM org_unit u JOIN subtree s ON u.parent_id = s.id
+              )
+              SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)`
+	err = tx.Get(&descendant, query, ancestorId, candidateId)
+	return
+}
+
+// CreateTx сохраняет подразделение и заполняет Id, CreatedAt и UpdatedAt
+func (r *Repository) CreateTx(tx *sqlx.Tx, e *Entity) error {
+	query := `INSERT INTO org_unit (name, parent_id, head_id) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at`
+	return tx.QueryRowx(query, e.Name, e.ParentId, e.HeadId).Scan(&e.Id, &e.CreatedAt, &e.UpdatedAt)
+}
+
+func (r *Repository) UpdateTx(tx *sqlx.Tx, e *Entity) error {
+	query := `UPDATE org_unit SET name = $1, parent_id = $2, head_id = $3, updated_at = NOW()
+              WHERE id = $4 RETURNING *`
+	return tx.Get(e, query, e.Name, e.ParentId, e.HeadId, e.Id)
+}
+
+// CountChildrenTx возвращает количество подразделений, непосредственно вложенных в id
+func (r *Repository) CountChildrenTx(tx *sqlx.Tx, id int64) (count int, err error) {
+	err = tx.Get(&count, "SELECT COUNT(*) FROM org_unit WHERE parent_id = $1", id)
+	return
+}
+
+// DeleteTx удаляет подразделение; его сотрудники остаются без подразделения
+func (r *Repository) DeleteTx(tx *sqlx.Tx, id int64) (int64, error) {
+	res, err := tx.Exec("DELETE FROM org_unit WHERE id = $1", id)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SetMemberTx переводит сотрудника в подразделение orgUnitId; 0 убирает его из подразделения.
+// Возвращает 0, если сотрудника нет
+func (r *Repository) SetMemberTx(tx *sqlx.Tx, employeeId int64, orgUnitId int64) (int64, error) {
+	query := `UPDATE employee SET org_unit_id = NULLIF($1, 0), version = version + 1, updated_at = NOW() WHERE id = $2`
+	res, err := tx.Exec(query, orgUnitId, employeeId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// FindMemberUnitTx возвращает подразделение сотрудника; 0, если сотрудник ни в каком не состоит
+func (r *Repository) FindMemberUnitTx(tx *sqlx.Tx, employeeId int64) (orgUnitId int64, err error) {
+	err = tx.Get(&orgUnitId, "SELECT COALESCE(org_unit_id, 0) FROM employee WHERE id = $1 FOR UPDATE", employeeId)
+	return
+}
@@ -0,0 +1,319 @@
+package orgunit
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"log"
+)
+
+// Структура сервиса подразделений
+type Service struct {
+	repo      Repo
+	validator Validator
+	rules     Rules
+}
+
+// Request подразделение верхнего уровня создаётся без ParentId
+type Request struct {
+	Name     string `json:"name" validate:"required,min=2,max=155"`
+	ParentId int64  `json:"parent_id" validate:"min=0"`
+	HeadId   int64  `json:"head_id" validate:"min=0"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+// Rules пересчитывает роли сотрудника, выдаваемые правилами, например assignmentrule.Service
+type Rules interface {
+	Apply(employeeId int64) error
+}
+
+type Repo interface {
+	FindAll() ([]Entity, error)
+	FindById(int64) (Entity, error)
+	FindMembers(int64, bool) ([]MemberEntity, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	FindByIdTx(*sqlx.Tx, int64) (Entity, error)
+	FindByNameTx(*sqlx.Tx, int64, string, int64) (bool, error)
+	EmployeeExistsTx(*sqlx.Tx, int64) (bool, error)
+	IsDescendantTx(*sqlx.Tx, int64, int64) (bool, error)
+	CreateTx(*sqlx.Tx, *Entity) error
+	UpdateTx(*sqlx.Tx, *Entity) error
+	CountChildrenTx(*sqlx.Tx, int64) (int, error)
+	DeleteTx(*sqlx.Tx, int64) (int64, error)
+	FindMemberUnitTx(*sqlx.Tx, int64) (int64, error)
+	SetMemberTx(*sqlx.Tx, int64, int64) (int64, error)
+}
+
+// NewService создаёт сервис подразделений. Если rules равен nil, роли при переводе сотрудников не пересчитываются
+func NewService(repo Repo, validator Validator, rules Rules) *Service {
+	return &Service{
+		repo:      repo,
+		validator: validator,
+		rules:     rules,
+	}
+}
+
+// Create создаёт подразделение и возвращает его идентификатор
+func (srv *Service) Create(request Request) (id int64, err error) {
+	err = srv.validator.Validate(request)
+	if err != nil {
+		return 0, common.RequestValidationError{Message: err.Error()}
+	}
+	var entity = Entity{
+		Name:     request.Name,
+		ParentId: sql.NullInt64{Int64: request.ParentId, Valid: request.ParentId > 0},
+		HeadId:   sql.NullInt64{Int64: request.HeadId, Valid: request.HeadId > 0},
+	}
+
+	err = database.InTransaction(srv.repo.BeginTransaction, "creating org unit", func(tx *sqlx.Tx) error {
+		if err := srv.check(tx, &entity); err != nil {
+			return err
+		}
+		if err := srv.repo.CreateTx(tx, &entity); err != nil {
+			return fmt.Errorf("error creating org unit: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entity.Id, nil
+}
+
+// FindTree возвращает все подразделения в виде дерева
+func (srv *Service) FindTree() ([]TreeResponse, error) {
+	var entities, err = srv.repo.FindAll()
+	if err != nil {
+		return []TreeResponse{}, fmt.Errorf("error get org units: %w", err)
+	}
+	return toTree(entities), nil
+}
+
+func (srv *Service) FindById(id int64) (Response, error) {
+	var entity, err = srv.repo.FindById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Response{}, common.NotFoundError{Message: fmt.Sprintf("org unit with id %d not found", id)}
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("error finding org unit with id %d: %w", id, err)
+	}
+	return entity.toResponse(), nil
+}
+
+// Update переименовывает подразделение, меняет руководителя или переносит его к другому родителю.
+// Перенос в само подразделение или во вложенное в него отклоняется, чтобы в дереве не появился цикл
+func (srv *Service) Update(id int64, request Request) (Response, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return Response{}, common.RequestValidationError{Message: err.Error()}
+	}
+	var entity = Entity{
+		Id:       id,
+		Name:     request.Name,
+		ParentId: sql.NullInt64{Int64: request.ParentId, Valid: request.ParentId > 0},
+		HeadId:   sql.NullInt64{Int64: request.HeadId, Valid: request.HeadId > 0},
+	}
+
+	var moved bool
+	err = database.InTransaction(srv.repo.BeginTransaction, "updating org unit", func(tx *sqlx.Tx) error {
+		current, err := srv.repo.FindByIdTx(tx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("org unit with id %d not found", id)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding org unit with id %d: %w", id, err)
+		}
+		if entity.ParentId.Valid {
+			descendant, err := srv.repo.IsDescendantTx(tx, id, entity.ParentId.Int64)
+			if err != nil {
+				return fmt.Errorf("error checking org unit hierarchy: %w", err)
+			}
+			if descendant {
+				return common.RequestValidationError{
+					Message: fmt.Sprintf("org unit %d cannot be moved into itself or its descendant %d", id, entity.ParentId.Int64),
+				}
+			}
+		}
+		if err := srv.check(tx, &entity); err != nil {
+			return err
+		}
+		moved = current.ParentId != entity.ParentId
+		if err := srv.repo.UpdateTx(tx, &entity); err != nil {
+			return fmt.Errorf("error updating org unit with id %d: %w", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	if moved {
+		// правила по подразделению учитывают вложенность, поэтому перенос меняет роли всех сотрудников поддерева
+		srv.applyRulesToMembers(id)
+	}
+	return entity.toResponse(), nil
+}
+
+// Delete удаляет подразделение без вложенных. Его сотрудники остаются без подразделения
+func (srv *Service) Delete(id int64) (int64, error) {
+	var members, err = srv.repo.FindMembers(id, false)
+	if err != nil {
+		return 0, fmt.Errorf("error get members of org unit %d: %w", id, err)
+	}
+
+	var count int64
+	err = database.InTransaction(srv.repo.BeginTransaction, "deleting org unit", func(tx *sqlx.Tx) error {
+		children, err := srv.repo.CountChildrenTx(tx, id)
+		if err != nil {
+			return fmt.Errorf("error counting children of org unit %d: %w", id, err)
+		}
+		if children > 0 {
+			return common.ConflictError{Message: fmt.Sprintf("org unit %d has %d child units", id, children)}
+		}
+		count, err = srv.repo.DeleteTx(tx, id)
+		if err != nil {
+			return fmt.Errorf("error deleting org unit with id %d: %w", id, err)
+		}
+		if count == 0 {
+			return common.NotFoundError{Message: fmt.Sprintf("org unit with id %d not found", id)}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range members {
+		srv.applyRules(m.Id)
+	}
+	return count, nil
+}
+
+// FindMembers возвращает сотрудников подразделения, а при recursive — и всех вложенных подразделений
+func (srv *Service) FindMembers(id int64, recursive bool) ([]MemberResponse, error) {
+	if _, err := srv.FindById(id); err != nil {
+		return []MemberResponse{}, err
+	}
+	var entities, err = srv.repo.FindMembers(id, recursive)
+	if err != nil {
+		return []MemberResponse{}, fmt.Errorf("error get members of org unit %d: %w", id, err)
+	}
+
+	var resp = []MemberResponse{}
+	for _, e := range entities {
+		resp = append(resp, MemberResponse{Id: e.Id, Name: e.Name, OrgUnitId: e.OrgUnitId})
+	}
+	return resp, nil
+}
+
+// AddMember переводит сотрудника в подразделение; сотрудник состоит не более чем в одном подразделении
+func (srv *Service) AddMember(id int64, employeeId int64) error {
+	err := database.InTransaction(srv.repo.BeginTransaction, "adding org unit member", func(tx *sqlx.Tx) error {
+		if _, err := srv.repo.FindByIdTx(tx, id); errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("org unit with id %d not found", id)}
+		} else if err != nil {
+			return fmt.Errorf("error finding org unit with id %d: %w", id, err)
+		}
+		return srv.setMember(tx, employeeId, id)
+	})
+	if err != nil {
+		return err
+	}
+	srv.applyRules(employeeId)
+	return nil
+}
+
+// RemoveMember убирает сотрудника из подразделения; NotFoundError, если он в нём не состоит
+func (srv *Service) RemoveMember(id int64, employeeId int64) error {
+	err := database.InTransaction(srv.repo.BeginTransaction, "removing org unit member", func(tx *sqlx.Tx) error {
+		current, err := srv.repo.FindMemberUnitTx(tx, employeeId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", employeeId)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding org unit of employee %d: %w", employeeId, err)
+		}
+		if current != id {
+			return common.NotFoundError{Message: fmt.Sprintf("employee %d is not a member of org unit %d", employeeId, id)}
+		}
+		return srv.setMember(tx, employeeId, 0)
+	})
+	if err != nil {
+		return err
+	}
+	srv.applyRules(employeeId)
+	return nil
+}
+
+func (srv *Service) setMember(tx *sqlx.Tx, employeeId int64, id int64) error {
+	count, err := srv.repo.SetMemberTx(tx, employeeId, id)
+	if err != nil {
+		return fmt.Errorf("error setting org unit of employee %d: %w", employeeId, err)
+	}
+	if count == 0 {
+		return common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", employeeId)}
+	}
+	return nil
+}
+
+// check проверяет, что родитель и руководитель существуют, а имя не занято у того же родителя
+func (srv *Service) check(tx *sqlx.Tx, entity *Entity) error {
+	if entity.ParentId.Valid {
+		_, err := srv.repo.FindByIdTx(tx, entity.ParentId.Int64)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("parent org unit with id %d not found", entity.ParentId.Int64)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding org unit with id %d: %w", entity.ParentId.Int64, err)
+		}
+	}
+	if entity.HeadId.Valid {
+		exists, err := srv.repo.EmployeeExistsTx(tx, entity.HeadId.Int64)
+		if err != nil {
+			return fmt.Errorf("error finding employee with id %d: %w", entity.HeadId.Int64, err)
+		}
+		if !exists {
+			return common.NotFoundError{Message: fmt.Sprintf("employee with id %d not found", entity.HeadId.Int64)}
+		}
+	}
+	exists, err := srv.repo.FindByNameTx(tx, entity.ParentId.Int64, entity.Name, entity.Id)
+	if err != nil {
+		return fmt.Errorf("error finding org unit by name: %s, %w", entity.Name, err)
+	}
+	if exists {
+		return common.AlreadyExistsError{Message: fmt.Sprintf("org unit with name %s already exists", entity.Name)}
+	}
+	return nil
+}
+
+// applyRulesToMembers пересчитывает роли сотрудников подразделения и всех вложенных в него
+func (srv *Service) applyRulesToMembers(id int64) {
+	if srv.rules == nil {
+		return
+	}
+	members, err := srv.repo.FindMembers(id, true)
+	if err != nil {
+		log.Printf("orgunit: get members of org unit %d failed: %v", id, err)
+		return
+	}
+	for _, m := range members {
+		srv.applyRules(m.Id)
+	}
+}
+
+// applyRules пересчитывает роли сотрудника по правилам. Ошибка не отменяет уже сохранённое изменение:
+// она записывается в лог, а роли будут исправлены фоновым пересчётом
+func (srv *Service) applyRules(employeeId int64) {
+	if srv.rules == nil {
+		return
+	}
+	if err := srv.rules.Apply(employeeId); err != nil {
+		log.Printf("orgunit: applying assignment rules to employee %d failed: %v", employeeId, err)
+	}
+}
@@ -0,0 +1,258 @@
+package orgunit
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindAll() ([]Entity, error) {
+	args := m.Called()
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindById(id int64) (Entity, error) {
+	args := m.Called(id)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindMembers(id int64, recursive bool) ([]MemberEntity, error) {
+	args := m.Called(id, recursive)
+	return args.Get(0).([]MemberEntity), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) FindByIdTx(tx *sqlx.Tx, id int64) (Entity, error) {
+	args := m.Called(tx, id)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindByNameTx(tx *sqlx.Tx, parentId int64, name string, exceptId int64) (bool, error) {
+	args := m.Called(tx, parentId, name, exceptId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) EmployeeExistsTx(tx *sqlx.Tx, id int64) (bool, error) {
+	args := m.Called(tx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) IsDescendantTx(tx *sqlx.Tx, ancestorId int64, candidateId int64) (bool, error) {
+	args := m.Called(tx, ancestorId, candidateId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) CreateTx(tx *sqlx.Tx, e *Entity) error {
+	args := m.Called(tx, e)
+	e.Id = 1
+	return args.Error(0)
+}
+
+func (m *MockRepo) UpdateTx(tx *sqlx.Tx, e *Entity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) CountChildrenTx(tx *sqlx.Tx, id int64) (int, error) {
+	args := m.Called(tx, id)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepo) DeleteTx(tx *sqlx.Tx, id int64) (int64, error) {
+	args := m.Called(tx, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindMemberUnitTx(tx *sqlx.Tx, employeeId int64) (int64, error) {
+	args := m.Called(tx, employeeId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) SetMemberTx(tx *sqlx.Tx, employeeId int64, id int64) (int64, error) {
+	args := m.Called(tx, employeeId, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// StubRules запоминает сотрудников, чьи роли пересчитывались
+type StubRules struct {
+	applied []int64
+}
+
+func (s *StubRules) Apply(employeeId int64) error {
+	s.applied = append(s.applied, employeeId)
+	return nil
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+func parent(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: true}
+}
+
+func TestToTree(t *testing.T) {
+	var a = assert.New(t)
+	var tree = toTree([]Entity{
+		{Id: 1, Name: "Company"},
+		{Id: 2, Name: "Engineering", ParentId: parent(1)},
+		{Id: 3, Name: "Backend", ParentId: parent(2)},
+		{Id: 4, Name: "Sales", ParentId: parent(1)},
+	})
+	a.Len(tree, 1)
+	a.Equal("Company", tree[0].Name)
+	a.Len(tree[0].Children, 2)
+	a.Equal("Backend", tree[0].Children[0].Children[0].Name)
+	a.Empty(tree[0].Children[1].Children)
+}
+
+func TestCreate(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should create unit under parent", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(2)).Return(Entity{Id: 2}, nil)
+		repo.On("EmployeeExistsTx", tx, int64(10)).Return(true, nil)
+		repo.On("FindByNameTx", tx, int64(2), "Backend", int64(0)).Return(false, nil)
+		repo.On("CreateTx", tx, mock.MatchedBy(func(e *Entity) bool {
+			return e.ParentId == parent(2) && e.HeadId.Int64 == 10
+		})).Return(nil)
+		var id, err = svc.Create(Request{Name: "Backend", ParentId: 2, HeadId: 10})
+		a.Nil(err)
+		a.Equal(int64(1), id)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should return not found for unknown parent", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(99)).Return(Entity{}, sql.ErrNoRows)
+		var _, err = svc.Create(Request{Name: "Backend", ParentId: 99})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+
+	t.Run("should reject duplicate name under same parent", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, int64(0), "Company", int64(0)).Return(true, nil)
+		var _, err = svc.Create(Request{Name: "Company"})
+		a.ErrorAs(err, &common.AlreadyExistsError{})
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should reject move into descendant", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(2)).Return(Entity{Id: 2, Name: "Engineering", ParentId: parent(1)}, nil)
+		repo.On("IsDescendantTx", tx, int64(2), int64(3)).Return(true, nil)
+		var _, err = svc.Update(2, Request{Name: "Engineering", ParentId: 3})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		repo.AssertNotCalled(t, "UpdateTx", mock.Anything, mock.Anything)
+	})
+
+	t.Run("should apply rules to subtree members after move", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var rules = new(StubRules)
+		var svc = NewService(repo, validator.New(), rules)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(2)).Return(Entity{Id: 2, Name: "Engineering", ParentId: parent(1)}, nil)
+		repo.On("IsDescendantTx", tx, int64(2), int64(4)).Return(false, nil)
+		repo.On("FindByIdTx", tx, int64(4)).Return(Entity{Id: 4}, nil)
+		repo.On("FindByNameTx", tx, int64(4), "Engineering", int64(2)).Return(false, nil)
+		repo.On("UpdateTx", tx, mock.Anything).Return(nil)
+		repo.On("FindMembers", int64(2), true).Return([]MemberEntity{{Id: 10}, {Id: 11}}, nil)
+		var resp, err = svc.Update(2, Request{Name: "Engineering", ParentId: 4})
+		a.Nil(err)
+		a.Equal(int64(4), resp.ParentId)
+		a.Equal([]int64{10, 11}, rules.applied)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+}
+
+func TestDelete(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = NewService(repo, validator.New(), nil)
+	tx, _ := newTx(a, false)
+	repo.On("FindMembers", int64(1), false).Return([]MemberEntity{}, nil)
+	repo.On("BeginTransaction").Return(tx, nil)
+	repo.On("CountChildrenTx", tx, int64(1)).Return(2, nil)
+	var _, err = svc.Delete(1)
+	a.ErrorAs(err, &common.ConflictError{})
+	repo.AssertNotCalled(t, "DeleteTx", mock.Anything, mock.Anything)
+}
+
+func TestMembers(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should add member and apply rules", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var rules = new(StubRules)
+		var svc = NewService(repo, validator.New(), rules)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(2)).Return(Entity{Id: 2}, nil)
+		repo.On("SetMemberTx", tx, int64(10), int64(2)).Return(int64(1), nil)
+		a.Nil(svc.AddMember(2, 10))
+		a.Equal([]int64{10}, rules.applied)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should return not found for unknown employee", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByIdTx", tx, int64(2)).Return(Entity{Id: 2}, nil)
+		repo.On("SetMemberTx", tx, int64(99), int64(2)).Return(int64(0), nil)
+		a.ErrorAs(svc.AddMember(2, 99), &common.NotFoundError{})
+	})
+
+	t.Run("should not remove member of another unit", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New(), nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindMemberUnitTx", tx, int64(10)).Return(int64(3), nil)
+		a.ErrorAs(svc.RemoveMember(2, 10), &common.NotFoundError{})
+		repo.AssertNotCalled(t, "SetMemberTx", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
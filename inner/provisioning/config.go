@@ -0,0 +1,166 @@
+package provisioning
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Config настройки провижининга, загружаемые из JSON файла:
+//
+//	{"connectors": [{"name": "mirror", "kind": "memory", "settings": {"path": "accounts.json"},
+//	  "retry": {"max_attempts": 5, "initial_backoff": "1s", "max_backoff": "1m"},
+//	  "reconcile": {"interval": "1h", "orphan_account": "disable", "extra_entitlement": "remove"}}]}
+type Config struct {
+	Connectors []ConnectorConfig `json:"connectors"`
+}
+
+// ConnectorConfig настройки одного коннектора. Kind выбирает фабрику в Registry,
+// Settings передаются фабрике как есть
+type ConnectorConfig struct {
+	Name      string            `json:"name"`
+	Kind      string            `json:"kind"`
+	Disabled  bool              `json:"disabled"`
+	Settings  map[string]string `json:"settings"`
+	Retry     RetryConfig       `json:"retry"`
+	QueueSize int               `json:"queue_size"`
+	Reconcile ReconcilePolicy   `json:"reconcile"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int      `json:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+}
+
+// Действия сверки над найденными расхождениями. ActionReport только записывает расхождение в отчёт
+const (
+	ActionReport  = "report"
+	ActionCreate  = "create"
+	ActionDisable = "disable"
+	ActionDelete  = "delete"
+	ActionAdd     = "add"
+	ActionRemove  = "remove"
+)
+
+// ReconcilePolicy что делать с каждым видом расхождений, найденных при сверке с внешней системой.
+// Если Interval больше нуля, сверка запускается по расписанию
+type ReconcilePolicy struct {
+	Interval Duration `json:"interval"`
+	// учётная запись без сотрудника: report, disable или delete
+	OrphanAccount string `json:"orphan_account"`
+	// сотрудник без учётной записи: report или create
+	MissingAccount string `json:"missing_account"`
+	// право без назначения роли: report или remove
+	ExtraEntitlement string `json:"extra_entitlement"`
+	// назначение роли без права: report или add
+	MissingEntitlement string `json:"missing_entitlement"`
+}
+
+// validate проверяет, что для каждого вида расхождений задано допустимое действие. Пустое значение допустимо
+func (p *ReconcilePolicy) validate() error {
+	var allowed = []struct {
+		name    string
+		value   string
+		actions []string
+	}{
+		{"orphan_account", p.OrphanAccount, []string{ActionReport, ActionDisable, ActionDelete}},
+		{"missing_account", p.MissingAccount, []string{ActionReport, ActionCreate}},
+		{"extra_entitlement", p.ExtraEntitlement, []string{ActionReport, ActionRemove}},
+		{"missing_entitlement", p.MissingEntitlement, []string{ActionReport, ActionAdd}},
+	}
+	for _, a := range allowed {
+		if a.value != "" && !slices.Contains(a.actions, a.value) {
+			return fmt.Errorf("reconcile %s must be one of %v, got %q", a.name, a.actions, a.value)
+		}
+	}
+	return nil
+}
+
+// applyDefaults по умолчанию расхождения только попадают в отчёт
+func (p *ReconcilePolicy) applyDefaults() {
+	for _, action := range []*string{&p.OrphanAccount, &p.MissingAccount, &p.ExtraEntitlement, &p.MissingEntitlement} {
+		if *action == "" {
+			*action = ActionReport
+		}
+	}
+}
+
+// Duration длительность, записанная в JSON строкой вида "1m30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+	defaultQueueSize      = 1000
+)
+
+// LoadConfig читает настройки провижининга из файла. Пустой путь означает, что коннекторов нет
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return Config{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading provisioning config: %w", err)
+	}
+	var cfg Config
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("error parsing provisioning config: %w", err)
+	}
+	for i := range cfg.Connectors {
+		if cfg.Connectors[i].Name == "" || cfg.Connectors[i].Kind == "" {
+			return Config{}, fmt.Errorf("provisioning connector must have name and kind")
+		}
+		if err = cfg.Connectors[i].Reconcile.validate(); err != nil {
+			return Config{}, fmt.Errorf("provisioning connector %q: %w", cfg.Connectors[i].Name, err)
+		}
+		cfg.Connectors[i].applyDefaults()
+	}
+	return cfg, nil
+}
+
+// applyDefaults заполняет незаданные параметры повторов, размер очереди и действия сверки значениями по умолчанию
+func (c *ConnectorConfig) applyDefaults() {
+	if c.Retry.MaxAttempts <= 0 {
+		c.Retry.MaxAttempts = defaultMaxAttempts
+	}
+	if c.Retry.InitialBackoff <= 0 {
+		c.Retry.InitialBackoff = Duration(defaultInitialBackoff)
+	}
+	if c.Retry.MaxBackoff <= 0 {
+		c.Retry.MaxBackoff = Duration(defaultMaxBackoff)
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultQueueSize
+	}
+	c.Reconcile.applyDefaults()
+}
+
+// backoff задержка перед попыткой номер attempt (с единицы): удваивается с каждой попыткой до MaxBackoff
+func (r RetryConfig) backoff(attempt int) time.Duration {
+	var delay = time.Duration(r.InitialBackoff)
+	for i := 1; i < attempt && delay < time.Duration(r.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if delay > time.Duration(r.MaxBackoff) {
+		delay = time.Duration(r.MaxBackoff)
+	}
+	return delay
+}
@@ -0,0 +1,99 @@
+package provisioning
+
+import (
+	"errors"
+)
+
+// Account учётная запись сотрудника во внешней системе
+type Account struct {
+	EmployeeId int64  `db:"employee_id" json:"employee_id"`
+	Name       string `db:"name" json:"name"`
+}
+
+// Entitlement право во внешней системе, соответствующее роли: группа, членство, лицензия
+type Entitlement struct {
+	RoleId int64  `db:"role_id" json:"role_id"`
+	Name   string `db:"name" json:"name"`
+}
+
+// Connector передаёт изменения сотрудников и их ролей во внешнюю систему.
+// Методы вызываются из отдельной горутины диспетчера и должны быть идемпотентными:
+// после ошибки тот же вызов повторяется
+type Connector interface {
+	CreateAccount(account Account) error
+	UpdateAccount(account Account) error
+	DisableAccount(account Account) error
+	DeleteAccount(account Account) error
+	AddEntitlement(account Account, entitlement Entitlement) error
+	RemoveEntitlement(account Account, entitlement Entitlement) error
+}
+
+// TargetAccount учётная запись в том виде, в каком она сейчас есть во внешней системе.
+// У записей, созданных во внешней системе вручную, EmployeeId может быть нулевым,
+// а у прав — RoleId, если система хранит только имя
+type TargetAccount struct {
+	Account      Account       `json:"account"`
+	Disabled     bool          `json:"disabled"`
+	Entitlements []Entitlement `json:"entitlements"`
+}
+
+// Reader коннектор, который умеет прочитать текущее состояние внешней системы. Нужен для сверки
+type Reader interface {
+	ListAccounts() ([]TargetAccount, error)
+}
+
+type EventType string
+
+const (
+	AccountCreated     EventType = "account_created"
+	AccountUpdated     EventType = "account_updated"
+	AccountDisabled    EventType = "account_disabled"
+	AccountDeleted     EventType = "account_deleted"
+	EntitlementAdded   EventType = "entitlement_added"
+	EntitlementRemoved EventType = "entitlement_removed"
+)
+
+// Event изменение в IdM, которое нужно передать во все коннекторы
+type Event struct {
+	Type        EventType
+	Account     Account
+	Entitlement Entitlement
+}
+
+// apply вызывает метод коннектора, соответствующий типу события
+func (e Event) apply(connector Connector) error {
+	switch e.Type {
+	case AccountCreated:
+		return connector.CreateAccount(e.Account)
+	case AccountUpdated:
+		return connector.UpdateAccount(e.Account)
+	case AccountDisabled:
+		return connector.DisableAccount(e.Account)
+	case AccountDeleted:
+		return connector.DeleteAccount(e.Account)
+	case EntitlementAdded:
+		return connector.AddEntitlement(e.Account, e.Entitlement)
+	case EntitlementRemoved:
+		return connector.RemoveEntitlement(e.Account, e.Entitlement)
+	default:
+		return Permanent(errors.New("unknown event type " + string(e.Type)))
+	}
+}
+
+// PermanentError ошибка, которую бессмысленно повторять: например, внешняя система отклонила данные
+type PermanentError struct {
+	Err error
+}
+
+func (err PermanentError) Error() string {
+	return err.Err.Error()
+}
+
+func (err PermanentError) Unwrap() error {
+	return err.Err
+}
+
+// Permanent помечает ошибку коннектора как неповторяемую
+func Permanent(err error) error {
+	return PermanentError{Err: err}
+}
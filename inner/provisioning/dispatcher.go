@@ -0,0 +1,166 @@
+package provisioning
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Dispatcher асинхронно передаёт события в коннекторы. У каждого коннектора своя очередь
+// и своя горутина, поэтому медленная внешняя система не задерживает остальные,
+// а события для одного коннектора применяются в том порядке, в котором были опубликованы
+type Dispatcher struct {
+	mu      sync.Mutex
+	workers []*worker
+	started bool
+	stopped bool
+	wg      sync.WaitGroup
+	// sending не даёт Stop закрыть очереди, пока Publish в них пишет. Отправка идёт без mu,
+	// чтобы заполненная очередь не блокировала Start, Stop и Targets
+	sending sync.RWMutex
+	// done закрывается в Stop и будит Publish, ждущие места в очереди
+	done chan struct{}
+}
+
+// Target коннектор вместе с его настройками
+type Target struct {
+	Config    ConnectorConfig
+	Connector Connector
+}
+
+type worker struct {
+	name      string
+	config    ConnectorConfig
+	connector Connector
+	retry     RetryConfig
+	queue     chan Event
+	// sleep подменяется в тестах, чтобы не ждать реальные задержки между попытками
+	sleep func(time.Duration)
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{done: make(chan struct{})}
+}
+
+// Add подключает коннектор к диспетчеру. Вызывается до Start
+func (d *Dispatcher) Add(cfg ConnectorConfig, connector Connector) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cfg.applyDefaults()
+	d.workers = append(d.workers, &worker{
+		name:      cfg.Name,
+		config:    cfg,
+		connector: connector,
+		retry:     cfg.Retry,
+		queue:     make(chan Event, cfg.QueueSize),
+		sleep:     time.Sleep,
+	})
+}
+
+// Targets возвращает все подключённые коннекторы в порядке добавления
+func (d *Dispatcher) Targets() []Target {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var targets = make([]Target, 0, len(d.workers))
+	for _, w := range d.workers {
+		targets = append(targets, Target{Config: w.config, Connector: w.connector})
+	}
+	return targets
+}
+
+// Target возвращает коннектор по имени из конфигурации
+func (d *Dispatcher) Target(name string) (Target, bool) {
+	for _, t := range d.Targets() {
+		if t.Config.Name == name {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
+
+// Start запускает горутины коннекторов. До запуска события только накапливаются в очередях
+func (d *Dispatcher) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.started {
+		return
+	}
+	d.started = true
+	for _, w := range d.workers {
+		d.wg.Add(1)
+		go func(w *worker) {
+			defer d.wg.Done()
+			w.run()
+		}(w)
+	}
+}
+
+// Publish ставит событие в очереди всех коннекторов. Если очередь заполнена,
+// вызов ждёт, пока коннектор её разберёт: события не теряются. Ожидание прерывает только Stop
+func (d *Dispatcher) Publish(event Event) {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		log.Printf("provisioning: dispatcher is stopped, %s for employee %d dropped", event.Type, event.Account.EmployeeId)
+		return
+	}
+	var workers = slices.Clone(d.workers)
+	d.sending.RLock()
+	d.mu.Unlock()
+	defer d.sending.RUnlock()
+
+	for _, w := range workers {
+		select {
+		case w.queue <- event:
+		case <-d.done:
+			log.Printf("provisioning: dispatcher is stopped, %s for employee %d dropped", event.Type, event.Account.EmployeeId)
+			return
+		}
+	}
+}
+
+// Stop перестаёт принимать события и ждёт, пока коннекторы обработают уже поставленные в очередь
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+	close(d.done)
+	d.mu.Unlock()
+
+	// новые Publish видят stopped, а уже начатые завершаются после закрытия done
+	d.sending.Lock()
+	for _, w := range d.workers {
+		close(w.queue)
+	}
+	d.sending.Unlock()
+	d.wg.Wait()
+}
+
+func (w *worker) run() {
+	for event := range w.queue {
+		if err := w.deliver(event); err != nil {
+			log.Printf("provisioning: connector %s failed to apply %s for employee %d: %v",
+				w.name, event.Type, event.Account.EmployeeId, err)
+		}
+	}
+}
+
+// deliver применяет событие, повторяя попытки с экспоненциальной задержкой
+func (w *worker) deliver(event Event) error {
+	var err error
+	for attempt := 1; attempt <= w.retry.MaxAttempts; attempt++ {
+		if err = event.apply(w.connector); err == nil {
+			return nil
+		}
+		if errors.As(err, &PermanentError{}) || attempt == w.retry.MaxAttempts {
+			break
+		}
+		w.sleep(w.retry.backoff(attempt))
+	}
+	return err
+}
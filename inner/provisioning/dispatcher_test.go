@@ -0,0 +1,207 @@
+package provisioning
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FlakyConnector коннектор, который падает заданное количество раз, прежде чем выполнить вызов
+type FlakyConnector struct {
+	*MemoryConnector
+	mu       sync.Mutex
+	failures int
+	err      error
+	calls    int
+}
+
+func (c *FlakyConnector) CreateAccount(account Account) error {
+	c.mu.Lock()
+	c.calls++
+	if c.failures > 0 {
+		c.failures--
+		c.mu.Unlock()
+		return c.err
+	}
+	c.mu.Unlock()
+	return c.MemoryConnector.CreateAccount(account)
+}
+
+func newTestDispatcher(connector Connector, retry RetryConfig) (*Dispatcher, *[]time.Duration) {
+	var delays []time.Duration
+	var dispatcher = NewDispatcher()
+	dispatcher.Add(ConnectorConfig{Name: "test", Kind: "memory", Retry: retry}, connector)
+	dispatcher.workers[0].sleep = func(d time.Duration) {
+		delays = append(delays, d)
+	}
+	return dispatcher, &delays
+}
+
+func TestDispatcher(t *testing.T) {
+	var a = assert.New(t)
+	var account = Account{EmployeeId: 1, Name: "John Doe"}
+	var admin = Entitlement{RoleId: 10, Name: "Admin"}
+
+	t.Run("should apply events in order", func(t *testing.T) {
+		var memory, _ = NewMemoryConnector("")
+		var dispatcher, _ = newTestDispatcher(memory, RetryConfig{})
+		dispatcher.Start()
+		dispatcher.Publish(Event{Type: AccountCreated, Account: account})
+		dispatcher.Publish(Event{Type: EntitlementAdded, Account: account, Entitlement: admin})
+		dispatcher.Publish(Event{Type: AccountUpdated, Account: Account{EmployeeId: 1, Name: "John Deer"}})
+		dispatcher.Stop()
+		a.Equal(map[int64]MemoryAccount{
+			1: {Name: "John Deer", Entitlements: map[int64]string{10: "Admin"}},
+		}, memory.Accounts())
+	})
+
+	t.Run("should retry with exponential backoff", func(t *testing.T) {
+		var memory, _ = NewMemoryConnector("")
+		var flaky = &FlakyConnector{MemoryConnector: memory, failures: 3, err: errors.New("timeout")}
+		var retry = RetryConfig{MaxAttempts: 5, InitialBackoff: Duration(time.Second), MaxBackoff: Duration(3 * time.Second)}
+		var dispatcher, delays = newTestDispatcher(flaky, retry)
+		dispatcher.Start()
+		dispatcher.Publish(Event{Type: AccountCreated, Account: account})
+		dispatcher.Stop()
+		a.Equal(4, flaky.calls)
+		a.Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, *delays)
+		a.Contains(memory.Accounts(), int64(1))
+	})
+
+	t.Run("should give up after max attempts", func(t *testing.T) {
+		var memory, _ = NewMemoryConnector("")
+		var flaky = &FlakyConnector{MemoryConnector: memory, failures: 10, err: errors.New("timeout")}
+		var dispatcher, _ = newTestDispatcher(flaky, RetryConfig{MaxAttempts: 3})
+		dispatcher.Start()
+		dispatcher.Publish(Event{Type: AccountCreated, Account: account})
+		dispatcher.Stop()
+		a.Equal(3, flaky.calls)
+		a.Empty(memory.Accounts())
+	})
+
+	t.Run("should not retry permanent errors", func(t *testing.T) {
+		var memory, _ = NewMemoryConnector("")
+		var flaky = &FlakyConnector{MemoryConnector: memory, failures: 10, err: Permanent(errors.New("rejected"))}
+		var dispatcher, delays = newTestDispatcher(flaky, RetryConfig{MaxAttempts: 5})
+		dispatcher.Start()
+		dispatcher.Publish(Event{Type: AccountCreated, Account: account})
+		dispatcher.Stop()
+		a.Equal(1, flaky.calls)
+		a.Empty(*delays)
+	})
+}
+
+func TestDispatcherFullQueue(t *testing.T) {
+	var a = assert.New(t)
+	var account = Account{EmployeeId: 1, Name: "John Doe"}
+
+	t.Run("should not block dispatcher while queue is full", func(t *testing.T) {
+		var memory, _ = NewMemoryConnector("")
+		var dispatcher = NewDispatcher()
+		dispatcher.Add(ConnectorConfig{Name: "test", Kind: "memory", QueueSize: 2}, memory)
+		var published = make(chan struct{})
+		go func() {
+			defer close(published)
+			// до Start очередь не разбирается, последнее событие ждёт места
+			for i := int64(1); i <= 3; i++ {
+				dispatcher.Publish(Event{Type: AccountCreated, Account: Account{EmployeeId: i, Name: account.Name}})
+			}
+		}()
+		a.Eventually(func() bool { return len(dispatcher.workers[0].queue) == 2 }, time.Second, time.Millisecond)
+
+		var targets = make(chan []Target)
+		go func() { targets <- dispatcher.Targets() }()
+		select {
+		case got := <-targets:
+			a.Len(got, 1)
+		case <-time.After(time.Second):
+			a.Fail("Targets blocked by full queue")
+		}
+
+		dispatcher.Start()
+		select {
+		case <-published:
+		case <-time.After(time.Second):
+			a.Fail("Publish blocked after Start")
+		}
+		dispatcher.Stop()
+		a.Len(memory.Accounts(), 3)
+	})
+
+	t.Run("should release publisher on stop", func(t *testing.T) {
+		var memory, _ = NewMemoryConnector("")
+		var dispatcher = NewDispatcher()
+		dispatcher.Add(ConnectorConfig{Name: "test", Kind: "memory", QueueSize: 1}, memory)
+		dispatcher.Publish(Event{Type: AccountCreated, Account: account})
+		var published = make(chan struct{})
+		go func() {
+			defer close(published)
+			dispatcher.Publish(Event{Type: AccountUpdated, Account: account})
+		}()
+
+		var stopped = make(chan struct{})
+		go func() {
+			defer close(stopped)
+			dispatcher.Stop()
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			a.Fail("Stop blocked by full queue")
+		}
+		<-published
+	})
+}
+
+func TestMemoryConnectorFile(t *testing.T) {
+	var a = assert.New(t)
+	var path = filepath.Join(t.TempDir(), "accounts.json")
+	var connector, err = NewMemoryConnector(path)
+	a.Nil(err)
+	var account = Account{EmployeeId: 1, Name: "John Doe"}
+	a.Nil(connector.CreateAccount(account))
+	a.Nil(connector.AddEntitlement(account, Entitlement{RoleId: 10, Name: "Admin"}))
+	a.Nil(connector.DisableAccount(account))
+
+	// новый коннектор с тем же файлом видит сохранённое состояние
+	reloaded, err := NewMemoryConnector(path)
+	a.Nil(err)
+	a.Equal(connector.Accounts(), reloaded.Accounts())
+	a.True(reloaded.Accounts()[1].Disabled)
+
+	a.Nil(reloaded.DeleteAccount(account))
+	a.Empty(reloaded.Accounts())
+}
+
+func TestRegistryBuild(t *testing.T) {
+	var a = assert.New(t)
+	var path = filepath.Join(t.TempDir(), "provisioning.json")
+	var data = `{"connectors": [
+		{"name": "mirror", "kind": "memory", "retry": {"max_attempts": 2, "initial_backoff": "10ms"}},
+		{"name": "old", "kind": "ldap", "disabled": true}
+	]}`
+	a.Nil(os.WriteFile(path, []byte(data), 0o600))
+
+	cfg, err := LoadConfig(path)
+	a.Nil(err)
+	a.Equal(2, cfg.Connectors[0].Retry.MaxAttempts)
+	a.Equal(Duration(10*time.Millisecond), cfg.Connectors[0].Retry.InitialBackoff)
+	a.Equal(Duration(defaultMaxBackoff), cfg.Connectors[0].Retry.MaxBackoff)
+
+	dispatcher, err := NewRegistry().Build(cfg)
+	a.Nil(err)
+	a.Len(dispatcher.workers, 1)
+
+	a.Equal(ActionReport, dispatcher.Targets()[0].Config.Reconcile.OrphanAccount)
+
+	_, err = NewRegistry().Build(Config{Connectors: []ConnectorConfig{{Name: "x", Kind: "unknown"}}})
+	a.NotNil(err)
+
+	a.Nil(os.WriteFile(path, []byte(`{"connectors": [{"name": "m", "kind": "memory", "reconcile": {"orphan_account": "create"}}]}`), 0o600))
+	_, err = LoadConfig(path)
+	a.NotNil(err)
+}
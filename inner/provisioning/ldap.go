@@ -0,0 +1,325 @@
+package provisioning
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhedevops/idm/inner/ldap"
+)
+
+// Значения по умолчанию для отметки заблокированной учётной записи: атрибут overlay ppolicy,
+// при котором OpenLDAP и совместимые серверы отказывают в bind
+const (
+	defaultDisabledAttribute = "pwdAccountLockedTime"
+	defaultDisabledValue     = "000001010000Z"
+)
+
+// groupPrefix начало cn группы роли, за ним следует id роли
+const groupPrefix = "role-"
+
+// LdapConnector передаёт сотрудников в каталог LDAP как записи inetOrgPerson,
+// а роли — как группы groupOfNames, членами которых являются эти записи. Имя роли хранится в description группы.
+// Для каждого вызова открывается отдельное соединение
+type LdapConnector struct {
+	url               string
+	bindDN            string
+	bindPassword      string
+	usersDN           string
+	groupsDN          string
+	timeout           time.Duration
+	disabledAttribute string
+	disabledValue     string
+}
+
+// NewLdapConnectorFromSettings фабрика для Registry.
+// Обязательные настройки: url, users_dn, groups_dn. Необязательные: bind_dn, bind_password,
+// timeout, disabled_attribute и disabled_value
+func NewLdapConnectorFromSettings(settings map[string]string) (Connector, error) {
+	var c = &LdapConnector{
+		url:               settings["url"],
+		bindDN:            settings["bind_dn"],
+		bindPassword:      settings["bind_password"],
+		usersDN:           settings["users_dn"],
+		groupsDN:          settings["groups_dn"],
+		timeout:           ldap.DefaultTimeout,
+		disabledAttribute: settings["disabled_attribute"],
+		disabledValue:     settings["disabled_value"],
+	}
+	for _, required := range []string{"url", "users_dn", "groups_dn"} {
+		if settings[required] == "" {
+			return nil, fmt.Errorf("ldap connector setting %q is required", required)
+		}
+	}
+	if timeout := settings["timeout"]; timeout != "" {
+		var err error
+		if c.timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid ldap connector timeout %q: %w", timeout, err)
+		}
+	}
+	if c.disabledAttribute == "" {
+		c.disabledAttribute = defaultDisabledAttribute
+		c.disabledValue = defaultDisabledValue
+	}
+	return c, nil
+}
+
+// AccountDN DN записи сотрудника: uid=<id сотрудника>,<users_dn>
+func (c *LdapConnector) AccountDN(account Account) string {
+	return "uid=" + strconv.FormatInt(account.EmployeeId, 10) + "," + c.usersDN
+}
+
+// GroupDN DN группы роли: cn=role-<id роли>,<groups_dn>. DN не зависит от имени роли,
+// поэтому после переименования роли участники остаются в той же группе.
+// Группа, созданная в каталоге вручную, известна только по имени: cn=<имя>,<groups_dn>
+func (c *LdapConnector) GroupDN(entitlement Entitlement) string {
+	if entitlement.RoleId == 0 {
+		return "cn=" + ldap.EscapeDN(entitlement.Name) + "," + c.groupsDN
+	}
+	return "cn=" + groupPrefix + strconv.FormatInt(entitlement.RoleId, 10) + "," + c.groupsDN
+}
+
+func (c *LdapConnector) CreateAccount(account Account) error {
+	return c.do(func(conn *ldap.Conn) error {
+		var err = conn.Add(c.AccountDN(account), c.accountAttributes(account))
+		if ldap.IsCode(err, ldap.ResultEntryAlreadyExists) {
+			// запись могла остаться от предыдущей попытки или от сотрудника, которого уволили и приняли снова:
+			// приводим её к актуальному состоянию и снимаем блокировку. Replace без значений удаляет атрибут
+			return c.modifyAccount(conn, account, ldap.Change{
+				Operation: ldap.ModReplace,
+				Attribute: ldap.Attribute{Type: c.disabledAttribute},
+			})
+		}
+		return err
+	})
+}
+
+func (c *LdapConnector) UpdateAccount(account Account) error {
+	return c.do(func(conn *ldap.Conn) error {
+		var err = c.modifyAccount(conn, account)
+		if ldap.IsCode(err, ldap.ResultNoSuchObject) {
+			return conn.Add(c.AccountDN(account), c.accountAttributes(account))
+		}
+		return err
+	})
+}
+
+func (c *LdapConnector) DisableAccount(account Account) error {
+	return c.do(func(conn *ldap.Conn) error {
+		var err = conn.Modify(c.AccountDN(account), []ldap.Change{{
+			Operation: ldap.ModReplace,
+			Attribute: ldap.Attribute{Type: c.disabledAttribute, Values: []string{c.disabledValue}},
+		}})
+		if ldap.IsCode(err, ldap.ResultNoSuchObject) {
+			return nil
+		}
+		return err
+	})
+}
+
+// DeleteAccount удаляет сотрудника из всех групп и затем его запись
+func (c *LdapConnector) DeleteAccount(account Account) error {
+	return c.do(func(conn *ldap.Conn) error {
+		var dn = c.AccountDN(account)
+		groups, err := conn.Search(ldap.SearchRequest{
+			BaseDN:     c.groupsDN,
+			Scope:      ldap.ScopeWholeSubtree,
+			Filter:     "(&(objectClass=groupOfNames)(member=" + ldap.EscapeFilter(dn) + "))",
+			Attributes: []string{"member"},
+		})
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			if err := c.removeMember(conn, group.DN, dn); err != nil {
+				return err
+			}
+		}
+		err = conn.Delete(dn)
+		if ldap.IsCode(err, ldap.ResultNoSuchObject) {
+			return nil
+		}
+		return err
+	})
+}
+
+// AddEntitlement добавляет сотрудника в группу роли, создавая группу при первом участнике.
+// Заодно обновляет имя роли в description, если роль переименовали
+func (c *LdapConnector) AddEntitlement(account Account, entitlement Entitlement) error {
+	return c.do(func(conn *ldap.Conn) error {
+		var dn, group = c.AccountDN(account), c.GroupDN(entitlement)
+		var description = ldap.Change{
+			Operation: ldap.ModReplace,
+			Attribute: ldap.Attribute{Type: "description", Values: []string{entitlement.Name}},
+		}
+		var err = conn.Modify(group, []ldap.Change{{
+			Operation: ldap.ModAdd,
+			Attribute: ldap.Attribute{Type: "member", Values: []string{dn}},
+		}, description})
+		switch {
+		case ldap.IsCode(err, ldap.ResultAttributeOrValueExists):
+			return conn.Modify(group, []ldap.Change{description})
+		case ldap.IsCode(err, ldap.ResultNoSuchObject):
+			return conn.Add(group, []ldap.Attribute{
+				{Type: "objectClass", Values: []string{"top", "groupOfNames"}},
+				{Type: "cn", Values: []string{groupCn(entitlement)}},
+				{Type: "description", Values: []string{entitlement.Name}},
+				{Type: "member", Values: []string{dn}},
+			})
+		}
+		return err
+	})
+}
+
+func (c *LdapConnector) RemoveEntitlement(account Account, entitlement Entitlement) error {
+	return c.do(func(conn *ldap.Conn) error {
+		return c.removeMember(conn, c.GroupDN(entitlement), c.AccountDN(account))
+	})
+}
+
+// ListAccounts читает записи inetOrgPerson из users_dn и группы groupOfNames из groups_dn.
+// Записи, uid которых не является id сотрудника, возвращаются с нулевым EmployeeId,
+// а группы, созданные в каталоге вручную, — с нулевым RoleId и именем из cn
+func (c *LdapConnector) ListAccounts() (accounts []TargetAccount, err error) {
+	err = c.do(func(conn *ldap.Conn) error {
+		users, err := conn.Search(ldap.SearchRequest{
+			BaseDN:     c.usersDN,
+			Scope:      ldap.ScopeSingleLevel,
+			Filter:     "(objectClass=inetOrgPerson)",
+			Attributes: []string{"uid", "cn", c.disabledAttribute},
+		})
+		if err != nil {
+			return err
+		}
+		groups, err := conn.Search(ldap.SearchRequest{
+			BaseDN:     c.groupsDN,
+			Scope:      ldap.ScopeWholeSubtree,
+			Filter:     "(objectClass=groupOfNames)",
+			Attributes: []string{"cn", "description", "member"},
+		})
+		if err != nil {
+			return err
+		}
+
+		var byDN = map[string]int{}
+		for _, user := range users {
+			var account = TargetAccount{Account: Account{Name: first(user.Get("cn"))}}
+			account.Account.EmployeeId, _ = strconv.ParseInt(first(user.Get("uid")), 10, 64)
+			account.Disabled = len(user.Get(c.disabledAttribute)) > 0
+			byDN[ldap.NormalizeDN(user.DN)] = len(accounts)
+			accounts = append(accounts, account)
+		}
+		for _, group := range groups {
+			var entitlement = Entitlement{Name: first(group.Get("cn"))}
+			if id, ok := strings.CutPrefix(entitlement.Name, groupPrefix); ok {
+				if entitlement.RoleId, _ = strconv.ParseInt(id, 10, 64); entitlement.RoleId != 0 {
+					entitlement.Name = first(group.Get("description"))
+				}
+			}
+			for _, member := range group.Get("member") {
+				if i, ok := byDN[ldap.NormalizeDN(member)]; ok {
+					accounts[i].Entitlements = append(accounts[i].Entitlements, entitlement)
+				}
+			}
+		}
+		return nil
+	})
+	return accounts, err
+}
+
+// groupCn значение cn группы, совпадающее с её RDN в GroupDN
+func groupCn(entitlement Entitlement) string {
+	if entitlement.RoleId == 0 {
+		return entitlement.Name
+	}
+	return groupPrefix + strconv.FormatInt(entitlement.RoleId, 10)
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// removeMember удаляет участника группы. Группа groupOfNames не может быть пустой,
+// поэтому вместе с последним участником удаляется и она сама
+func (c *LdapConnector) removeMember(conn *ldap.Conn, group string, member string) error {
+	var err = conn.Modify(group, []ldap.Change{{
+		Operation: ldap.ModDelete,
+		Attribute: ldap.Attribute{Type: "member", Values: []string{member}},
+	}})
+	switch {
+	case ldap.IsCode(err, ldap.ResultNoSuchObject, ldap.ResultNoSuchAttribute):
+		return nil
+	case ldap.IsCode(err, ldap.ResultObjectClassViolation):
+		err = conn.Delete(group)
+		if ldap.IsCode(err, ldap.ResultNoSuchObject) {
+			return nil
+		}
+	}
+	return err
+}
+
+// modifyAccount заменяет атрибуты записи сотрудника и применяет дополнительные изменения extra
+func (c *LdapConnector) modifyAccount(conn *ldap.Conn, account Account, extra ...ldap.Change) error {
+	var changes []ldap.Change
+	for _, a := range c.accountAttributes(account) {
+		if a.Type == "objectClass" || a.Type == "uid" {
+			continue
+		}
+		changes = append(changes, ldap.Change{Operation: ldap.ModReplace, Attribute: a})
+	}
+	changes = append(changes, extra...)
+	return conn.Modify(c.AccountDN(account), changes)
+}
+
+// accountAttributes атрибуты inetOrgPerson; sn обязателен по схеме и заполняется последним словом имени
+func (c *LdapConnector) accountAttributes(account Account) []ldap.Attribute {
+	var id = strconv.FormatInt(account.EmployeeId, 10)
+	var name = strings.TrimSpace(account.Name)
+	if name == "" {
+		name = id
+	}
+	var words = strings.Fields(name)
+	return []ldap.Attribute{
+		{Type: "objectClass", Values: []string{"top", "person", "organizationalPerson", "inetOrgPerson"}},
+		{Type: "uid", Values: []string{id}},
+		{Type: "cn", Values: []string{name}},
+		{Type: "sn", Values: []string{words[len(words)-1]}},
+		{Type: "displayName", Values: []string{name}},
+		{Type: "employeeNumber", Values: []string{id}},
+	}
+}
+
+// do открывает соединение, выполняет bind и операцию fn.
+// Ошибки, которые не исправятся повтором (неверные данные, права, нарушение схемы), помечаются как постоянные
+func (c *LdapConnector) do(fn func(conn *ldap.Conn) error) error {
+	conn, err := ldap.Dial(c.url, c.timeout)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", c.url, err)
+	}
+	defer conn.Close()
+
+	if c.bindDN != "" {
+		if err := conn.Bind(c.bindDN, c.bindPassword); err != nil {
+			return classifyLdapError(fmt.Errorf("error binding as %s: %w", c.bindDN, err))
+		}
+	}
+	return classifyLdapError(fn(conn))
+}
+
+func classifyLdapError(err error) error {
+	var ldapErr *ldap.Error
+	if err == nil || !errors.As(err, &ldapErr) {
+		return err
+	}
+	switch ldapErr.Code {
+	case ldap.ResultInvalidDNSyntax, ldap.ResultInvalidCredentials, ldap.ResultInsufficientAccess,
+		ldap.ResultObjectClassViolation, ldap.ResultProtocolError:
+		return Permanent(err)
+	}
+	return err
+}
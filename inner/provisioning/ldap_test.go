@@ -0,0 +1,190 @@
+package provisioning
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/ldap/ldaptest"
+)
+
+const (
+	testBindDN   = "cn=admin,dc=example,dc=com"
+	testUsersDN  = "ou=people,dc=example,dc=com"
+	testGroupsDN = "ou=groups,dc=example,dc=com"
+)
+
+func newTestLdap(t *testing.T) (*ldaptest.Server, Connector) {
+	var server = ldaptest.NewServer(testBindDN, "secret")
+	t.Cleanup(server.Close)
+	server.Put("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	server.Put(testUsersDN, map[string][]string{"objectClass": {"organizationalUnit"}})
+	server.Put(testGroupsDN, map[string][]string{"objectClass": {"organizationalUnit"}})
+	var connector, err = NewLdapConnectorFromSettings(map[string]string{
+		"url":           server.URL(),
+		"bind_dn":       testBindDN,
+		"bind_password": "secret",
+		"users_dn":      testUsersDN,
+		"groups_dn":     testGroupsDN,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, connector
+}
+
+func TestLdapConnector(t *testing.T) {
+	var a = assert.New(t)
+	var john = Account{EmployeeId: 1, Name: "John Doe"}
+	var jane = Account{EmployeeId: 2, Name: "Jane Roe"}
+	var admins = Entitlement{RoleId: 10, Name: "Admins, EU"}
+	const johnDN = "uid=1," + testUsersDN
+	const janeDN = "uid=2," + testUsersDN
+	const adminsDN = "cn=role-10," + testGroupsDN
+
+	t.Run("should create and update inetOrgPerson entry", func(t *testing.T) {
+		var server, connector = newTestLdap(t)
+		a.Nil(connector.CreateAccount(john))
+		// повторное создание не ошибка
+		a.Nil(connector.CreateAccount(john))
+		a.Nil(connector.UpdateAccount(Account{EmployeeId: 1, Name: "John Deer"}))
+
+		var entry, ok = server.Get(johnDN)
+		a.True(ok)
+		a.Equal([]string{"top", "person", "organizationalPerson", "inetOrgPerson"}, entry["objectclass"])
+		a.Equal([]string{"John Deer"}, entry["cn"])
+		a.Equal([]string{"Deer"}, entry["sn"])
+		a.Equal([]string{"1"}, entry["uid"])
+	})
+
+	t.Run("should enable previously disabled entry on create", func(t *testing.T) {
+		var server, connector = newTestLdap(t)
+		a.Nil(connector.CreateAccount(john))
+		a.Nil(connector.DisableAccount(john))
+		a.Nil(connector.CreateAccount(john))
+
+		var entry, _ = server.Get(johnDN)
+		a.NotContains(entry, "pwdaccountlockedtime")
+		a.Equal([]string{"John Doe"}, entry["cn"])
+	})
+
+	t.Run("should maintain groupOfNames membership", func(t *testing.T) {
+		var server, connector = newTestLdap(t)
+		a.Nil(connector.CreateAccount(john))
+		a.Nil(connector.CreateAccount(jane))
+		a.Nil(connector.AddEntitlement(john, admins))
+		a.Nil(connector.AddEntitlement(jane, admins))
+		a.Nil(connector.AddEntitlement(jane, admins))
+
+		var group, ok = server.Get(adminsDN)
+		a.True(ok)
+		a.Equal([]string{"role-10"}, group["cn"])
+		a.Equal([]string{"Admins, EU"}, group["description"])
+		a.Equal([]string{johnDN, janeDN}, group["member"])
+
+		a.Nil(connector.RemoveEntitlement(john, admins))
+		a.Nil(connector.RemoveEntitlement(john, admins))
+		group, _ = server.Get(adminsDN)
+		a.Equal([]string{janeDN}, group["member"])
+
+		// группа не может остаться без участников и удаляется вместе с последним
+		a.Nil(connector.RemoveEntitlement(jane, admins))
+		_, ok = server.Get(adminsDN)
+		a.False(ok)
+	})
+
+	t.Run("should keep group of renamed role", func(t *testing.T) {
+		var server, connector = newTestLdap(t)
+		a.Nil(connector.CreateAccount(john))
+		a.Nil(connector.CreateAccount(jane))
+		a.Nil(connector.AddEntitlement(john, admins))
+
+		var renamed = Entitlement{RoleId: 10, Name: "Administrators"}
+		a.Nil(connector.AddEntitlement(jane, renamed))
+		var group, _ = server.Get(adminsDN)
+		a.Equal([]string{johnDN, janeDN}, group["member"])
+		a.Equal([]string{"Administrators"}, group["description"])
+
+		// повторное назначение тоже обновляет имя
+		a.Nil(connector.AddEntitlement(john, admins))
+		group, _ = server.Get(adminsDN)
+		a.Equal([]string{"Admins, EU"}, group["description"])
+
+		a.Nil(connector.RemoveEntitlement(john, renamed))
+		group, _ = server.Get(adminsDN)
+		a.Equal([]string{janeDN}, group["member"])
+	})
+
+	t.Run("should disable and delete account", func(t *testing.T) {
+		var server, connector = newTestLdap(t)
+		a.Nil(connector.CreateAccount(john))
+		a.Nil(connector.CreateAccount(jane))
+		a.Nil(connector.AddEntitlement(john, admins))
+		a.Nil(connector.AddEntitlement(jane, admins))
+
+		a.Nil(connector.DisableAccount(john))
+		var entry, _ = server.Get(johnDN)
+		a.Equal([]string{"000001010000Z"}, entry["pwdaccountlockedtime"])
+
+		a.Nil(connector.DeleteAccount(Account{EmployeeId: 1}))
+		a.Nil(connector.DeleteAccount(Account{EmployeeId: 1}))
+		_, ok := server.Get(johnDN)
+		a.False(ok)
+		group, _ := server.Get(adminsDN)
+		a.Equal([]string{janeDN}, group["member"])
+	})
+
+	t.Run("should list accounts with groups", func(t *testing.T) {
+		var server, connector = newTestLdap(t)
+		a.Nil(connector.CreateAccount(john))
+		a.Nil(connector.AddEntitlement(john, admins))
+		a.Nil(connector.DisableAccount(john))
+		// учётная запись, созданная в каталоге вручную
+		server.Put("uid=jsmith,"+testUsersDN, map[string][]string{
+			"objectClass": {"inetOrgPerson"}, "uid": {"jsmith"}, "cn": {"J Smith"}, "sn": {"Smith"},
+		})
+		// группа, созданная в каталоге вручную
+		server.Put("cn=VPN,"+testGroupsDN, map[string][]string{
+			"objectClass": {"groupOfNames"}, "cn": {"VPN"}, "member": {johnDN},
+		})
+
+		var accounts, err = connector.(Reader).ListAccounts()
+		a.Nil(err)
+		a.Equal([]TargetAccount{
+			{Account: Account{EmployeeId: 1, Name: "John Doe"}, Disabled: true, Entitlements: []Entitlement{{RoleId: 10, Name: "Admins, EU"}, {Name: "VPN"}}},
+			{Account: Account{Name: "J Smith"}},
+		}, accounts)
+
+		// вручную созданную группу можно убрать по имени
+		a.Nil(connector.RemoveEntitlement(john, Entitlement{Name: "VPN"}))
+		_, ok := server.Get("cn=VPN," + testGroupsDN)
+		a.False(ok)
+	})
+
+	t.Run("should report invalid credentials as permanent error", func(t *testing.T) {
+		var server, _ = newTestLdap(t)
+		var connector, err = NewLdapConnectorFromSettings(map[string]string{
+			"url":           server.URL(),
+			"bind_dn":       testBindDN,
+			"bind_password": "wrong",
+			"users_dn":      testUsersDN,
+			"groups_dn":     testGroupsDN,
+		})
+		a.Nil(err)
+		err = connector.CreateAccount(john)
+		a.ErrorAs(err, &PermanentError{})
+	})
+
+	t.Run("should retry when server is unreachable", func(t *testing.T) {
+		var server, connector = newTestLdap(t)
+		server.Close()
+		var err = connector.CreateAccount(john)
+		a.NotNil(err)
+		a.False(errors.As(err, &PermanentError{}))
+	})
+
+	t.Run("should require directory settings", func(t *testing.T) {
+		var _, err = NewLdapConnectorFromSettings(map[string]string{"url": "ldap://localhost"})
+		a.NotNil(err)
+	})
+}
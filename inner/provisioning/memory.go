@@ -0,0 +1,163 @@
+package provisioning
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+)
+
+// MemoryAccount состояние учётной записи в MemoryConnector
+type MemoryAccount struct {
+	Name         string           `json:"name"`
+	Disabled     bool             `json:"disabled"`
+	Entitlements map[int64]string `json:"entitlements"`
+}
+
+// MemoryConnector эталонный коннектор, который хранит учётные записи в памяти
+// и, если задан путь, сохраняет их в JSON файл после каждого изменения.
+// Используется в тестах и как образец для коннекторов к настоящим системам
+type MemoryConnector struct {
+	mu       sync.Mutex
+	path     string
+	accounts map[int64]*MemoryAccount
+}
+
+// NewMemoryConnector создаёт коннектор. Если файл path существует, состояние загружается из него
+func NewMemoryConnector(path string) (*MemoryConnector, error) {
+	var connector = &MemoryConnector{path: path, accounts: map[int64]*MemoryAccount{}}
+	if path == "" {
+		return connector, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return connector, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &connector.accounts); err != nil {
+		return nil, err
+	}
+	return connector, nil
+}
+
+// NewMemoryConnectorFromSettings фабрика для Registry, настройка "path" необязательна
+func NewMemoryConnectorFromSettings(settings map[string]string) (Connector, error) {
+	return NewMemoryConnector(settings["path"])
+}
+
+func (c *MemoryConnector) CreateAccount(account Account) error {
+	return c.change(func() {
+		c.account(account).Disabled = false
+	})
+}
+
+func (c *MemoryConnector) UpdateAccount(account Account) error {
+	return c.change(func() {
+		c.account(account)
+	})
+}
+
+func (c *MemoryConnector) DisableAccount(account Account) error {
+	return c.change(func() {
+		if existing, ok := c.accounts[account.EmployeeId]; ok {
+			existing.Disabled = true
+		}
+	})
+}
+
+func (c *MemoryConnector) DeleteAccount(account Account) error {
+	return c.change(func() {
+		delete(c.accounts, account.EmployeeId)
+	})
+}
+
+func (c *MemoryConnector) AddEntitlement(account Account, entitlement Entitlement) error {
+	return c.change(func() {
+		c.account(account).Entitlements[entitlement.RoleId] = entitlement.Name
+	})
+}
+
+func (c *MemoryConnector) RemoveEntitlement(account Account, entitlement Entitlement) error {
+	return c.change(func() {
+		if existing, ok := c.accounts[account.EmployeeId]; ok {
+			delete(existing.Entitlements, entitlement.RoleId)
+		}
+	})
+}
+
+// Accounts возвращает копию текущего состояния учётных записей
+func (c *MemoryConnector) Accounts() map[int64]MemoryAccount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var accounts = make(map[int64]MemoryAccount, len(c.accounts))
+	for id, a := range c.accounts {
+		var entitlements = make(map[int64]string, len(a.Entitlements))
+		for roleId, name := range a.Entitlements {
+			entitlements[roleId] = name
+		}
+		accounts[id] = MemoryAccount{Name: a.Name, Disabled: a.Disabled, Entitlements: entitlements}
+	}
+	return accounts
+}
+
+// ListAccounts возвращает учётные записи, упорядоченные по id сотрудника, с правами, упорядоченными по id роли
+func (c *MemoryConnector) ListAccounts() ([]TargetAccount, error) {
+	var accounts = c.Accounts()
+	var ids = make([]int64, 0, len(accounts))
+	for id := range accounts {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var result = make([]TargetAccount, 0, len(ids))
+	for _, id := range ids {
+		var a = accounts[id]
+		var target = TargetAccount{Account: Account{EmployeeId: id, Name: a.Name}, Disabled: a.Disabled}
+		var roleIds = make([]int64, 0, len(a.Entitlements))
+		for roleId := range a.Entitlements {
+			roleIds = append(roleIds, roleId)
+		}
+		slices.Sort(roleIds)
+		for _, roleId := range roleIds {
+			target.Entitlements = append(target.Entitlements, Entitlement{RoleId: roleId, Name: a.Entitlements[roleId]})
+		}
+		result = append(result, target)
+	}
+	return result, nil
+}
+
+// account возвращает учётную запись, создавая её при необходимости, и обновляет имя
+func (c *MemoryConnector) account(account Account) *MemoryAccount {
+	var existing, ok = c.accounts[account.EmployeeId]
+	if !ok {
+		existing = &MemoryAccount{Entitlements: map[int64]string{}}
+		c.accounts[account.EmployeeId] = existing
+	}
+	if account.Name != "" {
+		existing.Name = account.Name
+	}
+	return existing
+}
+
+// change применяет изменение под блокировкой и сохраняет состояние в файл
+func (c *MemoryConnector) change(apply func()) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	apply()
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	// пишем во временный файл и переименовываем, чтобы не оставить файл записанным наполовину
+	var tmp = c.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
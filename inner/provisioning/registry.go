@@ -0,0 +1,61 @@
+package provisioning
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Factory создаёт коннектор по его настройкам из ConnectorConfig.Settings
+type Factory func(settings map[string]string) (Connector, error)
+
+// Registry реестр видов коннекторов: по значению kind из конфигурации выбирается фабрика
+type Registry struct {
+	factories map[string]Factory
+}
+
+// NewRegistry создаёт реестр со встроенными коннекторами "memory" и "ldap"
+func NewRegistry() *Registry {
+	var registry = &Registry{factories: map[string]Factory{}}
+	registry.Register("memory", NewMemoryConnectorFromSettings)
+	registry.Register("ldap", NewLdapConnectorFromSettings)
+	return registry
+}
+
+func (r *Registry) Register(kind string, factory Factory) {
+	r.factories[kind] = factory
+}
+
+func (r *Registry) Kinds() []string {
+	var kinds = make([]string, 0, len(r.factories))
+	for kind := range r.factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Build создаёт коннекторы по конфигурации и диспетчер, который рассылает им события.
+// Отключённые коннекторы пропускаются
+func (r *Registry) Build(cfg Config) (*Dispatcher, error) {
+	var dispatcher = NewDispatcher()
+	var names = map[string]bool{}
+	for _, c := range cfg.Connectors {
+		if c.Disabled {
+			continue
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate provisioning connector %q", c.Name)
+		}
+		names[c.Name] = true
+		factory, ok := r.factories[c.Kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown provisioning connector kind %q for %q", c.Kind, c.Name)
+		}
+		connector, err := factory(c.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("error creating provisioning connector %q: %w", c.Name, err)
+		}
+		dispatcher.Add(c, connector)
+	}
+	return dispatcher, nil
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Заголовки лимита по черновику IETF "RateLimit header fields for HTTP"
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRateLimitPolicy    = "RateLimit-Policy"
+)
+
+// New создаёт middleware, которое ограничивает частоту запросов токен-бакетом по правилам rules.
+// Бакет свой у каждого клиента в каждом правиле. Клиент — API-ключ или сотрудник из auth.Principal,
+// поэтому по ним лимит считается, только если middleware стоит после auth.NewMiddleware; иначе — IP-адрес.
+// Ошибка хранилища не блокирует запросы, а только пишется в лог
+func New(store Store, rules []Rule) fiber.Handler {
+	return newMiddleware(store, rules, time.Now)
+}
+
+func newMiddleware(store Store, rules []Rule, now func() time.Time) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		var rule = match(rules, ctx.Method(), ctx.Path())
+		if rule == nil {
+			return ctx.Next()
+		}
+		tokens, allowed, err := store.Take(rule.Name()+"|"+clientKey(ctx), rule.Limit, now())
+		if err != nil {
+			log.Printf("ratelimit: error taking token for %s: %v", rule.Name(), err)
+			return ctx.Next()
+		}
+
+		var limit = rule.Limit
+		ctx.Set(HeaderRateLimitLimit, strconv.Itoa(limit.Burst))
+		ctx.Set(HeaderRateLimitRemaining, strconv.Itoa(int(math.Floor(tokens))))
+		ctx.Set(HeaderRateLimitReset, seconds((float64(limit.Burst)-tokens)/limit.Rate))
+		ctx.Set(HeaderRateLimitPolicy, strconv.Itoa(int(math.Round(limit.Rate*rule.Window.Seconds())))+
+			";w="+strconv.Itoa(int(rule.Window.Seconds()))+";burst="+strconv.Itoa(limit.Burst))
+		if !allowed {
+			ctx.Set(fiber.HeaderRetryAfter, seconds((1-tokens)/limit.Rate))
+			return common.ErrResponse(ctx, fiber.StatusTooManyRequests, "rate limit exceeded for "+rule.Name())
+		}
+		return ctx.Next()
+	}
+}
+
+// Schedule каждые interval удаляет простаивающие бакеты, пока не отменён ctx.
+// Бакет простаивает, если за время наполнения самого медленного правила к нему не обращались
+func Schedule(ctx context.Context, store Store, rules []Rule, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	var idle time.Duration
+	for _, rule := range rules {
+		idle = max(idle, rule.Limit.fillTime())
+	}
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deleted, err := store.DeleteIdle(time.Now().Add(-idle))
+				if err != nil {
+					log.Printf("ratelimit: cleanup failed: %v", err)
+					continue
+				}
+				if deleted > 0 {
+					log.Printf("ratelimit: %d idle buckets deleted", deleted)
+				}
+			}
+		}
+	}()
+}
+
+// clientKey клиент запроса: API-ключ, сотрудник по токену или IP-адрес
+func clientKey(ctx *fiber.Ctx) string {
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		switch {
+		case principal.ApiKeyId != 0:
+			return "apikey:" + strconv.FormatInt(principal.ApiKeyId, 10)
+		case principal.EmployeeId != 0:
+			return "employee:" + strconv.FormatInt(principal.EmployeeId, 10)
+		}
+	}
+	return "ip:" + ctx.IP()
+}
+
+// seconds целое число секунд с округлением вверх, как в Retry-After
+func seconds(value float64) string {
+	return strconv.Itoa(int(math.Ceil(max(value, 0))))
+}
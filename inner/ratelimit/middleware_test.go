@@ -0,0 +1,194 @@
+package ratelimit
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// FailingStore хранилище, которое всегда отвечает ошибкой
+type FailingStore struct{}
+
+func (FailingStore) Take(string, Limit, time.Time) (float64, bool, error) {
+	return 0, false, errors.New("connection refused")
+}
+
+func (FailingStore) DeleteIdle(time.Time) (int64, error) {
+	return 0, nil
+}
+
+// newTestApp приложение с лимитом; principal, если задан, кладётся в запрос вместо auth.NewMiddleware
+func newTestApp(store Store, spec string, now *time.Time, principal *auth.Principal) *fiber.App {
+	rules, err := ParseRules(spec)
+	if err != nil {
+		panic(err)
+	}
+	var app = fiber.New()
+	if principal != nil {
+		app.Use(auth.NewMiddleware(nil, stubApiKeys{principal: *principal}, nil))
+	}
+	app.Use(newMiddleware(store, rules, func() time.Time { return *now }))
+	var handler = func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+	app.Get("/api/v1/employees", handler)
+	app.Get("/api/v1/employees/:id", handler)
+	app.Get("/api/v1/roles", handler)
+	return app
+}
+
+type stubApiKeys struct {
+	principal auth.Principal
+}
+
+func (s stubApiKeys) Authenticate(key string, clientIp string) (auth.Principal, error) {
+	var principal = s.principal
+	principal.ApiKeyId = map[string]int64{"key-1": 1, "key-2": 2}[key]
+	principal.Scopes = []string{"employees:read", "roles:read"}
+	return principal, nil
+}
+
+func get(app *fiber.App, path string, apiKey string) *http.Response {
+	var req = httptest.NewRequest(fiber.MethodGet, path, nil)
+	if apiKey != "" {
+		req.Header.Set(fiber.HeaderAuthorization, auth.SchemeApiKey+" "+apiKey)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		panic(err)
+	}
+	return resp
+}
+
+func TestParseRules(t *testing.T) {
+	var a = assert.New(t)
+
+	rules, err := ParseRules(common.DefaultRateLimits)
+	a.Nil(err)
+	a.Len(rules, 3)
+	a.Equal(Rule{Method: "GET", Path: "/api/v1/employees", Limit: Limit{Rate: 1, Burst: 20}, Window: time.Minute}, rules[1])
+	a.Equal(600, rules[0].Limit.Burst)
+
+	rules, err = ParseRules("")
+	a.Nil(err)
+	a.Empty(rules)
+
+	for _, spec := range []string{
+		"*=10",
+		"*=10/d",
+		"*=0/m",
+		"*=10/m:0",
+		"employees=10/m",
+		"GET PUT /x=10/m",
+		"*=10/m,*=20/m",
+	} {
+		_, err = ParseRules(spec)
+		a.NotNil(err, spec)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	var a = assert.New(t)
+	rules, err := ParseRules("*=100/m,/api/v1/*=50/m,GET /api/v1/*=40/m,GET /api/v1/employees=10/m")
+	a.Nil(err)
+
+	a.Equal("GET /api/v1/employees", match(rules, "GET", "/api/v1/employees").Name())
+	a.Equal("GET /api/v1/*", match(rules, "GET", "/api/v1/employees/1").Name())
+	a.Equal("/api/v1/*", match(rules, "POST", "/api/v1/employees").Name())
+	a.Equal("*", match(rules, "GET", "/scim/v2/Users").Name())
+	// fiber не различает регистр и завершающий "/", поэтому и лимит не должен
+	a.Equal("GET /api/v1/employees", match(rules, "GET", "/api/v1/employees/").Name())
+	a.Equal("GET /api/v1/employees", match(rules, "GET", "/API/v1/Employees").Name())
+	a.Equal("GET /api/v1/*", match(rules, "GET", "/Api/V1/employees/1/").Name())
+
+	rules, err = ParseRules("GET /API/v1/Employees/=10/m,/Scim/*=5/m")
+	a.Nil(err)
+	a.Equal("GET /api/v1/employees", match(rules, "GET", "/api/v1/employees").Name())
+	a.Equal("/scim/*", match(rules, "GET", "/scim/v2/Users").Name())
+
+	rules, err = ParseRules("GET /api/v1/employees=10/m")
+	a.Nil(err)
+	a.Nil(match(rules, "GET", "/api/v1/roles"))
+}
+
+func TestMemoryStore(t *testing.T) {
+	var a = assert.New(t)
+	var store = NewMemoryStore()
+	var limit = Limit{Rate: 2, Burst: 2}
+	var now = time.Date(2025, 12, 24, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		_, allowed, err := store.Take("k", limit, now)
+		a.Nil(err)
+		a.True(allowed)
+	}
+	tokens, allowed, _ := store.Take("k", limit, now)
+	a.False(allowed)
+	a.Equal(0.0, tokens)
+
+	// два токена в секунду: через полсекунды появляется один
+	_, allowed, _ = store.Take("k", limit, now.Add(500*time.Millisecond))
+	a.True(allowed)
+	// бакет не переполняется сверх Burst
+	tokens, _, _ = store.Take("k", limit, now.Add(time.Hour))
+	a.Equal(1.0, tokens)
+
+	deleted, _ := store.DeleteIdle(now.Add(2 * time.Hour))
+	a.Equal(int64(1), deleted)
+}
+
+func TestMiddleware(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should limit by ip and set headers", func(t *testing.T) {
+		var now = time.Date(2025, 12, 24, 12, 0, 0, 0, time.UTC)
+		var app = newTestApp(NewMemoryStore(), "GET /api/v1/employees=60/m:2", &now, nil)
+
+		var resp = get(app, "/api/v1/employees", "")
+		a.Equal(fiber.StatusOK, resp.StatusCode)
+		a.Equal("2", resp.Header.Get(HeaderRateLimitLimit))
+		a.Equal("1", resp.Header.Get(HeaderRateLimitRemaining))
+		a.Equal("1", resp.Header.Get(HeaderRateLimitReset))
+		a.Equal("60;w=60;burst=2", resp.Header.Get(HeaderRateLimitPolicy))
+
+		a.Equal(fiber.StatusOK, get(app, "/api/v1/employees", "").StatusCode)
+		resp = get(app, "/api/v1/employees", "")
+		a.Equal(fiber.StatusTooManyRequests, resp.StatusCode)
+		a.Equal("1", resp.Header.Get(fiber.HeaderRetryAfter))
+		a.Equal("0", resp.Header.Get(HeaderRateLimitRemaining))
+
+		// у маршрутов без правила лимита нет
+		resp = get(app, "/api/v1/employees/1", "")
+		a.Equal(fiber.StatusOK, resp.StatusCode)
+		a.Empty(resp.Header.Get(HeaderRateLimitLimit))
+
+		now = now.Add(time.Second)
+		a.Equal(fiber.StatusOK, get(app, "/api/v1/employees", "").StatusCode)
+		// другой регистр и завершающий "/" ведут на тот же маршрут и тратят тот же бюджет
+		a.Equal(fiber.StatusTooManyRequests, get(app, "/api/v1/employees/", "").StatusCode)
+		a.Equal(fiber.StatusTooManyRequests, get(app, "/API/v1/Employees", "").StatusCode)
+	})
+
+	t.Run("should keep separate budgets per api key", func(t *testing.T) {
+		var now = time.Date(2025, 12, 24, 12, 0, 0, 0, time.UTC)
+		var app = newTestApp(NewMemoryStore(), "*=1/m", &now, &auth.Principal{ServiceAccountId: 5})
+
+		a.Equal(fiber.StatusOK, get(app, "/api/v1/employees", "key-1").StatusCode)
+		a.Equal(fiber.StatusTooManyRequests, get(app, "/api/v1/employees", "key-1").StatusCode)
+		a.Equal(fiber.StatusOK, get(app, "/api/v1/employees", "key-2").StatusCode)
+		// правило по умолчанию — один бюджет на все маршруты
+		a.Equal(fiber.StatusTooManyRequests, get(app, "/api/v1/roles", "key-1").StatusCode)
+	})
+
+	t.Run("should let requests through when store fails", func(t *testing.T) {
+		var now = time.Now()
+		var app = newTestApp(FailingStore{}, "*=1/m", &now, nil)
+		a.Equal(fiber.StatusOK, get(app, "/api/v1/employees", "").StatusCode)
+	})
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// Repository бакеты в Postgres, общие для всех экземпляров IdM
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// refilled остаток бакета после пополнения; считается по заблокированной строке, а не по снимку до вставки
+const refilled = `LEAST($2::float8, b.tokens + GREATEST(EXTRACT(EPOCH FROM (EXCLUDED.updated_at - b.updated_at))::float8, 0) * $3::float8)`
+
+// Take пополняет бакет и забирает токен одним запросом, поэтому параллельные запросы
+// разных экземпляров не тратят один и тот же токен дважды
+func (r *Repository) Take(key string, limit Limit, now time.Time) (tokens float64, allowed bool, err error) {
+	query := `INSERT INTO rate_limit_bucket AS b (key, tokens, allowed, updated_at)
+              VALUES ($1, $2::float8 - 1, TRUE, $4)
+              ON CONFLICT (key) DO UPDATE SET
+                  tokens = CASE WHEN ` + refilled + ` >= 1 THEN ` + refilled + ` - 1 ELSE ` + refilled + ` END,
+                  allowed = ` + refilled + ` >= 1,
+                  updated_at = GREATEST(b.updated_at, EXCLUDED.updated_at)
+              RETURNING tokens, allowed`
+	var row struct {
+		Tokens  float64 `db:"tokens"`
+		Allowed bool    `db:"allowed"`
+	}
+	err = r.db.Get(&row, query, key, float64(limit.Burst), limit.Rate, now)
+	return row.Tokens, row.Allowed, err
+}
+
+func (r *Repository) DeleteIdle(before time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM rate_limit_bucket WHERE updated_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
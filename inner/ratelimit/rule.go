@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnyPath путь правила по умолчанию, которое действует на все маршруты без своего правила
+const AnyPath = "*"
+
+// Limit бюджет токен-бакета: Burst запросов подряд, дальше Rate запросов в секунду
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Rule бюджет для маршрута. Пустой Method — любой метод; Path — точный путь,
+// путь с "/*" в конце — все вложенные пути, AnyPath — все маршруты. Пути сравниваются так же, как их
+// сопоставляет fiber: без учёта регистра и завершающего "/"
+type Rule struct {
+	Method string
+	Path   string
+	Limit  Limit
+	// Window окно, в котором указан бюджет; нужно только для заголовка RateLimit-Policy
+	Window time.Duration
+}
+
+var units = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// ParseRules разбирает правила через запятую вида "[МЕТОД ]ПУТЬ=ЧИСЛО/ЕДИНИЦА[:ВСПЛЕСК]", например
+// "*=600/m,GET /api/v1/employees=60/m:20,POST /api/v1/auth/*=10/m". Единица — s, m или h; без всплеска он равен числу
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		route, budget, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q: expected route=budget", entry)
+		}
+		var rule Rule
+		var fields = strings.Fields(route)
+		switch len(fields) {
+		case 1:
+			rule.Path = fields[0]
+		case 2:
+			rule.Method, rule.Path = strings.ToUpper(fields[0]), fields[1]
+		default:
+			return nil, fmt.Errorf("rate limit %q: expected [METHOD ]PATH", entry)
+		}
+		if rule.Path != AnyPath && !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("rate limit %q: path must start with / or be *", entry)
+		}
+		if rule.Path != AnyPath {
+			rule.Path = normalizePath(rule.Path)
+		}
+
+		count, rest, ok := strings.Cut(strings.TrimSpace(budget), "/")
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q: expected COUNT/UNIT", entry)
+		}
+		unit, burst, hasBurst := strings.Cut(rest, ":")
+		requests, err := strconv.Atoi(count)
+		if err != nil || requests <= 0 {
+			return nil, fmt.Errorf("rate limit %q: count must be a positive number", entry)
+		}
+		if rule.Window, ok = units[unit]; !ok {
+			return nil, fmt.Errorf("rate limit %q: unit must be s, m or h", entry)
+		}
+		rule.Limit = Limit{Rate: float64(requests) / rule.Window.Seconds(), Burst: requests}
+		if hasBurst {
+			if rule.Limit.Burst, err = strconv.Atoi(burst); err != nil || rule.Limit.Burst <= 0 {
+				return nil, fmt.Errorf("rate limit %q: burst must be a positive number", entry)
+			}
+		}
+		for _, other := range rules {
+			if other.Method == rule.Method && other.Path == rule.Path {
+				return nil, fmt.Errorf("rate limit %q: route is listed twice", entry)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Name маршрут правила в том виде, в каком он задан; служит частью ключа бакета
+func (r Rule) Name() string {
+	if r.Method == "" {
+		return r.Path
+	}
+	return r.Method + " " + r.Path
+}
+
+// match подбирает самое точное правило: точный путь важнее префикса, длинный префикс важнее короткого,
+// правило для метода важнее правила для любого метода. nil, если ни одно правило не подходит
+func match(rules []Rule, method string, path string) *Rule {
+	var best *Rule
+	var bestScore = -1
+	path = normalizePath(path)
+	for i := range rules {
+		var rule = &rules[i]
+		if rule.Method != "" && rule.Method != method {
+			continue
+		}
+		var score int
+		switch {
+		case rule.Path == AnyPath:
+			score = 0
+		case normalizePath(rule.Path) == path:
+			score = math.MaxInt32
+		case strings.HasSuffix(rule.Path, "/*") && strings.HasPrefix(path, strings.ToLower(strings.TrimSuffix(rule.Path, "*"))):
+			score = len(rule.Path)
+		default:
+			continue
+		}
+		score *= 2
+		if rule.Method != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = rule, score
+		}
+	}
+	return best
+}
+
+// normalizePath приводит путь к виду, в котором его сопоставляет fiber по умолчанию:
+// нижний регистр, без завершающего "/". Иначе /API/v1/Employees/ обходил бы правило для /api/v1/employees.
+// Путь с "/*" в конце не меняется, кроме регистра
+func normalizePath(path string) string {
+	path = strings.ToLower(path)
+	if strings.HasSuffix(path, "/*") {
+		return path
+	}
+	if path = strings.TrimRight(path, "/"); path == "" {
+		return "/"
+	}
+	return path
+}
+
+// refill пополняет бакет за время с updatedAt и забирает из него токен, если он есть
+func (l Limit) refill(tokens float64, updatedAt time.Time, now time.Time) (float64, bool) {
+	var elapsed = max(now.Sub(updatedAt).Seconds(), 0)
+	tokens = min(float64(l.Burst), tokens+elapsed*l.Rate)
+	if tokens < 1 {
+		return tokens, false
+	}
+	return tokens - 1, true
+}
+
+// fillTime сколько пустой бакет наполняется до Burst; через это время простаивающий бакет можно удалить
+func (l Limit) fillTime() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
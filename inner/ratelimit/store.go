@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store хранит токен-бакеты клиентов
+type Store interface {
+	// Take пополняет бакет и забирает из него токен. Возвращает остаток и был ли токен
+	Take(key string, limit Limit, now time.Time) (tokens float64, allowed bool, err error)
+	// DeleteIdle удаляет бакеты, к которым не обращались с before; такие бакеты уже полны
+	DeleteIdle(before time.Time) (int64, error)
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryStore бакеты в памяти процесса; у каждого экземпляра IdM свои бюджеты
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b, ok = s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+	var tokens, allowed = limit.refill(b.tokens, b.updatedAt, now)
+	b.tokens = tokens
+	if now.After(b.updatedAt) {
+		b.updatedAt = now
+	}
+	return tokens, allowed, nil
+}
+
+func (s *MemoryStore) DeleteIdle(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted int64
+	for key, b := range s.buckets {
+		if b.updatedAt.Before(before) {
+			delete(s.buckets, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
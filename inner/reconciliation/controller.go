@@ -0,0 +1,98 @@
+package reconciliation
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+)
+
+type Controller struct {
+	server                *web.Server
+	reconciliationService Svc
+}
+
+// интерфейс сервиса reconciliation.Service
+type Svc interface {
+	Run(request RunRequest) (ReportResponse, error)
+	FindReports() ([]ReportResponse, error)
+	FindReportById(id int64) (ReportResponse, error)
+}
+
+func NewController(server *web.Server, reconciliationService Svc) *Controller {
+	return &Controller{
+		server:                server,
+		reconciliationService: reconciliationService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/reconciliations", c.Run)
+	c.server.GroupApiV1.Get("/reconciliations", c.FindReports)
+	c.server.GroupApiV1.Get("/reconciliations/:id", c.FindReportById)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/reconciliations", запускает сверку сразу
+func (c *Controller) Run(ctx *fiber.Ctx) error {
+	var request RunRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	var report, err = c.reconciliationService.Run(request)
+	if err != nil {
+		switch {
+		case errors.As(err, &common.RequestValidationError{}):
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		case errors.As(err, &common.NotFoundError{}):
+			return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if err = common.OkResponse(ctx, report); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning reconciliation report")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindReports(ctx *fiber.Ctx) error {
+	var reports, err = c.reconciliationService.FindReports()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, reports); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get reconciliation reports")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindReportById(ctx *fiber.Ctx) error {
+	idStr := ctx.Params("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	report, err := c.reconciliationService.FindReportById(id)
+	if err != nil {
+		switch {
+		case errors.As(err, &common.NotFoundError{}):
+			return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if err = common.OkResponse(ctx, report); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get reconciliation report by id")
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package reconciliation
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Виды расхождений между IdM и внешней системой
+const (
+	// учётная запись есть во внешней системе, но такого сотрудника нет
+	KindOrphanAccount = "orphan_account"
+	// сотрудник есть, а учётной записи во внешней системе нет
+	KindMissingAccount = "missing_account"
+	// во внешней системе у сотрудника есть право, роль для которого ему не назначена
+	KindExtraEntitlement = "extra_entitlement"
+	// роль назначена, а соответствующего права во внешней системе нет
+	KindMissingEntitlement = "missing_entitlement"
+)
+
+// Результаты обработки расхождения
+const (
+	OutcomeReported   = "reported"
+	OutcomeRemediated = "remediated"
+	OutcomeFailed     = "failed"
+	OutcomeSkipped    = "skipped"
+)
+
+// ReportEntity отчёт об одной сверке
+type ReportEntity struct {
+	Id            int64     `db:"id"`
+	Connector     string    `db:"connector"`
+	StartedAt     time.Time `db:"started_at"`
+	FinishedAt    time.Time `db:"finished_at"`
+	Accounts      int       `db:"accounts"`
+	Discrepancies int       `db:"discrepancies"`
+}
+
+// DiscrepancyEntity расхождение, найденное сверкой, и что с ним было сделано
+type DiscrepancyEntity struct {
+	Id              int64  `db:"id"`
+	ReportId        int64  `db:"report_id"`
+	Kind            string `db:"kind"`
+	EmployeeId      int64  `db:"employee_id"`
+	AccountName     string `db:"account_name"`
+	RoleId          int64  `db:"role_id"`
+	EntitlementName string `db:"entitlement_name"`
+	Action          string `db:"action"`
+	Outcome         string `db:"outcome"`
+	Error           string `db:"error"`
+}
+
+// ExpectedEntity сотрудник и одна из назначенных ему ролей; у сотрудника без ролей RoleId не задан
+type ExpectedEntity struct {
+	EmployeeId   int64          `db:"employee_id"`
+	EmployeeName string         `db:"employee_name"`
+	RoleId       sql.NullInt64  `db:"role_id"`
+	RoleName     sql.NullString `db:"role_name"`
+}
+
+type ReportResponse struct {
+	Id            int64                 `json:"id"`
+	Connector     string                `json:"connector"`
+	StartedAt     time.Time             `json:"started_at"`
+	FinishedAt    time.Time             `json:"finished_at"`
+	Accounts      int                   `json:"accounts"`
+	Discrepancies []DiscrepancyResponse `json:"discrepancies,omitempty"`
+	Total         int                   `json:"total_discrepancies"`
+}
+
+type DiscrepancyResponse struct {
+	Kind            string `json:"kind"`
+	EmployeeId      int64  `json:"employee_id,omitempty"`
+	AccountName     string `json:"account_name,omitempty"`
+	RoleId          int64  `json:"role_id,omitempty"`
+	EntitlementName string `json:"entitlement_name,omitempty"`
+	Action          string `json:"action"`
+	Outcome         string `json:"outcome"`
+	Error           string `json:"error,omitempty"`
+}
+
+func (e *ReportEntity) toResponse(discrepancies []DiscrepancyEntity) ReportResponse {
+	var resp = ReportResponse{
+		Id:         e.Id,
+		Connector:  e.Connector,
+		StartedAt:  e.StartedAt,
+		FinishedAt: e.FinishedAt,
+		Accounts:   e.Accounts,
+		Total:      e.Discrepancies,
+	}
+	for _, d := range discrepancies {
+		resp.Discrepancies = append(resp.Discrepancies, d.toResponse())
+	}
+	return resp
+}
+
+func (d *DiscrepancyEntity) toResponse() DiscrepancyResponse {
+	return DiscrepancyResponse{
+		Kind:            d.Kind,
+		EmployeeId:      d.EmployeeId,
+		AccountName:     d.AccountName,
+		RoleId:          d.RoleId,
+		EntitlementName: d.EntitlementName,
+		Action:          d.Action,
+		Outcome:         d.Outcome,
+		Error:           d.Error,
+	}
+}
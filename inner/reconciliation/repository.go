@@ -0,0 +1,91 @@
+package reconciliation
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+// FindExpected возвращает всех сотрудников с назначенными ролями: по строке на назначение
+// и одну строку без роли для сотрудника, у которого ролей нет
+func (r *Repository) FindExpected() (expected []ExpectedEntity, err error) {
+	query := `SELECT e.id AS employee_id, e.name AS employee_name, r.id AS role_id, r.name AS role_name
+              FROM employee e
+              LEFT JOIN effective_employee_role er ON er.employee_id = e.id
+              LEFT JOIN role r ON r.id = er.role_id
+              ORDER BY e.id, r.id`
+	err = r.db.Select(&expected, query)
+	if err != nil {
+		return nil, err
+	}
+	return expected, nil
+}
+
+// CreateReport сохраняет отчёт вместе с расхождениями в одной транзакции и заполняет их id
+func (r *Repository) CreateReport(report *ReportEntity, discrepancies []DiscrepancyEntity) (err error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	query := `INSERT INTO reconciliation_report (connector, started_at, finished_at, accounts, discrepancies)
+              VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err = tx.Get(&report.Id, query, report.Connector, report.StartedAt, report.FinishedAt, report.Accounts, report.Discrepancies)
+	if err != nil {
+		return err
+	}
+	for i := range discrepancies {
+		discrepancies[i].ReportId = report.Id
+		rows, err := tx.NamedQuery(`
+			INSERT INTO reconciliation_discrepancy
+			    (report_id, kind, employee_id, account_name, role_id, entitlement_name, action, outcome, error)
+			VALUES (:report_id, :kind, :employee_id, :account_name, :role_id, :entitlement_name, :action, :outcome, :error)
+			RETURNING id`, discrepancies[i])
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&discrepancies[i].Id)
+		}
+		_ = rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) FindReports() (reports []ReportEntity, err error) {
+	err = r.db.Select(&reports, "SELECT * FROM reconciliation_report ORDER BY started_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func (r *Repository) FindReportById(id int64) (report ReportEntity, err error) {
+	err = r.db.Get(&report, "SELECT * FROM reconciliation_report WHERE id = $1", id)
+	return
+}
+
+func (r *Repository) FindDiscrepancies(reportId int64) (discrepancies []DiscrepancyEntity, err error) {
+	query := "SELECT * FROM reconciliation_discrepancy WHERE report_id = $1 ORDER BY id"
+	err = r.db.Select(&discrepancies, query, reportId)
+	if err != nil {
+		return nil, err
+	}
+	return discrepancies, nil
+}
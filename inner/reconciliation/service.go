@@ -0,0 +1,306 @@
+package reconciliation
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/provisioning"
+)
+
+// Структура сервиса, которая сверяет состояние внешних систем с сотрудниками и их ролями
+type Service struct {
+	repo      Repo
+	validator Validator
+	targets   Targets
+	// now подменяется в тестах
+	now func() time.Time
+}
+
+type Repo interface {
+	FindExpected() ([]ExpectedEntity, error)
+	CreateReport(*ReportEntity, []DiscrepancyEntity) error
+	FindReports() ([]ReportEntity, error)
+	FindReportById(int64) (ReportEntity, error)
+	FindDiscrepancies(int64) ([]DiscrepancyEntity, error)
+}
+
+// Targets коннекторы провижининга, например provisioning.Dispatcher
+type Targets interface {
+	Targets() []provisioning.Target
+	Target(name string) (provisioning.Target, bool)
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+type RunRequest struct {
+	Connector string `json:"connector" validate:"required"`
+}
+
+func NewService(repo Repo, validator Validator, targets Targets) *Service {
+	return &Service{
+		repo:      repo,
+		validator: validator,
+		targets:   targets,
+		now:       time.Now,
+	}
+}
+
+// Run сверяет один коннектор: читает учётные записи из внешней системы, сравнивает их
+// с сотрудниками и назначениями ролей, исправляет расхождения согласно политике коннектора
+// и сохраняет отчёт
+func (srv *Service) Run(request RunRequest) (ReportResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return ReportResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	var target, ok = srv.targets.Target(request.Connector)
+	if !ok {
+		return ReportResponse{}, common.NotFoundError{Message: fmt.Sprintf("connector %s not found", request.Connector)}
+	}
+	reader, ok := target.Connector.(provisioning.Reader)
+	if !ok {
+		return ReportResponse{}, common.RequestValidationError{
+			Message: fmt.Sprintf("connector %s does not support reading accounts", request.Connector),
+		}
+	}
+
+	var report = ReportEntity{Connector: request.Connector, StartedAt: srv.now()}
+	expected, err := srv.repo.FindExpected()
+	if err != nil {
+		return ReportResponse{}, fmt.Errorf("error get employees and role assignments: %w", err)
+	}
+	actual, err := reader.ListAccounts()
+	if err != nil {
+		return ReportResponse{}, fmt.Errorf("error reading accounts from connector %s: %w", request.Connector, err)
+	}
+
+	var discrepancies = compare(expected, actual)
+	for i := range discrepancies {
+		remediate(target, &discrepancies[i])
+	}
+	report.FinishedAt = srv.now()
+	report.Accounts = len(actual)
+	report.Discrepancies = len(discrepancies)
+	if err = srv.repo.CreateReport(&report, discrepancies); err != nil {
+		return ReportResponse{}, fmt.Errorf("error saving reconciliation report: %w", err)
+	}
+
+	return report.toResponse(discrepancies), nil
+}
+
+func (srv *Service) FindReports() ([]ReportResponse, error) {
+	var reports, err = srv.repo.FindReports()
+	if err != nil {
+		return []ReportResponse{}, fmt.Errorf("error get reconciliation reports: %w", err)
+	}
+
+	var resp = []ReportResponse{}
+	for _, r := range reports {
+		resp = append(resp, r.toResponse(nil))
+	}
+	return resp, nil
+}
+
+// FindReportById возвращает отчёт вместе со всеми расхождениями
+func (srv *Service) FindReportById(id int64) (ReportResponse, error) {
+	var report, err = srv.repo.FindReportById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ReportResponse{}, common.NotFoundError{Message: fmt.Sprintf("reconciliation report with id %d not found", id)}
+	}
+	if err != nil {
+		return ReportResponse{}, fmt.Errorf("error finding reconciliation report with id %d: %w", id, err)
+	}
+	discrepancies, err := srv.repo.FindDiscrepancies(id)
+	if err != nil {
+		return ReportResponse{}, fmt.Errorf("error get discrepancies of reconciliation report with id %d: %w", id, err)
+	}
+
+	return report.toResponse(discrepancies), nil
+}
+
+// Schedule запускает сверку по расписанию для коннекторов, у которых задан reconcile.interval.
+// Сверки останавливаются вместе с ctx
+func (srv *Service) Schedule(ctx context.Context) {
+	for _, target := range srv.targets.Targets() {
+		var interval = time.Duration(target.Config.Reconcile.Interval)
+		if interval <= 0 {
+			continue
+		}
+		go func(name string) {
+			var ticker = time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					report, err := srv.Run(RunRequest{Connector: name})
+					if err != nil {
+						log.Printf("reconciliation: connector %s failed: %v", name, err)
+						continue
+					}
+					log.Printf("reconciliation: connector %s, report %d, %d discrepancies", name, report.Id, report.Total)
+				}
+			}
+		}(target.Config.Name)
+	}
+}
+
+type expectedAccount struct {
+	name  string
+	roles map[string]provisioning.Entitlement
+}
+
+// compare находит расхождения. Права сравниваются по id роли, если внешняя система его хранит,
+// иначе по имени без учёта регистра, потому что внешние системы часто знают только имя группы
+func compare(expected []ExpectedEntity, actual []provisioning.TargetAccount) []DiscrepancyEntity {
+	var accounts = map[int64]*expectedAccount{}
+	var order []int64
+	for _, e := range expected {
+		var account, ok = accounts[e.EmployeeId]
+		if !ok {
+			account = &expectedAccount{name: e.EmployeeName, roles: map[string]provisioning.Entitlement{}}
+			accounts[e.EmployeeId] = account
+			order = append(order, e.EmployeeId)
+		}
+		if e.RoleId.Valid {
+			account.roles[strings.ToLower(e.RoleName.String)] = provisioning.Entitlement{RoleId: e.RoleId.Int64, Name: e.RoleName.String}
+		}
+	}
+
+	var discrepancies []DiscrepancyEntity
+	var seen = map[int64]bool{}
+	for _, a := range actual {
+		var account, ok = accounts[a.Account.EmployeeId]
+		if !ok {
+			discrepancies = append(discrepancies, DiscrepancyEntity{
+				Kind:        KindOrphanAccount,
+				EmployeeId:  a.Account.EmployeeId,
+				AccountName: a.Account.Name,
+			})
+			continue
+		}
+		seen[a.Account.EmployeeId] = true
+		var granted = map[string]bool{}
+		for _, entitlement := range a.Entitlements {
+			var key = account.key(entitlement)
+			granted[key] = true
+			if _, ok := account.roles[key]; !ok {
+				discrepancies = append(discrepancies, DiscrepancyEntity{
+					Kind:            KindExtraEntitlement,
+					EmployeeId:      a.Account.EmployeeId,
+					AccountName:     account.name,
+					RoleId:          entitlement.RoleId,
+					EntitlementName: entitlement.Name,
+				})
+			}
+		}
+		for _, e := range sortedRoles(account.roles) {
+			if !granted[strings.ToLower(e.Name)] {
+				discrepancies = append(discrepancies, DiscrepancyEntity{
+					Kind:            KindMissingEntitlement,
+					EmployeeId:      a.Account.EmployeeId,
+					AccountName:     account.name,
+					RoleId:          e.RoleId,
+					EntitlementName: e.Name,
+				})
+			}
+		}
+	}
+	for _, id := range order {
+		if !seen[id] {
+			discrepancies = append(discrepancies, DiscrepancyEntity{
+				Kind:        KindMissingAccount,
+				EmployeeId:  id,
+				AccountName: accounts[id].name,
+			})
+		}
+	}
+	return discrepancies
+}
+
+// key ключ права в account.roles: роль с тем же id, если он известен, даже если её переименовали
+func (a *expectedAccount) key(entitlement provisioning.Entitlement) string {
+	if entitlement.RoleId == 0 {
+		return strings.ToLower(entitlement.Name)
+	}
+	for key, role := range a.roles {
+		if role.RoleId == entitlement.RoleId {
+			return key
+		}
+	}
+	return "#" + strconv.FormatInt(entitlement.RoleId, 10)
+}
+
+func sortedRoles(roles map[string]provisioning.Entitlement) []provisioning.Entitlement {
+	var result = make([]provisioning.Entitlement, 0, len(roles))
+	for _, e := range roles {
+		result = append(result, e)
+	}
+	slices.SortFunc(result, func(a, b provisioning.Entitlement) int { return cmp.Compare(a.RoleId, b.RoleId) })
+	return result
+}
+
+// remediate выполняет действие, заданное политикой коннектора для вида расхождения,
+// и записывает в d действие и его результат
+func remediate(target provisioning.Target, d *DiscrepancyEntity) {
+	var policy = target.Config.Reconcile
+	var account = provisioning.Account{EmployeeId: d.EmployeeId, Name: d.AccountName}
+	var entitlement = provisioning.Entitlement{RoleId: d.RoleId, Name: d.EntitlementName}
+	var apply func() error
+	switch d.Kind {
+	case KindOrphanAccount:
+		d.Action = policy.OrphanAccount
+		switch d.Action {
+		case provisioning.ActionDisable:
+			apply = func() error { return target.Connector.DisableAccount(account) }
+		case provisioning.ActionDelete:
+			apply = func() error { return target.Connector.DeleteAccount(account) }
+		}
+	case KindMissingAccount:
+		d.Action = policy.MissingAccount
+		if d.Action == provisioning.ActionCreate {
+			apply = func() error { return target.Connector.CreateAccount(account) }
+		}
+	case KindExtraEntitlement:
+		d.Action = policy.ExtraEntitlement
+		if d.Action == provisioning.ActionRemove {
+			apply = func() error { return target.Connector.RemoveEntitlement(account, entitlement) }
+		}
+	case KindMissingEntitlement:
+		d.Action = policy.MissingEntitlement
+		if d.Action == provisioning.ActionAdd {
+			apply = func() error { return target.Connector.AddEntitlement(account, entitlement) }
+		}
+	}
+	if d.Action == "" {
+		d.Action = provisioning.ActionReport
+	}
+
+	switch {
+	case apply == nil:
+		d.Outcome = OutcomeReported
+	case d.Kind == KindOrphanAccount && d.EmployeeId == 0:
+		// учётную запись, созданную вручную, нельзя адресовать через коннектор: её id не связан с сотрудником
+		d.Outcome = OutcomeSkipped
+		d.Error = "account is not linked to an employee id"
+	default:
+		if err := apply(); err != nil {
+			d.Outcome = OutcomeFailed
+			d.Error = err.Error()
+			return
+		}
+		d.Outcome = OutcomeRemediated
+	}
+}
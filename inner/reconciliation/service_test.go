@@ -0,0 +1,219 @@
+package reconciliation
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindExpected() ([]ExpectedEntity, error) {
+	args := m.Called()
+	return args.Get(0).([]ExpectedEntity), args.Error(1)
+}
+
+func (m *MockRepo) CreateReport(report *ReportEntity, discrepancies []DiscrepancyEntity) error {
+	args := m.Called(report, discrepancies)
+	return args.Error(0)
+}
+
+func (m *MockRepo) FindReports() ([]ReportEntity, error) {
+	args := m.Called()
+	return args.Get(0).([]ReportEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindReportById(id int64) (ReportEntity, error) {
+	args := m.Called(id)
+	return args.Get(0).(ReportEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindDiscrepancies(reportId int64) ([]DiscrepancyEntity, error) {
+	args := m.Called(reportId)
+	return args.Get(0).([]DiscrepancyEntity), args.Error(1)
+}
+
+// StubTargets набор коннекторов без диспетчера
+type StubTargets []provisioning.Target
+
+func (t StubTargets) Targets() []provisioning.Target {
+	return t
+}
+
+func (t StubTargets) Target(name string) (provisioning.Target, bool) {
+	for _, target := range t {
+		if target.Config.Name == name {
+			return target, true
+		}
+	}
+	return provisioning.Target{}, false
+}
+
+// WriteOnlyConnector коннектор, который не умеет читать состояние внешней системы
+type WriteOnlyConnector struct {
+	provisioning.Connector
+}
+
+func role(id int64, name string) (sql.NullInt64, sql.NullString) {
+	return sql.NullInt64{Int64: id, Valid: true}, sql.NullString{String: name, Valid: true}
+}
+
+// newDriftedTarget создаёт коннектор, состояние которого разошлось с expected из TestRun
+func newDriftedTarget(a *assert.Assertions, policy provisioning.ReconcilePolicy) (provisioning.Target, *provisioning.MemoryConnector) {
+	var memory, err = provisioning.NewMemoryConnector("")
+	a.Nil(err)
+	var john = provisioning.Account{EmployeeId: 1, Name: "John Doe"}
+	a.Nil(memory.CreateAccount(john))
+	a.Nil(memory.AddEntitlement(john, provisioning.Entitlement{RoleId: 11, Name: "Auditor"}))
+	a.Nil(memory.CreateAccount(provisioning.Account{EmployeeId: 99, Name: "Manual"}))
+	var cfg = provisioning.ConnectorConfig{Name: "mirror", Kind: "memory", Reconcile: policy}
+	return provisioning.Target{Config: cfg, Connector: memory}, memory
+}
+
+func TestRun(t *testing.T) {
+	var a = assert.New(t)
+	var adminId, adminName = role(10, "Admin")
+	var expected = []ExpectedEntity{
+		{EmployeeId: 1, EmployeeName: "John Doe", RoleId: adminId, RoleName: adminName},
+		{EmployeeId: 2, EmployeeName: "Jane Roe"},
+	}
+
+	t.Run("should report discrepancies without remediation by default", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var target, memory = newDriftedTarget(a, provisioning.ReconcilePolicy{})
+		var svc = NewService(repo, validator.New(), StubTargets{target})
+		repo.On("FindExpected").Return(expected, nil)
+		repo.On("CreateReport", mock.Anything, mock.Anything).Return(nil)
+		var before = memory.Accounts()
+
+		var report, err = svc.Run(RunRequest{Connector: "mirror"})
+		a.Nil(err)
+		a.Equal(2, report.Accounts)
+		a.Equal(4, report.Total)
+		a.Equal([]DiscrepancyResponse{
+			{Kind: KindExtraEntitlement, EmployeeId: 1, AccountName: "John Doe", RoleId: 11, EntitlementName: "Auditor",
+				Action: provisioning.ActionReport, Outcome: OutcomeReported},
+			{Kind: KindMissingEntitlement, EmployeeId: 1, AccountName: "John Doe", RoleId: 10, EntitlementName: "Admin",
+				Action: provisioning.ActionReport, Outcome: OutcomeReported},
+			{Kind: KindOrphanAccount, EmployeeId: 99, AccountName: "Manual",
+				Action: provisioning.ActionReport, Outcome: OutcomeReported},
+			{Kind: KindMissingAccount, EmployeeId: 2, AccountName: "Jane Roe",
+				Action: provisioning.ActionReport, Outcome: OutcomeReported},
+		}, report.Discrepancies)
+		a.Equal(before, memory.Accounts())
+	})
+
+	t.Run("should match renamed role by id", func(t *testing.T) {
+		var discrepancies = compare(expected[:1], []provisioning.TargetAccount{{
+			Account: provisioning.Account{EmployeeId: 1, Name: "John Doe"},
+			Entitlements: []provisioning.Entitlement{
+				{RoleId: 10, Name: "Administrators"},
+				{RoleId: 12, Name: "Admin"},
+			},
+		}})
+		// роль 12 названа так же, как ожидаемая роль 10, но это другая роль
+		a.Equal([]DiscrepancyEntity{
+			{Kind: KindExtraEntitlement, EmployeeId: 1, AccountName: "John Doe", RoleId: 12, EntitlementName: "Admin"},
+		}, discrepancies)
+	})
+
+	t.Run("should remediate according to policy", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var target, memory = newDriftedTarget(a, provisioning.ReconcilePolicy{
+			OrphanAccount:      provisioning.ActionDelete,
+			MissingAccount:     provisioning.ActionCreate,
+			ExtraEntitlement:   provisioning.ActionRemove,
+			MissingEntitlement: provisioning.ActionAdd,
+		})
+		var svc = NewService(repo, validator.New(), StubTargets{target})
+		repo.On("FindExpected").Return(expected, nil)
+		repo.On("CreateReport", mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) {
+				args.Get(0).(*ReportEntity).Id = 5
+			}).
+			Return(nil)
+
+		var report, err = svc.Run(RunRequest{Connector: "mirror"})
+		a.Nil(err)
+		a.Equal(int64(5), report.Id)
+		for _, d := range report.Discrepancies {
+			a.Equal(OutcomeRemediated, d.Outcome, d.Kind)
+		}
+		a.Equal(map[int64]provisioning.MemoryAccount{
+			1: {Name: "John Doe", Entitlements: map[int64]string{10: "Admin"}},
+			2: {Name: "Jane Roe", Entitlements: map[int64]string{}},
+		}, memory.Accounts())
+
+		// после исправления повторная сверка расхождений не находит
+		report, err = svc.Run(RunRequest{Connector: "mirror"})
+		a.Nil(err)
+		a.Empty(report.Discrepancies)
+	})
+
+	t.Run("should record failed remediation", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var target, _ = newDriftedTarget(a, provisioning.ReconcilePolicy{OrphanAccount: provisioning.ActionDisable})
+		target.Connector = FailingConnector{target.Connector.(*provisioning.MemoryConnector)}
+		var svc = NewService(repo, validator.New(), StubTargets{target})
+		repo.On("FindExpected").Return(expected, nil)
+		repo.On("CreateReport", mock.Anything, mock.Anything).Return(nil)
+
+		var report, err = svc.Run(RunRequest{Connector: "mirror"})
+		a.Nil(err)
+		a.Equal(OutcomeFailed, report.Discrepancies[2].Outcome)
+		a.Equal("target unavailable", report.Discrepancies[2].Error)
+	})
+
+	t.Run("should return errors for unknown or write-only connector", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var targets = StubTargets{{Config: provisioning.ConnectorConfig{Name: "push"}, Connector: WriteOnlyConnector{}}}
+		var svc = NewService(repo, validator.New(), targets)
+		var _, err = svc.Run(RunRequest{Connector: "ldap"})
+		a.ErrorAs(err, &common.NotFoundError{})
+		_, err = svc.Run(RunRequest{Connector: "push"})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		_, err = svc.Run(RunRequest{})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		a.True(repo.AssertNumberOfCalls(t, "FindExpected", 0))
+	})
+}
+
+// FailingConnector читает состояние, но не может его изменить
+type FailingConnector struct {
+	*provisioning.MemoryConnector
+}
+
+func (c FailingConnector) DisableAccount(provisioning.Account) error {
+	return errors.New("target unavailable")
+}
+
+func TestFindReportById(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = NewService(repo, validator.New(), StubTargets{})
+
+	t.Run("should return report with discrepancies", func(t *testing.T) {
+		repo.On("FindReportById", int64(1)).Return(ReportEntity{Id: 1, Connector: "mirror", Discrepancies: 1}, nil)
+		repo.On("FindDiscrepancies", int64(1)).Return([]DiscrepancyEntity{
+			{Id: 1, ReportId: 1, Kind: KindMissingAccount, EmployeeId: 2, Action: "report", Outcome: OutcomeReported},
+		}, nil)
+		var report, err = svc.FindReportById(1)
+		a.Nil(err)
+		a.Equal(1, report.Total)
+		a.Len(report.Discrepancies, 1)
+	})
+
+	t.Run("should return not found", func(t *testing.T) {
+		repo.On("FindReportById", int64(2)).Return(ReportEntity{}, sql.ErrNoRows)
+		var _, err = svc.FindReportById(2)
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+}
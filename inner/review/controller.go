@@ -0,0 +1,185 @@
+package review
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+)
+
+type Controller struct {
+	server        *web.Server
+	reviewService Svc
+}
+
+// интерфейс сервиса review.Service
+type Svc interface {
+	Create(request CreateRequest) (CampaignResponse, error)
+	FindAll() ([]CampaignResponse, error)
+	FindById(id int64) (CampaignResponse, error)
+	FindItems(campaignId int64, reviewerId int64) ([]ItemResponse, error)
+	Certify(request DecisionRequest) (ItemResponse, error)
+	Revoke(request DecisionRequest) (ItemResponse, error)
+}
+
+func NewController(server *web.Server, reviewService Svc) *Controller {
+	return &Controller{
+		server:        server,
+		reviewService: reviewService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/reviews", c.Create)
+	c.server.GroupApiV1.Get("/reviews", c.FindAll)
+	c.server.GroupApiV1.Get("/reviews/:id", c.FindById)
+	c.server.GroupApiV1.Get("/reviews/:id/items", c.FindItems)
+	c.server.GroupApiV1.Post("/reviews/:id/items/:itemId/certify", c.Certify)
+	c.server.GroupApiV1.Post("/reviews/:id/items/:itemId/revoke", c.Revoke)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/reviews"
+func (c *Controller) Create(ctx *fiber.Ctx) error {
+	var request CreateRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	var resp, err = c.reviewService.Create(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning created review campaign")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindAll(ctx *fiber.Ctx) error {
+	var resp, err = c.reviewService.FindAll()
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get review campaigns")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindById(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.reviewService.FindById(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get review campaign by id")
+	}
+
+	return nil
+}
+
+// FindItems возвращает элементы кампании. Сотрудник видит только элементы, которые пересматривает сам;
+// администратор и сервисный аккаунт — все элементы или, с параметром reviewer_id, элементы одного пересматривающего
+func (c *Controller) FindItems(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	var reviewerId int64
+	if principal, ok := auth.PrincipalFrom(ctx); ok && principal.EmployeeId != 0 && !principal.Admin {
+		reviewerId = principal.EmployeeId
+	} else if s := ctx.Query("reviewer_id"); s != "" {
+		if reviewerId, err = strconv.ParseInt(s, 10, 64); err != nil || reviewerId <= 0 {
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid reviewer_id")
+		}
+	}
+
+	resp, err := c.reviewService.FindItems(id, reviewerId)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get review items")
+	}
+
+	return nil
+}
+
+func (c *Controller) Certify(ctx *fiber.Ctx) error {
+	return c.decide(ctx, c.reviewService.Certify)
+}
+
+func (c *Controller) Revoke(ctx *fiber.Ctx) error {
+	return c.decide(ctx, c.reviewService.Revoke)
+}
+
+func (c *Controller) decide(ctx *fiber.Ctx, decide func(DecisionRequest) (ItemResponse, error)) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	itemId, err := strconv.ParseInt(ctx.Params("itemId"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid item id")
+	}
+	var request DecisionRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.CampaignId = id
+	request.ItemId = itemId
+	if request.ReviewerId, err = reviewer(ctx); err != nil {
+		return errResponse(ctx, err)
+	}
+
+	resp, err := decide(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning review item")
+	}
+
+	return nil
+}
+
+// reviewer сотрудник, от имени которого принимается решение по элементу: только вошедший сотрудник.
+// Сервисный аккаунт решений пересмотра не принимает
+func reviewer(ctx *fiber.Ctx) (int64, error) {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if !ok || principal.EmployeeId == 0 {
+		return 0, common.ForbiddenError{Message: "review decisions require an employee session"}
+	}
+	return principal.EmployeeId, nil
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.ForbiddenError{}):
+		return common.ErrResponse(ctx, fiber.StatusForbidden, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	case errors.As(err, &common.ConflictError{}):
+		return common.ErrResponse(ctx, fiber.StatusConflict, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
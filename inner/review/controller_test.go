@@ -0,0 +1,100 @@
+package review
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+// StubSvc запоминает запросы, дошедшие до сервиса
+type StubSvc struct {
+	Svc
+	decided    DecisionRequest
+	reviewerId int64
+}
+
+func (s *StubSvc) Certify(request DecisionRequest) (ItemResponse, error) {
+	s.decided = request
+	return ItemResponse{Id: request.ItemId}, nil
+}
+
+func (s *StubSvc) FindItems(campaignId int64, reviewerId int64) ([]ItemResponse, error) {
+	s.reviewerId = reviewerId
+	return []ItemResponse{}, nil
+}
+
+// StubSessions принимает "employee-token" сотрудника 7 и "admin-token" администратора 1
+type StubSessions struct{}
+
+func (StubSessions) Verify(accessToken string) (auth.Claims, error) {
+	switch accessToken {
+	case "employee-token":
+		return auth.Claims{EmployeeId: 7}, nil
+	case "admin-token":
+		return auth.Claims{EmployeeId: 1, Roles: []string{"admin"}}, nil
+	}
+	return auth.Claims{}, common.UnauthorizedError{Message: "invalid token"}
+}
+
+type StubApiKeys struct{}
+
+func (StubApiKeys) Authenticate(key string, clientIp string) (auth.Principal, error) {
+	return auth.Principal{ServiceAccountId: 3, Scopes: []string{"reviews:read", "reviews:write"}}, nil
+}
+
+func newApp(svc Svc) *fiber.App {
+	var server = web.NewServer()
+	server.GroupApiV1.Use(auth.NewMiddleware(StubSessions{}, StubApiKeys{}, []string{"admin"}))
+	NewController(server, svc).RegisterRoutes()
+	return server.App
+}
+
+func TestControllerReviewer(t *testing.T) {
+	a := assert.New(t)
+
+	var send = func(app *fiber.App, method string, path string, body string, authorization string) int {
+		var req = httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+		resp, err := app.Test(req)
+		a.Nil(err)
+		return resp.StatusCode
+	}
+
+	t.Run("should take reviewer from employee session", func(t *testing.T) {
+		var svc = &StubSvc{}
+		var app = newApp(svc)
+
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodPost, "/api/v1/reviews/1/items/4/certify",
+			`{"reviewer_id":99,"comment":"still needed"}`, "Bearer employee-token"))
+		a.Equal(DecisionRequest{CampaignId: 1, ItemId: 4, ReviewerId: 7, Comment: "still needed"}, svc.decided)
+
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodGet, "/api/v1/reviews/1/items?reviewer_id=99", "", "Bearer employee-token"))
+		a.Equal(int64(7), svc.reviewerId)
+	})
+
+	t.Run("should let administrator filter items by reviewer", func(t *testing.T) {
+		var svc = &StubSvc{}
+		var app = newApp(svc)
+
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodGet, "/api/v1/reviews/1/items?reviewer_id=99", "", "Bearer admin-token"))
+		a.Equal(int64(99), svc.reviewerId)
+		a.Equal(fiber.StatusOK, send(app, fiber.MethodGet, "/api/v1/reviews/1/items", "", "Bearer admin-token"))
+		a.Equal(int64(0), svc.reviewerId)
+	})
+
+	t.Run("should not let service account decide on review items", func(t *testing.T) {
+		var svc = &StubSvc{}
+		var app = newApp(svc)
+
+		a.Equal(fiber.StatusForbidden, send(app, fiber.MethodPost, "/api/v1/reviews/1/items/4/certify",
+			`{"reviewer_id":99}`, "ApiKey key"))
+		a.Equal(DecisionRequest{}, svc.decided)
+	})
+}
@@ -0,0 +1,175 @@
+package review
+
+import (
+	"database/sql"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"time"
+)
+
+// Области кампании: какие назначения попадают в снимок
+const (
+	// ScopeAll все действующие назначения
+	ScopeAll = "all"
+	// ScopeRole назначения роли scope_id
+	ScopeRole = "role"
+	// ScopeManager назначения непосредственных подчинённых сотрудника scope_id
+	ScopeManager = "manager"
+	// ScopeOrgUnit назначения сотрудников подразделения scope_id и всех вложенных в него
+	ScopeOrgUnit = "org_unit"
+)
+
+// Кто пересматривает назначение. Если такого нет или это сам сотрудник, пересматривает автор кампании
+const (
+	ReviewerManager   = "manager"
+	ReviewerRoleOwner = "role_owner"
+)
+
+// Статусы кампании
+const (
+	StatusActive = "active"
+	StatusClosed = "closed"
+)
+
+// Решения по элементу пересмотра
+const (
+	DecisionPending   = "pending"
+	DecisionCertified = "certified"
+	DecisionRevoked   = "revoked"
+	// DecisionExpired элемент не подтверждён до срока, назначение отозвано автоматически
+	DecisionExpired = "expired"
+)
+
+type CampaignEntity struct {
+	Id           int64         `db:"id"`
+	Name         string        `db:"name"`
+	ScopeType    string        `db:"scope_type"`
+	ScopeId      sql.NullInt64 `db:"scope_id"`
+	ReviewerType string        `db:"reviewer_type"`
+	CreatedBy    int64         `db:"created_by"`
+	Deadline     time.Time     `db:"deadline"`
+	Status       string        `db:"status"`
+	CreatedAt    time.Time     `db:"created_at"`
+	ClosedAt     sql.NullTime  `db:"closed_at"`
+}
+
+// ItemEntity назначение роли из снимка кампании и решение по нему
+type ItemEntity struct {
+	Id           int64        `db:"id"`
+	CampaignId   int64        `db:"campaign_id"`
+	EmployeeId   int64        `db:"employee_id"`
+	EmployeeName string       `db:"employee_name"`
+	RoleId       int64        `db:"role_id"`
+	RoleName     string       `db:"role_name"`
+	ReviewerId   int64        `db:"reviewer_id"`
+	Decision     string       `db:"decision"`
+	Comment      string       `db:"comment"`
+	DecidedAt    sql.NullTime `db:"decided_at"`
+}
+
+// StatsEntity количество элементов кампании с решением Decision
+type StatsEntity struct {
+	Decision string `db:"decision"`
+	Count    int    `db:"count"`
+}
+
+type CampaignResponse struct {
+	Id           int64         `json:"id"`
+	Name         string        `json:"name"`
+	ScopeType    string        `json:"scope_type"`
+	ScopeId      int64         `json:"scope_id,omitempty"`
+	ReviewerType string        `json:"reviewer_type"`
+	CreatedBy    int64         `json:"created_by"`
+	Deadline     time.Time     `json:"deadline"`
+	Status       string        `json:"status"`
+	CreatedAt    time.Time     `json:"created_at"`
+	ClosedAt     *time.Time    `json:"closed_at,omitempty"`
+	Progress     StatsResponse `json:"progress"`
+}
+
+// StatsResponse прогресс кампании
+type StatsResponse struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Certified int `json:"certified"`
+	Revoked   int `json:"revoked"`
+	Expired   int `json:"expired"`
+	// Percent доля элементов с решением, от 0 до 100
+	Percent int `json:"percent"`
+}
+
+type ItemResponse struct {
+	Id           int64      `json:"id"`
+	EmployeeId   int64      `json:"employee_id"`
+	EmployeeName string     `json:"employee_name"`
+	RoleId       int64      `json:"role_id"`
+	RoleName     string     `json:"role_name"`
+	ReviewerId   int64      `json:"reviewer_id"`
+	Decision     string     `json:"decision"`
+	Comment      string     `json:"comment,omitempty"`
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`
+}
+
+func (c *CampaignEntity) toResponse(stats []StatsEntity) CampaignResponse {
+	var resp = CampaignResponse{
+		Id:           c.Id,
+		Name:         c.Name,
+		ScopeType:    c.ScopeType,
+		ScopeId:      c.ScopeId.Int64,
+		ReviewerType: c.ReviewerType,
+		CreatedBy:    c.CreatedBy,
+		Deadline:     c.Deadline,
+		Status:       c.Status,
+		CreatedAt:    c.CreatedAt,
+		Progress:     toStats(stats),
+	}
+	if c.ClosedAt.Valid {
+		resp.ClosedAt = &c.ClosedAt.Time
+	}
+	return resp
+}
+
+func toStats(stats []StatsEntity) StatsResponse {
+	var resp StatsResponse
+	for _, s := range stats {
+		resp.Total += s.Count
+		switch s.Decision {
+		case DecisionPending:
+			resp.Pending = s.Count
+		case DecisionCertified:
+			resp.Certified = s.Count
+		case DecisionRevoked:
+			resp.Revoked = s.Count
+		case DecisionExpired:
+			resp.Expired = s.Count
+		}
+	}
+	if resp.Total > 0 {
+		resp.Percent = (resp.Total - resp.Pending) * 100 / resp.Total
+	}
+	return resp
+}
+
+func (i *ItemEntity) toResponse() ItemResponse {
+	var resp = ItemResponse{
+		Id:           i.Id,
+		EmployeeId:   i.EmployeeId,
+		EmployeeName: i.EmployeeName,
+		RoleId:       i.RoleId,
+		RoleName:     i.RoleName,
+		ReviewerId:   i.ReviewerId,
+		Decision:     i.Decision,
+		Comment:      i.Comment,
+	}
+	if i.DecidedAt.Valid {
+		resp.DecidedAt = &i.DecidedAt.Time
+	}
+	return resp
+}
+
+func (i *ItemEntity) toEvent() provisioning.Event {
+	return provisioning.Event{
+		Type:        provisioning.EntitlementRemoved,
+		Account:     provisioning.Account{EmployeeId: i.EmployeeId, Name: i.EmployeeName},
+		Entitlement: provisioning.Entitlement{RoleId: i.RoleId, Name: i.RoleName},
+	}
+}
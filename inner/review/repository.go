@@ -0,0 +1,138 @@
+package review
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+const selectItems = `SELECT i.id, i.campaign_id, i.employee_id, e.name AS employee_name, i.role_id, r.name AS role_name,
+              i.reviewer_id, i.decision, i.comment, i.decided_at
+              FROM review_item i
+              JOIN employee e ON e.id = i.employee_id
+              JOIN role r ON r.id = i.role_id`
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+// CreateCampaignTx сохраняет кампанию и заполняет Id, Status и CreatedAt
+func (r *Repository) CreateCampaignTx(tx *sqlx.Tx, c *CampaignEntity) error {
+	query := `INSERT INTO review_campaign (name, scope_type, scope_id, reviewer_type, created_by, deadline)
+              VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, status, created_at`
+	return tx.QueryRowx(query, c.Name, c.ScopeType, c.ScopeId, c.ReviewerType, c.CreatedBy, c.Deadline).
+		Scan(&c.Id, &c.Status, &c.CreatedAt)
+}
+
+// SnapshotTx создаёт элементы кампании по действующим назначениям в её области и возвращает их количество.
+// Пересматривающий — руководитель сотрудника или владелец роли, а если его нет или это сам сотрудник, то автор кампании
+func (r *Repository) SnapshotTx(tx *sqlx.Tx, c CampaignEntity) (int64, error) {
+	query := `WITH RECURSIVE subtree AS (
+                  SELECT id FROM org_unit WHERE $2 = 'org_unit' AND id = $3
+                  UNION
+                  SELECT u.id FROM org_unit u JOIN subtree s ON u.parent_id = s.id
+              )
+              INSERT INTO review_item (campaign_id, employee_id, role_id, reviewer_id)
+              SELECT $1, er.employee_id, er.role_id,
+                  COALESCE(NULLIF(CASE WHEN $4 = 'manager' THEN e.manager_id ELSE r.owner_id END, er.employee_id), $5)
+              FROM effective_employee_role er
+              JOIN employee e ON e.id = er.employee_id
+              JOIN role r ON r.id = er.role_id
+              WHERE $2 = 'all'
+                  OR ($2 = 'role' AND er.role_id = $3)
+                  OR ($2 = 'manager' AND e.manager_id = $3)
+                  OR ($2 = 'org_unit' AND e.org_unit_id IN (SELECT id FROM subtree))`
+	res, err := tx.Exec(query, c.Id, c.ScopeType, c.ScopeId, c.ReviewerType, c.CreatedBy)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *Repository) FindCampaigns() (campaigns []CampaignEntity, err error) {
+	err = r.db.Select(&campaigns, "SELECT * FROM review_campaign ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+func (r *Repository) FindCampaignById(id int64) (campaign CampaignEntity, err error) {
+	err = r.db.Get(&campaign, "SELECT * FROM review_campaign WHERE id = $1", id)
+	return
+}
+
+func (r *Repository) FindStats(campaignId int64) (stats []StatsEntity, err error) {
+	query := "SELECT decision, COUNT(*) AS count FROM review_item WHERE campaign_id = $1 GROUP BY decision"
+	err = r.db.Select(&stats, query, campaignId)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// FindItems возвращает элементы кампании; reviewerId больше 0 оставляет только элементы этого пересматривающего
+func (r *Repository) FindItems(campaignId int64, reviewerId int64) (items []ItemEntity, err error) {
+	query := selectItems + " WHERE i.campaign_id = $1 AND ($2 = 0 OR i.reviewer_id = $2) ORDER BY i.id"
+	err = r.db.Select(&items, query, campaignId, reviewerId)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// FindItemTx читает элемент вместе со статусом кампании и блокирует его до конца транзакции
+func (r *Repository) FindItemTx(tx *sqlx.Tx, campaignId int64, itemId int64) (item ItemEntity, status string, err error) {
+	err = tx.Get(&item, selectItems+" WHERE i.campaign_id = $1 AND i.id = $2 FOR UPDATE OF i", campaignId, itemId)
+	if err != nil {
+		return
+	}
+	err = tx.Get(&status, "SELECT status FROM review_campaign WHERE id = $1", campaignId)
+	return
+}
+
+func (r *Repository) DecideTx(tx *sqlx.Tx, itemId int64, decision string, comment string) error {
+	query := "UPDATE review_item SET decision = $1, comment = $2, decided_at = NOW() WHERE id = $3"
+	_, err := tx.Exec(query, decision, comment, itemId)
+	return err
+}
+
+// RevokeTx снимает роль с сотрудника. Назначение могло быть уже удалено, тогда возвращается 0
+func (r *Repository) RevokeTx(tx *sqlx.Tx, employeeId int64, roleId int64) (int64, error) {
+	res, err := tx.Exec("DELETE FROM employee_role WHERE employee_id = $1 AND role_id = $2", employeeId, roleId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// FindOverdueTx возвращает активные кампании, срок которых истёк к моменту now, и блокирует их
+func (r *Repository) FindOverdueTx(tx *sqlx.Tx, now time.Time) (campaigns []CampaignEntity, err error) {
+	query := "SELECT * FROM review_campaign WHERE status = 'active' AND deadline <= $1 ORDER BY id FOR UPDATE"
+	err = tx.Select(&campaigns, query, now)
+	if err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+func (r *Repository) FindPendingItemsTx(tx *sqlx.Tx, campaignId int64) (items []ItemEntity, err error) {
+	query := selectItems + " WHERE i.campaign_id = $1 AND i.decision = 'pending' ORDER BY i.id FOR UPDATE OF i"
+	err = tx.Select(&items, query, campaignId)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *Repository) CloseCampaignTx(tx *sqlx.Tx, campaignId int64) error {
+	_, err := tx.Exec("UPDATE review_campaign SET status = 'closed', closed_at = NOW() WHERE id = $1", campaignId)
+	return err
+}
@@ -0,0 +1,335 @@
+package review
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"log"
+	"time"
+)
+
+// Структура сервиса пересмотра доступа: кампании, решения пересматривающих и отзыв неподтверждённых назначений
+type Service struct {
+	repo        Repo
+	validator   Validator
+	provisioner Provisioner
+	// now подменяется в тестах
+	now func() time.Time
+}
+
+// CreateRequest запуск кампании. ScopeId обязателен для областей role, manager и org_unit
+type CreateRequest struct {
+	Name         string    `json:"name" validate:"required,min=2,max=155"`
+	ScopeType    string    `json:"scope_type" validate:"required,oneof=all role manager org_unit"`
+	ScopeId      int64     `json:"scope_id" validate:"omitempty,gt=0"`
+	ReviewerType string    `json:"reviewer_type" validate:"required,oneof=manager role_owner"`
+	CreatedBy    int64     `json:"created_by" validate:"required,gt=0"`
+	Deadline     time.Time `json:"deadline" validate:"required"`
+}
+
+// DecisionRequest решение пересматривающего ReviewerId по элементу ItemId кампании CampaignId.
+// ReviewerId — вошедший сотрудник, а не id из тела запроса
+type DecisionRequest struct {
+	CampaignId int64  `json:"-" validate:"required,gt=0"`
+	ItemId     int64  `json:"-" validate:"required,gt=0"`
+	ReviewerId int64  `json:"-" validate:"required,gt=0"`
+	Comment    string `json:"comment" validate:"max=1000"`
+}
+
+// CloseResult сколько кампаний закрыто и назначений отозвано за один проход
+type CloseResult struct {
+	Closed  int
+	Revoked int
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+// Provisioner асинхронно передаёт отозванные роли во внешние системы
+type Provisioner interface {
+	Publish(event provisioning.Event)
+}
+
+type Repo interface {
+	BeginTransaction() (*sqlx.Tx, error)
+	CreateCampaignTx(*sqlx.Tx, *CampaignEntity) error
+	SnapshotTx(*sqlx.Tx, CampaignEntity) (int64, error)
+	FindCampaigns() ([]CampaignEntity, error)
+	FindCampaignById(int64) (CampaignEntity, error)
+	FindStats(int64) ([]StatsEntity, error)
+	FindItems(int64, int64) ([]ItemEntity, error)
+	FindItemTx(*sqlx.Tx, int64, int64) (ItemEntity, string, error)
+	DecideTx(*sqlx.Tx, int64, string, string) error
+	RevokeTx(*sqlx.Tx, int64, int64) (int64, error)
+	FindOverdueTx(*sqlx.Tx, time.Time) ([]CampaignEntity, error)
+	FindPendingItemsTx(*sqlx.Tx, int64) ([]ItemEntity, error)
+	CloseCampaignTx(*sqlx.Tx, int64) error
+}
+
+// NewService создаёт сервис пересмотра. Если provisioner равен nil, отозванные роли во внешние системы не передаются
+func NewService(repo Repo, validator Validator, provisioner Provisioner) *Service {
+	return &Service{
+		repo:        repo,
+		validator:   validator,
+		provisioner: provisioner,
+		now:         time.Now,
+	}
+}
+
+// Create запускает кампанию: в одной транзакции сохраняет её и снимок действующих назначений в её области
+func (srv *Service) Create(request CreateRequest) (CampaignResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return CampaignResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	if request.ScopeType != ScopeAll && request.ScopeId == 0 {
+		return CampaignResponse{}, common.RequestValidationError{Message: fmt.Sprintf("scope_id is required for scope %s", request.ScopeType)}
+	}
+	if !request.Deadline.After(srv.now()) {
+		return CampaignResponse{}, common.RequestValidationError{Message: "deadline must be in the future"}
+	}
+
+	var entity = CampaignEntity{
+		Name:         request.Name,
+		ScopeType:    request.ScopeType,
+		ReviewerType: request.ReviewerType,
+		CreatedBy:    request.CreatedBy,
+		Deadline:     request.Deadline,
+	}
+	if request.ScopeType != ScopeAll {
+		entity.ScopeId = sql.NullInt64{Int64: request.ScopeId, Valid: true}
+	}
+
+	var count int64
+	err = database.InTransaction(srv.repo.BeginTransaction, "creating review campaign", func(tx *sqlx.Tx) error {
+		if err := srv.repo.CreateCampaignTx(tx, &entity); err != nil {
+			return fmt.Errorf("error creating review campaign: %w", err)
+		}
+		count, err = srv.repo.SnapshotTx(tx, entity)
+		if err != nil {
+			return fmt.Errorf("error creating review items of campaign %d: %w", entity.Id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return CampaignResponse{}, err
+	}
+	return entity.toResponse([]StatsEntity{{Decision: DecisionPending, Count: int(count)}}), nil
+}
+
+// FindAll возвращает кампании без статистики, новые первыми
+func (srv *Service) FindAll() ([]CampaignResponse, error) {
+	var entities, err = srv.repo.FindCampaigns()
+	if err != nil {
+		return []CampaignResponse{}, fmt.Errorf("error get review campaigns: %w", err)
+	}
+	var resp = make([]CampaignResponse, 0, len(entities))
+	for _, e := range entities {
+		resp = append(resp, e.toResponse(nil))
+	}
+	return resp, nil
+}
+
+// FindById возвращает кампанию вместе с прогрессом пересмотра
+func (srv *Service) FindById(id int64) (CampaignResponse, error) {
+	var entity, err = srv.findCampaign(id)
+	if err != nil {
+		return CampaignResponse{}, err
+	}
+	stats, err := srv.repo.FindStats(id)
+	if err != nil {
+		return CampaignResponse{}, fmt.Errorf("error get progress of review campaign %d: %w", id, err)
+	}
+	return entity.toResponse(stats), nil
+}
+
+// FindItems возвращает элементы кампании; reviewerId больше 0 оставляет только элементы этого пересматривающего
+func (srv *Service) FindItems(campaignId int64, reviewerId int64) ([]ItemResponse, error) {
+	if reviewerId < 0 {
+		return []ItemResponse{}, common.RequestValidationError{Message: "reviewer_id must be greater than 0"}
+	}
+	if _, err := srv.findCampaign(campaignId); err != nil {
+		return []ItemResponse{}, err
+	}
+	var entities, err = srv.repo.FindItems(campaignId, reviewerId)
+	if err != nil {
+		return []ItemResponse{}, fmt.Errorf("error get items of review campaign %d: %w", campaignId, err)
+	}
+	var resp = make([]ItemResponse, 0, len(entities))
+	for _, e := range entities {
+		resp = append(resp, e.toResponse())
+	}
+	return resp, nil
+}
+
+// Certify подтверждает, что назначение по-прежнему нужно
+func (srv *Service) Certify(request DecisionRequest) (ItemResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return ItemResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+
+	var item ItemEntity
+	err = database.InTransaction(srv.repo.BeginTransaction, "certifying review item", func(tx *sqlx.Tx) error {
+		item, err = srv.decide(tx, request, DecisionCertified)
+		return err
+	})
+	if err != nil {
+		return ItemResponse{}, err
+	}
+	return item.toResponse(), nil
+}
+
+// Revoke снимает роль с сотрудника в той же транзакции, что и решение; причина обязательна
+func (srv *Service) Revoke(request DecisionRequest) (ItemResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return ItemResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	if request.Comment == "" {
+		return ItemResponse{}, common.RequestValidationError{Message: "comment is required to revoke access"}
+	}
+
+	var item ItemEntity
+	var events []provisioning.Event
+	err = database.InTransaction(srv.repo.BeginTransaction, "revoking review item", func(tx *sqlx.Tx) error {
+		item, err = srv.decide(tx, request, DecisionRevoked)
+		if err != nil {
+			return err
+		}
+		return srv.revoke(tx, item, &events)
+	})
+	if err != nil {
+		return ItemResponse{}, err
+	}
+
+	srv.publish(events)
+	return item.toResponse(), nil
+}
+
+// decide проверяет, что решение принимает назначенный пересматривающий по ещё не решённому элементу активной кампании
+func (srv *Service) decide(tx *sqlx.Tx, request DecisionRequest, decision string) (ItemEntity, error) {
+	item, status, err := srv.repo.FindItemTx(tx, request.CampaignId, request.ItemId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ItemEntity{}, common.NotFoundError{Message: fmt.Sprintf("review item %d of campaign %d not found", request.ItemId, request.CampaignId)}
+	}
+	if err != nil {
+		return ItemEntity{}, fmt.Errorf("error finding review item %d: %w", request.ItemId, err)
+	}
+	if status != StatusActive {
+		return ItemEntity{}, common.ConflictError{Message: fmt.Sprintf("review campaign %d is already %s", request.CampaignId, status)}
+	}
+	if item.ReviewerId != request.ReviewerId {
+		return ItemEntity{}, common.ForbiddenError{Message: fmt.Sprintf("employee %d is not the reviewer of item %d", request.ReviewerId, item.Id)}
+	}
+	if item.Decision != DecisionPending {
+		return ItemEntity{}, common.ConflictError{Message: fmt.Sprintf("review item %d is already %s", item.Id, item.Decision)}
+	}
+
+	if err := srv.repo.DecideTx(tx, item.Id, decision, request.Comment); err != nil {
+		return ItemEntity{}, fmt.Errorf("error saving decision on review item %d: %w", item.Id, err)
+	}
+	item.Decision = decision
+	item.Comment = request.Comment
+	item.DecidedAt = sql.NullTime{Time: srv.now(), Valid: true}
+	return item, nil
+}
+
+// revoke снимает роль из элемента и добавляет событие, если назначение ещё существовало
+func (srv *Service) revoke(tx *sqlx.Tx, item ItemEntity, events *[]provisioning.Event) error {
+	deleted, err := srv.repo.RevokeTx(tx, item.EmployeeId, item.RoleId)
+	if err != nil {
+		return fmt.Errorf("error revoke role %d from employee %d: %w", item.RoleId, item.EmployeeId, err)
+	}
+	if deleted > 0 && srv.provisioner != nil {
+		*events = append(*events, item.toEvent())
+	}
+	return nil
+}
+
+// Schedule запускает CloseDue каждые interval, пока не отменён ctx
+func (srv *Service) Schedule(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				result, err := srv.CloseDue()
+				if err != nil {
+					log.Printf("review: closing overdue campaigns failed: %v", err)
+					continue
+				}
+				if result != (CloseResult{}) {
+					log.Printf("review: %d campaigns closed, %d assignments revoked", result.Closed, result.Revoked)
+				}
+			}
+		}
+	}()
+}
+
+// CloseDue закрывает кампании с истёкшим сроком. Назначения, по которым не принято решение, отзываются
+func (srv *Service) CloseDue() (CloseResult, error) {
+	var result CloseResult
+	var events []provisioning.Event
+	err := database.InTransaction(srv.repo.BeginTransaction, "closing overdue review campaigns", func(tx *sqlx.Tx) error {
+		campaigns, err := srv.repo.FindOverdueTx(tx, srv.now())
+		if err != nil {
+			return fmt.Errorf("error get overdue review campaigns: %w", err)
+		}
+		for _, c := range campaigns {
+			items, err := srv.repo.FindPendingItemsTx(tx, c.Id)
+			if err != nil {
+				return fmt.Errorf("error get pending items of review campaign %d: %w", c.Id, err)
+			}
+			for _, item := range items {
+				if err := srv.repo.DecideTx(tx, item.Id, DecisionExpired, "not certified before deadline"); err != nil {
+					return fmt.Errorf("error saving decision on review item %d: %w", item.Id, err)
+				}
+				if err := srv.revoke(tx, item, &events); err != nil {
+					return err
+				}
+			}
+			if err := srv.repo.CloseCampaignTx(tx, c.Id); err != nil {
+				return fmt.Errorf("error closing review campaign %d: %w", c.Id, err)
+			}
+			result.Closed++
+			result.Revoked += len(items)
+		}
+		return nil
+	})
+	if err != nil {
+		return CloseResult{}, err
+	}
+
+	srv.publish(events)
+	return result, nil
+}
+
+func (srv *Service) publish(events []provisioning.Event) {
+	for _, e := range events {
+		srv.provisioner.Publish(e)
+	}
+}
+
+func (srv *Service) findCampaign(id int64) (CampaignEntity, error) {
+	var entity, err = srv.repo.FindCampaignById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CampaignEntity{}, common.NotFoundError{Message: fmt.Sprintf("review campaign with id %d not found", id)}
+	}
+	if err != nil {
+		return CampaignEntity{}, fmt.Errorf("error finding review campaign with id %d: %w", id, err)
+	}
+	return entity, nil
+}
@@ -0,0 +1,285 @@
+package review
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) CreateCampaignTx(tx *sqlx.Tx, c *CampaignEntity) error {
+	args := m.Called(tx, c)
+	c.Id = 1
+	c.Status = StatusActive
+	return args.Error(0)
+}
+
+func (m *MockRepo) SnapshotTx(tx *sqlx.Tx, c CampaignEntity) (int64, error) {
+	args := m.Called(tx, c)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindCampaigns() ([]CampaignEntity, error) {
+	args := m.Called()
+	return args.Get(0).([]CampaignEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindCampaignById(id int64) (CampaignEntity, error) {
+	args := m.Called(id)
+	return args.Get(0).(CampaignEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindStats(campaignId int64) ([]StatsEntity, error) {
+	args := m.Called(campaignId)
+	return args.Get(0).([]StatsEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindItems(campaignId int64, reviewerId int64) ([]ItemEntity, error) {
+	args := m.Called(campaignId, reviewerId)
+	return args.Get(0).([]ItemEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindItemTx(tx *sqlx.Tx, campaignId int64, itemId int64) (ItemEntity, string, error) {
+	args := m.Called(tx, campaignId, itemId)
+	return args.Get(0).(ItemEntity), args.String(1), args.Error(2)
+}
+
+func (m *MockRepo) DecideTx(tx *sqlx.Tx, itemId int64, decision string, comment string) error {
+	args := m.Called(tx, itemId, decision, comment)
+	return args.Error(0)
+}
+
+func (m *MockRepo) RevokeTx(tx *sqlx.Tx, employeeId int64, roleId int64) (int64, error) {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindOverdueTx(tx *sqlx.Tx, now time.Time) ([]CampaignEntity, error) {
+	args := m.Called(tx, now)
+	return args.Get(0).([]CampaignEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindPendingItemsTx(tx *sqlx.Tx, campaignId int64) ([]ItemEntity, error) {
+	args := m.Called(tx, campaignId)
+	return args.Get(0).([]ItemEntity), args.Error(1)
+}
+
+func (m *MockRepo) CloseCampaignTx(tx *sqlx.Tx, campaignId int64) error {
+	args := m.Called(tx, campaignId)
+	return args.Error(0)
+}
+
+// StubProvisioner запоминает опубликованные события
+type StubProvisioner struct {
+	events []provisioning.Event
+}
+
+func (s *StubProvisioner) Publish(event provisioning.Event) {
+	s.events = append(s.events, event)
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+var now = time.Date(2025, 12, 1, 12, 0, 0, 0, time.UTC)
+
+func newService(repo Repo, provisioner Provisioner) *Service {
+	var svc = NewService(repo, validator.New(), provisioner)
+	svc.now = func() time.Time { return now }
+	return svc
+}
+
+func pendingItem() ItemEntity {
+	return ItemEntity{Id: 7, CampaignId: 1, EmployeeId: 10, EmployeeName: "Ivan", RoleId: 5, RoleName: "Admin",
+		ReviewerId: 20, Decision: DecisionPending}
+}
+
+// expectedEvent событие об отзыве роли из pendingItem
+var expectedEvent = provisioning.Event{
+	Type:        provisioning.EntitlementRemoved,
+	Account:     provisioning.Account{EmployeeId: 10, Name: "Ivan"},
+	Entitlement: provisioning.Entitlement{RoleId: 5, Name: "Admin"},
+}
+
+func TestCreate(t *testing.T) {
+	var a = assert.New(t)
+	var request = CreateRequest{Name: "Q4 admins", ScopeType: ScopeRole, ScopeId: 5, ReviewerType: ReviewerManager,
+		CreatedBy: 1, Deadline: now.Add(14 * 24 * time.Hour)}
+
+	t.Run("should snapshot assignments when campaign is created", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("CreateCampaignTx", tx, mock.Anything).Return(nil)
+		repo.On("SnapshotTx", tx, mock.MatchedBy(func(c CampaignEntity) bool {
+			return c.Id == 1 && c.ScopeId.Valid && c.ScopeId.Int64 == 5
+		})).Return(int64(3), nil)
+		var resp, err = svc.Create(request)
+		a.Nil(err)
+		a.Equal(int64(1), resp.Id)
+		a.Equal(StatsResponse{Total: 3, Pending: 3}, resp.Progress)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should require scope id for role scope", func(t *testing.T) {
+		var svc = newService(new(MockRepo), nil)
+		var noScope = request
+		noScope.ScopeId = 0
+		var _, err = svc.Create(noScope)
+		a.ErrorAs(err, &common.RequestValidationError{})
+		noScope.ScopeType = ScopeOrgUnit
+		_, err = svc.Create(noScope)
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should reject deadline in the past", func(t *testing.T) {
+		var svc = newService(new(MockRepo), nil)
+		var past = request
+		past.Deadline = now.Add(-time.Hour)
+		var _, err = svc.Create(past)
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+}
+
+func TestFindById(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = newService(repo, nil)
+	repo.On("FindCampaignById", int64(1)).Return(CampaignEntity{Id: 1, Status: StatusActive}, nil)
+	repo.On("FindStats", int64(1)).Return([]StatsEntity{
+		{Decision: DecisionPending, Count: 1},
+		{Decision: DecisionCertified, Count: 2},
+		{Decision: DecisionRevoked, Count: 1},
+	}, nil)
+	var resp, err = svc.FindById(1)
+	a.Nil(err)
+	a.Equal(StatsResponse{Total: 4, Pending: 1, Certified: 2, Revoked: 1, Percent: 75}, resp.Progress)
+}
+
+func TestDecide(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should certify item without revoking role", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = newService(repo, provisioner)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindItemTx", tx, int64(1), int64(7)).Return(pendingItem(), StatusActive, nil)
+		repo.On("DecideTx", tx, int64(7), DecisionCertified, "").Return(nil)
+		var resp, err = svc.Certify(DecisionRequest{CampaignId: 1, ItemId: 7, ReviewerId: 20})
+		a.Nil(err)
+		a.Equal(DecisionCertified, resp.Decision)
+		a.Empty(provisioner.events)
+		repo.AssertNotCalled(t, "RevokeTx", mock.Anything, mock.Anything, mock.Anything)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should revoke role and publish event after commit", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = newService(repo, provisioner)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindItemTx", tx, int64(1), int64(7)).Return(pendingItem(), StatusActive, nil)
+		repo.On("DecideTx", tx, int64(7), DecisionRevoked, "moved to sales").Return(nil)
+		repo.On("RevokeTx", tx, int64(10), int64(5)).Return(int64(1), nil)
+		var resp, err = svc.Revoke(DecisionRequest{CampaignId: 1, ItemId: 7, ReviewerId: 20, Comment: "moved to sales"})
+		a.Nil(err)
+		a.Equal(DecisionRevoked, resp.Decision)
+		a.Equal([]provisioning.Event{expectedEvent}, provisioner.events)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should require comment to revoke", func(t *testing.T) {
+		var svc = newService(new(MockRepo), nil)
+		var _, err = svc.Revoke(DecisionRequest{CampaignId: 1, ItemId: 7, ReviewerId: 20})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should forbid decision of another reviewer", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindItemTx", tx, int64(1), int64(7)).Return(pendingItem(), StatusActive, nil)
+		var _, err = svc.Certify(DecisionRequest{CampaignId: 1, ItemId: 7, ReviewerId: 21})
+		a.ErrorAs(err, &common.ForbiddenError{})
+	})
+
+	t.Run("should not change decided item", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		var item = pendingItem()
+		item.Decision = DecisionCertified
+		repo.On("FindItemTx", tx, int64(1), int64(7)).Return(item, StatusActive, nil)
+		var _, err = svc.Revoke(DecisionRequest{CampaignId: 1, ItemId: 7, ReviewerId: 20, Comment: "changed my mind"})
+		a.ErrorAs(err, &common.ConflictError{})
+	})
+
+	t.Run("should not decide in closed campaign", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(repo, nil)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindItemTx", tx, int64(1), int64(7)).Return(pendingItem(), StatusClosed, nil)
+		var _, err = svc.Certify(DecisionRequest{CampaignId: 1, ItemId: 7, ReviewerId: 20})
+		a.ErrorAs(err, &common.ConflictError{})
+	})
+}
+
+func TestCloseDue(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var provisioner = new(StubProvisioner)
+	var svc = newService(repo, provisioner)
+	tx, sqlMock := newTx(a, true)
+	repo.On("BeginTransaction").Return(tx, nil)
+	repo.On("FindOverdueTx", tx, now).Return([]CampaignEntity{{Id: 1}}, nil)
+	var gone = pendingItem()
+	gone.Id = 8
+	gone.RoleId = 6
+	repo.On("FindPendingItemsTx", tx, int64(1)).Return([]ItemEntity{pendingItem(), gone}, nil)
+	repo.On("DecideTx", tx, mock.Anything, DecisionExpired, mock.Anything).Return(nil)
+	repo.On("RevokeTx", tx, int64(10), int64(5)).Return(int64(1), nil)
+	// назначение уже снято другим путём, во внешние системы ничего не отправляем
+	repo.On("RevokeTx", tx, int64(10), int64(6)).Return(int64(0), nil)
+	repo.On("CloseCampaignTx", tx, int64(1)).Return(nil)
+
+	var result, err = svc.CloseDue()
+	a.Nil(err)
+	a.Equal(CloseResult{Closed: 1, Revoked: 2}, result)
+	a.Equal([]provisioning.Event{expectedEvent}, provisioner.events)
+	repo.AssertNumberOfCalls(t, "DecideTx", 2)
+	a.Nil(sqlMock.ExpectationsWereMet())
+}
@@ -0,0 +1,52 @@
+package role
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/export"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+type Controller struct {
+	server      *web.Server
+	roleService Svc
+}
+
+// интерфейс сервиса role.Service
+type Svc interface {
+	Export(request export.Request) (export.Stream, error)
+}
+
+func NewController(server *web.Server, roleService Svc) *Controller {
+	return &Controller{
+		server:      server,
+		roleService: roleService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	// полный маршрут получится "/api/v1/roles/export"
+	c.server.GroupApiV1.Get("/roles/export", c.Export)
+}
+
+// Export отдаёт роли файлом CSV, NDJSON или XLSX: GET /roles/export?format=ndjson&columns=name,members
+func (c *Controller) Export(ctx *fiber.Ctx) error {
+	req, err := export.ParseRequest(ctx)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	stream, err := c.roleService.Export(req)
+	if err != nil {
+		switch {
+		case errors.As(err, &common.RequestValidationError{}):
+			return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+		default:
+			return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	return export.Send(ctx, "roles", req.Format, stream)
+}
@@ -1,28 +1,108 @@
 package role
 
 import (
+	"database/sql"
+	"encoding/json"
+	"github.com/lib/pq"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/provisioning"
 	"time"
 )
 
 type Entity struct {
-	Id        int64     `db:"id"`
-	Name      string    `db:"name"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	Id   int64  `db:"id"`
+	Name string `db:"name"`
+	// OwnerId сотрудник, отвечающий за роль; согласует заявки на неё
+	OwnerId   sql.NullInt64 `db:"owner_id"`
+	Version   int64         `db:"version"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
 }
 
 type Response struct {
 	Id        int64     `json:"id"`
 	Name      string    `json:"name"`
+	OwnerId   int64     `json:"owner_id,omitempty"`
+	Version   int64     `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// HistoryEntity версия записи role, сохранённая триггером при изменении
+type HistoryEntity struct {
+	HistoryId int64     `db:"history_id"`
+	RoleId    int64     `db:"role_id"`
+	Operation string    `db:"operation"`
+	Data      []byte    `db:"data"`
+	ChangedAt time.Time `db:"changed_at"`
+}
+
+type HistoryResponse struct {
+	Version   int64                `json:"version"`
+	Operation string               `json:"operation"`
+	ChangedAt time.Time            `json:"changed_at"`
+	Role      Response             `json:"role"`
+	Changes   []common.FieldChange `json:"changes"`
+}
+
+// MemberEntity сотрудник, которому назначена роль
+type MemberEntity struct {
+	RoleId       int64  `db:"role_id"`
+	RoleName     string `db:"role_name"`
+	EmployeeId   int64  `db:"employee_id"`
+	EmployeeName string `db:"employee_name"`
+}
+
+func (m *MemberEntity) toEvent(eventType provisioning.EventType) provisioning.Event {
+	return provisioning.Event{
+		Type:        eventType,
+		Account:     provisioning.Account{EmployeeId: m.EmployeeId, Name: m.EmployeeName},
+		Entitlement: provisioning.Entitlement{RoleId: m.RoleId, Name: m.RoleName},
+	}
+}
+
 func (e *Entity) toResponse() Response {
 	return Response{
 		Id:        e.Id,
 		Name:      e.Name,
+		OwnerId:   e.OwnerId.Int64,
+		Version:   e.Version,
 		CreatedAt: e.CreatedAt,
 		UpdatedAt: e.UpdatedAt,
 	}
 }
+
+// снимок в data содержит колонки таблицы role, имена которых совпадают с json-тегами Response
+func (h *HistoryEntity) toResponse() (Response, error) {
+	var resp Response
+	err := json.Unmarshal(h.Data, &resp)
+	return resp, err
+}
+
+// ExportEntity строка выгрузки ролей вместе с именами сотрудников, которым роль назначена
+type ExportEntity struct {
+	Entity
+	Members pq.StringArray `db:"members"`
+}
+
+// ExportColumns колонки выгрузки ролей в порядке по умолчанию
+var ExportColumns = []string{"id", "name", "version", "created_at", "updated_at", "members"}
+
+func (e *ExportEntity) value(column string) any {
+	switch column {
+	case "id":
+		return e.Id
+	case "name":
+		return e.Name
+	case "version":
+		return e.Version
+	case "created_at":
+		return e.CreatedAt
+	case "updated_at":
+		return e.UpdatedAt
+	case "members":
+		return []string(e.Members)
+	default:
+		return nil
+	}
+}
@@ -1,7 +1,12 @@
 package role
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"time"
 )
 
 type Repository struct {
@@ -46,8 +51,13 @@ func (r *Repository) CreateNamed(e *Entity) error {
 	return nil
 }
 
+// CreateTx создаёт роль в транзакции tx и заполняет e.Id
+func (r *Repository) CreateTx(tx *sqlx.Tx, e *Entity) error {
+	return tx.Get(&e.Id, "INSERT INTO role (name) VALUES ($1) RETURNING id", e.Name)
+}
+
 func (r *Repository) FindAll() (roles []Entity, err error) {
-	query := "SELECT id, name, created_at, updated_at FROM role ORDER BY id"
+	query := "SELECT id, name, owner_id, version, created_at, updated_at FROM role ORDER BY id"
 	err = r.db.Select(&roles, query)
 	if err != nil {
 		return nil, err
@@ -81,6 +91,59 @@ func (r *Repository) DeleteById(id int64) (int64, error) {
 	return rows, nil
 }
 
+// Update обновляет запись, только если её версия совпадает с e.Version.
+// При успехе e заполняется актуальным состоянием записи с увеличенной версией
+func (r *Repository) Update(e *Entity) error {
+	query := `UPDATE role SET name = $1, version = version + 1, updated_at = NOW()
+              WHERE id = $2 AND version = $3 RETURNING *`
+	err := r.db.Get(e, query, e.Name, e.Id, e.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return versionConflict(r.db, e.Id)
+	}
+	return err
+}
+
+// UpdateTx делает то же, что Update, в транзакции tx
+func (r *Repository) UpdateTx(tx *sqlx.Tx, e *Entity) error {
+	query := `UPDATE role SET name = $1, version = version + 1, updated_at = NOW()
+              WHERE id = $2 AND version = $3 RETURNING *`
+	err := tx.Get(e, query, e.Name, e.Id, e.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return versionConflict(tx, e.Id)
+	}
+	return err
+}
+
+// DeleteByIdVersion удаляет запись, только если её версия совпадает с переданной
+func (r *Repository) DeleteByIdVersion(id int64, version int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM role WHERE id = $1 AND version = $2", id, version)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, versionConflict(r.db, id)
+	}
+	return rows, nil
+}
+
+// versionConflict выясняет, почему условное изменение не затронуло ни одной строки:
+// записи нет совсем или её версия уже ушла вперёд
+func versionConflict(q sqlx.Queryer, id int64) error {
+	var exists bool
+	err := sqlx.Get(q, &exists, "SELECT EXISTS (SELECT 1 FROM role WHERE id = $1)", id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return common.NotFoundError{Message: fmt.Sprintf("role with id %d not found", id)}
+	}
+	return common.ConflictError{Message: fmt.Sprintf("role with id %d was modified by another request", id)}
+}
+
 func (r *Repository) DeleteByIds(ids []int64) (int64, error) {
 	query, args, err := sqlx.In("DELETE FROM role WHERE id IN (?)", ids)
 	if err != nil {
@@ -97,3 +160,78 @@ func (r *Repository) DeleteByIds(ids []int64) (int64, error) {
 	}
 	return rows, nil
 }
+
+func (r *Repository) FindHistory(id int64) (history []HistoryEntity, err error) {
+	query := `SELECT history_id, role_id, operation, data, changed_at FROM role_history
+              WHERE role_id = $1 ORDER BY changed_at, history_id`
+	err = r.db.Select(&history, query, id)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// FindByIdAsOf возвращает последнюю версию записи, сохранённую не позже момента asOf
+func (r *Repository) FindByIdAsOf(id int64, asOf time.Time) (version HistoryEntity, err error) {
+	query := `SELECT history_id, role_id, operation, data, changed_at FROM role_history
+              WHERE role_id = $1 AND changed_at <= $2
+              ORDER BY changed_at DESC, history_id DESC LIMIT 1`
+	err = r.db.Get(&version, query, id, asOf)
+	return
+}
+
+// FindMembers возвращает сотрудников, которым назначены роли с переданными id
+func (r *Repository) FindMembers(ids []int64) (members []MemberEntity, err error) {
+	query, args, err := sqlx.In(`SELECT r.id AS role_id, r.name AS role_name, e.id AS employee_id, e.name AS employee_name
+              FROM role r
+              JOIN effective_employee_role er ON er.role_id = r.id
+              JOIN employee e ON e.id = er.employee_id
+              WHERE r.id IN (?) ORDER BY r.id, e.id`, ids)
+	if err != nil {
+		return nil, err
+	}
+	query = r.db.Rebind(query)
+
+	err = r.db.Select(&members, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// Export читает роли курсором и передаёт их в fn по одной, не загружая выборку в память.
+// Пустой ids означает все роли
+func (r *Repository) Export(ids []int64, fn func(ExportEntity) error) error {
+	query := `SELECT r.id, r.name, r.version, r.created_at, r.updated_at,
+              COALESCE(array_agg(e.name ORDER BY e.name) FILTER (WHERE e.id IS NOT NULL), '{}') AS members
+              FROM role r
+              LEFT JOIN effective_employee_role er ON er.role_id = r.id
+              LEFT JOIN employee e ON e.id = er.employee_id`
+	var args []any
+	if len(ids) > 0 {
+		var err error
+		query, args, err = sqlx.In(query+" WHERE r.id IN (?)", ids)
+		if err != nil {
+			return err
+		}
+		query = r.db.Rebind(query)
+	}
+	query += " GROUP BY r.id ORDER BY r.id"
+
+	rows, err := r.db.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e ExportEntity
+		if err = rows.StructScan(&e); err != nil {
+			return err
+		}
+		if err = fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -1,12 +1,21 @@
 package role
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/export"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"io"
+	"time"
 )
 
 // Структура сервиса, которая будет инкапсулировать бизнес-логику
 type Service struct {
-	repo Repo
+	repo        Repo
+	provisioner Provisioner
 }
 
 // Согласно идеологии Go:
@@ -15,15 +24,30 @@ type Service struct {
 type Repo interface {
 	FindById(id int64) (Entity, error)
 	CreateNamed(*Entity) error
+	CreateTx(*sqlx.Tx, *Entity) error
 	FindAll() ([]Entity, error)
 	FilterByIDs([]int64) ([]Entity, error)
 	DeleteById(int64) (int64, error)
 	DeleteByIds([]int64) (int64, error)
+	Update(*Entity) error
+	UpdateTx(*sqlx.Tx, *Entity) error
+	DeleteByIdVersion(int64, int64) (int64, error)
+	FindHistory(int64) ([]HistoryEntity, error)
+	FindByIdAsOf(int64, time.Time) (HistoryEntity, error)
+	FindMembers([]int64) ([]MemberEntity, error)
+	Export([]int64, func(ExportEntity) error) error
 }
 
-func NewService(repo Repo) *Service {
+// Provisioner асинхронно передаёт изменения ролей во внешние системы
+type Provisioner interface {
+	Publish(event provisioning.Event)
+}
+
+// NewService создаёт сервис ролей. Если provisioner равен nil, изменения во внешние системы не передаются
+func NewService(repo Repo, provisioner Provisioner) *Service {
 	return &Service{
-		repo: repo,
+		repo:        repo,
+		provisioner: provisioner,
 	}
 }
 
@@ -45,6 +69,26 @@ func (srv *Service) CreateNamed(e Entity) error {
 	return nil
 }
 
+// CreateRole создаёт роль и возвращает её идентификатор
+func (srv *Service) CreateRole(e Entity) (int64, error) {
+	var err = srv.repo.CreateNamed(&e)
+	if err != nil {
+		return 0, fmt.Errorf("role not created: %w", err)
+	}
+
+	return e.Id, nil
+}
+
+// CreateRoleTx создаёт роль в транзакции вызывающего и возвращает её идентификатор
+func (srv *Service) CreateRoleTx(tx *sqlx.Tx, e Entity) (int64, error) {
+	var err = srv.repo.CreateTx(tx, &e)
+	if err != nil {
+		return 0, fmt.Errorf("role not created: %w", err)
+	}
+
+	return e.Id, nil
+}
+
 func (srv *Service) FindAll() ([]Response, error) {
 	var entities, err = srv.repo.FindAll()
 	if err != nil {
@@ -72,19 +116,170 @@ func (srv *Service) FilterByIDs(ids []int64) ([]Response, error) {
 }
 
 func (srv *Service) DeleteById(id int64) (int64, error) {
-	var count, err = srv.repo.DeleteById(id)
+	var members, err = srv.findMembers([]int64{id})
+	if err != nil {
+		return 0, err
+	}
+	count, err := srv.repo.DeleteById(id)
 	if err != nil {
 		return 0, fmt.Errorf("error delete role by id: %w", err)
 	}
 
+	if count > 0 {
+		srv.publishRemoved(members)
+	}
 	return count, nil
 }
 
 func (srv *Service) DeleteByIds(ids []int64) (int64, error) {
-	var count, err = srv.repo.DeleteByIds(ids)
+	var members, err = srv.findMembers(ids)
+	if err != nil {
+		return 0, err
+	}
+	count, err := srv.repo.DeleteByIds(ids)
 	if err != nil {
 		return 0, fmt.Errorf("error delete roles by ids: %w", err)
 	}
 
+	if count > 0 {
+		srv.publishRemoved(members)
+	}
+	return count, nil
+}
+
+// findMembers читает назначения удаляемых ролей до удаления: после него они исчезнут каскадно.
+// Если провижининг выключен, запрос не выполняется
+func (srv *Service) findMembers(ids []int64) ([]MemberEntity, error) {
+	if srv.provisioner == nil {
+		return nil, nil
+	}
+	var members, err = srv.repo.FindMembers(ids)
+	if err != nil {
+		return nil, fmt.Errorf("error get members of roles: %w", err)
+	}
+	return members, nil
+}
+
+// publishRemoved отзывает во внешних системах права, соответствующие удалённым ролям
+func (srv *Service) publishRemoved(members []MemberEntity) {
+	for _, m := range members {
+		srv.provisioner.Publish(m.toEvent(provisioning.EntitlementRemoved))
+	}
+}
+
+// Update обновляет роль, если с момента чтения её версия не изменилась.
+// Если версия ушла вперёд, возвращается common.ConflictError
+func (srv *Service) Update(e Entity) (Response, error) {
+	var err = srv.repo.Update(&e)
+	if err != nil {
+		return Response{}, fmt.Errorf("error update role with id %d: %w", e.Id, err)
+	}
+
+	return e.toResponse(), nil
+}
+
+// UpdateTx делает то же, что Update, в транзакции вызывающего
+func (srv *Service) UpdateTx(tx *sqlx.Tx, e Entity) (Response, error) {
+	var err = srv.repo.UpdateTx(tx, &e)
+	if err != nil {
+		return Response{}, fmt.Errorf("error update role with id %d: %w", e.Id, err)
+	}
+
+	return e.toResponse(), nil
+}
+
+// DeleteByIdVersion удаляет роль, если с момента чтения её версия не изменилась
+func (srv *Service) DeleteByIdVersion(id int64, version int64) (int64, error) {
+	var members, err = srv.findMembers([]int64{id})
+	if err != nil {
+		return 0, err
+	}
+	count, err := srv.repo.DeleteByIdVersion(id, version)
+	if err != nil {
+		return 0, fmt.Errorf("error delete role by id: %w", err)
+	}
+
+	srv.publishRemoved(members)
 	return count, nil
 }
+
+// FindHistory возвращает все версии роли от самой старой к самой новой
+// вместе со списком полей, изменившихся относительно предыдущей версии
+func (srv *Service) FindHistory(id int64) ([]HistoryResponse, error) {
+	var history, err = srv.repo.FindHistory(id)
+	if err != nil {
+		return []HistoryResponse{}, fmt.Errorf("error get history of role with id %d: %w", id, err)
+	}
+
+	var resp = []HistoryResponse{}
+	var prev []byte
+	for _, h := range history {
+		role, err := h.toResponse()
+		if err != nil {
+			return []HistoryResponse{}, fmt.Errorf("error decoding history of role with id %d: %w", id, err)
+		}
+		changes, err := common.DiffSnapshots(prev, h.Data)
+		if err != nil {
+			return []HistoryResponse{}, fmt.Errorf("error comparing history of role with id %d: %w", id, err)
+		}
+		// версия берётся из снимка, как и в ETag роли
+		resp = append(resp, HistoryResponse{
+			Version:   role.Version,
+			Operation: h.Operation,
+			ChangedAt: h.ChangedAt,
+			Role:      role,
+			Changes:   changes,
+		})
+		prev = h.Data
+	}
+	return resp, nil
+}
+
+// FindByIdAsOf восстанавливает состояние роли на момент asOf
+func (srv *Service) FindByIdAsOf(id int64, asOf time.Time) (Response, error) {
+	var version, err = srv.repo.FindByIdAsOf(id, asOf)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && version.Operation == "DELETE") {
+		return Response{}, common.NotFoundError{
+			Message: fmt.Sprintf("role with id %d did not exist at %s", id, asOf.Format(time.RFC3339)),
+		}
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("error finding role with id %d as of %s: %w", id, asOf.Format(time.RFC3339), err)
+	}
+
+	role, err := version.toResponse()
+	if err != nil {
+		return Response{}, fmt.Errorf("error decoding history of role with id %d: %w", id, err)
+	}
+	return role, nil
+}
+
+// Export проверяет параметры выгрузки и возвращает функцию, которая потоком пишет роли в выбранном формате
+func (srv *Service) Export(request export.Request) (export.Stream, error) {
+	var err = request.Validate()
+	if err != nil {
+		return nil, common.RequestValidationError{Message: err.Error()}
+	}
+	columns, err := export.SelectColumns(request.Columns, ExportColumns)
+	if err != nil {
+		return nil, common.RequestValidationError{Message: err.Error()}
+	}
+
+	return func(w io.Writer) error {
+		writer, err := export.NewWriter(request.Format, w, columns)
+		if err != nil {
+			return err
+		}
+		var values = make([]any, len(columns))
+		err = srv.repo.Export(request.Ids, func(e ExportEntity) error {
+			for i, c := range columns {
+				values[i] = e.value(c)
+			}
+			return writer.Write(values)
+		})
+		if err != nil {
+			return fmt.Errorf("error export roles: %w", err)
+		}
+		return writer.Close()
+	}, nil
+}
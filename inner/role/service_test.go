@@ -1,10 +1,16 @@
 package role
 
 import (
+	"bytes"
+	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/export"
+	"github.com/zhedevops/idm/inner/provisioning"
 	"testing"
 	"time"
 )
@@ -50,6 +56,52 @@ func (m *MockRepo) DeleteByIds(ids []int64) (int64, error) {
 	return args.Get(0).(int64), args.Error(1)
 }
 
+func (m *MockRepo) Update(e *Entity) error {
+	args := m.Called(e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) UpdateTx(tx *sqlx.Tx, e *Entity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) CreateTx(tx *sqlx.Tx, e *Entity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) DeleteByIdVersion(id int64, version int64) (int64, error) {
+	args := m.Called(id, version)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindHistory(id int64) ([]HistoryEntity, error) {
+	args := m.Called(id)
+	return args.Get(0).([]HistoryEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindByIdAsOf(id int64, asOf time.Time) (HistoryEntity, error) {
+	args := m.Called(id, asOf)
+	return args.Get(0).(HistoryEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindMembers(ids []int64) ([]MemberEntity, error) {
+	args := m.Called(ids)
+	return args.Get(0).([]MemberEntity), args.Error(1)
+}
+
+// Export передаёт в fn строки, которые вернул мок
+func (m *MockRepo) Export(ids []int64, fn func(ExportEntity) error) error {
+	args := m.Called(ids)
+	for _, e := range args.Get(0).([]ExportEntity) {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
 func TestFindById(t *testing.T) {
 	var a = assert.New(t)
 
@@ -57,7 +109,7 @@ func TestFindById(t *testing.T) {
 		// создаём экземпляр мок-объекта
 		var repo = new(MockRepo)
 		// создаём экземпляр сервиса, который собираемся тестировать. Передаём в его конструктор мок вместо реального репозитория
-		var svc = NewService(repo)
+		var svc = NewService(repo, nil)
 		// создаём Entity, которую должен вернуть репозиторий
 		var entity = Entity{
 			Id:        1,
@@ -85,7 +137,7 @@ func TestFindById(t *testing.T) {
 		// выполненных в рамках одного нашего теста.
 		// Ели сделать мок общим для нескольких тестов, то он посчитает вызовы, которые сделали все тесты
 		var repo = new(MockRepo)
-		var svc = NewService(repo)
+		var svc = NewService(repo, nil)
 		// создаём пустую структуру role.Entity, которую сервис вернёт вместе с ошибкой
 		var entity = Entity{}
 		// ошибка, которую вернёт репозиторий
@@ -105,7 +157,7 @@ func TestFindById(t *testing.T) {
 func TestCreateNamed(t *testing.T) {
 	var a = assert.New(t)
 	var repo = new(MockRepo)
-	var svc = NewService(repo)
+	var svc = NewService(repo, nil)
 	t.Run("error is nil", func(t *testing.T) {
 		var entity = Entity{
 			Name: "Grigory Leps",
@@ -125,11 +177,25 @@ func TestCreateNamed(t *testing.T) {
 	})
 }
 
+func TestCreateRole(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = NewService(repo, nil)
+	repo.On("CreateNamed", &Entity{Name: "Admin"}).
+		Run(func(args mock.Arguments) {
+			args.Get(0).(*Entity).Id = 7
+		}).
+		Return(nil)
+	var id, err = svc.CreateRole(Entity{Name: "Admin"})
+	a.Nil(err)
+	a.Equal(int64(7), id)
+}
+
 func TestFindAll(t *testing.T) {
 	var a = assert.New(t)
 	t.Run("found roles", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo)
+		var svc = NewService(repo, nil)
 		var entity1 = Entity{
 			Id:        1,
 			Name:      "Grigory Leps",
@@ -154,7 +220,7 @@ func TestFindAll(t *testing.T) {
 	})
 	t.Run("not found roles", func(t *testing.T) {
 		var repo = new(MockRepo)
-		var svc = NewService(repo)
+		var svc = NewService(repo, nil)
 		var entities = []Entity{}
 		var want []Response
 		repo.On("FindAll").Return(entities, nil)
@@ -180,7 +246,7 @@ func TestFilterByIDs(t *testing.T) {
 	}
 	var entities = []Entity{entity1, entity2}
 	var repo = new(MockRepo)
-	var svc = NewService(repo)
+	var svc = NewService(repo, nil)
 	t.Run("found roles", func(t *testing.T) {
 		var ids = []int64{1, 2}
 		var want []Response
@@ -207,7 +273,7 @@ func TestFilterByIDs(t *testing.T) {
 func TestDeleteById(t *testing.T) {
 	var a = assert.New(t)
 	var repo = new(MockRepo)
-	var svc = NewService(repo)
+	var svc = NewService(repo, nil)
 	t.Run("delete role", func(t *testing.T) {
 		repo.On("DeleteById", int64(1)).Return(int64(1), nil)
 		var response, err = svc.DeleteById(1)
@@ -227,7 +293,7 @@ func TestDeleteById(t *testing.T) {
 func TestDeleteByIds(t *testing.T) {
 	var a = assert.New(t)
 	var repo = new(MockRepo)
-	var svc = NewService(repo)
+	var svc = NewService(repo, nil)
 	t.Run("delete roles", func(t *testing.T) {
 		var ids = []int64{1, 2}
 		repo.On("DeleteByIds", ids).Return(int64(2), nil)
@@ -245,3 +311,159 @@ func TestDeleteByIds(t *testing.T) {
 		a.Equal(int64(0), response)
 	})
 }
+
+func TestFindHistory(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = NewService(repo, nil)
+	var history = []HistoryEntity{
+		{HistoryId: 1, RoleId: 1, Operation: "INSERT", Data: []byte(`{"id": 1, "name": "Admin", "version": 2}`)},
+		{HistoryId: 2, RoleId: 1, Operation: "DELETE", Data: []byte(`{"id": 1, "name": "Admin", "version": 2}`)},
+	}
+	repo.On("FindHistory", int64(1)).Return(history, nil)
+	var got, err = svc.FindHistory(1)
+	a.Nil(err)
+	a.Len(got, 2)
+	a.Equal("Admin", got[0].Role.Name)
+	a.Equal(int64(2), got[0].Version)
+	a.Equal([]common.FieldChange{
+		{Field: "id", Old: nil, New: float64(1)},
+		{Field: "name", Old: nil, New: "Admin"},
+		{Field: "version", Old: nil, New: float64(2)},
+	}, got[0].Changes)
+	a.Equal("DELETE", got[1].Operation)
+	// удаление хранит последнюю версию строки
+	a.Equal(int64(2), got[1].Version)
+	a.Empty(got[1].Changes)
+}
+
+func TestFindByIdAsOf(t *testing.T) {
+	var a = assert.New(t)
+	var asOf = time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+	t.Run("found role version", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, nil)
+		var version = HistoryEntity{HistoryId: 1, RoleId: 1, Operation: "INSERT", Data: []byte(`{"id": 1, "name": "Admin"}`)}
+		repo.On("FindByIdAsOf", int64(1), asOf).Return(version, nil)
+		var got, err = svc.FindByIdAsOf(1, asOf)
+		a.Nil(err)
+		a.Equal(Response{Id: 1, Name: "Admin"}, got)
+	})
+	t.Run("role did not exist", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, nil)
+		repo.On("FindByIdAsOf", int64(1), asOf).Return(HistoryEntity{}, sql.ErrNoRows)
+		var _, err = svc.FindByIdAsOf(1, asOf)
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = NewService(repo, nil)
+	t.Run("updated role", func(t *testing.T) {
+		var entity = Entity{Id: 1, Name: "Admin", Version: 1}
+		repo.On("Update", &entity).Return(nil)
+		var got, err = svc.Update(entity)
+		a.Nil(err)
+		a.Equal(entity.toResponse(), got)
+	})
+	t.Run("version conflict", func(t *testing.T) {
+		var entity = Entity{Id: 2, Name: "Admin", Version: 1}
+		var conflict = common.ConflictError{Message: "role with id 2 was modified by another request"}
+		repo.On("Update", &entity).Return(conflict)
+		var _, err = svc.Update(entity)
+		a.ErrorAs(err, &common.ConflictError{})
+	})
+}
+
+// StubProvisioner запоминает опубликованные события
+type StubProvisioner struct {
+	events []provisioning.Event
+}
+
+func (p *StubProvisioner) Publish(event provisioning.Event) {
+	p.events = append(p.events, event)
+}
+
+func TestDeleteProvisioning(t *testing.T) {
+	var a = assert.New(t)
+	var members = []MemberEntity{
+		{RoleId: 1, RoleName: "Admin", EmployeeId: 10, EmployeeName: "John Doe"},
+		{RoleId: 1, RoleName: "Admin", EmployeeId: 11, EmployeeName: "Jane Doe"},
+	}
+
+	t.Run("should remove entitlement from every member", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, provisioner)
+		repo.On("FindMembers", []int64{1}).Return(members, nil)
+		repo.On("DeleteById", int64(1)).Return(int64(1), nil)
+		var _, err = svc.DeleteById(1)
+		a.Nil(err)
+		a.Equal([]provisioning.Event{
+			{
+				Type:        provisioning.EntitlementRemoved,
+				Account:     provisioning.Account{EmployeeId: 10, Name: "John Doe"},
+				Entitlement: provisioning.Entitlement{RoleId: 1, Name: "Admin"},
+			},
+			{
+				Type:        provisioning.EntitlementRemoved,
+				Account:     provisioning.Account{EmployeeId: 11, Name: "Jane Doe"},
+				Entitlement: provisioning.Entitlement{RoleId: 1, Name: "Admin"},
+			},
+		}, provisioner.events)
+	})
+
+	t.Run("should not publish on version conflict", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var provisioner = new(StubProvisioner)
+		var svc = NewService(repo, provisioner)
+		var conflict = common.ConflictError{Message: "role with id 1 was modified by another request"}
+		repo.On("FindMembers", []int64{1}).Return(members, nil)
+		repo.On("DeleteByIdVersion", int64(1), int64(2)).Return(int64(0), conflict)
+		var _, err = svc.DeleteByIdVersion(1, 2)
+		a.ErrorAs(err, &common.ConflictError{})
+		a.Empty(provisioner.events)
+	})
+}
+
+func TestExport(t *testing.T) {
+	var a = assert.New(t)
+	var created = time.Date(2025, 11, 1, 10, 0, 0, 0, time.UTC)
+	var rows = []ExportEntity{
+		{Entity: Entity{Id: 1, Name: "Admin", Version: 1, CreatedAt: created, UpdatedAt: created}, Members: []string{"Jane Doe", "John Doe"}},
+		{Entity: Entity{Id: 2, Name: "Auditor", Version: 3, CreatedAt: created, UpdatedAt: created}, Members: []string{}},
+	}
+
+	t.Run("should write selected columns as ndjson", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, nil)
+		repo.On("Export", []int64{1, 2}).Return(rows, nil)
+		stream, err := svc.Export(export.Request{Format: export.FormatNdjson, Columns: "name,members", Ids: []int64{1, 2}})
+		a.Nil(err)
+		var out bytes.Buffer
+		a.Nil(stream(&out))
+		a.Equal(`{"name":"Admin","members":["Jane Doe","John Doe"]}`+"\n"+
+			`{"name":"Auditor","members":[]}`+"\n", out.String())
+	})
+
+	t.Run("should reject unknown column before streaming", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, nil)
+		var _, err = svc.Export(export.Request{Format: export.FormatCsv, Columns: "name,password"})
+		a.ErrorAs(err, &common.RequestValidationError{})
+		repo.AssertNotCalled(t, "Export", mock.Anything)
+	})
+
+	t.Run("should wrap repository error", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, nil)
+		repo.On("Export", []int64(nil)).Return([]ExportEntity{}, errors.New("connection reset"))
+		stream, err := svc.Export(export.Request{Format: export.FormatCsv})
+		a.Nil(err)
+		err = stream(&bytes.Buffer{})
+		a.ErrorContains(err, "error export roles")
+	})
+}
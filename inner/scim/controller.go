@@ -0,0 +1,303 @@
+package scim
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+	"strings"
+)
+
+type Controller struct {
+	server      *web.Server
+	scimService Svc
+}
+
+// интерфейс сервиса scim.Service
+type Svc interface {
+	ListUsers(request ListRequest) (ListResponse[User], error)
+	GetUser(id string) (User, error)
+	CreateUser(user User) (User, error)
+	ReplaceUser(id string, version int64, user User) (User, error)
+	PatchUser(id string, version int64, patch PatchRequest) (User, error)
+	DeleteUser(id string, version int64) error
+	ListGroups(request ListRequest) (ListResponse[Group], error)
+	GetGroup(id string) (Group, error)
+	CreateGroup(group Group) (Group, error)
+	ReplaceGroup(id string, version int64, group Group) (Group, error)
+	PatchGroup(id string, version int64, patch PatchRequest) (Group, error)
+	DeleteGroup(id string, version int64) error
+}
+
+func NewController(server *web.Server, scimService Svc) *Controller {
+	return &Controller{
+		server:      server,
+		scimService: scimService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	// полный маршрут получится "/scim/v2/Users"
+	c.server.GroupScimV2.Get("/ServiceProviderConfig", c.ServiceProviderConfig)
+	c.server.GroupScimV2.Get("/ResourceTypes", c.ResourceTypes)
+	c.server.GroupScimV2.Get("/ResourceTypes/:id", c.ResourceType)
+	c.server.GroupScimV2.Get("/Schemas", c.Schemas)
+	c.server.GroupScimV2.Get("/Schemas/:id", c.Schema)
+
+	c.server.GroupScimV2.Get("/Users", c.ListUsers)
+	c.server.GroupScimV2.Post("/Users", c.CreateUser)
+	c.server.GroupScimV2.Get("/Users/:id", c.GetUser)
+	c.server.GroupScimV2.Put("/Users/:id", c.ReplaceUser)
+	c.server.GroupScimV2.Patch("/Users/:id", c.PatchUser)
+	c.server.GroupScimV2.Delete("/Users/:id", c.DeleteUser)
+
+	c.server.GroupScimV2.Get("/Groups", c.ListGroups)
+	c.server.GroupScimV2.Post("/Groups", c.CreateGroup)
+	c.server.GroupScimV2.Get("/Groups/:id", c.GetGroup)
+	c.server.GroupScimV2.Put("/Groups/:id", c.ReplaceGroup)
+	c.server.GroupScimV2.Patch("/Groups/:id", c.PatchGroup)
+	c.server.GroupScimV2.Delete("/Groups/:id", c.DeleteGroup)
+}
+
+func (c *Controller) ServiceProviderConfig(ctx *fiber.Ctx) error {
+	return ctx.JSON(newServiceProviderConfig(), MIMEScimJson)
+}
+
+func (c *Controller) ResourceTypes(ctx *fiber.Ctx) error {
+	return ctx.JSON(paginate(resourceTypes, ListRequest{Count: MaxResults}), MIMEScimJson)
+}
+
+func (c *Controller) ResourceType(ctx *fiber.Ctx) error {
+	for _, rt := range resourceTypes {
+		if rt.Id == ctx.Params("id") {
+			return ctx.JSON(rt, MIMEScimJson)
+		}
+	}
+	return errResponse(ctx, notFound("ResourceType", ctx.Params("id")))
+}
+
+func (c *Controller) Schemas(ctx *fiber.Ctx) error {
+	return ctx.JSON(paginate(schemas, ListRequest{Count: MaxResults}), MIMEScimJson)
+}
+
+func (c *Controller) Schema(ctx *fiber.Ctx) error {
+	for _, s := range schemas {
+		if s.Id == ctx.Params("id") {
+			return ctx.JSON(s, MIMEScimJson)
+		}
+	}
+	return errResponse(ctx, notFound("Schema", ctx.Params("id")))
+}
+
+func (c *Controller) ListUsers(ctx *fiber.Ctx) error {
+	request, err := listRequest(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	resp, err := c.scimService.ListUsers(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return ctx.JSON(resp, MIMEScimJson)
+}
+
+func (c *Controller) GetUser(ctx *fiber.Ctx) error {
+	user, err := c.scimService.GetUser(ctx.Params("id"))
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return resourceResponse(ctx, fiber.StatusOK, user, user.Meta)
+}
+
+func (c *Controller) CreateUser(ctx *fiber.Ctx) error {
+	var request User
+	if err := ctx.BodyParser(&request); err != nil {
+		return errResponse(ctx, newError(fiber.StatusBadRequest, "invalidSyntax", err.Error()))
+	}
+	user, err := c.scimService.CreateUser(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return resourceResponse(ctx, fiber.StatusCreated, user, user.Meta)
+}
+
+func (c *Controller) ReplaceUser(ctx *fiber.Ctx) error {
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	var request User
+	if err := ctx.BodyParser(&request); err != nil {
+		return errResponse(ctx, newError(fiber.StatusBadRequest, "invalidSyntax", err.Error()))
+	}
+	user, err := c.scimService.ReplaceUser(ctx.Params("id"), version, request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return resourceResponse(ctx, fiber.StatusOK, user, user.Meta)
+}
+
+func (c *Controller) PatchUser(ctx *fiber.Ctx) error {
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	request, err := patchRequest(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	user, err := c.scimService.PatchUser(ctx.Params("id"), version, request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return resourceResponse(ctx, fiber.StatusOK, user, user.Meta)
+}
+
+func (c *Controller) DeleteUser(ctx *fiber.Ctx) error {
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	if err = c.scimService.DeleteUser(ctx.Params("id"), version); err != nil {
+		return errResponse(ctx, err)
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (c *Controller) ListGroups(ctx *fiber.Ctx) error {
+	request, err := listRequest(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	resp, err := c.scimService.ListGroups(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return ctx.JSON(resp, MIMEScimJson)
+}
+
+func (c *Controller) GetGroup(ctx *fiber.Ctx) error {
+	group, err := c.scimService.GetGroup(ctx.Params("id"))
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return resourceResponse(ctx, fiber.StatusOK, group, group.Meta)
+}
+
+func (c *Controller) CreateGroup(ctx *fiber.Ctx) error {
+	var request Group
+	if err := ctx.BodyParser(&request); err != nil {
+		return errResponse(ctx, newError(fiber.StatusBadRequest, "invalidSyntax", err.Error()))
+	}
+	group, err := c.scimService.CreateGroup(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return resourceResponse(ctx, fiber.StatusCreated, group, group.Meta)
+}
+
+func (c *Controller) ReplaceGroup(ctx *fiber.Ctx) error {
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	var request Group
+	if err := ctx.BodyParser(&request); err != nil {
+		return errResponse(ctx, newError(fiber.StatusBadRequest, "invalidSyntax", err.Error()))
+	}
+	group, err := c.scimService.ReplaceGroup(ctx.Params("id"), version, request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return resourceResponse(ctx, fiber.StatusOK, group, group.Meta)
+}
+
+func (c *Controller) PatchGroup(ctx *fiber.Ctx) error {
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	request, err := patchRequest(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	group, err := c.scimService.PatchGroup(ctx.Params("id"), version, request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	return resourceResponse(ctx, fiber.StatusOK, group, group.Meta)
+}
+
+func (c *Controller) DeleteGroup(ctx *fiber.Ctx) error {
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+	if err = c.scimService.DeleteGroup(ctx.Params("id"), version); err != nil {
+		return errResponse(ctx, err)
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// listRequest читает параметры filter, startIndex и count запроса списка
+func listRequest(ctx *fiber.Ctx) (ListRequest, error) {
+	var request = ListRequest{Filter: ctx.Query("filter"), StartIndex: 1, Count: MaxResults}
+	var err error
+	if value := ctx.Query("startIndex"); value != "" {
+		if request.StartIndex, err = strconv.Atoi(value); err != nil {
+			return ListRequest{}, invalidValue("startIndex must be an integer")
+		}
+	}
+	if value := ctx.Query("count"); value != "" {
+		if request.Count, err = strconv.Atoi(value); err != nil {
+			return ListRequest{}, invalidValue("count must be an integer")
+		}
+	}
+	return request, nil
+}
+
+func patchRequest(ctx *fiber.Ctx) (PatchRequest, error) {
+	var request PatchRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return PatchRequest{}, newError(fiber.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+	if len(request.Schemas) != 1 || request.Schemas[0] != SchemaPatchOp {
+		return PatchRequest{}, newError(fiber.StatusBadRequest, "invalidSyntax", "schemas must be ["+SchemaPatchOp+"]")
+	}
+	return request, nil
+}
+
+// ifMatchVersion возвращает версию из необязательного в SCIM заголовка If-Match или ноль, если его нет или он равен "*".
+// SCIM выдаёт в meta.version слабые ETag и сравнивает их слабо (RFC 7644, 3.14), поэтому префикс W/ допустим
+func ifMatchVersion(ctx *fiber.Ctx) (int64, error) {
+	var header = strings.TrimSpace(ctx.Get(fiber.HeaderIfMatch))
+	if header == "" {
+		return 0, nil
+	}
+	version, err := common.ParseIfMatch(strings.TrimPrefix(header, "W/"))
+	if err != nil {
+		return 0, newError(fiber.StatusPreconditionFailed, "", err.Error())
+	}
+	return version, nil
+}
+
+func resourceResponse(ctx *fiber.Ctx, status int, resource any, meta *Meta) error {
+	if meta != nil {
+		ctx.Set(fiber.HeaderETag, meta.Version)
+		if status == fiber.StatusCreated {
+			ctx.Set(fiber.HeaderLocation, ctx.BaseURL()+meta.Location)
+		}
+	}
+	return ctx.Status(status).JSON(resource, MIMEScimJson)
+}
+
+// errResponse формирует ответ с ошибкой в формате SCIM
+func errResponse(ctx *fiber.Ctx, err error) error {
+	var scimErr *Error
+	if !errors.As(err, &scimErr) {
+		scimErr = newError(fiber.StatusInternalServerError, "", err.Error())
+	}
+	return ctx.Status(scimErr.StatusCode()).JSON(scimErr, MIMEScimJson)
+}
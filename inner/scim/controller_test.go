@@ -0,0 +1,150 @@
+package scim
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/role"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+func newTestApp() (*fiber.App, *MockRepo, *MockEmployeeSvc, *MockRoleSvc, *MockAssignmentSvc) {
+	var svc, repo, employees, roles, assignments = newTestService()
+	var server = web.NewServer()
+	NewController(server, svc).RegisterRoutes()
+	return server.App, repo, employees, roles, assignments
+}
+
+func send(t *testing.T, app *fiber.App, method string, path string, body string, headers ...string) *http.Response {
+	var req = httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, MIMEScimJson)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func decodeBody[T any](t *testing.T, resp *http.Response) T {
+	var value T
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(body, &value); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	return value
+}
+
+func TestControllerUsers(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("get user returns etag and scim content type", func(t *testing.T) {
+		var app, _, employees, _, assignments = newTestApp()
+		employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(employee.Response{Id: 1, Name: "John Doe", Version: 3}, nil)
+		assignments.On("FindByEmployeeId", int64(1)).Return([]assignment.Response{}, nil)
+
+		var resp = send(t, app, fiber.MethodGet, "/scim/v2/Users/1", "")
+		a.Equal(fiber.StatusOK, resp.StatusCode)
+		a.Equal(`W/"3"`, resp.Header.Get(fiber.HeaderETag))
+		a.True(strings.HasPrefix(resp.Header.Get(fiber.HeaderContentType), MIMEScimJson))
+		a.Equal("John Doe", decodeBody[User](t, resp).UserName)
+	})
+
+	t.Run("unknown user is a scim error", func(t *testing.T) {
+		var app, _, employees, _, _ = newTestApp()
+		employees.On("FindById", employee.ParamIdRequest{Id: 5}).
+			Return(employee.Response{}, fmt.Errorf("error finding employee with id 5: %w", sql.ErrNoRows))
+
+		var resp = send(t, app, fiber.MethodGet, "/scim/v2/Users/5", "")
+		a.Equal(fiber.StatusNotFound, resp.StatusCode)
+		var scimErr = decodeBody[Error](t, resp)
+		a.Equal([]string{SchemaError}, scimErr.Schemas)
+		a.Equal("404", scimErr.Status)
+	})
+
+	t.Run("invalid count", func(t *testing.T) {
+		var app, _, _, _, _ = newTestApp()
+		var resp = send(t, app, fiber.MethodGet, "/scim/v2/Users?count=ten", "")
+		a.Equal(fiber.StatusBadRequest, resp.StatusCode)
+		a.Equal("invalidValue", decodeBody[Error](t, resp).ScimType)
+	})
+
+	t.Run("stale if-match", func(t *testing.T) {
+		var app, _, employees, _, _ = newTestApp()
+		employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(employee.Response{Id: 1, Name: "John Doe", Version: 3}, nil)
+
+		var resp = send(t, app, fiber.MethodDelete, "/scim/v2/Users/1", "", fiber.HeaderIfMatch, `W/"2"`)
+		a.Equal(fiber.StatusPreconditionFailed, resp.StatusCode)
+		employees.AssertNotCalled(t, "DeleteByIdVersion", employee.ParamIdVersionRequest{Id: 1, Version: 3})
+	})
+
+	t.Run("delete user", func(t *testing.T) {
+		var app, _, employees, _, _ = newTestApp()
+		employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(employee.Response{Id: 1, Name: "John Doe", Version: 3}, nil)
+		employees.On("DeleteByIdVersion", employee.ParamIdVersionRequest{Id: 1, Version: 3}).Return(int64(1), nil)
+
+		var resp = send(t, app, fiber.MethodDelete, "/scim/v2/Users/1", "", fiber.HeaderIfMatch, `W/"3"`)
+		a.Equal(fiber.StatusNoContent, resp.StatusCode)
+		resp = send(t, app, fiber.MethodDelete, "/scim/v2/Users/1", "", fiber.HeaderIfMatch, "*")
+		a.Equal(fiber.StatusNoContent, resp.StatusCode)
+	})
+}
+
+func TestControllerGroups(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("create group with members in one transaction", func(t *testing.T) {
+		var app, repo, employees, roles, assignments = newTestApp()
+		var tx, sqlMock = newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		employees.On("FilterByIDs", employee.ParamIdsRequest{Ids: []int64{1}}).Return([]employee.Response{{Id: 1, Name: "John Doe"}}, nil)
+		roles.On("CreateRoleTx", tx, role.Entity{Name: "Admin"}).Return(int64(10), nil)
+		assignments.On("SetRoleMembersTx", tx, assignment.SetRoleMembersRequest{RoleId: 10, EmployeeIds: []int64{1}}).
+			Return([]provisioning.Event(nil), nil)
+		assignments.On("Publish", []provisioning.Event(nil)).Return()
+		roles.On("FindById", int64(10)).Return(role.Response{Id: 10, Name: "Admin", Version: 1}, nil)
+		assignments.On("FindByRoleId", int64(10)).Return([]assignment.Response{{EmployeeId: 1, RoleId: 10}}, nil)
+
+		var resp = send(t, app, fiber.MethodPost, "/scim/v2/Groups",
+			`{"schemas":["`+SchemaGroup+`"],"displayName":"Admin","members":[{"value":"1"}]}`)
+		a.Equal(fiber.StatusCreated, resp.StatusCode)
+		a.Equal("http://example.com/scim/v2/Groups/10", resp.Header.Get(fiber.HeaderLocation))
+		a.Equal(`W/"1"`, resp.Header.Get(fiber.HeaderETag))
+		var group = decodeBody[Group](t, resp)
+		a.Equal("10", group.Id)
+		a.Equal([]Ref{{Value: "1", Ref: "/scim/v2/Users/1", Display: "John Doe"}}, group.Members)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("patch without patch schema", func(t *testing.T) {
+		var app, repo, _, roles, _ = newTestApp()
+		var resp = send(t, app, fiber.MethodPatch, "/scim/v2/Groups/10", `{"Operations":[{"op":"remove","path":"members"}]}`)
+		a.Equal(fiber.StatusBadRequest, resp.StatusCode)
+		a.Equal("invalidSyntax", decodeBody[Error](t, resp).ScimType)
+		roles.AssertNotCalled(t, "FindById", int64(10))
+		repo.AssertNotCalled(t, "BeginTransaction")
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		var app, _, _, _, _ = newTestApp()
+		var resp = send(t, app, fiber.MethodPut, "/scim/v2/Groups/10", `{"displayName":`)
+		a.Equal(fiber.StatusBadRequest, resp.StatusCode)
+		a.Equal("invalidSyntax", decodeBody[Error](t, resp).ScimType)
+	})
+}
@@ -0,0 +1,154 @@
+package scim
+
+// Документы обнаружения SCIM (RFC 7643, разделы 5–7): какие возможности поддерживает сервис,
+// какие типы ресурсов он отдаёт и как устроены их схемы
+
+type attribute struct {
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	MultiValued   bool        `json:"multiValued"`
+	Required      bool        `json:"required"`
+	CaseExact     bool        `json:"caseExact"`
+	Mutability    string      `json:"mutability"`
+	Returned      string      `json:"returned"`
+	Uniqueness    string      `json:"uniqueness"`
+	Description   string      `json:"description,omitempty"`
+	SubAttributes []attribute `json:"subAttributes,omitempty"`
+}
+
+type schema struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Attributes  []attribute `json:"attributes"`
+	Meta        resourceRef `json:"meta"`
+}
+
+type resourceType struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id"`
+	Name        string      `json:"name"`
+	Endpoint    string      `json:"endpoint"`
+	Description string      `json:"description"`
+	Schema      string      `json:"schema"`
+	Meta        resourceRef `json:"meta"`
+}
+
+type resourceRef struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type serviceProviderConfig struct {
+	Schemas []string  `json:"schemas"`
+	Patch   supported `json:"patch"`
+	Bulk    struct {
+		Supported      bool `json:"supported"`
+		MaxOperations  int  `json:"maxOperations"`
+		MaxPayloadSize int  `json:"maxPayloadSize"`
+	} `json:"bulk"`
+	Filter struct {
+		Supported  bool `json:"supported"`
+		MaxResults int  `json:"maxResults"`
+	} `json:"filter"`
+	ChangePassword        supported   `json:"changePassword"`
+	Sort                  supported   `json:"sort"`
+	Etag                  supported   `json:"etag"`
+	AuthenticationSchemes []any       `json:"authenticationSchemes"`
+	Meta                  resourceRef `json:"meta"`
+}
+
+func newServiceProviderConfig() serviceProviderConfig {
+	var cfg = serviceProviderConfig{
+		Schemas:               []string{SchemaServiceProviderConfig},
+		Patch:                 supported{Supported: true},
+		ChangePassword:        supported{Supported: false},
+		Sort:                  supported{Supported: false},
+		Etag:                  supported{Supported: true},
+		AuthenticationSchemes: []any{},
+		Meta: resourceRef{
+			ResourceType: "ServiceProviderConfig",
+			Location:     BasePath + "/ServiceProviderConfig",
+		},
+	}
+	cfg.Filter.Supported = true
+	cfg.Filter.MaxResults = MaxResults
+	return cfg
+}
+
+var resourceTypes = []resourceType{
+	{
+		Schemas:     []string{SchemaResourceType},
+		Id:          "User",
+		Name:        "User",
+		Endpoint:    "/Users",
+		Description: "Employee",
+		Schema:      SchemaUser,
+		Meta:        resourceRef{ResourceType: "ResourceType", Location: BasePath + "/ResourceTypes/User"},
+	},
+	{
+		Schemas:     []string{SchemaResourceType},
+		Id:          "Group",
+		Name:        "Group",
+		Endpoint:    "/Groups",
+		Description: "Role with the employees it is assigned to",
+		Schema:      SchemaGroup,
+		Meta:        resourceRef{ResourceType: "ResourceType", Location: BasePath + "/ResourceTypes/Group"},
+	},
+}
+
+var refAttributes = []attribute{
+	{Name: "value", Type: "string", Mutability: "immutable", Returned: "default", Uniqueness: "none"},
+	{Name: "$ref", Type: "reference", Mutability: "immutable", Returned: "default", Uniqueness: "none"},
+	{Name: "display", Type: "string", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+}
+
+var schemas = []schema{
+	{
+		Schemas:     []string{SchemaSchema},
+		Id:          SchemaUser,
+		Name:        "User",
+		Description: "Employee",
+		Attributes: []attribute{
+			{
+				Name: "userName", Type: "string", Required: true, Mutability: "readWrite", Returned: "always",
+				Uniqueness: "server", Description: "Employee name",
+			},
+			{
+				Name: "displayName", Type: "string", Mutability: "readWrite", Returned: "default",
+				Uniqueness: "none", Description: "Same as userName",
+			},
+			{
+				Name: "active", Type: "boolean", Mutability: "readWrite", Returned: "default",
+				Uniqueness: "none", Description: "Always true, deactivation is not supported",
+			},
+			{
+				Name: "groups", Type: "complex", MultiValued: true, Mutability: "readOnly", Returned: "default",
+				Uniqueness: "none", Description: "Roles assigned to the employee", SubAttributes: refAttributes,
+			},
+		},
+		Meta: resourceRef{ResourceType: "Schema", Location: BasePath + "/Schemas/" + SchemaUser},
+	},
+	{
+		Schemas:     []string{SchemaSchema},
+		Id:          SchemaGroup,
+		Name:        "Group",
+		Description: "Role",
+		Attributes: []attribute{
+			{
+				Name: "displayName", Type: "string", Required: true, Mutability: "readWrite", Returned: "always",
+				Uniqueness: "none", Description: "Role name",
+			},
+			{
+				Name: "members", Type: "complex", MultiValued: true, Mutability: "readWrite", Returned: "default",
+				Uniqueness: "none", Description: "Employees the role is assigned to", SubAttributes: refAttributes,
+			},
+		},
+		Meta: resourceRef{ResourceType: "Schema", Location: BasePath + "/Schemas/" + SchemaGroup},
+	},
+}
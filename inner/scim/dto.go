@@ -0,0 +1,125 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+
+	// MIMEScimJson тип содержимого всех ответов SCIM
+	MIMEScimJson = "application/scim+json"
+)
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version,omitempty"`
+}
+
+// Ref ссылка на связанный ресурс: группа пользователя или участник группы
+type Ref struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// User ресурс SCIM, отображаемый на employee. userName и displayName соответствуют полю name
+type User struct {
+	Schemas     []string `json:"schemas"`
+	Id          string   `json:"id"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	Groups      []Ref    `json:"groups,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Group ресурс SCIM, отображаемый на role. Участники группы — сотрудники, которым назначена роль
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	Id          string   `json:"id"`
+	DisplayName string   `json:"displayName"`
+	Members     []Ref    `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type ListResponse[T any] struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []T      `json:"Resources"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ListRequest параметры запроса списка ресурсов
+type ListRequest struct {
+	Filter     string
+	StartIndex int
+	Count      int
+}
+
+// Error ответ с ошибкой в формате SCIM (RFC 7644, раздел 3.12)
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func (err *Error) Error() string {
+	return err.Detail
+}
+
+func (err *Error) StatusCode() int {
+	var code, _ = strconv.Atoi(err.Status)
+	return code
+}
+
+func newError(status int, scimType string, detail string) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+func invalidFilter(detail string) *Error {
+	return newError(400, "invalidFilter", "invalid filter: "+detail)
+}
+
+func invalidPath(path string) *Error {
+	return newError(400, "invalidPath", fmt.Sprintf("invalid path %q", path))
+}
+
+func invalidValue(detail string) *Error {
+	return newError(400, "invalidValue", detail)
+}
+
+func notFound(resourceType string, id string) *Error {
+	return newError(404, "", fmt.Sprintf("%s %s not found", resourceType, id))
+}
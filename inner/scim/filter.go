@@ -0,0 +1,226 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Filter разобранное выражение фильтра SCIM (RFC 7644, раздел 3.4.2.2).
+// Поддерживаются операторы eq, co, sw, логические and/or и скобки
+type Filter interface {
+	Match(attrs Attributes) bool
+}
+
+// Attributes значения атрибутов ресурса по имени в нижнем регистре.
+// У многозначных атрибутов (members.value, groups.value) значений может быть несколько
+type Attributes map[string][]string
+
+type logicalFilter struct {
+	op    string
+	left  Filter
+	right Filter
+}
+
+func (f logicalFilter) Match(attrs Attributes) bool {
+	if f.op == "and" {
+		return f.left.Match(attrs) && f.right.Match(attrs)
+	}
+	return f.left.Match(attrs) || f.right.Match(attrs)
+}
+
+type compareFilter struct {
+	attr  string
+	op    string
+	value string
+}
+
+// Match сравнивает значения без учёта регистра: все атрибуты, которые отдаёт сервис,
+// объявлены в схемах с caseExact = false
+func (f compareFilter) Match(attrs Attributes) bool {
+	var want = strings.ToLower(f.value)
+	for _, v := range attrs[f.attr] {
+		var got = strings.ToLower(v)
+		switch f.op {
+		case "eq":
+			if got == want {
+				return true
+			}
+		case "co":
+			if strings.Contains(got, want) {
+				return true
+			}
+		case "sw":
+			if strings.HasPrefix(got, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseFilter разбирает выражение фильтра, например `userName sw "j" and groups.value eq "2"`
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	var p = parser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilter(fmt.Sprintf("unexpected %q", p.tokens[p.pos].text))
+	}
+	return filter, nil
+}
+
+// ParsePath разбирает путь операции PATCH: "displayName", "members" или `members[value eq "1"]`.
+// Возвращает имя атрибута в нижнем регистре и фильтр значений, если он указан
+func ParsePath(path string) (string, Filter, error) {
+	var open = strings.Index(path, "[")
+	if open < 0 {
+		return normalizeAttr(path), nil, nil
+	}
+	if !strings.HasSuffix(path, "]") {
+		return "", nil, invalidPath(path)
+	}
+	filter, err := ParseFilter(path[open+1 : len(path)-1])
+	if err != nil {
+		return "", nil, invalidPath(path)
+	}
+	return normalizeAttr(path[:open]), filter, nil
+}
+
+// normalizeAttr убирает из имени атрибута URN схемы и приводит его к нижнему регистру
+func normalizeAttr(attr string) string {
+	attr = strings.TrimSpace(attr)
+	if strings.HasPrefix(strings.ToLower(attr), "urn:") {
+		attr = attr[strings.LastIndex(attr, ":")+1:]
+	}
+	return strings.ToLower(attr)
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	var i = 0
+	for i < len(expr) {
+		var c = expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case c == '"':
+			// строка в кавычках записывается по правилам JSON, поэтому и разбираем её как JSON
+			var end = i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, invalidFilter("unterminated string")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(expr[i:end+1]), &value); err != nil {
+				return nil, invalidFilter("invalid string " + expr[i:end+1])
+			}
+			tokens = append(tokens, token{text: value, quoted: true})
+			i = end + 1
+		default:
+			var end = i
+			for end < len(expr) && !strings.ContainsRune(" \t()\"", rune(expr[end])) {
+				end++
+			}
+			tokens = append(tokens, token{text: expr[i:end]})
+			i = end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, invalidFilter("empty filter")
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseTerm() (Filter, error) {
+	if p.peekKeyword("(") {
+		p.pos++
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekKeyword(")") {
+			return nil, invalidFilter("missing closing parenthesis")
+		}
+		p.pos++
+		return filter, nil
+	}
+
+	if p.pos+2 >= len(p.tokens) {
+		return nil, invalidFilter("incomplete expression")
+	}
+	var attr, op, value = p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	if attr.quoted || op.quoted {
+		return nil, invalidFilter(fmt.Sprintf("unexpected %q", attr.text))
+	}
+	var operator = strings.ToLower(op.text)
+	if operator != "eq" && operator != "co" && operator != "sw" {
+		return nil, invalidFilter("unsupported operator " + op.text)
+	}
+	// без кавычек допускаются true, false и числа, они сравниваются как строки
+	if !value.quoted && (value.text == "(" || value.text == ")") {
+		return nil, invalidFilter("missing value")
+	}
+	p.pos += 3
+	return compareFilter{attr: normalizeAttr(attr.text), op: operator, value: value.text}, nil
+}
@@ -0,0 +1,78 @@
+package scim
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	var attrs = Attributes{
+		"id":           {"1"},
+		"username":     {"John Doe"},
+		"active":       {"true"},
+		"groups.value": {"2", "3"},
+	}
+	tests := []struct {
+		name   string
+		filter string
+		want   bool
+	}{
+		{name: "eq ignores case", filter: `userName eq "john doe"`, want: true},
+		{name: "eq does not match", filter: `userName eq "John"`, want: false},
+		{name: "co", filter: `userName co "n D"`, want: true},
+		{name: "sw", filter: `userName sw "Jo"`, want: true},
+		{name: "sw does not match", filter: `userName sw "Doe"`, want: false},
+		{name: "multi-valued attribute", filter: `groups.value eq "3"`, want: true},
+		{name: "schema urn prefix", filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "John Doe"`, want: true},
+		{name: "boolean value", filter: `active eq true`, want: true},
+		{name: "and", filter: `userName sw "J" and id eq "2"`, want: false},
+		{name: "or", filter: `userName sw "X" or id eq "1"`, want: true},
+		{name: "and binds tighter than or", filter: `id eq "1" or id eq "2" and userName eq "X"`, want: true},
+		{name: "parentheses", filter: `(id eq "1" or id eq "2") and userName eq "X"`, want: false},
+		{name: "unknown attribute", filter: `externalId eq "1"`, want: false},
+		{name: "escaped quote", filter: `userName eq "John \"Doe\""`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.filter)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, filter.Match(attrs))
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	var a = assert.New(t)
+	for _, expr := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName gt "a"`,
+		`userName eq "a`,
+		`(userName eq "a"`,
+		`userName eq "a" and`,
+		`userName eq "a" id eq "1"`,
+	} {
+		_, err := ParseFilter(expr)
+		var scimErr *Error
+		a.ErrorAs(err, &scimErr, expr)
+		a.Equal("invalidFilter", scimErr.ScimType, expr)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	var a = assert.New(t)
+	attr, filter, err := ParsePath("displayName")
+	a.Nil(err)
+	a.Equal("displayname", attr)
+	a.Nil(filter)
+
+	attr, filter, err = ParsePath(`members[value eq "7"]`)
+	a.Nil(err)
+	a.Equal("members", attr)
+	a.True(filter.Match(Attributes{"value": {"7"}}))
+	a.False(filter.Match(Attributes{"value": {"8"}}))
+
+	_, _, err = ParsePath(`members[value eq "7"`)
+	a.NotNil(err)
+}
@@ -0,0 +1,18 @@
+package scim
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// Repository открывает транзакции, в которых изменения ролей и назначений группы SCIM применяются вместе
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
@@ -0,0 +1,769 @@
+package scim
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/role"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BasePath префикс маршрутов SCIM, из него строятся ссылки meta.location и $ref
+const BasePath = "/scim/v2"
+
+// MaxResults максимальное количество ресурсов в одном ответе на запрос списка
+const MaxResults = 200
+
+// Сервис отображает ресурсы SCIM на employee, role и назначения ролей
+type Service struct {
+	repo        Repo
+	employees   EmployeeSvc
+	roles       RoleSvc
+	assignments AssignmentSvc
+}
+
+// Repo открывает транзакцию, общую для роли и её назначений
+type Repo interface {
+	BeginTransaction() (*sqlx.Tx, error)
+}
+
+// интерфейс сервиса employee.Service
+type EmployeeSvc interface {
+	FindById(request employee.ParamIdRequest) (employee.Response, error)
+	FindAll() ([]employee.Response, error)
+	FilterByIDs(request employee.ParamIdsRequest) ([]employee.Response, error)
+	CreateEmployee(request employee.CreateRequest) (int64, error)
+	UpdateEmployee(request employee.UpdateRequest) (employee.Response, error)
+	DeleteByIdVersion(request employee.ParamIdVersionRequest) (int64, error)
+}
+
+// интерфейс сервиса role.Service
+type RoleSvc interface {
+	FindById(id int64) (role.Response, error)
+	FindAll() ([]role.Response, error)
+	CreateRoleTx(tx *sqlx.Tx, e role.Entity) (int64, error)
+	UpdateTx(tx *sqlx.Tx, e role.Entity) (role.Response, error)
+	DeleteByIdVersion(id int64, version int64) (int64, error)
+}
+
+// интерфейс сервиса assignment.Service
+type AssignmentSvc interface {
+	FindAll() ([]assignment.Response, error)
+	FindByEmployeeId(employeeId int64) ([]assignment.Response, error)
+	FindByRoleId(roleId int64) ([]assignment.Response, error)
+	SetRoleMembersTx(tx *sqlx.Tx, request assignment.SetRoleMembersRequest) ([]provisioning.Event, error)
+	Publish(events []provisioning.Event)
+}
+
+func NewService(repo Repo, employees EmployeeSvc, roles RoleSvc, assignments AssignmentSvc) *Service {
+	return &Service{
+		repo:        repo,
+		employees:   employees,
+		roles:       roles,
+		assignments: assignments,
+	}
+}
+
+func (srv *Service) ListUsers(request ListRequest) (ListResponse[User], error) {
+	filter, err := parseListFilter(request.Filter)
+	if err != nil {
+		return ListResponse[User]{}, err
+	}
+	employees, err := srv.employees.FindAll()
+	if err != nil {
+		return ListResponse[User]{}, toError(err, "User", "")
+	}
+	groups, err := srv.groupRefsByEmployee()
+	if err != nil {
+		return ListResponse[User]{}, err
+	}
+
+	var users []User
+	for _, e := range employees {
+		var user = toUser(e, groups[e.Id])
+		if filter == nil || filter.Match(userAttributes(user)) {
+			users = append(users, user)
+		}
+	}
+	return paginate(users, request), nil
+}
+
+func (srv *Service) GetUser(id string) (User, error) {
+	employeeId, err := parseId("User", id)
+	if err != nil {
+		return User{}, err
+	}
+	e, err := srv.employees.FindById(employee.ParamIdRequest{Id: employeeId})
+	if err != nil {
+		return User{}, toError(err, "User", id)
+	}
+	return srv.userWithGroups(e)
+}
+
+func (srv *Service) CreateUser(user User) (User, error) {
+	var name = userName(user)
+	if name == "" {
+		return User{}, invalidValue("userName is required")
+	}
+	id, err := srv.employees.CreateEmployee(employee.CreateRequest{Name: name})
+	if err != nil {
+		return User{}, toError(err, "User", "")
+	}
+	return srv.GetUser(strconv.FormatInt(id, 10))
+}
+
+// ReplaceUser заменяет пользователя целиком (PUT). version равна нулю, если клиент не передал If-Match
+func (srv *Service) ReplaceUser(id string, version int64, user User) (User, error) {
+	var name = userName(user)
+	if name == "" {
+		return User{}, invalidValue("userName is required")
+	}
+	if user.Active != nil && !*user.Active {
+		return User{}, deactivationNotSupported()
+	}
+	current, err := srv.currentEmployee(id, version)
+	if err != nil {
+		return User{}, err
+	}
+	return srv.renameEmployee(id, current, name)
+}
+
+// PatchUser применяет операции PATCH к пользователю. version равна нулю, если клиент не передал If-Match
+func (srv *Service) PatchUser(id string, version int64, patch PatchRequest) (User, error) {
+	current, err := srv.currentEmployee(id, version)
+	if err != nil {
+		return User{}, err
+	}
+	var name = current.Name
+	for _, op := range patch.Operations {
+		name, err = applyUserOperation(op, name)
+		if err != nil {
+			return User{}, err
+		}
+	}
+	return srv.renameEmployee(id, current, name)
+}
+
+func (srv *Service) DeleteUser(id string, version int64) error {
+	current, err := srv.currentEmployee(id, version)
+	if err != nil {
+		return err
+	}
+	_, err = srv.employees.DeleteByIdVersion(employee.ParamIdVersionRequest{Id: current.Id, Version: current.Version})
+	if err != nil {
+		return toError(err, "User", id)
+	}
+	return nil
+}
+
+func (srv *Service) ListGroups(request ListRequest) (ListResponse[Group], error) {
+	filter, err := parseListFilter(request.Filter)
+	if err != nil {
+		return ListResponse[Group]{}, err
+	}
+	roles, err := srv.roles.FindAll()
+	if err != nil {
+		return ListResponse[Group]{}, toError(err, "Group", "")
+	}
+	members, err := srv.memberRefsByRole()
+	if err != nil {
+		return ListResponse[Group]{}, err
+	}
+
+	var groups []Group
+	for _, r := range roles {
+		var group = toGroup(r, members[r.Id])
+		if filter == nil || filter.Match(groupAttributes(group)) {
+			groups = append(groups, group)
+		}
+	}
+	return paginate(groups, request), nil
+}
+
+func (srv *Service) GetGroup(id string) (Group, error) {
+	roleId, err := parseId("Group", id)
+	if err != nil {
+		return Group{}, err
+	}
+	r, err := srv.roles.FindById(roleId)
+	if err != nil {
+		return Group{}, toError(err, "Group", id)
+	}
+	return srv.groupWithMembers(r)
+}
+
+func (srv *Service) CreateGroup(group Group) (Group, error) {
+	if strings.TrimSpace(group.DisplayName) == "" {
+		return Group{}, invalidValue("displayName is required")
+	}
+	memberIds, err := srv.memberIds(group.Members)
+	if err != nil {
+		return Group{}, err
+	}
+	roleId, err := srv.saveGroup(role.Response{}, group.DisplayName, memberIds)
+	if err != nil {
+		return Group{}, err
+	}
+	return srv.GetGroup(strconv.FormatInt(roleId, 10))
+}
+
+// ReplaceGroup заменяет группу целиком (PUT), включая список участников
+func (srv *Service) ReplaceGroup(id string, version int64, group Group) (Group, error) {
+	if strings.TrimSpace(group.DisplayName) == "" {
+		return Group{}, invalidValue("displayName is required")
+	}
+	current, err := srv.currentRole(id, version)
+	if err != nil {
+		return Group{}, err
+	}
+	memberIds, err := srv.memberIds(group.Members)
+	if err != nil {
+		return Group{}, err
+	}
+	if _, err = srv.saveGroup(current, group.DisplayName, memberIds); err != nil {
+		return Group{}, err
+	}
+	return srv.GetGroup(id)
+}
+
+// PatchGroup применяет операции PATCH к группе: изменение displayName,
+// добавление, удаление и замену участников
+func (srv *Service) PatchGroup(id string, version int64, patch PatchRequest) (Group, error) {
+	current, err := srv.currentRole(id, version)
+	if err != nil {
+		return Group{}, err
+	}
+	group, err := srv.groupWithMembers(current)
+	if err != nil {
+		return Group{}, err
+	}
+	for _, op := range patch.Operations {
+		if err = applyGroupOperation(op, &group); err != nil {
+			return Group{}, err
+		}
+	}
+
+	memberIds, err := srv.memberIds(group.Members)
+	if err != nil {
+		return Group{}, err
+	}
+	if _, err = srv.saveGroup(current, group.DisplayName, memberIds); err != nil {
+		return Group{}, err
+	}
+	return srv.GetGroup(id)
+}
+
+func (srv *Service) DeleteGroup(id string, version int64) error {
+	current, err := srv.currentRole(id, version)
+	if err != nil {
+		return err
+	}
+	_, err = srv.roles.DeleteByIdVersion(current.Id, current.Version)
+	if err != nil {
+		return toError(err, "Group", id)
+	}
+	return nil
+}
+
+// currentEmployee читает сотрудника и сверяет его версию с переданной в If-Match
+func (srv *Service) currentEmployee(id string, version int64) (employee.Response, error) {
+	employeeId, err := parseId("User", id)
+	if err != nil {
+		return employee.Response{}, err
+	}
+	current, err := srv.employees.FindById(employee.ParamIdRequest{Id: employeeId})
+	if err != nil {
+		return employee.Response{}, toError(err, "User", id)
+	}
+	if version > 0 && version != current.Version {
+		return employee.Response{}, versionMismatch("User", id)
+	}
+	return current, nil
+}
+
+func (srv *Service) currentRole(id string, version int64) (role.Response, error) {
+	roleId, err := parseId("Group", id)
+	if err != nil {
+		return role.Response{}, err
+	}
+	current, err := srv.roles.FindById(roleId)
+	if err != nil {
+		return role.Response{}, toError(err, "Group", id)
+	}
+	if version > 0 && version != current.Version {
+		return role.Response{}, versionMismatch("Group", id)
+	}
+	return current, nil
+}
+
+func (srv *Service) renameEmployee(id string, current employee.Response, name string) (User, error) {
+	if name == "" {
+		return User{}, invalidValue("userName is required")
+	}
+	if name != current.Name {
+		// SCIM меняет только имя, атрибуты из HR сохраняются
+		var _, err = srv.employees.UpdateEmployee(employee.UpdateRequest{
+			Id:         current.Id,
+			Name:       name,
+			Version:    current.Version,
+			Attributes: current.Attributes,
+		})
+		if err != nil {
+			return User{}, toError(err, "User", id)
+		}
+	}
+	return srv.GetUser(id)
+}
+
+// saveGroup в одной транзакции создаёт роль, если current.Id равен нулю, или переименовывает её,
+// и задаёт участников группы. События для внешних систем публикуются после коммита
+func (srv *Service) saveGroup(current role.Response, name string, memberIds []int64) (int64, error) {
+	if strings.TrimSpace(name) == "" {
+		return 0, invalidValue("displayName is required")
+	}
+	var roleId = current.Id
+	var events []provisioning.Event
+	var err = database.InTransaction(srv.repo.BeginTransaction, "saving group", func(tx *sqlx.Tx) (err error) {
+		if roleId == 0 {
+			if roleId, err = srv.roles.CreateRoleTx(tx, role.Entity{Name: name}); err != nil {
+				return toError(err, "Group", "")
+			}
+		} else if name != current.Name {
+			if _, err = srv.roles.UpdateTx(tx, role.Entity{Id: current.Id, Name: name, Version: current.Version}); err != nil {
+				return toError(err, "Group", strconv.FormatInt(roleId, 10))
+			}
+		}
+		events, err = srv.assignments.SetRoleMembersTx(tx, assignment.SetRoleMembersRequest{RoleId: roleId, EmployeeIds: memberIds})
+		if err != nil {
+			return toError(err, "Group", strconv.FormatInt(roleId, 10))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	srv.assignments.Publish(events)
+	return roleId, nil
+}
+
+// memberIds проверяет, что все участники группы существуют, и возвращает их идентификаторы
+func (srv *Service) memberIds(members []Ref) ([]int64, error) {
+	var ids = make([]int64, 0, len(members))
+	var seen = map[int64]bool{}
+	for _, m := range members {
+		id, err := strconv.ParseInt(m.Value, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, invalidValue(fmt.Sprintf("invalid member %q", m.Value))
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	found, err := srv.employees.FilterByIDs(employee.ParamIdsRequest{Ids: ids})
+	if err != nil {
+		return nil, toError(err, "User", "")
+	}
+	if len(found) != len(ids) {
+		for _, e := range found {
+			delete(seen, e.Id)
+		}
+		var missing []string
+		for id := range seen {
+			missing = append(missing, strconv.FormatInt(id, 10))
+		}
+		sort.Strings(missing)
+		return nil, invalidValue("unknown members: " + strings.Join(missing, ", "))
+	}
+	return ids, nil
+}
+
+func (srv *Service) userWithGroups(e employee.Response) (User, error) {
+	assignments, err := srv.assignments.FindByEmployeeId(e.Id)
+	if err != nil {
+		return User{}, toError(err, "User", "")
+	}
+	var groups []Ref
+	for _, a := range assignments {
+		r, err := srv.roles.FindById(a.RoleId)
+		if err != nil {
+			return User{}, toError(err, "Group", "")
+		}
+		groups = append(groups, groupRef(r))
+	}
+	return toUser(e, groups), nil
+}
+
+func (srv *Service) groupWithMembers(r role.Response) (Group, error) {
+	assignments, err := srv.assignments.FindByRoleId(r.Id)
+	if err != nil {
+		return Group{}, toError(err, "Group", "")
+	}
+	var members []Ref
+	if len(assignments) > 0 {
+		var ids []int64
+		for _, a := range assignments {
+			ids = append(ids, a.EmployeeId)
+		}
+		employees, err := srv.employees.FilterByIDs(employee.ParamIdsRequest{Ids: ids})
+		if err != nil {
+			return Group{}, toError(err, "User", "")
+		}
+		for _, e := range employees {
+			members = append(members, memberRef(e))
+		}
+	}
+	return toGroup(r, members), nil
+}
+
+// groupRefsByEmployee группы всех сотрудников, собранные за два запроса вместо запроса на каждого сотрудника
+func (srv *Service) groupRefsByEmployee() (map[int64][]Ref, error) {
+	roles, err := srv.roles.FindAll()
+	if err != nil {
+		return nil, toError(err, "Group", "")
+	}
+	assignments, err := srv.assignments.FindAll()
+	if err != nil {
+		return nil, toError(err, "Group", "")
+	}
+	var byId = map[int64]role.Response{}
+	for _, r := range roles {
+		byId[r.Id] = r
+	}
+	var refs = map[int64][]Ref{}
+	for _, a := range assignments {
+		refs[a.EmployeeId] = append(refs[a.EmployeeId], groupRef(byId[a.RoleId]))
+	}
+	return refs, nil
+}
+
+// memberRefsByRole участники всех групп, собранные за два запроса
+func (srv *Service) memberRefsByRole() (map[int64][]Ref, error) {
+	employees, err := srv.employees.FindAll()
+	if err != nil {
+		return nil, toError(err, "User", "")
+	}
+	assignments, err := srv.assignments.FindAll()
+	if err != nil {
+		return nil, toError(err, "Group", "")
+	}
+	var byId = map[int64]employee.Response{}
+	for _, e := range employees {
+		byId[e.Id] = e
+	}
+	var refs = map[int64][]Ref{}
+	for _, a := range assignments {
+		refs[a.RoleId] = append(refs[a.RoleId], memberRef(byId[a.EmployeeId]))
+	}
+	return refs, nil
+}
+
+func applyUserOperation(op PatchOperation, name string) (string, error) {
+	var operation = strings.ToLower(op.Op)
+	if operation != "add" && operation != "replace" && operation != "remove" {
+		return "", invalidValue(fmt.Sprintf("unsupported operation %q", op.Op))
+	}
+	// без пути значение — объект с заменяемыми атрибутами
+	var values = map[string]json.RawMessage{}
+	if op.Path == "" {
+		if operation == "remove" {
+			return "", newError(400, "noTarget", "remove operation requires a path")
+		}
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return "", invalidValue("value must be an object when path is omitted")
+		}
+	} else {
+		attr, filter, err := ParsePath(op.Path)
+		if err != nil {
+			return "", err
+		}
+		if filter != nil {
+			return "", invalidPath(op.Path)
+		}
+		if operation == "remove" {
+			if attr == "username" || attr == "displayname" {
+				return "", newError(400, "mutability", attr+" is required and cannot be removed")
+			}
+			return "", invalidPath(op.Path)
+		}
+		values[op.Path] = op.Value
+	}
+
+	for path, raw := range values {
+		switch normalizeAttr(path) {
+		case "username", "displayname":
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil || strings.TrimSpace(value) == "" {
+				return "", invalidValue(path + " must be a non-empty string")
+			}
+			name = value
+		case "active":
+			var active bool
+			if err := json.Unmarshal(raw, &active); err != nil {
+				return "", invalidValue("active must be a boolean")
+			}
+			if !active {
+				return "", deactivationNotSupported()
+			}
+		default:
+			return "", invalidPath(path)
+		}
+	}
+	return name, nil
+}
+
+func applyGroupOperation(op PatchOperation, group *Group) error {
+	var operation = strings.ToLower(op.Op)
+	if operation != "add" && operation != "replace" && operation != "remove" {
+		return invalidValue(fmt.Sprintf("unsupported operation %q", op.Op))
+	}
+	if op.Path == "" {
+		if operation == "remove" {
+			return newError(400, "noTarget", "remove operation requires a path")
+		}
+		var values = map[string]json.RawMessage{}
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return invalidValue("value must be an object when path is omitted")
+		}
+		for path, raw := range values {
+			if err := applyGroupAttribute(operation, path, nil, raw, group); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	attr, filter, err := ParsePath(op.Path)
+	if err != nil {
+		return err
+	}
+	return applyGroupAttribute(operation, attr, filter, op.Value, group)
+}
+
+func applyGroupAttribute(operation string, path string, filter Filter, raw json.RawMessage, group *Group) error {
+	switch normalizeAttr(path) {
+	case "displayname":
+		if operation == "remove" {
+			return newError(400, "mutability", "displayName is required and cannot be removed")
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil || strings.TrimSpace(value) == "" {
+			return invalidValue("displayName must be a non-empty string")
+		}
+		group.DisplayName = value
+	case "members":
+		var members []Ref
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &members); err != nil {
+				return invalidValue("members must be an array of objects with value")
+			}
+		}
+		switch operation {
+		case "add":
+			group.Members = append(group.Members, members...)
+		case "replace":
+			group.Members = members
+		case "remove":
+			group.Members = removeMembers(group.Members, filter, members)
+		}
+	default:
+		return invalidPath(path)
+	}
+	return nil
+}
+
+// removeMembers удаляет участников, подходящих под фильтр пути или перечисленных в значении.
+// Без фильтра и значения удаляются все участники
+func removeMembers(members []Ref, filter Filter, listed []Ref) []Ref {
+	if filter == nil && len(listed) == 0 {
+		return nil
+	}
+	var drop = map[string]bool{}
+	for _, m := range listed {
+		drop[m.Value] = true
+	}
+	var kept []Ref
+	for _, m := range members {
+		if drop[m.Value] {
+			continue
+		}
+		if filter != nil && filter.Match(Attributes{"value": {m.Value}, "display": {m.Display}}) {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+func toUser(e employee.Response, groups []Ref) User {
+	var id = strconv.FormatInt(e.Id, 10)
+	// у сотрудников нет статуса, существующий сотрудник всегда активен
+	var active = true
+	return User{
+		Schemas:     []string{SchemaUser},
+		Id:          id,
+		UserName:    e.Name,
+		DisplayName: e.Name,
+		Active:      &active,
+		Groups:      groups,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      e.CreatedAt,
+			LastModified: e.UpdatedAt,
+			Location:     BasePath + "/Users/" + id,
+			Version:      common.WeakETag(e.Version),
+		},
+	}
+}
+
+func toGroup(r role.Response, members []Ref) Group {
+	var id = strconv.FormatInt(r.Id, 10)
+	return Group{
+		Schemas:     []string{SchemaGroup},
+		Id:          id,
+		DisplayName: r.Name,
+		Members:     members,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      r.CreatedAt,
+			LastModified: r.UpdatedAt,
+			Location:     BasePath + "/Groups/" + id,
+			Version:      common.WeakETag(r.Version),
+		},
+	}
+}
+
+func groupRef(r role.Response) Ref {
+	var id = strconv.FormatInt(r.Id, 10)
+	return Ref{Value: id, Ref: BasePath + "/Groups/" + id, Display: r.Name}
+}
+
+func memberRef(e employee.Response) Ref {
+	var id = strconv.FormatInt(e.Id, 10)
+	return Ref{Value: id, Ref: BasePath + "/Users/" + id, Display: e.Name}
+}
+
+func userAttributes(u User) Attributes {
+	var attrs = Attributes{
+		"id":          {u.Id},
+		"username":    {u.UserName},
+		"displayname": {u.DisplayName},
+		"active":      {strconv.FormatBool(u.Active != nil && *u.Active)},
+	}
+	for _, g := range u.Groups {
+		attrs["groups"] = append(attrs["groups"], g.Value)
+		attrs["groups.value"] = append(attrs["groups.value"], g.Value)
+		attrs["groups.display"] = append(attrs["groups.display"], g.Display)
+	}
+	return attrs
+}
+
+func groupAttributes(g Group) Attributes {
+	var attrs = Attributes{
+		"id":          {g.Id},
+		"displayname": {g.DisplayName},
+	}
+	for _, m := range g.Members {
+		attrs["members"] = append(attrs["members"], m.Value)
+		attrs["members.value"] = append(attrs["members.value"], m.Value)
+		attrs["members.display"] = append(attrs["members.display"], m.Display)
+	}
+	return attrs
+}
+
+// userName имя сотрудника из ресурса: userName, а если он не задан — displayName
+func userName(u User) string {
+	if strings.TrimSpace(u.UserName) != "" {
+		return u.UserName
+	}
+	return strings.TrimSpace(u.DisplayName)
+}
+
+func parseListFilter(expr string) (Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	return ParseFilter(expr)
+}
+
+// paginate возвращает страницу ресурсов. startIndex в SCIM начинается с единицы
+func paginate[T any](resources []T, request ListRequest) ListResponse[T] {
+	var start = request.StartIndex
+	if start < 1 {
+		start = 1
+	}
+	var count = request.Count
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxResults {
+		count = MaxResults
+	}
+
+	var page = []T{}
+	if start <= len(resources) {
+		var end = start - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[start-1 : end]
+	}
+	return ListResponse[T]{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+func parseId(resourceType string, id string) (int64, error) {
+	var value, err = strconv.ParseInt(id, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, notFound(resourceType, id)
+	}
+	return value, nil
+}
+
+func versionMismatch(resourceType string, id string) *Error {
+	return newError(412, "", fmt.Sprintf("%s %s was modified by another request", resourceType, id))
+}
+
+func deactivationNotSupported() *Error {
+	return newError(400, "mutability", "deactivating users is not supported, delete the user instead")
+}
+
+// toError переводит ошибки сервисов в ошибки SCIM с подходящим HTTP статусом
+func toError(err error, resourceType string, id string) error {
+	var scimErr *Error
+	switch {
+	case errors.As(err, &scimErr):
+		return scimErr
+	case errors.As(err, &common.RequestValidationError{}):
+		return invalidValue(err.Error())
+	case errors.As(err, &common.AlreadyExistsError{}):
+		return newError(409, "uniqueness", err.Error())
+	case errors.As(err, &common.NotFoundError{}) || errors.Is(err, sql.ErrNoRows):
+		return notFound(resourceType, id)
+	case errors.As(err, &common.ConflictError{}):
+		return newError(412, "", err.Error())
+	case errors.As(err, &common.PolicyViolationError{}):
+		return newError(403, "", err.Error())
+	default:
+		return newError(500, "", err.Error())
+	}
+}
@@ -0,0 +1,364 @@
+package scim
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/provisioning"
+	"github.com/zhedevops/idm/inner/role"
+	"testing"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+type MockEmployeeSvc struct {
+	mock.Mock
+}
+
+func (m *MockEmployeeSvc) FindById(request employee.ParamIdRequest) (employee.Response, error) {
+	args := m.Called(request)
+	return args.Get(0).(employee.Response), args.Error(1)
+}
+
+func (m *MockEmployeeSvc) FindAll() ([]employee.Response, error) {
+	args := m.Called()
+	return args.Get(0).([]employee.Response), args.Error(1)
+}
+
+func (m *MockEmployeeSvc) FilterByIDs(request employee.ParamIdsRequest) ([]employee.Response, error) {
+	args := m.Called(request)
+	return args.Get(0).([]employee.Response), args.Error(1)
+}
+
+func (m *MockEmployeeSvc) CreateEmployee(request employee.CreateRequest) (int64, error) {
+	args := m.Called(request)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockEmployeeSvc) UpdateEmployee(request employee.UpdateRequest) (employee.Response, error) {
+	args := m.Called(request)
+	return args.Get(0).(employee.Response), args.Error(1)
+}
+
+func (m *MockEmployeeSvc) DeleteByIdVersion(request employee.ParamIdVersionRequest) (int64, error) {
+	args := m.Called(request)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type MockRoleSvc struct {
+	mock.Mock
+}
+
+func (m *MockRoleSvc) FindById(id int64) (role.Response, error) {
+	args := m.Called(id)
+	return args.Get(0).(role.Response), args.Error(1)
+}
+
+func (m *MockRoleSvc) FindAll() ([]role.Response, error) {
+	args := m.Called()
+	return args.Get(0).([]role.Response), args.Error(1)
+}
+
+func (m *MockRoleSvc) CreateRoleTx(tx *sqlx.Tx, e role.Entity) (int64, error) {
+	args := m.Called(tx, e)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRoleSvc) UpdateTx(tx *sqlx.Tx, e role.Entity) (role.Response, error) {
+	args := m.Called(tx, e)
+	return args.Get(0).(role.Response), args.Error(1)
+}
+
+func (m *MockRoleSvc) DeleteByIdVersion(id int64, version int64) (int64, error) {
+	args := m.Called(id, version)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type MockAssignmentSvc struct {
+	mock.Mock
+}
+
+func (m *MockAssignmentSvc) FindAll() ([]assignment.Response, error) {
+	args := m.Called()
+	return args.Get(0).([]assignment.Response), args.Error(1)
+}
+
+func (m *MockAssignmentSvc) FindByEmployeeId(employeeId int64) ([]assignment.Response, error) {
+	args := m.Called(employeeId)
+	return args.Get(0).([]assignment.Response), args.Error(1)
+}
+
+func (m *MockAssignmentSvc) FindByRoleId(roleId int64) ([]assignment.Response, error) {
+	args := m.Called(roleId)
+	return args.Get(0).([]assignment.Response), args.Error(1)
+}
+
+func (m *MockAssignmentSvc) SetRoleMembersTx(tx *sqlx.Tx, request assignment.SetRoleMembersRequest) ([]provisioning.Event, error) {
+	args := m.Called(tx, request)
+	return args.Get(0).([]provisioning.Event), args.Error(1)
+}
+
+func (m *MockAssignmentSvc) Publish(events []provisioning.Event) {
+	m.Called(events)
+}
+
+func newTestService() (*Service, *MockRepo, *MockEmployeeSvc, *MockRoleSvc, *MockAssignmentSvc) {
+	var repo = new(MockRepo)
+	var employees = new(MockEmployeeSvc)
+	var roles = new(MockRoleSvc)
+	var assignments = new(MockAssignmentSvc)
+	return NewService(repo, employees, roles, assignments), repo, employees, roles, assignments
+}
+
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+func patch(operations ...string) PatchRequest {
+	var request = PatchRequest{Schemas: []string{SchemaPatchOp}}
+	for _, op := range operations {
+		var operation PatchOperation
+		if err := json.Unmarshal([]byte(op), &operation); err != nil {
+			panic(err)
+		}
+		request.Operations = append(request.Operations, operation)
+	}
+	return request
+}
+
+func scimStatus(err error) string {
+	if scimErr, ok := err.(*Error); ok {
+		return scimErr.Status + " " + scimErr.ScimType
+	}
+	return fmt.Sprintf("not a scim error: %v", err)
+}
+
+func TestListUsers(t *testing.T) {
+	var a = assert.New(t)
+	var svc, _, employees, roles, assignments = newTestService()
+	employees.On("FindAll").Return([]employee.Response{
+		{Id: 1, Name: "John Doe", Version: 1},
+		{Id: 2, Name: "Jane Doe", Version: 1},
+		{Id: 3, Name: "Bob Smith", Version: 1},
+	}, nil)
+	roles.On("FindAll").Return([]role.Response{{Id: 10, Name: "Admin"}}, nil)
+	assignments.On("FindAll").Return([]assignment.Response{{EmployeeId: 2, RoleId: 10}}, nil)
+
+	t.Run("filter and pagination", func(t *testing.T) {
+		var got, err = svc.ListUsers(ListRequest{Filter: `userName co "doe"`, StartIndex: 2, Count: 1})
+		a.Nil(err)
+		a.Equal(2, got.TotalResults)
+		a.Equal(2, got.StartIndex)
+		a.Equal(1, got.ItemsPerPage)
+		a.Equal("Jane Doe", got.Resources[0].UserName)
+		a.Equal([]Ref{{Value: "10", Ref: "/scim/v2/Groups/10", Display: "Admin"}}, got.Resources[0].Groups)
+		a.Equal(`W/"1"`, got.Resources[0].Meta.Version)
+	})
+
+	t.Run("filter by group", func(t *testing.T) {
+		var got, err = svc.ListUsers(ListRequest{Filter: `groups.value eq "10"`, StartIndex: 1, Count: 10})
+		a.Nil(err)
+		a.Equal(1, got.TotalResults)
+		a.Equal("2", got.Resources[0].Id)
+	})
+
+	t.Run("page past the end", func(t *testing.T) {
+		var got, err = svc.ListUsers(ListRequest{StartIndex: 10, Count: 10})
+		a.Nil(err)
+		a.Equal(3, got.TotalResults)
+		a.Empty(got.Resources)
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		var _, err = svc.ListUsers(ListRequest{Filter: `userName gt "a"`})
+		a.Equal("400 invalidFilter", scimStatus(err))
+	})
+}
+
+func TestGetUser(t *testing.T) {
+	var a = assert.New(t)
+	var svc, _, employees, _, _ = newTestService()
+	employees.On("FindById", employee.ParamIdRequest{Id: 5}).
+		Return(employee.Response{}, fmt.Errorf("error finding employee with id 5: %w", sql.ErrNoRows))
+	var _, err = svc.GetUser("5")
+	a.Equal("404 ", scimStatus(err))
+	_, err = svc.GetUser("abc")
+	a.Equal("404 ", scimStatus(err))
+}
+
+func TestCreateUser(t *testing.T) {
+	var a = assert.New(t)
+	var svc, _, employees, _, assignments = newTestService()
+	employees.On("CreateEmployee", employee.CreateRequest{Name: "John Doe"}).Return(int64(1), nil)
+	employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(employee.Response{Id: 1, Name: "John Doe", Version: 1}, nil)
+	assignments.On("FindByEmployeeId", int64(1)).Return([]assignment.Response{}, nil)
+	var got, err = svc.CreateUser(User{UserName: "John Doe"})
+	a.Nil(err)
+	a.Equal("1", got.Id)
+	a.True(*got.Active)
+
+	_, err = svc.CreateUser(User{})
+	a.Equal("400 invalidValue", scimStatus(err))
+}
+
+func TestPatchUser(t *testing.T) {
+	var a = assert.New(t)
+	var current = employee.Response{Id: 1, Name: "John Doe", Version: 3}
+
+	t.Run("replace userName", func(t *testing.T) {
+		var svc, _, employees, _, assignments = newTestService()
+		employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(current, nil)
+		employees.On("UpdateEmployee", employee.UpdateRequest{Id: 1, Name: "John Deer", Version: 3}).
+			Return(employee.Response{Id: 1, Name: "John Deer", Version: 4}, nil)
+		assignments.On("FindByEmployeeId", int64(1)).Return([]assignment.Response{}, nil)
+		var _, err = svc.PatchUser("1", 0, patch(`{"op": "Replace", "path": "userName", "value": "John Deer"}`))
+		a.Nil(err)
+		employees.AssertNumberOfCalls(t, "UpdateEmployee", 1)
+	})
+
+	t.Run("replace without path", func(t *testing.T) {
+		var svc, _, employees, _, assignments = newTestService()
+		employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(current, nil)
+		employees.On("UpdateEmployee", employee.UpdateRequest{Id: 1, Name: "John Deer", Version: 3}).
+			Return(employee.Response{}, nil)
+		assignments.On("FindByEmployeeId", int64(1)).Return([]assignment.Response{}, nil)
+		var _, err = svc.PatchUser("1", 3, patch(`{"op": "replace", "value": {"userName": "John Deer", "active": true}}`))
+		a.Nil(err)
+	})
+
+	t.Run("deactivation is not supported", func(t *testing.T) {
+		var svc, _, employees, _, _ = newTestService()
+		employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(current, nil)
+		var _, err = svc.PatchUser("1", 0, patch(`{"op": "replace", "path": "active", "value": false}`))
+		a.Equal("400 mutability", scimStatus(err))
+	})
+
+	t.Run("stale version", func(t *testing.T) {
+		var svc, _, employees, _, _ = newTestService()
+		employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(current, nil)
+		var _, err = svc.PatchUser("1", 2, patch(`{"op": "replace", "path": "userName", "value": "John Deer"}`))
+		a.Equal("412 ", scimStatus(err))
+	})
+
+	t.Run("unknown attribute", func(t *testing.T) {
+		var svc, _, employees, _, _ = newTestService()
+		employees.On("FindById", employee.ParamIdRequest{Id: 1}).Return(current, nil)
+		var _, err = svc.PatchUser("1", 0, patch(`{"op": "replace", "path": "title", "value": "CEO"}`))
+		a.Equal("400 invalidPath", scimStatus(err))
+	})
+}
+
+func TestPatchGroup(t *testing.T) {
+	var a = assert.New(t)
+	var current = role.Response{Id: 10, Name: "Admin", Version: 1}
+	var events = []provisioning.Event{{
+		Type:        provisioning.EntitlementAdded,
+		Account:     provisioning.Account{EmployeeId: 3, Name: "Bob Smith"},
+		Entitlement: provisioning.Entitlement{RoleId: 10, Name: "Admin"},
+	}}
+
+	var setup = func() (*Service, *MockRepo, *MockEmployeeSvc, *MockRoleSvc, *MockAssignmentSvc) {
+		var svc, repo, employees, roles, assignments = newTestService()
+		roles.On("FindById", int64(10)).Return(current, nil)
+		assignments.On("FindByRoleId", int64(10)).Return([]assignment.Response{
+			{EmployeeId: 1, RoleId: 10},
+			{EmployeeId: 2, RoleId: 10},
+		}, nil)
+		employees.On("FilterByIDs", employee.ParamIdsRequest{Ids: []int64{1, 2}}).Return([]employee.Response{
+			{Id: 1, Name: "John Doe"},
+			{Id: 2, Name: "Jane Doe"},
+		}, nil)
+		return svc, repo, employees, roles, assignments
+	}
+
+	t.Run("add and remove members", func(t *testing.T) {
+		var svc, repo, employees, _, assignments = setup()
+		var tx, sqlMock = newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		employees.On("FilterByIDs", employee.ParamIdsRequest{Ids: []int64{2, 3}}).Return([]employee.Response{
+			{Id: 2, Name: "Jane Doe"},
+			{Id: 3, Name: "Bob Smith"},
+		}, nil)
+		assignments.On("SetRoleMembersTx", tx, assignment.SetRoleMembersRequest{RoleId: 10, EmployeeIds: []int64{2, 3}}).Return(events, nil)
+		assignments.On("Publish", events).Return()
+		var _, err = svc.PatchGroup("10", 0, patch(
+			`{"op": "add", "path": "members", "value": [{"value": "3"}]}`,
+			`{"op": "remove", "path": "members[value eq \"1\"]"}`,
+		))
+		a.Nil(err)
+		a.Nil(sqlMock.ExpectationsWereMet())
+		assignments.AssertNumberOfCalls(t, "SetRoleMembersTx", 1)
+		assignments.AssertNumberOfCalls(t, "Publish", 1)
+	})
+
+	t.Run("rename group", func(t *testing.T) {
+		var svc, repo, _, roles, assignments = setup()
+		var tx, sqlMock = newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		roles.On("UpdateTx", tx, role.Entity{Id: 10, Name: "Administrators", Version: 1}).Return(role.Response{}, nil)
+		assignments.On("SetRoleMembersTx", tx, assignment.SetRoleMembersRequest{RoleId: 10, EmployeeIds: []int64{1, 2}}).
+			Return([]provisioning.Event(nil), nil)
+		assignments.On("Publish", []provisioning.Event(nil)).Return()
+		var _, err = svc.PatchGroup("10", 0, patch(`{"op": "replace", "value": {"displayName": "Administrators"}}`))
+		a.Nil(err)
+		a.Nil(sqlMock.ExpectationsWereMet())
+		roles.AssertNumberOfCalls(t, "UpdateTx", 1)
+	})
+
+	t.Run("failed members roll back rename", func(t *testing.T) {
+		var svc, repo, employees, roles, assignments = setup()
+		var tx, sqlMock = newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		employees.On("FilterByIDs", employee.ParamIdsRequest{Ids: []int64{1, 2, 3}}).Return([]employee.Response{
+			{Id: 1, Name: "John Doe"},
+			{Id: 2, Name: "Jane Doe"},
+			{Id: 3, Name: "Bob Smith"},
+		}, nil)
+		roles.On("UpdateTx", tx, role.Entity{Id: 10, Name: "Administrators", Version: 1}).Return(role.Response{}, nil)
+		assignments.On("SetRoleMembersTx", tx, assignment.SetRoleMembersRequest{RoleId: 10, EmployeeIds: []int64{1, 2, 3}}).
+			Return([]provisioning.Event(nil), common.PolicyViolationError{Message: "separation of duties conflict"})
+		var _, err = svc.PatchGroup("10", 0, patch(
+			`{"op": "replace", "path": "displayName", "value": "Administrators"}`,
+			`{"op": "add", "path": "members", "value": [{"value": "3"}]}`,
+		))
+		a.Equal("403 ", scimStatus(err))
+		a.Nil(sqlMock.ExpectationsWereMet())
+		assignments.AssertNotCalled(t, "Publish", mock.Anything)
+	})
+
+	t.Run("unknown member", func(t *testing.T) {
+		var svc, repo, employees, _, assignments = setup()
+		employees.On("FilterByIDs", employee.ParamIdsRequest{Ids: []int64{1, 2, 9}}).Return([]employee.Response{
+			{Id: 1, Name: "John Doe"},
+			{Id: 2, Name: "Jane Doe"},
+		}, nil)
+		var _, err = svc.PatchGroup("10", 0, patch(`{"op": "add", "path": "members", "value": [{"value": "9"}]}`))
+		a.Equal("400 invalidValue", scimStatus(err))
+		repo.AssertNotCalled(t, "BeginTransaction")
+		assignments.AssertNumberOfCalls(t, "SetRoleMembersTx", 0)
+	})
+}
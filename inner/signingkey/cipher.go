@@ -0,0 +1,46 @@
+package signingkey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// Cipher шифрует закрытые ключи AES-256-GCM. Kid передаётся как связанные данные,
+// поэтому зашифрованный ключ нельзя подменить ключом из другой строки таблицы
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes long")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal возвращает nonce, за которым следует шифртекст
+func (c *Cipher) Seal(plaintext []byte, kid string) ([]byte, error) {
+	var nonce = make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, []byte(kid)), nil
+}
+
+func (c *Cipher) Open(sealed []byte, kid string) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted key is too short")
+	}
+	var nonce, ciphertext = sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, []byte(kid))
+}
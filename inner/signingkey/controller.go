@@ -0,0 +1,99 @@
+package signingkey
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/oidc"
+	"github.com/zhedevops/idm/inner/web"
+)
+
+// jwksMaxAge сколько клиенты могут кешировать JWKS; следующий ключ публикуется задолго до активации
+const jwksMaxAge = "public, max-age=300"
+
+type Controller struct {
+	server            *web.Server
+	signingKeyService Svc
+}
+
+// интерфейс сервиса signingkey.Service
+type Svc interface {
+	Jwks() oidc.Jwks
+	FindKeys() ([]Response, error)
+	Rotate() error
+	Revoke(kid string) error
+}
+
+func NewController(server *web.Server, signingKeyService Svc) *Controller {
+	return &Controller{
+		server:            server,
+		signingKeyService: signingKeyService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.App.Get("/.well-known/jwks.json", c.Jwks)
+
+	c.server.GroupApiV1.Get("/signing-keys", c.FindKeys)
+	c.server.GroupApiV1.Post("/signing-keys/rotate", c.Rotate)
+	c.server.GroupApiV1.Delete("/signing-keys/:kid", c.Revoke)
+}
+
+func (c *Controller) Jwks(ctx *fiber.Ctx) error {
+	ctx.Set(fiber.HeaderCacheControl, jwksMaxAge)
+	return ctx.JSON(c.signingKeyService.Jwks())
+}
+
+func (c *Controller) FindKeys(ctx *fiber.Ctx) error {
+	resp, err := c.signingKeyService.FindKeys()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get signing keys")
+	}
+
+	return nil
+}
+
+// Rotate внеочередная ротация, например при подозрении на компрометацию активного ключа
+func (c *Controller) Rotate(ctx *fiber.Ctx) error {
+	if err := c.signingKeyService.Rotate(); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := c.signingKeyService.FindKeys()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error rotate signing keys")
+	}
+
+	return nil
+}
+
+// Revoke немедленно отзывает ключ и убирает его из JWKS. В отличие от Rotate, подписанные им токены
+// сразу перестают проверяться, поэтому сотрудникам с такими токенами придётся войти заново
+func (c *Controller) Revoke(ctx *fiber.Ctx) error {
+	if err := c.signingKeyService.Revoke(ctx.Params("kid")); err != nil {
+		if errors.As(err, &common.NotFoundError{}) {
+			return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+		}
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := c.signingKeyService.FindKeys()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error revoke signing key")
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package signingkey
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Состояния ключа подписи
+const (
+	// StateNext ключ опубликован в JWKS заранее, чтобы клиенты успели его закешировать до активации
+	StateNext = "next"
+	// StateActive ключ, которым подписываются новые токены
+	StateActive = "active"
+	// StateRetired ключ больше не подписывает, но публикуется, пока действуют выданные им токены
+	StateRetired = "retired"
+)
+
+// Entity ключ подписи; закрытый ключ хранится только в зашифрованном виде
+type Entity struct {
+	Kid         string       `db:"kid"`
+	Algorithm   string       `db:"algorithm"`
+	State       string       `db:"state"`
+	PublicKey   []byte       `db:"public_key"`
+	PrivateKey  []byte       `db:"private_key"`
+	CreatedAt   time.Time    `db:"created_at"`
+	ActivatedAt sql.NullTime `db:"activated_at"`
+	RetiredAt   sql.NullTime `db:"retired_at"`
+}
+
+// Response ключ подписи без закрытой части
+type Response struct {
+	Kid         string     `json:"kid"`
+	Algorithm   string     `json:"algorithm"`
+	State       string     `json:"state"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+	// ExpiresAt когда выведенный ключ пропадёт из JWKS
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (e *Entity) toResponse(gracePeriod time.Duration) Response {
+	var resp = Response{
+		Kid:       e.Kid,
+		Algorithm: e.Algorithm,
+		State:     e.State,
+		CreatedAt: e.CreatedAt,
+	}
+	if e.ActivatedAt.Valid {
+		resp.ActivatedAt = &e.ActivatedAt.Time
+	}
+	if e.RetiredAt.Valid {
+		resp.RetiredAt = &e.RetiredAt.Time
+		var expiresAt = e.RetiredAt.Time.Add(gracePeriod)
+		resp.ExpiresAt = &expiresAt
+	}
+	return resp
+}
@@ -0,0 +1,91 @@
+package signingkey
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+func (r *Repository) FindAll() (keys []Entity, err error) {
+	err = r.db.Select(&keys, "SELECT * FROM signing_key ORDER BY created_at, kid")
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// FindPublished возвращает ключи для JWKS: следующий, активный и выведенные после retiredAfter
+func (r *Repository) FindPublished(retiredAfter time.Time) (keys []Entity, err error) {
+	query := `SELECT * FROM signing_key
+              WHERE state <> 'retired' OR retired_at > $1
+              ORDER BY created_at, kid`
+	err = r.db.Select(&keys, query, retiredAfter)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+// LockTx не даёт нескольким экземплярам IdM ротировать ключи одновременно
+func (r *Repository) LockTx(tx *sqlx.Tx) error {
+	_, err := tx.Exec("LOCK TABLE signing_key IN SHARE ROW EXCLUSIVE MODE")
+	return err
+}
+
+// FindCurrentTx возвращает следующий и активный ключи
+func (r *Repository) FindCurrentTx(tx *sqlx.Tx) (keys []Entity, err error) {
+	err = tx.Select(&keys, "SELECT * FROM signing_key WHERE state IN ('next', 'active')")
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *Repository) FindTx(tx *sqlx.Tx, kid string) (key Entity, err error) {
+	err = tx.Get(&key, "SELECT * FROM signing_key WHERE kid = $1", kid)
+	return key, err
+}
+
+// DeleteTx удаляет ключ вместе с зашифрованным закрытым ключом
+func (r *Repository) DeleteTx(tx *sqlx.Tx, kid string) error {
+	_, err := tx.Exec("DELETE FROM signing_key WHERE kid = $1", kid)
+	return err
+}
+
+func (r *Repository) CreateTx(tx *sqlx.Tx, e *Entity) error {
+	query := `INSERT INTO signing_key (kid, algorithm, state, public_key, private_key, activated_at)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              RETURNING *`
+	return tx.Get(e, query, e.Kid, e.Algorithm, e.State, e.PublicKey, e.PrivateKey, e.ActivatedAt)
+}
+
+// ActivateTx делает следующий ключ активным
+func (r *Repository) ActivateTx(tx *sqlx.Tx, kid string, at time.Time) error {
+	_, err := tx.Exec("UPDATE signing_key SET state = 'active', activated_at = $2 WHERE kid = $1", kid, at)
+	return err
+}
+
+func (r *Repository) RetireTx(tx *sqlx.Tx, kid string, at time.Time) error {
+	_, err := tx.Exec("UPDATE signing_key SET state = 'retired', retired_at = $2 WHERE kid = $1", kid, at)
+	return err
+}
+
+// DeleteRetiredTx удаляет ключи, выведенные до before; выданные ими токены уже истекли
+func (r *Repository) DeleteRetiredTx(tx *sqlx.Tx, before time.Time) (int64, error) {
+	result, err := tx.Exec("DELETE FROM signing_key WHERE state = 'retired' AND retired_at <= $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,384 @@
+package signingkey
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"github.com/zhedevops/idm/inner/oidc"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// rsaKeyBits длина новых ключей RS256
+const rsaKeyBits = 2048
+
+// Policy алгоритм и сроки ротации ключей
+type Policy struct {
+	// Algorithm алгоритм новых ключей: RS256 или ES256
+	Algorithm string
+	// Rotation сколько ключ остаётся активным
+	Rotation time.Duration
+	// GracePeriod сколько выведенный ключ публикуется в JWKS
+	GracePeriod time.Duration
+}
+
+// Структура хранилища ключей подписи. Реализует oidc.Keys: подписывает активным ключом
+// и проверяет токены всеми опубликованными. Ключи читаются из базы в Load и держатся в памяти
+type Service struct {
+	repo   Repo
+	cipher *Cipher
+	policy Policy
+	now    func() time.Time
+
+	mu      sync.RWMutex
+	signer  crypto.Signer
+	active  oidc.Jwk
+	public  map[string]crypto.PublicKey
+	jwks    oidc.Jwks
+	methods []string
+}
+
+type Repo interface {
+	FindAll() ([]Entity, error)
+	FindPublished(time.Time) ([]Entity, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	LockTx(*sqlx.Tx) error
+	FindCurrentTx(*sqlx.Tx) ([]Entity, error)
+	FindTx(*sqlx.Tx, string) (Entity, error)
+	DeleteTx(*sqlx.Tx, string) error
+	CreateTx(*sqlx.Tx, *Entity) error
+	ActivateTx(*sqlx.Tx, string, time.Time) error
+	RetireTx(*sqlx.Tx, string, time.Time) error
+	DeleteRetiredTx(*sqlx.Tx, time.Time) (int64, error)
+}
+
+func NewService(repo Repo, cipher *Cipher, policy Policy) *Service {
+	return &Service{
+		repo:   repo,
+		cipher: cipher,
+		policy: policy,
+		now:    time.Now,
+		public: map[string]crypto.PublicKey{},
+	}
+}
+
+// Rotate выводит активный ключ, активирует следующий и создаёт новый следующий
+func (srv *Service) Rotate() error {
+	if _, err := srv.rotate(true); err != nil {
+		return err
+	}
+	return srv.Load()
+}
+
+// Revoke немедленно отзывает скомпрометированный ключ: удаляет его из базы и из JWKS, так что подписанные им
+// токены сразу перестают проверяться. Вместо отозванного активного ключа активируется следующий, вместо
+// следующего создаётся новый. Другие экземпляры IdM подхватят отзыв при ближайшем RotateDue
+func (srv *Service) Revoke(kid string) error {
+	var now = srv.now()
+	var err = database.InTransaction(srv.repo.BeginTransaction, "revoking signing key", func(tx *sqlx.Tx) error {
+		if err := srv.repo.LockTx(tx); err != nil {
+			return fmt.Errorf("error locking signing keys: %w", err)
+		}
+		key, err := srv.repo.FindTx(tx, kid)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.NotFoundError{Message: fmt.Sprintf("signing key %s not found", kid)}
+		}
+		if err != nil {
+			return fmt.Errorf("error finding signing key %s: %w", kid, err)
+		}
+		if err = srv.repo.DeleteTx(tx, kid); err != nil {
+			return fmt.Errorf("error deleting signing key %s: %w", kid, err)
+		}
+		log.Printf("signingkey: %s signing key %s revoked", key.State, kid)
+		if key.State == StateRetired {
+			return nil
+		}
+		_, err = srv.advance(tx, false, now)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return srv.Load()
+}
+
+// RotateDue ротирует ключи, если активный ключ старше Policy.Rotation или ключей ещё нет, и перечитывает их из базы,
+// чтобы подхватить ротацию, сделанную другим экземпляром
+func (srv *Service) RotateDue() (bool, error) {
+	rotated, err := srv.rotate(false)
+	if err != nil {
+		return false, err
+	}
+	return rotated, srv.Load()
+}
+
+// Schedule запускает RotateDue каждые interval, пока не отменён ctx
+func (srv *Service) Schedule(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rotated, err := srv.RotateDue()
+				if err != nil {
+					log.Printf("signingkey: rotation failed: %v", err)
+					continue
+				}
+				if rotated {
+					log.Printf("signingkey: signing key rotated, active kid %s", srv.activeKid())
+				}
+			}
+		}
+	}()
+}
+
+// Load читает опубликованные ключи из базы и расшифровывает активный
+func (srv *Service) Load() error {
+	keys, err := srv.repo.FindPublished(srv.now().Add(-srv.policy.GracePeriod))
+	if err != nil {
+		return fmt.Errorf("error finding signing keys: %w", err)
+	}
+	var signer crypto.Signer
+	var active oidc.Jwk
+	var public = make(map[string]crypto.PublicKey, len(keys))
+	var jwks = oidc.Jwks{Keys: make([]oidc.Jwk, 0, len(keys))}
+	var methods []string
+	for _, key := range keys {
+		parsed, err := x509.ParsePKIXPublicKey(key.PublicKey)
+		if err != nil {
+			return fmt.Errorf("error parsing public key %s: %w", key.Kid, err)
+		}
+		jwk, err := oidc.NewJwk(parsed, key.Algorithm)
+		if err != nil {
+			return fmt.Errorf("error encoding public key %s: %w", key.Kid, err)
+		}
+		public[key.Kid] = parsed
+		jwks.Keys = append(jwks.Keys, jwk)
+		if !slices.Contains(methods, key.Algorithm) {
+			methods = append(methods, key.Algorithm)
+		}
+		if key.State != StateActive {
+			continue
+		}
+		if signer, err = srv.decrypt(key); err != nil {
+			return err
+		}
+		active = jwk
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.signer, srv.active, srv.public, srv.jwks, srv.methods = signer, active, public, jwks, methods
+	return nil
+}
+
+// Sign подписывает токен активным ключом; метод подписи токена заменяется алгоритмом ключа
+func (srv *Service) Sign(token *jwt.Token) (string, error) {
+	srv.mu.RLock()
+	var signer, active = srv.signer, srv.active
+	srv.mu.RUnlock()
+	if signer == nil {
+		return "", errors.New("no active signing key")
+	}
+	token.Method = jwt.GetSigningMethod(active.Alg)
+	token.Header["alg"] = active.Alg
+	token.Header["kid"] = active.Kid
+	return token.SignedString(signer)
+}
+
+// Key возвращает открытый ключ по kid; ключ должен быть опубликован и совпадать по алгоритму
+func (srv *Service) Key(token *jwt.Token) (any, error) {
+	var kid, _ = token.Header["kid"].(string)
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	var key, ok = srv.public[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	for _, jwk := range srv.jwks.Keys {
+		if jwk.Kid == kid && jwk.Alg != token.Method.Alg() {
+			return nil, fmt.Errorf("signing key %q is not used with %s", kid, token.Method.Alg())
+		}
+	}
+	return key, nil
+}
+
+func (srv *Service) Jwks() oidc.Jwks {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.jwks
+}
+
+func (srv *Service) Algorithms() []string {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	if len(srv.methods) == 0 {
+		return []string{srv.policy.Algorithm}
+	}
+	return slices.Clone(srv.methods)
+}
+
+// FindKeys все ключи в базе, включая выведенные
+func (srv *Service) FindKeys() ([]Response, error) {
+	keys, err := srv.repo.FindAll()
+	if err != nil {
+		return nil, fmt.Errorf("error finding signing keys: %w", err)
+	}
+	var resp = make([]Response, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, key.toResponse(srv.policy.GracePeriod))
+	}
+	return resp, nil
+}
+
+// rotate при force или наступлении срока выводит активный ключ и активирует следующий,
+// заодно удаляя выведенные ключи старше GracePeriod
+func (srv *Service) rotate(force bool) (rotated bool, err error) {
+	var now = srv.now()
+	err = database.InTransaction(srv.repo.BeginTransaction, "rotating signing keys", func(tx *sqlx.Tx) error {
+		if err := srv.repo.LockTx(tx); err != nil {
+			return fmt.Errorf("error locking signing keys: %w", err)
+		}
+		if _, err := srv.repo.DeleteRetiredTx(tx, now.Add(-srv.policy.GracePeriod)); err != nil {
+			return fmt.Errorf("error deleting expired signing keys: %w", err)
+		}
+		var err error
+		rotated, err = srv.advance(tx, force, now)
+		return err
+	})
+	return rotated, err
+}
+
+// advance выполняет ротацию под блокировкой LockTx: выводит активный ключ, активирует следующий
+// и создаёт недостающие ключи. Без активного ключа ротация выполняется всегда
+func (srv *Service) advance(tx *sqlx.Tx, force bool, now time.Time) (rotated bool, err error) {
+	keys, err := srv.repo.FindCurrentTx(tx)
+	if err != nil {
+		return false, fmt.Errorf("error finding signing keys: %w", err)
+	}
+	var active, next *Entity
+	for i := range keys {
+		switch keys[i].State {
+		case StateActive:
+			active = &keys[i]
+		case StateNext:
+			next = &keys[i]
+		}
+	}
+
+	rotated = force || active == nil || !now.Before(active.ActivatedAt.Time.Add(srv.policy.Rotation))
+	if rotated {
+		if active != nil {
+			if err := srv.repo.RetireTx(tx, active.Kid, now); err != nil {
+				return false, fmt.Errorf("error retiring signing key %s: %w", active.Kid, err)
+			}
+		}
+		if next != nil {
+			if err := srv.repo.ActivateTx(tx, next.Kid, now); err != nil {
+				return false, fmt.Errorf("error activating signing key %s: %w", next.Kid, err)
+			}
+		} else if _, err := srv.create(tx, StateActive, now); err != nil {
+			// при первом запуске следующего ключа нет, активный создаётся сразу
+			return false, err
+		}
+		next = nil
+	}
+	if next == nil {
+		if _, err := srv.create(tx, StateNext, now); err != nil {
+			return false, err
+		}
+	}
+	return rotated, nil
+}
+
+// create генерирует ключ алгоритмом из политики и сохраняет его зашифрованным
+func (srv *Service) create(tx *sqlx.Tx, state string, now time.Time) (Entity, error) {
+	signer, err := generateKey(srv.policy.Algorithm)
+	if err != nil {
+		return Entity{}, fmt.Errorf("error generating signing key: %w", err)
+	}
+	jwk, err := oidc.NewJwk(signer.Public(), srv.policy.Algorithm)
+	if err != nil {
+		return Entity{}, fmt.Errorf("error encoding signing key: %w", err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return Entity{}, fmt.Errorf("error encoding signing key: %w", err)
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return Entity{}, fmt.Errorf("error encoding signing key: %w", err)
+	}
+	sealed, err := srv.cipher.Seal(private, jwk.Kid)
+	if err != nil {
+		return Entity{}, fmt.Errorf("error encrypting signing key: %w", err)
+	}
+	var key = Entity{
+		Kid:        jwk.Kid,
+		Algorithm:  srv.policy.Algorithm,
+		State:      state,
+		PublicKey:  public,
+		PrivateKey: sealed,
+	}
+	if state == StateActive {
+		key.ActivatedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	if err = srv.repo.CreateTx(tx, &key); err != nil {
+		return Entity{}, fmt.Errorf("error saving signing key %s: %w", key.Kid, err)
+	}
+	return key, nil
+}
+
+// decrypt расшифровывает закрытый ключ; ошибка означает, что задан другой ключ шифрования или данные повреждены
+func (srv *Service) decrypt(key Entity) (crypto.Signer, error) {
+	private, err := srv.cipher.Open(key.PrivateKey, key.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting signing key %s: %w", key.Kid, err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key %s: %w", key.Kid, err)
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s cannot sign", key.Kid)
+	}
+	return signer, nil
+}
+
+func (srv *Service) activeKid() string {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.active.Kid
+}
+
+// generateKey новый ключ для RS256 или ES256
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case jwt.SigningMethodES256.Alg():
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
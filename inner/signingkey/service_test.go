@@ -0,0 +1,322 @@
+package signingkey
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/oidc"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindAll() ([]Entity, error) {
+	args := m.Called()
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindPublished(retiredAfter time.Time) ([]Entity, error) {
+	args := m.Called(retiredAfter)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) LockTx(tx *sqlx.Tx) error {
+	args := m.Called(tx)
+	return args.Error(0)
+}
+
+func (m *MockRepo) FindCurrentTx(tx *sqlx.Tx) ([]Entity, error) {
+	args := m.Called(tx)
+	return args.Get(0).([]Entity), args.Error(1)
+}
+
+func (m *MockRepo) FindTx(tx *sqlx.Tx, kid string) (Entity, error) {
+	args := m.Called(tx, kid)
+	return args.Get(0).(Entity), args.Error(1)
+}
+
+func (m *MockRepo) DeleteTx(tx *sqlx.Tx, kid string) error {
+	args := m.Called(tx, kid)
+	return args.Error(0)
+}
+
+func (m *MockRepo) CreateTx(tx *sqlx.Tx, e *Entity) error {
+	args := m.Called(tx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ActivateTx(tx *sqlx.Tx, kid string, at time.Time) error {
+	args := m.Called(tx, kid, at)
+	return args.Error(0)
+}
+
+func (m *MockRepo) RetireTx(tx *sqlx.Tx, kid string, at time.Time) error {
+	args := m.Called(tx, kid, at)
+	return args.Error(0)
+}
+
+func (m *MockRepo) DeleteRetiredTx(tx *sqlx.Tx, before time.Time) (int64, error) {
+	args := m.Called(tx, before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+var now = time.Date(2025, 12, 19, 12, 0, 0, 0, time.UTC)
+
+var policy = Policy{Algorithm: "ES256", Rotation: 30 * 24 * time.Hour, GracePeriod: 7 * 24 * time.Hour}
+
+func newService(a *assert.Assertions, repo Repo) *Service {
+	cipher, err := NewCipher(make([]byte, 32))
+	a.Nil(err)
+	var svc = NewService(repo, cipher, policy)
+	svc.now = func() time.Time { return now }
+	return svc
+}
+
+// newKey ключ, как его сохраняет create
+func newKey(a *assert.Assertions, svc *Service, algorithm string, state string, at time.Time) Entity {
+	signer, err := generateKey(algorithm)
+	a.Nil(err)
+	jwk, err := oidc.NewJwk(signer.Public(), algorithm)
+	a.Nil(err)
+	public, err := x509.MarshalPKIXPublicKey(signer.Public())
+	a.Nil(err)
+	private, err := x509.MarshalPKCS8PrivateKey(signer)
+	a.Nil(err)
+	sealed, err := svc.cipher.Seal(private, jwk.Kid)
+	a.Nil(err)
+	var key = Entity{Kid: jwk.Kid, Algorithm: algorithm, State: state, PublicKey: public, PrivateKey: sealed, CreatedAt: at}
+	switch state {
+	case StateActive:
+		key.ActivatedAt = sql.NullTime{Time: at, Valid: true}
+	case StateRetired:
+		key.ActivatedAt = sql.NullTime{Time: at.Add(-policy.Rotation), Valid: true}
+		key.RetiredAt = sql.NullTime{Time: at, Valid: true}
+	}
+	return key
+}
+
+func TestCipher(t *testing.T) {
+	var a = assert.New(t)
+	cipher, err := NewCipher(make([]byte, 32))
+	a.Nil(err)
+
+	sealed, err := cipher.Seal([]byte("private key"), "kid-1")
+	a.Nil(err)
+	a.NotContains(string(sealed), "private key")
+	opened, err := cipher.Open(sealed, "kid-1")
+	a.Nil(err)
+	a.Equal("private key", string(opened))
+
+	_, err = cipher.Open(sealed, "kid-2")
+	a.NotNil(err, "key moved to another row must not decrypt")
+	sealed[len(sealed)-1] ^= 1
+	_, err = cipher.Open(sealed, "kid-1")
+	a.NotNil(err)
+
+	_, err = NewCipher(make([]byte, 16))
+	a.NotNil(err)
+}
+
+func TestRotate(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should create active and next keys on first run", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(a, repo)
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockTx", tx).Return(nil)
+		repo.On("DeleteRetiredTx", tx, now.Add(-policy.GracePeriod)).Return(int64(0), nil)
+		repo.On("FindCurrentTx", tx).Return([]Entity{}, nil)
+		var created []Entity
+		repo.On("CreateTx", tx, mock.Anything).Run(func(args mock.Arguments) {
+			created = append(created, *args.Get(1).(*Entity))
+		}).Return(nil)
+
+		rotated, err := svc.rotate(false)
+		a.Nil(err)
+		a.True(rotated)
+		a.Nil(sqlMock.ExpectationsWereMet())
+		a.Len(created, 2)
+		a.Equal(StateActive, created[0].State)
+		a.True(created[0].ActivatedAt.Valid)
+		a.Equal(StateNext, created[1].State)
+		a.Equal("ES256", created[1].Algorithm)
+		repo.AssertNotCalled(t, "RetireTx", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("should promote next key and retire active one", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(a, repo)
+		var active = newKey(a, svc, "ES256", StateActive, now.Add(-time.Hour))
+		var next = newKey(a, svc, "ES256", StateNext, now.Add(-time.Hour))
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockTx", tx).Return(nil)
+		repo.On("DeleteRetiredTx", tx, now.Add(-policy.GracePeriod)).Return(int64(1), nil)
+		repo.On("FindCurrentTx", tx).Return([]Entity{active, next}, nil)
+		repo.On("RetireTx", tx, active.Kid, now).Return(nil)
+		repo.On("ActivateTx", tx, next.Kid, now).Return(nil)
+		repo.On("CreateTx", tx, mock.MatchedBy(func(e *Entity) bool { return e.State == StateNext })).Return(nil)
+
+		rotated, err := svc.rotate(true)
+		a.Nil(err)
+		a.True(rotated)
+		a.Nil(sqlMock.ExpectationsWereMet())
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("should not rotate before the due date", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(a, repo)
+		var active = newKey(a, svc, "ES256", StateActive, now.Add(-policy.Rotation).Add(time.Minute))
+		var next = newKey(a, svc, "ES256", StateNext, now)
+		tx, _ := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockTx", tx).Return(nil)
+		repo.On("DeleteRetiredTx", tx, now.Add(-policy.GracePeriod)).Return(int64(0), nil)
+		repo.On("FindCurrentTx", tx).Return([]Entity{active, next}, nil)
+
+		rotated, err := svc.rotate(false)
+		a.Nil(err)
+		a.False(rotated)
+		repo.AssertNotCalled(t, "CreateTx", mock.Anything, mock.Anything)
+		repo.AssertNotCalled(t, "ActivateTx", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("should rotate when the active key is due", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(a, repo)
+		var active = newKey(a, svc, "ES256", StateActive, now.Add(-policy.Rotation))
+		var next = newKey(a, svc, "ES256", StateNext, now.Add(-policy.Rotation))
+		tx, _ := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockTx", tx).Return(nil)
+		repo.On("DeleteRetiredTx", tx, now.Add(-policy.GracePeriod)).Return(int64(0), nil)
+		repo.On("FindCurrentTx", tx).Return([]Entity{active, next}, nil)
+		repo.On("RetireTx", tx, active.Kid, now).Return(nil)
+		repo.On("ActivateTx", tx, next.Kid, now).Return(nil)
+		repo.On("CreateTx", tx, mock.Anything).Return(nil)
+
+		rotated, err := svc.rotate(false)
+		a.Nil(err)
+		a.True(rotated)
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should drop compromised active key from jwks at once", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(a, repo)
+		var active = newKey(a, svc, "ES256", StateActive, now.Add(-time.Hour))
+		var next = newKey(a, svc, "ES256", StateNext, now.Add(-time.Hour))
+		repo.On("FindPublished", now.Add(-policy.GracePeriod)).Return([]Entity{active, next}, nil).Once()
+		a.Nil(svc.Load())
+		signed, err := svc.Sign(jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1"}))
+		a.Nil(err)
+
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockTx", tx).Return(nil)
+		repo.On("FindTx", tx, active.Kid).Return(active, nil)
+		repo.On("DeleteTx", tx, active.Kid).Return(nil)
+		repo.On("FindCurrentTx", tx).Return([]Entity{next}, nil)
+		repo.On("ActivateTx", tx, next.Kid, now).Return(nil)
+		repo.On("CreateTx", tx, mock.MatchedBy(func(e *Entity) bool { return e.State == StateNext })).Return(nil)
+		var promoted = next
+		promoted.State = StateActive
+		repo.On("FindPublished", now.Add(-policy.GracePeriod)).Return([]Entity{promoted}, nil).Once()
+
+		a.Nil(svc.Revoke(active.Kid))
+		a.Nil(sqlMock.ExpectationsWereMet())
+		repo.AssertNotCalled(t, "RetireTx", mock.Anything, mock.Anything, mock.Anything)
+		a.Len(svc.Jwks().Keys, 1)
+		a.Equal(next.Kid, svc.Jwks().Keys[0].Kid)
+		_, err = jwt.Parse(signed, svc.Key, jwt.WithValidMethods(svc.Algorithms()))
+		a.NotNil(err)
+	})
+
+	t.Run("should return not found for unknown key", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = newService(a, repo)
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("LockTx", tx).Return(nil)
+		repo.On("FindTx", tx, "missing").Return(Entity{}, sql.ErrNoRows)
+		a.ErrorAs(svc.Revoke("missing"), &common.NotFoundError{})
+	})
+}
+
+func TestLoad(t *testing.T) {
+	var a = assert.New(t)
+	var repo = new(MockRepo)
+	var svc = newService(a, repo)
+	var retired = newKey(a, svc, "RS256", StateRetired, now.Add(-time.Hour))
+	var active = newKey(a, svc, "ES256", StateActive, now.Add(-time.Hour))
+	var next = newKey(a, svc, "ES256", StateNext, now.Add(-time.Hour))
+	repo.On("FindPublished", now.Add(-policy.GracePeriod)).Return([]Entity{retired, active, next}, nil)
+
+	// до загрузки подписывать нечем
+	_, err := svc.Sign(jwt.New(jwt.SigningMethodRS256))
+	a.NotNil(err)
+
+	a.Nil(svc.Load())
+	var jwks = svc.Jwks()
+	a.Len(jwks.Keys, 3)
+	a.Equal("EC", jwks.Keys[1].Kty)
+	a.Equal("P-256", jwks.Keys[1].Crv)
+	a.ElementsMatch([]string{"RS256", "ES256"}, svc.Algorithms())
+
+	signed, err := svc.Sign(jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1"}))
+	a.Nil(err)
+	token, err := jwt.Parse(signed, svc.Key, jwt.WithValidMethods(svc.Algorithms()))
+	a.Nil(err)
+	a.Equal("ES256", token.Method.Alg())
+	a.Equal(active.Kid, token.Header["kid"])
+
+	t.Run("should reject key used with another algorithm", func(t *testing.T) {
+		var forged = jwt.New(jwt.SigningMethodHS256)
+		forged.Header["kid"] = active.Kid
+		_, err := svc.Key(forged)
+		a.NotNil(err)
+	})
+
+	t.Run("should fail with another encryption key", func(t *testing.T) {
+		var other = newService(a, repo)
+		var key = make([]byte, 32)
+		key[0] = 1
+		other.cipher, _ = NewCipher(key)
+		a.NotNil(other.Load())
+	})
+}
@@ -0,0 +1,186 @@
+package sod
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/web"
+	"strconv"
+)
+
+type Controller struct {
+	server     *web.Server
+	sodService Svc
+}
+
+// интерфейс сервиса sod.Service
+type Svc interface {
+	CreateRule(request CreateRuleRequest) (int64, error)
+	FindRules() ([]RuleResponse, error)
+	FindRuleById(id int64) (RuleResponse, error)
+	DeleteRule(id int64) (int64, error)
+	GrantException(request ExceptionRequest) (ExceptionResponse, error)
+	RevokeException(ruleId int64, employeeId int64) (int64, error)
+	FindViolations() ([]ViolationResponse, error)
+}
+
+func NewController(server *web.Server, sodService Svc) *Controller {
+	return &Controller{
+		server:     server,
+		sodService: sodService,
+	}
+}
+
+// функция для регистрации маршрутов
+func (c *Controller) RegisterRoutes() {
+	c.server.GroupApiV1.Post("/sod/rules", c.CreateRule)
+	c.server.GroupApiV1.Get("/sod/rules", c.FindRules)
+	c.server.GroupApiV1.Get("/sod/rules/:id", c.FindRuleById)
+	c.server.GroupApiV1.Delete("/sod/rules/:id", c.DeleteRule)
+	c.server.GroupApiV1.Post("/sod/rules/:id/exceptions", c.GrantException)
+	c.server.GroupApiV1.Delete("/sod/rules/:id/exceptions/:employeeId", c.RevokeException)
+	c.server.GroupApiV1.Get("/sod/violations", c.FindViolations)
+}
+
+// функция-хендлер для POST запроса по маршруту "/api/v1/sod/rules"
+func (c *Controller) CreateRule(ctx *fiber.Ctx) error {
+	var request CreateRuleRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	var id, err = c.sodService.CreateRule(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, id); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error returning created SoD rule id")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindRules(ctx *fiber.Ctx) error {
+	var resp, err = c.sodService.FindRules()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get SoD rules")
+	}
+
+	return nil
+}
+
+func (c *Controller) FindRuleById(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	resp, err := c.sodService.FindRuleById(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get SoD rule by id")
+	}
+
+	return nil
+}
+
+func (c *Controller) DeleteRule(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+
+	count, err := c.sodService.DeleteRule(id)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, count); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error delete SoD rule by id")
+	}
+
+	return nil
+}
+
+func (c *Controller) GrantException(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	var request ExceptionRequest
+	if err := ctx.BodyParser(&request); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	request.RuleId = id
+
+	resp, err := c.sodService.GrantException(request)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error grant SoD exception")
+	}
+
+	return nil
+}
+
+func (c *Controller) RevokeException(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid id")
+	}
+	employeeId, err := strconv.ParseInt(ctx.Params("employeeId"), 10, 64)
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, "invalid employee id")
+	}
+
+	count, err := c.sodService.RevokeException(id, employeeId)
+	if err != nil {
+		return errResponse(ctx, err)
+	}
+
+	if err = common.OkResponse(ctx, count); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error revoke SoD exception")
+	}
+
+	return nil
+}
+
+// FindViolations отчёт о сотрудниках, которые уже имеют взаимоисключающие роли
+func (c *Controller) FindViolations(ctx *fiber.Ctx) error {
+	var resp, err = c.sodService.FindViolations()
+	if err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err = common.OkResponse(ctx, resp); err != nil {
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, "error get SoD violations")
+	}
+
+	return nil
+}
+
+// errResponse переводит ошибку сервиса в код ответа
+func errResponse(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.As(err, &common.RequestValidationError{}):
+		return common.ErrResponse(ctx, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &common.ForbiddenError{}):
+		return common.ErrResponse(ctx, fiber.StatusForbidden, err.Error())
+	case errors.As(err, &common.NotFoundError{}):
+		return common.ErrResponse(ctx, fiber.StatusNotFound, err.Error())
+	case errors.As(err, &common.AlreadyExistsError{}):
+		return common.ErrResponse(ctx, fiber.StatusConflict, err.Error())
+	default:
+		return common.ErrResponse(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,120 @@
+package sod
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Способы применения правила
+const (
+	// EnforcementReject назначение конфликтующей роли всегда отклоняется
+	EnforcementReject = "reject"
+	// EnforcementException назначение разрешено, если для сотрудника согласовано исключение из правила
+	EnforcementException = "exception"
+)
+
+// RuleEntity правило разделения полномочий: сотрудник не может иметь две и более роли из RoleIds
+type RuleEntity struct {
+	Id          int64         `db:"id"`
+	Name        string        `db:"name"`
+	Description string        `db:"description"`
+	Enforcement string        `db:"enforcement"`
+	CreatedAt   time.Time     `db:"created_at"`
+	RoleIds     pq.Int64Array `db:"role_ids"`
+}
+
+// ExceptionEntity согласованное исключение из правила для одного сотрудника
+type ExceptionEntity struct {
+	Id         int64        `db:"id"`
+	RuleId     int64        `db:"rule_id"`
+	EmployeeId int64        `db:"employee_id"`
+	Reason     string       `db:"reason"`
+	ApprovedBy int64        `db:"approved_by"`
+	ValidUntil sql.NullTime `db:"valid_until"`
+	CreatedAt  time.Time    `db:"created_at"`
+}
+
+// ConflictEntity правило, которое нарушит назначение роли, и уже имеющаяся у сотрудника роль из того же правила
+type ConflictEntity struct {
+	RuleId      int64  `db:"rule_id"`
+	RuleName    string `db:"rule_name"`
+	Enforcement string `db:"enforcement"`
+	RoleId      int64  `db:"role_id"`
+	RoleName    string `db:"role_name"`
+}
+
+// ViolationEntity сотрудник, который уже имеет несколько ролей из одного правила
+type ViolationEntity struct {
+	RuleId       int64          `db:"rule_id"`
+	RuleName     string         `db:"rule_name"`
+	EmployeeId   int64          `db:"employee_id"`
+	EmployeeName string         `db:"employee_name"`
+	Roles        pq.StringArray `db:"roles"`
+	Excepted     bool           `db:"excepted"`
+}
+
+type RuleResponse struct {
+	Id          int64               `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Enforcement string              `json:"enforcement"`
+	RoleIds     []int64             `json:"role_ids"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Exceptions  []ExceptionResponse `json:"exceptions,omitempty"`
+}
+
+type ExceptionResponse struct {
+	RuleId     int64      `json:"rule_id"`
+	EmployeeId int64      `json:"employee_id"`
+	Reason     string     `json:"reason"`
+	ApprovedBy int64      `json:"approved_by"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type ViolationResponse struct {
+	RuleId       int64    `json:"rule_id"`
+	RuleName     string   `json:"rule_name"`
+	EmployeeId   int64    `json:"employee_id"`
+	EmployeeName string   `json:"employee_name"`
+	Roles        []string `json:"roles"`
+	// Excepted нарушение покрыто действующим исключением
+	Excepted bool `json:"excepted"`
+}
+
+func (e *RuleEntity) toResponse() RuleResponse {
+	return RuleResponse{
+		Id:          e.Id,
+		Name:        e.Name,
+		Description: e.Description,
+		Enforcement: e.Enforcement,
+		RoleIds:     e.RoleIds,
+		CreatedAt:   e.CreatedAt,
+	}
+}
+
+func (e *ExceptionEntity) toResponse() ExceptionResponse {
+	var resp = ExceptionResponse{
+		RuleId:     e.RuleId,
+		EmployeeId: e.EmployeeId,
+		Reason:     e.Reason,
+		ApprovedBy: e.ApprovedBy,
+		CreatedAt:  e.CreatedAt,
+	}
+	if e.ValidUntil.Valid {
+		resp.ValidUntil = &e.ValidUntil.Time
+	}
+	return resp
+}
+
+func (v *ViolationEntity) toResponse() ViolationResponse {
+	return ViolationResponse{
+		RuleId:       v.RuleId,
+		RuleName:     v.RuleName,
+		EmployeeId:   v.EmployeeId,
+		EmployeeName: v.EmployeeName,
+		Roles:        v.Roles,
+		Excepted:     v.Excepted,
+	}
+}
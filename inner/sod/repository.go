@@ -0,0 +1,136 @@
+package sod
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(database *sqlx.DB) *Repository {
+	return &Repository{db: database}
+}
+
+const selectRules = `SELECT r.id, r.name, r.description, r.enforcement, r.created_at,
+              COALESCE(array_agg(rr.role_id ORDER BY rr.role_id) FILTER (WHERE rr.role_id IS NOT NULL), '{}') AS role_ids
+              FROM sod_rule r LEFT JOIN sod_rule_role rr ON rr.rule_id = r.id`
+
+func (r *Repository) FindRules() (rules []RuleEntity, err error) {
+	err = r.db.Select(&rules, selectRules+" GROUP BY r.id ORDER BY r.id")
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *Repository) FindRuleById(id int64) (rule RuleEntity, err error) {
+	err = r.db.Get(&rule, selectRules+" WHERE r.id = $1 GROUP BY r.id", id)
+	return
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) FindByNameTx(tx *sqlx.Tx, name string) (exists bool, err error) {
+	err = tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM sod_rule WHERE name = $1)", name)
+	return
+}
+
+// CountRolesTx возвращает, сколько ролей из ids существует
+func (r *Repository) CountRolesTx(tx *sqlx.Tx, ids []int64) (count int, err error) {
+	err = tx.Get(&count, "SELECT COUNT(*) FROM role WHERE id = ANY($1)", pq.Array(ids))
+	return
+}
+
+// CreateRuleTx сохраняет правило вместе с его ролями и заполняет Id и CreatedAt
+func (r *Repository) CreateRuleTx(tx *sqlx.Tx, e *RuleEntity) error {
+	query := `INSERT INTO sod_rule (name, description, enforcement) VALUES ($1, $2, $3) RETURNING id, created_at`
+	err := tx.QueryRowx(query, e.Name, e.Description, e.Enforcement).Scan(&e.Id, &e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO sod_rule_role (rule_id, role_id) SELECT $1, unnest($2::BIGINT[])", e.Id, e.RoleIds)
+	return err
+}
+
+func (r *Repository) DeleteRule(id int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM sod_rule WHERE id = $1", id)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *Repository) FindExceptions(ruleId int64) (exceptions []ExceptionEntity, err error) {
+	err = r.db.Select(&exceptions, "SELECT * FROM sod_exception WHERE rule_id = $1 ORDER BY employee_id", ruleId)
+	if err != nil {
+		return nil, err
+	}
+	return exceptions, nil
+}
+
+// SaveException создаёт исключение или заменяет уже выданное тому же сотруднику
+func (r *Repository) SaveException(e *ExceptionEntity) error {
+	query := `INSERT INTO sod_exception (rule_id, employee_id, reason, approved_by, valid_until)
+              VALUES ($1, $2, $3, $4, $5)
+              ON CONFLICT (rule_id, employee_id) DO UPDATE SET reason = EXCLUDED.reason,
+                  approved_by = EXCLUDED.approved_by, valid_until = EXCLUDED.valid_until, created_at = NOW()
+              RETURNING *`
+	return r.db.Get(e, query, e.RuleId, e.EmployeeId, e.Reason, e.ApprovedBy, e.ValidUntil)
+}
+
+func (r *Repository) DeleteException(ruleId int64, employeeId int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM sod_exception WHERE rule_id = $1 AND employee_id = $2", ruleId, employeeId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// FindConflictsTx возвращает правила, которые нарушит назначение роли roleId сотруднику employeeId.
+// Учитываются и будущие назначения, а правила с действующим исключением пропускаются.
+// Строка сотрудника блокируется до конца транзакции, чтобы параллельные назначения не обошли проверку
+func (r *Repository) FindConflictsTx(tx *sqlx.Tx, employeeId int64, roleId int64) (conflicts []ConflictEntity, err error) {
+	if _, err = tx.Exec("SELECT id FROM employee WHERE id = $1 FOR UPDATE", employeeId); err != nil {
+		return nil, err
+	}
+	query := `SELECT r.id AS rule_id, r.name AS rule_name, r.enforcement, ro.id AS role_id, ro.name AS role_name
+              FROM sod_rule r
+              JOIN sod_rule_role target ON target.rule_id = r.id AND target.role_id = $2
+              JOIN sod_rule_role other ON other.rule_id = r.id AND other.role_id <> $2
+              JOIN employee_role er ON er.role_id = other.role_id AND er.employee_id = $1
+              JOIN role ro ON ro.id = er.role_id
+              WHERE NOT (r.enforcement = 'exception' AND EXISTS (
+                  SELECT 1 FROM sod_exception x WHERE x.rule_id = r.id AND x.employee_id = $1
+                  AND (x.valid_until IS NULL OR x.valid_until > NOW())))
+              ORDER BY r.id, ro.id`
+	err = tx.Select(&conflicts, query, employeeId, roleId)
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// FindViolations возвращает сотрудников, которые сейчас имеют две и более роли из одного правила
+func (r *Repository) FindViolations() (violations []ViolationEntity, err error) {
+	query := `SELECT r.id AS rule_id, r.name AS rule_name, e.id AS employee_id, e.name AS employee_name,
+              array_agg(ro.name ORDER BY ro.name) AS roles,
+              EXISTS (SELECT 1 FROM sod_exception x WHERE x.rule_id = r.id AND x.employee_id = e.id
+                  AND r.enforcement = 'exception' AND (x.valid_until IS NULL OR x.valid_until > NOW())) AS excepted
+              FROM sod_rule r
+              JOIN sod_rule_role rr ON rr.rule_id = r.id
+              JOIN effective_employee_role er ON er.role_id = rr.role_id
+              JOIN employee e ON e.id = er.employee_id
+              JOIN role ro ON ro.id = rr.role_id
+              GROUP BY r.id, e.id
+              HAVING COUNT(*) >= 2
+              ORDER BY r.id, e.id`
+	err = r.db.Select(&violations, query)
+	if err != nil {
+		return nil, err
+	}
+	return violations, nil
+}
@@ -0,0 +1,249 @@
+package sod
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/database"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Структура сервиса правил разделения полномочий (segregation of duties)
+type Service struct {
+	repo      Repo
+	validator Validator
+}
+
+// CreateRuleRequest правило из двух и более взаимоисключающих ролей
+type CreateRuleRequest struct {
+	Name        string  `json:"name" validate:"required,min=2,max=155"`
+	Description string  `json:"description" validate:"max=1000"`
+	Enforcement string  `json:"enforcement" validate:"omitempty,oneof=reject exception"`
+	RoleIds     []int64 `json:"role_ids" validate:"required,min=2,max=50,dive,gt=0"`
+}
+
+// ExceptionRequest исключение из правила для сотрудника, согласованное сотрудником ApprovedBy
+type ExceptionRequest struct {
+	RuleId     int64      `json:"-" validate:"required,gt=0"`
+	EmployeeId int64      `json:"employee_id" validate:"required,gt=0"`
+	Reason     string     `json:"reason" validate:"required,min=10,max=1000"`
+	ApprovedBy int64      `json:"approved_by" validate:"required,gt=0"`
+	ValidUntil *time.Time `json:"valid_until"`
+}
+
+type Validator interface {
+	Validate(request any) error
+}
+
+type Repo interface {
+	FindRules() ([]RuleEntity, error)
+	FindRuleById(int64) (RuleEntity, error)
+	BeginTransaction() (*sqlx.Tx, error)
+	FindByNameTx(*sqlx.Tx, string) (bool, error)
+	CountRolesTx(*sqlx.Tx, []int64) (int, error)
+	CreateRuleTx(*sqlx.Tx, *RuleEntity) error
+	DeleteRule(int64) (int64, error)
+	FindExceptions(int64) ([]ExceptionEntity, error)
+	SaveException(*ExceptionEntity) error
+	DeleteException(int64, int64) (int64, error)
+	FindConflictsTx(*sqlx.Tx, int64, int64) ([]ConflictEntity, error)
+	FindViolations() ([]ViolationEntity, error)
+}
+
+func NewService(repo Repo, validator Validator) *Service {
+	return &Service{
+		repo:      repo,
+		validator: validator,
+	}
+}
+
+// CheckTx проверяет в транзакции назначения, что роль roleId можно выдать сотруднику employeeId.
+// Нарушение правила возвращается как common.PolicyViolationError
+func (srv *Service) CheckTx(tx *sqlx.Tx, employeeId int64, roleId int64) error {
+	var conflicts, err = srv.repo.FindConflictsTx(tx, employeeId, roleId)
+	if err != nil {
+		return fmt.Errorf("error checking SoD rules for role %d of employee %d: %w", roleId, employeeId, err)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	var messages []string
+	for _, c := range conflicts {
+		var message = fmt.Sprintf("rule %q forbids combining it with role %q", c.RuleName, c.RoleName)
+		if c.Enforcement == EnforcementException {
+			message += " without an approved exception"
+		}
+		messages = append(messages, message)
+	}
+	return common.PolicyViolationError{Message: fmt.Sprintf("role %d cannot be assigned to employee %d: %s",
+		roleId, employeeId, strings.Join(messages, "; "))}
+}
+
+// CreateRule создаёт правило и возвращает его идентификатор
+func (srv *Service) CreateRule(request CreateRuleRequest) (id int64, err error) {
+	err = srv.validator.Validate(request)
+	if err != nil {
+		return 0, common.RequestValidationError{Message: err.Error()}
+	}
+	var entity = RuleEntity{
+		Name:        request.Name,
+		Description: request.Description,
+		Enforcement: request.Enforcement,
+	}
+	if entity.Enforcement == "" {
+		entity.Enforcement = EnforcementReject
+	}
+	for _, roleId := range request.RoleIds {
+		if !slices.Contains(entity.RoleIds, roleId) {
+			entity.RoleIds = append(entity.RoleIds, roleId)
+		}
+	}
+	if len(entity.RoleIds) < 2 {
+		return 0, common.RequestValidationError{Message: "rule must contain at least two different roles"}
+	}
+
+	err = database.InTransaction(srv.repo.BeginTransaction, "creating SoD rule", func(tx *sqlx.Tx) error {
+		exists, err := srv.repo.FindByNameTx(tx, entity.Name)
+		if err != nil {
+			return fmt.Errorf("error finding SoD rule by name: %s, %w", entity.Name, err)
+		}
+		if exists {
+			return common.AlreadyExistsError{Message: fmt.Sprintf("SoD rule with name %s already exists", entity.Name)}
+		}
+		count, err := srv.repo.CountRolesTx(tx, entity.RoleIds)
+		if err != nil {
+			return fmt.Errorf("error checking roles of SoD rule: %w", err)
+		}
+		if count != len(entity.RoleIds) {
+			return common.NotFoundError{Message: fmt.Sprintf("some of roles %v not found", []int64(entity.RoleIds))}
+		}
+		if err := srv.repo.CreateRuleTx(tx, &entity); err != nil {
+			return fmt.Errorf("error creating SoD rule: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entity.Id, nil
+}
+
+func (srv *Service) FindRules() ([]RuleResponse, error) {
+	var entities, err = srv.repo.FindRules()
+	if err != nil {
+		return []RuleResponse{}, fmt.Errorf("error get SoD rules: %w", err)
+	}
+
+	var resp = []RuleResponse{}
+	for _, e := range entities {
+		resp = append(resp, e.toResponse())
+	}
+	return resp, nil
+}
+
+// FindRuleById возвращает правило вместе с исключениями из него
+func (srv *Service) FindRuleById(id int64) (RuleResponse, error) {
+	var entity, err = srv.findRule(id)
+	if err != nil {
+		return RuleResponse{}, err
+	}
+	exceptions, err := srv.repo.FindExceptions(id)
+	if err != nil {
+		return RuleResponse{}, fmt.Errorf("error get exceptions of SoD rule %d: %w", id, err)
+	}
+
+	var resp = entity.toResponse()
+	for _, e := range exceptions {
+		resp.Exceptions = append(resp.Exceptions, e.toResponse())
+	}
+	return resp, nil
+}
+
+// DeleteRule удаляет правило вместе с исключениями; уже выданные роли не меняются
+func (srv *Service) DeleteRule(id int64) (int64, error) {
+	var count, err = srv.repo.DeleteRule(id)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting SoD rule with id %d: %w", id, err)
+	}
+	if count == 0 {
+		return 0, common.NotFoundError{Message: fmt.Sprintf("SoD rule with id %d not found", id)}
+	}
+	return count, nil
+}
+
+// GrantException разрешает сотруднику иметь роли правила с enforcement=exception
+func (srv *Service) GrantException(request ExceptionRequest) (ExceptionResponse, error) {
+	var err = srv.validator.Validate(request)
+	if err != nil {
+		return ExceptionResponse{}, common.RequestValidationError{Message: err.Error()}
+	}
+	if request.ApprovedBy == request.EmployeeId {
+		return ExceptionResponse{}, common.ForbiddenError{Message: "employee cannot approve an exception for themselves"}
+	}
+	if request.ValidUntil != nil && !request.ValidUntil.After(time.Now()) {
+		return ExceptionResponse{}, common.RequestValidationError{Message: "valid_until must be in the future"}
+	}
+	rule, err := srv.findRule(request.RuleId)
+	if err != nil {
+		return ExceptionResponse{}, err
+	}
+	if rule.Enforcement != EnforcementException {
+		return ExceptionResponse{}, common.RequestValidationError{Message: fmt.Sprintf("SoD rule %q does not allow exceptions", rule.Name)}
+	}
+
+	var entity = ExceptionEntity{
+		RuleId:     request.RuleId,
+		EmployeeId: request.EmployeeId,
+		Reason:     request.Reason,
+		ApprovedBy: request.ApprovedBy,
+	}
+	if request.ValidUntil != nil {
+		entity.ValidUntil = sql.NullTime{Time: *request.ValidUntil, Valid: true}
+	}
+	if err = srv.repo.SaveException(&entity); err != nil {
+		return ExceptionResponse{}, fmt.Errorf("error saving exception from SoD rule %d: %w", request.RuleId, err)
+	}
+	return entity.toResponse(), nil
+}
+
+// RevokeException отзывает исключение. Роли, выданные по нему, остаются и попадают в отчёт о нарушениях
+func (srv *Service) RevokeException(ruleId int64, employeeId int64) (int64, error) {
+	var count, err = srv.repo.DeleteException(ruleId, employeeId)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting exception from SoD rule %d: %w", ruleId, err)
+	}
+	if count == 0 {
+		return 0, common.NotFoundError{Message: fmt.Sprintf("exception from SoD rule %d for employee %d not found", ruleId, employeeId)}
+	}
+	return count, nil
+}
+
+// FindViolations возвращает сотрудников, которые уже нарушают правила, включая покрытых исключениями
+func (srv *Service) FindViolations() ([]ViolationResponse, error) {
+	var entities, err = srv.repo.FindViolations()
+	if err != nil {
+		return []ViolationResponse{}, fmt.Errorf("error get SoD violations: %w", err)
+	}
+
+	var resp = []ViolationResponse{}
+	for _, v := range entities {
+		resp = append(resp, v.toResponse())
+	}
+	return resp, nil
+}
+
+func (srv *Service) findRule(id int64) (RuleEntity, error) {
+	var entity, err = srv.repo.FindRuleById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RuleEntity{}, common.NotFoundError{Message: fmt.Sprintf("SoD rule with id %d not found", id)}
+	}
+	if err != nil {
+		return RuleEntity{}, fmt.Errorf("error finding SoD rule with id %d: %w", id, err)
+	}
+	return entity, nil
+}
@@ -0,0 +1,186 @@
+package sod
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/zhedevops/idm/inner/common"
+	"github.com/zhedevops/idm/inner/validator"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) FindRules() ([]RuleEntity, error) {
+	args := m.Called()
+	return args.Get(0).([]RuleEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindRuleById(id int64) (RuleEntity, error) {
+	args := m.Called(id)
+	return args.Get(0).(RuleEntity), args.Error(1)
+}
+
+func (m *MockRepo) BeginTransaction() (*sqlx.Tx, error) {
+	args := m.Called()
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockRepo) FindByNameTx(tx *sqlx.Tx, name string) (bool, error) {
+	args := m.Called(tx, name)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) CountRolesTx(tx *sqlx.Tx, ids []int64) (int, error) {
+	args := m.Called(tx, ids)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepo) CreateRuleTx(tx *sqlx.Tx, e *RuleEntity) error {
+	args := m.Called(tx, e)
+	e.Id = 1
+	return args.Error(0)
+}
+
+func (m *MockRepo) DeleteRule(id int64) (int64, error) {
+	args := m.Called(id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindExceptions(ruleId int64) ([]ExceptionEntity, error) {
+	args := m.Called(ruleId)
+	return args.Get(0).([]ExceptionEntity), args.Error(1)
+}
+
+func (m *MockRepo) SaveException(e *ExceptionEntity) error {
+	args := m.Called(e)
+	return args.Error(0)
+}
+
+func (m *MockRepo) DeleteException(ruleId int64, employeeId int64) (int64, error) {
+	args := m.Called(ruleId, employeeId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) FindConflictsTx(tx *sqlx.Tx, employeeId int64, roleId int64) ([]ConflictEntity, error) {
+	args := m.Called(tx, employeeId, roleId)
+	return args.Get(0).([]ConflictEntity), args.Error(1)
+}
+
+func (m *MockRepo) FindViolations() ([]ViolationEntity, error) {
+	args := m.Called()
+	return args.Get(0).([]ViolationEntity), args.Error(1)
+}
+
+// newTx создаёт транзакцию sqlmock, которая ожидает коммит или откат
+func newTx(a *assert.Assertions, commit bool) (*sqlx.Tx, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	a.Nil(err)
+	mock.ExpectBegin()
+	if commit {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+	tx, err := sqlx.NewDb(db, "sqlmock").Beginx()
+	a.Nil(err)
+	return tx, mock
+}
+
+func TestCheckTx(t *testing.T) {
+	var a = assert.New(t)
+	var tx = &sqlx.Tx{}
+
+	t.Run("should allow role without conflicts", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New())
+		repo.On("FindConflictsTx", tx, int64(10), int64(2)).Return([]ConflictEntity{}, nil)
+		a.Nil(svc.CheckTx(tx, 10, 2))
+	})
+
+	t.Run("should reject role conflicting with one already held", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New())
+		repo.On("FindConflictsTx", tx, int64(10), int64(2)).Return([]ConflictEntity{
+			{RuleId: 1, RuleName: "Payments", Enforcement: EnforcementReject, RoleId: 1, RoleName: "Payment Creator"},
+			{RuleId: 2, RuleName: "Vendors", Enforcement: EnforcementException, RoleId: 3, RoleName: "Vendor Manager"},
+		}, nil)
+		var err = svc.CheckTx(tx, 10, 2)
+		a.ErrorAs(err, &common.PolicyViolationError{})
+		a.Equal(`role 2 cannot be assigned to employee 10: rule "Payments" forbids combining it with role "Payment Creator"; `+
+			`rule "Vendors" forbids combining it with role "Vendor Manager" without an approved exception`, err.Error())
+	})
+}
+
+func TestCreateRule(t *testing.T) {
+	var a = assert.New(t)
+
+	t.Run("should create rule with reject enforcement by default", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New())
+		tx, sqlMock := newTx(a, true)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, "Payments").Return(false, nil)
+		repo.On("CountRolesTx", tx, []int64{1, 2}).Return(2, nil)
+		repo.On("CreateRuleTx", tx, mock.MatchedBy(func(e *RuleEntity) bool {
+			return e.Enforcement == EnforcementReject
+		})).Return(nil)
+		var id, err = svc.CreateRule(CreateRuleRequest{Name: "Payments", RoleIds: []int64{1, 2, 1}})
+		a.Nil(err)
+		a.Equal(int64(1), id)
+		a.Nil(sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("should require two different roles", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator.New())
+		var _, err = svc.CreateRule(CreateRuleRequest{Name: "Payments", RoleIds: []int64{1, 1}})
+		a.ErrorAs(err, &common.RequestValidationError{})
+	})
+
+	t.Run("should return not found for unknown role", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New())
+		tx, _ := newTx(a, false)
+		repo.On("BeginTransaction").Return(tx, nil)
+		repo.On("FindByNameTx", tx, "Payments").Return(false, nil)
+		repo.On("CountRolesTx", tx, []int64{1, 99}).Return(1, nil)
+		var _, err = svc.CreateRule(CreateRuleRequest{Name: "Payments", RoleIds: []int64{1, 99}})
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+}
+
+func TestGrantException(t *testing.T) {
+	var a = assert.New(t)
+	var request = ExceptionRequest{RuleId: 1, EmployeeId: 10, ApprovedBy: 20, Reason: "only finance person in branch"}
+
+	t.Run("should not allow exception from reject rule", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New())
+		repo.On("FindRuleById", int64(1)).Return(RuleEntity{Id: 1, Name: "Payments", Enforcement: EnforcementReject}, nil)
+		var _, err = svc.GrantException(request)
+		a.ErrorAs(err, &common.RequestValidationError{})
+		repo.AssertNotCalled(t, "SaveException", mock.Anything)
+	})
+
+	t.Run("should save exception approved by another employee", func(t *testing.T) {
+		var repo = new(MockRepo)
+		var svc = NewService(repo, validator.New())
+		repo.On("FindRuleById", int64(1)).Return(RuleEntity{Id: 1, Name: "Vendors", Enforcement: EnforcementException}, nil)
+		repo.On("SaveException", mock.Anything).Return(nil)
+		var resp, err = svc.GrantException(request)
+		a.Nil(err)
+		a.Equal(int64(20), resp.ApprovedBy)
+	})
+
+	t.Run("should forbid approving own exception", func(t *testing.T) {
+		var svc = NewService(new(MockRepo), validator.New())
+		var own = request
+		own.ApprovedBy = own.EmployeeId
+		var _, err = svc.GrantException(own)
+		a.ErrorAs(err, &common.ForbiddenError{})
+	})
+}
@@ -6,6 +6,10 @@ import "github.com/gofiber/fiber/v2"
 type Server struct {
 	App        *fiber.App
 	GroupApiV1 fiber.Router
+	// группа "/scim/v2" для клиентов, работающих по протоколу SCIM 2.0
+	GroupScimV2 fiber.Router
+	// группа "/oauth2" для приложений, входящих через OpenID Connect
+	GroupOAuth2 fiber.Router
 }
 
 // функция-конструктор
@@ -18,8 +22,16 @@ func NewServer() *Server {
 
 	// создаём подгруппу "api/v1"
 	groupApiV1 := groupApi.Group("/v1")
+
+	// создаём группу "/scim/v2"
+	groupScimV2 := app.Group("/scim/v2")
+
+	// создаём группу "/oauth2"
+	groupOAuth2 := app.Group("/oauth2")
 	return &Server{
-		App:        app,
-		GroupApiV1: groupApiV1,
+		App:         app,
+		GroupApiV1:  groupApiV1,
+		GroupScimV2: groupScimV2,
+		GroupOAuth2: groupOAuth2,
 	}
 }
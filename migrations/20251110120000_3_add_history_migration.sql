@@ -0,0 +1,67 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE employee_history (
+    history_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    employee_id BIGINT NOT NULL,
+    operation TEXT NOT NULL,
+    data JSONB NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
+CREATE INDEX employee_history_employee_id_idx ON employee_history (employee_id, changed_at);
+
+CREATE TABLE role_history (
+    history_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    role_id BIGINT NOT NULL,
+    operation TEXT NOT NULL,
+    data JSONB NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
+CREATE INDEX role_history_role_id_idx ON role_history (role_id, changed_at);
+
+-- снимок строки целиком хранится в data, поэтому новые колонки попадают в историю без правки триггеров
+CREATE FUNCTION employee_history_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO employee_history (employee_id, operation, data) VALUES (OLD.id, TG_OP, to_jsonb(OLD));
+        RETURN OLD;
+    END IF;
+    INSERT INTO employee_history (employee_id, operation, data) VALUES (NEW.id, TG_OP, to_jsonb(NEW));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION role_history_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO role_history (role_id, operation, data) VALUES (OLD.id, TG_OP, to_jsonb(OLD));
+        RETURN OLD;
+    END IF;
+    INSERT INTO role_history (role_id, operation, data) VALUES (NEW.id, TG_OP, to_jsonb(NEW));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER employee_history AFTER INSERT OR UPDATE OR DELETE ON employee
+    FOR EACH ROW EXECUTE FUNCTION employee_history_trigger();
+CREATE TRIGGER role_history AFTER INSERT OR UPDATE OR DELETE ON role
+    FOR EACH ROW EXECUTE FUNCTION role_history_trigger();
+
+-- у записей, созданных до появления истории, первой версией становится их текущее состояние
+-- на момент последнего изменения: более ранние состояния неизвестны
+INSERT INTO employee_history (employee_id, operation, data, changed_at)
+SELECT id, 'INSERT', to_jsonb(employee), updated_at FROM employee ORDER BY id;
+INSERT INTO role_history (role_id, operation, data, changed_at)
+SELECT id, 'INSERT', to_jsonb(role), updated_at FROM role ORDER BY id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TRIGGER IF EXISTS employee_history ON employee;
+DROP TRIGGER IF EXISTS role_history ON role;
+DROP FUNCTION IF EXISTS employee_history_trigger();
+DROP FUNCTION IF EXISTS role_history_trigger();
+DROP TABLE IF EXISTS employee_history CASCADE;
+DROP TABLE IF EXISTS role_history CASCADE;
+-- +goose StatementEnd
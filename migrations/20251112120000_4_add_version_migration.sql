@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE employee ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE role ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+-- снимки истории, сохранённые до появления колонки, получают ту же версию, что и строки
+UPDATE employee_history SET data = data || '{"version": 1}' WHERE NOT data ? 'version';
+UPDATE role_history SET data = data || '{"version": 1}' WHERE NOT data ? 'version';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE employee DROP COLUMN IF EXISTS version;
+ALTER TABLE role DROP COLUMN IF EXISTS version;
+-- +goose StatementEnd
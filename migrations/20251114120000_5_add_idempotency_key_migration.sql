@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- ключ действует в пределах клиента: owner — сотрудник или API-ключ, отправивший запрос
+CREATE TABLE idempotency_key (
+    owner TEXT NOT NULL DEFAULT '',
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INT NOT NULL DEFAULT 0,
+    content_type TEXT NOT NULL DEFAULT '',
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    -- до какого момента ключ занят обрабатываемым запросом; ключ запроса, не дождавшегося ответа
+    -- из-за падения экземпляра, освобождается по истечении этого срока, а не через весь срок хранения
+    locked_until TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (owner, key)
+);
+CREATE INDEX idempotency_key_expires_at_idx ON idempotency_key (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS idempotency_key CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE employee_role (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (employee_id, role_id)
+);
+CREATE INDEX employee_role_role_id_idx ON employee_role (role_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS employee_role CASCADE;
+-- +goose StatementEnd
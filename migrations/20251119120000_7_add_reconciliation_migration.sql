@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE reconciliation_report (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    connector TEXT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    accounts INT NOT NULL DEFAULT 0,
+    discrepancies INT NOT NULL DEFAULT 0
+);
+CREATE INDEX reconciliation_report_connector_idx ON reconciliation_report (connector, started_at);
+
+CREATE TABLE reconciliation_discrepancy (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    report_id BIGINT NOT NULL REFERENCES reconciliation_report (id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    employee_id BIGINT NOT NULL DEFAULT 0,
+    account_name TEXT NOT NULL DEFAULT '',
+    role_id BIGINT NOT NULL DEFAULT 0,
+    entitlement_name TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX reconciliation_discrepancy_report_id_idx ON reconciliation_discrepancy (report_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS reconciliation_discrepancy CASCADE;
+DROP TABLE IF EXISTS reconciliation_report CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- табельный номер из HR-системы, по которому импорт сопоставляет строки файла с сотрудниками
+ALTER TABLE employee ADD COLUMN external_id TEXT UNIQUE;
+-- момент увольнения по выгрузке HR; уволенный сотрудник остаётся в таблице, но не может войти
+ALTER TABLE employee ADD COLUMN terminated_at TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE employee DROP COLUMN IF EXISTS terminated_at;
+ALTER TABLE employee DROP COLUMN IF EXISTS external_id;
+-- +goose StatementEnd
@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- владелец роли и руководитель сотрудника согласуют заявки на доступ
+ALTER TABLE role ADD COLUMN owner_id BIGINT REFERENCES employee (id) ON DELETE SET NULL;
+ALTER TABLE employee ADD COLUMN manager_id BIGINT REFERENCES employee (id) ON DELETE SET NULL;
+-- порядок согласующих для роли; если строки нет, заявку согласует владелец роли
+CREATE TABLE role_approval_policy (
+    role_id BIGINT PRIMARY KEY REFERENCES role (id) ON DELETE CASCADE,
+    approvers TEXT[] NOT NULL
+);
+CREATE TABLE access_request (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    requester_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+    justification TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    -- согласующие, зафиксированные при подаче заявки, и номер текущего шага
+    approvers TEXT[] NOT NULL,
+    -- сотрудники, которые были согласующими на момент подачи; позже назначенный руководитель или владелец
+    -- заявку не согласует
+    approver_ids BIGINT[] NOT NULL,
+    step INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    closed_at TIMESTAMPTZ
+);
+-- на одну роль для сотрудника может быть только одна незакрытая заявка
+CREATE UNIQUE INDEX access_request_pending_idx ON access_request (employee_id, role_id) WHERE status = 'pending';
+CREATE TABLE access_request_decision (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    request_id BIGINT NOT NULL REFERENCES access_request (id) ON DELETE CASCADE,
+    step INT NOT NULL,
+    approver_type TEXT NOT NULL,
+    approver_id BIGINT NOT NULL,
+    decision TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    decided_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS access_request_decision CASCADE;
+DROP TABLE IF EXISTS access_request CASCADE;
+DROP TABLE IF EXISTS role_approval_policy CASCADE;
+ALTER TABLE employee DROP COLUMN IF EXISTS manager_id;
+ALTER TABLE role DROP COLUMN IF EXISTS owner_id;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- срок действия назначения: с valid_from по valid_until, пустой valid_until — бессрочно
+ALTER TABLE employee_role ADD COLUMN valid_from TIMESTAMPTZ;
+UPDATE employee_role SET valid_from = created_at;
+ALTER TABLE employee_role ALTER COLUMN valid_from SET NOT NULL;
+ALTER TABLE employee_role ALTER COLUMN valid_from SET DEFAULT NOW();
+ALTER TABLE employee_role ADD COLUMN valid_until TIMESTAMPTZ;
+ALTER TABLE employee_role ADD CONSTRAINT employee_role_validity_check CHECK (valid_until IS NULL OR valid_until > valid_from);
+-- activated: начало действия обработано и роль передана во внешние системы;
+-- warned_at: когда отправлено предупреждение об окончании срока
+ALTER TABLE employee_role ADD COLUMN activated BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE employee_role ADD COLUMN warned_at TIMESTAMPTZ;
+CREATE INDEX employee_role_valid_until_idx ON employee_role (valid_until) WHERE valid_until IS NOT NULL;
+-- назначения, действующие сейчас; по ним считаются права сотрудников
+CREATE VIEW effective_employee_role AS
+    SELECT * FROM employee_role
+    WHERE valid_from <= NOW() AND (valid_until IS NULL OR valid_until > NOW());
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP VIEW IF EXISTS effective_employee_role;
+ALTER TABLE employee_role DROP CONSTRAINT IF EXISTS employee_role_validity_check;
+ALTER TABLE employee_role DROP COLUMN IF EXISTS warned_at;
+ALTER TABLE employee_role DROP COLUMN IF EXISTS activated;
+ALTER TABLE employee_role DROP COLUMN IF EXISTS valid_until;
+ALTER TABLE employee_role DROP COLUMN IF EXISTS valid_from;
+-- +goose StatementEnd
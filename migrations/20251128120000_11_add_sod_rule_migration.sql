@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- правило разделения полномочий: сотрудник не может иметь две и более роли из набора
+CREATE TABLE sod_rule (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    -- reject: назначение отклоняется всегда; exception: разрешено при согласованном исключении
+    enforcement TEXT NOT NULL DEFAULT 'reject',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE TABLE sod_rule_role (
+    rule_id BIGINT NOT NULL REFERENCES sod_rule (id) ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+    PRIMARY KEY (rule_id, role_id)
+);
+CREATE INDEX sod_rule_role_role_id_idx ON sod_rule_role (role_id);
+CREATE TABLE sod_exception (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    rule_id BIGINT NOT NULL REFERENCES sod_rule (id) ON DELETE CASCADE,
+    employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    approved_by BIGINT NOT NULL REFERENCES employee (id),
+    valid_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (rule_id, employee_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS sod_exception CASCADE;
+DROP TABLE IF EXISTS sod_rule_role CASCADE;
+DROP TABLE IF EXISTS sod_rule CASCADE;
+-- +goose StatementEnd
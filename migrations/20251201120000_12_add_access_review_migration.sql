@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- кампания пересмотра доступа: снимок назначений в области scope_type/scope_id на момент запуска
+CREATE TABLE review_campaign (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    scope_type TEXT NOT NULL,
+    scope_id BIGINT,
+    reviewer_type TEXT NOT NULL,
+    created_by BIGINT NOT NULL REFERENCES employee (id),
+    deadline TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    closed_at TIMESTAMPTZ
+);
+CREATE TABLE review_item (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    campaign_id BIGINT NOT NULL REFERENCES review_campaign (id) ON DELETE CASCADE,
+    employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+    reviewer_id BIGINT NOT NULL REFERENCES employee (id),
+    decision TEXT NOT NULL DEFAULT 'pending',
+    comment TEXT NOT NULL DEFAULT '',
+    decided_at TIMESTAMPTZ,
+    UNIQUE (campaign_id, employee_id, role_id)
+);
+CREATE INDEX review_item_reviewer_id_idx ON review_item (reviewer_id) WHERE decision = 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS review_item CASCADE;
+DROP TABLE IF EXISTS review_campaign CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- атрибуты сотрудника, по которым правила назначают роли
+ALTER TABLE employee ADD COLUMN department TEXT NOT NULL DEFAULT '';
+ALTER TABLE employee ADD COLUMN title TEXT NOT NULL DEFAULT '';
+ALTER TABLE employee ADD COLUMN employment_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE employee ADD COLUMN location TEXT NOT NULL DEFAULT '';
+-- правило выдаёт роль сотрудникам, атрибуты которых входят во все непустые списки правила
+CREATE TABLE assignment_rule (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+    departments TEXT[] NOT NULL DEFAULT '{}',
+    titles TEXT[] NOT NULL DEFAULT '{}',
+    employment_types TEXT[] NOT NULL DEFAULT '{}',
+    locations TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- правило, выдавшее роль; у назначений, сделанных вручную, пусто.
+-- Внешнего ключа нет: назначения удалённого правила снимаются при пересчёте, а не превращаются в ручные
+ALTER TABLE employee_role ADD COLUMN rule_id BIGINT;
+CREATE INDEX employee_role_rule_id_idx ON employee_role (rule_id) WHERE rule_id IS NOT NULL;
+CREATE OR REPLACE VIEW effective_employee_role AS
+    SELECT * FROM employee_role
+    WHERE valid_from <= NOW() AND (valid_until IS NULL OR valid_until > NOW());
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP VIEW IF EXISTS effective_employee_role;
+ALTER TABLE employee_role DROP COLUMN IF EXISTS rule_id;
+CREATE VIEW effective_employee_role AS
+    SELECT * FROM employee_role
+    WHERE valid_from <= NOW() AND (valid_until IS NULL OR valid_until > NOW());
+DROP TABLE IF EXISTS assignment_rule CASCADE;
+ALTER TABLE employee DROP COLUMN IF EXISTS location;
+ALTER TABLE employee DROP COLUMN IF EXISTS employment_type;
+ALTER TABLE employee DROP COLUMN IF EXISTS title;
+ALTER TABLE employee DROP COLUMN IF EXISTS department;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- подразделение: департамент, отдел или дирекция; корневые подразделения без parent_id
+CREATE TABLE org_unit (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    parent_id BIGINT REFERENCES org_unit (id),
+    head_id BIGINT REFERENCES employee (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- имена уникальны среди подразделений одного родителя
+CREATE UNIQUE INDEX org_unit_parent_name_idx ON org_unit (COALESCE(parent_id, 0), name);
+ALTER TABLE employee ADD COLUMN org_unit_id BIGINT REFERENCES org_unit (id) ON DELETE SET NULL;
+CREATE INDEX employee_org_unit_id_idx ON employee (org_unit_id);
+-- правило с org_unit_id выдаёт роль только сотрудникам этого подразделения и вложенных в него
+ALTER TABLE assignment_rule ADD COLUMN org_unit_id BIGINT REFERENCES org_unit (id) ON DELETE CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE assignment_rule DROP COLUMN IF EXISTS org_unit_id;
+ALTER TABLE employee DROP COLUMN IF EXISTS org_unit_id;
+DROP TABLE IF EXISTS org_unit CASCADE;
+-- +goose StatementEnd
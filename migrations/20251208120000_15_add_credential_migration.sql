@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- локальный пароль сотрудника; хранится только хеш в формате argon2id или bcrypt
+CREATE TABLE credential (
+    employee_id BIGINT PRIMARY KEY REFERENCES employee (id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    -- пароль выдан администратором и должен быть сменён при первом входе
+    must_change BOOLEAN NOT NULL DEFAULT FALSE,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- прежние пароли сотрудника для запрета их повторного использования
+CREATE TABLE password_history (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX password_history_employee_id_idx ON password_history (employee_id, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS password_history;
+DROP TABLE IF EXISTS credential;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- refresh-токены сессий; хранится только SHA-256 токена
+CREATE TABLE refresh_token (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    -- все токены одной сессии, полученные друг из друга при обновлении
+    family_id TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    -- токен уже обменян на новый; повторное предъявление означает кражу
+    used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX refresh_token_family_id_idx ON refresh_token (family_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS refresh_token;
+-- +goose StatementEnd
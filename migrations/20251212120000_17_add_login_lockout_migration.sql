@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- счётчики неудачных входов по сотруднику (kind = 'employee', key — id) и по IP клиента (kind = 'ip')
+CREATE TABLE login_lockout (
+    kind TEXT NOT NULL,
+    key TEXT NOT NULL,
+    -- неудачные попытки подряд с last_failure_at; сбрасываются при блокировке
+    failures INT NOT NULL DEFAULT 0,
+    -- сколько раз подряд ключ блокировался; от этого зависит срок следующей блокировки
+    lockouts INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (kind, key)
+);
+CREATE INDEX login_lockout_locked_until_idx ON login_lockout (locked_until) WHERE locked_until IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS login_lockout;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- TOTP сотрудника по RFC 6238; секрет нужен в открытом виде, чтобы вычислять коды
+CREATE TABLE mfa_totp (
+    employee_id BIGINT PRIMARY KEY REFERENCES employee (id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    -- пустое, пока сотрудник не подтвердил подключение кодом из приложения
+    confirmed_at TIMESTAMPTZ,
+    -- последний принятый интервал; коды этого и более ранних интервалов повторно не принимаются
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- одноразовые коды восстановления; хранится только SHA-256 кода
+CREATE TABLE mfa_recovery_code (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (employee_id, code_hash)
+);
+-- сессия открыта со вторым фактором
+ALTER TABLE refresh_token ADD COLUMN mfa BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE refresh_token DROP COLUMN IF EXISTS mfa;
+DROP TABLE IF EXISTS mfa_recovery_code;
+DROP TABLE IF EXISTS mfa_totp;
+-- +goose StatementEnd
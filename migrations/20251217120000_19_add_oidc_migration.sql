@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- приложения, входящие через OpenID Connect; у публичных клиентов секрета нет, их защищает только PKCE
+CREATE TABLE oidc_client (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- SHA-256 секрета клиента
+    secret_hash TEXT,
+    redirect_uris TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- выданные коды авторизации; хранится только SHA-256 кода
+CREATE TABLE oidc_authorization_code (
+    code_hash TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES oidc_client (id) ON DELETE CASCADE,
+    employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    nonce TEXT NOT NULL DEFAULT '',
+    code_challenge TEXT NOT NULL,
+    -- роли и способы входа из сессии IdM, в которой выдан код
+    roles TEXT[] NOT NULL,
+    amr TEXT[] NOT NULL,
+    auth_time TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS oidc_authorization_code;
+DROP TABLE IF EXISTS oidc_client;
+-- +goose StatementEnd
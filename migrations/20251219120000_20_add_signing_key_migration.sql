@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- ключи подписи токенов: next уже публикуется в JWKS, active подписывает, retired публикуется до конца льготного срока
+CREATE TABLE signing_key (
+    kid TEXT PRIMARY KEY,
+    algorithm TEXT NOT NULL,
+    state TEXT NOT NULL CHECK (state IN ('next', 'active', 'retired')),
+    -- открытый ключ в PKIX DER
+    public_key BYTEA NOT NULL,
+    -- закрытый ключ в PKCS#8 DER, зашифрованный AES-256-GCM
+    private_key BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    activated_at TIMESTAMPTZ,
+    retired_at TIMESTAMPTZ
+);
+-- активный и следующий ключ всегда в единственном экземпляре
+CREATE UNIQUE INDEX signing_key_state_idx ON signing_key (state) WHERE state IN ('next', 'active');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS signing_key;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- сервисные аккаунты для машинных клиентов: выгрузка из HR, CI и другие интеграции
+CREATE TABLE service_account (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- API-ключи сервисных аккаунтов; по префиксу ключ находится, сам ключ хранится только как SHA-256
+CREATE TABLE api_key (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    service_account_id BIGINT NOT NULL REFERENCES service_account (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL UNIQUE,
+    secret_hash TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip TEXT,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX api_key_service_account_idx ON api_key (service_account_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS api_key;
+DROP TABLE IF EXISTS service_account;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- токен-бакеты ограничения частоты запросов, общие для всех экземпляров IdM; ключ — правило и клиент
+CREATE UNLOGGED TABLE rate_limit_bucket (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    -- достался ли токен последнему запросу
+    allowed BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX rate_limit_bucket_updated_at_idx ON rate_limit_bucket (updated_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS rate_limit_bucket;
+-- +goose StatementEnd
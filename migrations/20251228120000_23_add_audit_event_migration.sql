@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- журнал аудита: каждое событие хранит SHA-256 от своего содержимого и хеша предыдущего события,
+-- поэтому правка или удаление записи обнаруживается проверкой цепочки (audit verify)
+CREATE TABLE audit_event (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    category TEXT NOT NULL,
+    action TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    -- JSON, а не JSONB: текст хранится как есть, и хеш по нему сходится при проверке
+    data JSON NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL UNIQUE
+);
+CREATE INDEX audit_event_subject_idx ON audit_event (subject);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS audit_event;
+-- +goose StatementEnd
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/accessrequest"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/role"
+	"testing"
+)
+
+func TestAccessRequestRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateAccessRequestTables(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM access_request")
+		db.MustExec("DELETE FROM role_approval_policy")
+		db.MustExec("DELETE FROM employee_role")
+		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM role")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = accessrequest.NewRepository(db)
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+	var ownerId = NewFixtureEmployee(employee.NewRepository(db)).Employee("Jane Doe")
+	var roleId = NewFixtureRole(role.NewRepository(db)).Role("Payment Approver")
+
+	t.Run("Save policy and read it back", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		found, err := Repository.SavePolicyTx(tx, accessrequest.PolicyEntity{
+			RoleId:    roleId,
+			OwnerId:   sql.NullInt64{Int64: ownerId, Valid: true},
+			Approvers: []string{accessrequest.ApproverRoleOwner},
+		})
+		a.Nil(err, "SavePolicyTx: expected error to be nil")
+		a.True(found)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		policy, err := Repository.FindPolicy(roleId)
+		a.Nil(err, "expected error to be nil")
+		a.Equal(ownerId, policy.OwnerId.Int64)
+		a.Equal([]string{accessrequest.ApproverRoleOwner}, []string(policy.Approvers))
+	})
+
+	var requestId int64
+	t.Run("Create request and find it pending for role owner", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		var request = accessrequest.Entity{
+			RequesterId:   employeeId,
+			EmployeeId:    employeeId,
+			RoleId:        roleId,
+			Justification: "need to approve invoices",
+			Approvers:     []string{accessrequest.ApproverRoleOwner},
+			ApproverIds:   []int64{ownerId},
+		}
+		a.Nil(Repository.CreateTx(tx, &request), "CreateTx: expected error to be nil")
+		a.Equal([]int64{ownerId}, []int64(request.ApproverIds))
+		a.Equal(accessrequest.StatusPending, request.Status)
+		pending, err := Repository.HasPendingTx(tx, employeeId, roleId)
+		a.Nil(err, "HasPendingTx: expected error to be nil")
+		a.True(pending)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+		requestId = request.Id
+
+		requests, err := Repository.FindPendingByApprover(ownerId)
+		a.Nil(err, "expected error to be nil")
+		a.Len(requests, 1)
+		requests, err = Repository.FindPendingByApprover(employeeId)
+		a.Nil(err, "expected error to be nil")
+		a.Empty(requests)
+	})
+
+	t.Run("Approve request and assign role", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		request, err := Repository.FindByIdTx(tx, requestId)
+		a.Nil(err, "FindByIdTx: expected error to be nil")
+		a.Nil(Repository.CreateDecisionTx(tx, accessrequest.DecisionEntity{
+			RequestId: requestId, ApproverType: accessrequest.ApproverRoleOwner, ApproverId: ownerId,
+			Decision: accessrequest.DecisionApproved,
+		}), "CreateDecisionTx: expected error to be nil")
+		request.Step, request.Status = 1, accessrequest.StatusApproved
+		a.Nil(Repository.UpdateTx(tx, &request), "UpdateTx: expected error to be nil")
+		a.True(request.ClosedAt.Valid)
+		a.Nil(Repository.AssignTx(tx, employeeId, roleId), "AssignTx: expected error to be nil")
+		assigned, err := Repository.HasAssignmentTx(tx, employeeId, roleId)
+		a.Nil(err, "HasAssignmentTx: expected error to be nil")
+		a.True(assigned)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		decisions, err := Repository.FindDecisions(requestId)
+		a.Nil(err, "expected error to be nil")
+		a.Len(decisions, 1)
+	})
+
+	clearDatabase()
+}
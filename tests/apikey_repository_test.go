@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/apikey"
+	"testing"
+	"time"
+)
+
+func TestApiKeyRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateServiceAccountTables(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM api_key")
+		db.MustExec("DELETE FROM service_account")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = apikey.NewRepository(db)
+	var now = time.Now().Truncate(time.Microsecond)
+	var account = apikey.AccountEntity{Name: "hr-sync", Description: "HR import"}
+	var key = apikey.KeyEntity{
+		Name:       "nightly",
+		Prefix:     "0123456789ab",
+		SecretHash: "hash",
+		Scopes:     pq.StringArray{"employees:write"},
+		ExpiresAt:  sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+	}
+
+	t.Run("Create account and key", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		exists, err := Repository.AccountExistsTx(tx, "hr-sync")
+		a.Nil(err, "AccountExistsTx: expected error to be nil")
+		a.False(exists)
+		a.Nil(Repository.CreateAccountTx(tx, &account), "CreateAccountTx: expected error to be nil")
+		key.ServiceAccountId = account.Id
+		a.Nil(Repository.CreateKeyTx(tx, &key), "CreateKeyTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		found, err := Repository.FindKeyByPrefix("0123456789ab")
+		a.Nil(err, "FindKeyByPrefix: expected error to be nil")
+		a.Equal(key.Id, found.Id)
+		a.Equal([]string{"employees:write"}, []string(found.Scopes))
+		_, err = Repository.FindKeyByPrefix("missing")
+		a.ErrorIs(err, sql.ErrNoRows)
+	})
+
+	t.Run("Track last use", func(t *testing.T) {
+		a.Nil(Repository.TouchKey(key.Id, now, "10.0.0.1"), "TouchKey: expected error to be nil")
+		// повтор в течение минуты с того же адреса не пишет в базу
+		a.Nil(Repository.TouchKey(key.Id, now.Add(time.Second), "10.0.0.1"), "TouchKey: expected error to be nil")
+		keys, err := Repository.FindKeys(account.Id)
+		a.Nil(err, "FindKeys: expected error to be nil")
+		a.Len(keys, 1)
+		a.True(keys[0].LastUsedAt.Time.Equal(now))
+		a.Equal("10.0.0.1", keys[0].LastUsedIp.String)
+	})
+
+	t.Run("Shorten expiry and revoke", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		locked, err := Repository.FindKeyTx(tx, key.Id)
+		a.Nil(err, "FindKeyTx: expected error to be nil")
+		a.Nil(Repository.ExpireKeyTx(tx, locked.Id, now), "ExpireKeyTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		revoked, err := Repository.RevokeKey(account.Id+1, key.Id, now)
+		a.Nil(err, "RevokeKey: expected error to be nil")
+		a.False(revoked, "key of another account must not be revoked")
+		revoked, err = Repository.RevokeKey(account.Id, key.Id, now)
+		a.Nil(err, "RevokeKey: expected error to be nil")
+		a.True(revoked)
+
+		found, err := Repository.FindKeyByPrefix("0123456789ab")
+		a.Nil(err, "FindKeyByPrefix: expected error to be nil")
+		a.True(found.ExpiresAt.Time.Equal(now))
+		a.True(found.RevokedAt.Valid)
+	})
+
+	t.Run("Delete account with keys", func(t *testing.T) {
+		deleted, err := Repository.DeleteAccount(account.Id)
+		a.Nil(err, "DeleteAccount: expected error to be nil")
+		a.True(deleted)
+		_, err = Repository.FindKeyByPrefix("0123456789ab")
+		a.ErrorIs(err, sql.ErrNoRows)
+	})
+
+	clearDatabase()
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/role"
+	"testing"
+	"time"
+)
+
+func TestAssignmentRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeRoleTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM employee_role")
+		db.MustExec("DELETE FROM audit_event")
+		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM role")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = assignment.NewRepository(db)
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+	var roleId = NewFixtureRole(role.NewRepository(db)).Role("Admin")
+
+	t.Run("Assign role in TX and find it", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.CreateTx(tx, employeeId, roleId), "CreateTx: expected error to be nil")
+		// повторное назначение не создаёт дубликат
+		a.Nil(Repository.CreateTx(tx, employeeId, roleId), "CreateTx: expected error to be nil")
+		members, err := Repository.FindByRoleIdTx(tx, roleId)
+		a.Nil(err, "FindByRoleIdTx: expected error to be nil")
+		a.Len(members, 1)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		assignments, err := Repository.FindByEmployeeId(employeeId)
+		a.Nil(err, "expected error to be nil")
+		a.Len(assignments, 1)
+		a.Equal(roleId, assignments[0].RoleId)
+	})
+
+	t.Run("Unassign role in TX", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		count, err := Repository.DeleteTx(tx, employeeId, roleId)
+		a.Nil(err, "DeleteTx: expected error to be nil")
+		a.Equal(int64(1), count)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		assignments, err := Repository.FindByRoleId(roleId)
+		a.Nil(err, "expected error to be nil")
+		a.Empty(assignments)
+	})
+
+	t.Run("Time-bound assignments expire and activate", func(t *testing.T) {
+		var now = time.Now()
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.UpsertTx(tx, assignment.Entity{EmployeeId: employeeId, RoleId: roleId,
+			ValidFrom: now.Add(time.Hour), ValidUntil: sql.NullTime{Time: now.Add(2 * time.Hour), Valid: true}}),
+			"UpsertTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		// назначение, срок которого ещё не наступил, не даёт прав
+		assignments, err := Repository.FindByEmployeeId(employeeId)
+		a.Nil(err, "expected error to be nil")
+		a.Empty(assignments)
+
+		tx, err = Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		activated, err := Repository.ActivateTx(tx, now.Add(90*time.Minute))
+		a.Nil(err, "ActivateTx: expected error to be nil")
+		a.Len(activated, 1)
+		expiring, err := Repository.MarkExpiringTx(tx, now.Add(90*time.Minute), now.Add(3*time.Hour))
+		a.Nil(err, "MarkExpiringTx: expected error to be nil")
+		a.Len(expiring, 1)
+		expired, err := Repository.ExpireTx(tx, now.Add(3*time.Hour))
+		a.Nil(err, "ExpireTx: expected error to be nil")
+		a.Len(expired, 1)
+		a.Nil(Repository.CreateAuditTx(tx, assignment.AuditEntity{EmployeeId: employeeId, RoleId: roleId,
+			Event: assignment.AuditExpired, ValidFrom: expired[0].ValidFrom, ValidUntil: expired[0].ValidUntil}),
+			"CreateAuditTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		assignments, err = Repository.FindAll()
+		a.Nil(err, "expected error to be nil")
+		a.Empty(assignments)
+	})
+
+	t.Run("Permanent assignment replaces time-bound one", func(t *testing.T) {
+		var now = time.Now()
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.UpsertTx(tx, assignment.Entity{EmployeeId: employeeId, RoleId: roleId,
+			ValidFrom: now.Add(time.Hour), ValidUntil: sql.NullTime{Time: now.Add(2 * time.Hour), Valid: true}}),
+			"UpsertTx: expected error to be nil")
+		a.Nil(Repository.CreateTx(tx, employeeId, roleId), "CreateTx: expected error to be nil")
+		found, err := Repository.FindTx(tx, employeeId, roleId)
+		a.Nil(err, "FindTx: expected error to be nil")
+		a.False(found.ValidUntil.Valid)
+		a.False(found.ValidFrom.After(now.Add(time.Minute)))
+		a.True(found.Activated)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		tx, err = Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		_, err = Repository.DeleteTx(tx, employeeId, roleId)
+		a.Nil(err, "DeleteTx: expected error to be nil")
+		_, err = Repository.FindTx(tx, employeeId, roleId)
+		a.ErrorIs(err, sql.ErrNoRows)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	clearDatabase()
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/assignmentrule"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/role"
+	"testing"
+)
+
+func TestAssignmentRuleRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateAssignmentRuleTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM assignment_rule")
+		db.MustExec("DELETE FROM employee_role")
+		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM role")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = assignmentrule.NewRepository(db)
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+	var roleId = NewFixtureRole(role.NewRepository(db)).Role("Engineer")
+	db.MustExec("UPDATE employee SET department = 'Engineering', location = 'Berlin' WHERE id = $1", employeeId)
+
+	var rule = assignmentrule.RuleEntity{Name: "Engineering", RoleId: roleId, Departments: []string{"Engineering"}}
+	t.Run("Create rule and find it with role name", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.CreateRuleTx(tx, &rule), "CreateRuleTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		found, err := Repository.FindRuleById(rule.Id)
+		a.Nil(err, "expected error to be nil")
+		a.Equal("Engineer", found.RoleName)
+		a.Equal([]string{"Engineering"}, []string(found.Departments))
+		a.Empty(found.Locations)
+	})
+
+	t.Run("Assign role by rule and remove it", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		found, err := Repository.FindEmployeeTx(tx, employeeId)
+		a.Nil(err, "FindEmployeeTx: expected error to be nil")
+		a.Equal("Engineering", found.Department)
+		a.Nil(Repository.AssignTx(tx, employeeId, roleId, rule.Id), "AssignTx: expected error to be nil")
+		assignments, err := Repository.FindAssignmentsTx(tx, employeeId)
+		a.Nil(err, "FindAssignmentsTx: expected error to be nil")
+		a.Len(assignments, 1)
+		a.Equal(rule.Id, assignments[0].RuleId.Int64)
+		a.Nil(Repository.RemoveTx(tx, employeeId, roleId), "RemoveTx: expected error to be nil")
+		assignments, err = Repository.FindAssignmentsTx(tx, employeeId)
+		a.Nil(err, "FindAssignmentsTx: expected error to be nil")
+		a.Empty(assignments)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	t.Run("Manual assignment takes over role given by rule", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.AssignTx(tx, employeeId, roleId, rule.Id), "AssignTx: expected error to be nil")
+		a.Nil(assignment.NewRepository(db).CreateTx(tx, employeeId, roleId), "CreateTx: expected error to be nil")
+		// ручное назначение правило не снимает
+		a.Nil(Repository.RemoveTx(tx, employeeId, roleId), "RemoveTx: expected error to be nil")
+		assignments, err := Repository.FindAssignmentsTx(tx, employeeId)
+		a.Nil(err, "FindAssignmentsTx: expected error to be nil")
+		a.Len(assignments, 1)
+		a.False(assignments[0].RuleId.Valid)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	clearDatabase()
+}
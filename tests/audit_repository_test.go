@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/audit"
+	"testing"
+	"time"
+)
+
+func TestAuditRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateAuditEventTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM audit_event")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = audit.NewRepository(db)
+	var Service = audit.NewService(Repository)
+
+	t.Run("Append events to chain and verify it", func(t *testing.T) {
+		_, err := Repository.FindHead()
+		a.ErrorIs(err, sql.ErrNoRows)
+
+		for _, action := range []string{"locked", "unlocked"} {
+			tx, err := db.Beginx()
+			a.Nil(err, "Beginx: expected error to be nil")
+			var event = audit.Event{
+				Category:   audit.CategoryLockout,
+				Action:     action,
+				Subject:    "ip:10.0.0.1",
+				Data:       map[string]any{"b": 2, "a": 1},
+				OccurredAt: time.Now(),
+			}
+			a.Nil(audit.AppendTx(tx, event), "AppendTx: expected error to be nil")
+			a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+		}
+
+		events, err := Repository.FindAfter(0, 10)
+		a.Nil(err, "FindAfter: expected error to be nil")
+		a.Len(events, 2)
+		a.Empty(events[0].PrevHash)
+		a.Equal(events[0].Hash, events[1].PrevHash)
+		head, err := Repository.FindHead()
+		a.Nil(err, "FindHead: expected error to be nil")
+		a.Equal(events[1].Id, head.Id)
+
+		result, err := Service.Verify()
+		a.Nil(err, "Verify: expected error to be nil")
+		a.True(result.Valid, result.Reason)
+		a.Equal(int64(2), result.Events)
+		a.Equal(audit.Head{Id: head.Id, Hash: head.Hash}, result.Head)
+	})
+
+	t.Run("Edited event breaks chain", func(t *testing.T) {
+		events, err := Repository.FindAfter(0, 10)
+		a.Nil(err, "FindAfter: expected error to be nil")
+		db.MustExec("UPDATE audit_event SET subject = 'ip:10.0.0.2' WHERE id = $1", events[0].Id)
+
+		result, err := Service.Verify()
+		a.Nil(err, "Verify: expected error to be nil")
+		a.False(result.Valid)
+		a.Equal(events[0].Id, result.BrokenId)
+	})
+
+	clearDatabase()
+}
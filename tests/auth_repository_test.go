@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/auth"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/role"
+	"testing"
+	"time"
+)
+
+func TestAuthRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRefreshTokenTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM refresh_token")
+		db.MustExec("DELETE FROM employee_role")
+		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM role")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = auth.NewRepository(db)
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+	var roleId = NewFixtureRole(role.NewRepository(db)).Role("Auditor")
+
+	t.Run("Find employee by name with effective roles", func(t *testing.T) {
+		db.MustExec("INSERT INTO employee_role (employee_id, role_id) VALUES ($1, $2)", employeeId, roleId)
+
+		id, err := Repository.FindEmployeeIdByName("John Doe")
+		a.Nil(err, "expected error to be nil")
+		a.Equal(employeeId, id)
+		_, err = Repository.FindEmployeeIdByName("Nobody")
+		a.ErrorIs(err, sql.ErrNoRows)
+		// уволенный сотрудник войти не может
+		var terminatedId = NewFixtureEmployee(employee.NewRepository(db)).Employee("Gone Away")
+		db.MustExec("UPDATE employee SET terminated_at = NOW() WHERE id = $1", terminatedId)
+		_, err = Repository.FindEmployeeIdByName("Gone Away")
+		a.ErrorIs(err, sql.ErrNoRows)
+
+		roles, err := Repository.FindRoles(employeeId)
+		a.Nil(err, "expected error to be nil")
+		a.Equal([]string{"Auditor"}, roles)
+	})
+
+	t.Run("Rotate token and revoke session", func(t *testing.T) {
+		var now = time.Now()
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		var first = auth.TokenEntity{EmployeeId: employeeId, FamilyId: "family", TokenHash: "first", ExpiresAt: now.Add(time.Hour), Mfa: true}
+		a.Nil(Repository.CreateTokenTx(tx, &first), "CreateTokenTx: expected error to be nil")
+		a.Nil(Repository.MarkUsedTx(tx, first.Id, now), "MarkUsedTx: expected error to be nil")
+		var second = auth.TokenEntity{EmployeeId: employeeId, FamilyId: "family", TokenHash: "second", ExpiresAt: now.Add(time.Hour)}
+		a.Nil(Repository.CreateTokenTx(tx, &second), "CreateTokenTx: expected error to be nil")
+		a.Nil(Repository.RevokeFamilyTx(tx, "family", now), "RevokeFamilyTx: expected error to be nil")
+
+		found, err := Repository.FindTokenTx(tx, "first")
+		a.Nil(err, "FindTokenTx: expected error to be nil")
+		a.True(found.UsedAt.Valid)
+		a.True(found.RevokedAt.Valid)
+		a.True(found.Mfa)
+		found, err = Repository.FindTokenTx(tx, "second")
+		a.Nil(err, "FindTokenTx: expected error to be nil")
+		a.False(found.UsedAt.Valid)
+		a.True(found.RevokedAt.Valid)
+		_, err = Repository.FindTokenTx(tx, "unknown")
+		a.ErrorIs(err, sql.ErrNoRows)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	clearDatabase()
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/credential"
+	"github.com/zhedevops/idm/inner/employee"
+	"testing"
+	"time"
+)
+
+func TestCredentialRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateCredentialTables(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRefreshTokenTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM refresh_token")
+		db.MustExec("DELETE FROM password_history")
+		db.MustExec("DELETE FROM credential")
+		db.MustExec("DELETE FROM employee")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = credential.NewRepository(db)
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+
+	t.Run("Save password and replace it", func(t *testing.T) {
+		_, err := Repository.FindByEmployeeId(employeeId)
+		a.ErrorIs(err, sql.ErrNoRows)
+
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		exists, err := Repository.LockEmployeeTx(tx, employeeId)
+		a.Nil(err, "LockEmployeeTx: expected error to be nil")
+		a.True(exists)
+		var saved = credential.Entity{EmployeeId: employeeId, PasswordHash: "first", MustChange: true, ChangedAt: time.Now()}
+		a.Nil(Repository.SaveTx(tx, &saved), "SaveTx: expected error to be nil")
+		saved.PasswordHash = "second"
+		saved.MustChange = false
+		a.Nil(Repository.SaveTx(tx, &saved), "SaveTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		found, err := Repository.FindByEmployeeId(employeeId)
+		a.Nil(err, "expected error to be nil")
+		a.Equal("second", found.PasswordHash)
+		a.False(found.MustChange)
+	})
+
+	t.Run("Keep only last passwords in history", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		for _, hash := range []string{"h1", "h2", "h3"} {
+			a.Nil(Repository.AddHistoryTx(tx, employeeId, hash, 2), "AddHistoryTx: expected error to be nil")
+		}
+		hashes, err := Repository.FindHistoryTx(tx, employeeId, 5)
+		a.Nil(err, "FindHistoryTx: expected error to be nil")
+		a.Equal([]string{"h3", "h2"}, hashes)
+		exists, err := Repository.LockEmployeeTx(tx, -1)
+		a.Nil(err, "LockEmployeeTx: expected error to be nil")
+		a.False(exists)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	t.Run("Revoke refresh tokens of employee", func(t *testing.T) {
+		var expiresAt = time.Now().Add(time.Hour)
+		db.MustExec(`INSERT INTO refresh_token (employee_id, family_id, token_hash, expires_at) VALUES
+                     ($1, 'f1', 'active', $2), ($1, 'f2', 'revoked', $2)`, employeeId, expiresAt)
+		var revokedAt = time.Now().Add(-time.Minute).Truncate(time.Microsecond)
+		db.MustExec("UPDATE refresh_token SET revoked_at = $1 WHERE token_hash = 'revoked'", revokedAt)
+
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		var now = time.Now().Truncate(time.Microsecond)
+		a.Nil(Repository.RevokeRefreshTokensTx(tx, employeeId, now), "RevokeRefreshTokensTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		var active int
+		a.Nil(db.Get(&active, "SELECT COUNT(*) FROM refresh_token WHERE revoked_at IS NULL"))
+		a.Equal(0, active)
+		var kept time.Time
+		a.Nil(db.Get(&kept, "SELECT revoked_at FROM refresh_token WHERE token_hash = 'revoked'"))
+		a.True(revokedAt.Equal(kept), "already revoked token keeps its revocation time")
+	})
+
+	clearDatabase()
+}
@@ -3,8 +3,10 @@ package tests
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/common"
 	"github.com/zhedevops/idm/inner/employee"
 	"testing"
+	"time"
 )
 
 func TestEmployeeRepository(t *testing.T) {
@@ -13,10 +15,13 @@ func TestEmployeeRepository(t *testing.T) {
 	a.Nil(err, "expected error to be nil")
 	err = fixtureDb.CreateEmployeeTable()
 	a.Nil(err, "expected error to be nil")
+	err = fixtureDb.CreateEmployeeHistoryTable()
+	a.Nil(err, "expected error to be nil")
 	db := fixtureDb.testDb
 
 	var clearDatabase = func() {
 		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM employee_history")
 	}
 	defer func() {
 		if r := recover(); r != nil {
@@ -91,5 +96,80 @@ func TestEmployeeRepository(t *testing.T) {
 		a.Nil(errTx, "tx.Commit: expected error to be nil")
 	})
 
+	t.Run("Conditional Update and DeleteByIdVersion", func(t *testing.T) {
+		var id = fixture.Employee("Mary Major")
+		var entity = employee.Entity{Id: id, Name: "Mary Minor", Version: 1}
+		var err = Repository.Update(&entity)
+		a.Nil(err, "expected error to be nil")
+		a.Equal(int64(2), entity.Version)
+		a.Equal("Mary Minor", entity.Name)
+
+		var stale = employee.Entity{Id: id, Name: "Mary Stale", Version: 1}
+		err = Repository.Update(&stale)
+		a.ErrorAs(err, &common.ConflictError{})
+		_, err = Repository.DeleteByIdVersion(id, 1)
+		a.ErrorAs(err, &common.ConflictError{})
+		count, err := Repository.DeleteByIdVersion(id, 2)
+		a.Nil(err, "expected error to be nil")
+		a.Equal(int64(1), count)
+		_, err = Repository.DeleteByIdVersion(id, 2)
+		a.ErrorAs(err, &common.NotFoundError{})
+	})
+
+	t.Run("History and FindByIdAsOf", func(t *testing.T) {
+		var id = fixture.Employee("Jane Doe")
+		var created = time.Now()
+		db.MustExec("UPDATE employee SET name = 'Jane Smith' WHERE id = $1", id)
+		var history, err = Repository.FindHistory(id)
+		a.Nil(err, "expected error to be nil")
+		a.Len(history, 2, "expected insert and update versions")
+		a.Equal("INSERT", history[0].Operation)
+		a.Equal("UPDATE", history[1].Operation)
+
+		version, err := Repository.FindByIdAsOf(id, created)
+		a.Nil(err, "expected error to be nil")
+		a.Equal(history[0].HistoryId, version.HistoryId)
+		_, err = Repository.DeleteById(id)
+		a.Nil(err, "expected error to be nil")
+		version, err = Repository.FindByIdAsOf(id, time.Now())
+		a.Nil(err, "expected error to be nil")
+		a.Equal("DELETE", version.Operation)
+	})
+
+	t.Run("Find reporting line and stop on cycle", func(t *testing.T) {
+		var cto = fixture.Employee("Chief Officer")
+		var lead = fixture.Employee("Team Lead")
+		var dev = fixture.Employee("Developer")
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.LockManagersTx(tx), "LockManagersTx: expected error to be nil")
+		_, err = Repository.SetManagerTx(tx, lead, cto)
+		a.Nil(err, "SetManagerTx: expected error to be nil")
+		_, err = Repository.SetManagerTx(tx, dev, lead)
+		a.Nil(err, "SetManagerTx: expected error to be nil")
+		report, err := Repository.IsReportTx(tx, cto, dev)
+		a.Nil(err, "IsReportTx: expected error to be nil")
+		a.True(report)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		managers, err := Repository.FindManagers(dev, 10)
+		a.Nil(err, "expected error to be nil")
+		a.Len(managers, 2)
+		a.Equal(lead, managers[0].Id)
+		a.Equal(2, managers[1].Depth)
+		reports, err := Repository.FindReports(cto, 1)
+		a.Nil(err, "expected error to be nil")
+		a.Len(reports, 1, "expected only direct reports at depth 1")
+
+		// цикл, записанный в обход сервиса, не зацикливает запросы
+		db.MustExec("UPDATE employee SET manager_id = $1 WHERE id = $2", dev, cto)
+		managers, err = Repository.FindManagers(dev, 10)
+		a.Nil(err, "expected error to be nil")
+		a.Len(managers, 2)
+		reports, err = Repository.FindReports(cto, 10)
+		a.Nil(err, "expected error to be nil")
+		a.Len(reports, 2)
+	})
+
 	clearDatabase()
 }
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/role"
+	"testing"
+)
+
+func TestExportRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeRoleTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM employee_role")
+		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM role")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var employeeRepository = employee.NewRepository(db)
+	var roleRepository = role.NewRepository(db)
+	var johnId = NewFixtureEmployee(employeeRepository).Employee("John Doe")
+	var janeId = NewFixtureEmployee(employeeRepository).Employee("Jane Doe")
+	var adminId = NewFixtureRole(roleRepository).Role("Admin")
+	var auditorId = NewFixtureRole(roleRepository).Role("Auditor")
+
+	var assignments = assignment.NewRepository(db)
+	tx, err := assignments.BeginTransaction()
+	a.Nil(err, "BeginTransaction: expected error to be nil")
+	a.Nil(assignments.CreateTx(tx, johnId, adminId), "CreateTx: expected error to be nil")
+	a.Nil(assignments.CreateTx(tx, johnId, auditorId), "CreateTx: expected error to be nil")
+	a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+	t.Run("Export employees with role names", func(t *testing.T) {
+		var rows []employee.ExportEntity
+		err := employeeRepository.Export(nil, func(e employee.ExportEntity) error {
+			rows = append(rows, e)
+			return nil
+		})
+		a.Nil(err, "expected error to be nil")
+		a.Len(rows, 2)
+		a.Equal([]string{"Admin", "Auditor"}, []string(rows[0].Roles))
+		a.Empty(rows[1].Roles)
+	})
+
+	t.Run("Export employees filtered by ids", func(t *testing.T) {
+		var ids []int64
+		err := employeeRepository.Export([]int64{janeId}, func(e employee.ExportEntity) error {
+			ids = append(ids, e.Id)
+			return nil
+		})
+		a.Nil(err, "expected error to be nil")
+		a.Equal([]int64{janeId}, ids)
+	})
+
+	t.Run("Export roles with member names", func(t *testing.T) {
+		var rows []role.ExportEntity
+		err := roleRepository.Export([]int64{adminId}, func(e role.ExportEntity) error {
+			rows = append(rows, e)
+			return nil
+		})
+		a.Nil(err, "expected error to be nil")
+		a.Len(rows, 1)
+		a.Equal([]string{"John Doe"}, []string(rows[0].Members))
+	})
+
+	clearDatabase()
+}
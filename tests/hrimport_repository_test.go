@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/hrimport"
+	"testing"
+)
+
+func TestHrImportRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM employee")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = hrimport.NewRepository(db)
+
+	t.Run("Failed row rolls back to savepoint and batch continues", func(t *testing.T) {
+		var manualId = NewFixtureEmployee(employee.NewRepository(db)).Employee("Bob Smith")
+		var it = employee.Attributes{Department: "IT", Title: "Engineer"}
+
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		johnId, err := Repository.CreateTx(tx, "E1", "John Doe", it)
+		a.Nil(err, "CreateTx: expected error to be nil")
+		// повторный табельный номер нарушает уникальность, но не ломает транзакцию пачки
+		_, err = Repository.CreateTx(tx, "E1", "John Twice", it)
+		a.NotNil(err, "CreateTx: expected unique violation")
+		a.Nil(Repository.UpdateTx(tx, manualId, "E2", "Bob Smith", it), "UpdateTx: expected error to be nil")
+		a.Nil(Repository.TerminateTx(tx, johnId), "TerminateTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		found, err := Repository.FindAll()
+		a.Nil(err, "FindAll: expected error to be nil")
+		a.Len(found, 2)
+		a.Equal(manualId, found[0].Id)
+		a.Equal("E2", found[0].ExternalId.String)
+		a.Equal(it, found[0].Attributes)
+		a.False(found[0].TerminatedAt.Valid)
+		// уволенный сотрудник остаётся в таблице
+		a.Equal(johnId, found[1].Id)
+		a.True(found[1].TerminatedAt.Valid)
+	})
+
+	t.Run("Update rehires terminated employee", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		id, err := Repository.CreateTx(tx, "E3", "Jane Roe", employee.Attributes{})
+		a.Nil(err, "CreateTx: expected error to be nil")
+		a.Nil(Repository.TerminateTx(tx, id), "TerminateTx: expected error to be nil")
+		a.Nil(Repository.UpdateTx(tx, id, "E3", "Jane Roe", employee.Attributes{}), "UpdateTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		found, err := employee.NewRepository(db).FindById(id)
+		a.Nil(err, "FindById: expected error to be nil")
+		a.False(found.TerminatedAt.Valid)
+	})
+
+	clearDatabase()
+}
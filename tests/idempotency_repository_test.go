@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/idempotency"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateIdempotencyKeyTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM idempotency_key")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = idempotency.NewRepository(db)
+	var now = time.Now()
+
+	t.Run("Reserve key and save response", func(t *testing.T) {
+		var entity = idempotency.Entity{Owner: "employee:7", Key: "key-1", RequestHash: "hash", ExpiresAt: now.Add(time.Hour), LockedUntil: now.Add(time.Minute)}
+		reserved, err := Repository.Reserve(&entity)
+		a.Nil(err, "Reserve: expected error to be nil")
+		a.True(reserved)
+		reserved, err = Repository.Reserve(&entity)
+		a.Nil(err, "Reserve: expected error to be nil")
+		a.False(reserved, "key in progress must stay reserved")
+
+		entity.StatusCode = 201
+		entity.ContentType = "application/json"
+		entity.ResponseBody = []byte(`{"data":1}`)
+		a.Nil(Repository.SaveResponse(&entity), "SaveResponse: expected error to be nil")
+		stored, err := Repository.FindByKey("employee:7", "key-1")
+		a.Nil(err, "FindByKey: expected error to be nil")
+		a.Equal(201, stored.StatusCode)
+		a.Equal(`{"data":1}`, string(stored.ResponseBody))
+	})
+
+	t.Run("Completed key is kept after lease", func(t *testing.T) {
+		db.MustExec("UPDATE idempotency_key SET locked_until = NOW() - INTERVAL '1 second' WHERE key = 'key-1'")
+		var entity = idempotency.Entity{Owner: "employee:7", Key: "key-1", RequestHash: "hash", ExpiresAt: now.Add(time.Hour), LockedUntil: now.Add(time.Minute)}
+		reserved, err := Repository.Reserve(&entity)
+		a.Nil(err, "Reserve: expected error to be nil")
+		a.False(reserved, "completed key must be replayed until it expires")
+	})
+
+	t.Run("Abandoned key is reclaimed after lease", func(t *testing.T) {
+		var entity = idempotency.Entity{Owner: "employee:7", Key: "key-2", RequestHash: "hash", ExpiresAt: now.Add(time.Hour), LockedUntil: now.Add(-time.Second)}
+		reserved, err := Repository.Reserve(&entity)
+		a.Nil(err, "Reserve: expected error to be nil")
+		a.True(reserved)
+
+		entity.RequestHash = "retry"
+		entity.LockedUntil = now.Add(time.Minute)
+		reserved, err = Repository.Reserve(&entity)
+		a.Nil(err, "Reserve: expected error to be nil")
+		a.True(reserved, "key without response must be reclaimed after lease")
+		stored, err := Repository.FindByKey("employee:7", "key-2")
+		a.Nil(err, "FindByKey: expected error to be nil")
+		a.Equal("retry", stored.RequestHash)
+		a.Equal(0, stored.StatusCode)
+	})
+
+	t.Run("Same key of another owner is reserved separately", func(t *testing.T) {
+		var entity = idempotency.Entity{Owner: "apikey:3", Key: "key-1", RequestHash: "hash", ExpiresAt: now.Add(time.Hour), LockedUntil: now.Add(time.Minute)}
+		reserved, err := Repository.Reserve(&entity)
+		a.Nil(err, "Reserve: expected error to be nil")
+		a.True(reserved)
+		stored, err := Repository.FindByKey("employee:7", "key-1")
+		a.Nil(err, "FindByKey: expected error to be nil")
+		a.Equal(201, stored.StatusCode, "response of the first owner must stay intact")
+		a.Nil(Repository.Release("apikey:3", "key-1"), "Release: expected error to be nil")
+	})
+
+	t.Run("Release and delete expired keys", func(t *testing.T) {
+		a.Nil(Repository.Release("employee:7", "key-2"), "Release: expected error to be nil")
+		_, err := Repository.FindByKey("employee:7", "key-2")
+		a.ErrorIs(err, sql.ErrNoRows)
+
+		db.MustExec("UPDATE idempotency_key SET expires_at = NOW() - INTERVAL '1 second' WHERE key = 'key-1'")
+		deleted, err := Repository.DeleteExpired()
+		a.Nil(err, "DeleteExpired: expected error to be nil")
+		a.Equal(int64(1), deleted)
+	})
+
+	clearDatabase()
+}
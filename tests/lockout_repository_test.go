@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/lockout"
+	"testing"
+	"time"
+)
+
+func TestLockoutRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateLoginLockoutTables(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM audit_event")
+		db.MustExec("DELETE FROM login_lockout")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = lockout.NewRepository(db)
+	var now = time.Now().Truncate(time.Microsecond)
+
+	t.Run("Count failures within window and lock", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		for i := 1; i <= 2; i++ {
+			found, err := Repository.AddFailureTx(tx, lockout.KindIp, "10.0.0.1", now, now.Add(-time.Minute), now.Add(-time.Hour))
+			a.Nil(err, "AddFailureTx: expected error to be nil")
+			a.Equal(i, found.Failures)
+		}
+		a.Nil(Repository.LockTx(tx, lockout.KindIp, "10.0.0.1", now.Add(time.Minute)), "LockTx: expected error to be nil")
+		// следующая попытка через час: окно и число блокировок обнулились
+		found, err := Repository.AddFailureTx(tx, lockout.KindIp, "10.0.0.1", now.Add(2*time.Hour), now.Add(time.Hour), now.Add(time.Hour))
+		a.Nil(err, "AddFailureTx: expected error to be nil")
+		a.Equal(1, found.Failures)
+		a.Equal(0, found.Lockouts)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		locked, err := Repository.FindLocked(now)
+		a.Nil(err, "FindLocked: expected error to be nil")
+		a.Len(locked, 1)
+		a.Equal("10.0.0.1", locked[0].Key)
+	})
+
+	t.Run("Unlock and record audit", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		unlocked, err := Repository.DeleteLockedTx(tx, lockout.KindIp, "10.0.0.1", now)
+		a.Nil(err, "DeleteLockedTx: expected error to be nil")
+		a.True(unlocked)
+		unlocked, err = Repository.DeleteLockedTx(tx, lockout.KindIp, "10.0.0.1", now)
+		a.Nil(err, "DeleteLockedTx: expected error to be nil")
+		a.False(unlocked)
+		var audit = lockout.AuditEntity{Kind: lockout.KindIp, Key: "10.0.0.1", Event: lockout.AuditUnlocked, OccurredAt: now}
+		a.Nil(Repository.CreateAuditTx(tx, audit), "CreateAuditTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		_, err = Repository.Find(lockout.KindIp, "10.0.0.1")
+		a.ErrorIs(err, sql.ErrNoRows)
+		a.Nil(Repository.Delete(lockout.KindEmployee, "1"), "Delete: expected error to be nil")
+	})
+
+	clearDatabase()
+}
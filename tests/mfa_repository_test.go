@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/mfa"
+	"testing"
+	"time"
+)
+
+func TestMfaRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateMfaTables(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM mfa_recovery_code")
+		db.MustExec("DELETE FROM mfa_totp")
+		db.MustExec("DELETE FROM employee")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = mfa.NewRepository(db)
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+
+	t.Run("Enroll, confirm and use recovery code", func(t *testing.T) {
+		var now = time.Now()
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		name, err := Repository.FindEmployeeNameTx(tx, employeeId)
+		a.Nil(err, "FindEmployeeNameTx: expected error to be nil")
+		a.Equal("John Doe", name)
+		var totp = mfa.TotpEntity{EmployeeId: employeeId, Secret: "SECRET"}
+		a.Nil(Repository.SaveTotpTx(tx, &totp), "SaveTotpTx: expected error to be nil")
+		a.False(totp.ConfirmedAt.Valid)
+		a.Nil(Repository.ConfirmTotpTx(tx, employeeId, now, 100), "ConfirmTotpTx: expected error to be nil")
+		a.Nil(Repository.ReplaceRecoveryCodesTx(tx, employeeId, []string{"h1", "h2"}), "ReplaceRecoveryCodesTx: expected error to be nil")
+		used, err := Repository.UseRecoveryCodeTx(tx, employeeId, "h1", now)
+		a.Nil(err, "UseRecoveryCodeTx: expected error to be nil")
+		a.True(used)
+		used, err = Repository.UseRecoveryCodeTx(tx, employeeId, "h1", now)
+		a.Nil(err, "UseRecoveryCodeTx: expected error to be nil")
+		a.False(used)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		found, err := Repository.FindTotp(employeeId)
+		a.Nil(err, "expected error to be nil")
+		a.True(found.ConfirmedAt.Valid)
+		a.Equal(int64(100), found.LastUsedStep)
+		count, err := Repository.CountRecoveryCodes(employeeId)
+		a.Nil(err, "expected error to be nil")
+		a.Equal(1, count)
+	})
+
+	t.Run("Reset mfa", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		deleted, err := Repository.DeleteTx(tx, employeeId)
+		a.Nil(err, "DeleteTx: expected error to be nil")
+		a.True(deleted)
+		deleted, err = Repository.DeleteTx(tx, employeeId)
+		a.Nil(err, "DeleteTx: expected error to be nil")
+		a.False(deleted)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		_, err = Repository.FindTotp(employeeId)
+		a.ErrorIs(err, sql.ErrNoRows)
+	})
+
+	clearDatabase()
+}
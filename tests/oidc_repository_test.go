@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/oidc"
+	"testing"
+	"time"
+)
+
+func TestOidcRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateOidcTables(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM oidc_authorization_code")
+		db.MustExec("DELETE FROM oidc_client")
+		db.MustExec("DELETE FROM employee")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = oidc.NewRepository(db)
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+
+	t.Run("Create and find client", func(t *testing.T) {
+		var client = oidc.ClientEntity{
+			Id:           "wiki",
+			Name:         "Wiki",
+			SecretHash:   sql.NullString{String: "hash", Valid: true},
+			RedirectUris: pq.StringArray{"https://wiki.example.com/callback"},
+		}
+		a.Nil(Repository.CreateClient(&client), "CreateClient: expected error to be nil")
+		a.False(client.CreatedAt.IsZero())
+
+		found, err := Repository.FindClient("wiki")
+		a.Nil(err, "FindClient: expected error to be nil")
+		a.Equal("hash", found.SecretHash.String)
+		a.Equal([]string{"https://wiki.example.com/callback"}, []string(found.RedirectUris))
+		clients, err := Repository.FindClients()
+		a.Nil(err, "FindClients: expected error to be nil")
+		a.Len(clients, 1)
+
+		_, err = Repository.FindClient("missing")
+		a.ErrorIs(err, sql.ErrNoRows)
+	})
+
+	t.Run("Find employee attributes", func(t *testing.T) {
+		db.MustExec("UPDATE employee SET department = 'IT', title = 'Engineer' WHERE id = $1", employeeId)
+		found, err := Repository.FindEmployee(employeeId)
+		a.Nil(err, "FindEmployee: expected error to be nil")
+		a.Equal("John Doe", found.Name)
+		a.Equal("IT", found.Department)
+		a.Equal("Engineer", found.Title)
+	})
+
+	t.Run("Authorization code is used once", func(t *testing.T) {
+		var now = time.Now()
+		var code = oidc.CodeEntity{
+			CodeHash:      "code",
+			ClientId:      "wiki",
+			EmployeeId:    employeeId,
+			RedirectUri:   "https://wiki.example.com/callback",
+			Scope:         "openid roles",
+			CodeChallenge: "challenge",
+			Roles:         pq.StringArray{"admin"},
+			Amr:           pq.StringArray{"pwd"},
+			AuthTime:      now,
+			ExpiresAt:     now.Add(time.Minute),
+		}
+		a.Nil(Repository.CreateCode(&code), "CreateCode: expected error to be nil")
+
+		used, err := Repository.UseCode("code", now)
+		a.Nil(err, "UseCode: expected error to be nil")
+		a.True(used.UsedAt.Valid)
+		a.Equal([]string{"admin"}, []string(used.Roles))
+		_, err = Repository.UseCode("code", now)
+		a.ErrorIs(err, sql.ErrNoRows)
+	})
+
+	t.Run("Delete client with its codes", func(t *testing.T) {
+		deleted, err := Repository.DeleteClient("wiki")
+		a.Nil(err, "DeleteClient: expected error to be nil")
+		a.True(deleted)
+		deleted, err = Repository.DeleteClient("wiki")
+		a.Nil(err, "DeleteClient: expected error to be nil")
+		a.False(deleted)
+
+		var codes int
+		a.Nil(db.Get(&codes, "SELECT COUNT(*) FROM oidc_authorization_code"), "expected error to be nil")
+		a.Equal(0, codes)
+	})
+
+	clearDatabase()
+}
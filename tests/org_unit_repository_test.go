@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/orgunit"
+	"testing"
+)
+
+func TestOrgUnitRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM org_unit")
+		db.MustExec("DELETE FROM employee")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = orgunit.NewRepository(db)
+	var headId = NewFixtureEmployee(employee.NewRepository(db)).Employee("Jane Doe")
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+
+	var company = orgunit.Entity{Name: "Company", HeadId: sql.NullInt64{Int64: headId, Valid: true}}
+	var engineering = orgunit.Entity{Name: "Engineering"}
+	var backend = orgunit.Entity{Name: "Backend"}
+	t.Run("Create unit tree", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.CreateTx(tx, &company), "CreateTx: expected error to be nil")
+		engineering.ParentId = sql.NullInt64{Int64: company.Id, Valid: true}
+		a.Nil(Repository.CreateTx(tx, &engineering), "CreateTx: expected error to be nil")
+		backend.ParentId = sql.NullInt64{Int64: engineering.Id, Valid: true}
+		a.Nil(Repository.CreateTx(tx, &backend), "CreateTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		units, err := Repository.FindAll()
+		a.Nil(err, "expected error to be nil")
+		a.Len(units, 3)
+		found, err := Repository.FindById(company.Id)
+		a.Nil(err, "expected error to be nil")
+		a.Equal(headId, found.HeadId.Int64)
+	})
+
+	t.Run("Detect descendants and duplicate names", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		descendant, err := Repository.IsDescendantTx(tx, company.Id, backend.Id)
+		a.Nil(err, "IsDescendantTx: expected error to be nil")
+		a.True(descendant)
+		descendant, err = Repository.IsDescendantTx(tx, backend.Id, company.Id)
+		a.Nil(err, "IsDescendantTx: expected error to be nil")
+		a.False(descendant)
+		exists, err := Repository.FindByNameTx(tx, company.Id, "Engineering", 0)
+		a.Nil(err, "FindByNameTx: expected error to be nil")
+		a.True(exists)
+		exists, err = Repository.FindByNameTx(tx, company.Id, "Engineering", engineering.Id)
+		a.Nil(err, "FindByNameTx: expected error to be nil")
+		a.False(exists)
+		children, err := Repository.CountChildrenTx(tx, company.Id)
+		a.Nil(err, "CountChildrenTx: expected error to be nil")
+		a.Equal(1, children)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	t.Run("Find members recursively", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		count, err := Repository.SetMemberTx(tx, employeeId, backend.Id)
+		a.Nil(err, "SetMemberTx: expected error to be nil")
+		a.Equal(int64(1), count)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		members, err := Repository.FindMembers(engineering.Id, false)
+		a.Nil(err, "expected error to be nil")
+		a.Empty(members)
+		members, err = Repository.FindMembers(company.Id, true)
+		a.Nil(err, "expected error to be nil")
+		a.Len(members, 1)
+		a.Equal(backend.Id, members[0].OrgUnitId)
+	})
+
+	t.Run("Delete unit leaves members without unit", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		count, err := Repository.DeleteTx(tx, backend.Id)
+		a.Nil(err, "DeleteTx: expected error to be nil")
+		a.Equal(int64(1), count)
+		unitId, err := Repository.FindMemberUnitTx(tx, employeeId)
+		a.Nil(err, "FindMemberUnitTx: expected error to be nil")
+		a.Equal(int64(0), unitId)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	clearDatabase()
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestRateLimitRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateRateLimitTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM rate_limit_bucket")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = ratelimit.NewRepository(db)
+	var limit = ratelimit.Limit{Rate: 1, Burst: 2}
+	var now = time.Now().Truncate(time.Microsecond)
+
+	t.Run("Spend and refill bucket", func(t *testing.T) {
+		tokens, allowed, err := Repository.Take("rule|ip:10.0.0.1", limit, now)
+		a.Nil(err, "Take: expected error to be nil")
+		a.True(allowed)
+		a.Equal(1.0, tokens)
+		_, allowed, err = Repository.Take("rule|ip:10.0.0.1", limit, now)
+		a.Nil(err, "Take: expected error to be nil")
+		a.True(allowed)
+		tokens, allowed, err = Repository.Take("rule|ip:10.0.0.1", limit, now)
+		a.Nil(err, "Take: expected error to be nil")
+		a.False(allowed)
+		a.Equal(0.0, tokens)
+
+		// через полсекунды набралось полтокена — всё ещё мало
+		_, allowed, err = Repository.Take("rule|ip:10.0.0.1", limit, now.Add(500*time.Millisecond))
+		a.Nil(err, "Take: expected error to be nil")
+		a.False(allowed)
+		tokens, allowed, err = Repository.Take("rule|ip:10.0.0.1", limit, now.Add(time.Second))
+		a.Nil(err, "Take: expected error to be nil")
+		a.True(allowed)
+		a.InDelta(0.0, tokens, 1e-6)
+
+		_, allowed, err = Repository.Take("rule|ip:10.0.0.2", limit, now)
+		a.Nil(err, "Take: expected error to be nil")
+		a.True(allowed, "other client has its own bucket")
+	})
+
+	t.Run("Delete idle buckets", func(t *testing.T) {
+		deleted, err := Repository.DeleteIdle(now.Add(500 * time.Millisecond))
+		a.Nil(err, "DeleteIdle: expected error to be nil")
+		a.Equal(int64(1), deleted)
+	})
+
+	clearDatabase()
+}
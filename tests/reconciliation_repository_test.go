@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/reconciliation"
+	"github.com/zhedevops/idm/inner/role"
+	"testing"
+	"time"
+)
+
+func TestReconciliationRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateReconciliationTables(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM reconciliation_report")
+		db.MustExec("DELETE FROM employee_role")
+		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM role")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = reconciliation.NewRepository(db)
+
+	t.Run("Find employees with and without roles", func(t *testing.T) {
+		var johnId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+		NewFixtureEmployee(employee.NewRepository(db)).Employee("Jane Roe")
+		var roleId = NewFixtureRole(role.NewRepository(db)).Role("Admin")
+		var assignments = assignment.NewRepository(db)
+		tx, err := assignments.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(assignments.CreateTx(tx, johnId, roleId), "CreateTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		expected, err := Repository.FindExpected()
+		a.Nil(err, "expected error to be nil")
+		a.Len(expected, 2)
+		a.Equal("Admin", expected[0].RoleName.String)
+		a.False(expected[1].RoleId.Valid)
+	})
+
+	t.Run("Create report with discrepancies and find it", func(t *testing.T) {
+		var now = time.Now().UTC().Truncate(time.Second)
+		var report = reconciliation.ReportEntity{Connector: "ldap", StartedAt: now, FinishedAt: now, Accounts: 3, Discrepancies: 2}
+		var discrepancies = []reconciliation.DiscrepancyEntity{
+			{Kind: reconciliation.KindOrphanAccount, AccountName: "Manual", Action: "report", Outcome: reconciliation.OutcomeReported},
+			{Kind: reconciliation.KindMissingAccount, EmployeeId: 2, Action: "create", Outcome: reconciliation.OutcomeRemediated},
+		}
+		a.Nil(Repository.CreateReport(&report, discrepancies), "CreateReport: expected error to be nil")
+		a.NotZero(report.Id)
+
+		found, err := Repository.FindReportById(report.Id)
+		a.Nil(err, "expected error to be nil")
+		a.Equal("ldap", found.Connector)
+		saved, err := Repository.FindDiscrepancies(report.Id)
+		a.Nil(err, "expected error to be nil")
+		a.Len(saved, 2)
+		a.Equal(discrepancies[1].Id, saved[1].Id)
+	})
+
+	clearDatabase()
+}
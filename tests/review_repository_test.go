@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/review"
+	"github.com/zhedevops/idm/inner/role"
+	"testing"
+	"time"
+)
+
+func TestReviewRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateReviewTables(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM review_campaign")
+		db.MustExec("DELETE FROM employee_role")
+		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM role")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = review.NewRepository(db)
+	var managerId = NewFixtureEmployee(employee.NewRepository(db)).Employee("Jane Doe")
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+	var roleId = NewFixtureRole(role.NewRepository(db)).Role("Payment Approver")
+	db.MustExec("UPDATE employee SET manager_id = $1 WHERE id = $2", managerId, employeeId)
+
+	var assignments = assignment.NewRepository(db)
+	tx, err := assignments.BeginTransaction()
+	a.Nil(err, "BeginTransaction: expected error to be nil")
+	a.Nil(assignments.CreateTx(tx, employeeId, roleId), "CreateTx: expected error to be nil")
+	a.Nil(assignments.CreateTx(tx, managerId, roleId), "CreateTx: expected error to be nil")
+	a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+	var campaign = review.CampaignEntity{
+		Name:         "Payments",
+		ScopeType:    review.ScopeRole,
+		ScopeId:      sql.NullInt64{Int64: roleId, Valid: true},
+		ReviewerType: review.ReviewerManager,
+		CreatedBy:    managerId,
+		Deadline:     time.Now().Add(time.Hour),
+	}
+	t.Run("Snapshot assignments of role with manager as reviewer", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.CreateCampaignTx(tx, &campaign), "CreateCampaignTx: expected error to be nil")
+		a.Equal(review.StatusActive, campaign.Status)
+		count, err := Repository.SnapshotTx(tx, campaign)
+		a.Nil(err, "SnapshotTx: expected error to be nil")
+		a.Equal(int64(2), count)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		items, err := Repository.FindItems(campaign.Id, managerId)
+		a.Nil(err, "expected error to be nil")
+		// у руководителя самого нет руководителя, его назначение пересматривает автор кампании — тоже он
+		a.Len(items, 2)
+		a.Equal("Payment Approver", items[0].RoleName)
+	})
+
+	t.Run("Revoke item and count progress", func(t *testing.T) {
+		items, err := Repository.FindItems(campaign.Id, 0)
+		a.Nil(err, "expected error to be nil")
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		item, status, err := Repository.FindItemTx(tx, campaign.Id, items[0].Id)
+		a.Nil(err, "FindItemTx: expected error to be nil")
+		a.Equal(review.StatusActive, status)
+		a.Nil(Repository.DecideTx(tx, item.Id, review.DecisionRevoked, "no longer needed"), "DecideTx: expected error to be nil")
+		deleted, err := Repository.RevokeTx(tx, item.EmployeeId, item.RoleId)
+		a.Nil(err, "RevokeTx: expected error to be nil")
+		a.Equal(int64(1), deleted)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		stats, err := Repository.FindStats(campaign.Id)
+		a.Nil(err, "expected error to be nil")
+		a.ElementsMatch([]review.StatsEntity{
+			{Decision: review.DecisionPending, Count: 1},
+			{Decision: review.DecisionRevoked, Count: 1},
+		}, stats)
+	})
+
+	t.Run("Find overdue campaign and close it", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		overdue, err := Repository.FindOverdueTx(tx, time.Now().Add(2*time.Hour))
+		a.Nil(err, "FindOverdueTx: expected error to be nil")
+		a.Len(overdue, 1)
+		pending, err := Repository.FindPendingItemsTx(tx, campaign.Id)
+		a.Nil(err, "FindPendingItemsTx: expected error to be nil")
+		a.Len(pending, 1)
+		a.Nil(Repository.CloseCampaignTx(tx, campaign.Id), "CloseCampaignTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		found, err := Repository.FindCampaignById(campaign.Id)
+		a.Nil(err, "expected error to be nil")
+		a.Equal(review.StatusClosed, found.Status)
+		a.True(found.ClosedAt.Valid)
+	})
+
+	clearDatabase()
+}
@@ -3,6 +3,7 @@ package tests
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/common"
 	"github.com/zhedevops/idm/inner/role"
 	"testing"
 )
@@ -73,5 +74,23 @@ func TestRoleRepository(t *testing.T) {
 		a.Equal(count, int64(1), "expected count to be 1")
 	})
 
+	t.Run("CreateTx and UpdateTx roll back together", func(t *testing.T) {
+		tx, err := db.Beginx()
+		a.Nil(err, "Beginx: expected error to be nil")
+		var e = role.Entity{Name: "Auditor"}
+		a.Nil(Repository.CreateTx(tx, &e), "CreateTx: expected error to be nil")
+		a.NotZero(e.Id)
+		e.Name = "Senior Auditor"
+		a.Nil(Repository.UpdateTx(tx, &e), "UpdateTx: expected error to be nil")
+		a.Equal(int64(2), e.Version)
+		e.Version = 1
+		err = Repository.UpdateTx(tx, &e)
+		a.ErrorAs(err, &common.ConflictError{})
+		a.Nil(tx.Rollback(), "tx.Rollback: expected error to be nil")
+
+		_, err = Repository.FindById(e.Id)
+		a.NotNil(err, "FindById: expected rolled back role to be absent")
+	})
+
 	clearDatabase()
 }
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/signingkey"
+	"testing"
+	"time"
+)
+
+func TestSigningKeyRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateSigningKeyTable(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM signing_key")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = signingkey.NewRepository(db)
+	var now = time.Now().Truncate(time.Microsecond)
+
+	t.Run("Rotate keys", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(Repository.LockTx(tx), "LockTx: expected error to be nil")
+		var active = signingkey.Entity{
+			Kid: "k1", Algorithm: "ES256", State: signingkey.StateActive, PublicKey: []byte{1}, PrivateKey: []byte{2},
+			ActivatedAt: sql.NullTime{Time: now, Valid: true},
+		}
+		var next = signingkey.Entity{Kid: "k2", Algorithm: "ES256", State: signingkey.StateNext, PublicKey: []byte{3}, PrivateKey: []byte{4}}
+		a.Nil(Repository.CreateTx(tx, &active), "CreateTx: expected error to be nil")
+		a.Nil(Repository.CreateTx(tx, &next), "CreateTx: expected error to be nil")
+		a.False(active.CreatedAt.IsZero())
+
+		keys, err := Repository.FindCurrentTx(tx)
+		a.Nil(err, "FindCurrentTx: expected error to be nil")
+		a.Len(keys, 2)
+		a.Nil(Repository.RetireTx(tx, "k1", now), "RetireTx: expected error to be nil")
+		a.Nil(Repository.ActivateTx(tx, "k2", now), "ActivateTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		keys, err = Repository.FindAll()
+		a.Nil(err, "FindAll: expected error to be nil")
+		a.Len(keys, 2)
+		a.Equal(signingkey.StateRetired, keys[0].State)
+		a.True(keys[0].RetiredAt.Valid)
+		a.Equal(signingkey.StateActive, keys[1].State)
+	})
+
+	t.Run("Only one active key", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		var duplicate = signingkey.Entity{Kid: "k3", Algorithm: "ES256", State: signingkey.StateActive, PublicKey: []byte{5}, PrivateKey: []byte{6}}
+		a.NotNil(Repository.CreateTx(tx, &duplicate), "CreateTx: expected unique violation")
+		a.Nil(tx.Rollback(), "tx.Rollback: expected error to be nil")
+	})
+
+	t.Run("Retired keys leave JWKS after grace period", func(t *testing.T) {
+		keys, err := Repository.FindPublished(now.Add(-time.Hour))
+		a.Nil(err, "FindPublished: expected error to be nil")
+		a.Len(keys, 2)
+		keys, err = Repository.FindPublished(now)
+		a.Nil(err, "FindPublished: expected error to be nil")
+		a.Len(keys, 1)
+
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		deleted, err := Repository.DeleteRetiredTx(tx, now)
+		a.Nil(err, "DeleteRetiredTx: expected error to be nil")
+		a.Equal(int64(1), deleted)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	t.Run("Revoke key", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		key, err := Repository.FindTx(tx, "k2")
+		a.Nil(err, "FindTx: expected error to be nil")
+		a.Equal(signingkey.StateActive, key.State)
+		a.Nil(Repository.DeleteTx(tx, "k2"), "DeleteTx: expected error to be nil")
+		_, err = Repository.FindTx(tx, "k2")
+		a.ErrorIs(err, sql.ErrNoRows)
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+	})
+
+	clearDatabase()
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zhedevops/idm/inner/assignment"
+	"github.com/zhedevops/idm/inner/employee"
+	"github.com/zhedevops/idm/inner/role"
+	"github.com/zhedevops/idm/inner/sod"
+	"testing"
+)
+
+func TestSodRepository(t *testing.T) {
+	a := assert.New(t)
+	fixtureDb, err := NewFixtureDb()
+	a.Nil(err, "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateEmployeeRoleTable(), "expected error to be nil")
+	a.Nil(fixtureDb.CreateSodTables(), "expected error to be nil")
+	db := fixtureDb.testDb
+
+	var clearDatabase = func() {
+		db.MustExec("DELETE FROM sod_rule")
+		db.MustExec("DELETE FROM employee_role")
+		db.MustExec("DELETE FROM employee")
+		db.MustExec("DELETE FROM role")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			clearDatabase()
+		}
+	}()
+	var Repository = sod.NewRepository(db)
+	var employeeId = NewFixtureEmployee(employee.NewRepository(db)).Employee("John Doe")
+	var creatorId = NewFixtureRole(role.NewRepository(db)).Role("Payment Creator")
+	var approverId = NewFixtureRole(role.NewRepository(db)).Role("Payment Approver")
+
+	t.Run("Create rule and find it", func(t *testing.T) {
+		tx, err := Repository.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		var rule = sod.RuleEntity{Name: "Payments", Enforcement: sod.EnforcementReject, RoleIds: []int64{creatorId, approverId}}
+		a.Nil(Repository.CreateRuleTx(tx, &rule), "CreateRuleTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		found, err := Repository.FindRuleById(rule.Id)
+		a.Nil(err, "expected error to be nil")
+		a.Equal([]int64{creatorId, approverId}, []int64(found.RoleIds))
+	})
+
+	t.Run("Find conflict with role already held", func(t *testing.T) {
+		var assignments = assignment.NewRepository(db)
+		tx, err := assignments.BeginTransaction()
+		a.Nil(err, "BeginTransaction: expected error to be nil")
+		a.Nil(assignments.CreateTx(tx, employeeId, creatorId), "CreateTx: expected error to be nil")
+		conflicts, err := Repository.FindConflictsTx(tx, employeeId, approverId)
+		a.Nil(err, "FindConflictsTx: expected error to be nil")
+		a.Len(conflicts, 1)
+		a.Equal("Payment Creator", conflicts[0].RoleName)
+		// роль, которая уже есть у сотрудника, сама с собой не конфликтует
+		conflicts, err = Repository.FindConflictsTx(tx, employeeId, creatorId)
+		a.Nil(err, "FindConflictsTx: expected error to be nil")
+		a.Empty(conflicts)
+		a.Nil(assignments.CreateTx(tx, employeeId, approverId), "CreateTx: expected error to be nil")
+		a.Nil(tx.Commit(), "tx.Commit: expected error to be nil")
+
+		violations, err := Repository.FindViolations()
+		a.Nil(err, "expected error to be nil")
+		a.Len(violations, 1)
+		a.Equal([]string{"Payment Approver", "Payment Creator"}, []string(violations[0].Roles))
+		a.False(violations[0].Excepted)
+	})
+
+	clearDatabase()
+}
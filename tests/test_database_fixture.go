@@ -34,13 +34,32 @@ func NewFixtureDb() (*FixtureDb, error) {
 	return &FixtureDb{testDb}, nil
 }
 
+// CreateEmployeeTable создаёт таблицу сотрудников вместе с таблицей подразделений, так как они ссылаются друг на друга
 func (f *FixtureDb) CreateEmployeeTable() error {
 	query := `CREATE TABLE IF NOT EXISTS employee (
               id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
               name TEXT NOT NULL,
               created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
               updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-          );`
+          );
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS external_id TEXT UNIQUE;
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS terminated_at TIMESTAMPTZ;
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS manager_id BIGINT REFERENCES employee (id) ON DELETE SET NULL;
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS department TEXT NOT NULL DEFAULT '';
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '';
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS employment_type TEXT NOT NULL DEFAULT '';
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS location TEXT NOT NULL DEFAULT '';
+          CREATE TABLE IF NOT EXISTS org_unit (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              name TEXT NOT NULL,
+              parent_id BIGINT REFERENCES org_unit (id),
+              head_id BIGINT REFERENCES employee (id) ON DELETE SET NULL,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          CREATE UNIQUE INDEX IF NOT EXISTS org_unit_parent_name_idx ON org_unit (COALESCE(parent_id, 0), name);
+          ALTER TABLE employee ADD COLUMN IF NOT EXISTS org_unit_id BIGINT REFERENCES org_unit (id) ON DELETE SET NULL;`
 	_, err := f.testDb.Exec(query)
 	if err != nil {
 		return err
@@ -54,6 +73,416 @@ func (f *FixtureDb) CreateRoleTable() error {
               name TEXT NOT NULL,
               created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
               updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          ALTER TABLE role ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+          ALTER TABLE role ADD COLUMN IF NOT EXISTS owner_id BIGINT;`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateEmployeeHistoryTable создаёт таблицу истории и триггер, как это делает миграция
+func (f *FixtureDb) CreateEmployeeHistoryTable() error {
+	query := `CREATE TABLE IF NOT EXISTS employee_history (
+              history_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              employee_id BIGINT NOT NULL,
+              operation TEXT NOT NULL,
+              data JSONB NOT NULL,
+              changed_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+          );
+          CREATE OR REPLACE FUNCTION employee_history_trigger() RETURNS TRIGGER AS $$
+          BEGIN
+              IF TG_OP = 'DELETE' THEN
+                  INSERT INTO employee_history (employee_id, operation, data) VALUES (OLD.id, TG_OP, to_jsonb(OLD));
+                  RETURN OLD;
+              END IF;
+              INSERT INTO employee_history (employee_id, operation, data) VALUES (NEW.id, TG_OP, to_jsonb(NEW));
+              RETURN NEW;
+          END;
+          $$ LANGUAGE plpgsql;
+          DROP TRIGGER IF EXISTS employee_history ON employee;
+          CREATE TRIGGER employee_history AFTER INSERT OR UPDATE OR DELETE ON employee
+              FOR EACH ROW EXECUTE FUNCTION employee_history_trigger();`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateEmployeeRoleTable() error {
+	query := `CREATE TABLE IF NOT EXISTS employee_role (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              UNIQUE (employee_id, role_id)
+          );
+          ALTER TABLE employee_role ADD COLUMN IF NOT EXISTS valid_from TIMESTAMPTZ NOT NULL DEFAULT NOW();
+          ALTER TABLE employee_role ADD COLUMN IF NOT EXISTS valid_until TIMESTAMPTZ;
+          ALTER TABLE employee_role ADD COLUMN IF NOT EXISTS activated BOOLEAN NOT NULL DEFAULT TRUE;
+          ALTER TABLE employee_role ADD COLUMN IF NOT EXISTS warned_at TIMESTAMPTZ;
+          ALTER TABLE employee_role ADD COLUMN IF NOT EXISTS rule_id BIGINT;
+          CREATE OR REPLACE VIEW effective_employee_role AS
+              SELECT * FROM employee_role
+              WHERE valid_from <= NOW() AND (valid_until IS NULL OR valid_until > NOW());`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	// события назначений пишутся в журнал аудита
+	return f.CreateAuditEventTable()
+}
+
+func (f *FixtureDb) CreateReconciliationTables() error {
+	query := `CREATE TABLE IF NOT EXISTS reconciliation_report (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              connector TEXT NOT NULL,
+              started_at TIMESTAMPTZ NOT NULL,
+              finished_at TIMESTAMPTZ NOT NULL,
+              accounts INT NOT NULL DEFAULT 0,
+              discrepancies INT NOT NULL DEFAULT 0
+          );
+          CREATE TABLE IF NOT EXISTS reconciliation_discrepancy (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              report_id BIGINT NOT NULL REFERENCES reconciliation_report (id) ON DELETE CASCADE,
+              kind TEXT NOT NULL,
+              employee_id BIGINT NOT NULL DEFAULT 0,
+              account_name TEXT NOT NULL DEFAULT '',
+              role_id BIGINT NOT NULL DEFAULT 0,
+              entitlement_name TEXT NOT NULL DEFAULT '',
+              action TEXT NOT NULL,
+              outcome TEXT NOT NULL,
+              error TEXT NOT NULL DEFAULT ''
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateAccessRequestTables() error {
+	query := `CREATE TABLE IF NOT EXISTS role_approval_policy (
+              role_id BIGINT PRIMARY KEY REFERENCES role (id) ON DELETE CASCADE,
+              approvers TEXT[] NOT NULL
+          );
+          CREATE TABLE IF NOT EXISTS access_request (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              requester_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+              justification TEXT NOT NULL,
+              status TEXT NOT NULL DEFAULT 'pending',
+              approvers TEXT[] NOT NULL,
+              approver_ids BIGINT[] NOT NULL,
+              step INT NOT NULL DEFAULT 0,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              closed_at TIMESTAMPTZ
+          );
+          CREATE UNIQUE INDEX IF NOT EXISTS access_request_pending_idx ON access_request (employee_id, role_id)
+              WHERE status = 'pending';
+          CREATE TABLE IF NOT EXISTS access_request_decision (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              request_id BIGINT NOT NULL REFERENCES access_request (id) ON DELETE CASCADE,
+              step INT NOT NULL,
+              approver_type TEXT NOT NULL,
+              approver_id BIGINT NOT NULL,
+              decision TEXT NOT NULL,
+              comment TEXT NOT NULL DEFAULT '',
+              decided_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateSodTables() error {
+	query := `CREATE TABLE IF NOT EXISTS sod_rule (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              name TEXT NOT NULL UNIQUE,
+              description TEXT NOT NULL DEFAULT '',
+              enforcement TEXT NOT NULL DEFAULT 'reject',
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          CREATE TABLE IF NOT EXISTS sod_rule_role (
+              rule_id BIGINT NOT NULL REFERENCES sod_rule (id) ON DELETE CASCADE,
+              role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+              PRIMARY KEY (rule_id, role_id)
+          );
+          CREATE TABLE IF NOT EXISTS sod_exception (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              rule_id BIGINT NOT NULL REFERENCES sod_rule (id) ON DELETE CASCADE,
+              employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              reason TEXT NOT NULL,
+              approved_by BIGINT NOT NULL REFERENCES employee (id),
+              valid_until TIMESTAMPTZ,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              UNIQUE (rule_id, employee_id)
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateReviewTables() error {
+	query := `CREATE TABLE IF NOT EXISTS review_campaign (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              name TEXT NOT NULL,
+              scope_type TEXT NOT NULL,
+              scope_id BIGINT,
+              reviewer_type TEXT NOT NULL,
+              created_by BIGINT NOT NULL REFERENCES employee (id),
+              deadline TIMESTAMPTZ NOT NULL,
+              status TEXT NOT NULL DEFAULT 'active',
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              closed_at TIMESTAMPTZ
+          );
+          CREATE TABLE IF NOT EXISTS review_item (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              campaign_id BIGINT NOT NULL REFERENCES review_campaign (id) ON DELETE CASCADE,
+              employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+              reviewer_id BIGINT NOT NULL REFERENCES employee (id),
+              decision TEXT NOT NULL DEFAULT 'pending',
+              comment TEXT NOT NULL DEFAULT '',
+              decided_at TIMESTAMPTZ,
+              UNIQUE (campaign_id, employee_id, role_id)
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateAssignmentRuleTable() error {
+	query := `CREATE TABLE IF NOT EXISTS assignment_rule (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              name TEXT NOT NULL UNIQUE,
+              role_id BIGINT NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+              departments TEXT[] NOT NULL DEFAULT '{}',
+              titles TEXT[] NOT NULL DEFAULT '{}',
+              employment_types TEXT[] NOT NULL DEFAULT '{}',
+              locations TEXT[] NOT NULL DEFAULT '{}',
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          ALTER TABLE assignment_rule ADD COLUMN IF NOT EXISTS org_unit_id BIGINT REFERENCES org_unit (id) ON DELETE CASCADE;`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateCredentialTables() error {
+	query := `CREATE TABLE IF NOT EXISTS credential (
+              employee_id BIGINT PRIMARY KEY REFERENCES employee (id) ON DELETE CASCADE,
+              password_hash TEXT NOT NULL,
+              must_change BOOLEAN NOT NULL DEFAULT FALSE,
+              changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          CREATE TABLE IF NOT EXISTS password_history (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              password_hash TEXT NOT NULL,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateRefreshTokenTable() error {
+	query := `CREATE TABLE IF NOT EXISTS refresh_token (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              family_id TEXT NOT NULL,
+              token_hash TEXT NOT NULL UNIQUE,
+              expires_at TIMESTAMPTZ NOT NULL,
+              used_at TIMESTAMPTZ,
+              revoked_at TIMESTAMPTZ,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          ALTER TABLE refresh_token ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE;`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateLoginLockoutTables() error {
+	query := `CREATE TABLE IF NOT EXISTS login_lockout (
+              kind TEXT NOT NULL,
+              key TEXT NOT NULL,
+              failures INT NOT NULL DEFAULT 0,
+              lockouts INT NOT NULL DEFAULT 0,
+              last_failure_at TIMESTAMPTZ NOT NULL,
+              locked_until TIMESTAMPTZ,
+              PRIMARY KEY (kind, key)
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	// события блокировок пишутся в журнал аудита
+	return f.CreateAuditEventTable()
+}
+
+func (f *FixtureDb) CreateMfaTables() error {
+	query := `CREATE TABLE IF NOT EXISTS mfa_totp (
+              employee_id BIGINT PRIMARY KEY REFERENCES employee (id) ON DELETE CASCADE,
+              secret TEXT NOT NULL,
+              confirmed_at TIMESTAMPTZ,
+              last_used_step BIGINT NOT NULL DEFAULT 0,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          CREATE TABLE IF NOT EXISTS mfa_recovery_code (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              code_hash TEXT NOT NULL,
+              used_at TIMESTAMPTZ,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              UNIQUE (employee_id, code_hash)
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateOidcTables() error {
+	query := `CREATE TABLE IF NOT EXISTS oidc_client (
+              id TEXT PRIMARY KEY,
+              name TEXT NOT NULL,
+              secret_hash TEXT,
+              redirect_uris TEXT[] NOT NULL,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          CREATE TABLE IF NOT EXISTS oidc_authorization_code (
+              code_hash TEXT PRIMARY KEY,
+              client_id TEXT NOT NULL REFERENCES oidc_client (id) ON DELETE CASCADE,
+              employee_id BIGINT NOT NULL REFERENCES employee (id) ON DELETE CASCADE,
+              redirect_uri TEXT NOT NULL,
+              scope TEXT NOT NULL,
+              nonce TEXT NOT NULL DEFAULT '',
+              code_challenge TEXT NOT NULL,
+              roles TEXT[] NOT NULL,
+              amr TEXT[] NOT NULL,
+              auth_time TIMESTAMPTZ NOT NULL,
+              expires_at TIMESTAMPTZ NOT NULL,
+              used_at TIMESTAMPTZ,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateSigningKeyTable() error {
+	query := `CREATE TABLE IF NOT EXISTS signing_key (
+              kid TEXT PRIMARY KEY,
+              algorithm TEXT NOT NULL,
+              state TEXT NOT NULL CHECK (state IN ('next', 'active', 'retired')),
+              public_key BYTEA NOT NULL,
+              private_key BYTEA NOT NULL,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              activated_at TIMESTAMPTZ,
+              retired_at TIMESTAMPTZ
+          );
+          CREATE UNIQUE INDEX IF NOT EXISTS signing_key_state_idx ON signing_key (state) WHERE state IN ('next', 'active');`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateServiceAccountTables() error {
+	query := `CREATE TABLE IF NOT EXISTS service_account (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              name TEXT NOT NULL UNIQUE,
+              description TEXT NOT NULL DEFAULT '',
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );
+          CREATE TABLE IF NOT EXISTS api_key (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              service_account_id BIGINT NOT NULL REFERENCES service_account (id) ON DELETE CASCADE,
+              name TEXT NOT NULL,
+              prefix TEXT NOT NULL UNIQUE,
+              secret_hash TEXT NOT NULL,
+              scopes TEXT[] NOT NULL,
+              expires_at TIMESTAMPTZ,
+              last_used_at TIMESTAMPTZ,
+              last_used_ip TEXT,
+              revoked_at TIMESTAMPTZ,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateRateLimitTable() error {
+	query := `CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_bucket (
+              key TEXT PRIMARY KEY,
+              tokens DOUBLE PRECISION NOT NULL,
+              allowed BOOLEAN NOT NULL,
+              updated_at TIMESTAMPTZ NOT NULL
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateIdempotencyKeyTable() error {
+	query := `CREATE TABLE IF NOT EXISTS idempotency_key (
+              owner TEXT NOT NULL DEFAULT '',
+              key TEXT NOT NULL,
+              request_hash TEXT NOT NULL,
+              status_code INT NOT NULL DEFAULT 0,
+              content_type TEXT NOT NULL DEFAULT '',
+              response_body BYTEA,
+              created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              expires_at TIMESTAMPTZ NOT NULL,
+              locked_until TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+              PRIMARY KEY (owner, key)
+          );`
+	_, err := f.testDb.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *FixtureDb) CreateAuditEventTable() error {
+	query := `CREATE TABLE IF NOT EXISTS audit_event (
+              id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+              category TEXT NOT NULL,
+              action TEXT NOT NULL,
+              subject TEXT NOT NULL,
+              data JSON NOT NULL,
+              occurred_at TIMESTAMPTZ NOT NULL,
+              prev_hash TEXT NOT NULL,
+              hash TEXT NOT NULL UNIQUE
           );`
 	_, err := f.testDb.Exec(query)
 	if err != nil {